@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -13,7 +12,7 @@ import (
 
 func cmdAM(args []string) error {
 	fs := flag.NewFlagSet("am", flag.ContinueOnError)
-	mboxFile := fs.StringP("mbox", "m", "", "Input mbox file (default: stdin)")
+	mboxFiles := fs.StringArrayP("mbox", "m", nil, "Input mbox file, merged with the others (repeatable, default: stdin)")
 	output := fs.StringP("output", "o", "", "Output file (default: stdout)")
 	revision := fs.IntP("revision", "v", 0, "Select patch revision (default: latest)")
 	threeWay := fs.BoolP("3way", "3", false, "Enable 3-way merge")
@@ -21,35 +20,34 @@ func cmdAM(args []string) error {
 	linkPrefix := fs.String("link-prefix", "", "Link URL prefix")
 	addMsgID := fs.Bool("add-message-id", false, "Add Message-Id trailer")
 	coverTrails := fs.Bool("apply-cover-trailers", false, "Apply cover letter trailers to all patches")
+	trailerOrder := fs.String("trailer-order", "chronological", "Trailer order: chronological or grouped (by name)")
+	dedupTrailers := fs.Bool("dedup-trailers", false, "Drop trailers repeating another except for case and spacing")
+	dropTrailers := fs.StringArray("drop-trailer", nil, "Leave out trailers with this name, e.g. Change-Id (repeatable)")
+	store := fs.String("store", "", "Also archive the series in imap://<folder> or maildir:<path>")
+	account := fs.StringP("account", "a", "", "emx-mail account for --store imap:// (default: default account)")
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 
-	// Remaining positional arg is mbox file
-	if *mboxFile == "" && fs.NArg() > 0 {
-		*mboxFile = fs.Arg(0)
+	order, err := patchwork.ParseTrailerOrder(*trailerOrder)
+	if err != nil {
+		return err
 	}
 
-	_ = *threeWay // used in shazam
-
-	// Read input
-	var reader io.Reader
-	if *mboxFile == "" || *mboxFile == "-" {
-		reader = os.Stdin
-	} else {
-		f, err := os.Open(*mboxFile)
-		if err != nil {
-			return fmt.Errorf("open mbox file: %w", err)
+	var target storeTarget
+	if *store != "" {
+		if target, err = parseStoreSpec(*store); err != nil {
+			return err
 		}
-		defer f.Close()
-		reader = f
 	}
 
-	// Parse mbox
-	mb := patchwork.NewMailbox()
-	if err := mb.ReadMbox(reader); err != nil {
-		return fmt.Errorf("parse mbox: %w", err)
+	_ = *threeWay // used in shazam
+
+	// Remaining positional args are mbox files too
+	mb, err := readMailbox(append(*mboxFiles, fs.Args()...))
+	if err != nil {
+		return err
 	}
 
 	series := mb.GetSeries(*revision)
@@ -58,8 +56,12 @@ func cmdAM(args []string) error {
 	}
 
 	if !series.Complete {
-		fmt.Fprintf(os.Stderr, "Warning: incomplete patch series (expected %d, found %d)\n",
-			series.Expected, len(series.Patches))
+		if missing := formatMissing(series); missing != "" {
+			fmt.Fprintf(os.Stderr, "Warning: incomplete patch series v%d (%s)\n", series.Revision, missing)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: incomplete patch series (expected %d, found %d)\n",
+				series.Expected, len(series.Patches))
+		}
 	}
 
 	opts := patchwork.AMReadyOptions{
@@ -67,6 +69,9 @@ func cmdAM(args []string) error {
 		LinkPrefix:         *linkPrefix,
 		AddMessageID:       *addMsgID,
 		ApplyCoverTrailers: *coverTrails,
+		TrailerOrder:       order,
+		DedupTrailers:      *dedupTrailers,
+		DropTrailers:       *dropTrailers,
 	}
 
 	data, err := series.GetAMReady(opts)
@@ -83,12 +88,20 @@ func cmdAM(args []string) error {
 		fmt.Fprintf(os.Stderr, "Saved to %s (%d patches)\n", *output, len(series.Patches))
 	}
 
+	if *store != "" {
+		n, err := storeSeries(target, *account, data)
+		if err != nil {
+			return fmt.Errorf("store series in %s (%d messages stored): %w", *store, n, err)
+		}
+		fmt.Fprintf(os.Stderr, "Stored %d messages in %s\n", n, *store)
+	}
+
 	return nil
 }
 
 func cmdShazam(args []string) error {
 	fs := flag.NewFlagSet("shazam", flag.ContinueOnError)
-	mboxFile := fs.StringP("mbox", "m", "", "Input mbox file (default: stdin)")
+	mboxFiles := fs.StringArrayP("mbox", "m", nil, "Input mbox file, merged with the others (repeatable, default: stdin)")
 	revision := fs.IntP("revision", "v", 0, "Select patch revision (default: latest)")
 	threeWay := fs.BoolP("3way", "3", false, "Enable 3-way merge")
 
@@ -96,32 +109,18 @@ func cmdShazam(args []string) error {
 		return err
 	}
 
-	if *mboxFile == "" && fs.NArg() > 0 {
-		*mboxFile = fs.Arg(0)
-	}
-
-	// Read input
-	var reader io.Reader
-	if *mboxFile == "" || *mboxFile == "-" {
-		reader = os.Stdin
-	} else {
-		f, err := os.Open(*mboxFile)
-		if err != nil {
-			return fmt.Errorf("open mbox file: %w", err)
-		}
-		defer f.Close()
-		reader = f
-	}
-
-	mb := patchwork.NewMailbox()
-	if err := mb.ReadMbox(reader); err != nil {
-		return fmt.Errorf("parse mbox: %w", err)
+	mb, err := readMailbox(append(*mboxFiles, fs.Args()...))
+	if err != nil {
+		return err
 	}
 
 	series := mb.GetSeries(*revision)
 	if series == nil {
 		return fmt.Errorf("patch series not found (revision %d)", *revision)
 	}
+	if missing := formatMissing(series); missing != "" {
+		fmt.Fprintf(os.Stderr, "Warning: incomplete patch series v%d (%s)\n", series.Revision, missing)
+	}
 
 	opts := patchwork.AMReadyOptions{
 		ApplyCoverTrailers: true,
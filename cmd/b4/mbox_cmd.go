@@ -2,52 +2,58 @@ package main
 
 import (
 	"fmt"
-	"os"
+	"strings"
 
 	"github.com/emx-mail/cli/pkgs/patchwork"
 )
 
 func cmdMbox(args []string) error {
-	var mboxFile string
+	var mboxFiles []string
+	tree := false
 
-	// Simple positional arg — no flags needed
+	// Simple positional args — the only flag is --tree
 	for _, arg := range args {
-		if arg == "-h" || arg == "--help" {
+		switch arg {
+		case "-h", "--help":
 			printMboxUsage()
 			return nil
+		case "--tree":
+			tree = true
+			continue
 		}
-		if mboxFile == "" {
-			mboxFile = arg
-		} else {
-			return fmt.Errorf("unexpected argument: %s", arg)
-		}
+		mboxFiles = append(mboxFiles, arg)
 	}
 
-	if mboxFile == "" {
+	if len(mboxFiles) == 0 {
 		return fmt.Errorf("mbox file is required")
 	}
 
-	f, err := os.Open(mboxFile)
+	mb, err := readMailbox(mboxFiles)
 	if err != nil {
-		return fmt.Errorf("open mbox file: %w", err)
+		return err
 	}
-	defer f.Close()
-
-	mb := patchwork.NewMailbox()
-	if err := mb.ReadMbox(f); err != nil {
-		return fmt.Errorf("parse mbox: %w", err)
+	if tree {
+		printThreadTree(mb)
+		return nil
 	}
 
 	fmt.Printf("Total messages: %d\n", len(mb.Messages))
+	if mb.Duplicates > 0 {
+		fmt.Printf("Duplicates:     %d\n", mb.Duplicates)
+	}
 	fmt.Printf("Versions:       %d\n", len(mb.Series))
 	fmt.Printf("Unclassified:   %d\n\n", len(mb.Unknowns))
 
-	for rev, series := range mb.Series {
+	for _, rev := range mb.Revisions() {
+		series := mb.Series[rev]
 		fmt.Printf("== Version v%d ==\n", rev)
 		if series.CoverLetter != nil {
 			fmt.Printf("  Cover: %s\n", series.CoverLetter.Parsed.Subject)
 		}
 		fmt.Printf("  Patches: %d/%d\n", len(series.Patches), series.Expected)
+		if missing := formatMissing(series); missing != "" {
+			fmt.Printf("  Incomplete: %s\n", missing)
+		}
 		for i, p := range series.Patches {
 			fmt.Printf("  [%d] %s\n", i+1, p.Parsed.Subject)
 			if len(p.BodyParts.Trailers) > 0 {
@@ -62,9 +68,86 @@ func cmdMbox(args []string) error {
 	return nil
 }
 
+// printThreadTree prints the reply trees of mb with the trailers each
+// message adds, marking the patches without a Reviewed-by, and counts
+// them at the end.
+func printThreadTree(mb *patchwork.Mailbox) {
+	var patches, unreviewed []string
+	var walk func(n *patchwork.ThreadNode, prefix, childPrefix string)
+	walk = func(n *patchwork.ThreadNode, prefix, childPrefix string) {
+		m := n.Message
+		line := prefix + m.RawSubject
+		if from := formatSender(m); from != "" {
+			line += " (" + from + ")"
+		}
+		if n.IsPatch() {
+			name := fmt.Sprintf("v%d %d/%d", m.Parsed.Revision, m.Parsed.Counter, m.Parsed.Expected)
+			patches = append(patches, name)
+			if !n.Reviewed() {
+				unreviewed = append(unreviewed, name)
+				line += "  [no Reviewed-by]"
+			}
+		}
+		fmt.Println(line)
+
+		// Trailers go under the message, beside the line down to its replies
+		trailerPrefix := childPrefix + "    "
+		if len(n.Replies) > 0 {
+			trailerPrefix = childPrefix + "│   "
+		}
+		mark := ""
+		if m.Parsed.IsReply && !m.HasDiff {
+			mark = "+ " // Given in a reply
+		}
+		for _, t := range n.Trailers() {
+			fmt.Printf("%s%s%s\n", trailerPrefix, mark, t.String())
+		}
+
+		for i, r := range n.Replies {
+			if i == len(n.Replies)-1 {
+				walk(r, childPrefix+"└── ", childPrefix+"    ")
+			} else {
+				walk(r, childPrefix+"├── ", childPrefix+"│   ")
+			}
+		}
+	}
+	for _, root := range mb.Thread() {
+		walk(root, "", "")
+	}
+
+	if len(patches) > 0 {
+		fmt.Printf("\nReviewed: %d/%d patches", len(patches)-len(unreviewed), len(patches))
+		if len(unreviewed) > 0 {
+			fmt.Printf("; without Reviewed-by: %s", strings.Join(unreviewed, ", "))
+		}
+		fmt.Println()
+	}
+}
+
+// formatSender returns the name of the sender of m, or the address
+// without one.
+func formatSender(m *patchwork.PatchMessage) string {
+	switch {
+	case m.From == nil:
+		return ""
+	case m.From.Name != "":
+		return m.From.Name
+	default:
+		return m.From.Address
+	}
+}
+
 func printMboxUsage() {
 	fmt.Println(`emx-b4 mbox - Show mbox file information
 
 Usage:
-  emx-b4 mbox <file>`)
+  emx-b4 mbox [--tree] <file>...
+
+Several files are merged, skipping messages already read from another,
+and the patch numbers still missing are listed per version.
+
+With --tree the reply tree is shown instead (cover letter, patches,
+reviews) with the trailers each message adds ("+" for those given in
+replies), marking the patches that still lack a Reviewed-by, whether
+on the patch, in a reply to it or in a reply to the cover letter.`)
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/emx-mail/cli/pkgs/patchwork"
@@ -20,10 +21,16 @@ func cmdPrep(args []string) error {
 		return cmdPrepNew(args[1:])
 	case "cover":
 		return cmdPrepCover(args[1:])
+	case "recipients":
+		return cmdPrepRecipients(args[1:])
 	case "reroll":
 		return cmdPrepReroll(args[1:])
+	case "check":
+		return cmdPrepCheck(args[1:])
 	case "patches":
 		return cmdPrepPatches(args[1:])
+	case "send":
+		return cmdPrepSend(args[1:])
 	case "status":
 		return cmdPrepStatus(args[1:])
 	case "list":
@@ -40,18 +47,41 @@ Usage:
   emx-b4 prep <subcommand> [options]
 
 Subcommands:
-  new     Create a new patch branch
-  cover   Edit cover letter
-  reroll  Bump version number
-  patches Generate patch files
-  status  Show current status
-  list    List all prep branches`)
+  new        Create a new patch branch
+  cover      Edit cover letter
+  recipients Set the series To/Cc addresses
+  reroll     Bump version number
+  check      Check the commits before generating patches
+  patches    Generate patch files
+  send       Send the series with an emx-mail account
+  status     Show current status
+  list       List all prep branches
+
+Every patch is sent to the series To/Cc plus the Cc: trailers of its own
+commit message; the cover letter goes to all of them. "prep patches" writes
+these as To:/Cc: headers, and "prep status" shows them per message.
+
+With a cover letter, "prep patches" also writes it as the 0000 patch,
+[PATCH vN 0/K], with ${shortlog} and ${diffstat} in its body replaced by
+the series shortlog and diffstat (appended if the body has neither).
+
+"prep check" checks each commit for a subject or message line over 75
+characters, a missing Signed-off-by: of its author and trailing whitespace
+in its diff; --disable, --enable and the length flags are saved for the
+series. "prep patches" and "prep send" run the checks first and refuse to
+go on if any fails; --check=warn only reports them, --check=off skips them.
+
+"prep send [-a account] [--dry-run]" sends the cover letter and patches over
+one SMTP connection, threaded under the first message. Patches by other
+authors keep them in a From: line at the top of the body.`)
 }
 
 func cmdPrepNew(args []string) error {
 	fs := flag.NewFlagSet("prep new", flag.ContinueOnError)
 	slug := fs.StringP("name", "n", "", "Branch name")
 	baseBranch := fs.StringP("base", "b", "", "Base branch")
+	to := fs.StringArray("to", nil, "Send the series to this address (repeatable)")
+	cc := fs.StringArray("cc", nil, "Copy the series to this address (repeatable)")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -71,6 +101,8 @@ func cmdPrepNew(args []string) error {
 	if err != nil {
 		return err
 	}
+	pb.To = *to
+	pb.Cc = *cc
 
 	if err := pb.Create(); err != nil {
 		return err
@@ -83,7 +115,7 @@ func cmdPrepNew(args []string) error {
 func cmdPrepCover(args []string) error {
 	fs := flag.NewFlagSet("prep cover", flag.ContinueOnError)
 	subject := fs.StringP("subject", "s", "", "Cover subject")
-	body := fs.StringP("body", "b", "", "Cover body")
+	body := fs.StringP("body", "b", "", "Cover body; ${shortlog} and ${diffstat} are filled in by prep patches")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -107,6 +139,38 @@ func cmdPrepCover(args []string) error {
 	return nil
 }
 
+func cmdPrepRecipients(args []string) error {
+	fs := flag.NewFlagSet("prep recipients", flag.ContinueOnError)
+	to := fs.StringArray("to", nil, "Add a To address (repeatable)")
+	cc := fs.StringArray("cc", nil, "Add a Cc address (repeatable)")
+	reset := fs.Bool("reset", false, "Drop the current addresses first")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	git := patchwork.NewGit(".")
+	pb, err := patchwork.LoadPrepBranch(git)
+	if err != nil {
+		return err
+	}
+
+	newTo, newCc := pb.To, pb.Cc
+	if *reset {
+		newTo, newCc = nil, nil
+	}
+	newTo = append(newTo, *to...)
+	newCc = append(newCc, *cc...)
+
+	if err := pb.SaveRecipients(newTo, newCc); err != nil {
+		return err
+	}
+
+	fmt.Printf("To: %s\n", strings.Join(pb.To, ", "))
+	fmt.Printf("Cc: %s\n", strings.Join(pb.Cc, ", "))
+	return nil
+}
+
 func cmdPrepReroll(args []string) error {
 	git := patchwork.NewGit(".")
 	pb, err := patchwork.LoadPrepBranch(git)
@@ -123,9 +187,110 @@ func cmdPrepReroll(args []string) error {
 	return nil
 }
 
+func cmdPrepCheck(args []string) error {
+	fs := flag.NewFlagSet("prep check", flag.ContinueOnError)
+	disable := fs.StringArray("disable", nil, "Stop running a check: "+strings.Join(patchwork.CheckNames, ", ")+" (repeatable)")
+	enable := fs.StringArray("enable", nil, "Run a disabled check again (repeatable)")
+	maxSubject := fs.Int("max-subject-length", 0, "Longest allowed commit subject (default 75)")
+	maxLine := fs.Int("max-line-length", 0, "Longest allowed commit message line (default 75)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	git := patchwork.NewGit(".")
+	pb, err := patchwork.LoadPrepBranch(git)
+	if err != nil {
+		return err
+	}
+
+	cfg := pb.CheckConfig
+	changed := false
+	for _, name := range append(append([]string(nil), *disable...), *enable...) {
+		if !slices.Contains(patchwork.CheckNames, name) {
+			return fmt.Errorf("unknown check %q (want one of %s)", name, strings.Join(patchwork.CheckNames, ", "))
+		}
+	}
+	for _, name := range *disable {
+		if cfg.Enabled(name) {
+			cfg.Disabled = append(cfg.Disabled, name)
+			changed = true
+		}
+	}
+	for _, name := range *enable {
+		for i, d := range cfg.Disabled {
+			if d == name {
+				cfg.Disabled = append(cfg.Disabled[:i:i], cfg.Disabled[i+1:]...)
+				changed = true
+				break
+			}
+		}
+	}
+	if fs.Changed("max-subject-length") {
+		cfg.MaxSubjectLength, changed = *maxSubject, true
+	}
+	if fs.Changed("max-line-length") {
+		cfg.MaxLineLength, changed = *maxLine, true
+	}
+	if changed {
+		if err := pb.SaveCheckConfig(cfg); err != nil {
+			return err
+		}
+	}
+
+	report, err := pb.Check()
+	if err != nil {
+		return err
+	}
+	printCheckReport(report)
+	if !report.Passed() {
+		return fmt.Errorf("%d problems in %d patches", len(report.Problems), report.Patches)
+	}
+	return nil
+}
+
+// printCheckReport writes each problem of a check report to stderr, or a
+// line saying all patches passed.
+func printCheckReport(report *patchwork.CheckReport) {
+	if report.Passed() {
+		fmt.Fprintf(os.Stderr, "All %d patches passed the checks\n", report.Patches)
+		return
+	}
+	for _, p := range report.Problems {
+		fmt.Fprintf(os.Stderr, "%d/%d %s: %s: %s\n", p.Patch, report.Patches, p.Subject, p.Check, p.Message)
+	}
+}
+
+// runPrepChecks checks the series before its patches are generated: with
+// mode "error" problems stop it, with "warn" they are only reported, and
+// "off" skips the checks.
+func runPrepChecks(pb *patchwork.PrepBranch, mode string) error {
+	switch mode {
+	case "off":
+		return nil
+	case "error", "warn":
+	default:
+		return fmt.Errorf("invalid --check %q (want error, warn or off)", mode)
+	}
+
+	report, err := pb.Check()
+	if err != nil {
+		return fmt.Errorf("checking patches: %w", err)
+	}
+	if report.Passed() {
+		return nil
+	}
+	printCheckReport(report)
+	if mode == "error" {
+		return fmt.Errorf("%d problems in %d patches; fix them or use --check=warn", len(report.Problems), report.Patches)
+	}
+	return nil
+}
+
 func cmdPrepPatches(args []string) error {
 	fs := flag.NewFlagSet("prep patches", flag.ContinueOnError)
 	outputDir := fs.StringP("output", "o", "", "Output directory")
+	check := fs.String("check", "error", "Check the commits first: error (refuse on problems), warn or off")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -136,6 +301,9 @@ func cmdPrepPatches(args []string) error {
 	if err != nil {
 		return err
 	}
+	if err := runPrepChecks(pb, *check); err != nil {
+		return err
+	}
 
 	paths, err := pb.GetPatches(*outputDir)
 	if err != nil {
@@ -179,6 +347,24 @@ func cmdPrepStatus(args []string) error {
 		}
 	}
 
+	matrix, err := pb.Recipients()
+	if err == nil && hasRecipients(matrix) {
+		fmt.Printf("\nRecipients:\n")
+		for _, r := range matrix {
+			if r.Patch == 0 {
+				fmt.Printf("  Cover letter\n")
+			} else {
+				fmt.Printf("  %d/%d %s\n", r.Patch, len(matrix)-1, r.Subject)
+			}
+			if len(r.To) > 0 {
+				fmt.Printf("      To: %s\n", strings.Join(r.To, ", "))
+			}
+			if len(r.Cc) > 0 {
+				fmt.Printf("      Cc: %s\n", strings.Join(r.Cc, ", "))
+			}
+		}
+	}
+
 	stat, err := pb.DiffStat()
 	if err == nil && stat != "" {
 		fmt.Printf("\nDiffstat:\n%s", stat)
@@ -187,6 +373,16 @@ func cmdPrepStatus(args []string) error {
 	return nil
 }
 
+// hasRecipients reports whether any message of the matrix has an address.
+func hasRecipients(matrix []patchwork.PatchRecipients) bool {
+	for _, r := range matrix {
+		if len(r.To) > 0 || len(r.Cc) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func cmdPrepList(args []string) error {
 	git := patchwork.NewGit(".")
 	branches, err := patchwork.ListPrepBranches(git)
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/mail"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/emx-mail/cli/pkgs/email"
+	"github.com/emx-mail/cli/pkgs/patchwork"
+	flag "github.com/spf13/pflag"
+)
+
+func cmdPrepSend(args []string) error {
+	fs := flag.NewFlagSet("prep send", flag.ContinueOnError)
+	account := fs.StringP("account", "a", "", "emx-mail account to send with (default: default account)")
+	dryRun := fs.Bool("dry-run", false, "Show what would be sent without sending")
+	check := fs.String("check", "error", "Check the commits first: error (refuse on problems), warn or off")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	git := patchwork.NewGit(".")
+	pb, err := patchwork.LoadPrepBranch(git)
+	if err != nil {
+		return err
+	}
+	acc, err := loadAccount(*account)
+	if err != nil {
+		return err
+	}
+	if acc.SMTP.Host == "" && !*dryRun {
+		return fmt.Errorf("SMTP not configured for account %s", acc.Email)
+	}
+	if err := runPrepChecks(pb, *check); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "emx-b4-send-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	paths, err := pb.GetPatches(dir)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no commits to send")
+	}
+
+	// Every message replies to the first one, the cover letter if any
+	sender := mail.Address{Name: acc.FromName, Address: acc.Email}
+	msgs := make([]*email.ComposedMessage, len(paths))
+	var subjects []string
+	var root string
+	for i, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		msgID := email.GenerateMessageID(acc.Email)
+		m, subject, err := seriesMessage(raw, sender, msgID, root)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if i == 0 {
+			root = msgID
+		}
+		msgs[i] = m
+		subjects = append(subjects, subject)
+	}
+
+	if *dryRun {
+		for i, m := range msgs {
+			fmt.Printf("%s\n    to: %s\n", subjects[i], strings.Join(m.Recipients, ", "))
+		}
+		return nil
+	}
+
+	client, err := email.NewAccount(acc).SMTP()
+	if err != nil {
+		return err
+	}
+	session := client.NewSession()
+	session.Retries = 3
+	defer session.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for i, m := range msgs {
+		if _, err := session.SendComposed(ctx, m); err != nil {
+			return fmt.Errorf("sending %s (%d of %d sent): %w", subjects[i], i, len(msgs), err)
+		}
+		fmt.Fprintf(os.Stderr, "Sent: %s\n", subjects[i])
+	}
+	fmt.Fprintf(os.Stderr, "Sent %d messages\n", len(msgs))
+	return nil
+}
+
+// seriesMessage turns a format-patch file into a message from sender with
+// the given Message-ID, replying to inReplyTo unless it is empty. A patch
+// by someone else keeps its author in a From: line at the top of the body,
+// where git am picks it up. The envelope recipients are the To: and Cc:
+// headers. It also returns the subject.
+func seriesMessage(raw []byte, sender mail.Address, msgID, inReplyTo string) (*email.ComposedMessage, string, error) {
+	text := strings.ReplaceAll(string(raw), "\r\n", "\n")
+	if strings.HasPrefix(text, "From ") {
+		// mbox separator line of format-patch
+		if i := strings.IndexByte(text, '\n'); i >= 0 {
+			text = text[i+1:]
+		}
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(text))
+	if err != nil {
+		return nil, "", err
+	}
+	var recipients []string
+	for _, field := range []string{"To", "Cc"} {
+		addrs, err := msg.Header.AddressList(field)
+		if err != nil && err != mail.ErrHeaderNotPresent {
+			return nil, "", fmt.Errorf("%s header: %w", field, err)
+		}
+		for _, a := range addrs {
+			recipients = append(recipients, a.Address)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, "", fmt.Errorf("no recipients; add them with: emx-b4 prep recipients --to <addr>")
+	}
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	header, body, _ := strings.Cut(text, "\n\n")
+	author, _ := mail.ParseAddress(msg.Header.Get("From"))
+	if author != nil && !strings.EqualFold(author.Address, sender.Address) {
+		// Unencoded, as git am expects it in the body
+		from := "<" + author.Address + ">"
+		if author.Name != "" {
+			from = author.Name + " " + from
+		}
+		body = "From: " + from + "\n\n" + body
+	}
+
+	// Replace the From: header (and its continuation lines) with the sender
+	var b strings.Builder
+	skip := false
+	for _, line := range strings.Split(header, "\n") {
+		if skip && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			continue
+		}
+		skip = strings.HasPrefix(strings.ToLower(line), "from:")
+		if !skip {
+			b.WriteString(line + "\n")
+		}
+	}
+	fmt.Fprintf(&b, "From: %s\n", sender.String())
+	fmt.Fprintf(&b, "Message-ID: %s\n", msgID)
+	if inReplyTo != "" {
+		fmt.Fprintf(&b, "In-Reply-To: %s\nReferences: %s\n", inReplyTo, inReplyTo)
+	}
+	b.WriteString("\n" + body)
+
+	data := bytes.ReplaceAll([]byte(b.String()), []byte("\n"), []byte("\r\n"))
+	return &email.ComposedMessage{From: sender.Address, Recipients: recipients, Data: data}, subject, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/emx-mail/cli/pkgs/email"
+	"github.com/emx-mail/cli/pkgs/patchwork"
+)
+
+// storeTarget is where am --store archives a series: an IMAP folder of an
+// emx-mail account, or a local maildir.
+type storeTarget struct {
+	folder  string
+	maildir string
+}
+
+// parseStoreSpec parses a --store value: "imap://<folder>" or
+// "maildir:<path>", where the path may start with "~/".
+func parseStoreSpec(spec string) (storeTarget, error) {
+	switch {
+	case strings.HasPrefix(spec, "imap://"):
+		folder := strings.TrimPrefix(spec, "imap://")
+		if folder == "" {
+			return storeTarget{}, fmt.Errorf("--store %s: missing folder", spec)
+		}
+		return storeTarget{folder: folder}, nil
+	case strings.HasPrefix(spec, "maildir:"):
+		path := strings.TrimPrefix(strings.TrimPrefix(spec, "maildir:"), "//")
+		if path == "" {
+			return storeTarget{}, fmt.Errorf("--store %s: missing path", spec)
+		}
+		if path == "~" || strings.HasPrefix(path, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return storeTarget{}, fmt.Errorf("--store %s: %w", spec, err)
+			}
+			path = filepath.Join(home, path[1:])
+		}
+		return storeTarget{maildir: path}, nil
+	default:
+		return storeTarget{}, fmt.Errorf("--store %s: want imap://<folder> or maildir:<path>", spec)
+	}
+}
+
+// storeSeries archives every message of an am-ready mbox in target, using
+// the emx-mail account for IMAP. It returns the number of messages stored.
+func storeSeries(target storeTarget, account string, mbox []byte) (int, error) {
+	var msgs [][]byte
+	err := patchwork.WalkMbox(bytes.NewReader(mbox), func(r io.Reader) error {
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, raw)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if target.maildir != "" {
+		md := email.Maildir{Path: target.maildir}
+		for i, raw := range msgs {
+			if _, err := md.Deliver(raw); err != nil {
+				return i, err
+			}
+		}
+		return len(msgs), nil
+	}
+
+	acc, err := loadAccount(account)
+	if err != nil {
+		return 0, err
+	}
+	client, err := email.NewAccount(acc).IMAP()
+	if err != nil {
+		return 0, err
+	}
+	if err := client.Connect(); err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	for i, raw := range msgs {
+		// IMAP wants CRLF line endings
+		crlf := bytes.ReplaceAll(bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+		if err := client.AppendMessage(target.folder, crlf, nil); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
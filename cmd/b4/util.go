@@ -4,6 +4,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/patchwork"
 )
 
 func fatal(format string, args ...interface{}) {
@@ -11,6 +16,16 @@ func fatal(format string, args ...interface{}) {
 	os.Exit(1)
 }
 
+// loadAccount loads an emx-mail account by name or email; "" selects the
+// default account.
+func loadAccount(name string) (*config.AccountConfig, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("load emx-mail config: %w", err)
+	}
+	return cfg.GetAccount(name)
+}
+
 // absPath returns the absolute path of a file, or the original path if resolution fails.
 func absPath(path string) string {
 	abs, err := filepath.Abs(path)
@@ -19,3 +34,45 @@ func absPath(path string) string {
 	}
 	return abs
 }
+
+// readMailbox merges the messages of the mbox files into one mailbox, so a
+// series can be completed from partial downloads. No files, or "-", reads
+// stdin.
+func readMailbox(files []string) (*patchwork.Mailbox, error) {
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	mb := patchwork.NewMailbox()
+	for _, name := range files {
+		if name == "-" {
+			if err := mb.ReadMbox(os.Stdin); err != nil {
+				return nil, fmt.Errorf("parse mbox: %w", err)
+			}
+			continue
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, fmt.Errorf("open mbox file: %w", err)
+		}
+		err = mb.ReadMbox(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parse mbox %s: %w", name, err)
+		}
+	}
+	return mb, nil
+}
+
+// formatMissing describes the patches missing from series, e.g.
+// "missing 3, 5 of 7"; "" if none are.
+func formatMissing(series *patchwork.PatchSeries) string {
+	missing := series.Missing()
+	if len(missing) == 0 {
+		return ""
+	}
+	nums := make([]string, len(missing))
+	for i, n := range missing {
+		nums[i] = strconv.Itoa(n)
+	}
+	return fmt.Sprintf("missing %s of %d", strings.Join(nums, ", "), series.Expected)
+}
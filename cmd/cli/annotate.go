@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	flag "github.com/spf13/pflag"
+)
+
+type annotateFlags struct {
+	folder     string
+	uid        string
+	server     bool
+	jsonOutput bool
+	args       []string // name to read, or name=value to set
+}
+
+// annotateFlagSet defines the flags of the annotate command on f.
+func annotateFlagSet(f *annotateFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("annotate", flag.ExitOnError)
+	fs.StringVar(&f.folder, "folder", "", "Folder or logical folder to annotate (default: inbox)")
+	fs.StringVar(&f.uid, "uid", "", "Annotate this message of the folder instead of the folder")
+	fs.BoolVar(&f.server, "server", false, "Annotate the server instead of a folder")
+	fs.BoolVar(&f.jsonOutput, "json", false, "Output the annotations as a JSON object")
+	return fs
+}
+
+func parseAnnotateFlags(args []string) annotateFlags {
+	var f annotateFlags
+	fs := annotateFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("annotate: %v", err)
+	}
+	f.args = fs.Args()
+	return f
+}
+
+// handleAnnotate reads or sets the annotations of a folder, a message or
+// the server, kept on the server with METADATA: "name" arguments read
+// annotations, "name=value" ones set them and "name=" removes them. With no
+// arguments every annotation emx-mail keeps there is shown.
+func handleAnnotate(acc *config.AccountConfig, f annotateFlags) error {
+	if f.server && (f.uid != "" || f.folder != "") {
+		return fmt.Errorf("--server cannot be combined with --folder or --uid")
+	}
+	var uid uint32
+	if f.uid != "" {
+		if _, err := fmt.Sscanf(f.uid, "%d", &uid); err != nil || uid == 0 {
+			return fmt.Errorf("invalid UID: %s", f.uid)
+		}
+	}
+
+	var names []string
+	values := make(map[string]string)
+	for _, arg := range f.args {
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			values[name] = value
+		} else {
+			names = append(names, arg)
+		}
+	}
+	if len(names) > 0 && len(values) > 0 {
+		return fmt.Errorf("either read annotations (name) or set them (name=value), not both")
+	}
+
+	client, err := newAccount(acc).IMAP()
+	if err != nil {
+		return err
+	}
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if len(values) > 0 {
+		switch {
+		case f.server:
+			err = client.SetServerAnnotations(values)
+		case uid != 0:
+			err = client.SetMessageAnnotations(f.folder, uid, values)
+		default:
+			err = client.SetAnnotations(f.folder, values)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%d annotations changed\n", len(values))
+		return nil
+	}
+
+	var got map[string]string
+	switch {
+	case f.server:
+		got, err = client.ServerAnnotations(names...)
+	case uid != 0:
+		got, err = client.MessageAnnotations(f.folder, uid, names...)
+	default:
+		got, err = client.Annotations(f.folder, names...)
+	}
+	if err != nil {
+		return err
+	}
+
+	if f.jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(got)
+	}
+	keys := make([]string, 0, len(got))
+	for name := range got {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+	for _, name := range keys {
+		fmt.Printf("%s=%s\n", name, got[name])
+	}
+	return nil
+}
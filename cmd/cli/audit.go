@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/emx-mail/cli/pkgs/audit"
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+	flag "github.com/spf13/pflag"
+)
+
+// recordAudit appends a mailbox change to the audit log. Like the send
+// journal it is best effort: the change has been made on the server by
+// then, so failing to record it only prints a warning.
+func recordAudit(acc *config.AccountConfig, m email.Mutation) {
+	rec := audit.Record{
+		Account:   acc.Name,
+		Op:        m.Op,
+		Folder:    m.Folder,
+		UID:       m.UID,
+		MessageID: m.MessageID,
+		Dest:      m.Dest,
+		Add:       m.Add,
+		Remove:    m.Remove,
+		Args:      os.Args,
+	}
+	log, err := audit.Default()
+	if err == nil {
+		err = log.Append(rec)
+		log.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record %s of UID %d in the audit log: %v\n", m.Op, m.UID, err)
+	}
+}
+
+type auditFlags struct {
+	limit   int
+	since   string
+	folder  string
+	op      string
+	uid     uint32
+	verify  bool
+	json    bool
+	noColor bool
+}
+
+// auditFlagSet defines the flags of the audit command on f.
+func auditFlagSet(f *auditFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	fs.IntVar(&f.limit, "limit", 20, "Show the most recent N entries (0 = all)")
+	fs.StringVar(&f.since, "since", "", "Only entries newer than a duration (24h) or date (2006-01-02)")
+	fs.StringVar(&f.folder, "folder", "", "Only changes in this server folder")
+	fs.StringVar(&f.op, "op", "", "Only this operation: flags, delete, expunge, move or annotate")
+	fs.Uint32Var(&f.uid, "uid", 0, "Only changes to this UID")
+	fs.BoolVar(&f.verify, "verify", false, "Check the hash chain of the whole log and print its head")
+	fs.BoolVar(&f.json, "json", false, "Output JSON lines")
+	fs.BoolVar(&f.noColor, "no-color", false, "Disable colored output")
+	return fs
+}
+
+func parseAuditFlags(args []string) auditFlags {
+	var f auditFlags
+	fs := auditFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("audit: %v", err)
+	}
+	return f
+}
+
+// handleAudit prints the audit log, or with --verify checks it. It needs no
+// config; the global --account option filters by account name.
+func handleAudit(account string, f auditFlags) error {
+	var since time.Time
+	if f.since != "" {
+		var err error
+		if since, err = parseSince(f.since); err != nil {
+			return err
+		}
+	}
+
+	log, err := audit.Default()
+	if err != nil {
+		return err
+	}
+	all, err := log.Read()
+	if err != nil {
+		return err
+	}
+
+	if f.verify {
+		for i, e := range all {
+			if !e.Intact {
+				return fmt.Errorf("audit log tampered with: record %d of %d (%s) does not match the chain",
+					i+1, len(all), e.Time.Local().Format("2006-01-02 15:04:05"))
+			}
+		}
+		head := "(empty)"
+		if len(all) > 0 {
+			head = all[len(all)-1].Hash
+		}
+		fmt.Printf("Audit log intact: %d records, head %s\n", len(all), head)
+		return nil
+	}
+
+	var entries []audit.Entry
+	for _, e := range all {
+		if auditMatches(e, f, account, since) {
+			entries = append(entries, e)
+		}
+	}
+	if f.limit > 0 && len(entries) > f.limit {
+		entries = entries[len(entries)-f.limit:]
+	}
+
+	if f.json {
+		out := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := out.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No mailbox changes recorded")
+		return nil
+	}
+	tbl := newTable([]string{"Time", "Op", "Account", "Folder", "UID", "Change"}, 5, terminalWidth(), useColor(f.noColor))
+	for _, e := range entries {
+		style := ""
+		if !e.Intact {
+			style = ansiYellow
+		}
+		uid := ""
+		if e.UID != 0 {
+			uid = fmt.Sprint(e.UID)
+		}
+		tbl.addRow(style,
+			e.Time.Local().Format("2006-01-02 15:04:05"),
+			e.Op,
+			e.Account,
+			e.Folder,
+			uid,
+			auditChange(e.Record))
+		note := strings.Join(e.Args, " ")
+		if e.MessageID != "" {
+			note = "<" + e.MessageID + ">  " + note
+		}
+		if !e.Intact {
+			note = "CHAIN BROKEN  " + note
+		}
+		tbl.addNote(note)
+	}
+	tbl.render(os.Stdout)
+	return nil
+}
+
+// auditChange describes what a record changed.
+func auditChange(r audit.Record) string {
+	switch r.Op {
+	case "move":
+		return "to " + r.Dest
+	case "flags", "annotate":
+		var parts []string
+		for _, flag := range r.Add {
+			parts = append(parts, "+"+flag)
+		}
+		for _, flag := range r.Remove {
+			parts = append(parts, "-"+flag)
+		}
+		return strings.Join(parts, " ")
+	}
+	return ""
+}
+
+func auditMatches(e audit.Entry, f auditFlags, account string, since time.Time) bool {
+	switch {
+	case !since.IsZero() && e.Time.Before(since):
+		return false
+	case account != "" && !strings.EqualFold(account, e.Account):
+		return false
+	case f.folder != "" && f.folder != e.Folder:
+		return false
+	case f.op != "" && f.op != e.Op:
+		return false
+	case f.uid != 0 && f.uid != e.UID:
+		return false
+	}
+	return true
+}
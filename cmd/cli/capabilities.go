@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+	flag "github.com/spf13/pflag"
+)
+
+type capabilitiesFlags struct {
+	protocol   string
+	jsonOutput bool
+}
+
+// capabilitiesFlagSet defines the flags of the capabilities command on f.
+func capabilitiesFlagSet(f *capabilitiesFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("capabilities", flag.ExitOnError)
+	fs.StringVar(&f.protocol, "protocol", "", "Only query one protocol: imap, pop3 or smtp")
+	fs.BoolVar(&f.jsonOutput, "json", false, "Output in JSON lines format")
+	return fs
+}
+
+func parseCapabilitiesFlags(args []string) capabilitiesFlags {
+	var f capabilitiesFlags
+	fs := capabilitiesFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("capabilities: %v", err)
+	}
+	return f
+}
+
+// handleCapabilities queries every configured server of the account and
+// prints what it advertises. A failing server does not stop the others.
+func handleCapabilities(acc *config.AccountConfig, f capabilitiesFlags) error {
+	type server struct {
+		protocol string
+		cfg      config.ProtocolSettings
+		query    func() (*email.Capabilities, error)
+	}
+	a := newAccount(acc)
+	servers := []server{
+		{"imap", acc.IMAP, func() (*email.Capabilities, error) {
+			client, err := a.IMAP()
+			if err != nil {
+				return nil, err
+			}
+			return client.Capabilities()
+		}},
+		{"pop3", acc.POP3, func() (*email.Capabilities, error) {
+			client, err := a.POP3()
+			if err != nil {
+				return nil, err
+			}
+			return client.Capabilities()
+		}},
+		{"smtp", acc.SMTP, func() (*email.Capabilities, error) {
+			client, err := a.SMTP()
+			if err != nil {
+				return nil, err
+			}
+			return client.Capabilities()
+		}},
+	}
+
+	switch f.protocol {
+	case "", "imap", "pop3", "smtp":
+	default:
+		return fmt.Errorf("unknown protocol %q (want imap, pop3 or smtp)", f.protocol)
+	}
+
+	failed, queried := 0, 0
+	for _, srv := range servers {
+		if srv.cfg.Host == "" || (f.protocol != "" && f.protocol != srv.protocol) {
+			continue
+		}
+		queried++
+		addr := fmt.Sprintf("%s:%d", srv.cfg.Host, srv.cfg.Port)
+		caps, err := srv.query()
+		if err != nil {
+			failed++
+		}
+
+		if f.jsonOutput {
+			printCapabilitiesJSON(srv.protocol, addr, caps, err)
+			continue
+		}
+		fmt.Printf("%s %s\n", strings.ToUpper(srv.protocol), addr)
+		if err != nil {
+			fmt.Printf("  error: %v\n\n", err)
+			continue
+		}
+		fmt.Printf("  Capabilities: %s\n", strings.Join(caps.List, " "))
+		fmt.Println("  Features:")
+		for _, feat := range caps.Features {
+			mark := " "
+			if feat.Enabled {
+				mark = "x"
+			}
+			line := fmt.Sprintf("    [%s] %s (%s)", mark, feat.Name, feat.Capability)
+			if feat.Note != "" {
+				line += " - " + feat.Note
+			}
+			fmt.Println(line)
+		}
+		fmt.Println()
+	}
+
+	if queried == 0 {
+		if f.protocol != "" {
+			return fmt.Errorf("%s is not configured for account %s", strings.ToUpper(f.protocol), acc.Email)
+		}
+		return fmt.Errorf("no servers configured for account %s", acc.Email)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d servers could not be queried", failed, queried)
+	}
+	return nil
+}
+
+func printCapabilitiesJSON(protocol, addr string, caps *email.Capabilities, err error) {
+	type jsonFeature struct {
+		Name       string `json:"name"`
+		Capability string `json:"capability"`
+		Enabled    bool   `json:"enabled"`
+		Note       string `json:"note,omitempty"`
+	}
+	type jsonServer struct {
+		Protocol     string        `json:"protocol"`
+		Server       string        `json:"server"`
+		Capabilities []string      `json:"capabilities,omitempty"`
+		Features     []jsonFeature `json:"features,omitempty"`
+		Error        string        `json:"error,omitempty"`
+	}
+	js := jsonServer{Protocol: protocol, Server: addr}
+	if err != nil {
+		js.Error = err.Error()
+	} else {
+		js.Capabilities = caps.List
+		for _, feat := range caps.Features {
+			js.Features = append(js.Features, jsonFeature(feat))
+		}
+	}
+	data, _ := json.Marshal(js)
+	fmt.Println(string(data))
+}
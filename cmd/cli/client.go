@@ -2,60 +2,125 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/emx-mail/cli/pkgs/config"
 	"github.com/emx-mail/cli/pkgs/email"
 )
 
-func newIMAPClient(acc *config.AccountConfig) (*email.IMAPClient, error) {
-	if acc.IMAP.Host == "" {
-		return nil, fmt.Errorf("IMAP not configured for account %s", acc.Email)
-	}
-	return email.NewIMAPClient(email.IMAPConfig{
-		Host:     acc.IMAP.Host,
-		Port:     acc.IMAP.Port,
-		Username: acc.IMAP.Username,
-		Password: acc.IMAP.Password,
-		SSL:      acc.IMAP.SSL,
-		StartTLS: acc.IMAP.StartTLS,
-	}), nil
+// newAccount returns the mail clients of acc. IMAP connections are limited
+// across processes and their changes recorded in the audit log; all
+// connections stop at --timeout, see commandContext.
+func newAccount(acc *config.AccountConfig) *email.Account {
+	a := email.NewAccount(acc)
+	a.Limiter = newConnLimiter(acc)
+	a.Mutated = func(m email.Mutation) { recordAudit(acc, m) }
+	a.Dial = dial
+	return a
 }
 
-func newSMTPClient(acc *config.AccountConfig) *email.SMTPClient {
-	return email.NewSMTPClient(email.SMTPConfig{
-		Host:     acc.SMTP.Host,
-		Port:     acc.SMTP.Port,
-		Username: acc.SMTP.Username,
-		Password: acc.SMTP.Password,
-		SSL:      acc.SMTP.SSL,
-		StartTLS: acc.SMTP.StartTLS,
-	})
+// printFetchProgress shows on stderr how much of a message fetched in
+// chunks has arrived, see fetch_chunk_size.
+func printFetchProgress(uid uint32, done, total int64) {
+	fmt.Fprintf(os.Stderr, "\rFetching UID %d: %d of %d KiB", uid, done/1024, total/1024)
+	if done >= total {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// newConnLimiter returns the limiter shared by every emx-mail process
+// connecting to the account's IMAP server, or nil if it has no home
+// directory to keep the state in.
+func newConnLimiter(acc *config.AccountConfig) *email.ConnLimiter {
+	limiter, err := email.DefaultConnLimiter(acc.IMAP.MaxConnections)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: connection limits disabled: %v\n", err)
+		return nil
+	}
+	return limiter
+}
+
+// newNotifier returns the notifier of rule n, wrapped in a digest if it
+// configures one.
+func newNotifier(acc *config.AccountConfig, n config.NotifyConfig) (email.Notifier, error) {
+	notifier, err := newNotifierType(acc, n)
+	if err != nil || n.DigestMinutes <= 0 {
+		return notifier, err
+	}
+	return &email.DigestNotifier{
+		Window: time.Duration(n.DigestMinutes) * time.Minute,
+		Sender: notifier.(email.DigestSender),
+	}, nil
+}
+
+func newNotifierType(acc *config.AccountConfig, n config.NotifyConfig) (email.Notifier, error) {
+	switch n.Type {
+	case "desktop":
+		return email.DesktopNotifier{}, nil
+	case "ntfy":
+		if n.URL == "" {
+			return nil, fmt.Errorf("notify: url (topic) is required for type ntfy")
+		}
+		url := n.URL
+		if !strings.Contains(url, "://") {
+			url = "https://ntfy.sh/" + url
+		}
+		return &email.NtfyNotifier{URL: url, Token: n.Token, Priority: n.Priority}, nil
+	case "slack", "discord":
+		if n.URL == "" {
+			return nil, fmt.Errorf("notify: url is required for type %s", n.Type)
+		}
+		if n.Type == "slack" {
+			return &email.SlackNotifier{WebhookURL: n.URL}, nil
+		}
+		return &email.DiscordNotifier{WebhookURL: n.URL}, nil
+	case "email":
+		to, err := parseAddressList(n.To)
+		if err != nil {
+			return nil, fmt.Errorf("notify: to: %w", err)
+		}
+		if len(to) == 0 {
+			return nil, fmt.Errorf("notify: to is required for type email")
+		}
+		client, err := newAccount(acc).SMTP()
+		if err != nil {
+			return nil, err
+		}
+		return &email.MailNotifier{Client: client, From: email.Address{Name: acc.FromName, Email: acc.Email}, To: to}, nil
+	default:
+		return nil, fmt.Errorf("notify: unknown type %q (want desktop, ntfy, slack, discord or email)", n.Type)
+	}
 }
 
-func newPOP3Client(acc *config.AccountConfig) (*email.POP3Client, error) {
-	if acc.POP3.Host == "" {
-		return nil, fmt.Errorf("POP3 not configured for account %s", acc.Email)
-	}
-	return email.NewPOP3Client(email.POP3Config{
-		Host:     acc.POP3.Host,
-		Port:     acc.POP3.Port,
-		Username: acc.POP3.Username,
-		Password: acc.POP3.Password,
-		SSL:      acc.POP3.SSL,
-		StartTLS: acc.POP3.StartTLS,
-	}), nil
+// newScanOptions returns the account's attachment scan settings, or nil if
+// scanning is not configured.
+func newScanOptions(acc *config.AccountConfig) *email.ScanOptions {
+	if acc.Scan == nil || acc.Scan.Command == "" {
+		return nil
+	}
+	return &email.ScanOptions{
+		Scanner:          &email.CommandScanner{Command: acc.Scan.Command},
+		Reject:           acc.Scan.Reject,
+		QuarantineFolder: acc.Scan.QuarantineFolder,
+		Keyword:          acc.Scan.Keyword,
+	}
 }
 
-// selectProtocol returns "imap" or "pop3" based on config and user flag.
-func selectProtocol(acc *config.AccountConfig, protocol string) string {
-	if protocol != "" {
-		return protocol
+// newSyncFilter returns the folders and messages the account's sync config
+// selects for sync and export; everything without one.
+func newSyncFilter(acc *config.AccountConfig) email.SyncFilter {
+	if acc.Sync == nil {
+		return email.SyncFilter{}
 	}
-	if acc.IMAP.Host != "" {
-		return "imap"
+	filter := email.SyncFilter{
+		Include: acc.Sync.Include,
+		Exclude: acc.Sync.Exclude,
+		MaxSize: acc.Sync.MaxSize,
 	}
-	if acc.POP3.Host != "" {
-		return "pop3"
+	if acc.Sync.MaxAgeDays > 0 {
+		filter.Since = time.Now().AddDate(0, 0, -acc.Sync.MaxAgeDays)
 	}
-	return "imap"
+	return filter
 }
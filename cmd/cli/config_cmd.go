@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+	flag "github.com/spf13/pflag"
+)
+
+type configFlags struct {
+	file   string
+	json   bool
+	strict bool
+	args   []string
+}
+
+// configFlagSet defines the flags of the config command on f.
+func configFlagSet(f *configFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	fs.StringVar(&f.file, "file", "", "Check this JSON config file instead of the one emx-mail loads")
+	fs.BoolVar(&f.json, "json", false, "Output JSON lines")
+	fs.BoolVar(&f.strict, "strict", false, "Fail on warnings as well as errors")
+	return fs
+}
+
+func parseConfigFlags(args []string) configFlags {
+	var f configFlags
+	fs := configFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("config: %v", err)
+	}
+	f.args = fs.Args()
+	return f
+}
+
+// handleConfig runs "config validate": it checks the configuration as
+// written, without resolving its secrets or connecting to any server, and
+// fails if it has errors, or warnings with --strict.
+func handleConfig(f configFlags) error {
+	if len(f.args) != 1 || f.args[0] != "validate" {
+		return fmt.Errorf("usage: emx-mail config validate [--file <path>] [--strict] [--json]")
+	}
+
+	cfg, err := config.LoadRawConfig(f.file)
+	if err != nil {
+		return err
+	}
+	problems := append(cfg.Check(), handlerProblems(cfg)...)
+
+	var errs, warnings int
+	out := json.NewEncoder(os.Stdout)
+	for _, p := range problems {
+		if p.Severity == config.SeverityError {
+			errs++
+		} else {
+			warnings++
+		}
+		if f.json {
+			out.Encode(p)
+		} else {
+			fmt.Println(p)
+		}
+	}
+	if len(problems) == 0 && !f.json {
+		fmt.Printf("Config ok (%d accounts)\n", len(cfg.Accounts))
+	}
+
+	if errs > 0 || (f.strict && warnings > 0) {
+		return fmt.Errorf("%d errors, %d warnings", errs, warnings)
+	}
+	return nil
+}
+
+// handlerProblems warns about the watch handlers, pipeline stages and
+// scanners whose program cannot be found.
+func handlerProblems(cfg *config.Config) []config.Problem {
+	var problems []config.Problem
+	check := func(account, field, shell, cmd string) {
+		if cmd == "" {
+			return
+		}
+		if err := email.FindHandlerProgram(shell, cmd); err != nil {
+			problems = append(problems, config.Problem{
+				Severity: config.SeverityWarning,
+				Account:  account,
+				Field:    field,
+				Message:  fmt.Sprintf("command cannot be run: %v", err),
+			})
+		}
+	}
+
+	for _, name := range cfg.AccountNames() {
+		acc := cfg.Accounts[name]
+		if w := acc.Watch; w != nil {
+			check(name, "watch.handler_cmd", w.HandlerShell, w.HandlerCmd)
+			for i, p := range w.Pipelines {
+				for j, s := range p.Stages {
+					check(name, fmt.Sprintf("watch.pipelines[%d].stages[%d].command", i, j), s.Shell, s.Command)
+				}
+			}
+			if w.Commands != nil {
+				for i, h := range w.Commands.Handlers {
+					check(name, fmt.Sprintf("watch.commands.handlers[%d].command", i), h.Shell, h.Command)
+				}
+			}
+		}
+		if acc.Scan != nil {
+			// The scanner runs without a shell
+			check(name, "scan.command", email.HandlerShellNone, acc.Scan.Command)
+		}
+	}
+	return problems
+}
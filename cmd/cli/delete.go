@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
 	flag "github.com/spf13/pflag"
 )
 
@@ -14,13 +15,19 @@ type deleteFlags struct {
 	protocol string
 }
 
-func parseDeleteFlags(args []string) deleteFlags {
+// deleteFlagSet defines the flags of the delete command on f.
+func deleteFlagSet(f *deleteFlags) *flag.FlagSet {
 	fs := flag.NewFlagSet("delete", flag.ExitOnError)
-	var f deleteFlags
 	fs.StringVar(&f.uid, "uid", "", "Message UID (IMAP) or ID (POP3) to delete")
-	fs.StringVar(&f.folder, "folder", "INBOX", "Folder containing the message")
+	fs.StringVar(&f.folder, "folder", "", "Folder or logical folder containing the message (default: inbox)")
 	fs.BoolVar(&f.expunge, "expunge", false, "Permanently remove the message (IMAP only)")
 	fs.StringVar(&f.protocol, "protocol", "", "Force protocol: imap or pop3")
+	return fs
+}
+
+func parseDeleteFlags(args []string) deleteFlags {
+	var f deleteFlags
+	fs := deleteFlagSet(&f)
 	if err := fs.Parse(args); err != nil {
 		fatal("delete: %v", err)
 	}
@@ -37,20 +44,23 @@ func handleDelete(acc *config.AccountConfig, f deleteFlags) error {
 		return fmt.Errorf("invalid UID: %s", f.uid)
 	}
 
-	proto := selectProtocol(acc, f.protocol)
+	account := newAccount(acc)
+	account.Protocol = f.protocol
 
-	switch proto {
+	switch account.ReadProtocol() {
 	case "pop3":
-		client, cerr := newPOP3Client(acc)
+		client, cerr := account.POP3()
 		if cerr != nil {
 			return cerr
 		}
 		if err := client.DeleteMessage(uid); err != nil {
 			return err
 		}
+		// POP3 has no folders and DELE is final once QUIT succeeds
+		recordAudit(acc, email.Mutation{Op: "expunge", Folder: "INBOX", UID: uid})
 		fmt.Println("Message deleted (POP3 DELE + QUIT)")
 	default: // imap
-		client, cerr := newIMAPClient(acc)
+		client, cerr := account.IMAP()
 		if cerr != nil {
 			return cerr
 		}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+	"github.com/emx-mail/cli/pkgs/event"
+	flag "github.com/spf13/pflag"
+)
+
+type deliveryStatusFlags struct {
+	folder string
+	since  string
+	dryRun bool
+}
+
+// deliveryStatusFlagSet defines the flags of the delivery-status command on f.
+func deliveryStatusFlagSet(f *deliveryStatusFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("delivery-status", flag.ExitOnError)
+	fs.StringVar(&f.folder, "folder", "", "Folder or logical folder the reports arrive in (default: inbox)")
+	fs.StringVar(&f.since, "since", "", "Only reports received after a duration (24h) or date (2006-01-02)")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "Show the matching reports without recording them in the sent-log")
+	return fs
+}
+
+func parseDeliveryStatusFlags(args []string) deliveryStatusFlags {
+	var f deliveryStatusFlags
+	fs := deliveryStatusFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("delivery-status: %v", err)
+	}
+	return f
+}
+
+// handleDeliveryStatus matches the delivery status notifications in a
+// folder to the messages of the send journal by Message-ID, and records
+// the status of each recipient in the journal, where sent-log shows it.
+// Reports already recorded are skipped, so it can run on a schedule.
+func handleDeliveryStatus(acc *config.AccountConfig, f deliveryStatusFlags) error {
+	filter := email.SearchFilter{Type: "delivery-status"}
+	if f.since != "" {
+		var err error
+		if filter.Since, err = parseSince(f.since); err != nil {
+			return err
+		}
+	}
+
+	bus, err := event.DefaultBus()
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+	entries, deliveries, err := readJournal(bus)
+	if err != nil {
+		return err
+	}
+	sent := make(map[string]bool)
+	for _, e := range entries {
+		if e.MessageID != "" {
+			sent[e.MessageID] = true
+		}
+	}
+	recorded := make(map[string]bool)
+	for _, d := range deliveries {
+		recorded[d.ReportID] = true
+	}
+
+	client, err := newAccount(acc).IMAP()
+	if err != nil {
+		return err
+	}
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	defer client.Close()
+
+	folder, err := client.ResolveFolder(f.folder)
+	if err != nil {
+		return err
+	}
+	uids, err := client.Search(folder, filter)
+	if err != nil {
+		return err
+	}
+
+	matched, unmatched, seen := 0, 0, 0
+	err = client.FetchRawMessages(folder, uids, 0, func(uid uint32, raw []byte) error {
+		report, err := email.ParseDeliveryReport(bytes.NewReader(raw))
+		if errors.Is(err, email.ErrNotDeliveryReport) {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: UID %d: %v\n", uid, err)
+			return nil
+		}
+		id := report.ID
+		if id == "" {
+			id = fmt.Sprintf("%s/%d", folder, uid)
+		}
+		switch {
+		case recorded[id]:
+			seen++
+			return nil
+		case report.MessageID == "" || !sent[report.MessageID]:
+			unmatched++
+			return nil
+		}
+
+		matched++
+		for _, r := range report.Recipients {
+			fmt.Printf("<%s> %s: %s %s %s\n", report.MessageID, r.Recipient, r.Action, r.Status, r.Diagnostic)
+			if f.dryRun {
+				continue
+			}
+			payload, err := json.Marshal(deliveryRecord{
+				Account:      acc.Name,
+				MessageID:    report.MessageID,
+				Recipient:    r.Recipient,
+				Action:       r.Action,
+				Status:       r.Status,
+				Diagnostic:   r.Diagnostic,
+				ReportingMTA: report.ReportingMTA,
+				ReportID:     id,
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := bus.Add(deliveryEventType, sentLogChannel, payload); err != nil {
+				return fmt.Errorf("failed to record delivery status: %w", err)
+			}
+		}
+		recorded[id] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d reports matched sent messages, %d already recorded, %d for messages not in the sent-log\n",
+		matched, seen, unmatched)
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/emx-mail/cli/pkgs/email"
+	flag "github.com/spf13/pflag"
+)
+
+type diffFlags struct {
+	uids    []uint
+	folder  string
+	ignore  []string
+	context int
+	noColor bool
+	files   []string
+}
+
+// diffFlagSet defines the flags of the diff command on f.
+func diffFlagSet(f *diffFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.UintSliceVar(&f.uids, "uid", nil, "UID of a message to compare (give two, or one and a file)")
+	fs.StringVar(&f.folder, "folder", "", "Folder or logical folder containing the --uid messages (default: inbox)")
+	fs.StringArrayVar(&f.ignore, "ignore", nil, "Header field to leave out of the comparison, e.g. Received (repeatable)")
+	fs.IntVar(&f.context, "context", 3, "Unchanged body lines shown around each change")
+	fs.BoolVar(&f.noColor, "no-color", false, "Disable colored output")
+	return fs
+}
+
+func parseDiffFlags(args []string) diffFlags {
+	var f diffFlags
+	fs := diffFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("diff: %v", err)
+	}
+	f.files = fs.Args()
+	return f
+}
+
+// diffSource is one of the compared messages.
+type diffSource struct {
+	name string
+	raw  []byte
+}
+
+// handleDiff compares two messages, given by UID or as .eml files, and
+// fails if they differ. An account is only needed for UIDs.
+func handleDiff(a *app, f diffFlags) error {
+	if len(f.uids)+len(f.files) != 2 {
+		return fmt.Errorf("give two messages to compare: --uid A --uid B, two files, or one of each")
+	}
+
+	var sources []diffSource
+	if len(f.uids) > 0 {
+		account := newAccount(a.loadAccount())
+		defer account.Close()
+		for _, uid := range f.uids {
+			raw, err := account.FetchRaw(f.folder, uint32(uid))
+			if err != nil {
+				return fmt.Errorf("UID %d: %w", uid, err)
+			}
+			sources = append(sources, diffSource{fmt.Sprintf("UID %d", uid), raw})
+		}
+	}
+	for _, name := range f.files {
+		raw, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, diffSource{name, raw})
+	}
+
+	d, err := email.DiffMessages(sources[0].raw, sources[1].raw, f.ignore)
+	if err != nil {
+		return err
+	}
+	if d.Empty() {
+		fmt.Println("Messages are identical")
+		return nil
+	}
+	color := useColor(f.noColor)
+	fmt.Printf("--- %s\n+++ %s\n", sources[0].name, sources[1].name)
+	for _, h := range d.Headers {
+		fmt.Printf("\n%s:\n", h.Name)
+		for _, v := range h.A {
+			writeDiffLine(os.Stdout, email.DiffLine{Op: '-', Text: v}, color)
+		}
+		for _, v := range h.B {
+			writeDiffLine(os.Stdout, email.DiffLine{Op: '+', Text: v}, color)
+		}
+	}
+	for _, body := range []struct {
+		title string
+		lines []email.DiffLine
+	}{
+		{"Text body", d.Text},
+		{"HTML body", d.HTML},
+		{"Attachments", d.Attachments},
+	} {
+		if body.lines != nil {
+			fmt.Printf("\n%s:\n", body.title)
+			writeDiffHunks(os.Stdout, body.lines, f.context, color)
+		}
+	}
+	return fmt.Errorf("messages differ")
+}
+
+// writeDiffHunks writes the changed lines with context unchanged lines
+// around them, as unified diff hunks.
+func writeDiffHunks(w io.Writer, lines []email.DiffLine, context int, color bool) {
+	if context < 0 {
+		context = 0
+	}
+	// Line numbers in the first and second message before each line
+	na, nb := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for i, l := range lines {
+		na[i+1], nb[i+1] = na[i], nb[i]
+		if l.Op != '+' {
+			na[i+1]++
+		}
+		if l.Op != '-' {
+			nb[i+1]++
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		if lines[i].Op == ' ' {
+			i++
+			continue
+		}
+		// A hunk runs until more than 2*context unchanged lines follow
+		start, end := max(i-context, 0), i
+		for j := i; j < len(lines) && j-end <= 2*context; j++ {
+			if lines[j].Op != ' ' {
+				end = j
+			}
+		}
+		end = min(end+context+1, len(lines))
+		fmt.Fprintf(w, "@@ -%d,%d +%d,%d @@\n", na[start]+1, na[end]-na[start], nb[start]+1, nb[end]-nb[start])
+		for _, l := range lines[start:end] {
+			writeDiffLine(w, l, color)
+		}
+		i = end
+	}
+}
+
+// writeDiffLine writes l prefixed by its operation, removed lines in red
+// and added ones in green with color.
+func writeDiffLine(w io.Writer, l email.DiffLine, color bool) {
+	style := ""
+	switch {
+	case !color:
+	case l.Op == '-':
+		style = ansiRed
+	case l.Op == '+':
+		style = ansiGreen
+	}
+	if style == "" {
+		fmt.Fprintf(w, "%c %s\n", l.Op, l.Text)
+		return
+	}
+	fmt.Fprintf(w, "%s%c %s%s\n", style, l.Op, l.Text, ansiReset)
+}
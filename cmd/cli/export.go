@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+	flag "github.com/spf13/pflag"
+)
+
+type exportFlags struct {
+	dir    string
+	folder string
+	all    bool
+	window int
+}
+
+// exportFlagSet defines the flags of the export command on f.
+func exportFlagSet(f *exportFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&f.dir, "dir", "", "Directory to export to")
+	fs.StringVar(&f.folder, "folder", "", "Folder or logical folder to export (default: inbox)")
+	fs.BoolVar(&f.all, "all", false, "Export every folder selected by the account's sync config")
+	fs.IntVar(&f.window, "window", email.DefaultFetchWindow, "Messages requested from the server at once; raise it on high-latency links")
+	return fs
+}
+
+func parseExportFlags(args []string) exportFlags {
+	var f exportFlags
+	fs := exportFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("export: %v", err)
+	}
+	return f
+}
+
+// handleExport copies the messages of a folder, or with --all of every
+// folder the account's sync config selects, to .eml files below f.dir.
+// Messages already exported are skipped, so running it again only fetches
+// new mail.
+func handleExport(acc *config.AccountConfig, f exportFlags) error {
+	if f.dir == "" {
+		return fmt.Errorf("--dir is required")
+	}
+	if f.all && f.folder != "" {
+		return fmt.Errorf("--all and --folder cannot be combined")
+	}
+
+	a := newAccount(acc)
+	a.FetchProgress = printFetchProgress
+	client, err := a.IMAP()
+	if err != nil {
+		return err
+	}
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	defer client.Close()
+
+	filter := newSyncFilter(acc)
+	folders := []string{f.folder}
+	if f.all {
+		if folders, err = client.SyncFolders(filter); err != nil {
+			return err
+		}
+	}
+
+	for _, folder := range folders {
+		server, err := client.FetchFolderFlags(folder, filter)
+		if err != nil {
+			return err
+		}
+		dir := filepath.Join(f.dir, url.PathEscape(server.Folder))
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+
+		uids := make([]uint32, 0, len(server.Flags))
+		for uid := range server.Flags {
+			uids = append(uids, uid)
+		}
+		sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+
+		// UIDs are only unique together with UIDVALIDITY
+		path := func(uid uint32) string {
+			return filepath.Join(dir, fmt.Sprintf("%d-%d.eml", server.UIDValidity, uid))
+		}
+		var missing []uint32
+		for _, uid := range uids {
+			if _, err := os.Stat(path(uid)); errors.Is(err, os.ErrNotExist) {
+				missing = append(missing, uid)
+			} else if err != nil {
+				return err
+			}
+		}
+
+		exported, present := 0, len(uids)-len(missing)
+		err = client.FetchRawMessages(server.Folder, missing, f.window, func(uid uint32, raw []byte) error {
+			if err := writeFileAtomic(path(uid), raw); err != nil {
+				return err
+			}
+			exported++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Exported %s: %d new messages, %d already present\n", server.Folder, exported, present)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it, so an interrupted export never leaves a truncated message behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"mime"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -16,22 +23,40 @@ import (
 
 type fetchFlags struct {
 	uid             string
+	seq             string
 	folder          string
 	output          string
 	format          string
 	protocol        string
 	saveAttachments string
+	blobDir         string
+	showCharset     bool
+	redactSalt      string
+	inlineImages    string
+	attachmentData  bool
 }
 
-func parseFetchFlags(args []string) fetchFlags {
+// fetchFlagSet defines the flags of the fetch command on f.
+func fetchFlagSet(f *fetchFlags) *flag.FlagSet {
 	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
-	var f fetchFlags
 	fs.StringVar(&f.uid, "uid", "", "Message UID (IMAP) or ID (POP3) to fetch")
-	fs.StringVar(&f.folder, "folder", "INBOX", "Folder containing the message")
+	fs.StringVar(&f.seq, "seq", "", "Fetch the messages with these sequence numbers instead (IMAP): 1:100, 990:*, or -N for the last N")
+	fs.StringVar(&f.folder, "folder", "", "Folder or logical folder containing the message (default: inbox)")
 	fs.StringVar(&f.output, "output", "", "Output file (default: stdout)")
-	fs.StringVar(&f.format, "format", "text", "Output format: text or html")
+	fs.StringVar(&f.format, "format", "text", "Output format: text, html, json, structure or redacted")
 	fs.StringVar(&f.protocol, "protocol", "", "Force protocol: imap or pop3")
 	fs.StringVar(&f.saveAttachments, "save-attachments", "", "Save attachments to directory")
+	fs.StringVar(&f.blobDir, "blob-dir", "", "Store saved attachments once per content in this directory and link them from --save-attachments")
+	fs.BoolVar(&f.showCharset, "show-charset", false, "Show the original body charset before UTF-8 conversion")
+	fs.StringVar(&f.redactSalt, "redact-salt", "", "Salt for the hashed addresses and IDs of --format redacted (default: random)")
+	fs.StringVar(&f.inlineImages, "inline-images", "", "Resolve cid: images of --format html: files (saved next to --output) or data (data: URIs)")
+	fs.BoolVar(&f.attachmentData, "attachment-data", false, "Include the base64 attachment data in --format json")
+	return fs
+}
+
+func parseFetchFlags(args []string) fetchFlags {
+	var f fetchFlags
+	fs := fetchFlagSet(&f)
 	if err := fs.Parse(args); err != nil {
 		fatal("fetch: %v", err)
 	}
@@ -56,54 +81,146 @@ func validateAttachmentPath(baseDir, filename string) (string, error) {
 }
 
 func handleFetch(acc *config.AccountConfig, f fetchFlags) error {
-	if f.uid == "" {
-		return fmt.Errorf("--uid is required")
+	if f.uid == "" && f.seq == "" {
+		return fmt.Errorf("--uid or --seq is required")
+	}
+	if f.uid != "" && f.seq != "" {
+		return fmt.Errorf("--uid and --seq cannot be combined")
 	}
 
 	var uid uint32
-	if _, err := fmt.Sscanf(f.uid, "%d", &uid); err != nil {
-		return fmt.Errorf("invalid UID: %s", f.uid)
+	if f.uid != "" {
+		if _, err := fmt.Sscanf(f.uid, "%d", &uid); err != nil {
+			return fmt.Errorf("invalid UID: %s", f.uid)
+		}
+	}
+
+	if f.blobDir != "" && f.saveAttachments == "" {
+		return fmt.Errorf("--blob-dir requires --save-attachments")
+	}
+	if f.attachmentData && f.format != "json" {
+		return fmt.Errorf("--attachment-data requires --format json")
+	}
+	if f.inlineImages != "" && f.format != "html" {
+		return fmt.Errorf("--inline-images requires --format html")
+	}
+	switch f.inlineImages {
+	case "", "data":
+	case "files":
+		if f.output == "" {
+			return fmt.Errorf("--inline-images files requires --output")
+		}
+	default:
+		return fmt.Errorf("invalid --inline-images %q: want files or data", f.inlineImages)
 	}
 
-	proto := selectProtocol(acc, f.protocol)
+	account := newAccount(acc)
+	account.Protocol = f.protocol
+	if f.seq != "" {
+		return fetchSeq(account, f)
+	}
+	if f.format == "redacted" {
+		return fetchRedacted(account, f, uid)
+	}
 
-	var msg *email.Message
-	var err error
+	out, closeOut, err := fetchOutput(f)
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+	return fetchMessage(account, f, uid, out)
+}
 
-	switch proto {
-	case "pop3":
-		client, cerr := newPOP3Client(acc)
-		if cerr != nil {
-			return cerr
-		}
-		msg, err = client.FetchMessage(uid)
-	default: // imap
-		client, cerr := newIMAPClient(acc)
-		if cerr != nil {
-			return cerr
-		}
-		msg, err = client.FetchMessage(f.folder, uid)
+// fetchSeq fetches the messages of --seq one after another, each headed by
+// its UID.
+func fetchSeq(account *email.Account, f fetchFlags) error {
+	if account.ReadProtocol() == "pop3" {
+		return fmt.Errorf("--seq needs IMAP; with POP3, --uid takes the message number")
 	}
+	if f.format == "redacted" {
+		return fmt.Errorf("--format redacted takes a single --uid")
+	}
+	client, err := account.IMAP()
 	if err != nil {
 		return err
 	}
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	defer client.Close()
 
-	var out io.Writer = os.Stdout
-	if f.output != "" {
-		file, err := os.Create(f.output)
-		if err != nil {
-			return fmt.Errorf("failed to create output file: %w", err)
+	uids, err := client.SeqUIDs(f.folder, f.seq)
+	if err != nil {
+		return err
+	}
+	if len(uids) == 0 {
+		return fmt.Errorf("no messages match --seq %s", f.seq)
+	}
+
+	out, closeOut, err := fetchOutput(f)
+	if err != nil {
+		return err
+	}
+	defer closeOut()
+	for i, uid := range uids {
+		// JSON is one line per message, which carries its UID
+		if f.format == "json" {
+			if err := fetchMessage(account, f, uid, out); err != nil {
+				return err
+			}
+			continue
 		}
-		defer file.Close()
-		out = file
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintf(out, "==> UID %d <==\n", uid)
+		if err := fetchMessage(account, f, uid, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchOutput returns where fetch writes: the --output file, or stdout.
+func fetchOutput(f fetchFlags) (io.Writer, func(), error) {
+	if f.output == "" {
+		return os.Stdout, func() {}, nil
+	}
+	file, err := os.Create(f.output)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	return file, func() { file.Close() }, nil
+}
+
+// fetchMessage writes the message with uid to out in --format.
+func fetchMessage(account *email.Account, f fetchFlags, uid uint32, out io.Writer) error {
+	msg, err := account.Fetch(f.folder, uid)
+	if err != nil {
+		return err
 	}
 
 	switch f.format {
+	case "json":
+		data, err := email.MarshalMessage(msg, email.MessageJSONOptions{AttachmentData: f.attachmentData})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(data))
+	case "structure":
+		if msg.Structure == nil {
+			return fmt.Errorf("no body structure available (IMAP only)")
+		}
+		printStructure(out, msg.Structure)
 	case "html":
 		if msg.HTMLBody == "" {
 			return fmt.Errorf("no HTML body available")
 		}
-		fmt.Fprintln(out, msg.HTMLBody)
+		html, err := resolveInlineImages(msg, f)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, html)
 	case "text", "":
 		fmt.Fprintf(out, "From: %s\n", formatAddressList(msg.From))
 		fmt.Fprintf(out, "To: %s\n", formatAddressList(msg.To))
@@ -113,6 +230,13 @@ func handleFetch(acc *config.AccountConfig, f fetchFlags) error {
 		fmt.Fprintf(out, "Subject: %s\n", msg.Subject)
 		fmt.Fprintf(out, "Date: %s\n", msg.Date.Format(time.RFC1123))
 		fmt.Fprintf(out, "Message-ID: %s\n", msg.MessageID)
+		if f.showCharset {
+			charset := msg.Charset
+			if charset == "" {
+				charset = "(undeclared)"
+			}
+			fmt.Fprintf(out, "Charset: %s\n", charset)
+		}
 
 		if len(msg.Attachments) > 0 {
 			fmt.Fprintf(out, "\nAttachments (%d):\n", len(msg.Attachments))
@@ -121,6 +245,10 @@ func handleFetch(acc *config.AccountConfig, f fetchFlags) error {
 			}
 
 			if f.saveAttachments != "" {
+				infected, err := scanAttachments(account, f.folder, uid, msg)
+				if err != nil {
+					return err
+				}
 				fmt.Fprintf(os.Stderr, "\nSaving attachments to: %s\n", f.saveAttachments)
 				if err := os.MkdirAll(f.saveAttachments, 0755); err != nil {
 					return fmt.Errorf("failed to create directory: %w", err)
@@ -130,17 +258,34 @@ func handleFetch(acc *config.AccountConfig, f fetchFlags) error {
 						fmt.Fprintf(os.Stderr, "  [%d] Skipping %s (no data)\n", i+1, att.Filename)
 						continue
 					}
+					if res, ok := infected[att.Filename]; ok {
+						fmt.Fprintf(os.Stderr, "  [%d] Skipping %s: infected: %s\n", i+1, att.Filename, res.Detail)
+						continue
+					}
 					// Validate path to prevent traversal
 					filePath, err := validateAttachmentPath(f.saveAttachments, att.Filename)
 					if err != nil {
 						fmt.Fprintf(os.Stderr, "  [%d] Skipping %s: %v\n", i+1, att.Filename, err)
 						continue
 					}
-					if err := os.WriteFile(filePath, att.Data, 0644); err != nil {
+					if f.blobDir != "" {
+						store := &email.BlobStore{Dir: f.blobDir}
+						existed, err := store.Save(filePath, att.Data)
+						if err != nil {
+							return err
+						}
+						if existed {
+							fmt.Fprintf(os.Stderr, "  [%d] Saved: %s (already stored)\n", i+1, filepath.Base(att.Filename))
+							continue
+						}
+					} else if err := os.WriteFile(filePath, att.Data, 0644); err != nil {
 						return fmt.Errorf("failed to write %s: %w", att.Filename, err)
 					}
 					fmt.Fprintf(os.Stderr, "  [%d] Saved: %s\n", i+1, filepath.Base(att.Filename))
 				}
+				if len(infected) > 0 {
+					return fmt.Errorf("%d infected attachments not saved", len(infected))
+				}
 			}
 		}
 
@@ -150,3 +295,161 @@ func handleFetch(acc *config.AccountConfig, f fetchFlags) error {
 	}
 	return nil
 }
+
+// resolveInlineImages returns the HTML body with its cid: references
+// resolved as --inline-images asks: to data: URIs, or to files saved in a
+// "<output>_files" directory next to the output file.
+func resolveInlineImages(msg *email.Message, f fetchFlags) (string, error) {
+	switch f.inlineImages {
+	case "data":
+		return email.ResolveCIDs(msg.HTMLBody, msg.Attachments, func(att email.Attachment) (string, error) {
+			return email.DataURI(att), nil
+		})
+	case "files":
+		dirName := strings.TrimSuffix(filepath.Base(f.output), filepath.Ext(f.output)) + "_files"
+		dir := filepath.Join(filepath.Dir(f.output), dirName)
+		used := make(map[string]bool)
+		return email.ResolveCIDs(msg.HTMLBody, msg.Attachments, func(att email.Attachment) (string, error) {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return "", fmt.Errorf("failed to create directory: %w", err)
+			}
+			name := inlineImageName(att, used)
+			path, err := validateAttachmentPath(dir, name)
+			if err != nil {
+				return "", err
+			}
+			if err := os.WriteFile(path, att.Data, 0644); err != nil {
+				return "", fmt.Errorf("failed to write %s: %w", name, err)
+			}
+			return url.PathEscape(dirName) + "/" + url.PathEscape(name), nil
+		})
+	default:
+		return msg.HTMLBody, nil
+	}
+}
+
+// inlineImageName picks a file name for an inline image not yet in used:
+// its filename, or its Content-ID with an extension for its type, numbered
+// on collisions.
+func inlineImageName(att email.Attachment, used map[string]bool) string {
+	name := filepath.Base(att.Filename)
+	if att.Filename == "" || name == "." || name == ".." || name == string(filepath.Separator) {
+		name = strings.Map(func(r rune) rune {
+			if r == '/' || r == '\\' || r == ':' || r < ' ' {
+				return '_'
+			}
+			return r
+		}, att.ContentID)
+		if exts, _ := mime.ExtensionsByType(att.ContentType); len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; used[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	used[strings.ToLower(name)] = true
+	return name
+}
+
+// fetchRedacted writes the message source with personal data removed, see
+// email.RedactMessage.
+func fetchRedacted(account *email.Account, f fetchFlags, uid uint32) error {
+	raw, err := account.FetchRaw(f.folder, uid)
+	if err != nil {
+		return err
+	}
+
+	salt := f.redactSalt
+	if salt == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		salt = hex.EncodeToString(b)
+	}
+
+	var out io.Writer = os.Stdout
+	if f.output != "" {
+		file, err := os.Create(f.output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+	return email.RedactMessage(out, bytes.NewReader(raw), email.RedactOptions{Salt: salt})
+}
+
+// scanAttachments scans msg's attachments if the account configures a
+// scanner and returns the infected ones by filename. For IMAP messages the
+// scan policy (keyword, quarantine folder) is applied to the message.
+func scanAttachments(account *email.Account, folder string, uid uint32, msg *email.Message) (map[string]email.ScanResult, error) {
+	scan := newScanOptions(account.Config)
+	if scan == nil {
+		return nil, nil
+	}
+	results, err := email.ScanAttachments(context.Background(), scan.Scanner, msg.Attachments)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+	infected := make(map[string]email.ScanResult, len(results))
+	for _, res := range results {
+		infected[res.Attachment] = res
+	}
+	if account.ReadProtocol() == "imap" {
+		client, err := account.IMAP()
+		if err != nil {
+			return nil, err
+		}
+		if err := client.ApplyScanPolicy(folder, uid, scan); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else if scan.QuarantineFolder != "" {
+			fmt.Fprintf(os.Stderr, "Moved message to %s\n", scan.QuarantineFolder)
+		}
+	}
+	return infected, nil
+}
+
+// printStructure writes the MIME tree as one line per part: the IMAP part
+// number, the indented content type with its parameters, then encoding,
+// size and disposition.
+func printStructure(w io.Writer, root *email.Part) {
+	root.Walk(func(p *email.Part, depth int) {
+		number := p.Number
+		if number == "" {
+			number = "-"
+		}
+		line := strings.Repeat("  ", depth) + p.ContentType
+		keys := make([]string, 0, len(p.Params))
+		for k := range p.Params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			line += fmt.Sprintf("; %s=%s", k, p.Params[k])
+		}
+
+		var details []string
+		if p.Encoding != "" {
+			details = append(details, p.Encoding)
+		}
+		if !p.IsMultipart() {
+			details = append(details, fmt.Sprintf("%d bytes", p.Size))
+		}
+		if p.Disposition != "" {
+			details = append(details, p.Disposition)
+		}
+		if p.Filename != "" {
+			details = append(details, fmt.Sprintf("%q", p.Filename))
+		}
+		if len(details) > 0 {
+			line += "  (" + strings.Join(details, ", ") + ")"
+		}
+		fmt.Fprintf(w, "%-8s %s\n", number, line)
+	})
+}
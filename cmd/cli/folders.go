@@ -1,12 +1,48 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 
 	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+	flag "github.com/spf13/pflag"
 )
 
-func handleFolders(acc *config.AccountConfig) error {
+type foldersFlags struct {
+	counts      bool
+	flat        bool
+	jsonOutput  bool
+	subscribed  bool
+	subscribe   []string
+	unsubscribe []string
+}
+
+// foldersFlagSet defines the flags of the folders command on f.
+func foldersFlagSet(f *foldersFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("folders", flag.ExitOnError)
+	fs.BoolVar(&f.counts, "counts", false, "Show message and unseen counts (one STATUS per folder on older servers)")
+	fs.BoolVar(&f.flat, "flat", false, "List full folder names instead of a tree")
+	fs.BoolVar(&f.jsonOutput, "json", false, "Output in JSON lines format")
+	fs.BoolVar(&f.subscribed, "subscribed", false, "List only the subscribed folders")
+	fs.StringArrayVar(&f.subscribe, "subscribe", nil, "Subscribe to a folder or logical folder instead of listing (repeatable)")
+	fs.StringArrayVar(&f.unsubscribe, "unsubscribe", nil, "Unsubscribe from a folder or logical folder instead of listing (repeatable)")
+	return fs
+}
+
+func parseFoldersFlags(args []string) foldersFlags {
+	var f foldersFlags
+	fs := foldersFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("folders: %v", err)
+	}
+	return f
+}
+
+func handleFolders(acc *config.AccountConfig, f foldersFlags) error {
 	if acc.IMAP.Host == "" {
 		if acc.POP3.Host != "" {
 			fmt.Println("POP3 does not support folders. Only INBOX is available.")
@@ -15,23 +51,143 @@ func handleFolders(acc *config.AccountConfig) error {
 		return fmt.Errorf("neither IMAP nor POP3 is configured")
 	}
 
-	client, err := newIMAPClient(acc)
+	client, err := newAccount(acc).IMAP()
 	if err != nil {
 		return err
 	}
 
-	folders, err := client.ListFolders()
+	if len(f.subscribe)+len(f.unsubscribe) > 0 {
+		return changeSubscriptions(client, f.subscribe, f.unsubscribe)
+	}
+
+	folders, err := client.ListFolders(email.ListFoldersOptions{Counts: f.counts, Subscribed: f.subscribed})
 	if err != nil {
 		return err
 	}
+	applyFolderMappings(folders, acc.Folders)
+
+	// JSON output mode: one line per folder, in server order
+	if f.jsonOutput {
+		type jsonFolder struct {
+			Name       string   `json:"name"`
+			Delimiter  string   `json:"delimiter,omitempty"`
+			Attributes []string `json:"attributes,omitempty"`
+			NoSelect   bool     `json:"noselect"`
+			Subscribed bool     `json:"subscribed"`
+			Role       string   `json:"role,omitempty"`
+			Messages   *int     `json:"messages,omitempty"`
+			Unseen     *int     `json:"unseen,omitempty"`
+		}
+		for _, folder := range folders {
+			jf := jsonFolder{
+				Name:       folder.Name,
+				Attributes: folder.Flags,
+				NoSelect:   folder.NoSelect,
+				Subscribed: folder.Subscribed,
+				Role:       folder.Role,
+			}
+			if folder.Delim != 0 {
+				jf.Delimiter = string(folder.Delim)
+			}
+			if st := folder.Status; st != nil {
+				jf.Messages, jf.Unseen = &st.Messages, &st.Unseen
+			}
+			data, _ := json.Marshal(jf)
+			fmt.Println(string(data))
+		}
+		return nil
+	}
 
 	fmt.Println("Folders:")
-	for _, f := range folders {
-		flags := ""
-		if f.ReadOnly {
-			flags = " [read-only]"
+	if f.flat {
+		for _, folder := range folders {
+			fmt.Printf("  %s%s\n", folder.Name, folderDetails(folder))
+		}
+		return nil
+	}
+	printFolderTree(os.Stdout, email.BuildFolderTree(folders), "  ", true)
+	return nil
+}
+
+// changeSubscriptions subscribes to and unsubscribes from the folders, over
+// one connection.
+func changeSubscriptions(client *email.IMAPClient, subscribe, unsubscribe []string) error {
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for _, name := range subscribe {
+		if err := client.Subscribe(name); err != nil {
+			return err
+		}
+		fmt.Printf("Subscribed to %s\n", name)
+	}
+	for _, name := range unsubscribe {
+		if err := client.Unsubscribe(name); err != nil {
+			return err
 		}
-		fmt.Printf("  %s%s\n", f.Name, flags)
+		fmt.Printf("Unsubscribed from %s\n", name)
 	}
 	return nil
 }
+
+// applyFolderMappings sets the roles of the folders named in the account's
+// folder mappings, which take precedence over SPECIAL-USE attributes.
+func applyFolderMappings(folders []email.Folder, mapping map[string]string) {
+	for role, name := range mapping {
+		for i := range folders {
+			switch {
+			case folders[i].Name == name:
+				folders[i].Role = role
+			case folders[i].Role == role:
+				folders[i].Role = ""
+			}
+		}
+	}
+}
+
+// printFolderTree draws nodes below prefix; top-level folders are not
+// drawn as branches.
+func printFolderTree(w io.Writer, nodes []*email.FolderNode, prefix string, top bool) {
+	for i, n := range nodes {
+		line, childPrefix := prefix, prefix
+		if !top {
+			if i == len(nodes)-1 {
+				line, childPrefix = prefix+"└── ", prefix+"    "
+			} else {
+				line, childPrefix = prefix+"├── ", prefix+"│   "
+			}
+		}
+		fmt.Fprintf(w, "%s%s%s\n", line, n.Label, folderDetails(n.Folder))
+		printFolderTree(w, n.Children, childPrefix, false)
+	}
+}
+
+// folderDetails returns the counts and attributes shown after a folder name.
+func folderDetails(f email.Folder) string {
+	var b strings.Builder
+	if st := f.Status; st != nil {
+		fmt.Fprintf(&b, "  %d messages", st.Messages)
+		if st.Unseen > 0 {
+			fmt.Fprintf(&b, ", %d unseen", st.Unseen)
+		}
+	}
+	var tags []string
+	if f.Role != "" && f.Role != email.FolderInbox {
+		tags = append(tags, f.Role)
+	}
+	if f.NoSelect {
+		tags = append(tags, "noselect")
+	}
+	if f.ReadOnly {
+		tags = append(tags, "read-only")
+	}
+	if f.Subscribed {
+		tags = append(tags, "subscribed")
+	}
+	if len(tags) > 0 {
+		fmt.Fprintf(&b, "  [%s]", strings.Join(tags, ", "))
+	}
+	return b.String()
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// command describes a command for the usage, -h and the reference pages
+// of "help --man" and "help --markdown". The flags come from the flag set
+// the command parses, so the pages cannot drift from the options.
+type command struct {
+	name    string
+	summary string
+	args    string               // Operands after the options, e.g. "<file>..."
+	flags   func() *flag.FlagSet // nil for commands without options
+}
+
+// commands are the commands in the order the usage lists them.
+var commands = []command{
+	{name: "send", summary: "Send an email", flags: func() *flag.FlagSet { return sendFlagSet(new(sendFlags)) }},
+	{name: "sendmany", summary: "Send one templated email per CSV row (mail merge)", flags: func() *flag.FlagSet { return sendManyFlagSet(new(sendManyFlags)) }},
+	{name: "list", summary: "List emails in a folder", flags: func() *flag.FlagSet { return listFlagSet(new(listFlags)) }},
+	{name: "fetch", summary: "Fetch and display an email", flags: func() *flag.FlagSet { return fetchFlagSet(new(fetchFlags)) }},
+	{name: "delete", summary: "Delete an email", flags: func() *flag.FlagSet { return deleteFlagSet(new(deleteFlags)) }},
+	{name: "folders", summary: "List all folders", flags: func() *flag.FlagSet { return foldersFlagSet(new(foldersFlags)) }},
+	{name: "flag", summary: "Add or remove flags (seen, flagged, ...) on an email, online or offline", flags: func() *flag.FlagSet { return flagFlagSet(new(flagFlags)) }},
+	{name: "mark", summary: "Mark every (matching) message of a folder as read or unread", flags: func() *flag.FlagSet { return markFlagSet(new(markFlags)) }},
+	{name: "annotate", summary: "Read or set annotations of a folder, message or the server (METADATA)", args: "[name[=value]...]", flags: func() *flag.FlagSet { return annotateFlagSet(new(annotateFlags)) }},
+	{name: "strip-attachments", summary: "Replace the attachments of an email on the server by placeholders", flags: func() *flag.FlagSet { return stripFlagSet(new(stripFlags)) }},
+	{name: "sync", summary: "Refresh the local flag cache of a folder and push offline flag changes", flags: func() *flag.FlagSet { return syncFlagSet(new(syncFlags)) }},
+	{name: "export", summary: "Copy the messages of folders to .eml files (incremental mirror)", flags: func() *flag.FlagSet { return exportFlagSet(new(exportFlags)) }},
+	{name: "snapshot", summary: "Save the messages, flags and metadata of folders to a snapshot file", flags: func() *flag.FlagSet { return snapshotFlagSet(new(snapshotFlags)) }},
+	{name: "restore", summary: "Restore a snapshot into the same or another account, and verify it", args: "[snapshot]", flags: func() *flag.FlagSet { return restoreFlagSet(new(restoreFlags)) }},
+	{name: "capabilities", summary: "Show server capabilities and the emx-mail features they enable", flags: func() *flag.FlagSet { return capabilitiesFlagSet(new(capabilitiesFlags)) }},
+	{name: "verify-smtp", summary: "Check SMTP connection and login (and a recipient) without sending", flags: func() *flag.FlagSet { return verifySMTPFlagSet(new(verifySMTPFlags)) }},
+	{name: "sent-log", summary: "Show the local journal of sent messages", flags: func() *flag.FlagSet { return sentLogFlagSet(new(sentLogFlags)) }},
+	{name: "delivery-status", summary: "Match bounces and delivery reports to sent messages and record their status", flags: func() *flag.FlagSet { return deliveryStatusFlagSet(new(deliveryStatusFlags)) }},
+	{name: "audit", summary: "Show or verify the log of deletes, moves and flag changes", flags: func() *flag.FlagSet { return auditFlagSet(new(auditFlags)) }},
+	{name: "lint", summary: "Check message files (.eml) for RFC 5322 and MIME problems", args: "<file>...", flags: func() *flag.FlagSet { return lintFlagSet(new(lintFlags)) }},
+	{name: "diff", summary: "Compare the headers and bodies of two emails (UIDs or .eml files)", args: "[file.eml...]", flags: func() *flag.FlagSet { return diffFlagSet(new(diffFlags)) }},
+	{name: "watch", summary: "Watch for new emails (IMAP only)", flags: func() *flag.FlagSet { return watchFlagSet(new(watchFlags)) }},
+	{name: "smtpd", summary: "Accept mail from local applications over SMTP and archive or relay it", flags: func() *flag.FlagSet { return smtpdFlagSet(new(smtpdFlags)) }},
+	{name: "imapd", summary: "Serve saved .eml files and maildirs over IMAP, for testing mail clients", flags: func() *flag.FlagSet { return imapdFlagSet(new(imapdFlags)) }},
+	{name: "init", summary: "Initialize configuration file"},
+	{name: "config", summary: "Check the configuration for mistakes and insecure settings", args: "validate", flags: func() *flag.FlagSet { return configFlagSet(new(configFlags)) }},
+	{name: "help", summary: "Show this help, or generate reference pages (--man, --markdown)", args: "[command...]", flags: func() *flag.FlagSet { return helpFlagSet(new(helpFlags)) }},
+}
+
+// findCommand returns the command called name.
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// writeCommandList writes the usage's list of commands and summaries.
+func writeCommandList(w io.Writer) {
+	for _, c := range commands {
+		// Long names get a wider column
+		width := 10
+		if len(c.name) >= width {
+			width = 13
+		}
+		fmt.Fprintf(w, "  %-*s %s\n", width, c.name, c.summary)
+	}
+}
+
+type helpFlags struct {
+	man      bool
+	markdown bool
+	dir      string
+	commands []string
+}
+
+// helpFlagSet defines the flags of the help command on f.
+func helpFlagSet(f *helpFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("help", flag.ExitOnError)
+	fs.BoolVar(&f.man, "man", false, "Write man pages (troff) for the commands")
+	fs.BoolVar(&f.markdown, "markdown", false, "Write Markdown reference pages for the commands")
+	fs.StringVar(&f.dir, "dir", "", "Write one file per command to this directory (emx-mail-<command>.1 or .md) instead of stdout")
+	return fs
+}
+
+func parseHelpFlags(args []string) helpFlags {
+	var f helpFlags
+	fs := helpFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("help: %v", err)
+	}
+	f.commands = fs.Args()
+	return f
+}
+
+// handleHelp prints the usage, the options of the given commands, or with
+// --man or --markdown their reference pages.
+func handleHelp(f helpFlags) error {
+	if f.man && f.markdown {
+		return fmt.Errorf("--man and --markdown are exclusive")
+	}
+	cmds := commands
+	if len(f.commands) > 0 {
+		cmds = nil
+		for _, name := range f.commands {
+			c, ok := findCommand(name)
+			if !ok {
+				return fmt.Errorf("unknown command '%s'", name)
+			}
+			cmds = append(cmds, c)
+		}
+	}
+
+	var write func(io.Writer, command)
+	ext := ""
+	switch {
+	case f.man:
+		write, ext = writeManPage, ".1"
+	case f.markdown:
+		write, ext = writeMarkdownPage, ".md"
+	case len(f.commands) == 0:
+		printUsage()
+		return nil
+	default:
+		for _, c := range cmds {
+			writeCommandHelp(os.Stdout, c)
+		}
+		return nil
+	}
+
+	if f.dir == "" {
+		for i, c := range cmds {
+			if i > 0 && f.markdown {
+				fmt.Println()
+			}
+			write(os.Stdout, c)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(f.dir, 0o755); err != nil {
+		return err
+	}
+	for _, c := range cmds {
+		path := filepath.Join(f.dir, "emx-mail-"+c.name+ext)
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		write(file, c)
+		if err := file.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+	}
+	return nil
+}
+
+// synopsis returns the command line of c, without the program name.
+func synopsis(c command) string {
+	s := "[global options] " + c.name
+	if c.flags != nil {
+		s += " [options]"
+	}
+	if c.args != "" {
+		s += " " + c.args
+	}
+	return s
+}
+
+// commandOptions returns the options of c, in definition order.
+func commandOptions(c command) []*flag.Flag {
+	if c.flags == nil {
+		return nil
+	}
+	fs := c.flags()
+	fs.SortFlags = false
+	var opts []*flag.Flag
+	fs.VisitAll(func(fl *flag.Flag) {
+		if !fl.Hidden {
+			opts = append(opts, fl)
+		}
+	})
+	return opts
+}
+
+// optionName returns the option as written on the command line, e.g.
+// "-a, --account <string>".
+func optionName(fl *flag.Flag) string {
+	name := "--" + fl.Name
+	if fl.Shorthand != "" {
+		name = "-" + fl.Shorthand + ", " + name
+	}
+	if varname, _ := flag.UnquoteUsage(fl); varname != "" {
+		name += " <" + varname + ">"
+	}
+	return name
+}
+
+// optionUsage returns the description of the option with its default.
+func optionUsage(fl *flag.Flag) string {
+	_, usage := flag.UnquoteUsage(fl)
+	switch fl.DefValue {
+	case "", "0", "0s", "false", "[]":
+	default:
+		if !strings.Contains(usage, "(default") {
+			usage += fmt.Sprintf(" (default: %s)", fl.DefValue)
+		}
+	}
+	return usage
+}
+
+// writeCommandHelp writes the synopsis and options of c as -h shows them.
+func writeCommandHelp(w io.Writer, c command) {
+	fmt.Fprintf(w, "emx-mail %s - %s\n\nUsage:\n  emx-mail %s\n", c.name, c.summary, synopsis(c))
+	if c.flags != nil {
+		fs := c.flags()
+		fs.SortFlags = false
+		fmt.Fprintf(w, "\nOptions:\n%s", fs.FlagUsages())
+	}
+	fmt.Fprintln(w)
+}
+
+// writeManPage writes the man page of c.
+func writeManPage(w io.Writer, c command) {
+	fmt.Fprintf(w, ".TH EMX-MAIL-%s 1 \"\" \"emx-mail %s\" \"emx-mail Manual\"\n", strings.ToUpper(c.name), version)
+	fmt.Fprintf(w, ".SH NAME\nemx-mail-%s \\- %s\n", roffEscape(c.name), roffEscape(c.summary))
+	fmt.Fprintf(w, ".SH SYNOPSIS\n.B emx-mail\n%s\n", roffEscape(synopsis(c)))
+	if opts := commandOptions(c); len(opts) > 0 {
+		fmt.Fprintln(w, ".SH OPTIONS")
+		for _, fl := range opts {
+			fmt.Fprintf(w, ".TP\n.B %s\n%s\n", roffEscape(optionName(fl)), roffEscape(optionUsage(fl)))
+		}
+	}
+	fmt.Fprintln(w, ".SH SEE ALSO\n.BR emx-mail (1)")
+}
+
+// roffEscape escapes s for a line of troff text.
+func roffEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// writeMarkdownPage writes the Markdown reference page of c.
+func writeMarkdownPage(w io.Writer, c command) {
+	fmt.Fprintf(w, "# emx-mail %s\n\n%s\n\n```\nemx-mail %s\n```\n", c.name, c.summary, synopsis(c))
+	if opts := commandOptions(c); len(opts) > 0 {
+		fmt.Fprintf(w, "\n## Options\n\n| Option | Description |\n| --- | --- |\n")
+		for _, fl := range opts {
+			fmt.Fprintf(w, "| `%s` | %s |\n", optionName(fl), strings.ReplaceAll(optionUsage(fl), "|", `\|`))
+		}
+	}
+}
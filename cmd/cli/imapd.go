@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/emx-mail/cli/pkgs/email"
+	flag "github.com/spf13/pflag"
+)
+
+type imapdFlags struct {
+	maildir  string
+	listen   string
+	user     string
+	password string
+}
+
+// imapdFlagSet defines the flags of the imapd command on f.
+func imapdFlagSet(f *imapdFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("imapd", flag.ExitOnError)
+	fs.StringVar(&f.maildir, "maildir", "", "Directory of saved .eml files and/or maildirs to serve (required)")
+	fs.StringVar(&f.listen, "listen", "127.0.0.1:1143", "Address to accept IMAP on; logins are not encrypted, so keep it on localhost")
+	fs.StringVar(&f.user, "user", "test", "Username clients log in with")
+	fs.StringVar(&f.password, "password", "test", "Password clients log in with")
+	return fs
+}
+
+func parseIMAPDFlags(args []string) imapdFlags {
+	var f imapdFlags
+	fs := imapdFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("imapd: %v", err)
+	}
+	return f
+}
+
+// handleIMAPD serves the messages saved in a directory over IMAP, for
+// pointing mail clients at archived fixtures. It needs no account.
+func handleIMAPD(f imapdFlags) error {
+	if f.maildir == "" {
+		return fmt.Errorf("--maildir is required")
+	}
+	folders, err := email.ScanArchive(f.maildir)
+	if err != nil {
+		return err
+	}
+	srv, err := email.NewArchiveIMAPServer(folders, f.user, f.password)
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", f.listen)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	for _, folder := range folders {
+		fmt.Fprintf(os.Stderr, "%s: %d messages\n", folder.Name, len(folder.Messages))
+	}
+	fmt.Fprintf(os.Stderr, "Serving IMAP on %s (user %q); changes are not saved\n", l.Addr(), f.user)
+	if err := srv.Serve(l); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
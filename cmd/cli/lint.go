@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/emx-mail/cli/pkgs/email"
+	flag "github.com/spf13/pflag"
+)
+
+type lintFlags struct {
+	json   bool
+	strict bool
+	files  []string
+}
+
+// lintFlagSet defines the flags of the lint command on f.
+func lintFlagSet(f *lintFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.BoolVar(&f.json, "json", false, "Output JSON lines")
+	fs.BoolVar(&f.strict, "strict", false, "Fail on warnings as well as errors")
+	return fs
+}
+
+func parseLintFlags(args []string) lintFlags {
+	var f lintFlags
+	fs := lintFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("lint: %v", err)
+	}
+	f.files = fs.Args()
+	return f
+}
+
+// lintResult is one issue as printed by --json.
+type lintResult struct {
+	File string `json:"file"`
+	email.Issue
+}
+
+// handleLint validates message files ("-" or none for stdin). It needs no
+// config and fails if any file has errors, or warnings with --strict.
+func handleLint(f lintFlags) error {
+	files := f.files
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	var errs, warnings int
+	out := json.NewEncoder(os.Stdout)
+	for _, name := range files {
+		issues, err := lintFile(name)
+		if err != nil {
+			return err
+		}
+		for _, is := range issues {
+			if is.Severity == email.SeverityError {
+				errs++
+			} else {
+				warnings++
+			}
+			if f.json {
+				out.Encode(lintResult{File: name, Issue: is})
+			} else {
+				// file:line: prefix, as compilers print, for editors to jump to
+				loc := name
+				if is.Line > 0 {
+					loc = fmt.Sprintf("%s:%d", name, is.Line)
+				}
+				is.Line = 0
+				fmt.Printf("%s: %s\n", loc, is)
+			}
+		}
+		if len(issues) == 0 && !f.json {
+			fmt.Printf("%s: ok\n", name)
+		}
+	}
+
+	if errs > 0 || (f.strict && warnings > 0) {
+		return fmt.Errorf("%d errors, %d warnings", errs, warnings)
+	}
+	return nil
+}
+
+func lintFile(name string) ([]email.Issue, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+	return email.Validate(r)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -15,73 +16,151 @@ import (
 type listFlags struct {
 	folder     string
 	limit      int
+	page       int
+	cursor     string
 	unreadOnly bool
+	newOnly    bool
 	protocol   string
 	jsonOutput bool
+	noColor    bool
+
+	maxSpamScore    float64
+	hasMaxSpamScore bool
 }
 
-func parseListFlags(args []string) listFlags {
+// listFlagSet defines the flags of the list command on f.
+func listFlagSet(f *listFlags) *flag.FlagSet {
 	fs := flag.NewFlagSet("list", flag.ExitOnError)
-	var f listFlags
-	fs.StringVar(&f.folder, "folder", "INBOX", "Folder to list")
+	fs.StringVar(&f.folder, "folder", "", "Folder, or logical folder (inbox, sent, drafts, trash, junk, archive), to list (default: inbox)")
 	fs.IntVar(&f.limit, "limit", 20, "Maximum messages to show")
+	fs.IntVar(&f.page, "page", 1, "Page to show, newest first (pages are --limit messages long)")
+	fs.StringVar(&f.cursor, "cursor", "", "Show messages older than this cursor (from a previous list)")
 	fs.BoolVar(&f.unreadOnly, "unread-only", false, "Show only unread messages")
+	fs.BoolVar(&f.newOnly, "new-only", false, "Show only messages that arrived since the last --new-only run (IMAP)")
 	fs.StringVar(&f.protocol, "protocol", "", "Force protocol: imap or pop3")
 	fs.BoolVar(&f.jsonOutput, "json", false, "Output in JSON lines format")
+	fs.BoolVar(&f.noColor, "no-color", false, "Disable colored output (also honours NO_COLOR)")
+	fs.Float64Var(&f.maxSpamScore, "max-spam-score", 0, "Hide messages the server's spam filter scored above this")
+	return fs
+}
+
+func parseListFlags(args []string) listFlags {
+	var f listFlags
+	fs := listFlagSet(&f)
 	if err := fs.Parse(args); err != nil {
 		fatal("list: %v", err)
 	}
+	f.hasMaxSpamScore = fs.Changed("max-spam-score")
 	return f
 }
 
 func handleList(acc *config.AccountConfig, f listFlags, verbose bool) error {
-	proto := selectProtocol(acc, f.protocol)
+	account := newAccount(acc)
+	account.Protocol = f.protocol
+	proto := account.ReadProtocol()
 
-	var result *email.ListResult
-	var err error
+	if f.page < 1 {
+		return fmt.Errorf("--page must be at least 1")
+	}
+	var before *email.Cursor
+	if f.cursor != "" {
+		c, err := email.ParseCursor(f.cursor)
+		if err != nil {
+			return err
+		}
+		before = &c
+	}
+	limit := f.limit
+	if limit <= 0 {
+		limit = 20
+	}
+	offset := (f.page - 1) * limit
 
 	// Warn if using --unread-only with POP3 (not supported)
 	if f.unreadOnly && proto == "pop3" {
 		fmt.Fprintf(os.Stderr, "WARNING: --unread-only is not supported with POP3, showing all messages\n")
 	}
 
-	switch proto {
-	case "pop3":
-		client, cerr := newPOP3Client(acc)
-		if cerr != nil {
-			return cerr
+	var state *listState
+	var after *email.Cursor
+	if f.newOnly {
+		if proto == "pop3" {
+			return fmt.Errorf("--new-only needs IMAP UIDs")
+		}
+		if f.cursor != "" || f.page != 1 {
+			return fmt.Errorf("--new-only cannot be combined with --cursor or --page")
+		}
+		var err error
+		if state, err = newListState(acc, f.folder); err != nil {
+			return err
 		}
-		result, err = client.FetchMessages(email.FetchOptions{
-			Folder: "INBOX",
-			Limit:  f.limit,
-			// POP3 doesn't support server-side filtering
-		})
-	default: // imap
-		client, cerr := newIMAPClient(acc)
-		if cerr != nil {
-			return cerr
+		if after, err = state.load(); err != nil {
+			return err
 		}
-		result, err = client.FetchMessages(email.FetchOptions{
-			Folder:     f.folder,
-			Limit:      f.limit,
-			UnreadOnly: f.unreadOnly, // Server-side filtering for IMAP
-		})
+	}
+
+	opts := email.FetchOptions{
+		Folder:     f.folder,
+		Limit:      limit,
+		UnreadOnly: f.unreadOnly, // Server-side filtering for IMAP
+		Preview:    verbose,
+		Spam:       f.jsonOutput || f.hasMaxSpamScore,
+		Offset:     offset,
+		Before:     before,
+		After:      after,
+	}
+	result, err := account.List(opts)
+	if errors.Is(err, email.ErrCursorExpired) && after != nil {
+		// The folder was renumbered: start over as on the first run
+		fmt.Fprintf(os.Stderr, "WARNING: %v; showing the newest messages\n", err)
+		after, opts.After = nil, nil
+		result, err = account.List(opts)
 	}
 	if err != nil {
 		return err
 	}
+	// The next --new-only run starts after the highest UID listed; on the
+	// first run that is the newest message. Messages hidden by
+	// --max-spam-score count as listed.
+	var lastUID uint32
+	if after != nil {
+		lastUID = after.UID
+	}
+	for _, msg := range result.Messages {
+		lastUID = max(lastUID, msg.UID)
+	}
+	saveState := func() error {
+		if state == nil {
+			return nil
+		}
+		return state.save(result.UIDValidity, lastUID)
+	}
+	if f.hasMaxSpamScore {
+		// Client-side, so a page may show fewer than --limit messages
+		kept := result.Messages[:0]
+		for _, msg := range result.Messages {
+			if msg.Spam == nil || !msg.Spam.HasScore || msg.Spam.Score <= f.maxSpamScore {
+				kept = append(kept, msg)
+			}
+		}
+		result.Messages = kept
+	}
 
 	// JSON output mode
 	if f.jsonOutput {
 		type jsonMessage struct {
-			UID       uint32   `json:"uid"`
-			From      string   `json:"from"`
-			To        []string `json:"to,omitempty"`
-			Subject   string   `json:"subject"`
-			Date      string   `json:"date"`
-			MessageID string   `json:"message_id,omitempty"`
-			Seen      bool     `json:"seen"`
-			Flagged   bool     `json:"flagged"`
+			UID       uint32             `json:"uid"`
+			From      string             `json:"from"`
+			To        []string           `json:"to,omitempty"`
+			Subject   string             `json:"subject"`
+			Date      string             `json:"date"`
+			MessageID string             `json:"message_id,omitempty"`
+			Seen      bool               `json:"seen"`
+			Flagged   bool               `json:"flagged"`
+			Preview   string             `json:"preview,omitempty"`
+			Spam      *email.SpamVerdict `json:"spam,omitempty"`
+			ARC       []email.ARCResult  `json:"arc,omitempty"`
+			Cursor    string             `json:"cursor"` // Resume after this message with --cursor
 		}
 		for _, msg := range result.Messages {
 			// Note: No need to filter here for IMAP, already done server-side
@@ -106,16 +185,26 @@ func handleList(acc *config.AccountConfig, f listFlags, verbose bool) error {
 				MessageID: msg.MessageID,
 				Seen:      msg.Flags.Seen,
 				Flagged:   msg.Flags.Flagged,
+				Preview:   msg.Preview,
+				Spam:      msg.Spam,
+				ARC:       msg.ARC,
+				Cursor:    email.Cursor{UIDValidity: result.UIDValidity, UID: msg.UID}.String(),
 			}
 			data, _ := json.Marshal(jm)
 			fmt.Println(string(data))
 		}
-		return nil
+		return saveState()
 	}
 
 	fmt.Printf("Protocol: %s | Folder: %s\n", strings.ToUpper(proto), result.Folder)
 	fmt.Printf("Total: %d, Unread: %d\n\n", result.Total, result.Unread)
 
+	idLabel := "UID"
+	if proto == "pop3" {
+		idLabel = "ID"
+	}
+
+	tbl := newTable([]string{"#", idLabel, "Flags", "Date", "From", "Subject"}, 5, terminalWidth(), useColor(f.noColor))
 	displayIdx := 0
 	for _, msg := range result.Messages {
 		// Note: Server-side filtering for IMAP, client-side for POP3
@@ -129,24 +218,56 @@ func handleList(acc *config.AccountConfig, f listFlags, verbose bool) error {
 			from = formatAddress(msg.From[0])
 		}
 
-		status := "✗"
-		if msg.Flags.Seen {
-			status = "✓"
+		tbl.addRow(listRowStyle(msg.Flags),
+			fmt.Sprintf("%d", displayIdx),
+			fmt.Sprintf("%d", msg.UID),
+			formatListFlags(msg.Flags),
+			msg.Date.Format("2006-01-02 15:04"),
+			truncateWidth(from, 32),
+			msg.Subject,
+		)
+		if verbose {
+			tbl.addNote(fmt.Sprintf("%s  %s", msg.MessageID, truncate(msg.Preview, 100)))
 		}
+	}
+	tbl.render(os.Stdout)
+	if result.Next != nil {
+		fmt.Printf("\nMore messages: --cursor %s\n", result.Next)
+	}
+	return saveState()
+}
 
-		idLabel := "UID"
-		if proto == "pop3" {
-			idLabel = "ID"
-		}
+// formatListFlags renders message flags as a compact mutt-style column:
+// N = unread, F = flagged, A = answered, D = draft.
+func formatListFlags(flags email.MessageFlag) string {
+	var b strings.Builder
+	if !flags.Seen {
+		b.WriteByte('N')
+	}
+	if flags.Flagged {
+		b.WriteByte('F')
+	}
+	if flags.Answered {
+		b.WriteByte('A')
+	}
+	if flags.Draft {
+		b.WriteByte('D')
+	}
+	if b.Len() == 0 {
+		return "-"
+	}
+	return b.String()
+}
 
-		fmt.Printf("[%d] %s:%d %s From: %s\n", displayIdx, idLabel, msg.UID, status, from)
-		fmt.Printf("    Subject: %s\n", msg.Subject)
-		fmt.Printf("    Date: %s\n", msg.Date.Format(time.RFC1123))
-		fmt.Printf("    Message-ID: %s\n", msg.MessageID)
-		if verbose {
-			fmt.Printf("    Preview: %s\n", truncate(msg.TextBody, 100))
-		}
-		fmt.Println()
+// listRowStyle returns the ANSI style for a list row: unread messages are
+// bold and flagged messages are highlighted.
+func listRowStyle(flags email.MessageFlag) string {
+	style := ""
+	if !flags.Seen {
+		style += ansiBold
+	}
+	if flags.Flagged {
+		style += ansiYellow
 	}
-	return nil
+	return style
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+)
+
+// listState records the newest message that list --new-only showed from a
+// folder, so the next run lists only the messages that arrived since. It
+// is a small JSON file per account and folder; nothing is marked on the
+// server.
+type listState struct {
+	path string
+}
+
+// listStateFile is the content of a list state file.
+type listStateFile struct {
+	UIDValidity uint32    `json:"uidvalidity"`
+	LastUID     uint32    `json:"last_uid"` // Highest UID listed so far
+	Updated     time.Time `json:"updated"`
+}
+
+// newListState returns the state of folder at
+// ~/.emx-mail/list/<account>/<folder>.json.
+func newListState(acc *config.AccountConfig, folder string) (*listState, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	account, folder := cacheAccount(acc), offlineFolder(acc, folder)
+	return &listState{
+		path: filepath.Join(home, ".emx-mail", "list", url.PathEscape(account), url.PathEscape(folder)+".json"),
+	}, nil
+}
+
+// load returns the position after which messages are new, or nil before
+// the first run.
+func (s *listState) load() (*email.Cursor, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read list state: %w", err)
+	}
+	var st listStateFile
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse list state %s: %w", s.path, err)
+	}
+	return &email.Cursor{UIDValidity: st.UIDValidity, UID: st.LastUID}, nil
+}
+
+// save records lastUID as the highest UID listed so far.
+func (s *listState) save(uidValidity, lastUID uint32) error {
+	st := listStateFile{UIDValidity: uidValidity, LastUID: lastUID, Updated: time.Now().UTC()}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return fmt.Errorf("failed to save list state: %w", err)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	flag "github.com/spf13/pflag"
 )
@@ -12,7 +13,9 @@ const version = "1.0.0"
 // app holds global options parsed from the command line
 type app struct {
 	account string
+	as      string // Shared mailbox to act as, one of the account's delegates
 	verbose bool
+	timeout time.Duration // Limit on the run time of the command; 0 means none
 }
 
 func main() {
@@ -20,9 +23,13 @@ func main() {
 
 	// Global flags
 	flag.StringVar(&a.account, "account", "", "Account name or email to use")
+	flag.StringVar(&a.as, "as", "", "Act as a shared mailbox the account is a delegate of")
 	flag.BoolVarP(&a.verbose, "verbose", "v", false, "Verbose output")
+	flag.DurationVar(&a.timeout, "timeout", 0, "Give up on the command after this long, e.g. 30s")
 	showVersion := flag.Bool("version", false, "Show version information")
 	flag.Usage = printUsage
+	// Global options come before the command; the rest belongs to the command
+	flag.CommandLine.SetInterspersed(false)
 	flag.Parse()
 
 	if *showVersion {
@@ -38,6 +45,16 @@ func main() {
 
 	cmd := args[0]
 	cmdArgs := args[1:]
+	ctx, cancel := a.commandContext(cmd)
+	defer cancel()
+
+	// "help" only describes the commands
+	if cmd == "help" {
+		if err := handleHelp(parseHelpFlags(cmdArgs)); err != nil {
+			fatal("help: %v", err)
+		}
+		return
+	}
 
 	// "init" doesn't need config loaded
 	if cmd == "init" {
@@ -47,6 +64,62 @@ func main() {
 		return
 	}
 
+	// "sent-log" reads the local journal and needs no account
+	if cmd == "sent-log" {
+		if err := handleSentLog(a.account, parseSentLogFlags(cmdArgs)); err != nil {
+			fatal("sent-log: %v", err)
+		}
+		return
+	}
+
+	// "audit" reads the local audit log and needs no account
+	if cmd == "audit" {
+		if err := handleAudit(a.account, parseAuditFlags(cmdArgs)); err != nil {
+			fatal("audit: %v", err)
+		}
+		return
+	}
+
+	// "config validate" checks the config without loading an account
+	if cmd == "config" {
+		if err := handleConfig(parseConfigFlags(cmdArgs)); err != nil {
+			fatal("config: %v", err)
+		}
+		return
+	}
+
+	// "lint" checks local files and needs no account
+	if cmd == "lint" {
+		if err := handleLint(parseLintFlags(cmdArgs)); err != nil {
+			fatal("lint: %v", err)
+		}
+		return
+	}
+
+	// "diff" needs an account only for UIDs
+	if cmd == "diff" {
+		if err := handleDiff(a, parseDiffFlags(cmdArgs)); err != nil {
+			fatal("diff: %v", err)
+		}
+		return
+	}
+
+	// "imapd" serves local files and needs no account
+	if cmd == "imapd" {
+		if err := handleIMAPD(parseIMAPDFlags(cmdArgs)); err != nil {
+			fatal("imapd: %v", err)
+		}
+		return
+	}
+
+	// "smtpd" needs an account only to relay
+	if cmd == "smtpd" {
+		if err := handleSMTPD(a, parseSMTPDFlags(cmdArgs)); err != nil {
+			fatal("smtpd: %v", err)
+		}
+		return
+	}
+
 	// Load config and resolve account
 	acc := a.loadAccount()
 
@@ -56,6 +129,11 @@ func main() {
 		if err := handleSend(acc, opts); err != nil {
 			fatal("send: %v", err)
 		}
+	case "sendmany":
+		opts := parseSendManyFlags(cmdArgs)
+		if err := handleSendMany(ctx, acc, opts); err != nil {
+			fatal("sendmany: %v", err)
+		}
 	case "list":
 		opts := parseListFlags(cmdArgs)
 		if err := handleList(acc, opts, a.verbose); err != nil {
@@ -72,17 +150,70 @@ func main() {
 			fatal("delete: %v", err)
 		}
 	case "folders":
-		if err := handleFolders(acc); err != nil {
+		opts := parseFoldersFlags(cmdArgs)
+		if err := handleFolders(acc, opts); err != nil {
 			fatal("folders: %v", err)
 		}
+	case "capabilities":
+		opts := parseCapabilitiesFlags(cmdArgs)
+		if err := handleCapabilities(acc, opts); err != nil {
+			fatal("capabilities: %v", err)
+		}
+	case "verify-smtp":
+		opts := parseVerifySMTPFlags(cmdArgs)
+		if err := handleVerifySMTP(acc, opts); err != nil {
+			fatal("verify-smtp: %v", err)
+		}
 	case "watch":
 		opts := parseWatchFlags(cmdArgs)
-		if err := handleWatch(acc, opts); err != nil {
+		if err := handleWatch(ctx, acc, opts); err != nil {
 			fatal("watch: %v", err)
 		}
-	case "help":
-		printUsage()
-		os.Exit(0)
+	case "sync":
+		opts := parseSyncFlags(cmdArgs)
+		if err := handleSync(acc, opts); err != nil {
+			fatal("sync: %v", err)
+		}
+	case "export":
+		opts := parseExportFlags(cmdArgs)
+		if err := handleExport(acc, opts); err != nil {
+			fatal("export: %v", err)
+		}
+	case "snapshot":
+		opts := parseSnapshotFlags(cmdArgs)
+		if err := handleSnapshot(acc, opts); err != nil {
+			fatal("snapshot: %v", err)
+		}
+	case "restore":
+		opts := parseRestoreFlags(cmdArgs)
+		if err := handleRestore(acc, opts); err != nil {
+			fatal("restore: %v", err)
+		}
+	case "flag":
+		opts := parseFlagFlags(cmdArgs)
+		if err := handleFlag(acc, opts); err != nil {
+			fatal("flag: %v", err)
+		}
+	case "mark":
+		opts := parseMarkFlags(cmdArgs)
+		if err := handleMark(acc, opts); err != nil {
+			fatal("mark: %v", err)
+		}
+	case "delivery-status":
+		opts := parseDeliveryStatusFlags(cmdArgs)
+		if err := handleDeliveryStatus(acc, opts); err != nil {
+			fatal("delivery-status: %v", err)
+		}
+	case "annotate":
+		opts := parseAnnotateFlags(cmdArgs)
+		if err := handleAnnotate(acc, opts); err != nil {
+			fatal("annotate: %v", err)
+		}
+	case "strip-attachments":
+		opts := parseStripFlags(cmdArgs)
+		if err := handleStrip(acc, opts); err != nil {
+			fatal("strip-attachments: %v", err)
+		}
 	default:
 		fatal("unknown command '%s'", cmd)
 	}
@@ -95,25 +226,65 @@ Usage:
   emx-mail [global options] <command> [command options]
 
 Commands:
-  send       Send an email
-  list       List emails in a folder
-  fetch      Fetch and display an email
-  delete     Delete an email
-  folders    List all folders
-  watch      Watch for new emails (IMAP only)
-  init       Initialize configuration file
-
+`, version)
+	writeCommandList(os.Stderr)
+	fmt.Fprint(os.Stderr, `
 Global Options:
   --account <name>   Account name or email to use
+  --as <mailbox>     Act as a shared mailbox the account is a delegate of
   -v, --verbose      Verbose output
+  --timeout <d>      Give up on the command after a duration (30s, 5m), for cron jobs
+                     facing unresponsive servers. watch and sendmany stop as on SIGTERM
   --version          Show version information
 
 Config Resolution:
   1) If emx-config exists: emx-mail reads config via emx-config list --json.
   2) Otherwise: set env var EMX_MAIL_CONFIG_JSON to a JSON config file.
+  Usernames, passwords and other credentials may refer to their value: "${NAME}" is
+  replaced by environment variable NAME, "@/path/to/file" by the file's content (e.g.
+  a mounted Kubernetes secret). Start a value with "@@" for a literal "@", and write
+  "$${" for a literal "${". Older versions took such values literally: a password
+  starting with "@" or containing "${" must now be escaped this way.
+
+Folder Names:
+  --folder takes a server folder name or a logical folder: inbox, sent, drafts,
+  trash, junk or archive. Logical folders use the account's "folders" mapping
+  (e.g. "folders": {"sent": "[Gmail]/Sent Mail"}), then the server's SPECIAL-USE
+  attributes. "folders" shows the role of each folder.
+
+Shared Mailboxes:
+  An account with delegated access lists the shared mailboxes under "delegates":
+    "delegates": [{"email": "support@corp.com", "from_name": "Support"}]
+  --as support@corp.com then logs in with the account's credentials and the mailbox
+  as SASL authorization identity ("authzid", default the mailbox address; IMAP needs
+  AUTH=PLAIN, POP3 is not supported), and sends as the mailbox. With "on_behalf":
+  true, sent messages also name the account in a Sender header ("on behalf of"). The
+  mailbox has its own cache and watch state, under the name <account>/<mailbox>.
+
+Connection Limits:
+  All emx-mail commands together keep at most "max_connections" (default 10) IMAP
+  connections open per server, set in the account's imap section; further ones wait
+  for a free slot. When the server refuses a connection for having too many open,
+  every command backs off for 5s, doubling up to 5 minutes. The state is kept in
+  ~/.emx-mail/conn/.
+
+Large Messages:
+  "fetch_chunk_size" in the imap section (bytes, e.g. 8388608) fetches larger
+  messages in chunks of that size: after a dropped connection emx-mail reconnects
+  and resumes from the last chunk received instead of starting over. export and
+  snapshot show the progress of such messages on stderr.
+
+Certificate Pinning:
+  "tls_fingerprint_sha256" in an imap, pop3 or smtp section pins the server's
+  certificate: connections fail unless the SHA-256 of its certificate matches, as
+  printed by openssl x509 -noout -fingerprint -sha256 (colons optional). The
+  certificate must still be valid for the host; add "tls_pin_only": true to trust
+  the pin alone, e.g. for a lab server with a self-signed certificate.
 
 Send Options:
-  --to <emails>          Recipients (comma-separated)
+  --to <emails>          Recipients (comma-separated), e.g.
+                         "Alice Example <alice@x.com>, \"Doe, Jane\" <jane@y.com>, bob@z.com";
+                         an invalid address fails before connecting
   --cc <emails>          CC recipients (comma-separated)
   --subject <text>       Email subject
   --text <text>          Plain text body (inline)
@@ -121,54 +292,501 @@ Send Options:
   --text-file <path>     Plain text body from file ("-" for stdin)
   --html-file <path>     HTML body from file ("-" for stdin)
   --attachment <path>    Attachment file path (repeatable)
-  --in-reply-to <msgid>  Message-ID to reply to
+  --in-reply-to <msgid>  Message-ID to reply to. The message is looked up over IMAP (inbox,
+                         sent, archive) to fill References and, without --subject, the
+                         subject ("Re: <original>"); if it is not found only In-Reply-To is set.
+                         Messages sent or found before are remembered for 90 days in
+                         ~/.emx-mail/threads/<account>.json and need no lookup
+  --no-thread            With --in-reply-to, skip the lookup
+  --auto-reply           Mark the email as an automatic reply (Auto-Submitted: auto-replied
+                         and X-Loop), e.g. when a watch handler answers mail, so that
+                         autoresponders, watch --auto-responder included, do not answer it
+  --dry-run              Show a summary without sending
+  --preview              Show the fully composed message and ask before sending
+  --yes                  With --preview, send without asking
+
+Sendmany Options:
+  --template <path>      Message template (Go text/template): Subject/To/Cc/Reply-To headers,
+                         a blank line, then the body; "Content-Type: text/html" sends HTML.
+                         CSV columns are fields, e.g. {{.name}}; To defaults to {{.email}}
+  --csv <path>           Recipients CSV with a header row
+  --checkpoint <path>    Record sent rows here and skip them when rerun (resume)
+  --rate <n>             Maximum messages per minute (default: unlimited)
+  --retries <n>          Retries for temporary failures (4xx, dropped connection) (default: 3)
+  --retry-delay <sec>    Delay before the first retry, doubled each time (default: 5)
+  --per-connection <n>   Open a new SMTP connection after n messages (default: never)
+  --attachment <path>    Attachment for every message (repeatable)
+  --dry-run              Render every message without sending
+  All messages go over one SMTP connection, reset with RSET between messages.
+  One JSON status line per row is written
+  to stdout ("sent", "failed", "skipped" or "rendered"); a summary goes to stderr.
 
 List Options:
-  --folder <name>        Folder to list (default: INBOX)
+  --folder <name>        Folder to list (default: inbox)
   --limit <number>       Maximum messages to show (default: 20)
+  --page <number>        Page to show, newest first, --limit messages per page (default: 1)
+  --cursor <cursor>      Show messages older than <cursor>; the table prints the next page's
+                         cursor and --json prints one per message. Unlike --page, cursors
+                         are not shifted by new mail arriving between invocations
   --unread-only          Show only unread messages
+  --new-only             Show only messages that arrived since the last --new-only run, up
+                         to --limit of the oldest (IMAP). The highest UID shown is kept in
+                         ~/.emx-mail/list/<account>/<folder>.json and nothing is marked
+                         seen, for cron jobs; the first run shows the newest messages
   --protocol <proto>     Force protocol: imap or pop3 (auto-detected)
-  --json                 Output in JSON lines format
+  --max-spam-score <n>   Hide messages the server's spam filter (X-Spam-Status/X-Spam-Score)
+                         scored above <n>; unscored messages are kept. Filtered per page,
+                         so a page may show fewer than --limit messages
+  --json                 Output in JSON lines format, with the spam verdict ("spam") and
+                         ARC-Authentication-Results ("arc") when the server recorded them
+  --no-color             Disable colored output (also honours NO_COLOR)
 
 Fetch Options:
   --uid <uid>            Message UID (IMAP) or ID (POP3) to fetch
-  --folder <name>        Folder containing the message (default: INBOX)
+  --seq <set>            Fetch by sequence number instead (IMAP): 1:100, 42, 990:* (* is
+                         the last message) or -N for the last N; each message is headed
+                         by "==> UID <uid> <==" (--format json: one line each). Not
+                         with --format redacted
+  --folder <name>        Folder containing the message (default: inbox)
   --output <path>        Output file (default: stdout)
-  --format <format>      Output format: text or html (default: text)
+  --format <format>      Output format: text, html, json, structure (MIME tree with
+                         IMAP part numbers, types, sizes and dispositions), or redacted
+                         (default: text)
   --protocol <proto>     Force protocol: imap or pop3 (auto-detected)
-  --save-attachments <dir>  Save attachments to directory
+  --save-attachments <dir>  Save attachments to directory; with a scanner configured
+                         (see Watch Handler), infected attachments are not saved
+  --blob-dir <dir>       With --save-attachments, store each distinct attachment once in
+                         <dir> (named by SHA-256, read-only) and hard link it into the
+                         save directory, so an archive keeps one copy of a file mailed
+                         many times; emx-save -attachments does the same for watch
+  --inline-images <mode>  With --format html, resolve cid: images: files saves them in
+                         <output>_files/ next to --output, data embeds data: URIs
+  --show-charset         Show the original body charset (bodies are always converted to UTF-8)
+  --redact-salt <salt>   Salt for hashed addresses and Message-IDs in --format redacted;
+                         reuse it to keep tokens consistent across messages (default: random)
+  --attachment-data      With --format json, include the attachments' data (base64)
+  --format json writes the message as one JSON object with stable field names: uid,
+  key, message_id, from and to (lists of {"name","email"}), subject, date (RFC 3339),
+  flags (such as "\Seen"), text_body, html_body, attachments (filename, content_type,
+  size, content_id and, with --attachment-data, data), structure, spam and arc. Empty
+  optional fields are left out. watch stdout lines use RFC 3339 dates as well.
+  --format redacted writes the message source (.eml) with personal data removed, for
+  attaching problem messages to bug reports: addresses and Message-IDs are hashed,
+  Received headers keep only their date, letters and digits in the subject and text
+  parts become "x" and "0", other parts become filler of the same size, and unknown
+  headers are replaced. The MIME structure, encodings and charsets are kept.
 
 Delete Options:
   --uid <uid>            Message UID (IMAP) or ID (POP3) to delete
-  --folder <name>        Folder containing the message (default: INBOX)
+  --folder <name>        Folder containing the message (default: inbox)
   --expunge              Permanently remove (expunge) the message (IMAP only)
   --protocol <proto>     Force protocol: imap or pop3 (auto-detected)
 
+Folders Options:
+  --counts               Show message and unseen counts per folder
+  --flat                 List full folder names instead of a tree
+  --json                 Output in JSON lines format
+  --subscribed           List only the subscribed folders
+  --subscribe <folder>   Subscribe to a folder instead of listing (repeatable)
+  --unsubscribe <folder> Unsubscribe from a folder instead of listing (repeatable)
+
+Flag Options:
+  --uid <uid>            Message UID
+  --folder <name>        Folder containing the message (default: inbox)
+  --add <flag>           Flag to add: seen, flagged, answered, deleted, draft or a
+                         keyword such as $Label1 (repeatable)
+  --remove <flag>        Flag to remove (repeatable)
+  --offline              Change only the local cache (filled by "sync"); the change
+                         is sent by the next "sync --push-flags"
+
+Mark Options:
+  --folder <name>        Folder to mark (default: inbox)
+  --all-read             Mark the matching unread messages as read
+  --all-unread           Mark the matching read messages as unread
+  --from <text>          Only messages whose sender contains text
+  --subject <text>       Only messages whose subject contains text
+  --since <when>         Only messages received since a duration ago (24h) or date (2006-01-02)
+  --before <when>        Only messages received before a duration ago or date
+  --dry-run              Show how many messages would change
+  The messages are found with one SEARCH and changed with one UID STORE, however
+  many there are.
+
+Annotate Options:
+  --folder <name>        Folder to annotate (default: inbox)
+  --uid <uid>            Annotate this message of the folder instead of the folder
+  --server               Annotate the server instead of a folder
+  --json                 Output the annotations as a JSON object
+  "name" reads an annotation, "name=value" sets it and "name=" removes it; with no
+  arguments every annotation emx-mail keeps is shown. Annotations are kept on the
+  server with METADATA (RFC 5464), e.g. processing state instead of keyword flags.
+  Names not starting with "/" live under /private/vendor/emx-mail/. Message
+  annotations are entries of their folder keyed by UIDVALIDITY and UID, so they
+  stay behind when a message is moved.
+
+Strip-Attachments Options:
+  --uid <uid>            Message UID
+  --folder <name>        Folder containing the message (default: inbox)
+  --dry-run              Show what would be removed without changing the message
+  Attachments, including inline images, are replaced by short text parts naming
+  their filename, type and size; the text, HTML and headers stay as they were. The
+  reduced message is appended with the original's flags and date, then the original
+  is deleted (expunged by UID with UIDPLUS, else only marked \Deleted). Signed and
+  encrypted parts are kept whole. The new message has a new UID.
+
+Sync Options:
+  --folder <name>        Folder to sync (default: inbox)
+  --all                  Sync every folder selected by the account's sync config
+  --push-flags           First push the flag changes made with "flag --offline"
+  --policy <policy>      How to resolve a message whose flags changed on the server too:
+                         last-writer-wins applies the local changes on top of the server's
+                         flags; server-wins keeps the server's flags (default: last-writer-wins)
+  --dry-run              Show what --push-flags would change without changing anything
+  The cache lives in ~/.emx-mail/cache/<account>/<folder>.json. --push-flags writes
+  one JSON line per changed message ("pushed", "merged", "discarded" or "gone").
+  If the folder's UIDVALIDITY changed, unpushed changes are discarded with a warning.
+
+Export Options:
+  --dir <path>           Directory to export to; messages go to <dir>/<folder>/<uidvalidity>-<uid>.eml
+  --folder <name>        Folder to export (default: inbox)
+  --all                  Export every folder selected by the account's sync config
+  --window <n>           Messages requested from the server at once (default 8); each is
+                         its own UID FETCH, so raising it hides the latency of slow links
+  Messages already exported are skipped, so rerunning export keeps a mirror up to date.
+
+  An account's "sync" config selects what sync and export copy:
+  {"sync": {"include": ["inbox", "sent"], "exclude": ["Archive"], "max_age_days": 365,
+  "max_size": 26214400}}. include and exclude take folder globs ("Lists/*") or logical
+  folders; a pattern covers subfolders too. Without include every folder is selected.
+  Older or larger messages (max_size in bytes) are skipped in every folder.
+
+Snapshot Options:
+  -o, --output <file>    Snapshot file to write (.tar.zst for zstd, else gzip)
+  --folder <name>        Folder to capture (repeatable; default: inbox)
+  --all                  Capture every folder selected by the account's sync config
+  --window <n>           Messages requested from the server at once (default 8)
+  A snapshot is a tar.gz (or tar.zst) of the messages with a manifest.json recording
+  each folder's name, role, delimiter and UIDVALIDITY, and each message's UID,
+  Message-ID, flags and internal date. Take one before risky bulk operations (mark,
+  strip-attachments).
+
+Restore Options:
+  -i, --input <file>     Snapshot file to restore (or as the only argument)
+  --map <old=new>        Restore snapshot folder old into folder new (repeatable)
+  --folder <name>        Only restore this snapshot folder (repeatable)
+  --dry-run              Show where each folder goes and how many messages it lacks
+  Restores into the account given with --account, the same or another one. Folders
+  without --map go to the folder of the same role (sent, trash, ...) or name, which
+  is created if missing. Messages whose Message-ID is already in the folder are
+  skipped, once for each copy there, so restore can be rerun; the others are
+  appended with their flags and internal date. Afterwards every Message-ID of the
+  snapshot is looked up in its folder, and restore fails listing the messages still
+  missing.
+
+Capabilities Options:
+  --protocol <proto>     Only query imap, pop3 or smtp (default: all configured)
+  --json                 Output in JSON lines format
+
+Verify-SMTP Options:
+  --rcpt <addr>          Also check the recipient with VRFY and a RCPT TO dry run
+                         (MAIL FROM is the account address; nothing is sent)
+  --json                 Output the result as JSON
+  Exits non-zero if the server cannot be reached, login fails, or --rcpt is rejected.
+
+Sent-Log Options:
+  --limit <n>            Show the most recent N entries (default: 20, 0 = all)
+  --since <when>         Only entries newer than a duration (24h) or date (2006-01-02)
+  --to <text>            Only messages with a recipient containing text
+  --message-id <id>      Only the message with this Message-ID
+  --failed               Only failed sends
+  --json                 Output in JSON lines format
+  --no-color             Disable colored output
+  send and sendmany record every message they transmit, with its recipients,
+  Message-ID, subject and result, on the "sent-log" channel of ~/.emx-mail/events.
+  The global --account option filters by account. Messages show the latest delivery
+  status of their recipients recorded by delivery-status; --failed includes bounces.
+
+Delivery-Status Options:
+  --folder <name>        Folder the reports arrive in (default: inbox)
+  --since <when>         Only reports received after a duration (24h) or date (2006-01-02)
+  --dry-run              Show the matching reports without recording them
+  Finds the delivery status notifications (RFC 3464 bounces and delivery reports)
+  in the folder, matches them to the sent-log by the Message-ID of the original
+  message, and records each recipient's status (failed, delayed, delivered, ...),
+  status code and diagnostic in the sent-log. Reports already recorded are skipped.
+
+Audit Options:
+  --limit <n>            Show the most recent N entries (default: 20, 0 = all)
+  --since <when>         Only entries newer than a duration (24h) or date (2006-01-02)
+  --folder <name>        Only changes in this server folder
+  --op <op>              Only flags, delete, expunge, move or annotate
+  --uid <uid>            Only changes to this UID
+  --verify               Check the whole log's hash chain; exits non-zero if it is broken
+  --json                 Output in JSON lines format
+  --no-color             Disable colored output
+  Every flag change, delete, expunge and move made by emx-mail (delete, flag, sync
+  --push-flags, watch) is recorded with the account, folder, UID, Message-ID and
+  command line on the "audit" channel of ~/.emx-mail/events. Each record holds the
+  SHA-256 of the one before, so edited or removed records break the chain. --verify
+  prints the head hash; keep it elsewhere to also detect records cut off the end.
+
+Config Options:
+  emx-mail config validate [options]
+  --file <path>          Check this JSON config file instead of the one emx-mail loads
+  --json                 Output problems as JSON lines
+  --strict               Fail on warnings as well as errors
+  Checks the config as written, without connecting: what loading it rejects, secret
+  references (${VAR}, @file) that cannot be resolved, ports that don't match ssl or
+  starttls (993/995/465 need ssl, 143/110/587 starttls), unencrypted connections,
+  passwords and tokens written in plaintext, email notifications without SMTP, and
+  watch handlers, pipeline stages and scanners whose program is not found.
+
+Lint Options:
+  emx-mail lint [options] <file.eml>...   (no file or "-" reads stdin)
+  --json                 Output issues as JSON lines
+  --strict               Fail on warnings as well as errors
+  Checks header syntax, required and duplicate headers, addresses and dates, MIME
+  boundaries and transfer encodings, line lengths (998 octets, 78 recommended), and
+  8-bit data without a matching Content-Transfer-Encoding. Exits non-zero on errors.
+
+Diff Options:
+  emx-mail diff [options] [file.eml...]
+  --uid <uid>            UID of a message to compare (repeatable; give two, or one and a file)
+  --folder <name>        Folder of the --uid messages (default: inbox)
+  --ignore <header>      Leave a header field out of the comparison (repeatable)
+  --context <n>          Unchanged body lines shown around each change (default: 3)
+  --no-color             Disable colored output
+  Compares the header fields (decoded, unfolded) and the bodies (decoded, with line
+  endings and trailing whitespace ignored) of two messages, and lists the attachments
+  that differ by name, type, size and hash. Fails if the messages differ, like diff(1).
+  --ignore Received --ignore Date --ignore Message-Id hides what every resend changes.
+
 Watch Options:
-  --folder <name>         Folder to watch (default: INBOX)
+  --folder <name>         Folder to watch (default: inbox)
   --handler <cmd>         Handler command for new emails (receives raw EML via stdin)
+  --handler-shell <name>  Run the handler via sh, cmd, powershell, pwsh, or none (split into
+                          argv and run directly); default: cmd on Windows, sh elsewhere
   --poll-only             Force polling mode (disable IDLE)
   --once                  Process existing emails then exit
+  --max <n>               With --once, process at most n emails in this run
+  --restart               With --once, ignore the progress of an interrupted run
+  --order-by-date         Process unseen emails in the order the server received them
+                          (INTERNALDATE) rather than by UID (or watch.order_by_date)
+  --backlog <policy>      Unseen emails already in the folder to process: all (default), none
+                          (only new mail) or since:<duration>, e.g. since:72h (or watch.backlog)
+  --auto-responder        The handler sends mail in response (auto-replies, tickets): emails
+                          that must not be answered automatically are marked processed
+                          without running it (or watch.auto_responder)
   --idle-keep-alive <sec> IDLE keep-alive interval in seconds (default: 300, min: 60, max: 1740)
+  --shutdown-grace <sec>  Seconds a running handler may finish after SIGINT/SIGTERM (default: 30)
+  --changes               Also report expunged messages and flag changes made by other clients
+  --notify <target>       Notify about each new email (repeatable): desktop, ntfy:<topic or URL>,
+                          slack:<webhook URL>, discord:<webhook URL> or email:<addresses> (sent
+                          through the account's SMTP server). Rules in the account's
+                          watch.notify config can also filter by "from" and "subject", and
+                          with "digest_minutes": 15 send one summary of the emails' count and
+                          subjects every 15 minutes instead of one notification each
+  --rate-limit <n>        FETCH/SEARCH commands per second while catching up on unprocessed
+                          emails (default: 5, negative: unlimited)
+  --rate-burst <n>        Commands sent at once before --rate-limit applies (default: 10)
+  --stats-interval <sec>  Publish the watch's counters every sec seconds as watch.stats events
+                          on the event bus, channel watch-stats (or watch.stats_interval)
+  --retry-queue           Queue emails the handler failed on and retry them with backoff
+                          (or a watch.retry config)
+  --retry-list            Show the folder's retry queue and exit
+  --retry-reset <which>   Make queued emails due now, given-up ones included: all or a UID
 
 Watch Handler:
   The handler receives the raw RFC 5322 email via stdin. Exit code 0 marks as processed.
+  Its environment also has EMX_ACCOUNT, EMX_FOLDER, EMX_UID, EMX_MESSAGE_ID, EMX_FROM
+  and EMX_SUBJECT describing the email (line breaks in values become spaces), and
+  EMX_KEY, a hash of the envelope that stays the same across folders and refetches
+  (also "key" in the stdout line). Handlers can use it as an idempotency key:
+    emx-event seen -c h "$EMX_KEY" || { process && emx-event seen -c h -mark "$EMX_KEY"; }
+  EMX_SUPPRESS_AUTO_RESPONSE is set, to the reason, for emails no program should answer
+  automatically: Auto-Submitted, Precedence bulk/list/junk, X-Loop, read receipts and
+  delivery reports (multipart/report) and bounces. emx-mail's own automatic mail (email
+  notifications, send --auto-reply) carries Auto-Submitted and X-Loop, so it is among
+  them when it comes back. --auto-responder skips the handler for them, and email
+  notifications are never sent for them, so replies cannot loop.
   Use emx-save to save emails as .eml files:
   - Build: go build -o emx-save.exe ./cmd/emx-save
   - Use:   emx-mail watch --handler "emx-save ./emails"
+  - On Windows the handler runs via cmd /C; use --handler-shell powershell
+    for PowerShell scripts.
+
+  With a "scan" section in the account config, every email's attachments are checked
+  before the handler runs: {"scan": {"command": "clamdscan --no-summary",
+  "quarantine_folder": "Quarantine", "keyword": "$Infected", "reject": true}}.
+  The command gets a temporary copy of each attachment as its last argument and
+  exits 0 if clean, 1 if infected. An infected email gets the keyword and is moved
+  to the quarantine folder; a quarantined or rejected one is not handed to the
+  handler. fetch --save-attachments skips infected attachments the same way.
+
+  watch.pipelines in the account config chains handlers for the emails matching a
+  pipeline's from/subject filters, in place of --handler (the first match runs):
+    {"watch": {"pipelines": [{"name": "invoices", "from": "billing@", "stages": [
+      {"name": "save", "command": "emx-save ./emails"},
+      {"name": "extract", "command": "extract-pdf", "on_error": "continue"},
+      {"name": "notify", "command": "notify-team", "on_error": "stop"}]}]}}
+  Every stage gets the email on stdin and the handler environment, plus EMX_STAGE,
+  and EMX_PREV_STAGE, EMX_PREV_EXIT and EMX_PREV_OUTPUT (a file holding the previous
+  stage's stdout, e.g. JSON) describing the stage before it. A failing stage fails
+  the email (on_error "fail", the default), is skipped ("continue"), or ends the
+  pipeline with the email processed ("stop").
+
+  watch.commands turns the folder into an automation inbox: an email whose subject,
+  or else the first line of its new text, is a directive such as "approve 1234" runs
+  the handler of that command instead of --handler and pipelines:
+    {"watch": {"commands": {"allowed_senders": ["ops@example.com", "@corp.example"],
+      "authserv_id": "mx.corp.example",
+      "handlers": [{"name": "approve", "command": "approve-order"}]}}}
+  The handler gets the email on stdin, the handler environment, EMX_COMMAND and
+  EMX_COMMAND_ARGS. Only allowed senders may send commands, and the
+  Authentication-Results header of the receiving server (authserv_id, required) must
+  also show a passing DKIM signature or DMARC check of the sender's domain. Only
+  headers above the first Received header count, the ones the server added.
+  "insecure_trust_from": true checks the From address alone instead: anyone can
+  forge it, so only use it when the server rejects forged mail. Rejected commands are
+  reported and marked as processed; emails without a directive are handled as usual.
+
+  watch.rate_alerts catch mail loops and runaway automation: more than "max" emails
+  from one sender ("by": "sender") or with one subject, reply markers removed
+  ("subject"), received within "window" raise an alert, once per flood:
+    {"watch": {"rate_alerts": [{"by": "sender", "max": 50, "window": "10m"},
+      {"name": "ticket loop", "by": "subject", "max": 5, "window": "1m",
+       "from": "@helpdesk.example", "notify": true}]}}
+  The alert is a "flood" status line and a mail.flood event on the event bus (channel
+  watch-alerts) with the rule, the sender or subject, the count and the UID that
+  crossed the threshold; "notify": true also sends it through the notify rules.
+
+  With --retry-queue, an email the handler (or a pipeline) fails on is recorded in
+  ~/.emx-mail/watch/<account>/retry/<folder>.json with the error, and retried after
+  1m, 2m, 4m... up to 6h, whether or not it is still unseen; it is left out of the
+  unseen emails handled meanwhile. After max_attempts failures (default 10) it is
+  given up with a warning until --retry-reset. watch.retry sets the backoff:
+    {"watch": {"retry": {"base_delay": "1m", "max_delay": "6h", "max_attempts": 10}}}
+
+  With --changes, stdout also gets {"type":"expunge",...} and {"type":"flags",...} lines
+  with the folder, UID, sequence number and (for "flags") the current flags, from the
+  server's EXPUNGE and FETCH updates, so mirrors and caches can follow the folder.
+  If the folder is renumbered (its UIDVALIDITY changes while reconnecting), a
+  "uidvalidity" status line, and with --changes a {"type":"uidvalidity",...} line,
+  says that every UID reported before is stale.
 
   IDLE mode sends NOOP every --idle-keep-alive seconds to keep the connection alive.
   This prevents server timeouts for long-running watch sessions.
 
+  watch --once saves its progress through the unprocessed emails in
+  ~/.emx-mail/watch/<account>/<folder>.json after each email, so a run that is
+  interrupted or stopped by --max resumes after the last email it handled (emails
+  that failed are not retried until a run gets through the whole backlog, which
+  removes the checkpoint). A UIDVALIDITY change discards the checkpoint.
+  With --order-by-date the backlog is handled oldest-received first, for folders where
+  a migration gave old emails high UIDs, and the checkpoint records the received time;
+  a checkpoint from a run in the other order is ignored. Each stdout line has both
+  "date" (the Date header) and "internal_date" (when the server received it), in RFC 3339.
+
+  --backlog none attaches a handler to a mailbox full of old unread mail without
+  running it on that mail: only emails arriving after the start (UIDs from the folder's
+  UIDNEXT) are handled, and the skipped ones stay unseen. since:72h also handles those
+  received in the 72 hours before the start.
+
+  Catching up on a backlog is throttled to --rate-limit commands per second. When the
+  server answers with a throttling response ([UNAVAILABLE], [LIMIT], Gmail's bandwidth
+  limits or Outlook's "Request is throttled"), the rate halves and the command is
+  retried, honouring a suggested backoff time; successful commands restore the rate.
+  Each slowdown is reported as a "throttle" status line. Set watch.rate_limit and
+  watch.rate_burst in the account config to change the defaults.
+
+  With --stats-interval 60 a watch.stats event on the event bus (channel watch-stats)
+  carries the watch's "account", "folder", "processed", "failed", "pending" (unseen
+  emails not handled yet), "last_uid" and "uptime" every minute and when it stops,
+  for dashboards: emx-event ls -channel watch-stats.
+
+  SIGINT/SIGTERM stops watching: no new emails are started, a running handler gets
+  --shutdown-grace seconds before it is killed, and a final "summary" status line
+  reports how many emails were processed and failed. A second signal exits at once.
+
+Smtpd Options:
+  --listen <addr>         Address to accept SMTP on (default: 127.0.0.1:2525). There is
+                          no authentication, so other than loopback addresses are refused
+  --allow-remote          Listen on an address other machines can reach anyway; with
+                          --relay anyone who can connect sends mail through the account
+  --handler <cmd>         Archive each message with a command that gets it on stdin
+  --handler-shell <name>  Shell for the handler, as for watch
+  --event <channel>       Archive each message on the event bus (type mail.journaled)
+  --relay                 Forward each message to the account's SMTP server
+  --max-size <bytes>      Largest message accepted (default: 32 MiB)
+  A sink for applications that can only "send email": every message accepted is
+  archived and/or relayed. The archived copy starts with Return-Path and X-Envelope-To
+  headers recording the envelope, so Bcc recipients are kept; the handler also gets
+  EMX_ENVELOPE_FROM and EMX_ENVELOPE_TO. Relayed messages go to the envelope
+  recipients, from the account's address. If archiving or relaying fails the
+  application is told to retry later (451), so a message may be archived twice. One
+  JSON line per message goes to stdout. No account is needed without --relay.
+
+Imapd Options:
+  --maildir <dir>         Directory to serve (required)
+  --listen <addr>         Address to accept IMAP on (default: 127.0.0.1:1143)
+  --user <name>           Username clients log in with (default: test)
+  --password <pass>       Password clients log in with (default: test)
+  Serves saved messages over IMAP so mail clients can be pointed at archived
+  fixtures: the .eml files written by emx-save and export, and maildirs (their
+  cur and new directories, with the flags in the file names). The directory itself
+  is INBOX and every directory below it holding messages is a folder. Messages are
+  loaded into memory when imapd starts; their files are never changed, and changes
+  clients make are gone when it exits. No account is needed.
+
+Help Options:
+  emx-mail help [options] [command...]
+  --man                  Write man pages (troff) for the commands
+  --markdown             Write Markdown reference pages for the commands
+  --dir <dir>            Write one file per command (emx-mail-<command>.1 or .md)
+  Without --man or --markdown, shows the options of the given commands. The pages
+  are generated from the same flag definitions the commands parse.
+
 Examples:
   emx-mail list
   emx-mail -v list --limit 5
+  emx-mail list --json --limit 100 --cursor 1700000000:4711
+  emx-mail list --folder sent
+  emx-mail list --new-only --json
+  emx-mail --account team --as support@corp.com list --unread-only
   emx-mail send --to user@example.com --subject "Hello" --text "Hi!"
+  emx-mail sendmany --template invite.tmpl --csv people.csv --checkpoint invite.done --rate 30
   emx-mail fetch --uid 12345
+  emx-mail fetch --uid 12345 --format redacted --output sample.eml
+  emx-mail fetch --seq=-10 --output latest.txt
+  emx-mail fetch --uid 12345 --format html --inline-images files --output msg.html
   emx-mail delete --uid 12345 --expunge
   emx-mail folders
+  emx-mail folders --counts
+  emx-mail folders --subscribed
+  emx-mail flag --uid 12345 --add flagged --remove seen
+  emx-mail mark --folder Notifications --all-read --from noreply@github.com
+  emx-mail strip-attachments --uid 12345 --folder archive --dry-run
+  emx-mail annotate --folder inbox --uid 4567 state=invoiced
+  emx-mail sync && emx-mail flag --uid 12345 --add seen --offline
+  emx-mail sync --push-flags --policy server-wins
+  emx-mail export --all --dir ~/mail-mirror
+  emx-mail snapshot --folder Projects -o projects.tar.gz
+  emx-mail --account backup restore projects.tar.gz --map Projects=Archive/Projects
+  emx-mail capabilities --protocol imap
+  emx-mail verify-smtp --rcpt user@example.com
+  emx-mail sent-log --since 24h --failed
+  emx-mail delivery-status --since 72h
+  emx-mail lint message.eml
+  emx-mail diff --uid 4711 --uid 4795 --ignore Received --ignore Date
+  emx-mail diff original.eml resent.eml
+  emx-mail audit --since 24h --op expunge
   emx-mail init
+  emx-mail config validate --strict
   emx-mail watch --handler "emx-save ./emails"
   emx-mail watch --once --handler "emx-save ./emails"
-`, version)
+  emx-mail watch --once --max 500 --handler "emx-save ./emails"
+  emx-mail watch --notify desktop --notify ntfy:my-mail-topic
+  emx-mail smtpd --handler "emx-save ./journal" --relay
+  emx-mail imapd --maildir ./emails
+  emx-mail help --man --dir /usr/local/share/man/man1
+`)
 }
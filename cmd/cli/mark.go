@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+	flag "github.com/spf13/pflag"
+)
+
+type markFlags struct {
+	folder    string
+	allRead   bool
+	allUnread bool
+	from      string
+	subject   string
+	since     string
+	before    string
+	dryRun    bool
+}
+
+// markFlagSet defines the flags of the mark command on f.
+func markFlagSet(f *markFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("mark", flag.ExitOnError)
+	fs.StringVar(&f.folder, "folder", "", "Folder or logical folder to mark (default: inbox)")
+	fs.BoolVar(&f.allRead, "all-read", false, "Mark the matching unread messages as read")
+	fs.BoolVar(&f.allUnread, "all-unread", false, "Mark the matching read messages as unread")
+	fs.StringVar(&f.from, "from", "", "Only messages whose sender contains this")
+	fs.StringVar(&f.subject, "subject", "", "Only messages whose subject contains this")
+	fs.StringVar(&f.since, "since", "", "Only messages received since a duration ago (24h) or date (2006-01-02)")
+	fs.StringVar(&f.before, "before", "", "Only messages received before a duration ago (24h) or date (2006-01-02)")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "Show how many messages would change without changing them")
+	return fs
+}
+
+func parseMarkFlags(args []string) markFlags {
+	var f markFlags
+	fs := markFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("mark: %v", err)
+	}
+	return f
+}
+
+// handleMark sets or clears \Seen on every matching message of a folder
+// with a single UID STORE.
+func handleMark(acc *config.AccountConfig, f markFlags) error {
+	if f.allRead == f.allUnread {
+		return fmt.Errorf("exactly one of --all-read and --all-unread is required")
+	}
+
+	filter := email.SearchFilter{From: f.from, Subject: f.subject}
+	var err error
+	if f.since != "" {
+		if filter.Since, err = parseSince(f.since); err != nil {
+			return err
+		}
+	}
+	if f.before != "" {
+		if filter.Before, err = parseSince(f.before); err != nil {
+			return fmt.Errorf("invalid --before %q: want a duration (24h) or date (2006-01-02)", f.before)
+		}
+	}
+	// Only the messages that change
+	var add, remove []string
+	if f.allRead {
+		filter.NotFlags, add = []string{`\Seen`}, []string{`\Seen`}
+	} else {
+		filter.Flags, remove = []string{`\Seen`}, []string{`\Seen`}
+	}
+
+	client, err := newAccount(acc).IMAP()
+	if err != nil {
+		return err
+	}
+	// One connection for the search and the store
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	defer client.Close()
+
+	start := time.Now()
+	uids, err := client.Search(f.folder, filter)
+	if err != nil {
+		return err
+	}
+	state := "read"
+	if f.allUnread {
+		state = "unread"
+	}
+	if f.dryRun {
+		fmt.Printf("Would mark %d messages as %s\n", len(uids), state)
+		return nil
+	}
+	if err := client.StoreFlagsBulk(f.folder, uids, add, remove); err != nil {
+		return err
+	}
+	fmt.Printf("Marked %d messages as %s (%s)\n", len(uids), state, time.Since(start).Round(time.Millisecond))
+	return nil
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/emx-mail/cli/pkgs/cache"
 	"github.com/emx-mail/cli/pkgs/config"
 	"github.com/emx-mail/cli/pkgs/email"
 	flag "github.com/spf13/pflag"
@@ -15,12 +19,13 @@ type sendFlags struct {
 	to, cc, subject, text, html, inReplyTo string
 	textFile, htmlFile                     string
 	attachments                            []string
-	dryRun                                 bool
+	dryRun, preview, yes, noThread         bool
+	autoReply                              bool
 }
 
-func parseSendFlags(args []string) sendFlags {
+// sendFlagSet defines the flags of the send command on f.
+func sendFlagSet(f *sendFlags) *flag.FlagSet {
 	fs := flag.NewFlagSet("send", flag.ExitOnError)
-	var f sendFlags
 	fs.StringVar(&f.to, "to", "", "Recipients (comma-separated)")
 	fs.StringVar(&f.cc, "cc", "", "CC recipients (comma-separated)")
 	fs.StringVar(&f.subject, "subject", "", "Email subject")
@@ -30,7 +35,17 @@ func parseSendFlags(args []string) sendFlags {
 	fs.StringVar(&f.htmlFile, "html-file", "", "HTML body from file (\"-\" for stdin)")
 	fs.StringArrayVar(&f.attachments, "attachment", nil, "Attachment file path (repeatable)")
 	fs.StringVar(&f.inReplyTo, "in-reply-to", "", "Message-ID to reply to")
+	fs.BoolVar(&f.noThread, "no-thread", false, "With --in-reply-to, do not look up the message for References and subject")
+	fs.BoolVar(&f.autoReply, "auto-reply", false, "Mark the email as an automatic reply (Auto-Submitted, X-Loop) so autoresponders do not answer it")
 	fs.BoolVar(&f.dryRun, "dry-run", false, "Preview email without sending")
+	fs.BoolVar(&f.preview, "preview", false, "Show the composed message and ask for confirmation before sending")
+	fs.BoolVar(&f.yes, "yes", false, "With --preview, send without asking")
+	return fs
+}
+
+func parseSendFlags(args []string) sendFlags {
+	var f sendFlags
+	fs := sendFlagSet(&f)
 	if err := fs.Parse(args); err != nil {
 		fatal("send: %v", err)
 	}
@@ -61,7 +76,7 @@ func handleSend(acc *config.AccountConfig, f sendFlags) error {
 	if f.to == "" {
 		return fmt.Errorf("--to is required")
 	}
-	if f.subject == "" {
+	if f.subject == "" && f.inReplyTo == "" {
 		return fmt.Errorf("--subject is required")
 	}
 
@@ -89,16 +104,40 @@ func handleSend(acc *config.AccountConfig, f sendFlags) error {
 		return fmt.Errorf("--text, --text-file, --html, or --html-file is required")
 	}
 
+	to, err := parseAddressList(f.to)
+	if err != nil {
+		return fmt.Errorf("--to: %w", err)
+	}
+	cc, err := parseAddressList(f.cc)
+	if err != nil {
+		return fmt.Errorf("--cc: %w", err)
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("--to is required")
+	}
 	opts := email.SendOptions{
 		From:      email.Address{Name: acc.FromName, Email: acc.Email},
-		To:        parseAddressList(f.to),
+		To:        to,
+		Cc:        cc,
 		Subject:   f.subject,
 		TextBody:  textBody,
 		HTMLBody:  htmlBody,
 		InReplyTo: f.inReplyTo,
 	}
-	if f.cc != "" {
-		opts.Cc = parseAddressList(f.cc)
+	if f.autoReply {
+		opts.AutoSubmitted = "auto-replied"
+	}
+	if f.inReplyTo != "" && !f.noThread {
+		// Without the original only In-Reply-To is set; the reply still goes out
+		parent, err := findReplyParent(acc, f.inReplyTo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot thread the reply: %v\n", err)
+		} else {
+			opts = email.ThreadReply(opts, parent)
+		}
+	}
+	if opts.Subject == "" {
+		return fmt.Errorf("--subject is required (the replied-to message was not found)")
 	}
 	for _, att := range f.attachments {
 		opts.Attachments = append(opts.Attachments, email.AttachmentPath{
@@ -107,8 +146,15 @@ func handleSend(acc *config.AccountConfig, f sendFlags) error {
 		})
 	}
 
+	client, err := newAccount(acc).SMTP()
+	if err != nil {
+		return err
+	}
+
 	// Dry-run mode: preview without sending
 	if f.dryRun {
+		// Show the recipients the account defaults will add
+		opts = client.ApplyDefaults(opts)
 		fmt.Println("=== Email Preview (Dry-Run Mode) ===")
 		fmt.Println()
 		fmt.Printf("From:    %s <%s>\n", acc.FromName, acc.Email)
@@ -116,10 +162,19 @@ func handleSend(acc *config.AccountConfig, f sendFlags) error {
 		if len(opts.Cc) > 0 {
 			fmt.Printf("Cc:      %s\n", formatAddressList(opts.Cc))
 		}
+		if len(opts.Bcc) > 0 {
+			fmt.Printf("Bcc:     %s\n", formatAddressList(opts.Bcc))
+		}
+		if len(opts.ReplyTo) > 0 {
+			fmt.Printf("Reply-To: %s\n", formatAddressList(opts.ReplyTo))
+		}
 		fmt.Printf("Subject: %s\n", opts.Subject)
 		if opts.InReplyTo != "" {
 			fmt.Printf("In-Reply-To: %s\n", opts.InReplyTo)
 		}
+		if len(opts.References) > 0 {
+			fmt.Printf("References: %s\n", strings.Join(opts.References, " "))
+		}
 		fmt.Println()
 		if len(opts.Attachments) > 0 {
 			fmt.Println("Attachments:")
@@ -152,10 +207,146 @@ func handleSend(acc *config.AccountConfig, f sendFlags) error {
 		return nil
 	}
 
-	client := newSMTPClient(acc)
-	if err := client.Send(opts); err != nil {
+	if f.preview {
+		// Confirmation is read from stdin, which must not also carry the body
+		if !f.yes && (f.textFile == "-" || f.htmlFile == "-") {
+			return fmt.Errorf("--preview reads the confirmation from stdin; add --yes when the body comes from stdin")
+		}
+		m, err := client.Compose(opts)
+		if err != nil {
+			return err
+		}
+		if err := printComposedMessage(os.Stdout, m); err != nil {
+			return err
+		}
+		if !f.yes && !confirm(os.Stdin, os.Stderr, "Send this message? [y/N] ") {
+			fmt.Println("Email NOT sent")
+			return nil
+		}
+		return sendAndRecord(client, acc, opts, m)
+	}
+
+	m, err := client.Compose(opts)
+	if err != nil {
+		return err
+	}
+	return sendAndRecord(client, acc, opts, m)
+}
+
+// findReplyParent returns the message with Message-ID id from the
+// account's thread index, or else looks it up over IMAP in
+// email.ReplyFolders and adds it to the index.
+func findReplyParent(acc *config.AccountConfig, id string) (*email.Message, error) {
+	threads, err := cache.DefaultThreads(cacheAccount(acc))
+	if err == nil {
+		var e cache.ThreadEntry
+		var ok bool
+		if e, ok, err = threads.Lookup(id, time.Now()); ok {
+			return &email.Message{MessageID: e.MessageID, Subject: e.Subject, References: e.References}, nil
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: thread index: %v\n", err)
+		threads = nil
+	}
+
+	client, err := newAccount(acc).IMAP()
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	msg, loc, err := client.LocateMessageByID(id, email.ReplyFolders...)
+	if err != nil {
+		return nil, err
+	}
+	if threads != nil {
+		recordThread(threads, cache.ThreadEntry{
+			MessageID:   msg.MessageID,
+			Subject:     msg.Subject,
+			References:  msg.References,
+			Folder:      loc.Folder,
+			UIDValidity: loc.UIDValidity,
+			UID:         loc.UID,
+		})
+	}
+	return msg, nil
+}
+
+// recordSentThread adds the sent message m to the account's thread index,
+// so replies to it are threaded without a server search.
+func recordSentThread(acc *config.AccountConfig, opts email.SendOptions, m *email.ComposedMessage) {
+	threads, err := cache.DefaultThreads(cacheAccount(acc))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: thread index: %v\n", err)
+		return
+	}
+	recordThread(threads, cache.ThreadEntry{MessageID: m.MessageID(), Subject: opts.Subject, References: opts.References})
+}
+
+// recordThread adds e to threads. The index is a cache, so failing to
+// write it only prints a warning.
+func recordThread(threads *cache.Threads, e cache.ThreadEntry) {
+	if err := threads.Record(time.Now(), e); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: thread index: %v\n", err)
+	}
+}
+
+// sendAndRecord transmits m and records the outcome in the sent-log, and a
+// sent m in the thread index.
+func sendAndRecord(client *email.SMTPClient, acc *config.AccountConfig, opts email.SendOptions, m *email.ComposedMessage) error {
+	err := client.SendComposed(m)
+	recordSent("send", acc, opts, m, 1, err)
+	if err != nil {
 		return err
 	}
+	recordSentThread(acc, opts, m)
 	fmt.Println("Email sent successfully")
 	return nil
 }
+
+// printComposedMessage writes the header block of m exactly as it will be
+// transmitted, the envelope recipients (which include Bcc), and the decoded
+// bodies and attachments.
+func printComposedMessage(w io.Writer, m *email.ComposedMessage) error {
+	msg, err := m.Decode()
+	if err != nil {
+		return fmt.Errorf("failed to decode composed message: %w", err)
+	}
+
+	fmt.Fprintln(w, "=== Composed Message ===")
+	fmt.Fprintln(w, strings.ReplaceAll(m.Header(), "\r\n", "\n"))
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Envelope: %s -> %s\n", m.From, strings.Join(m.Recipients, ", "))
+	fmt.Fprintln(w)
+	if msg.TextBody != "" {
+		fmt.Fprintln(w, "--- text/plain ---")
+		fmt.Fprintln(w, strings.TrimRight(msg.TextBody, "\r\n"))
+	}
+	if msg.HTMLBody != "" {
+		fmt.Fprintln(w, "--- text/html ---")
+		fmt.Fprintln(w, strings.TrimRight(msg.HTMLBody, "\r\n"))
+	}
+	for _, att := range msg.Attachments {
+		fmt.Fprintf(w, "--- attachment: %s (%s, %d bytes) ---\n", att.Filename, att.ContentType, att.Size)
+	}
+	for _, att := range m.Uploads() {
+		fmt.Fprintf(w, "--- attachment: %s, too large: uploaded and linked when sent ---\n", att.Path)
+	}
+	fmt.Fprintln(w, "=== End of Message ===")
+	return nil
+}
+
+// confirm writes prompt to w and reports whether the answer read from r is
+// yes. Anything else, including EOF, counts as no.
+func confirm(r io.Reader, w io.Writer, prompt string) bool {
+	fmt.Fprint(w, prompt)
+	line, _ := bufio.NewReader(r).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+	flag "github.com/spf13/pflag"
+)
+
+type sendManyFlags struct {
+	template    string
+	csv         string
+	checkpoint  string
+	rate        int
+	retries     int
+	retryDelay  int
+	perConn     int
+	attachments []string
+	dryRun      bool
+}
+
+// sendManyFlagSet defines the flags of the sendmany command on f.
+func sendManyFlagSet(f *sendManyFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("sendmany", flag.ExitOnError)
+	fs.StringVar(&f.template, "template", "", "Message template (text/template): headers, a blank line, then the body")
+	fs.StringVar(&f.csv, "csv", "", "Recipients CSV; the header row names the template fields")
+	fs.StringVar(&f.checkpoint, "checkpoint", "", "File recording sent rows; rows listed there are skipped on resume")
+	fs.IntVar(&f.rate, "rate", 0, "Maximum messages per minute (0 = unlimited)")
+	fs.IntVar(&f.retries, "retries", 3, "Retries per message for temporary failures")
+	fs.IntVar(&f.retryDelay, "retry-delay", 5, "Seconds before the first retry, doubled for each further retry")
+	fs.IntVar(&f.perConn, "per-connection", 0, "Open a new SMTP connection after this many messages (0 = never)")
+	fs.StringArrayVar(&f.attachments, "attachment", nil, "Attachment file path for every message (repeatable)")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "Render every message and report it without sending")
+	return fs
+}
+
+func parseSendManyFlags(args []string) sendManyFlags {
+	var f sendManyFlags
+	fs := sendManyFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("sendmany: %v", err)
+	}
+	return f
+}
+
+// sendManyStatus is the per-recipient JSON line written to stdout.
+type sendManyStatus struct {
+	Row      int    `json:"row"` // 1-based data row, excluding the CSV header
+	To       string `json:"to"`
+	Status   string `json:"status"` // "sent", "failed", "skipped" or "rendered" (--dry-run)
+	Attempts int    `json:"attempts,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handleSendMany renders one message per CSV row and sends them in one SMTP
+// session.
+func handleSendMany(ctx context.Context, acc *config.AccountConfig, f sendManyFlags) error {
+	if f.template == "" || f.csv == "" {
+		return fmt.Errorf("--template and --csv are required")
+	}
+	if f.rate < 0 || f.retries < 0 || f.retryDelay < 0 || f.perConn < 0 {
+		return fmt.Errorf("--rate, --retries, --retry-delay and --per-connection must not be negative")
+	}
+
+	tmplText, err := os.ReadFile(f.template)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New(f.template).Option("missingkey=error").Parse(string(tmplText))
+	if err != nil {
+		return fmt.Errorf("parse template: %w", err)
+	}
+
+	header, rows, err := readRecipientsCSV(f.csv)
+	if err != nil {
+		return err
+	}
+
+	done, err := loadCheckpoint(f.checkpoint)
+	if err != nil {
+		return err
+	}
+	var checkpoint *os.File
+	if f.checkpoint != "" && !f.dryRun {
+		checkpoint, err = os.OpenFile(f.checkpoint, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		defer checkpoint.Close()
+	}
+
+	client, err := newAccount(acc).SMTP()
+	if err != nil {
+		return err
+	}
+	session := client.NewSession()
+	session.Retries = f.retries
+	session.RetryDelay = time.Duration(f.retryDelay) * time.Second
+	session.MaxPerConnection = f.perConn
+	defer session.Close()
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var interval time.Duration
+	if f.rate > 0 {
+		interval = time.Minute / time.Duration(f.rate)
+	}
+	var lastSend time.Time
+
+	out := json.NewEncoder(os.Stdout)
+	var sent, failed, skipped int
+	for i, record := range rows {
+		if ctx.Err() != nil {
+			break
+		}
+		fields := make(map[string]string, len(header))
+		for j, name := range header {
+			fields[name] = record[j]
+		}
+
+		st := sendManyStatus{Row: i + 1}
+		opts, err := renderMergeMessage(tmpl, fields, acc, f.attachments)
+		if err != nil {
+			st.To, st.Status, st.Error = fields["email"], "failed", err.Error()
+			failed++
+			out.Encode(st)
+			continue
+		}
+		st.To = formatAddressList(opts.To)
+
+		key := checkpointKey(st.Row, st.To)
+		if done[key] {
+			st.Status = "skipped"
+			skipped++
+			out.Encode(st)
+			continue
+		}
+
+		m, err := client.Compose(opts)
+		if err == nil && f.dryRun {
+			st.Status = "rendered"
+			out.Encode(st)
+			continue
+		}
+		if err == nil {
+			if wait := interval - time.Since(lastSend); !lastSend.IsZero() && wait > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(wait):
+				}
+				if ctx.Err() != nil {
+					break
+				}
+			}
+			lastSend = time.Now()
+			st.Attempts, err = session.SendComposed(ctx, m)
+			recordSent("sendmany", acc, opts, m, st.Attempts, err)
+		}
+		if err != nil {
+			st.Status, st.Error = "failed", err.Error()
+			failed++
+			out.Encode(st)
+			continue
+		}
+
+		st.Status = "sent"
+		sent++
+		recordSentThread(acc, opts, m)
+		if checkpoint != nil {
+			if _, err := fmt.Fprintln(checkpoint, key); err != nil {
+				return fmt.Errorf("write checkpoint: %w", err)
+			}
+		}
+		out.Encode(st)
+	}
+
+	fmt.Fprintf(os.Stderr, "sendmany: %d sent, %d failed, %d skipped of %d rows\n", sent, failed, skipped, len(rows))
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out; rerun with the same --checkpoint to resume")
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted; rerun with the same --checkpoint to resume")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d messages failed", failed)
+	}
+	return nil
+}
+
+// readRecipientsCSV reads the header row and the data rows of path.
+func readRecipientsCSV(path string) (header []string, rows [][]string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	header, err = r.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read CSV header: %w", err)
+	}
+	for i := range header {
+		// Spreadsheet exports often start with a UTF-8 BOM
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+	rows, err = r.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read CSV: %w", err)
+	}
+	return header, rows, nil
+}
+
+// renderMergeMessage executes tmpl for one row. The output is a header
+// block (Subject, To, Cc, Reply-To, Content-Type) followed by a blank line
+// and the body; To defaults to the row's "email" column and a
+// "Content-Type: text/html" header makes the body HTML.
+func renderMergeMessage(tmpl *template.Template, fields map[string]string, acc *config.AccountConfig, attachments []string) (email.SendOptions, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, fields); err != nil {
+		return email.SendOptions{}, fmt.Errorf("render template: %w", err)
+	}
+	msg, err := mail.ReadMessage(&buf)
+	if err != nil {
+		return email.SendOptions{}, fmt.Errorf("rendered message has no header block: %w", err)
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		return email.SendOptions{}, err
+	}
+
+	to := msg.Header.Get("To")
+	if to == "" {
+		to = fields["email"]
+	}
+	opts := email.SendOptions{
+		From:    email.Address{Name: acc.FromName, Email: acc.Email},
+		Subject: msg.Header.Get("Subject"),
+	}
+	if opts.To, err = parseAddressList(to); err != nil {
+		return email.SendOptions{}, fmt.Errorf("To: %w", err)
+	}
+	if opts.Cc, err = parseAddressList(msg.Header.Get("Cc")); err != nil {
+		return email.SendOptions{}, fmt.Errorf("Cc: %w", err)
+	}
+	if opts.ReplyTo, err = parseAddressList(msg.Header.Get("Reply-To")); err != nil {
+		return email.SendOptions{}, fmt.Errorf("Reply-To: %w", err)
+	}
+	if len(opts.To) == 0 {
+		return email.SendOptions{}, fmt.Errorf("no recipient: set a To header or an \"email\" column")
+	}
+	if strings.HasPrefix(strings.ToLower(msg.Header.Get("Content-Type")), "text/html") {
+		opts.HTMLBody = string(body)
+	} else {
+		opts.TextBody = string(body)
+	}
+	for _, path := range attachments {
+		opts.Attachments = append(opts.Attachments, email.AttachmentPath{
+			Filename: filepath.Base(path),
+			Path:     path,
+		})
+	}
+	return opts, nil
+}
+
+// checkpointKey identifies a row in the checkpoint file by position and
+// recipient, so an edited CSV does not skip the wrong people.
+func checkpointKey(row int, to string) string {
+	return fmt.Sprintf("%d\t%s", row, to)
+}
+
+func loadCheckpoint(path string) (map[string]bool, error) {
+	done := make(map[string]bool)
+	if path == "" {
+		return done, nil
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			done[line] = true
+		}
+	}
+	return done, scanner.Err()
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+	"github.com/emx-mail/cli/pkgs/event"
+	flag "github.com/spf13/pflag"
+)
+
+// The send journal lives on the default event bus (~/.emx-mail/events), so
+// the emx-event tool can follow or isolate it like any other channel.
+const (
+	sentLogChannel    = "sent-log"
+	sentLogEventType  = "mail.sent"
+	deliveryEventType = "mail.delivery"
+)
+
+// sentRecord is the payload of a send journal event. The event timestamp
+// records when the send finished.
+type sentRecord struct {
+	Command    string   `json:"command"` // "send" or "sendmany"
+	Account    string   `json:"account"`
+	From       string   `json:"from"`
+	To         []string `json:"to"`
+	Recipients []string `json:"recipients"` // Envelope recipients: To, Cc and Bcc
+	MessageID  string   `json:"message_id,omitempty"`
+	Subject    string   `json:"subject"`
+	Result     string   `json:"result"` // "sent" or "failed"
+	Attempts   int      `json:"attempts,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// deliveryRecord is the payload of a delivery status event: the status of
+// one recipient of a journaled message, from a delivery status notification
+// matched by delivery-status. A later record for the same recipient
+// replaces an earlier one.
+type deliveryRecord struct {
+	Account      string `json:"account"`
+	MessageID    string `json:"message_id"`
+	Recipient    string `json:"recipient"`
+	Action       string `json:"action"` // "failed", "delayed", "delivered", "relayed" or "expanded"
+	Status       string `json:"status,omitempty"`
+	Diagnostic   string `json:"diagnostic,omitempty"`
+	ReportingMTA string `json:"reporting_mta,omitempty"`
+	ReportID     string `json:"report_id"` // Message-ID of the report, or folder/UID without one
+}
+
+// recordSent appends the outcome of transmitting m to the send journal.
+// The journal is best effort: failing to write it only prints a warning,
+// since the message has already gone out (or failed) by then.
+func recordSent(command string, acc *config.AccountConfig, opts email.SendOptions, m *email.ComposedMessage, attempts int, sendErr error) {
+	rec := sentRecord{
+		Command:    command,
+		Account:    acc.Name,
+		From:       m.From,
+		Recipients: m.Recipients,
+		MessageID:  m.MessageID(),
+		Subject:    opts.Subject,
+		Result:     "sent",
+		Attempts:   attempts,
+	}
+	for _, a := range opts.To {
+		rec.To = append(rec.To, a.Email)
+	}
+	if sendErr != nil {
+		rec.Result, rec.Error = "failed", sendErr.Error()
+	}
+
+	payload, err := json.Marshal(rec)
+	if err == nil {
+		var bus *event.Bus
+		if bus, err = event.DefaultBus(); err == nil {
+			_, err = bus.Add(sentLogEventType, sentLogChannel, payload)
+			bus.Close()
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record message in sent-log: %v\n", err)
+	}
+}
+
+type sentLogFlags struct {
+	limit     int
+	since     string
+	to        string
+	messageID string
+	failed    bool
+	json      bool
+	noColor   bool
+}
+
+// sentLogFlagSet defines the flags of the sent-log command on f.
+func sentLogFlagSet(f *sentLogFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("sent-log", flag.ExitOnError)
+	fs.IntVar(&f.limit, "limit", 20, "Show the most recent N entries (0 = all)")
+	fs.StringVar(&f.since, "since", "", "Only entries newer than a duration (24h) or date (2006-01-02)")
+	fs.StringVar(&f.to, "to", "", "Only messages with a recipient containing this text")
+	fs.StringVar(&f.messageID, "message-id", "", "Only the message with this Message-ID")
+	fs.BoolVar(&f.failed, "failed", false, "Only failed sends")
+	fs.BoolVar(&f.json, "json", false, "Output JSON lines")
+	fs.BoolVar(&f.noColor, "no-color", false, "Disable colored output")
+	return fs
+}
+
+func parseSentLogFlags(args []string) sentLogFlags {
+	var f sentLogFlags
+	fs := sentLogFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("sent-log: %v", err)
+	}
+	return f
+}
+
+// sentLogEntry is a journal record with its time and the latest delivery
+// status of its recipients, as printed by --json.
+type sentLogEntry struct {
+	Time time.Time `json:"time"`
+	sentRecord
+	Delivery []deliveryRecord `json:"delivery,omitempty"`
+}
+
+// bounced reports whether a recipient of the message was reported failed.
+func (e sentLogEntry) bounced() bool {
+	for _, d := range e.Delivery {
+		if d.Action == "failed" {
+			return true
+		}
+	}
+	return false
+}
+
+// readJournal returns the sent messages of the send journal, oldest first,
+// with their delivery status, and every delivery status record.
+func readJournal(bus *event.Bus) ([]sentLogEntry, []deliveryRecord, error) {
+	events, err := bus.ListFrom(sentLogChannel, event.Position{}, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entries []sentLogEntry
+	var deliveries []deliveryRecord
+	for _, evt := range events {
+		if evt.Channel != sentLogChannel || (evt.Type != sentLogEventType && evt.Type != deliveryEventType) {
+			continue
+		}
+		payload, err := bus.ResolvePayload(&evt.Event)
+		if err != nil {
+			return nil, nil, err
+		}
+		if evt.Type == deliveryEventType {
+			var d deliveryRecord
+			if err := json.Unmarshal(payload, &d); err == nil {
+				deliveries = append(deliveries, d)
+			}
+			continue
+		}
+		var rec sentRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			continue
+		}
+		entries = append(entries, sentLogEntry{Time: evt.Timestamp, sentRecord: rec})
+	}
+
+	byID := make(map[string]int)
+	for i, e := range entries {
+		if e.MessageID != "" {
+			byID[e.MessageID] = i
+		}
+	}
+	for _, d := range deliveries {
+		i, ok := byID[d.MessageID]
+		if !ok {
+			continue
+		}
+		e := &entries[i]
+		replaced := false
+		for j := range e.Delivery {
+			if strings.EqualFold(e.Delivery[j].Recipient, d.Recipient) {
+				e.Delivery[j], replaced = d, true
+			}
+		}
+		if !replaced {
+			e.Delivery = append(e.Delivery, d)
+		}
+	}
+	return entries, deliveries, nil
+}
+
+// handleSentLog prints the send journal. It needs no config; the global
+// --account option filters by account name or sender address instead.
+func handleSentLog(account string, f sentLogFlags) error {
+	var since time.Time
+	if f.since != "" {
+		var err error
+		if since, err = parseSince(f.since); err != nil {
+			return err
+		}
+	}
+
+	bus, err := event.DefaultBus()
+	if err != nil {
+		return err
+	}
+	all, _, err := readJournal(bus)
+	if err != nil {
+		return err
+	}
+
+	var entries []sentLogEntry
+	for _, e := range all {
+		if e.matches(f, account, since) {
+			entries = append(entries, e)
+		}
+	}
+	if f.limit > 0 && len(entries) > f.limit {
+		entries = entries[len(entries)-f.limit:]
+	}
+
+	if f.json {
+		out := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := out.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No sent messages recorded")
+		return nil
+	}
+	tbl := newTable([]string{"Time", "Result", "Account", "To", "Subject"}, 4, terminalWidth(), useColor(f.noColor))
+	for _, e := range entries {
+		style := ""
+		if e.Result != "sent" || e.bounced() {
+			style = ansiYellow
+		}
+		tbl.addRow(style,
+			e.Time.Local().Format("2006-01-02 15:04:05"),
+			e.Result,
+			e.Account,
+			strings.Join(e.Recipients, ", "),
+			e.Subject)
+		note := e.MessageID
+		if e.Error != "" {
+			note = strings.TrimSpace(note + "  " + e.Error)
+		}
+		for _, d := range e.Delivery {
+			status := strings.Join(strings.Fields(d.Action+" "+d.Status+" "+d.Diagnostic), " ")
+			note = strings.TrimSpace(note + "  " + d.Recipient + ": " + status)
+		}
+		if note != "" {
+			tbl.addNote(note)
+		}
+	}
+	tbl.render(os.Stdout)
+	return nil
+}
+
+// matches reports whether the entry passes the sent-log filters.
+func (e sentLogEntry) matches(f sentLogFlags, account string, since time.Time) bool {
+	if !since.IsZero() && e.Time.Before(since) {
+		return false
+	}
+	if f.failed && e.Result == "sent" && !e.bounced() {
+		return false
+	}
+	if account != "" && !strings.EqualFold(account, e.Account) && !strings.EqualFold(account, e.From) {
+		return false
+	}
+	if f.messageID != "" && strings.Trim(f.messageID, "<>") != e.MessageID {
+		return false
+	}
+	if f.to != "" {
+		want := strings.ToLower(f.to)
+		for _, r := range e.Recipients {
+			if strings.Contains(strings.ToLower(r), want) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// parseSince accepts a duration before now ("24h", "90m") or a local date
+// ("2006-01-02") or date and time ("2006-01-02 15:04").
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: want a duration (24h) or date (2006-01-02)", s)
+}
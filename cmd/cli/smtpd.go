@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/mail"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+	"github.com/emx-mail/cli/pkgs/event"
+	flag "github.com/spf13/pflag"
+)
+
+// Messages accepted by smtpd are archived on the event bus with this type.
+const smtpdEventType = "mail.journaled"
+
+// smtpdShutdownGrace is how long open connections may finish after
+// SIGINT/SIGTERM.
+const smtpdShutdownGrace = 30 * time.Second
+
+type smtpdFlags struct {
+	listen       string
+	handler      string
+	handlerShell string
+	event        string
+	relay        bool
+	allowRemote  bool
+	maxSize      int64
+}
+
+// smtpdFlagSet defines the flags of the smtpd command on f.
+func smtpdFlagSet(f *smtpdFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("smtpd", flag.ExitOnError)
+	fs.StringVar(&f.listen, "listen", email.DefaultRelayAddr, "Address to accept SMTP on; only loopback addresses unless --allow-remote, there is no authentication")
+	fs.StringVar(&f.handler, "handler", "", "Archive each message with this command, which gets it on stdin (e.g. \"emx-save ./journal\")")
+	fs.StringVar(&f.handlerShell, "handler-shell", "", "Shell for the handler: sh, cmd, powershell, pwsh or none (default: cmd on Windows, sh elsewhere)")
+	fs.StringVar(&f.event, "event", "", "Archive each message on this event bus channel")
+	fs.BoolVar(&f.relay, "relay", false, "Forward each message to the account's SMTP server")
+	fs.BoolVar(&f.allowRemote, "allow-remote", false, "Allow a --listen address other machines can reach; with --relay anyone who can connect sends mail through the account")
+	fs.Int64Var(&f.maxSize, "max-size", email.DefaultRelayMaxMessageSize, "Largest message accepted, in bytes")
+	return fs
+}
+
+func parseSMTPDFlags(args []string) smtpdFlags {
+	var f smtpdFlags
+	fs := smtpdFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("smtpd: %v", err)
+	}
+	return f
+}
+
+// smtpdStatus is the per-message JSON line written to stdout.
+type smtpdStatus struct {
+	Type      string   `json:"type"` // "message"
+	From      string   `json:"from"` // Envelope sender
+	To        []string `json:"to"`   // Envelope recipients
+	MessageID string   `json:"message_id,omitempty"`
+	Size      int      `json:"size"`
+	Status    string   `json:"status"` // "accepted" or "failed" (the client was told to retry)
+	Error     string   `json:"error,omitempty"`
+}
+
+// handleSMTPD runs a local SMTP server that archives and/or relays every
+// message it accepts. An account is only needed to relay.
+func handleSMTPD(a *app, f smtpdFlags) error {
+	if f.handler == "" && f.event == "" && !f.relay {
+		return fmt.Errorf("nothing to do with messages: give --handler, --event and/or --relay")
+	}
+	if f.handler != "" {
+		if _, err := email.HandlerCommand(f.handlerShell, f.handler); err != nil {
+			return err
+		}
+	}
+
+	var bus *event.Bus
+	if f.event != "" {
+		var err error
+		if bus, err = event.DefaultBus(); err != nil {
+			return err
+		}
+	}
+
+	var acc *config.AccountConfig
+	var smtpClient *email.SMTPClient
+	if f.relay {
+		acc = a.loadAccount()
+		if acc.SMTP.Host == "" {
+			return fmt.Errorf("--relay requires SMTP configuration")
+		}
+		var err error
+		if smtpClient, err = newAccount(acc).SMTP(); err != nil {
+			return err
+		}
+	}
+
+	// Messages are relayed one at a time over the shared client
+	var relayMu sync.Mutex
+	var outMu sync.Mutex
+	out := json.NewEncoder(os.Stdout)
+
+	server := &email.RelayServer{
+		MaxMessageSize: f.maxSize,
+		Handler: func(m *email.RelayMessage) error {
+			err := smtpdArchive(f, bus, m)
+			if err == nil && smtpClient != nil {
+				relayMu.Lock()
+				err = smtpClient.SendComposed(&email.ComposedMessage{
+					From:       acc.Email,
+					Recipients: m.To,
+					Data:       m.Data,
+				})
+				relayMu.Unlock()
+			}
+
+			status := smtpdStatus{
+				Type:      "message",
+				From:      m.From,
+				To:        m.To,
+				MessageID: smtpdMessageID(m.Data),
+				Size:      len(m.Data),
+				Status:    "accepted",
+			}
+			if err != nil {
+				status.Status, status.Error = "failed", err.Error()
+			}
+			outMu.Lock()
+			out.Encode(status)
+			outMu.Unlock()
+			return err
+		},
+	}
+
+	l, err := net.Listen("tcp", f.listen)
+	if err != nil {
+		return err
+	}
+	// Checked once listening, with host names resolved
+	if !f.allowRemote && !email.IsLoopbackAddr(l.Addr().String()) {
+		l.Close()
+		return fmt.Errorf("refusing to listen on %s, which other machines can reach: there is no authentication (give --allow-remote to do it anyway)", f.listen)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), smtpdShutdownGrace)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Accepting SMTP on %s\n", l.Addr())
+	return server.Serve(l)
+}
+
+// smtpdArchive hands the journal copy of m, which records its envelope, to
+// the handler and the event bus.
+func smtpdArchive(f smtpdFlags, bus *event.Bus, m *email.RelayMessage) error {
+	journal := m.Journal()
+	if f.handler != "" {
+		cmd, err := email.HandlerCommand(f.handlerShell, f.handler)
+		if err != nil {
+			return err
+		}
+		cmd.Stdin = bytes.NewReader(journal)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		cmd.Env = append(os.Environ(),
+			"EMX_ENVELOPE_FROM="+m.From,
+			"EMX_ENVELOPE_TO="+strings.Join(m.To, ","))
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("handler failed: %w", err)
+		}
+	}
+	if bus != nil {
+		if _, err := bus.AddBlob(smtpdEventType, f.event, bytes.NewReader(journal)); err != nil {
+			return fmt.Errorf("failed to add event: %w", err)
+		}
+	}
+	return nil
+}
+
+// smtpdMessageID returns the Message-ID of a raw message, without angle
+// brackets, or "" if it has none.
+func smtpdMessageID(data []byte) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(msg.Header.Get("Message-Id")), "<>")
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+	flag "github.com/spf13/pflag"
+)
+
+type snapshotFlags struct {
+	output  string
+	folders []string
+	all     bool
+	window  int
+}
+
+// snapshotFlagSet defines the flags of the snapshot command on f.
+func snapshotFlagSet(f *snapshotFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	fs.StringVarP(&f.output, "output", "o", "", "Snapshot file to write: .tar.zst for zstd, else gzip (.tar.gz)")
+	fs.StringArrayVar(&f.folders, "folder", nil, "Folder or logical folder to capture (repeatable; default: inbox)")
+	fs.BoolVar(&f.all, "all", false, "Capture every folder selected by the account's sync config")
+	fs.IntVar(&f.window, "window", email.DefaultFetchWindow, "Messages requested from the server at once; raise it on high-latency links")
+	return fs
+}
+
+func parseSnapshotFlags(args []string) snapshotFlags {
+	var f snapshotFlags
+	fs := snapshotFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("snapshot: %v", err)
+	}
+	return f
+}
+
+// handleSnapshot writes the messages of folders, with their flags, internal
+// dates and folder metadata, to a snapshot file for restore.
+func handleSnapshot(acc *config.AccountConfig, f snapshotFlags) error {
+	if f.output == "" {
+		return fmt.Errorf("--output is required")
+	}
+	if f.all && len(f.folders) > 0 {
+		return fmt.Errorf("--all and --folder cannot be combined")
+	}
+
+	a := newAccount(acc)
+	a.FetchProgress = printFetchProgress
+	client, err := a.IMAP()
+	if err != nil {
+		return err
+	}
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	defer client.Close()
+
+	folders := f.folders
+	if f.all {
+		if folders, err = client.SyncFolders(newSyncFilter(acc)); err != nil {
+			return err
+		}
+	} else if len(folders) == 0 {
+		folders = []string{""}
+	}
+	roles, delims, err := folderMetadata(client)
+	if err != nil {
+		return err
+	}
+
+	// Written next to the output and renamed when complete
+	tmp, err := os.CreateTemp(filepath.Dir(f.output), ".tmp-snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	w := email.NewSnapshotWriter(tmp, cacheAccount(acc))
+	if strings.HasSuffix(f.output, ".zst") {
+		if w, err = email.NewZstdSnapshotWriter(tmp, cacheAccount(acc)); err != nil {
+			return err
+		}
+	}
+	for _, name := range folders {
+		folder, err := client.FetchSnapshotFolder(name)
+		if err != nil {
+			return err
+		}
+		folder.Role, folder.Delim = roles[folder.Name], delims[folder.Name]
+		w.AddFolder(*folder)
+
+		byUID := make(map[uint32]email.SnapshotMessage, len(folder.Messages))
+		uids := make([]uint32, len(folder.Messages))
+		for i, m := range folder.Messages {
+			byUID[m.UID] = m
+			uids[i] = m.UID
+		}
+		captured := 0
+		err = client.FetchRawMessages(folder.Name, uids, f.window, func(uid uint32, raw []byte) error {
+			captured++
+			return w.AddMessage(byUID[uid], raw)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Captured %s: %d messages\n", folder.Name, captured)
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.output)
+}
+
+// folderMetadata returns the logical folder (role) of the folders of the
+// server that have one, and the hierarchy delimiter of every folder ("" in
+// a flat namespace), by name.
+func folderMetadata(client *email.IMAPClient) (roles, delims map[string]string, err error) {
+	list, err := client.ListFolders(email.ListFoldersOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	roles, delims = make(map[string]string), make(map[string]string)
+	for _, f := range list {
+		delims[f.Name] = ""
+		if f.Delim != 0 {
+			delims[f.Name] = string(f.Delim)
+		}
+	}
+	for _, role := range email.FolderRoles {
+		name, err := client.ResolveFolder(role)
+		if err != nil {
+			return nil, nil, err
+		}
+		if roles[name] == "" {
+			roles[name] = role
+		}
+	}
+	return roles, delims, nil
+}
+
+type restoreFlags struct {
+	input   string
+	mapping []string
+	folders []string
+	dryRun  bool
+}
+
+// restoreFlagSet defines the flags of the restore command on f.
+func restoreFlagSet(f *restoreFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.StringVarP(&f.input, "input", "i", "", "Snapshot file to restore")
+	fs.StringArrayVar(&f.mapping, "map", nil, "Restore snapshot folder OLD into NEW, as OLD=NEW (repeatable)")
+	fs.StringArrayVar(&f.folders, "folder", nil, "Only restore this snapshot folder (repeatable)")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "Show where each folder would be restored and how many messages it lacks")
+	return fs
+}
+
+func parseRestoreFlags(args []string) restoreFlags {
+	var f restoreFlags
+	fs := restoreFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("restore: %v", err)
+	}
+	if f.input == "" && fs.NArg() == 1 {
+		f.input = fs.Arg(0)
+	}
+	return f
+}
+
+// handleRestore appends the messages of a snapshot that are missing from
+// their destination folders, with their flags and internal dates, then
+// checks that every Message-ID of the snapshot is in its folder.
+func handleRestore(acc *config.AccountConfig, f restoreFlags) error {
+	if f.input == "" {
+		return fmt.Errorf("--input is required")
+	}
+	snap, err := email.OpenSnapshot(f.input)
+	if err != nil {
+		return err
+	}
+	mapping := make(map[string]string)
+	for _, m := range f.mapping {
+		from, to, ok := strings.Cut(m, "=")
+		if !ok || from == "" || to == "" {
+			return fmt.Errorf("invalid --map %q: want OLD=NEW", m)
+		}
+		mapping[from] = to
+	}
+	only := make(map[string]bool)
+	for _, name := range f.folders {
+		only[name] = true
+	}
+	for name := range only {
+		if !snapshotHasFolder(snap, name) {
+			return fmt.Errorf("the snapshot has no folder %s", name)
+		}
+	}
+
+	client, err := newAccount(acc).IMAP()
+	if err != nil {
+		return err
+	}
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_, delims, err := folderMetadata(client)
+	if err != nil {
+		return err
+	}
+	targetDelim := delims["INBOX"]
+
+	// Destination of each snapshot folder, and how many messages of each
+	// Message-ID are already there
+	dest := make(map[string]string)
+	present := make(map[string]map[string]int)
+	for i := range snap.Manifest.Folders {
+		sf := &snap.Manifest.Folders[i]
+		if len(only) > 0 && !only[sf.Name] {
+			continue
+		}
+		target, err := restoreTarget(client, sf, mapping, delims)
+		if err != nil {
+			return err
+		}
+		dest[sf.Name] = target
+		_, exists := delims[target]
+		if !exists && !f.dryRun {
+			if err := client.CreateFolder(target); err != nil {
+				return err
+			}
+			delims[target], exists = targetDelim, true
+		}
+		ids, err := folderMessageIDs(client, target, !exists)
+		if err != nil {
+			return err
+		}
+		present[target] = ids
+
+		// Messages without Message-ID cannot be recognized and are always appended
+		missing, noID := email.SnapshotCoverage(sf, ids)
+		fmt.Fprintf(os.Stderr, "%s -> %s: %d of %d messages to restore\n", sf.Name, target, len(missing)+noID, len(sf.Messages))
+	}
+	if f.dryRun {
+		return nil
+	}
+
+	appended := make(map[string]int)
+	err = snap.Messages(func(sf *email.SnapshotFolder, m *email.SnapshotMessage, raw []byte) error {
+		target, ok := dest[sf.Name]
+		if !ok {
+			return nil
+		}
+		// Each message already there stands for one of the snapshot, so
+		// duplicates are restored as many times as they were captured
+		if m.MessageID != "" && present[target][m.MessageID] > 0 {
+			present[target][m.MessageID]--
+			return nil
+		}
+		if err := client.AppendMessageAt(target, raw, m.Flags, m.InternalDate); err != nil {
+			return err
+		}
+		appended[sf.Name]++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Verify from the server, not from what was appended
+	incomplete := 0
+	for i := range snap.Manifest.Folders {
+		sf := &snap.Manifest.Folders[i]
+		target, ok := dest[sf.Name]
+		if !ok {
+			continue
+		}
+		ids, err := folderMessageIDs(client, target, false)
+		if err != nil {
+			return err
+		}
+		missing, unverifiable := email.SnapshotCoverage(sf, ids)
+		fmt.Printf("Restored %s -> %s: %d appended; %d of %d Message-IDs present",
+			sf.Name, target, appended[sf.Name], len(sf.Messages)-unverifiable-len(missing), len(sf.Messages)-unverifiable)
+		if unverifiable > 0 {
+			fmt.Printf(" (%d messages without Message-ID not checked)", unverifiable)
+		}
+		fmt.Println()
+		for _, m := range missing {
+			fmt.Printf("  missing: UID %d <%s>\n", m.UID, m.MessageID)
+		}
+		incomplete += len(missing)
+	}
+	if incomplete > 0 {
+		return fmt.Errorf("verification failed: %d messages are missing", incomplete)
+	}
+	return nil
+}
+
+// restoreTarget returns the folder sf is restored into: its --map entry,
+// the existing folder of the same role on the target server, or the same
+// name with the target server's hierarchy delimiter. delims holds the
+// delimiters of the target server's folders.
+func restoreTarget(client *email.IMAPClient, sf *email.SnapshotFolder, mapping, delims map[string]string) (string, error) {
+	if to, ok := mapping[sf.Name]; ok {
+		return client.ResolveFolder(to)
+	}
+	if sf.Role != "" {
+		name, err := client.ResolveFolder(sf.Role)
+		if err != nil {
+			return "", err
+		}
+		if _, exists := delims[name]; exists {
+			return name, nil
+		}
+	}
+	if delim := delims["INBOX"]; sf.Delim != "" && delim != "" && sf.Delim != delim {
+		return strings.ReplaceAll(sf.Name, sf.Delim, delim), nil
+	}
+	return sf.Name, nil
+}
+
+// folderMessageIDs returns the Message-IDs of the messages in folder with
+// how many messages have each, or none if it does not exist yet.
+func folderMessageIDs(client *email.IMAPClient, folder string, missing bool) (map[string]int, error) {
+	ids := make(map[string]int)
+	if missing {
+		return ids, nil
+	}
+	f, err := client.FetchSnapshotFolder(folder)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range f.Messages {
+		if m.MessageID != "" {
+			ids[m.MessageID]++
+		}
+	}
+	return ids, nil
+}
+
+func snapshotHasFolder(snap *email.Snapshot, name string) bool {
+	for _, f := range snap.Manifest.Folders {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	flag "github.com/spf13/pflag"
+)
+
+type stripFlags struct {
+	uid    string
+	folder string
+	dryRun bool
+}
+
+// stripFlagSet defines the flags of the strip-attachments command on f.
+func stripFlagSet(f *stripFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("strip-attachments", flag.ExitOnError)
+	fs.StringVar(&f.uid, "uid", "", "Message UID")
+	fs.StringVar(&f.folder, "folder", "", "Folder or logical folder containing the message (default: inbox)")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "Show what would be removed without changing the message")
+	return fs
+}
+
+func parseStripFlags(args []string) stripFlags {
+	var f stripFlags
+	fs := stripFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("strip-attachments: %v", err)
+	}
+	return f
+}
+
+// handleStrip replaces a message on the server by a copy whose attachments
+// are replaced by placeholder text parts.
+func handleStrip(acc *config.AccountConfig, f stripFlags) error {
+	if f.uid == "" {
+		return fmt.Errorf("--uid is required")
+	}
+	var uid uint32
+	if _, err := fmt.Sscanf(f.uid, "%d", &uid); err != nil {
+		return fmt.Errorf("invalid UID: %s", f.uid)
+	}
+
+	client, err := newAccount(acc).IMAP()
+	if err != nil {
+		return err
+	}
+	res, err := client.StripAttachments(f.folder, uid, f.dryRun)
+	if res == nil {
+		return err
+	}
+	if len(res.Stripped) == 0 {
+		fmt.Printf("Message %d has no attachments\n", uid)
+		return err
+	}
+
+	verb := "Removed"
+	if f.dryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d attachment(s) from message %d (%d -> %d bytes):\n", verb, len(res.Stripped), uid, res.OldSize, res.NewSize)
+	for _, p := range res.Stripped {
+		name := p.Filename
+		if name == "" {
+			name = "(no filename)"
+		}
+		fmt.Printf("  %s (%s, %d bytes)\n", name, p.ContentType, p.Size)
+	}
+	if f.dryRun {
+		return nil
+	}
+	if res.NewUID != 0 {
+		fmt.Printf("Stripped copy stored as UID %d\n", res.NewUID)
+	}
+	if !res.Expunged && err == nil {
+		fmt.Println("Original marked as deleted; without UIDPLUS it is removed by the next expunge of the folder")
+	}
+	return err
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emx-mail/cli/pkgs/cache"
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+	flag "github.com/spf13/pflag"
+)
+
+type syncFlags struct {
+	folder    string
+	all       bool
+	pushFlags bool
+	policy    string
+	dryRun    bool
+}
+
+// syncFlagSet defines the flags of the sync command on f.
+func syncFlagSet(f *syncFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	fs.StringVar(&f.folder, "folder", "", "Folder or logical folder to sync (default: inbox)")
+	fs.BoolVar(&f.all, "all", false, "Sync every folder selected by the account's sync config")
+	fs.BoolVar(&f.pushFlags, "push-flags", false, "Push flag changes made with 'flag --offline' to the server")
+	fs.StringVar(&f.policy, "policy", "", "Conflict policy for --push-flags: last-writer-wins or server-wins (default: last-writer-wins)")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "Report what --push-flags would change without changing anything")
+	return fs
+}
+
+func parseSyncFlags(args []string) syncFlags {
+	var f syncFlags
+	fs := syncFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("sync: %v", err)
+	}
+	return f
+}
+
+// syncChange is the JSON line written for each message --push-flags
+// touches.
+type syncChange struct {
+	Folder   string   `json:"folder"`
+	UID      uint32   `json:"uid"`
+	Status   string   `json:"status"` // "pushed", "merged", "discarded" (server won) or "gone" (expunged or outside the sync limits)
+	Add      []string `json:"add,omitempty"`
+	Remove   []string `json:"remove,omitempty"`
+	Flags    []string `json:"flags,omitempty"` // Flags on the server afterwards
+	Conflict bool     `json:"conflict,omitempty"`
+}
+
+// handleSync refreshes the flag cache of a folder, or with --all of every
+// folder the account's sync config selects, and with --push-flags first
+// pushes the pending local changes.
+func handleSync(acc *config.AccountConfig, f syncFlags) error {
+	policy, err := cache.ParsePolicy(f.policy)
+	if err != nil {
+		return err
+	}
+	if f.all && f.folder != "" {
+		return fmt.Errorf("--all and --folder cannot be combined")
+	}
+
+	store, err := cache.Default()
+	if err != nil {
+		return err
+	}
+	client, err := newAccount(acc).IMAP()
+	if err != nil {
+		return err
+	}
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	defer client.Close()
+
+	filter := newSyncFilter(acc)
+	folders := []string{f.folder}
+	if f.all {
+		if folders, err = client.SyncFolders(filter); err != nil {
+			return err
+		}
+	}
+	for _, folder := range folders {
+		if err := syncFolder(client, store, acc, folder, filter, policy, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syncFolder(client *email.IMAPClient, store *cache.Store, acc *config.AccountConfig, folder string, filter email.SyncFilter, policy cache.Policy, f syncFlags) error {
+	server, err := client.FetchFolderFlags(folder, filter)
+	if err != nil {
+		return err
+	}
+	cached, err := store.Load(cacheAccount(acc), server.Folder)
+	if err != nil {
+		return err
+	}
+
+	pending := 0
+	for _, m := range cached.Messages {
+		if m.Pending() {
+			pending++
+		}
+	}
+	if cached.UIDValidity != 0 && cached.UIDValidity != server.UIDValidity {
+		// The server renumbered the folder: cached UIDs mean nothing now
+		if pending > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: UIDVALIDITY of %s changed, discarding %d unpushed flag changes\n", server.Folder, pending)
+		}
+		cached.Messages = make(map[uint32]*cache.Message)
+		pending = 0
+	}
+
+	var pushed, conflicts int
+	if f.pushFlags && pending > 0 {
+		pushed, conflicts, err = pushFlags(client, cached, server, policy, f.dryRun)
+		if errors.Is(err, email.ErrUIDValidityChanged) {
+			// Renumbered since FetchFolderFlags: start over, which
+			// discards the changes left as the cached UIDs are stale
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			return syncFolder(client, store, acc, folder, filter, policy, f)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if f.dryRun {
+		return nil
+	}
+
+	// Refresh from the server, keeping local changes that were not pushed
+	messages := make(map[uint32]*cache.Message, len(server.Flags))
+	for uid, flags := range server.Flags {
+		m := &cache.Message{Flags: flags}
+		if old, ok := cached.Messages[uid]; ok && old.Pending() {
+			m = old
+		}
+		messages[uid] = m
+	}
+	cached.Messages = messages
+	cached.UIDValidity = server.UIDValidity
+	cached.Synced = time.Now()
+	if err := store.Save(cacheAccount(acc), cached); err != nil {
+		return err
+	}
+
+	summary := fmt.Sprintf("Synced %s: %d messages", server.Folder, len(messages))
+	if f.pushFlags {
+		summary += fmt.Sprintf(", %d flag changes pushed (%d conflicts, policy %s)", pushed, conflicts, policy)
+	} else if pending > 0 {
+		summary += fmt.Sprintf(", %d local flag changes pending (use --push-flags)", pending)
+	}
+	fmt.Fprintln(os.Stderr, summary)
+	return nil
+}
+
+// pushFlags stores the pending local changes of cached on the server,
+// resolving conflicts with policy, and updates server to the result.
+func pushFlags(client *email.IMAPClient, cached *cache.Folder, server *email.FolderFlags, policy cache.Policy, dryRun bool) (pushed, conflicts int, err error) {
+	out := json.NewEncoder(os.Stdout)
+	uids := make([]uint32, 0, len(cached.Messages))
+	for uid, m := range cached.Messages {
+		if m.Pending() {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+
+	for _, uid := range uids {
+		m := cached.Messages[uid]
+		current, ok := server.Flags[uid]
+		if !ok {
+			out.Encode(syncChange{Folder: server.Folder, UID: uid, Status: "gone"})
+			continue
+		}
+
+		flags, conflict := m.Resolve(current, policy)
+		add, remove := cache.Diff(current, flags)
+		change := syncChange{Folder: server.Folder, UID: uid, Add: add, Remove: remove, Flags: flags, Conflict: conflict}
+		switch {
+		case conflict && policy == cache.ServerWins:
+			change.Status = "discarded"
+		case conflict:
+			change.Status = "merged"
+		default:
+			change.Status = "pushed"
+		}
+		if conflict {
+			conflicts++
+		}
+
+		if len(add)+len(remove) > 0 && !dryRun {
+			if err := client.StoreFlags(server.Folder, uid, add, remove); err != nil {
+				return pushed, conflicts, err
+			}
+			pushed++
+		}
+		if !dryRun {
+			server.Flags[uid] = flags
+			m.Local, m.Changed = nil, time.Time{}
+		}
+		out.Encode(change)
+	}
+	return pushed, conflicts, nil
+}
+
+type flagFlags struct {
+	uid     string
+	folder  string
+	add     []string
+	remove  []string
+	offline bool
+}
+
+// flagFlagSet defines the flags of the flag command on f.
+func flagFlagSet(f *flagFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("flag", flag.ExitOnError)
+	fs.StringVar(&f.uid, "uid", "", "Message UID")
+	fs.StringVar(&f.folder, "folder", "", "Folder or logical folder containing the message (default: inbox)")
+	fs.StringArrayVar(&f.add, "add", nil, "Flag to add: seen, flagged, answered, deleted, draft or a keyword (repeatable)")
+	fs.StringArrayVar(&f.remove, "remove", nil, "Flag to remove (repeatable)")
+	fs.BoolVar(&f.offline, "offline", false, "Record the change in the local cache; 'sync --push-flags' sends it later")
+	return fs
+}
+
+func parseFlagFlags(args []string) flagFlags {
+	var f flagFlags
+	fs := flagFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("flag: %v", err)
+	}
+	return f
+}
+
+// handleFlag changes the flags of a message on the server, or with
+// --offline in the local cache only.
+func handleFlag(acc *config.AccountConfig, f flagFlags) error {
+	if f.uid == "" {
+		return fmt.Errorf("--uid is required")
+	}
+	var uid uint32
+	if _, err := fmt.Sscanf(f.uid, "%d", &uid); err != nil {
+		return fmt.Errorf("invalid UID: %s", f.uid)
+	}
+	if len(f.add)+len(f.remove) == 0 {
+		return fmt.Errorf("--add or --remove is required")
+	}
+	add, remove := imapFlagNames(f.add), imapFlagNames(f.remove)
+
+	if !f.offline {
+		client, err := newAccount(acc).IMAP()
+		if err != nil {
+			return err
+		}
+		if err := client.StoreFlags(f.folder, uid, add, remove); err != nil {
+			return err
+		}
+		fmt.Println("Flags updated")
+		return nil
+	}
+
+	store, err := cache.Default()
+	if err != nil {
+		return err
+	}
+	folder := offlineFolder(acc, f.folder)
+	cached, err := store.Load(cacheAccount(acc), folder)
+	if err != nil {
+		return err
+	}
+	if err := cached.SetFlags(uid, add, remove, time.Now()); err != nil {
+		return err
+	}
+	if err := store.Save(cacheAccount(acc), cached); err != nil {
+		return err
+	}
+	fmt.Printf("Flags of UID %d now %s (pending until sync --push-flags)\n", uid, strings.Join(cached.Messages[uid].Current(), " "))
+	return nil
+}
+
+// imapFlagNames maps the short names of system flags to IMAP flags;
+// keywords are kept as given.
+func imapFlagNames(names []string) []string {
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimPrefix(name, `\`)) {
+		case "seen":
+			name = `\Seen`
+		case "flagged":
+			name = `\Flagged`
+		case "answered":
+			name = `\Answered`
+		case "deleted":
+			name = `\Deleted`
+		case "draft":
+			name = `\Draft`
+		}
+		out = append(out, name)
+	}
+	return out
+}
+
+// cacheAccount names the account's directory in the local cache.
+func cacheAccount(acc *config.AccountConfig) string {
+	if acc.Name != "" {
+		return acc.Name
+	}
+	return acc.Email
+}
+
+// offlineFolder resolves a folder name without asking the server: logical
+// folders go through the account's folder mappings, and a logical folder
+// found by SPECIAL-USE needs its server name.
+func offlineFolder(acc *config.AccountConfig, name string) string {
+	role := strings.ToLower(name)
+	if name == "" {
+		role = email.FolderInbox
+	}
+	if !email.IsFolderRole(role) {
+		return name
+	}
+	if mapped := acc.Folders[role]; mapped != "" {
+		return mapped
+	}
+	if role == email.FolderInbox {
+		return "INBOX"
+	}
+	return name
+}
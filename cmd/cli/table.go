@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ANSI SGR sequences used by the table renderer and diff.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+)
+
+// defaultCols is the width of output that is not a terminal, when
+// $COLUMNS is unset.
+const defaultCols = 100
+
+// useColor reports whether ANSI colors should be emitted on stdout.
+// Colors are disabled by --no-color, by the NO_COLOR environment variable
+// (https://no-color.org), or when stdout is not a terminal.
+func useColor(noColor bool) bool {
+	if noColor {
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalWidth returns the width to fit output to: $COLUMNS if set, the
+// width of the terminal stdout is, or else defaultCols.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("COLUMNS"))); err == nil && n > 0 {
+		return n
+	}
+	if n, ok := ttyWidth(os.Stdout); ok {
+		return n
+	}
+	return defaultCols
+}
+
+// tableRow is a single row of cells plus the style applied to the whole row.
+type tableRow struct {
+	cells []string
+	style string // ANSI prefix applied to the row, empty for none
+	note  string // optional indented line printed below the row
+}
+
+// table renders rows as aligned columns that fit within a given width.
+// The flex column absorbs any shrinking needed to fit the terminal.
+type table struct {
+	headers []string
+	rows    []tableRow
+	flex    int // index of the column that is truncated to fit
+	width   int // total available width
+	color   bool
+}
+
+// newTable creates a table with the given headers.
+func newTable(headers []string, flex, width int, color bool) *table {
+	return &table{headers: headers, flex: flex, width: width, color: color}
+}
+
+// addRow appends a row. style is an ANSI prefix (e.g. ansiBold) or "".
+func (t *table) addRow(style string, cells ...string) {
+	for i, c := range cells {
+		cells[i] = cleanCell(c)
+	}
+	t.rows = append(t.rows, tableRow{cells: cells, style: style})
+}
+
+// addNote attaches an indented, dimmed line below the last added row.
+func (t *table) addNote(note string) {
+	if len(t.rows) > 0 {
+		t.rows[len(t.rows)-1].note = cleanCell(note)
+	}
+}
+
+// cleanCell replaces control characters (tabs, newlines from folded
+// headers, escape sequences) with spaces so they cannot break the layout.
+func cleanCell(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// render writes the table to w.
+func (t *table) render(w io.Writer) {
+	const gap = 2
+
+	widths := make([]int, len(t.headers))
+	for i, h := range t.headers {
+		widths[i] = displayWidth(h)
+	}
+	for _, r := range t.rows {
+		for i, c := range r.cells {
+			if i < len(widths) {
+				if cw := displayWidth(c); cw > widths[i] {
+					widths[i] = cw
+				}
+			}
+		}
+	}
+
+	// Shrink the flex column so the whole row fits the terminal width.
+	total := gap * (len(widths) - 1)
+	for _, cw := range widths {
+		total += cw
+	}
+	if total > t.width && t.flex >= 0 && t.flex < len(widths) {
+		minFlex := displayWidth(t.headers[t.flex])
+		if minFlex < 10 {
+			minFlex = 10
+		}
+		widths[t.flex] -= total - t.width
+		if widths[t.flex] < minFlex {
+			widths[t.flex] = minFlex
+		}
+	}
+
+	t.writeLine(w, t.headers, widths, ansiDim)
+	for _, r := range t.rows {
+		t.writeLine(w, r.cells, widths, r.style)
+		if r.note != "" {
+			note := "    " + truncateWidth(r.note, t.width-4)
+			if t.color {
+				note = ansiDim + note + ansiReset
+			}
+			fmt.Fprintln(w, note)
+		}
+	}
+}
+
+// writeLine writes one padded line; the last column is not right-padded.
+func (t *table) writeLine(w io.Writer, cells []string, widths []int, style string) {
+	var b strings.Builder
+	if t.color && style != "" {
+		b.WriteString(style)
+	}
+	for i := range widths {
+		cell := ""
+		if i < len(cells) {
+			cell = cells[i]
+		}
+		cell = truncateWidth(cell, widths[i])
+		b.WriteString(cell)
+		if i < len(widths)-1 {
+			b.WriteString(strings.Repeat(" ", widths[i]-displayWidth(cell)+2))
+		}
+	}
+	if t.color && style != "" {
+		b.WriteString(ansiReset)
+	}
+	fmt.Fprintln(w, strings.TrimRight(b.String(), " "))
+}
+
+// displayWidth returns the number of terminal cells needed to show s.
+// East Asian wide and fullwidth characters occupy two cells.
+func displayWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeWidth(r)
+	}
+	return n
+}
+
+// truncateWidth shortens s to at most max display cells, appending "…"
+// when anything was cut. It never splits a multi-byte character.
+func truncateWidth(s string, max int) string {
+	if displayWidth(s) <= max {
+		return s
+	}
+	if max <= 1 {
+		return strings.Repeat(".", max)
+	}
+	var b strings.Builder
+	w := 0
+	for _, r := range s {
+		rw := runeWidth(r)
+		if w+rw > max-1 {
+			break
+		}
+		b.WriteRune(r)
+		w += rw
+	}
+	b.WriteString("…")
+	return b.String()
+}
+
+// runeWidth returns the display width of a single rune.
+func runeWidth(r rune) int {
+	switch {
+	case r == utf8.RuneError, r < 0x20, r == 0x7f:
+		return 0
+	case r >= 0x1100 && r <= 0x115f, // Hangul Jamo
+		r >= 0x2e80 && r <= 0x303e, // CJK radicals, punctuation
+		r >= 0x3041 && r <= 0x33ff, // Kana, CJK compatibility
+		r >= 0x3400 && r <= 0x4dbf, // CJK extension A
+		r >= 0x4e00 && r <= 0x9fff, // CJK unified ideographs
+		r >= 0xa000 && r <= 0xa4cf, // Yi
+		r >= 0xac00 && r <= 0xd7a3, // Hangul syllables
+		r >= 0xf900 && r <= 0xfaff, // CJK compatibility ideographs
+		r >= 0xfe30 && r <= 0xfe4f, // CJK compatibility forms
+		r >= 0xff00 && r <= 0xff60, // Fullwidth forms
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f, // Emoji
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x20000 && r <= 0x3fffd: // CJK extensions B+
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,10 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package main
+
+import "os"
+
+// ttyWidth reports false: the terminal size is unknown on this platform.
+func ttyWidth(f *os.File) (int, bool) {
+	return 0, false
+}
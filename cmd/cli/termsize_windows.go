@@ -0,0 +1,20 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// ttyWidth returns the width in columns of the console window f is, and
+// false if f is not a console.
+func ttyWidth(f *os.File) (int, bool) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(f.Fd()), &info); err != nil {
+		return 0, false
+	}
+	w := int(info.Window.Right-info.Window.Left) + 1
+	return w, w > 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// timeoutGrace is how long a command taking the context, such as watch,
+// gets to shut down after --timeout runs out.
+const timeoutGrace = 30 * time.Second
+
+// contextCommands stop on their own when the command context is done:
+// watch and sendmany finish as on SIGTERM.
+var contextCommands = map[string]bool{
+	"watch":    true,
+	"sendmany": true,
+}
+
+// dial opens the connections of the mail clients newAccount returns; nil
+// for plain dials. commandContext sets it when --timeout is given.
+var dial func(network, addr string) (net.Conn, error)
+
+// commandContext returns the context cmd runs under, done when --timeout
+// runs out. Client calls cannot be interrupted, so connections to the
+// servers also stop at the timeout, or timeoutGrace later for
+// contextCommands: a call blocked on an unresponsive server then fails
+// with an i/o timeout error, and the command exits through its usual
+// error path.
+func (a *app) commandContext(cmd string) (context.Context, context.CancelFunc) {
+	if a.timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	deadline, _ := ctx.Deadline()
+	if contextCommands[cmd] {
+		deadline = deadline.Add(timeoutGrace)
+	}
+	dial = deadlineDialer(ctx, deadline)
+	return ctx, cancel
+}
+
+// deadlineDialer returns a dial function connecting with ctx, whose
+// connections keep every deadline set on them within deadline.
+func deadlineDialer(ctx context.Context, deadline time.Time) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{Timeout: 10 * time.Second}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		c := &deadlineConn{Conn: conn, deadline: deadline}
+		if err := c.SetDeadline(time.Time{}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// deadlineConn is a connection whose deadlines never pass deadline: the
+// clients set their own, e.g. 5 minutes per POP3 session.
+type deadlineConn struct {
+	net.Conn
+	deadline time.Time
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	return c.Conn.SetDeadline(c.clamp(t))
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	return c.Conn.SetReadDeadline(c.clamp(t))
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	return c.Conn.SetWriteDeadline(c.clamp(t))
+}
+
+// clamp returns t, or deadline if t is later or zero (no deadline).
+func (c *deadlineConn) clamp(t time.Time) time.Time {
+	if t.IsZero() || t.After(c.deadline) {
+		return c.deadline
+	}
+	return t
+}
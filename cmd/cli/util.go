@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"net/mail"
 	"os"
 	"strings"
 	"unicode/utf8"
@@ -27,36 +26,19 @@ func (a *app) loadAccount() *config.AccountConfig {
 	if err != nil {
 		fatal("%v", err)
 	}
+	if a.as != "" {
+		if acc, err = acc.Delegate(a.as); err != nil {
+			fatal("%v", err)
+		}
+	}
 	return acc
 }
 
-// parseAddressList splits a comma-separated address string and validates each address.
-func parseAddressList(s string) []email.Address {
-	parts := strings.Split(s, ",")
-	addrs := make([]email.Address, 0, len(parts))
-	for _, part := range parts {
-		part = strings.TrimSpace(part)
-		if part == "" {
-			continue
-		}
-		// Validate email address format
-		// Try to parse as "Name <email>" or just "email"
-		addr, err := mail.ParseAddress(part)
-		if err != nil {
-			// If parsing fails, check if it at least contains @ (basic validation)
-			if !strings.Contains(part, "@") {
-				fmt.Fprintf(os.Stderr, "Warning: invalid email address format: %s (missing @)\n", part)
-			}
-			// Still include it - let SMTP server reject if invalid
-			addrs = append(addrs, email.Address{Email: part})
-		} else {
-			addrs = append(addrs, email.Address{
-				Name:  addr.Name,
-				Email: addr.Address,
-			})
-		}
-	}
-	return addrs
+// parseAddressList parses a comma-separated address string such as
+// "Alice Example <alice@x.com>, bob@y.com". An invalid address is an
+// error, reported before connecting to the server.
+func parseAddressList(s string) ([]email.Address, error) {
+	return email.ParseAddresses(s)
 }
 
 func formatAddress(addr email.Address) string {
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/emx-mail/cli/pkgs/config"
+	"github.com/emx-mail/cli/pkgs/email"
+	flag "github.com/spf13/pflag"
+)
+
+type verifySMTPFlags struct {
+	rcpt       string
+	jsonOutput bool
+}
+
+// verifySMTPFlagSet defines the flags of the verify-smtp command on f.
+func verifySMTPFlagSet(f *verifySMTPFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet("verify-smtp", flag.ExitOnError)
+	fs.StringVar(&f.rcpt, "rcpt", "", "Also check that the server accepts this recipient")
+	fs.BoolVar(&f.jsonOutput, "json", false, "Output the result as JSON")
+	return fs
+}
+
+func parseVerifySMTPFlags(args []string) verifySMTPFlags {
+	var f verifySMTPFlags
+	fs := verifySMTPFlagSet(&f)
+	if err := fs.Parse(args); err != nil {
+		fatal("verify-smtp: %v", err)
+	}
+	return f
+}
+
+// handleVerifySMTP connects and authenticates to the account's SMTP server
+// without sending anything. It fails if the server cannot be used or the
+// --rcpt recipient is rejected, so pipelines can use it as a pre-flight check.
+func handleVerifySMTP(acc *config.AccountConfig, f verifySMTPFlags) error {
+	if acc.SMTP.Host == "" {
+		return fmt.Errorf("SMTP not configured for account %s", acc.Email)
+	}
+	client, err := newAccount(acc).SMTP()
+	if err != nil {
+		return err
+	}
+	addr := fmt.Sprintf("%s:%d", acc.SMTP.Host, acc.SMTP.Port)
+
+	result, err := client.Verify(email.SMTPVerifyOptions{From: acc.Email, Recipient: f.rcpt})
+	if f.jsonOutput {
+		type jsonResult struct {
+			Server      string `json:"server"`
+			OK          bool   `json:"ok"`
+			Error       string `json:"error,omitempty"`
+			Recipient   string `json:"recipient,omitempty"`
+			Accepted    *bool  `json:"accepted,omitempty"`
+			RcptReply   string `json:"rcpt_reply,omitempty"`
+			Verified    *bool  `json:"verified,omitempty"`
+			VerifyReply string `json:"vrfy_reply,omitempty"`
+		}
+		jr := jsonResult{Server: addr, OK: err == nil}
+		if err != nil {
+			jr.Error = err.Error()
+		} else if f.rcpt != "" {
+			jr.Recipient = f.rcpt
+			jr.Accepted, jr.RcptReply = &result.Accepted, result.RcptReply
+			jr.Verified, jr.VerifyReply = &result.Verified, result.VerifyReply
+			jr.OK = result.Accepted
+		}
+		data, _ := json.Marshal(jr)
+		fmt.Println(string(data))
+	}
+	if err != nil {
+		return err
+	}
+
+	if !f.jsonOutput {
+		fmt.Printf("SMTP %s: connected and authenticated\n", addr)
+		if f.rcpt != "" {
+			if result.Verified {
+				fmt.Printf("VRFY %s: confirmed\n", f.rcpt)
+			} else {
+				fmt.Printf("VRFY %s: not confirmed (%s)\n", f.rcpt, result.VerifyReply)
+			}
+			if result.Accepted {
+				fmt.Printf("RCPT %s: accepted\n", f.rcpt)
+			} else {
+				fmt.Printf("RCPT %s: rejected (%s)\n", f.rcpt, result.RcptReply)
+			}
+		}
+	}
+	if f.rcpt != "" && !result.Accepted {
+		return fmt.Errorf("recipient %s rejected: %s", f.rcpt, result.RcptReply)
+	}
+	return nil
+}
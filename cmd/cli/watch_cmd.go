@@ -2,49 +2,106 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/emx-mail/cli/pkgs/config"
 	"github.com/emx-mail/cli/pkgs/email"
+	"github.com/emx-mail/cli/pkgs/event"
 	flag "github.com/spf13/pflag"
 )
 
 type watchFlags struct {
 	folder        string
 	handler       string
+	handlerShell  string
 	pollOnly      bool
 	once          bool
 	idleKeepAlive int
+	shutdownGrace int
+	notify        []string
+	changes       bool
+	rateLimit     float64
+	rateBurst     int
+	max           int
+	restart       bool
+	orderByDate   bool
+	backlog       string
+	statsInterval int
+	autoResponder bool
+	retryQueue    bool
+	retryList     bool
+	retryReset    string
 }
 
-func parseWatchFlags(args []string) watchFlags {
+// Default throttling of watch's FETCH/SEARCH commands, well below the
+// limits of Gmail and Outlook.
+const (
+	defaultWatchRateLimit = 5
+	defaultWatchRateBurst = 10
+)
+
+// defaultRetryMaxAttempts is how often the retry queue tries a failed
+// email before giving up on it.
+const defaultRetryMaxAttempts = 10
+
+// watchFlagSet defines the flags of the watch command on f.
+func watchFlagSet(f *watchFlags) *flag.FlagSet {
 	fs := flag.NewFlagSet("watch", flag.ExitOnError)
-	var f watchFlags
-	fs.StringVar(&f.folder, "folder", "", "Folder to watch (default: INBOX)")
+	fs.StringVar(&f.folder, "folder", "", "Folder or logical folder to watch (default: inbox)")
 	fs.StringVar(&f.handler, "handler", "", "Handler command for new emails")
+	fs.StringVar(&f.handlerShell, "handler-shell", "", "Shell for the handler: sh, cmd, powershell, pwsh or none (default: cmd on Windows, sh elsewhere)")
 	fs.BoolVar(&f.pollOnly, "poll-only", false, "Force polling mode (disable IDLE)")
 	fs.BoolVar(&f.once, "once", false, "Process existing emails then exit")
+	fs.IntVar(&f.max, "max", 0, "With --once, process at most N emails in this run")
+	fs.BoolVar(&f.restart, "restart", false, "With --once, ignore the progress of an interrupted run and start from the oldest unseen email")
+	fs.StringVar(&f.backlog, "backlog", "", "Unseen emails to process at startup: all, none or since:<duration> such as since:72h (default: all)")
+	fs.BoolVar(&f.orderByDate, "order-by-date", false, "Process unseen emails in the order the server received them (INTERNALDATE) rather than by UID")
 	fs.IntVar(&f.idleKeepAlive, "idle-keep-alive", 0, "IDLE keep-alive interval in seconds (default: 300, min: 60, max: 1740)")
+	fs.IntVar(&f.shutdownGrace, "shutdown-grace", 0, "Seconds a running handler may finish after SIGINT/SIGTERM (default: 30)")
+	fs.IntVar(&f.statsInterval, "stats-interval", 0, "Publish watch.stats events with the watch's counters to the event bus every N seconds (default: off)")
+	fs.BoolVar(&f.autoResponder, "auto-responder", false, "The handler sends mail in response: skip it for auto-replies, lists, bounces and our own mail")
+	fs.BoolVar(&f.changes, "changes", false, "Also report expunged messages and flag changes on stdout")
+	fs.BoolVar(&f.retryQueue, "retry-queue", false, "Queue emails the handler failed on and retry them with backoff, even once seen")
+	fs.BoolVar(&f.retryList, "retry-list", false, "Show the retry queue of the folder and exit")
+	fs.StringVar(&f.retryReset, "retry-reset", "", "Make queued emails due now, including those given up: all or a UID, then exit")
+	fs.Float64Var(&f.rateLimit, "rate-limit", 0, "FETCH/SEARCH commands per second while catching up (default: 5, negative: unlimited)")
+	fs.IntVar(&f.rateBurst, "rate-burst", 0, "FETCH/SEARCH commands sent at once before --rate-limit applies (default: 10)")
+	fs.StringArrayVar(&f.notify, "notify", nil, "Notify about every new email: desktop, ntfy:<topic or URL>, slack:<webhook> or discord:<webhook> (repeatable)")
+	return fs
+}
+
+func parseWatchFlags(args []string) watchFlags {
+	var f watchFlags
+	fs := watchFlagSet(&f)
 	if err := fs.Parse(args); err != nil {
 		fatal("watch: %v", err)
 	}
 	return f
 }
 
-func handleWatch(acc *config.AccountConfig, opts watchFlags) error {
+func handleWatch(ctx context.Context, acc *config.AccountConfig, opts watchFlags) error {
 	if acc.IMAP.Host == "" {
 		return fmt.Errorf("watch mode requires IMAP configuration")
 	}
 
 	watchOpts := email.WatchOptions{
 		Folder:        opts.folder,
+		Account:       cacheAccount(acc),
 		HandlerCmd:    opts.handler,
+		HandlerShell:  opts.handlerShell,
 		PollOnly:      opts.pollOnly,
 		Once:          opts.once,
 		IdleKeepAlive: opts.idleKeepAlive,
+		ShutdownGrace: opts.shutdownGrace,
+		Changes:       opts.changes,
+		OrderByDate:   opts.orderByDate,
+		Backlog:       opts.backlog,
+		AutoResponder: opts.autoResponder,
 	}
 
 	// Apply config defaults if specified
@@ -55,6 +112,9 @@ func handleWatch(acc *config.AccountConfig, opts watchFlags) error {
 		if watchOpts.HandlerCmd == "" && acc.Watch.HandlerCmd != "" {
 			watchOpts.HandlerCmd = acc.Watch.HandlerCmd
 		}
+		if watchOpts.HandlerShell == "" && acc.Watch.HandlerShell != "" {
+			watchOpts.HandlerShell = acc.Watch.HandlerShell
+		}
 		if acc.Watch.KeepAlive > 0 {
 			watchOpts.KeepAlive = acc.Watch.KeepAlive
 		}
@@ -67,20 +127,309 @@ func handleWatch(acc *config.AccountConfig, opts watchFlags) error {
 		if acc.Watch.IdleKeepAlive > 0 && watchOpts.IdleKeepAlive == 0 {
 			watchOpts.IdleKeepAlive = acc.Watch.IdleKeepAlive
 		}
+		if acc.Watch.ShutdownGrace > 0 && watchOpts.ShutdownGrace == 0 {
+			watchOpts.ShutdownGrace = acc.Watch.ShutdownGrace
+		}
+		if acc.Watch.Changes {
+			watchOpts.Changes = true
+		}
+		if acc.Watch.OrderByDate {
+			watchOpts.OrderByDate = true
+		}
+		if watchOpts.Backlog == "" {
+			watchOpts.Backlog = acc.Watch.Backlog
+		}
+		if acc.Watch.AutoResponder {
+			watchOpts.AutoResponder = true
+		}
+	}
+
+	folder := watchOpts.Folder
+	if folder == "" {
+		folder = "inbox"
+	}
+	if opts.retryList || opts.retryReset != "" || opts.retryQueue || (acc.Watch != nil && acc.Watch.Retry != nil) {
+		queue, err := newRetryQueue(acc, folder)
+		if err != nil {
+			return err
+		}
+		switch {
+		case opts.retryList:
+			return printRetryQueue(queue)
+		case opts.retryReset != "":
+			return resetRetryQueue(queue, opts.retryReset)
+		}
+		watchOpts.RetryQueue = queue
+	}
+
+	if opts.once {
+		checkpoint, err := email.DefaultWatchCheckpoint(cacheAccount(acc), offlineFolder(acc, folder))
+		if err != nil {
+			return err
+		}
+		if opts.restart {
+			if err := checkpoint.Clear(); err != nil {
+				return fmt.Errorf("failed to clear checkpoint: %w", err)
+			}
+		}
+		watchOpts.Checkpoint = checkpoint
+		watchOpts.Max = opts.max
+	} else if opts.max > 0 || opts.restart {
+		return fmt.Errorf("--max and --restart require --once")
+	}
+
+	rate, burst := opts.rateLimit, opts.rateBurst
+	if acc.Watch != nil {
+		if rate == 0 {
+			rate = acc.Watch.RateLimit
+		}
+		if burst == 0 {
+			burst = acc.Watch.RateBurst
+		}
+	}
+	if rate == 0 {
+		rate = defaultWatchRateLimit
+	}
+	if burst <= 0 {
+		burst = defaultWatchRateBurst
+	}
+	if rate > 0 {
+		watchOpts.Throttle = email.NewThrottle(rate, burst)
+	}
+
+	// Configured notify rules, plus unfiltered ones from --notify
+	var notify []config.NotifyConfig
+	if acc.Watch != nil {
+		notify = append(notify, acc.Watch.Notify...)
+	}
+	for _, spec := range opts.notify {
+		n, err := parseNotifySpec(spec)
+		if err != nil {
+			return err
+		}
+		notify = append(notify, n)
+	}
+	for _, n := range notify {
+		notifier, err := newNotifier(acc, n)
+		if err != nil {
+			return err
+		}
+		watchOpts.Notify = append(watchOpts.Notify, email.NotifyRule{
+			Notifier: notifier,
+			From:     n.From,
+			Subject:  n.Subject,
+		})
+	}
+
+	if acc.Watch != nil {
+		for _, p := range acc.Watch.Pipelines {
+			pipeline := email.Pipeline{Name: p.Name, From: p.From, Subject: p.Subject}
+			for _, s := range p.Stages {
+				pipeline.Stages = append(pipeline.Stages, email.PipelineStage{
+					Name:    s.Name,
+					Cmd:     s.Command,
+					Shell:   s.Shell,
+					OnError: s.OnError,
+				})
+			}
+			watchOpts.Pipelines = append(watchOpts.Pipelines, pipeline)
+		}
+		if cc := acc.Watch.Commands; cc != nil {
+			commands := &email.Commands{
+				AllowedSenders:    cc.AllowedSenders,
+				AuthServID:        cc.AuthServID,
+				InsecureTrustFrom: cc.InsecureTrustFrom,
+			}
+			for _, h := range cc.Handlers {
+				commands.Commands = append(commands.Commands, email.MailCommand{Name: h.Name, Cmd: h.Command, Shell: h.Shell})
+			}
+			watchOpts.Commands = commands
+		}
+		for i, r := range acc.Watch.RateAlerts {
+			window, err := r.Duration()
+			if err != nil {
+				return fmt.Errorf("watch.rate_alerts[%d]: %w", i, err)
+			}
+			watchOpts.RateAlerts = append(watchOpts.RateAlerts, email.RateAlert{
+				Name:    r.Name,
+				By:      r.By,
+				Max:     r.Max,
+				Window:  window,
+				From:    r.From,
+				Subject: r.Subject,
+				Notify:  r.Notify,
+			})
+		}
 	}
 
-	client := email.NewIMAPClient(email.IMAPConfig{
-		Host:     acc.IMAP.Host,
-		Port:     acc.IMAP.Port,
-		Username: acc.IMAP.Username,
-		Password: acc.IMAP.Password,
-		SSL:      acc.IMAP.SSL,
-		StartTLS: acc.IMAP.StartTLS,
-	})
+	watchOpts.Scan = newScanOptions(acc)
 
-	// Set up graceful shutdown on SIGINT / SIGTERM
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	statsInterval := opts.statsInterval
+	if statsInterval == 0 && acc.Watch != nil {
+		statsInterval = acc.Watch.StatsInterval
+	}
+	if statsInterval > 0 {
+		watchOpts.Stats = publishWatchStats(cacheAccount(acc), folder)
+		watchOpts.StatsInterval = statsInterval
+	}
+	if len(watchOpts.RateAlerts) > 0 {
+		watchOpts.Flood = publishFlood(cacheAccount(acc), folder)
+	}
+
+	client, err := newAccount(acc).IMAP()
+	if err != nil {
+		return err
+	}
+
+	// Set up graceful shutdown on SIGINT / SIGTERM. Once the first signal
+	// arrives, default handling is restored so a second one exits at once
+	// instead of waiting out the handler grace period.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
 	return client.Watch(ctx, watchOpts)
 }
+
+// The counters of long-running watches are published on the default event
+// bus (~/.emx-mail/events), like the send journal, for dashboards following
+// the channel.
+const (
+	watchStatsChannel   = "watch-stats"
+	watchStatsEventType = "watch.stats"
+)
+
+// watchStatsRecord is the payload of a watch.stats event. The event
+// timestamp records when the counters were taken.
+type watchStatsRecord struct {
+	Account string `json:"account"`
+	Folder  string `json:"folder"`
+	email.WatchStats
+}
+
+// publishWatchStats returns a WatchOptions.Stats callback adding the
+// counters to the event bus. Publishing is best effort: a failure is
+// reported as a "stats" warning status line.
+func publishWatchStats(account, folder string) func(email.WatchStats) {
+	return func(s email.WatchStats) {
+		payload, err := json.Marshal(watchStatsRecord{Account: account, Folder: folder, WatchStats: s})
+		if err == nil {
+			var bus *event.Bus
+			if bus, err = event.DefaultBus(); err == nil {
+				_, err = bus.Add(watchStatsEventType, watchStatsChannel, payload)
+				bus.Close()
+			}
+		}
+		if err != nil {
+			data, _ := json.Marshal(email.WatchStatus{Type: "stats", Level: "warn", Message: fmt.Sprintf("Failed to publish watch stats: %v", err)})
+			fmt.Fprintln(os.Stderr, string(data))
+		}
+	}
+}
+
+// Rate alerts are published next to the counters, on their own channel.
+const watchAlertsChannel = "watch-alerts"
+
+// floodRecord is the payload of a mail.flood event.
+type floodRecord struct {
+	Account string `json:"account"`
+	Folder  string `json:"folder"`
+	email.Flood
+}
+
+// publishFlood returns a WatchOptions.Flood callback adding the alerts to
+// the event bus. Publishing is best effort, like publishWatchStats.
+func publishFlood(account, folder string) func(email.Flood) {
+	return func(f email.Flood) {
+		payload, err := json.Marshal(floodRecord{Account: account, Folder: folder, Flood: f})
+		if err == nil {
+			var bus *event.Bus
+			if bus, err = event.DefaultBus(); err == nil {
+				_, err = bus.Add(f.Type, watchAlertsChannel, payload)
+				bus.Close()
+			}
+		}
+		if err != nil {
+			data, _ := json.Marshal(email.WatchStatus{Type: "flood", Level: "warn", Message: fmt.Sprintf("Failed to publish flood alert: %v", err)})
+			fmt.Fprintln(os.Stderr, string(data))
+		}
+	}
+}
+
+// parseNotifySpec parses a --notify value: "desktop" or "<type>:<url>".
+func parseNotifySpec(spec string) (config.NotifyConfig, error) {
+	typ, url, _ := strings.Cut(spec, ":")
+	n := config.NotifyConfig{Type: strings.ToLower(typ), URL: url}
+	if n.Type != "desktop" && url == "" {
+		return n, fmt.Errorf("invalid --notify %q: want desktop, ntfy:<topic>, slack:<webhook>, discord:<webhook> or email:<addresses>", spec)
+	}
+	if n.Type == "email" {
+		n.To, n.URL = url, ""
+	}
+	return n, nil
+}
+
+// newRetryQueue returns the retry queue of the watched folder, with the
+// backoff of the account's watch.retry config.
+func newRetryQueue(acc *config.AccountConfig, folder string) (*email.RetryQueue, error) {
+	queue, err := email.DefaultRetryQueue(cacheAccount(acc), offlineFolder(acc, folder))
+	if err != nil {
+		return nil, err
+	}
+	queue.MaxAttempts = defaultRetryMaxAttempts
+	if acc.Watch != nil && acc.Watch.Retry != nil {
+		r := acc.Watch.Retry
+		if queue.BaseDelay, queue.MaxDelay, err = r.Delays(); err != nil {
+			return nil, fmt.Errorf("watch.retry: %w", err)
+		}
+		switch {
+		case r.MaxAttempts < 0:
+			queue.MaxAttempts = 0
+		case r.MaxAttempts > 0:
+			queue.MaxAttempts = r.MaxAttempts
+		}
+	}
+	return queue, nil
+}
+
+// printRetryQueue lists the emails in the retry queue.
+func printRetryQueue(queue *email.RetryQueue) error {
+	uidValidity, entries, err := queue.Entries()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("The retry queue is empty")
+		return nil
+	}
+	fmt.Printf("UIDVALIDITY %d\n", uidValidity)
+	for _, e := range entries {
+		next := "next " + e.Next.Local().Format("2006-01-02 15:04:05")
+		if e.GaveUp {
+			next = "given up"
+		}
+		fmt.Printf("UID %d: %d attempts, %s: %s\n", e.UID, e.Attempts, next, e.Reason)
+	}
+	return nil
+}
+
+// resetRetryQueue makes the queued email which ("all" or a UID) due now.
+func resetRetryQueue(queue *email.RetryQueue, which string) error {
+	var uids []uint32
+	if which != "all" {
+		var uid uint32
+		if _, err := fmt.Sscanf(which, "%d", &uid); err != nil || uid == 0 {
+			return fmt.Errorf("invalid --retry-reset %q: want all or a UID", which)
+		}
+		uids = append(uids, uid)
+	}
+	n, err := queue.Reset(uids...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d emails will be retried with the next check\n", n)
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// encryptedExt is appended to the name of messages saved with -encrypt.
+const encryptedExt = ".age"
+
+// parseEncrypt parses an -encrypt value, "age:<recipients file>", and
+// reads the age X25519 recipients (age1...) from the file, one per line;
+// blank lines and # comments are skipped.
+func parseEncrypt(spec string) ([]age.Recipient, error) {
+	scheme, path, ok := strings.Cut(spec, ":")
+	if !ok || scheme != "age" || path == "" {
+		return nil, fmt.Errorf("invalid -encrypt %q (want age:<recipients file>)", spec)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recipients: %w", err)
+	}
+	defer f.Close()
+	recipients, err := age.ParseRecipients(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse recipients %s: %w", path, err)
+	}
+	return recipients, nil
+}
+
+// nopWriteCloser adds a no-op Close to the file a message is written to
+// without encryption.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// messageWriter returns the writer a message is saved through: w itself,
+// or an age encryption of it to recipients. Closing it finishes the
+// encryption, but not w.
+func messageWriter(w io.Writer, recipients []age.Recipient) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nopWriteCloser{w}, nil
+	}
+	return age.Encrypt(w, recipients...)
+}
+
+// runDecrypt implements "emx-save decrypt": it decrypts the given saved
+// messages, or stdin, to stdout with the identities (AGE-SECRET-KEY-1...)
+// in the -identity file.
+func runDecrypt(args []string) {
+	identityFile := ""
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-identity", "-i":
+			if len(args) < 2 {
+				fatal("missing -identity argument value")
+			}
+			identityFile = args[1]
+			args = args[2:]
+		case "-h", "--help":
+			fatalUsage()
+		default:
+			fatal("unknown option: %s", args[0])
+		}
+	}
+	if identityFile == "" {
+		fatal("decrypt requires -identity <key file>")
+	}
+
+	f, err := os.Open(identityFile)
+	if err != nil {
+		fatal("failed to open identity: %v", err)
+	}
+	identities, err := age.ParseIdentities(f)
+	f.Close()
+	if err != nil {
+		fatal("failed to parse identity %s: %v", identityFile, err)
+	}
+
+	if len(args) == 0 {
+		if err := decryptTo(os.Stdout, os.Stdin, identities); err != nil {
+			fatal("%v", err)
+		}
+		return
+	}
+	for _, path := range args {
+		in, err := os.Open(path)
+		if err != nil {
+			fatal("%v", err)
+		}
+		err = decryptTo(os.Stdout, in, identities)
+		in.Close()
+		if err != nil {
+			fatal("%s: %v", path, err)
+		}
+	}
+}
+
+// decryptTo decrypts the age file r to w.
+func decryptTo(w io.Writer, r io.Reader, identities []age.Identity) error {
+	plain, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt: %w", err)
+	}
+	if _, err := io.Copy(w, plain); err != nil {
+		return fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return nil
+}
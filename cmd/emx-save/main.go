@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -12,18 +13,83 @@ import (
 	"regexp"
 	"strings"
 	"time"
+
+	"filippo.io/age"
+	"github.com/emx-mail/cli/pkgs/email"
+	"github.com/emx-mail/cli/pkgs/patchwork"
 )
 
 const version = "1.0.0"
 
 const maxHeaderSize = 1 << 20 // 1MB maximum header size
 
+// Naming strategies for -id-from.
+const (
+	idFromHeader = "header" // sanitized Message-ID (readable, may leak internal names)
+	idFromHash   = "hash"   // SHA-256 of the Message-ID (stable, leaks nothing)
+	idFromUUID   = "uuid"   // random UUID (no Message-ID required)
+)
+
 func main() {
+	idFrom := idFromHash
+	mboxMode := false
+	blobDir := ""
+	encrypt := ""
 	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "decrypt" {
+		runDecrypt(args[1:])
+		return
+	}
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-id-from":
+			if len(args) < 2 {
+				fatal("missing -id-from argument value")
+			}
+			idFrom = args[1]
+			args = args[2:]
+		case "-mbox":
+			mboxMode = true
+			args = args[1:]
+		case "-attachments":
+			if len(args) < 2 {
+				fatal("missing -attachments argument value")
+			}
+			blobDir = args[1]
+			args = args[2:]
+		case "-encrypt":
+			if len(args) < 2 {
+				fatal("missing -encrypt argument value")
+			}
+			encrypt = args[1]
+			args = args[2:]
+		case "-h", "--help":
+			fatalUsage()
+		default:
+			fatal("unknown option: %s", args[0])
+		}
+	}
 
-	if len(args) == 0 {
+	if len(args) != 1 {
 		fatalUsage()
 	}
+	switch idFrom {
+	case idFromHeader, idFromHash, idFromUUID:
+	default:
+		fatal("invalid -id-from %q (want header, hash or uuid)", idFrom)
+	}
+	var recipients []age.Recipient
+	if encrypt != "" {
+		if blobDir != "" {
+			// Attachments would be stored in the clear next to the message
+			fatal("-attachments cannot be combined with -encrypt")
+		}
+		var err error
+		if recipients, err = parseEncrypt(encrypt); err != nil {
+			fatal("%v", err)
+		}
+	}
 
 	dir := args[0]
 
@@ -32,17 +98,75 @@ func main() {
 		fatal("failed to create directory: %v", err)
 	}
 
-	// Stream the email from stdin:
-	//  1. Buffer the header portion (up to the first blank line) to extract
-	//     the Message-ID using net/mail which handles RFC 5322 header folding.
-	//  2. Write the headers + body to a temp file via streaming (no full
-	//     in-memory buffer), then rename to the final path.
-	//
-	// This matches the streaming contract of the watch handler pipeline:
-	// watch → OS pipe → emx-save, with bounded memory usage.
+	if mboxMode {
+		if failed := saveMbox(os.Stdin, dir, idFrom, blobDir, recipients); failed > 0 {
+			fatal("%d message(s) could not be saved", failed)
+		}
+		return
+	}
 
 	reader := bufio.NewReaderSize(os.Stdin, 64*1024) // 64KB read buffer
 
+	path, messageID, err := saveMessage(reader, dir, idFrom, recipients)
+	if err != nil {
+		fatal("%v", err)
+	}
+	extra, err := saveAttachments(path, blobDir)
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	// Output protocol: the saved path is the only line on stdout, so callers
+	// can capture it with $(emx-save ...). Status JSON goes to stderr (as per
+	// watch mode protocol).
+	fmt.Fprintf(os.Stderr, `{"type":"saved","message_id":%q,"path":%q%s}`+"\n", messageID, path, extra)
+	fmt.Println(path)
+}
+
+// saveMbox splits an mbox stream into individual .eml files, reporting one
+// status line per message on stderr and one saved path per line on stdout.
+// A message that cannot be saved is reported and skipped. Returns the
+// number of failed messages.
+func saveMbox(r io.Reader, dir, idFrom, blobDir string, recipients []age.Recipient) int {
+	index, failed := 0, 0
+	err := patchwork.WalkMbox(r, func(msg io.Reader) error {
+		index++
+		path, messageID, err := saveMessage(bufio.NewReaderSize(msg, 64*1024), dir, idFrom, recipients)
+		var extra string
+		if err == nil {
+			extra, err = saveAttachments(path, blobDir)
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, `{"type":"error","index":%d,"error":%q}`+"\n", index, err.Error())
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, `{"type":"saved","index":%d,"message_id":%q,"path":%q%s}`+"\n", index, messageID, path, extra)
+		fmt.Println(path)
+		return nil
+	})
+	if err != nil {
+		fatal("%v", err)
+	}
+	if index == 0 {
+		fatal("no messages found in mbox")
+	}
+	return failed
+}
+
+// saveMessage streams one email from reader into dir and returns the saved
+// path and the Message-ID ("" if absent and not required). With recipients
+// the file is age-encrypted to them and named .eml.age.
+//
+//  1. Buffer the header portion (up to the first blank line) to extract
+//     the Message-ID using net/mail which handles RFC 5322 header folding.
+//  2. Write the headers + body to a temp file via streaming (no full
+//     in-memory buffer), then rename to the final path.
+//
+// This matches the streaming contract of the watch handler pipeline:
+// watch → OS pipe → emx-save, with bounded memory usage. Encryption is
+// streamed too, so the plain message never reaches the disk.
+func saveMessage(reader *bufio.Reader, dir, idFrom string, recipients []age.Recipient) (string, string, error) {
 	// Read header portion by scanning until blank line (\r\n\r\n or \n\n).
 	var headerBuf []byte
 	for {
@@ -51,14 +175,14 @@ func main() {
 
 		// Check header size limit to prevent OOM on malformed input
 		if len(headerBuf) > maxHeaderSize {
-			fatal("header exceeds maximum size (%d bytes)", maxHeaderSize)
+			return "", "", fmt.Errorf("header exceeds maximum size (%d bytes)", maxHeaderSize)
 		}
 
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			fatal("failed to read stdin: %v", err)
+			return "", "", fmt.Errorf("failed to read input: %w", err)
 		}
 		// Blank line (just \n or \r\n) marks end of headers
 		trimmed := strings.TrimRight(string(line), "\r\n")
@@ -68,7 +192,7 @@ func main() {
 	}
 
 	if len(headerBuf) == 0 {
-		fatal("no email data received")
+		return "", "", fmt.Errorf("no email data received")
 	}
 
 	// Parse headers using net/mail (handles RFC 5322 folded headers correctly)
@@ -78,67 +202,154 @@ func main() {
 		messageID = msg.Header.Get("Message-ID")
 		messageID = strings.Trim(strings.TrimSpace(messageID), "<>")
 	}
-	if messageID == "" {
-		fatal("no Message-ID header found in email")
+	if messageID == "" && idFrom != idFromUUID {
+		return "", "", fmt.Errorf("no Message-ID header found in email")
 	}
 
-	// Generate filename: use hash to avoid leaking information from Message-ID
-	// Message-ID can contain internal domains or user info
-	// Format: <hash>.eml where hash uses message_id + random for uniqueness
-	b := make([]byte, 4)
-	var filename string
-	if _, err := rand.Read(b); err != nil {
-		// Fallback to timestamp if crypto/rand fails
-		filename = fmt.Sprintf("%d.eml", time.Now().UnixNano())
-	} else {
-		// Use message ID + random suffix for unique hash
-		hashInput := messageID + hex.EncodeToString(b)
-		// Use first 16 chars of hex encoding (enough for uniqueness)
-		filename = sanitizeFilename(hashInput[:16]) + ".eml"
+	ext := ".eml"
+	if len(recipients) > 0 {
+		ext += encryptedExt
 	}
-
+	filename := messageFilename(idFrom, messageID) + ext
 	path := filepath.Join(dir, filename)
 
 	// Check if file already exists — append random suffix to avoid overwrite
 	if _, err := os.Stat(path); err == nil {
-		// Hash collision or duplicate - add extra random bytes
-		extra := make([]byte, 4)
-		rand.Read(extra)
-		filename = sanitizeFilename(hex.EncodeToString(b)+hex.EncodeToString(extra)) + ".eml"
+		filename = strings.TrimSuffix(filename, ext) + "-" + randomHex(4) + ext
 		path = filepath.Join(dir, filename)
 	}
 
 	// Write to a temp file in the same directory then rename for atomicity
 	tmpFile, err := os.CreateTemp(dir, ".emx-save-*.tmp")
 	if err != nil {
-		fatal("failed to create temp file: %v", err)
+		return "", "", fmt.Errorf("failed to create temp file: %w", err)
 	}
 	tmpPath := tmpFile.Name()
 	defer os.Remove(tmpPath) // clean up on error
 
+	out, err := messageWriter(tmpFile, recipients)
+	if err != nil {
+		tmpFile.Close()
+		return "", "", fmt.Errorf("failed to start encryption: %w", err)
+	}
+
 	// Write the already-buffered header portion
-	if _, err := tmpFile.Write(headerBuf); err != nil {
+	if _, err := out.Write(headerBuf); err != nil {
 		tmpFile.Close()
-		fatal("failed to write headers: %v", err)
+		return "", "", fmt.Errorf("failed to write headers: %w", err)
 	}
 
-	// Stream the remaining body from stdin → file (no full memory buffer)
-	if _, err := io.Copy(tmpFile, reader); err != nil {
+	// Stream the remaining body from the input → file (no full memory buffer)
+	if _, err := io.Copy(out, reader); err != nil {
 		tmpFile.Close()
-		fatal("failed to write body: %v", err)
+		return "", "", fmt.Errorf("failed to write body: %w", err)
 	}
 
+	if err := out.Close(); err != nil {
+		tmpFile.Close()
+		return "", "", fmt.Errorf("failed to finish encryption: %w", err)
+	}
 	if err := tmpFile.Close(); err != nil {
-		fatal("failed to close temp file: %v", err)
+		return "", "", fmt.Errorf("failed to close temp file: %w", err)
 	}
 
 	// Atomic rename
 	if err := os.Rename(tmpPath, path); err != nil {
-		fatal("failed to rename temp file: %v", err)
+		return "", "", fmt.Errorf("failed to rename temp file: %w", err)
+	}
+
+	return path, messageID, nil
+}
+
+// saveAttachments saves the attachments of the message file at path to
+// <path without .eml>_attachments/, each linked to the blob of its content
+// in blobDir, so a file mailed many times is stored once. It returns the
+// fields the status line gains, or "" without blobDir.
+func saveAttachments(path, blobDir string) (string, error) {
+	if blobDir == "" {
+		return "", nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	msg, err := email.ParseBody(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s for attachments: %w", path, err)
+	}
+
+	dir := strings.TrimSuffix(path, ".eml") + "_attachments"
+	store := &email.BlobStore{Dir: blobDir}
+	used := make(map[string]bool)
+	saved, shared := 0, 0
+	for i, att := range msg.Attachments {
+		if att.Data == nil {
+			continue
+		}
+		if saved == 0 {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return "", fmt.Errorf("failed to create directory: %w", err)
+			}
+		}
+		existed, err := store.Save(filepath.Join(dir, attachmentName(att.Filename, i, used)), att.Data)
+		if err != nil {
+			return "", err
+		}
+		saved++
+		if existed {
+			shared++
+		}
+	}
+	return fmt.Sprintf(`,"attachments":%d,"attachments_shared":%d`, saved, shared), nil
+}
+
+// attachmentName returns a safe file name for the i-th attachment that is
+// not in used yet, and adds it to used.
+func attachmentName(filename string, i int, used map[string]bool) string {
+	name := sanitizeFilename(filepath.Base(filename))
+	if name == "" || name == "." || name == ".." {
+		name = fmt.Sprintf("attachment-%d", i+1)
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 2; used[name]; n++ {
+		name = fmt.Sprintf("%s-%d%s", base, n, ext)
+	}
+	used[name] = true
+	return name
+}
+
+// messageFilename returns the file name (without extension) for a message
+// according to the -id-from strategy.
+func messageFilename(idFrom, messageID string) string {
+	switch idFrom {
+	case idFromHeader:
+		return sanitizeFilename(messageID)
+	case idFromUUID:
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			// Fallback to timestamp if crypto/rand fails
+			return fmt.Sprintf("%d", time.Now().UnixNano())
+		}
+		b[6] = (b[6] & 0x0f) | 0x40 // version 4
+		b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+	default:
+		// Hash the Message-ID so internal domains or user info don't leak
+		// into file names; 16 hex chars are plenty for uniqueness.
+		sum := sha256.Sum256([]byte(messageID))
+		return hex.EncodeToString(sum[:8])
 	}
+}
 
-	// Write status to stderr (as per watch mode protocol)
-	fmt.Fprintf(os.Stderr, `{"type":"saved","message_id":%q,"path":%q}`+"\n", messageID, path)
+// randomHex returns n random bytes hex-encoded.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
 }
 
 // sanitizeFilename sanitizes a string for safe use as a filename
@@ -160,12 +371,18 @@ func fatalUsage() {
 	fmt.Fprintf(os.Stderr, `emx-save v%s - Save email from stdin as .eml file
 
 Usage:
-  emx-save <directory>
+  emx-save [-id-from header|hash|uuid] [-mbox] [-attachments <blob-dir>]
+           [-encrypt age:<recipients file>] <directory>
+  emx-save decrypt -identity <key file> [<file.eml.age>...]
 
 Description:
   Reads a raw RFC 5322 email from stdin and saves it as an .eml file
   in the specified directory, using a hashed filename based on Message-ID.
 
+  On success the saved path is printed as the only line on stdout, and a
+  status line {"type":"saved","message_id":...,"path":...} is written to
+  stderr, so the path can be captured by scripts that post-process the file.
+
   The email is streamed from stdin with bounded memory usage — only the
   headers are buffered in memory for Message-ID extraction; the body is
   written directly to disk.
@@ -173,12 +390,53 @@ Description:
   The filename is hashed to avoid leaking internal information from Message-ID
   (e.g., internal domain names or user identifiers).
 
+Options:
+  -id-from <strategy>  how to name the file (default hash):
+                         header  sanitized Message-ID
+                         hash    SHA-256 of the Message-ID
+                         uuid    random UUID (Message-ID not required)
+  -mbox                read an mbox stream and save each message as its
+                       own .eml file; one status line per message is
+                       written to stderr and one path per line to stdout
+  -attachments <blob-dir>
+                       also save each message's attachments to
+                       <message>_attachments/ next to the .eml; every distinct
+                       file is stored once in <blob-dir> (named by SHA-256,
+                       read-only) and hard linked there, so an archive keeps
+                       one copy of a file mailed to many recipients. The
+                       status line gains "attachments" (saved) and
+                       "attachments_shared" (already stored) counts
+  -encrypt age:<recipients file>
+                       encrypt each message with age (X25519) to the
+                       recipients in the file (age1..., one per line, as
+                       printed by age-keygen) while it is streamed to disk,
+                       so no plain copy is ever written; files are named
+                       .eml.age. Not with -attachments
+
+Decrypt:
+  emx-save decrypt -identity <key file> writes the decrypted messages, or
+  stdin, to stdout using the age identities (AGE-SECRET-KEY-1...) in the key
+  file. age -d -i <key file> decrypts them as well.
+
 Examples:
   # In watch mode
   emx-mail watch -handler "emx-save ./emails"
 
   # Standalone usage
   cat message.eml | emx-save ./saved-emails
+
+  # Archive with attachments stored once across all messages
+  emx-mail watch -handler "emx-save -attachments ./emails/.blobs ./emails"
+
+  # Split an mbox export into .eml files
+  emx-save -mbox ./saved-emails < export.mbox
+
+  # Archive encrypted at rest, and read a message back
+  emx-mail watch -handler "emx-save -encrypt age:archive.pub ./emails"
+  emx-save decrypt -identity archive.key ./emails/0123456789abcdef.eml.age
+
+  # Save then post-process the saved file
+  path=$(emx-save -id-from uuid ./saved-emails < message.eml) && grep -c . "$path"
 `, version)
 	os.Exit(1)
 }
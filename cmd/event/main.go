@@ -10,6 +10,9 @@
 //	ls      list new events (based on channel marker)
 //	mark    update channel consumption position
 //	status  show event file status
+//	isolate move a channel to its own directory
+//	seen    check or record a processed key
+//	channels list channels with their lag, prune abandoned markers
 package main
 
 import (
@@ -71,6 +74,12 @@ func main() {
 		err = cmdMark(bus, args)
 	case "status":
 		err = cmdStatus(bus, args)
+	case "isolate":
+		err = cmdIsolate(bus, args)
+	case "seen":
+		err = cmdSeen(bus, args)
+	case "channels":
+		err = cmdChannels(bus, args)
 	default:
 		fatal("unknown command: %s", cmd)
 	}
@@ -82,7 +91,7 @@ func main() {
 
 func makeBus(dir string) (*event.Bus, error) {
 	if dir != "" {
-		return event.NewBus(dir), nil
+		return event.OpenBus(dir)
 	}
 	return event.DefaultBus()
 }
@@ -90,7 +99,7 @@ func makeBus(dir string) (*event.Bus, error) {
 // --- add 命令 ---
 
 func cmdAdd(bus *event.Bus, args []string) error {
-	var typ, channel, payload string
+	var typ, channel, payload, blob string
 
 	for len(args) > 0 {
 		switch args[0] {
@@ -112,13 +121,33 @@ func cmdAdd(bus *event.Bus, args []string) error {
 			}
 			payload = args[1]
 			args = args[2:]
+		case "-blob", "-b":
+			if len(args) < 2 {
+				return fmt.Errorf("missing -blob argument value")
+			}
+			blob = args[1]
+			args = args[2:]
+		case "-max-payload":
+			if len(args) < 2 {
+				return fmt.Errorf("missing -max-payload argument value")
+			}
+			n, err := parseSize(args[1])
+			if err != nil {
+				return err
+			}
+			bus.MaxPayloadSize = n
+			args = args[2:]
 		case "-h", "--help":
-			fmt.Println("Usage: emx-event add -type <type> -channel <channel> [-payload <JSON>]")
+			fmt.Println("Usage: emx-event add -type <type> -channel <channel> [-payload <JSON>|@file|-]")
 			fmt.Println("")
 			fmt.Println("Options:")
 			fmt.Println("  -type, -t       event type (required)")
 			fmt.Println("  -channel, -c    event channel (required)")
 			fmt.Println("  -payload, -p    JSON payload (optional, default null)")
+			fmt.Println("                  @file reads the payload from a file, - reads stdin")
+			fmt.Println("                  payloads over 64 KB are moved to blobs/ automatically")
+			fmt.Println("  -blob, -b       binary payload from a file, or - for stdin (stored in blobs/)")
+			fmt.Printf("  -max-payload    maximum payload size, e.g. 512K, 8M (default %s)\n", formatSize(event.DefaultMaxPayloadSize))
 			return nil
 		default:
 			return fmt.Errorf("unknown option: %s", args[0])
@@ -131,18 +160,36 @@ func cmdAdd(bus *event.Bus, args []string) error {
 	if channel == "" {
 		return fmt.Errorf("-channel is required")
 	}
+	if payload != "" && blob != "" {
+		return fmt.Errorf("-payload and -blob are mutually exclusive")
+	}
 
-	var p json.RawMessage
-	if payload != "" {
-		if !json.Valid([]byte(payload)) {
-			return fmt.Errorf("invalid JSON payload: %s", payload)
+	var evt *event.Event
+	var err error
+	switch {
+	case blob == "-":
+		evt, err = bus.AddBlob(typ, channel, os.Stdin)
+	case blob != "":
+		f, ferr := os.Open(blob)
+		if ferr != nil {
+			return fmt.Errorf("failed to open blob file: %w", ferr)
+		}
+		defer f.Close()
+		evt, err = bus.AddBlob(typ, channel, f)
+	case payload == "":
+		evt, err = bus.Add(typ, channel, json.RawMessage("null"))
+	case payload == "-":
+		evt, err = bus.AddReader(typ, channel, os.Stdin)
+	case strings.HasPrefix(payload, "@"):
+		f, ferr := os.Open(payload[1:])
+		if ferr != nil {
+			return fmt.Errorf("failed to open payload file: %w", ferr)
 		}
-		p = json.RawMessage(payload)
-	} else {
-		p = json.RawMessage("null")
+		defer f.Close()
+		evt, err = bus.AddReader(typ, channel, f)
+	default:
+		evt, err = bus.AddReader(typ, channel, strings.NewReader(payload))
 	}
-
-	evt, err := bus.Add(typ, channel, p)
 	if err != nil {
 		return err
 	}
@@ -152,7 +199,7 @@ func cmdAdd(bus *event.Bus, args []string) error {
 	fmt.Printf("  Time:      %s\n", evt.Timestamp.Format(time.RFC3339))
 	fmt.Printf("  Type:      %s\n", evt.Type)
 	fmt.Printf("  Channel:   %s\n", evt.Channel)
-	fmt.Printf("  Payload:   %s\n", string(evt.Payload))
+	fmt.Printf("  Payload:   %s\n", previewPayload(evt))
 
 	return nil
 }
@@ -161,7 +208,7 @@ func cmdAdd(bus *event.Bus, args []string) error {
 
 func cmdList(bus *event.Bus, args []string) error {
 	var channel string
-	limit := 0
+	var opts event.ListOptions
 
 	for len(args) > 0 {
 		switch args[0] {
@@ -179,17 +226,45 @@ func cmdList(bus *event.Bus, args []string) error {
 			if err != nil {
 				return fmt.Errorf("invalid limit: %s", args[1])
 			}
-			limit = n
+			opts.Limit = n
+			args = args[2:]
+		case "-tail":
+			if len(args) < 2 {
+				return fmt.Errorf("missing -tail argument value")
+			}
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid tail: %s", args[1])
+			}
+			opts.Tail = n
+			args = args[2:]
+		case "-since":
+			if len(args) < 2 {
+				return fmt.Errorf("missing -since argument value")
+			}
+			t, err := parseTime(args[1])
+			if err != nil {
+				return err
+			}
+			opts.Since = t
 			args = args[2:]
+		case "-reverse", "-r":
+			opts.Reverse = true
+			args = args[1:]
 		case "-h", "--help":
-			fmt.Println("Usage: emx-event ls -channel <channel> [-limit N]")
+			fmt.Println("Usage: emx-event ls -channel <channel> [-limit N] [-tail N] [-since <time>] [-reverse]")
 			fmt.Println("")
 			fmt.Println("List new events for a channel starting from the last mark position.")
 			fmt.Println("If the channel has no marker, starts from the earliest file.")
+			fmt.Println("-tail and -since read the whole history instead; the marker is never changed.")
 			fmt.Println("")
 			fmt.Println("Options:")
 			fmt.Println("  -channel, -c    channel name (required)")
 			fmt.Println("  -limit, -n      maximum number of results")
+			fmt.Println("  -tail           only the last N events, ignoring the marker")
+			fmt.Println("  -since          only events from this time on, ignoring the marker:")
+			fmt.Println("                  2024-06-01, 2024-06-01T08:00, RFC 3339, or a duration like 24h")
+			fmt.Println("  -reverse, -r    newest first")
 			return nil
 		default:
 			return fmt.Errorf("unknown option: %s", args[0])
@@ -200,7 +275,7 @@ func cmdList(bus *event.Bus, args []string) error {
 		return fmt.Errorf("-channel is required")
 	}
 
-	entries, err := bus.List(channel, limit)
+	entries, err := bus.ListWith(channel, opts)
 	if err != nil {
 		return err
 	}
@@ -215,10 +290,7 @@ func cmdList(bus *event.Bus, args []string) error {
 	fmt.Fprintf(tw, "----\t----\t----\t----\t----\t----\n")
 
 	for i, e := range entries {
-		payloadStr := string(e.Payload)
-		if len(payloadStr) > 60 {
-			payloadStr = payloadStr[:57] + "..."
-		}
+		payloadStr := previewPayload(&e.Event)
 		pos := event.Position{File: e.File, Offset: e.Offset}
 		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n",
 			i+1,
@@ -231,8 +303,17 @@ func cmdList(bus *event.Bus, args []string) error {
 	}
 	tw.Flush()
 
+	// History listings do not start at the marker, so marking from them
+	// could move it backwards
+	if opts.Tail > 0 || !opts.Since.IsZero() {
+		return nil
+	}
+
 	// 打印最后的位置，方便 mark
 	last := entries[len(entries)-1]
+	if opts.Reverse {
+		last = entries[0]
+	}
 	fmt.Printf("\nLatest position: %s\n", event.Position{File: last.File, Offset: last.Offset}.String())
 	fmt.Printf("Use emx-event mark -channel %s %s to update consumption position\n", channel,
 		event.Position{File: last.File, Offset: last.Offset}.String())
@@ -348,6 +429,16 @@ func cmdStatus(bus *event.Bus, args []string) error {
 		tw.Flush()
 	}
 
+	// 显示隔离的 channel
+	isolated, err := bus.IsolatedChannels()
+	if err == nil && len(isolated) > 0 {
+		fmt.Println()
+		fmt.Println("Isolated channels:")
+		for _, ch := range isolated {
+			fmt.Printf("  %s\n", ch)
+		}
+	}
+
 	// 显示所有文件列表
 	files, err := bus.ListFiles()
 	if err == nil && len(files) > 1 {
@@ -365,6 +456,194 @@ func cmdStatus(bus *event.Bus, args []string) error {
 	return nil
 }
 
+// --- isolate 命令 ---
+
+func cmdIsolate(bus *event.Bus, args []string) error {
+	var channel string
+	var perm os.FileMode
+
+	for len(args) > 0 {
+		switch args[0] {
+		case "-channel", "-c":
+			if len(args) < 2 {
+				return fmt.Errorf("missing -channel argument value")
+			}
+			channel = args[1]
+			args = args[2:]
+		case "-mode":
+			if len(args) < 2 {
+				return fmt.Errorf("missing -mode argument value")
+			}
+			m, err := strconv.ParseUint(args[1], 8, 32)
+			if err != nil || m > 0o777 {
+				return fmt.Errorf("invalid mode: %s", args[1])
+			}
+			perm = os.FileMode(m)
+			args = args[2:]
+		case "-h", "--help":
+			fmt.Println("Usage: emx-event isolate -channel <channel> [-mode 0700]")
+			fmt.Println("")
+			fmt.Println("Move a channel to its own directory (channels/<channel>/).")
+			fmt.Println("New events of the channel are written there, and ls for the channel")
+			fmt.Println("reads only that directory. Existing events are copied out of the shared")
+			fmt.Println("files and the channel marker is translated to the new files.")
+			fmt.Println("")
+			fmt.Println("Options:")
+			fmt.Println("  -channel, -c    channel name (required)")
+			fmt.Println("  -mode           permissions of the channel directory (octal, default 0755)")
+			return nil
+		default:
+			return fmt.Errorf("unknown option: %s", args[0])
+		}
+	}
+
+	if channel == "" {
+		return fmt.Errorf("-channel is required")
+	}
+
+	n, err := bus.IsolateChannel(channel, perm)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Channel isolated: %s (%d events migrated)\n", channel, n)
+	return nil
+}
+
+// --- seen 命令 ---
+
+func cmdSeen(bus *event.Bus, args []string) error {
+	var channel, key string
+	mark := false
+
+	for len(args) > 0 {
+		switch args[0] {
+		case "-channel", "-c":
+			if len(args) < 2 {
+				return fmt.Errorf("missing -channel argument value")
+			}
+			channel = args[1]
+			args = args[2:]
+		case "-mark":
+			mark = true
+			args = args[1:]
+		case "-h", "--help":
+			fmt.Println("Usage: emx-event seen -channel <channel> [-mark] <key>")
+			fmt.Println("")
+			fmt.Println("Exit 0 if the key was recorded for the channel, 1 if not.")
+			fmt.Println("With -mark, record the key once it has been processed.")
+			fmt.Println("Keys are e.g. the \"key\" of emx-mail watch emails ($EMX_KEY in handlers):")
+			fmt.Println("")
+			fmt.Println("  emx-event seen -c h \"$EMX_KEY\" || { process && emx-event seen -c h -mark \"$EMX_KEY\"; }")
+			fmt.Println("")
+			fmt.Println("Options:")
+			fmt.Println("  -channel, -c    channel name (required)")
+			fmt.Println("  -mark           record the key")
+			return nil
+		default:
+			if strings.HasPrefix(args[0], "-") {
+				return fmt.Errorf("unknown option: %s", args[0])
+			}
+			if key != "" {
+				return fmt.Errorf("unexpected argument: %s", args[0])
+			}
+			key = args[0]
+			args = args[1:]
+		}
+	}
+
+	if channel == "" {
+		return fmt.Errorf("-channel is required")
+	}
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+
+	if mark {
+		return bus.MarkSeen(channel, key)
+	}
+	seen, err := bus.Seen(channel, key)
+	if err != nil {
+		return err
+	}
+	if !seen {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// --- channels 命令 ---
+
+func cmdChannels(bus *event.Bus, args []string) error {
+	var pruneAge time.Duration
+
+	for len(args) > 0 {
+		switch args[0] {
+		case "-prune-stale":
+			if len(args) < 2 {
+				return fmt.Errorf("missing -prune-stale argument value")
+			}
+			d, err := parseAge(args[1])
+			if err != nil {
+				return err
+			}
+			pruneAge = d
+			args = args[2:]
+		case "-h", "--help":
+			fmt.Println("Usage: emx-event channels [-prune-stale <age>]")
+			fmt.Println("")
+			fmt.Println("List the channels with a marker and their lag: the number of events")
+			fmt.Println("ls would return for the channel.")
+			fmt.Println("")
+			fmt.Println("With -prune-stale, first delete the markers not updated for <age> that")
+			fmt.Println("still lag, so abandoned consumers don't hold back old events forever.")
+			fmt.Println("Caught-up markers are kept. A consumer whose marker was deleted starts")
+			fmt.Println("over from the earliest events.")
+			fmt.Println("")
+			fmt.Println("Options:")
+			fmt.Println("  -prune-stale    age of the markers to delete, e.g. 30d or 12h")
+			return nil
+		default:
+			return fmt.Errorf("unknown option: %s", args[0])
+		}
+	}
+
+	if pruneAge > 0 {
+		pruned, err := bus.PruneMarkers(pruneAge)
+		for _, st := range pruned {
+			fmt.Printf("Pruned marker: %s (%d events behind, updated %s)\n", st.Channel, st.Lag, st.Marker.UpdatedAt.Local().Format("2006-01-02 15:04"))
+		}
+		if err != nil {
+			return err
+		}
+		if len(pruned) > 0 {
+			fmt.Println()
+		}
+	}
+
+	statuses, err := bus.Channels()
+	if err != nil {
+		return err
+	}
+	if len(statuses) == 0 {
+		fmt.Println("No channels with markers")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Channel\tLag\tPosition\tUpdated\n")
+	fmt.Fprintf(tw, "-------\t---\t--------\t-------\n")
+	for _, st := range statuses {
+		fmt.Fprintf(tw, "%s\t%d\t%s:%d\t%s", st.Channel, st.Lag, st.Marker.File, st.Marker.Offset,
+			st.Marker.UpdatedAt.Local().Format("2006-01-02 15:04"))
+		if st.Isolated {
+			fmt.Fprintf(tw, "\t(isolated)")
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
 // --- 辅助函数 ---
 
 func printUsage() {
@@ -377,16 +656,34 @@ func printUsage() {
 	fmt.Println("  ls       list new events (based on channel marker)")
 	fmt.Println("  mark     update channel consumption position")
 	fmt.Println("  status   show event file status")
+	fmt.Println("  isolate  move a channel to its own directory")
+	fmt.Println("  seen     check (exit 0 if seen) or record a processed key")
+	fmt.Println("  channels list channels with their lag, prune abandoned markers")
 	fmt.Println()
 	fmt.Println("Global options:")
 	fmt.Println("  -dir     event storage directory (default ~/.emx-mail/events/)")
 	fmt.Println("  -h       show help")
 	fmt.Println()
+	fmt.Println("Storage:")
+	fmt.Println("  Events and markers are kept in gzip files in the directory. A store.json there")
+	fmt.Println("  selects another backend built into the binary, e.g.")
+	fmt.Println("  {\"backend\": \"sqlite\", \"dsn\": \"/srv/emx/events.db\"}; status and isolate")
+	fmt.Println("  only work with the file store.")
+	fmt.Println("  Files rotate at 64 MB; {\"rotate\": \"hourly\"}, \"daily\" or \"max-age=6h\" in store.json")
+	fmt.Println("  also starts a new file at each hour, day or age boundary.")
+	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  emx-event add -type email.received -channel inbox -payload '{\"from\":\"alice@test.com\"}'")
+	fmt.Println("  emx-event add -type email.received -channel inbox -payload @event.json")
 	fmt.Println("  emx-event ls -channel inbox")
+	fmt.Println("  emx-event ls -channel inbox -tail 20 -reverse")
+	fmt.Println("  emx-event ls -channel inbox -since 2024-06-01T00:00")
 	fmt.Println("  emx-event mark -channel inbox events.001.jsonl.gz:2048")
 	fmt.Println("  emx-event status")
+	fmt.Println("  emx-event isolate -channel inbox -mode 0700")
+	fmt.Println("  emx-event seen -channel inbox \"$EMX_KEY\" || handle-email")
+	fmt.Println("  emx-event seen -channel inbox -mark \"$EMX_KEY\"")
+	fmt.Println("  emx-event channels -prune-stale 30d")
 }
 
 func fatal(format string, args ...interface{}) {
@@ -394,6 +691,66 @@ func fatal(format string, args ...interface{}) {
 	os.Exit(1)
 }
 
+// previewPayload shortens a payload for single-line display.
+func previewPayload(evt *event.Event) string {
+	if evt.Blob != nil {
+		return fmt.Sprintf("blob:%s (%s)", evt.Blob.SHA256[:12], formatSize(evt.Blob.Size))
+	}
+	s := string(evt.Payload)
+	if len(s) > 60 {
+		s = s[:57] + "..."
+	}
+	return s
+}
+
+// parseTime parses a -since value: a date, a date and time in local time,
+// RFC 3339, or a duration back from now.
+func parseTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: want 2024-06-01, 2024-06-01T08:00, RFC 3339 or a duration like 24h", s)
+}
+
+// parseAge parses a -prune-stale value: a number of days such as "30d",
+// or a duration such as "12h".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid age %q: want a number of days like 30d or a duration like 12h", s)
+}
+
+// parseSize parses a byte size such as "4096", "512K" or "8M".
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	num := strings.ToUpper(strings.TrimSpace(s))
+	num = strings.TrimSuffix(num, "B")
+	switch {
+	case strings.HasSuffix(num, "K"):
+		mult, num = 1024, strings.TrimSuffix(num, "K")
+	case strings.HasSuffix(num, "M"):
+		mult, num = 1024*1024, strings.TrimSuffix(num, "M")
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return n * mult, nil
+}
+
 func formatSize(bytes int64) string {
 	const (
 		KB = 1024
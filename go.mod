@@ -1,8 +1,9 @@
 module github.com/emx-mail/cli
 
-go 1.21.0
+go 1.22
 
 require (
+	filippo.io/age v1.2.1
 	github.com/emersion/go-imap/v2 v2.0.0-beta.8
 	github.com/emersion/go-mbox v1.0.4
 	github.com/emersion/go-message v0.18.2
@@ -10,4 +11,13 @@ require (
 	github.com/emersion/go-smtp v0.23.0
 )
 
-require github.com/spf13/pflag v1.0.10
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/spf13/pflag v1.0.10
+	golang.org/x/sys v0.21.0
+)
+
+require (
+	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+)
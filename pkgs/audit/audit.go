@@ -0,0 +1,124 @@
+// Package audit keeps a tamper-evident log of the changes emx-mail makes to
+// mailboxes: flags stored, messages deleted, expunged and moved.
+//
+// Records are events on the "audit" channel of the event bus
+// (~/.emx-mail/events), so emx-event can follow or isolate the channel like
+// any other. Each record carries the SHA-256 of the record before it:
+// editing, inserting or removing a record breaks the chain at the next one,
+// which Read reports. Cutting records off the end leaves the chain intact;
+// keep the head hash somewhere else to detect that.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/emx-mail/cli/pkgs/event"
+)
+
+const (
+	Channel   = "audit"
+	EventType = "mail.audit"
+)
+
+// Record describes one mailbox change.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Account   string    `json:"account"`
+	Op        string    `json:"op"` // "flags", "delete" (\Deleted added), "expunge", "move" or "annotate"
+	Folder    string    `json:"folder"`
+	UID       uint32    `json:"uid,omitempty"`
+	MessageID string    `json:"message_id,omitempty"`
+	Dest      string    `json:"dest,omitempty"`   // "move": destination folder
+	Add       []string  `json:"add,omitempty"`    // "flags": flags added; "annotate": annotations set
+	Remove    []string  `json:"remove,omitempty"` // "flags": flags removed; "annotate": annotations removed
+	Args      []string  `json:"args"`             // Command line of the emx-mail run
+	Prev      string    `json:"prev"`             // Hash of the previous record; "" for the first
+}
+
+// Entry is a record read back from the log.
+type Entry struct {
+	Record
+	Hash   string `json:"hash"`   // Hash of this record, the next record's Prev
+	Intact bool   `json:"intact"` // Prev matches the record before, and the payload is a valid record
+}
+
+// Log appends to and reads the audit channel of an event bus.
+type Log struct {
+	bus *event.Bus
+}
+
+// New creates a Log on bus.
+func New(bus *event.Bus) *Log {
+	return &Log{bus: bus}
+}
+
+// Default creates a Log on the default event bus.
+func Default() (*Log, error) {
+	bus, err := event.DefaultBus()
+	if err != nil {
+		return nil, err
+	}
+	return New(bus), nil
+}
+
+// Close closes the events file the bus of the log keeps open, see
+// event.Bus.Close.
+func (l *Log) Close() error {
+	return l.bus.Close()
+}
+
+// Append adds rec to the log, linking it to the last record. rec.Prev is
+// set by Append, and rec.Time if zero.
+func (l *Log) Append(rec Record) error {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now().UTC()
+	}
+	_, err := l.bus.AddLinked(EventType, Channel, func(last *event.Event) (json.RawMessage, error) {
+		rec.Prev = ""
+		if last != nil {
+			payload, err := l.bus.ResolvePayload(last)
+			if err != nil {
+				return nil, err
+			}
+			rec.Prev = hashPayload(payload)
+		}
+		return json.Marshal(rec)
+	})
+	return err
+}
+
+// Read returns every record of the log in order and checks the chain.
+// Events on the channel that are not valid records are returned as
+// entries that are not intact, since they break the chain too.
+func (l *Log) Read() ([]Entry, error) {
+	events, err := l.bus.ListFrom(Channel, event.Position{}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	prev := ""
+	for _, evt := range events {
+		if evt.Channel != Channel {
+			continue
+		}
+		payload, err := l.bus.ResolvePayload(&evt.Event)
+		if err != nil {
+			return nil, err
+		}
+		var e Entry
+		e.Intact = evt.Type == EventType && json.Unmarshal(payload, &e.Record) == nil && e.Prev == prev
+		e.Hash = hashPayload(payload)
+		entries = append(entries, e)
+		prev = e.Hash
+	}
+	return entries, nil
+}
+
+func hashPayload(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/emx-mail/cli/pkgs/event"
+)
+
+func TestAppendRead(t *testing.T) {
+	bus := event.NewBus(t.TempDir())
+	log := New(bus)
+
+	for _, rec := range []Record{
+		{Account: "work", Op: "flags", Folder: "INBOX", UID: 1, Add: []string{`\Seen`}},
+		{Account: "work", Op: "delete", Folder: "INBOX", UID: 2, MessageID: "a@example.com"},
+		{Account: "work", Op: "expunge", Folder: "INBOX", UID: 2},
+	} {
+		if err := log.Append(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bus.Add("other", "other-channel", json.RawMessage(`{}`))
+
+	entries, err := log.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("len(entries) = %d, want 3", len(entries))
+	}
+	for i, e := range entries {
+		if !e.Intact {
+			t.Errorf("entry %d not intact: %+v", i, e)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("entry %d has no time", i)
+		}
+	}
+	if entries[0].Prev != "" || entries[1].Prev != entries[0].Hash || entries[2].Prev != entries[1].Hash {
+		t.Error("records are not chained")
+	}
+	if entries[1].MessageID != "a@example.com" {
+		t.Errorf("MessageID = %q", entries[1].MessageID)
+	}
+}
+
+func TestReadDetectsForeignEvent(t *testing.T) {
+	bus := event.NewBus(t.TempDir())
+	log := New(bus)
+
+	log.Append(Record{Op: "flags", Folder: "INBOX", UID: 1})
+	// An event written around Append, e.g. with emx-event add
+	bus.Add(EventType, Channel, json.RawMessage(`{"op":"flags","prev":"forged"}`))
+	log.Append(Record{Op: "flags", Folder: "INBOX", UID: 2})
+
+	entries, err := log.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("len(entries) = %d, want 3", len(entries))
+	}
+	if !entries[0].Intact || entries[1].Intact {
+		t.Errorf("intact = %v, %v; want true, false", entries[0].Intact, entries[1].Intact)
+	}
+	// Append links to whatever came last, so the chain goes on from there
+	if !entries[2].Intact {
+		t.Error("record after the forged one should link to it")
+	}
+}
@@ -0,0 +1,236 @@
+// Package cache keeps a local copy of mailbox state for offline use.
+//
+// For each account and folder the cache records the flags of every message
+// as last synced with the server, plus flag changes made locally that have
+// not been pushed yet. Default storage directory is ~/.emx-mail/cache/.
+//
+// Directory structure:
+//
+//	~/.emx-mail/cache/
+//	└── <account>/                 # Path-escaped account name
+//	    ├── INBOX.json             # One file per folder, path-escaped
+//	    └── Archive%2F2024.json
+//
+// Threads, kept separately in ~/.emx-mail/threads/<account>.json, maps the
+// Message-IDs of messages emx-mail sent or looked up to what a reply to them
+// needs.
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Store reads and writes cached folders below Dir.
+type Store struct {
+	Dir string
+}
+
+// New creates a Store using the specified directory.
+func New(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+// Default creates a Store using the default path (~/.emx-mail/cache/).
+func Default() (*Store, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return New(filepath.Join(home, ".emx-mail", "cache")), nil
+}
+
+// Folder is the cached state of one server folder.
+type Folder struct {
+	Name        string              `json:"name"`         // Server folder name
+	UIDValidity uint32              `json:"uid_validity"` // UIDs are only valid together with this value
+	Synced      time.Time           `json:"synced"`       // Last sync with the server
+	Messages    map[uint32]*Message `json:"messages"`     // By UID
+}
+
+// Message is the cached flag state of one message.
+type Message struct {
+	Flags   []string  `json:"flags"`             // Flags on the server at the last sync: the merge base
+	Local   []string  `json:"local,omitempty"`   // Flags after local changes; nil if none are pending
+	Changed time.Time `json:"changed,omitempty"` // When the pending local change was made
+}
+
+// Pending reports whether the message has local changes not yet pushed.
+func (m *Message) Pending() bool {
+	return m.Local != nil
+}
+
+// Current returns the flags as the user sees them: the local flags if
+// changes are pending, the server flags otherwise.
+func (m *Message) Current() []string {
+	if m.Pending() {
+		return m.Local
+	}
+	return m.Flags
+}
+
+func (s *Store) path(account, folder string) string {
+	return filepath.Join(s.Dir, url.PathEscape(account), url.PathEscape(folder)+".json")
+}
+
+// Load returns the cached folder, or an empty one if nothing is cached yet.
+func (s *Store) Load(account, folder string) (*Folder, error) {
+	f := &Folder{Name: folder, Messages: make(map[uint32]*Message)}
+	data, err := os.ReadFile(s.path(account, folder))
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("corrupt cache for %s: %w", folder, err)
+	}
+	if f.Messages == nil {
+		f.Messages = make(map[uint32]*Message)
+	}
+	return f, nil
+}
+
+// Save writes the folder atomically: a crash leaves the old or the new
+// state, never a truncated file.
+func (s *Store) Save(account string, f *Folder) error {
+	path := s.path(account, f.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data)
+}
+
+// writeFile replaces path with data atomically.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// SetFlags records a local change to a cached message.
+func (f *Folder) SetFlags(uid uint32, add, remove []string, now time.Time) error {
+	m, ok := f.Messages[uid]
+	if !ok {
+		return fmt.Errorf("UID %d is not cached for %s; run sync first", uid, f.Name)
+	}
+	flags := applyDelta(m.Current(), add, remove)
+	if sameFlags(flags, m.Flags) {
+		// Back to the server state: nothing left to push
+		m.Local, m.Changed = nil, time.Time{}
+		return nil
+	}
+	m.Local, m.Changed = flags, now
+	return nil
+}
+
+// Policy decides between local flag changes and changes made on the
+// server to the same message since the last sync.
+type Policy string
+
+const (
+	// LastWriterWins applies the local changes on top of the server's
+	// current flags: the local change, made after the last sync, is taken
+	// as the later write. Server changes to other flags are kept.
+	LastWriterWins Policy = "last-writer-wins"
+
+	// ServerWins keeps the server's flags for a message changed on the
+	// server since the last sync and drops its local changes.
+	ServerWins Policy = "server-wins"
+)
+
+// ParsePolicy parses a policy name; "" selects LastWriterWins.
+func ParsePolicy(s string) (Policy, error) {
+	switch Policy(s) {
+	case "", LastWriterWins:
+		return LastWriterWins, nil
+	case ServerWins:
+		return ServerWins, nil
+	}
+	return "", fmt.Errorf("unknown policy %q (want %s or %s)", s, LastWriterWins, ServerWins)
+}
+
+// Resolve merges the pending local changes of m with server, the
+// message's current flags on the server. conflict is set if the server
+// flags changed since the last sync as well.
+func (m *Message) Resolve(server []string, p Policy) (flags []string, conflict bool) {
+	conflict = !sameFlags(server, m.Flags)
+	if !m.Pending() || (conflict && p == ServerWins) {
+		return normalize(server), conflict
+	}
+	add, remove := Diff(m.Flags, m.Local)
+	return applyDelta(server, add, remove), conflict
+}
+
+// Diff returns the flags to add to and remove from from to get to.
+func Diff(from, to []string) (add, remove []string) {
+	in := func(list []string, f string) bool {
+		for _, g := range list {
+			if g == f {
+				return true
+			}
+		}
+		return false
+	}
+	for _, f := range normalize(to) {
+		if !in(from, f) {
+			add = append(add, f)
+		}
+	}
+	for _, f := range normalize(from) {
+		if !in(to, f) {
+			remove = append(remove, f)
+		}
+	}
+	return add, remove
+}
+
+func applyDelta(flags, add, remove []string) []string {
+	set := make(map[string]bool, len(flags)+len(add))
+	for _, f := range flags {
+		set[f] = true
+	}
+	for _, f := range add {
+		set[f] = true
+	}
+	for _, f := range remove {
+		delete(set, f)
+	}
+	out := make([]string, 0, len(set))
+	for f := range set {
+		out = append(out, f)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// normalize returns a sorted copy of flags without duplicates.
+func normalize(flags []string) []string {
+	return applyDelta(flags, nil, nil)
+}
+
+func sameFlags(a, b []string) bool {
+	add, remove := Diff(a, b)
+	return len(add) == 0 && len(remove) == 0
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreSaveLoad(t *testing.T) {
+	s := New(t.TempDir())
+
+	f, err := s.Load("me@example.com", "Archive/2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Messages) != 0 || f.UIDValidity != 0 {
+		t.Fatalf("missing cache should load empty, got %+v", f)
+	}
+
+	f.UIDValidity = 7
+	f.Messages[1] = &Message{Flags: []string{`\Seen`}}
+	f.Messages[2] = &Message{Flags: []string{}, Local: []string{`\Flagged`}, Changed: time.Unix(100, 0)}
+	if err := s.Save("me@example.com", f); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Load("me@example.com", "Archive/2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Archive/2024" || got.UIDValidity != 7 || len(got.Messages) != 2 {
+		t.Fatalf("loaded %+v", got)
+	}
+	if !got.Messages[2].Pending() || got.Messages[1].Pending() {
+		t.Errorf("pending state not kept: %+v %+v", got.Messages[1], got.Messages[2])
+	}
+}
+
+func TestSetFlags(t *testing.T) {
+	f := &Folder{Name: "INBOX", Messages: map[uint32]*Message{
+		1: {Flags: []string{`\Seen`}},
+	}}
+	now := time.Unix(100, 0)
+
+	if err := f.SetFlags(1, []string{`\Flagged`}, []string{`\Seen`}, now); err != nil {
+		t.Fatal(err)
+	}
+	m := f.Messages[1]
+	if !reflect.DeepEqual(m.Local, []string{`\Flagged`}) || !m.Changed.Equal(now) {
+		t.Fatalf("after change: %+v", m)
+	}
+
+	// Undoing the change leaves nothing to push
+	if err := f.SetFlags(1, []string{`\Seen`}, []string{`\Flagged`}, now); err != nil {
+		t.Fatal(err)
+	}
+	if m.Pending() {
+		t.Errorf("change back to server flags still pending: %+v", m)
+	}
+
+	if err := f.SetFlags(2, []string{`\Seen`}, nil, now); err == nil || !strings.Contains(err.Error(), "run sync") {
+		t.Errorf("uncached UID: err = %v", err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	// Synced as \Seen; locally flagged and marked unread
+	m := &Message{Flags: []string{`\Seen`}, Local: []string{`\Flagged`}}
+
+	tests := []struct {
+		name     string
+		server   []string
+		policy   Policy
+		want     []string
+		conflict bool
+	}{
+		{"unchanged on server", []string{`\Seen`}, LastWriterWins, []string{`\Flagged`}, false},
+		{"unchanged on server, server-wins", []string{`\Seen`}, ServerWins, []string{`\Flagged`}, false},
+		{"answered elsewhere", []string{`\Answered`, `\Seen`}, LastWriterWins, []string{`\Answered`, `\Flagged`}, true},
+		{"answered elsewhere, server-wins", []string{`\Answered`, `\Seen`}, ServerWins, []string{`\Answered`, `\Seen`}, true},
+		{"deleted elsewhere", []string{`\Deleted`, `\Seen`}, LastWriterWins, []string{`\Deleted`, `\Flagged`}, true},
+	}
+	for _, tt := range tests {
+		got, conflict := m.Resolve(tt.server, tt.policy)
+		if !reflect.DeepEqual(got, tt.want) || conflict != tt.conflict {
+			t.Errorf("%s: got %v, %v; want %v, %v", tt.name, got, conflict, tt.want, tt.conflict)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	add, remove := Diff([]string{`\Seen`, "$Work"}, []string{"$Work", `\Flagged`, `\Flagged`})
+	if !reflect.DeepEqual(add, []string{`\Flagged`}) || !reflect.DeepEqual(remove, []string{`\Seen`}) {
+		t.Errorf("Diff = %v, %v", add, remove)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	if p, err := ParsePolicy(""); err != nil || p != LastWriterWins {
+		t.Errorf(`ParsePolicy("") = %q, %v`, p, err)
+	}
+	if p, err := ParsePolicy("server-wins"); err != nil || p != ServerWins {
+		t.Errorf(`ParsePolicy("server-wins") = %q, %v`, p, err)
+	}
+	if _, err := ParsePolicy("client-wins"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Defaults of Threads.
+const (
+	DefaultThreadTTL  = 90 * 24 * time.Hour
+	defaultMaxThreads = 5000
+)
+
+// Threads is a small index of the messages an account sent or looked up,
+// by Message-ID, with their subject, References and where they were found,
+// so a reply to them is threaded without searching the server. Entries
+// expire TTL after they were recorded. The index is a cache: concurrent
+// writers may lose each other's entries, and a miss means searching the
+// server as before.
+type Threads struct {
+	Path string
+	TTL  time.Duration // Default DefaultThreadTTL
+}
+
+// ThreadEntry is a message in Threads. Folder, UIDValidity and UID are
+// empty for a message that was sent but not found on the server.
+type ThreadEntry struct {
+	MessageID   string    `json:"message_id"` // Without angle brackets
+	Subject     string    `json:"subject"`
+	References  []string  `json:"references,omitempty"`
+	Folder      string    `json:"folder,omitempty"`
+	UIDValidity uint32    `json:"uid_validity,omitempty"`
+	UID         uint32    `json:"uid,omitempty"`
+	Recorded    time.Time `json:"recorded"`
+}
+
+// DefaultThreads returns the thread index of account at the default path
+// (~/.emx-mail/threads/<account>.json).
+func DefaultThreads(account string) (*Threads, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return &Threads{Path: filepath.Join(home, ".emx-mail", "threads", url.PathEscape(account)+".json")}, nil
+}
+
+// Lookup returns the unexpired entry of the message with Message-ID id.
+func (t *Threads) Lookup(id string, now time.Time) (ThreadEntry, bool, error) {
+	entries, err := t.load()
+	if err != nil {
+		return ThreadEntry{}, false, err
+	}
+	e, ok := entries[normalizeMsgID(id)]
+	if !ok || t.expired(e, now) {
+		return ThreadEntry{}, false, nil
+	}
+	return e, true, nil
+}
+
+// Record adds or replaces the entries of messages as recorded at now, and
+// drops the expired ones. Past defaultMaxThreads entries the oldest go
+// first.
+func (t *Threads) Record(now time.Time, messages ...ThreadEntry) error {
+	entries, err := t.load()
+	if err != nil {
+		return err
+	}
+	for _, e := range messages {
+		e.MessageID = normalizeMsgID(e.MessageID)
+		if e.MessageID == "" {
+			continue
+		}
+		e.Recorded = now.UTC()
+		entries[e.MessageID] = e
+	}
+	for id, e := range entries {
+		if t.expired(e, now) {
+			delete(entries, id)
+		}
+	}
+	if len(entries) > defaultMaxThreads {
+		ids := make([]string, 0, len(entries))
+		for id := range entries {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return entries[ids[i]].Recorded.Before(entries[ids[j]].Recorded) })
+		for _, id := range ids[:len(ids)-defaultMaxThreads] {
+			delete(entries, id)
+		}
+	}
+	return t.save(entries)
+}
+
+func (t *Threads) expired(e ThreadEntry, now time.Time) bool {
+	ttl := t.TTL
+	if ttl <= 0 {
+		ttl = DefaultThreadTTL
+	}
+	return now.Sub(e.Recorded) > ttl
+}
+
+func (t *Threads) load() (map[string]ThreadEntry, error) {
+	entries := make(map[string]ThreadEntry)
+	data, err := os.ReadFile(t.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []ThreadEntry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("corrupt thread index %s: %w", t.Path, err)
+	}
+	for _, e := range list {
+		entries[e.MessageID] = e
+	}
+	return entries, nil
+}
+
+// save writes entries sorted by Message-ID, or removes the file once there
+// are none.
+func (t *Threads) save(entries map[string]ThreadEntry) error {
+	if len(entries) == 0 {
+		if err := os.Remove(t.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	list := make([]ThreadEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].MessageID < list[j].MessageID })
+	if err := os.MkdirAll(filepath.Dir(t.Path), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return writeFile(t.Path, data)
+}
+
+// normalizeMsgID returns a Message-ID without surrounding space and angle
+// brackets.
+func normalizeMsgID(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
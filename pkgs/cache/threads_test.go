@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestThreads(t *testing.T) {
+	th := &Threads{Path: filepath.Join(t.TempDir(), "threads", "me@example.com.json"), TTL: time.Hour}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, ok, err := th.Lookup("a@example.com", now); ok || err != nil {
+		t.Fatalf("Lookup() on a missing index = %v, %v", ok, err)
+	}
+	err := th.Record(now, ThreadEntry{MessageID: "<a@example.com>", Subject: "Plans", References: []string{"root@example.com"}, Folder: "Sent", UIDValidity: 7, UID: 3},
+		ThreadEntry{MessageID: "  "})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, ok, err := th.Lookup("<a@example.com>", now.Add(30*time.Minute))
+	if err != nil || !ok {
+		t.Fatalf("Lookup() = %v, %v", ok, err)
+	}
+	want := ThreadEntry{MessageID: "a@example.com", Subject: "Plans", References: []string{"root@example.com"}, Folder: "Sent", UIDValidity: 7, UID: 3, Recorded: now}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("entry = %+v, want %+v", e, want)
+	}
+
+	// Expired entries are not found, and pruned by the next Record
+	if _, ok, _ := th.Lookup("a@example.com", now.Add(2*time.Hour)); ok {
+		t.Error("expired entry found")
+	}
+	if err := th.Record(now.Add(2*time.Hour), ThreadEntry{MessageID: "b@example.com", Subject: "Re: Plans"}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := th.load()
+	if err != nil || len(entries) != 1 {
+		t.Errorf("entries after pruning = %v, %v", entries, err)
+	}
+
+	// The file goes with the last entry
+	if err := th.Record(now.Add(4 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(th.Path); !os.IsNotExist(err) {
+		t.Errorf("empty index left behind: %v", err)
+	}
+}
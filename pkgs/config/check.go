@@ -0,0 +1,144 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severities of a Problem.
+const (
+	SeverityError   = "error"   // LoadConfig fails, or the account cannot work
+	SeverityWarning = "warning" // Likely a mistake, or insecure
+)
+
+// Problem is an issue Check found in a configuration.
+type Problem struct {
+	Severity string `json:"severity"`
+	Account  string `json:"account,omitempty"` // Key of the account in accounts
+	Field    string `json:"field,omitempty"`   // Config key within the account, e.g. "smtp.port"
+	Message  string `json:"message"`
+}
+
+// String formats the problem as "warning: account work: smtp.port: message".
+func (p Problem) String() string {
+	s := p.Severity + ": "
+	if p.Account != "" {
+		s += "account " + p.Account + ": "
+	}
+	if p.Field != "" {
+		s += p.Field + ": "
+	}
+	return s + p.Message
+}
+
+// tlsPorts are the well-known ports of a protocol: implicit expects TLS
+// from the start (ssl), starttls expects a plaintext connection upgraded
+// with STARTTLS.
+type tlsPorts struct {
+	implicit, starttls int
+}
+
+// Check looks for problems in a configuration as written, before its
+// secret references are resolved (see LoadRawConfig): what Validate
+// rejects, secret references that cannot be resolved, ports that don't fit
+// the TLS settings, unencrypted connections, passwords and tokens written
+// in plaintext, email notifications of accounts without SMTP, and command
+// emails authenticated by their From address alone.
+func (c *Config) Check() []Problem {
+	var problems []Problem
+	if err := c.Validate(); err != nil {
+		problems = append(problems, Problem{Severity: SeverityError, Message: err.Error()})
+	}
+
+	for _, name := range c.AccountNames() {
+		acc := c.Accounts[name]
+		add := func(severity, field, format string, args ...interface{}) {
+			problems = append(problems, Problem{Severity: severity, Account: name, Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+
+		for _, p := range []struct {
+			name     string
+			settings ProtocolSettings
+			ports    tlsPorts
+		}{
+			{"imap", acc.IMAP, tlsPorts{993, 143}},
+			{"pop3", acc.POP3, tlsPorts{995, 110}},
+			{"smtp", acc.SMTP, tlsPorts{465, 587}},
+		} {
+			s := p.settings
+			switch {
+			case s.Host == "":
+			case s.SSL && s.StartTLS:
+				add(SeverityWarning, p.name, "both ssl and starttls are set; set only one")
+			case s.Port == p.ports.implicit && !s.SSL:
+				add(SeverityWarning, p.name+".port", "port %d expects TLS from the start; set ssl", s.Port)
+			case s.Port == p.ports.starttls && s.SSL:
+				add(SeverityWarning, p.name+".port", "port %d expects STARTTLS, not ssl; set starttls instead", s.Port)
+			case !s.SSL && !s.StartTLS && s.TLSFingerprintSHA256 != "":
+				add(SeverityWarning, p.name+".tls_fingerprint_sha256", "the pin has no effect on an unencrypted connection; set ssl or starttls")
+			case !s.SSL && !s.StartTLS && !isLoopback(s.Host):
+				add(SeverityWarning, p.name, "connection to %s is not encrypted; set ssl or starttls", s.Host)
+			}
+		}
+
+		for _, f := range acc.secretFields() {
+			value := *f.value
+			switch {
+			case value == "":
+			case isSecretReference(value):
+				if _, err := ExpandSecret(value); err != nil {
+					add(SeverityError, f.key, "%v", err)
+				}
+			case isSecretKey(f.key):
+				add(SeverityWarning, f.key, "secret is written in plaintext; use ${ENV_VAR} or @/path/to/file")
+			}
+		}
+
+		if acc.Watch != nil && acc.SMTP.Host == "" {
+			for i, n := range acc.Watch.Notify {
+				if n.Type == "email" {
+					add(SeverityWarning, fmt.Sprintf("watch.notify[%d]", i), "email notifications are sent through SMTP, which is not configured")
+				}
+			}
+		}
+		if acc.Watch != nil && acc.Watch.Commands != nil {
+			switch cc := acc.Watch.Commands; {
+			case cc.InsecureTrustFrom:
+				add(SeverityWarning, "watch.commands", "command senders are only checked by their From address, which is easily forged; set authserv_id instead of insecure_trust_from")
+			case cc.AuthServID == "":
+				add(SeverityError, "watch.commands", "authserv_id is required: the receiving server whose Authentication-Results header authenticates command senders")
+			}
+		}
+	}
+	return problems
+}
+
+// isSecretReference reports whether a credential refers to its value, see
+// ExpandSecret.
+func isSecretReference(value string) bool {
+	if strings.HasPrefix(value, "@") {
+		return !strings.HasPrefix(value, "@@")
+	}
+	return strings.Contains(value, "${")
+}
+
+// isSecretKey reports whether the config key of a credential holds a
+// secret rather than, say, a username.
+func isSecretKey(key string) bool {
+	for _, suffix := range []string{"password", "token", "secret_key"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLoopback reports whether host is the local machine, where unencrypted
+// connections are fine.
+func isLoopback(host string) bool {
+	switch strings.ToLower(host) {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
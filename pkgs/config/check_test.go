@@ -0,0 +1,77 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigCheck(t *testing.T) {
+	t.Setenv("EMX_TEST_SMTP_PASSWORD", "from-env")
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"mail": {"accounts": {
+		"work": {
+			"email": "user@example.com",
+			"imap": {"host": "imap.example.com", "port": 993, "username": "user", "password": "plain", "ssl": true},
+			"smtp": {"host": "smtp.example.com", "port": 587, "username": "user", "password": "${EMX_TEST_SMTP_PASSWORD}", "ssl": true}
+		},
+		"home": {
+			"email": "me@example.org",
+			"imap": {"host": "imap.example.org", "port": 993, "password": "${EMX_TEST_UNSET_VARIABLE}"},
+			"watch": {
+				"notify": [{"type": "email", "to": "me@example.org"}],
+				"commands": {"allowed_senders": ["@example.org"], "handlers": [{"name": "approve", "command": "approve.sh"}]}
+			}
+		},
+		"local": {
+			"email": "dev@localhost",
+			"imap": {"host": "localhost", "port": 1143, "password": "@@literal"},
+			"watch": {
+				"commands": {"allowed_senders": ["@localhost"], "insecure_trust_from": true, "handlers": [{"name": "approve", "command": "approve.sh"}]}
+			}
+		}
+	}}}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadRawConfig(path)
+	if err != nil {
+		t.Fatalf("LoadRawConfig() error: %v", err)
+	}
+	if cfg.Accounts["work"].SMTP.Password != "${EMX_TEST_SMTP_PASSWORD}" {
+		t.Errorf("LoadRawConfig() resolved the secret: %q", cfg.Accounts["work"].SMTP.Password)
+	}
+
+	type key struct{ severity, account, field string }
+	want := map[key]bool{
+		{SeverityWarning, "home", "imap.port"}:       true,
+		{SeverityError, "home", "imap.password"}:     true,
+		{SeverityWarning, "home", "watch.notify[0]"}: true,
+		{SeverityError, "home", "watch.commands"}:    true,
+		{SeverityWarning, "local", "imap.password"}:  true, // "@@literal" is plaintext too
+		{SeverityWarning, "local", "watch.commands"}: true,
+		{SeverityWarning, "work", "imap.password"}:   true,
+		{SeverityWarning, "work", "smtp.port"}:       true,
+	}
+	got := map[key]bool{}
+	for _, p := range cfg.Check() {
+		got[key{p.Severity, p.Account, p.Field}] = true
+		if !want[key{p.Severity, p.Account, p.Field}] {
+			t.Errorf("unexpected problem: %s", p)
+		}
+	}
+	for k := range want {
+		if !got[k] {
+			t.Errorf("missing problem %v", k)
+		}
+	}
+}
+
+func TestConfigCheckValidate(t *testing.T) {
+	cfg := &Config{Accounts: map[string]AccountConfig{"bad": {Email: "bad@example.com"}}}
+	problems := cfg.Check()
+	if len(problems) != 1 || problems[0].Severity != SeverityError || problems[0].Account != "" {
+		t.Errorf("Check() = %v, want the Validate error", problems)
+	}
+}
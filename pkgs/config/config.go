@@ -2,13 +2,17 @@ package config
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net/mail"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 const (
@@ -28,6 +32,47 @@ type ProtocolSettings struct {
 	SSL bool `json:"ssl"`
 	// StartTLS enables opportunistic TLS upgrade after connecting in plaintext.
 	StartTLS bool `json:"starttls"`
+
+	// MaxConnections caps the IMAP connections emx-mail keeps open to the
+	// server at once, across all commands (default 10).
+	MaxConnections int `json:"max_connections,omitempty"`
+
+	// FetchChunkSize makes IMAP fetch messages larger than this many bytes
+	// in chunks of that size, resuming from the last chunk received after
+	// a dropped connection instead of starting over. 0 fetches messages
+	// whole.
+	FetchChunkSize int64 `json:"fetch_chunk_size,omitempty"`
+
+	// AuthzID is the SASL authorization identity: log in with Username's
+	// credentials and act as this user, e.g. a shared mailbox Username is a
+	// delegate of. IMAP then authenticates with AUTHENTICATE PLAIN instead
+	// of LOGIN; POP3 doesn't support it.
+	AuthzID string `json:"authzid,omitempty"`
+
+	// TLSFingerprintSHA256 pins the server's certificate: the SHA-256 hash
+	// of its leaf certificate, in hex with or without colons (as printed by
+	// "openssl x509 -noout -fingerprint -sha256"). The certificate must
+	// also pass the standard verification unless TLSPinOnly is set, for
+	// servers with self-signed certificates.
+	TLSFingerprintSHA256 string `json:"tls_fingerprint_sha256,omitempty"`
+	TLSPinOnly           bool   `json:"tls_pin_only,omitempty"`
+}
+
+// Fingerprint returns the decoded TLSFingerprintSHA256, nil if none is set.
+func (s ProtocolSettings) Fingerprint() ([]byte, error) {
+	v := strings.TrimSpace(s.TLSFingerprintSHA256)
+	if v == "" {
+		return nil, nil
+	}
+	// openssl prints "sha256 Fingerprint=AB:CD:..."
+	if i := strings.LastIndex(v, "="); i >= 0 {
+		v = v[i+1:]
+	}
+	fp, err := hex.DecodeString(strings.ReplaceAll(v, ":", ""))
+	if err != nil || len(fp) != sha256.Size {
+		return nil, fmt.Errorf("tls_fingerprint_sha256 must be a SHA-256 hash: 64 hex digits, optionally separated by colons")
+	}
+	return fp, nil
 }
 
 // AccountConfig holds email account configuration
@@ -45,6 +90,47 @@ type AccountConfig struct {
 
 	// Watch settings
 	Watch *WatchConfig `json:"watch,omitempty"`
+
+	// Defaults for outgoing messages
+	Outgoing *OutgoingConfig `json:"outgoing,omitempty"`
+
+	// Folders maps logical folders (inbox, sent, drafts, trash, junk,
+	// archive) to the server's names, e.g. "sent": "[Gmail]/Sent Mail".
+	// Unmapped ones are found by their IMAP SPECIAL-USE attribute.
+	Folders map[string]string `json:"folders,omitempty"`
+
+	// Attachment virus scanning for watch and fetch --save-attachments
+	Scan *ScanConfig `json:"scan,omitempty"`
+
+	// Folders and messages copied by sync --all and export
+	Sync *SyncConfig `json:"sync,omitempty"`
+
+	// Delegates are the shared mailboxes the account can act as with --as
+	Delegates []DelegateConfig `json:"delegates,omitempty"`
+}
+
+// DelegateConfig is a shared mailbox an account has delegated access to.
+type DelegateConfig struct {
+	Email    string `json:"email"`               // Shared mailbox address, as given to --as
+	FromName string `json:"from_name,omitempty"` // Display name of messages sent as the mailbox
+	AuthzID  string `json:"authzid,omitempty"`   // SASL authorization identity, default Email
+
+	// OnBehalf sends "on behalf of" the mailbox: the account's own address
+	// goes in a Sender header. Otherwise messages are sent as the mailbox
+	// ("Send As"), which the server must allow.
+	OnBehalf bool `json:"on_behalf,omitempty"`
+}
+
+// folderRoles are the logical folder names accepted as AccountConfig.Folders keys.
+var folderRoles = []string{"inbox", "sent", "drafts", "trash", "junk", "archive"}
+
+func isFolderRole(name string) bool {
+	for _, r := range folderRoles {
+		if name == r {
+			return true
+		}
+	}
+	return false
 }
 
 // Domain returns the domain part of the account email address.
@@ -56,14 +142,245 @@ func (a *AccountConfig) Domain() string {
 	return "localhost"
 }
 
+// Delegate returns the account acting as the shared mailbox: its address
+// and name are the mailbox's, and IMAP and SMTP authenticate with the
+// account's credentials for the mailbox's authorization identity. It is
+// named "<account>/<mailbox>", which keeps its cache, watch state and audit
+// entries apart from the account's. The mailbox must be one of the
+// account's Delegates.
+func (a *AccountConfig) Delegate(mailbox string) (*AccountConfig, error) {
+	for _, d := range a.Delegates {
+		if !strings.EqualFold(d.Email, mailbox) {
+			continue
+		}
+		acc := *a
+		acc.Email, acc.FromName = d.Email, d.FromName
+		if a.Name != "" {
+			acc.Name = a.Name + "/" + d.Email
+		} else {
+			acc.Name = d.Email
+		}
+		authzid := d.AuthzID
+		if authzid == "" {
+			authzid = d.Email
+		}
+		acc.IMAP.AuthzID, acc.POP3.AuthzID, acc.SMTP.AuthzID = authzid, authzid, authzid
+		if d.OnBehalf {
+			out := OutgoingConfig{}
+			if a.Outgoing != nil {
+				out = *a.Outgoing
+			}
+			out.Sender = formatMailbox(a.FromName, a.Email)
+			acc.Outgoing = &out
+		}
+		return &acc, nil
+	}
+	return nil, fmt.Errorf("account %s has no delegate access to %s", a.Name, mailbox)
+}
+
+// formatMailbox returns "Name <email>", or email without a name.
+func formatMailbox(name, email string) string {
+	if name == "" {
+		return email
+	}
+	return (&mail.Address{Name: name, Address: email}).String()
+}
+
 // WatchConfig holds watch mode configuration
 type WatchConfig struct {
-	Folder        string `json:"folder,omitempty"`          // Folder to watch, default "INBOX"
+	Folder        string `json:"folder,omitempty"`          // Folder or logical folder to watch, default "inbox"
 	HandlerCmd    string `json:"handler_cmd,omitempty"`     // Handler command (e.g., "/path/to/handler --opt")
+	HandlerShell  string `json:"handler_shell,omitempty"`   // Shell running HandlerCmd: sh, cmd, powershell, pwsh or none
 	KeepAlive     int    `json:"keep_alive,omitempty"`      // Keep-alive interval in seconds, default 30 (polling mode only)
 	PollInterval  int    `json:"poll_interval,omitempty"`   // Poll interval in seconds, default 30
 	MaxRetries    int    `json:"max_retries,omitempty"`     // Max retry attempts, default 5
 	IdleKeepAlive int    `json:"idle_keep_alive,omitempty"` // IDLE keep-alive interval in seconds, default 300 (5 min)
+	ShutdownGrace int    `json:"shutdown_grace,omitempty"`  // Seconds a running handler may finish on shutdown, default 30
+	Changes       bool   `json:"changes,omitempty"`         // Also report expunges and flag changes
+	OrderByDate   bool   `json:"order_by_date,omitempty"`   // Process unseen emails by INTERNALDATE rather than UID
+	Backlog       string `json:"backlog,omitempty"`         // Unseen emails handled at startup: all (default), none or since:<duration>
+	AutoResponder bool   `json:"auto_responder,omitempty"`  // The handler sends mail in response: skip automated mail and bounces
+
+	// RateLimit caps FETCH/SEARCH commands per second while catching up on
+	// unprocessed emails, default 5; negative disables throttling.
+	// RateBurst is how many may be sent at once, default 10.
+	RateLimit float64 `json:"rate_limit,omitempty"`
+	RateBurst int     `json:"rate_burst,omitempty"`
+
+	// StatsInterval, if > 0, publishes the watch's counters as watch.stats
+	// events on the default event bus every StatsInterval seconds.
+	StatsInterval int `json:"stats_interval,omitempty"`
+
+	// Notify rules send built-in notifications for new emails
+	Notify []NotifyConfig `json:"notify,omitempty"`
+
+	// Pipelines replace HandlerCmd for the emails they match; the first
+	// matching pipeline runs
+	Pipelines []PipelineConfig `json:"pipelines,omitempty"`
+
+	// Commands turn the watched folder into an automation inbox
+	Commands *CommandsConfig `json:"commands,omitempty"`
+
+	// RateAlerts raise a mail.flood event when too many emails from one
+	// sender or with one subject arrive, e.g. from a mail loop
+	RateAlerts []RateAlertConfig `json:"rate_alerts,omitempty"`
+
+	// Retry queues the emails the handler failed on and retries them with
+	// backoff, like "watch --retry-queue"
+	Retry *RetryConfig `json:"retry,omitempty"`
+}
+
+// RetryConfig is the watch retry queue: a failed email is retried after
+// BaseDelay, doubled after each further failure up to MaxDelay, and given
+// up after MaxAttempts failures.
+type RetryConfig struct {
+	BaseDelay   string `json:"base_delay,omitempty"`   // Duration, default "1m"
+	MaxDelay    string `json:"max_delay,omitempty"`    // Duration, default "6h"
+	MaxAttempts int    `json:"max_attempts,omitempty"` // Default 10; negative retries forever
+}
+
+// Delays returns the parsed BaseDelay and MaxDelay, 0 for those unset.
+func (r RetryConfig) Delays() (base, maxDelay time.Duration, err error) {
+	parse := func(s string) (time.Duration, error) {
+		if s == "" {
+			return 0, nil
+		}
+		d, err := time.ParseDuration(s)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("delay must be positive")
+		}
+		return d, err
+	}
+	if base, err = parse(r.BaseDelay); err != nil {
+		return 0, 0, err
+	}
+	if maxDelay, err = parse(r.MaxDelay); err != nil {
+		return 0, 0, err
+	}
+	return base, maxDelay, nil
+}
+
+// RateAlertConfig is a watch rate alert: more than Max emails per sender
+// or per subject within Window raise a mail.flood event.
+type RateAlertConfig struct {
+	Name    string `json:"name,omitempty"`
+	By      string `json:"by"`                // "sender" or "subject"
+	Max     int    `json:"max"`               // Emails allowed within the window
+	Window  string `json:"window"`            // Duration, e.g. "10m"
+	From    string `json:"from,omitempty"`    // Only count emails whose sender contains this (case-insensitive)
+	Subject string `json:"subject,omitempty"` // Only count emails whose subject contains this (case-insensitive)
+	Notify  bool   `json:"notify,omitempty"`  // Also send the alert through the watch's notify rules
+}
+
+// CommandsConfig configures command emails: a directive such as "approve
+// 1234" as the subject or first line of an email from an allowed sender
+// runs the handler of that command instead of the watch handler.
+type CommandsConfig struct {
+	AllowedSenders    []string        `json:"allowed_senders"`               // Addresses or @domains that may send commands
+	AuthServID        string          `json:"authserv_id,omitempty"`         // Receiving server whose Authentication-Results must show a DKIM or DMARC pass of the sender's domain
+	InsecureTrustFrom bool            `json:"insecure_trust_from,omitempty"` // Check senders by their From address alone, which anyone can forge
+	Handlers          []CommandConfig `json:"handlers"`
+}
+
+// CommandConfig is the handler of one command.
+type CommandConfig struct {
+	Name    string `json:"name"`            // Directive word, e.g. "approve"
+	Command string `json:"command"`         // Handler command, as handler_cmd, with EMX_COMMAND and EMX_COMMAND_ARGS set
+	Shell   string `json:"shell,omitempty"` // As handler_shell
+}
+
+// Duration returns the parsed Window.
+func (r RateAlertConfig) Duration() (time.Duration, error) {
+	d, err := time.ParseDuration(r.Window)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("window must be positive")
+	}
+	return d, err
+}
+
+// PipelineConfig is an ordered chain of watch handlers, run for the new
+// emails matching its filters.
+type PipelineConfig struct {
+	Name    string        `json:"name"`
+	From    string        `json:"from,omitempty"`    // Only emails whose sender contains this (case-insensitive)
+	Subject string        `json:"subject,omitempty"` // Only emails whose subject contains this (case-insensitive)
+	Stages  []StageConfig `json:"stages"`
+}
+
+// StageConfig is one handler of a pipeline.
+type StageConfig struct {
+	Name    string `json:"name,omitempty"`
+	Command string `json:"command"`            // Handler command, as handler_cmd
+	Shell   string `json:"shell,omitempty"`    // As handler_shell
+	OnError string `json:"on_error,omitempty"` // "fail" (default), "continue" or "stop"
+}
+
+// NotifyConfig is a watch notification rule: where to notify, and optional
+// filters an email must match.
+type NotifyConfig struct {
+	Type     string `json:"type"`               // "desktop", "ntfy", "slack", "discord" or "email"
+	URL      string `json:"url,omitempty"`      // ntfy topic URL (or bare topic on ntfy.sh), or webhook URL
+	To       string `json:"to,omitempty"`       // email: recipients, comma-separated; sent through the account's SMTP server
+	Token    string `json:"token,omitempty"`    // ntfy access token
+	Priority string `json:"priority,omitempty"` // ntfy priority: min, low, default, high or urgent
+
+	From    string `json:"from,omitempty"`    // Only emails whose sender contains this (case-insensitive)
+	Subject string `json:"subject,omitempty"` // Only emails whose subject contains this (case-insensitive)
+
+	// DigestMinutes collects the matching emails for this many minutes and
+	// sends one summary with their count and subjects instead of one
+	// notification each.
+	DigestMinutes int `json:"digest_minutes,omitempty"`
+}
+
+// ScanConfig configures the attachment scanner and what happens to an email
+// with an infected attachment.
+type ScanConfig struct {
+	Command          string `json:"command"`                     // Scanner command, e.g. "clamdscan --no-summary"; exits 0 if clean, 1 if infected
+	Reject           bool   `json:"reject,omitempty"`            // Watch: fail the email instead of running the handler
+	QuarantineFolder string `json:"quarantine_folder,omitempty"` // Move infected emails to this folder (or logical folder)
+	Keyword          string `json:"keyword,omitempty"`           // Add this IMAP keyword to infected emails, e.g. "$Infected"
+}
+
+// SyncConfig selects what sync and export copy from the server. Folder
+// patterns are globs ("Archive/*") or logical folders ("sent"); a pattern
+// also covers the subfolders of the folders it matches.
+type SyncConfig struct {
+	Include    []string `json:"include,omitempty"`      // Folders to copy; default all
+	Exclude    []string `json:"exclude,omitempty"`      // Folders to skip, even if included
+	MaxAgeDays int      `json:"max_age_days,omitempty"` // Skip messages received more than this many days ago
+	MaxSize    int64    `json:"max_size,omitempty"`     // Skip messages larger than this many bytes
+}
+
+// OutgoingConfig holds defaults applied to every message sent from an account.
+// Addresses use the "Name <user@example.com>" or "user@example.com" form.
+type OutgoingConfig struct {
+	AlwaysCc  []string `json:"always_cc,omitempty"`  // Added to Cc of every message
+	AlwaysBcc []string `json:"always_bcc,omitempty"` // Added to Bcc of every message (e.g. an archive mailbox)
+	ReplyTo   string   `json:"reply_to,omitempty"`   // Default Reply-To, comma-separated
+	XMailer   string   `json:"x_mailer,omitempty"`   // X-Mailer header value
+	Sender    string   `json:"sender,omitempty"`     // Sender header, when sending on behalf of the From address
+
+	// Messages larger than MaxMessageSize bytes are rejected before sending,
+	// or have their attachments uploaded and linked when Upload is set.
+	MaxMessageSize int64         `json:"max_message_size,omitempty"`
+	Upload         *UploadConfig `json:"upload,omitempty"`
+}
+
+// UploadConfig selects where oversized attachments are uploaded.
+type UploadConfig struct {
+	Type      string `json:"type"`                 // "http" (plain PUT), "webdav" or "s3"
+	URL       string `json:"url,omitempty"`        // http/webdav: upload location; s3: endpoint (optional)
+	PublicURL string `json:"public_url,omitempty"` // Base of the download links, if different from URL
+	Username  string `json:"username,omitempty"`   // http/webdav basic auth
+	Password  string `json:"password,omitempty"`
+	Token     string `json:"token,omitempty"` // http/webdav bearer token
+
+	Region    string `json:"region,omitempty"` // s3
+	Bucket    string `json:"bucket,omitempty"` // s3
+	Prefix    string `json:"prefix,omitempty"` // s3 key prefix
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
 }
 
 // Config holds the application configuration
@@ -91,10 +408,28 @@ func HasEmxConfig() bool {
 // 1) If emx-config exists: read config from `emx-config list --json`.
 // 2) Otherwise: read config from the JSON file specified by EnvConfigJSONPath.
 func LoadConfig() (*Config, error) {
-	if HasEmxConfig() {
-		return loadFromEmxConfig()
+	data, err := readConfigData()
+	if err != nil {
+		return nil, err
+	}
+	return parseRootConfig(data)
+}
+
+// LoadRawConfig loads the configuration from path, or from where LoadConfig
+// reads it if path is empty, as written: secret references are left
+// unresolved and it is not validated. It is meant for Check.
+func LoadRawConfig(path string) (*Config, error) {
+	var data []byte
+	var err error
+	if path == "" {
+		data, err = readConfigData()
+	} else if data, err = os.ReadFile(path); err != nil {
+		err = fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err != nil {
+		return nil, err
 	}
-	return loadFromEnvJSON()
+	return unmarshalRootConfig(data)
 }
 
 // LoadConfigFile loads configuration from a JSON file path.
@@ -145,6 +480,16 @@ func DefaultConfigPath() (string, error) {
 	return filepath.Join(home, ".emx-mail", "config.json"), nil
 }
 
+// AccountNames returns the keys of the accounts, sorted.
+func (c *Config) AccountNames() []string {
+	names := make([]string, 0, len(c.Accounts))
+	for name := range c.Accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // GetAccount returns an account by name or email.
 func (c *Config) GetAccount(identifier string) (*AccountConfig, error) {
 	if c.Accounts == nil || len(c.Accounts) == 0 {
@@ -156,12 +501,7 @@ func (c *Config) GetAccount(identifier string) (*AccountConfig, error) {
 			identifier = c.DefaultAccount
 		} else {
 			// Deterministic fallback to the first key
-			keys := make([]string, 0, len(c.Accounts))
-			for k := range c.Accounts {
-				keys = append(keys, k)
-			}
-			sort.Strings(keys)
-			identifier = keys[0]
+			identifier = c.AccountNames()[0]
 		}
 	}
 
@@ -201,6 +541,48 @@ func (c *Config) Validate() error {
 		if acc.IMAP.Host == "" && acc.POP3.Host == "" {
 			return fmt.Errorf("account %s: at least one of IMAP or POP3 must be configured", acc.Name)
 		}
+
+		for role := range acc.Folders {
+			if !isFolderRole(role) {
+				return fmt.Errorf("account %s: unknown folder %q in folders (want %s)",
+					acc.Name, role, strings.Join(folderRoles, ", "))
+			}
+		}
+
+		if acc.Sync != nil && (acc.Sync.MaxAgeDays < 0 || acc.Sync.MaxSize < 0) {
+			return fmt.Errorf("account %s: sync max_age_days and max_size must not be negative", acc.Name)
+		}
+
+		for _, p := range []struct {
+			name     string
+			settings ProtocolSettings
+		}{{"imap", acc.IMAP}, {"pop3", acc.POP3}, {"smtp", acc.SMTP}} {
+			if _, err := p.settings.Fingerprint(); err != nil {
+				return fmt.Errorf("account %s: %s: %w", acc.Name, p.name, err)
+			}
+			if p.settings.TLSPinOnly && p.settings.TLSFingerprintSHA256 == "" {
+				return fmt.Errorf("account %s: %s: tls_pin_only requires tls_fingerprint_sha256", acc.Name, p.name)
+			}
+		}
+
+		for _, d := range acc.Delegates {
+			if !strings.Contains(d.Email, "@") {
+				return fmt.Errorf("account %s: delegate email %q is not an address", acc.Name, d.Email)
+			}
+		}
+
+		if acc.Watch != nil {
+			for i, r := range acc.Watch.RateAlerts {
+				if _, err := r.Duration(); err != nil || r.Max <= 0 || (r.By != "sender" && r.By != "subject") {
+					return fmt.Errorf("account %s: watch.rate_alerts[%d]: want by sender or subject, a positive max and a window such as \"10m\"", acc.Name, i)
+				}
+			}
+			if r := acc.Watch.Retry; r != nil {
+				if _, _, err := r.Delays(); err != nil {
+					return fmt.Errorf("account %s: watch.retry: want delays such as \"1m\": %w", acc.Name, err)
+				}
+			}
+		}
 	}
 
 	if c.DefaultAccount != "" {
@@ -248,15 +630,24 @@ func ExampleRootConfig() *RootConfig {
 
 // --- internal helpers ---
 
-func loadFromEnvJSON() (*Config, error) {
+// readConfigData returns the JSON configuration from emx-config if it is
+// available, or else from the file at GetEnvConfigPath.
+func readConfigData() ([]byte, error) {
+	if HasEmxConfig() {
+		return readFromEmxConfig()
+	}
 	path, err := GetEnvConfigPath()
 	if err != nil {
 		return nil, err
 	}
-	return LoadConfigFile(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return data, nil
 }
 
-func loadFromEmxConfig() (*Config, error) {
+func readFromEmxConfig() ([]byte, error) {
 	cmd := exec.Command("emx-config", "list", "--json")
 	var out bytes.Buffer
 	var errOut bytes.Buffer
@@ -271,10 +662,11 @@ func loadFromEmxConfig() (*Config, error) {
 		return nil, fmt.Errorf("emx-config list --json failed: %w", err)
 	}
 
-	return parseRootConfig(out.Bytes())
+	return out.Bytes(), nil
 }
 
-func parseRootConfig(data []byte) (*Config, error) {
+// unmarshalRootConfig parses the JSON configuration as written.
+func unmarshalRootConfig(data []byte) (*Config, error) {
 	var root RootConfig
 	if err := json.Unmarshal(data, &root); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
@@ -284,6 +676,21 @@ func parseRootConfig(data []byte) (*Config, error) {
 	if cfg.Accounts == nil {
 		return nil, fmt.Errorf("missing required key: mail.accounts")
 	}
+	return cfg, nil
+}
+
+func parseRootConfig(data []byte) (*Config, error) {
+	cfg, err := unmarshalRootConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, acc := range cfg.Accounts {
+		if err := acc.expandSecrets(); err != nil {
+			return nil, fmt.Errorf("account %s: %w", name, err)
+		}
+		cfg.Accounts[name] = acc
+	}
 
 	if err := cfg.Validate(); err != nil {
 		return nil, err
@@ -291,3 +698,106 @@ func parseRootConfig(data []byte) (*Config, error) {
 
 	return cfg, nil
 }
+
+// secretField is a credential of an account, by its config key.
+type secretField struct {
+	key   string
+	value *string
+}
+
+// secretFields returns the credentials of the account, which may be given
+// as references (see ExpandSecret).
+func (a *AccountConfig) secretFields() []secretField {
+	type field = secretField
+	fields := []field{
+		{"imap.username", &a.IMAP.Username},
+		{"imap.password", &a.IMAP.Password},
+		{"pop3.username", &a.POP3.Username},
+		{"pop3.password", &a.POP3.Password},
+		{"smtp.username", &a.SMTP.Username},
+		{"smtp.password", &a.SMTP.Password},
+	}
+	if a.Outgoing != nil && a.Outgoing.Upload != nil {
+		u := a.Outgoing.Upload
+		fields = append(fields,
+			field{"outgoing.upload.username", &u.Username},
+			field{"outgoing.upload.password", &u.Password},
+			field{"outgoing.upload.token", &u.Token},
+			field{"outgoing.upload.access_key", &u.AccessKey},
+			field{"outgoing.upload.secret_key", &u.SecretKey})
+	}
+	if a.Watch != nil {
+		for i := range a.Watch.Notify {
+			fields = append(fields,
+				field{fmt.Sprintf("watch.notify[%d].token", i), &a.Watch.Notify[i].Token},
+				field{fmt.Sprintf("watch.notify[%d].url", i), &a.Watch.Notify[i].URL})
+		}
+	}
+	return fields
+}
+
+// expandSecrets resolves the references in the account's credentials, see
+// ExpandSecret.
+func (a *AccountConfig) expandSecrets() error {
+	for _, f := range a.secretFields() {
+		value, err := ExpandSecret(*f.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.key, err)
+		}
+		*f.value = value
+	}
+	return nil
+}
+
+// ExpandSecret resolves a credential that refers to its value instead of
+// holding it, so secrets can come from the environment or from files
+// mounted by Kubernetes or Docker:
+//
+//   - "@/path/to/secret" is replaced by the content of the file, without
+//     trailing line breaks; "@@" starts a value with a literal "@".
+//   - "${NAME}" anywhere in the value is replaced by the environment
+//     variable NAME, which must be set; "$${" is a literal "${".
+//
+// Values were taken literally before references existed: a password that
+// starts with "@" or contains "${" must be escaped. The errors say so.
+func ExpandSecret(value string) (string, error) {
+	if strings.HasPrefix(value, "@@") {
+		return value[1:], nil
+	}
+	if strings.HasPrefix(value, "@") {
+		path := value[1:]
+		if path == "" {
+			return "", fmt.Errorf("empty secret file path")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret (write @@ for a value starting with a literal @): %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(value, "${")
+		if i < 0 {
+			b.WriteString(value)
+			return b.String(), nil
+		}
+		if i > 0 && value[i-1] == '$' {
+			b.WriteString(value[:i] + "{")
+			value = value[i+2:]
+			continue
+		}
+		end := strings.IndexByte(value[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in value (write $${ for a literal ${)")
+		}
+		name := value[i+2 : i+end]
+		env, ok := os.LookupEnv(name)
+		if !ok || name == "" {
+			return "", fmt.Errorf("environment variable %q is not set (write $${ for a literal ${)", name)
+		}
+		b.WriteString(value[:i] + env)
+		value = value[i+end+1:]
+	}
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandSecret(t *testing.T) {
+	t.Setenv("EMX_TEST_USER", "alice")
+	t.Setenv("EMX_TEST_EMPTY", "")
+	secret := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"${EMX_TEST_USER}", "alice"},
+		{"${EMX_TEST_USER}@example.com", "alice@example.com"},
+		{"${EMX_TEST_EMPTY}", ""},
+		{"cost$$5 $${EMX_TEST_USER}", "cost$$5 ${EMX_TEST_USER}"},
+		{"@" + secret, "s3cret"},
+		{"@@handle", "@handle"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := ExpandSecret(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ExpandSecret(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"${EMX_TEST_UNSET_VARIABLE}", "${EMX_TEST_USER", "@" + secret + ".missing", "@"} {
+		if _, err := ExpandSecret(in); err == nil {
+			t.Errorf("ExpandSecret(%q) should fail", in)
+		}
+	}
+	// A literal password of older configs is pointed to its escape
+	if _, err := ExpandSecret("@" + secret + ".missing"); err == nil || !strings.Contains(err.Error(), "@@") {
+		t.Errorf("ExpandSecret() error = %v, want a hint at @@", err)
+	}
+}
+
+func TestLoadConfigFileExpandsSecrets(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "imap-password")
+	if err := os.WriteFile(secret, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EMX_TEST_SMTP_PASSWORD", "from-env")
+
+	path := filepath.Join(dir, "config.json")
+	data := `{"mail": {"accounts": {"work": {
+		"email": "user@example.com",
+		"imap": {"host": "imap.example.com", "username": "user", "password": "@` + filepath.ToSlash(secret) + `"},
+		"smtp": {"host": "smtp.example.com", "username": "user", "password": "${EMX_TEST_SMTP_PASSWORD}"}
+	}}}}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc := cfg.Accounts["work"]
+	if acc.IMAP.Password != "from-file" || acc.SMTP.Password != "from-env" {
+		t.Errorf("passwords = %q, %q", acc.IMAP.Password, acc.SMTP.Password)
+	}
+
+	data = strings.Replace(data, "EMX_TEST_SMTP_PASSWORD", "EMX_TEST_UNSET_VARIABLE", 1)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfigFile(path); err == nil || !strings.Contains(err.Error(), "smtp.password") {
+		t.Errorf("unset variable: err = %v", err)
+	}
+}
+
+func TestAccountDelegate(t *testing.T) {
+	acc := &AccountConfig{
+		Name:     "team",
+		Email:    "agent@corp.com",
+		FromName: "Agent",
+		Delegates: []DelegateConfig{
+			{Email: "support@corp.com", FromName: "Support"},
+			{Email: "sales@corp.com", AuthzID: "sales", OnBehalf: true},
+		},
+	}
+
+	d, err := acc.Delegate("Support@corp.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "team/support@corp.com" || d.Email != "support@corp.com" || d.FromName != "Support" {
+		t.Errorf("delegate = %q %q %q", d.Name, d.Email, d.FromName)
+	}
+	if d.IMAP.AuthzID != "support@corp.com" || d.SMTP.AuthzID != "support@corp.com" {
+		t.Errorf("authzid = %q, %q", d.IMAP.AuthzID, d.SMTP.AuthzID)
+	}
+	if d.Outgoing != nil {
+		t.Errorf("Send As delegate has outgoing %+v", d.Outgoing)
+	}
+
+	d, err = acc.Delegate("sales@corp.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.SMTP.AuthzID != "sales" {
+		t.Errorf("authzid = %q, want sales", d.SMTP.AuthzID)
+	}
+	if d.Outgoing == nil || d.Outgoing.Sender != `"Agent" <agent@corp.com>` {
+		t.Errorf("on-behalf outgoing = %+v", d.Outgoing)
+	}
+	if acc.Outgoing != nil || acc.IMAP.AuthzID != "" {
+		t.Error("Delegate modified the account")
+	}
+
+	if _, err := acc.Delegate("ceo@corp.com"); err == nil {
+		t.Error("Delegate accepted a mailbox that is not a delegate")
+	}
+}
+
+func TestProtocolSettingsFingerprint(t *testing.T) {
+	const hexFP = "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8"
+	colons := "sha256 Fingerprint=5E:88:48:98:DA:28:04:71:51:D0:E5:6F:8D:C6:29:27:73:60:3D:0D:6A:AB:BD:D6:2A:11:EF:72:1D:15:42:D8"
+	for _, v := range []string{hexFP, colons} {
+		fp, err := ProtocolSettings{TLSFingerprintSHA256: v}.Fingerprint()
+		if err != nil || len(fp) != 32 || fp[0] != 0x5e || fp[31] != 0xd8 {
+			t.Errorf("Fingerprint(%q) = %x, %v", v, fp, err)
+		}
+	}
+	if fp, err := (ProtocolSettings{}).Fingerprint(); fp != nil || err != nil {
+		t.Errorf("Fingerprint() without a pin = %x, %v", fp, err)
+	}
+	if _, err := (ProtocolSettings{TLSFingerprintSHA256: hexFP[:40]}).Fingerprint(); err == nil {
+		t.Error("Fingerprint() accepted a SHA-1 length hash")
+	}
+
+	cfg := &Config{Accounts: map[string]AccountConfig{"lab": {
+		Email: "me@lab",
+		IMAP:  ProtocolSettings{Host: "lab", TLSPinOnly: true},
+	}}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted tls_pin_only without a fingerprint")
+	}
+}
+
+func TestValidateRateAlerts(t *testing.T) {
+	for _, tt := range []struct {
+		alert RateAlertConfig
+		ok    bool
+	}{
+		{RateAlertConfig{By: "sender", Max: 50, Window: "10m"}, true},
+		{RateAlertConfig{By: "subject", Max: 5, Window: "1h30m"}, true},
+		{RateAlertConfig{By: "recipient", Max: 5, Window: "10m"}, false},
+		{RateAlertConfig{By: "sender", Window: "10m"}, false},
+		{RateAlertConfig{By: "sender", Max: 5, Window: "10"}, false},
+		{RateAlertConfig{By: "sender", Max: 5, Window: "-1m"}, false},
+	} {
+		cfg := &Config{Accounts: map[string]AccountConfig{"work": {
+			Email: "me@example.com",
+			IMAP:  ProtocolSettings{Host: "imap.example.com"},
+			Watch: &WatchConfig{RateAlerts: []RateAlertConfig{tt.alert}},
+		}}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) = %v, want ok %v", tt.alert, err, tt.ok)
+		}
+	}
+}
+
+func TestValidateWatchRetry(t *testing.T) {
+	for _, tt := range []struct {
+		retry RetryConfig
+		ok    bool
+	}{
+		{RetryConfig{}, true},
+		{RetryConfig{BaseDelay: "30s", MaxDelay: "2h", MaxAttempts: 5}, true},
+		{RetryConfig{BaseDelay: "30"}, false},
+		{RetryConfig{MaxDelay: "0s"}, false},
+	} {
+		cfg := &Config{Accounts: map[string]AccountConfig{"work": {
+			Email: "me@example.com",
+			IMAP:  ProtocolSettings{Host: "imap.example.com"},
+			Watch: &WatchConfig{Retry: &tt.retry},
+		}}}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) = %v, want ok %v", tt.retry, err, tt.ok)
+		}
+	}
+}
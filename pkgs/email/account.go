@@ -0,0 +1,451 @@
+package email
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+
+	"github.com/emx-mail/cli/pkgs/config"
+)
+
+// Account is the mail clients of one configured account: IMAP or POP3 for
+// reading, SMTP for sending. Each client is created from the account's
+// config on first use and shares the account's TLS settings; Close closes
+// the ones that are connected.
+type Account struct {
+	Config *config.AccountConfig
+
+	// Protocol is the protocol used for reading, "imap" or "pop3"; empty
+	// picks one the account configures, see ReadProtocol.
+	Protocol string
+
+	// TLSConfig, if set, is used for the connections to every server of
+	// the account, with ServerName defaulting to each server's host. A
+	// server configured with tls_fingerprint_sha256 gets a copy pinning its
+	// certificate, see PinCertificate.
+	TLSConfig *tls.Config
+
+	// Limiter and Mutated are passed to the IMAP client, see IMAPConfig.
+	Limiter *ConnLimiter
+	Mutated func(Mutation)
+
+	// Dial is passed to every client: it opens the connections to the
+	// servers of the account, see IMAPConfig.Dial.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// MaxBodyBytes is passed to the IMAP and POP3 clients: fetching a
+	// message larger than this fails with ErrBodyTooLarge. 0 means no
+	// limit.
+	MaxBodyBytes int64
+
+	// FetchProgress is passed to the IMAP client, see IMAPConfig. Chunked
+	// fetches are set up by the fetch_chunk_size of the IMAP config.
+	FetchProgress func(uid uint32, done, total int64)
+
+	imap *IMAPClient
+	pop3 *POP3Client
+	smtp *SMTPClient
+}
+
+// NewAccount returns the clients of the account configured by acc.
+func NewAccount(acc *config.AccountConfig) *Account {
+	return &Account{Config: acc}
+}
+
+// ReadProtocol returns the protocol messages are read with: Protocol if
+// set, else "imap" if the account has an IMAP server, else "pop3" if it
+// has a POP3 one.
+func (a *Account) ReadProtocol() string {
+	if a.Protocol != "" {
+		return a.Protocol
+	}
+	if a.Config.IMAP.Host == "" && a.Config.POP3.Host != "" {
+		return "pop3"
+	}
+	return "imap"
+}
+
+// IMAP returns the account's IMAP client.
+func (a *Account) IMAP() (*IMAPClient, error) {
+	if a.imap != nil {
+		return a.imap, nil
+	}
+	acc := a.Config
+	if acc.IMAP.Host == "" {
+		return nil, fmt.Errorf("IMAP not configured for account %s", acc.Email)
+	}
+	tlsCfg, err := a.tlsConfig(acc.IMAP)
+	if err != nil {
+		return nil, fmt.Errorf("imap: %w", err)
+	}
+	a.imap = NewIMAPClient(IMAPConfig{
+		Host:      acc.IMAP.Host,
+		Port:      acc.IMAP.Port,
+		Username:  acc.IMAP.Username,
+		Password:  acc.IMAP.Password,
+		SSL:       acc.IMAP.SSL,
+		StartTLS:  acc.IMAP.StartTLS,
+		AuthzID:   acc.IMAP.AuthzID,
+		TLSConfig: tlsCfg,
+		Folders:   acc.Folders,
+		Limiter:   a.Limiter,
+		Mutated:   a.Mutated,
+		Dial:      a.Dial,
+
+		MaxBodyBytes:  a.MaxBodyBytes,
+		ChunkSize:     acc.IMAP.FetchChunkSize,
+		FetchProgress: a.FetchProgress,
+	})
+	return a.imap, nil
+}
+
+// POP3 returns the account's POP3 client.
+func (a *Account) POP3() (*POP3Client, error) {
+	if a.pop3 != nil {
+		return a.pop3, nil
+	}
+	acc := a.Config
+	if acc.POP3.Host == "" {
+		return nil, fmt.Errorf("POP3 not configured for account %s", acc.Email)
+	}
+	if acc.POP3.AuthzID != "" {
+		return nil, fmt.Errorf("POP3 cannot act as %s, use IMAP", acc.POP3.AuthzID)
+	}
+	tlsCfg, err := a.tlsConfig(acc.POP3)
+	if err != nil {
+		return nil, fmt.Errorf("pop3: %w", err)
+	}
+	a.pop3 = NewPOP3Client(POP3Config{
+		Host:      acc.POP3.Host,
+		Port:      acc.POP3.Port,
+		Username:  acc.POP3.Username,
+		Password:  acc.POP3.Password,
+		SSL:       acc.POP3.SSL,
+		StartTLS:  acc.POP3.StartTLS,
+		TLSConfig: tlsCfg,
+		Dial:      a.Dial,
+
+		MaxBodyBytes: a.MaxBodyBytes,
+	})
+	return a.pop3, nil
+}
+
+// SMTP returns the account's SMTP client, with the defaults of its
+// outgoing config.
+func (a *Account) SMTP() (*SMTPClient, error) {
+	if a.smtp != nil {
+		return a.smtp, nil
+	}
+	acc := a.Config
+	tlsCfg, err := a.tlsConfig(acc.SMTP)
+	if err != nil {
+		return nil, fmt.Errorf("smtp: %w", err)
+	}
+	cfg := SMTPConfig{
+		Host:      acc.SMTP.Host,
+		Port:      acc.SMTP.Port,
+		Username:  acc.SMTP.Username,
+		Password:  acc.SMTP.Password,
+		SSL:       acc.SMTP.SSL,
+		StartTLS:  acc.SMTP.StartTLS,
+		AuthzID:   acc.SMTP.AuthzID,
+		TLSConfig: tlsCfg,
+		Dial:      a.Dial,
+	}
+	if out := acc.Outgoing; out != nil {
+		for _, s := range out.AlwaysCc {
+			cfg.AlwaysCc = append(cfg.AlwaysCc, ParseAddressList(s)...)
+		}
+		for _, s := range out.AlwaysBcc {
+			cfg.AlwaysBcc = append(cfg.AlwaysBcc, ParseAddressList(s)...)
+		}
+		cfg.ReplyTo = ParseAddressList(out.ReplyTo)
+		cfg.XMailer = out.XMailer
+		if sender := ParseAddressList(out.Sender); len(sender) > 0 {
+			cfg.Sender = sender[0]
+		}
+		cfg.MaxMessageSize = out.MaxMessageSize
+		if out.Upload != nil {
+			u, err := NewUploader(out.Upload)
+			if err != nil {
+				return nil, err
+			}
+			cfg.Uploader = u
+		}
+	}
+	a.smtp = NewSMTPClient(cfg)
+	return a.smtp, nil
+}
+
+// Receiver returns the client of ReadProtocol.
+func (a *Account) Receiver() (MailReceiver, error) {
+	if a.ReadProtocol() == "pop3" {
+		return a.POP3()
+	}
+	return a.IMAP()
+}
+
+// List lists messages with the read protocol. POP3 only has the inbox and
+// no flags, so Folder and UnreadOnly are ignored there.
+func (a *Account) List(opts FetchOptions) (*ListResult, error) {
+	if a.ReadProtocol() == "pop3" {
+		opts.Folder = "INBOX"
+		opts.UnreadOnly = false
+	}
+	r, err := a.Receiver()
+	if err != nil {
+		return nil, err
+	}
+	return r.FetchMessages(opts)
+}
+
+// Fetch fetches a message by UID, or by message number with POP3, which
+// ignores folder.
+func (a *Account) Fetch(folder string, uid uint32) (*Message, error) {
+	r, err := a.Receiver()
+	if err != nil {
+		return nil, err
+	}
+	return r.FetchMessageByID(folder, uid)
+}
+
+// FetchRaw fetches the source of a message like Fetch.
+func (a *Account) FetchRaw(folder string, uid uint32) ([]byte, error) {
+	if a.ReadProtocol() == "pop3" {
+		c, err := a.POP3()
+		if err != nil {
+			return nil, err
+		}
+		return c.FetchRawMessage(uid)
+	}
+	c, err := a.IMAP()
+	if err != nil {
+		return nil, err
+	}
+	return c.FetchRawMessage(folder, uid)
+}
+
+// FetchStream reads message uid of folder with the protocol of the
+// account, passing fn a MessageStream of its parts; see
+// IMAPClient.FetchMessageStream.
+func (a *Account) FetchStream(folder string, uid uint32, fn func(*MessageStream) error) error {
+	if a.ReadProtocol() == "pop3" {
+		c, err := a.POP3()
+		if err != nil {
+			return err
+		}
+		return c.FetchMessageStream(uid, fn)
+	}
+	c, err := a.IMAP()
+	if err != nil {
+		return err
+	}
+	return c.FetchMessageStream(folder, uid, fn)
+}
+
+// Send sends a message through the account's SMTP server.
+func (a *Account) Send(opts SendOptions) error {
+	c, err := a.SMTP()
+	if err != nil {
+		return err
+	}
+	return c.Send(opts)
+}
+
+// Move moves a message to another folder. It needs IMAP.
+func (a *Account) Move(folder string, uid uint32, dest string) error {
+	c, err := a.IMAP()
+	if err != nil {
+		return err
+	}
+	return c.MoveMessage(folder, uid, dest)
+}
+
+// Close closes the connections of the clients.
+func (a *Account) Close() error {
+	var errs []error
+	if a.imap != nil {
+		errs = append(errs, a.imap.Close())
+	}
+	if a.pop3 != nil {
+		errs = append(errs, a.pop3.Close())
+	}
+	if a.smtp != nil {
+		errs = append(errs, a.smtp.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// NewUploader returns the uploader for oversized attachments configured by
+// up.
+func NewUploader(up *config.UploadConfig) (Uploader, error) {
+	switch up.Type {
+	case "http", "webdav":
+		if up.URL == "" {
+			return nil, fmt.Errorf("outgoing.upload: url is required for type %q", up.Type)
+		}
+		return &HTTPPutUploader{
+			BaseURL:   up.URL,
+			PublicURL: up.PublicURL,
+			Username:  up.Username,
+			Password:  up.Password,
+			Token:     up.Token,
+		}, nil
+	case "s3":
+		if up.Bucket == "" || (up.Region == "" && up.URL == "") {
+			return nil, fmt.Errorf("outgoing.upload: bucket and region or url are required for type s3")
+		}
+		return &S3Uploader{
+			Endpoint:  up.URL,
+			Region:    up.Region,
+			Bucket:    up.Bucket,
+			Prefix:    up.Prefix,
+			AccessKey: up.AccessKey,
+			SecretKey: up.SecretKey,
+			PublicURL: up.PublicURL,
+		}, nil
+	default:
+		return nil, fmt.Errorf("outgoing.upload: unknown type %q (want http, webdav or s3)", up.Type)
+	}
+}
+
+// ParseAddressList parses a comma-separated list of addresses like
+// ParseAddresses, but keeps the entries that don't parse as the address,
+// for the server to reject.
+func ParseAddressList(s string) []Address {
+	entries := splitAddressList(s)
+	addrs := make([]Address, 0, len(entries))
+	for _, entry := range entries {
+		if parsed, err := parseAddressEntry(entry); err == nil {
+			addrs = append(addrs, parsed...)
+		} else {
+			addrs = append(addrs, Address{Email: entry})
+		}
+	}
+	return addrs
+}
+
+// ParseAddresses parses a comma-separated list of RFC 5322 addresses:
+// "Name <email>" or "email", with display names that are quoted (and may
+// hold commas) or encoded words. Groups ("Team: a@x.com, b@y.com;") yield
+// their members. An unquoted name with a comma, as in "Doe, Jane
+// <jane@x.com>", is read as one name. Any entry that is not a valid
+// address is an error.
+func ParseAddresses(s string) ([]Address, error) {
+	var addrs []Address
+	for _, entry := range splitAddressList(s) {
+		parsed, err := parseAddressEntry(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", entry, err)
+		}
+		addrs = append(addrs, parsed...)
+	}
+	return addrs, nil
+}
+
+// parseAddressEntry parses one entry of splitAddressList: an address or a
+// group of them.
+func parseAddressEntry(entry string) ([]Address, error) {
+	list, err := mail.ParseAddressList(entry)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]Address, len(list))
+	for i, a := range list {
+		addrs[i] = Address{Name: a.Name, Email: a.Address}
+	}
+	return addrs, nil
+}
+
+// splitAddressList splits s at the commas between addresses, leaving
+// those in quoted names, comments, angle brackets and groups. An entry
+// without "@" followed by a "Name <email>" one is taken as the start of
+// an unquoted name with a comma, and the two are joined with the name
+// quoted.
+func splitAddressList(s string) []string {
+	var entries []string
+	var quoted, escaped, group bool
+	depth, start := 0, 0 // depth counts open comments and angle brackets
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = quoted || depth > 0
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(' || c == '<':
+			depth++
+		case (c == ')' || c == '>') && depth > 0:
+			depth--
+		case depth > 0:
+		case c == ':':
+			group = true
+		case c == ';':
+			group = false
+		case c == ',' && !group:
+			entries = append(entries, s[start:i])
+			start = i + 1
+		}
+	}
+	entries = append(entries, s[start:])
+
+	kept := entries[:0]
+	for i := 0; i < len(entries); i++ {
+		entry := strings.TrimSpace(entries[i])
+		if entry == "" {
+			continue
+		}
+		if i+1 < len(entries) && !strings.ContainsAny(entry, "@:<>()\"\\") {
+			next := strings.TrimSpace(entries[i+1])
+			lt := strings.IndexByte(next, '<')
+			if name := strings.TrimSpace(next[:max(lt, 0)]); name != "" && !strings.ContainsAny(name, "()\"\\") {
+				entry = `"` + entry + ", " + name + `" ` + next[lt:]
+				i++
+			}
+		}
+		kept = append(kept, entry)
+	}
+	return kept
+}
+
+// checkAddress reports whether email is a valid addr-spec, as used in the
+// SMTP envelope.
+func checkAddress(email string) error {
+	addr, err := mail.ParseAddress("<" + email + ">")
+	if err != nil {
+		return err
+	}
+	if addr.Address != email {
+		return fmt.Errorf("not a plain address")
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS config for the server of the account set by s:
+// TLSConfig, with the certificate pinned if s has a fingerprint.
+func (a *Account) tlsConfig(s config.ProtocolSettings) (*tls.Config, error) {
+	fp, err := s.Fingerprint()
+	if err != nil || fp == nil {
+		return a.TLSConfig, err
+	}
+	return PinCertificate(a.TLSConfig, fp, s.TLSPinOnly), nil
+}
+
+// clientTLSConfig returns a copy of cfg for connecting to host, or the
+// default configuration if cfg is nil.
+func clientTLSConfig(cfg *tls.Config, host string) *tls.Config {
+	if cfg == nil {
+		return &tls.Config{ServerName: host}
+	}
+	cfg = cfg.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	return cfg
+}
@@ -0,0 +1,126 @@
+package email
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/emx-mail/cli/pkgs/config"
+)
+
+func TestAccountReadProtocol(t *testing.T) {
+	tests := []struct {
+		imap, pop3 string
+		protocol   string
+		want       string
+	}{
+		{"imap.example.com", "pop.example.com", "", "imap"},
+		{"", "pop.example.com", "", "pop3"},
+		{"", "", "", "imap"},
+		{"imap.example.com", "pop.example.com", "pop3", "pop3"},
+	}
+	for _, tt := range tests {
+		acc := &config.AccountConfig{}
+		acc.IMAP.Host, acc.POP3.Host = tt.imap, tt.pop3
+		a := NewAccount(acc)
+		a.Protocol = tt.protocol
+		if got := a.ReadProtocol(); got != tt.want {
+			t.Errorf("ReadProtocol(imap=%q, pop3=%q, protocol=%q) = %q, want %q", tt.imap, tt.pop3, tt.protocol, got, tt.want)
+		}
+	}
+}
+
+func TestAccountClients(t *testing.T) {
+	acc := &config.AccountConfig{Email: "me@example.com"}
+	acc.SMTP.Host = "smtp.example.com"
+	acc.Outgoing = &config.OutgoingConfig{
+		AlwaysBcc: []string{"Archive <archive@example.com>, log@example.com"},
+		ReplyTo:   "team@example.com",
+	}
+	a := NewAccount(acc)
+	a.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS13}
+
+	if _, err := a.POP3(); err == nil {
+		t.Error("POP3() succeeded without a POP3 server")
+	}
+	c, err := a.SMTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := a.SMTP(); again != c {
+		t.Error("SMTP() created a second client")
+	}
+	if got := c.config.AlwaysBcc; len(got) != 2 || got[0].Name != "Archive" || got[1].Email != "log@example.com" {
+		t.Errorf("AlwaysBcc = %+v", got)
+	}
+	if got := c.config.ReplyTo; len(got) != 1 || got[0].Email != "team@example.com" {
+		t.Errorf("ReplyTo = %+v", got)
+	}
+	tlsCfg := clientTLSConfig(c.config.TLSConfig, c.config.Host)
+	if tlsCfg.ServerName != "smtp.example.com" || tlsCfg.MinVersion != tls.VersionTLS13 {
+		t.Errorf("TLS config = %+v", tlsCfg)
+	}
+	if a.TLSConfig.ServerName != "" {
+		t.Error("the shared TLS config was modified")
+	}
+	if err := a.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestAccountUploaderError(t *testing.T) {
+	acc := &config.AccountConfig{}
+	acc.Outgoing = &config.OutgoingConfig{Upload: &config.UploadConfig{Type: "ftp"}}
+	if _, err := NewAccount(acc).SMTP(); err == nil {
+		t.Error("SMTP() accepted an unknown upload type")
+	}
+}
+
+func TestParseAddressList(t *testing.T) {
+	got := ParseAddressList(`Alice <alice@example.com>, bob@example.com, , not-an-address`)
+	want := []Address{{Name: "Alice", Email: "alice@example.com"}, {Email: "bob@example.com"}, {Email: "not-an-address"}}
+	if len(got) != len(want) {
+		t.Fatalf("ParseAddressList = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseAddresses(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []Address
+	}{
+		{`Alice Example <alice@x.com>, bob@y.com`, []Address{{Name: "Alice Example", Email: "alice@x.com"}, {Email: "bob@y.com"}}},
+		{`"Doe, Jane" <jane@x.com>, bob@y.com`, []Address{{Name: "Doe, Jane", Email: "jane@x.com"}, {Email: "bob@y.com"}}},
+		{`Doe, Jane <jane@x.com>`, []Address{{Name: "Doe, Jane", Email: "jane@x.com"}}},
+		{`Team: a@x.com, "B, b" <b@y.com>;, c@z.com`, []Address{{Email: "a@x.com"}, {Name: "B, b", Email: "b@y.com"}, {Email: "c@z.com"}}},
+		{`Jürgen Müller <j@x.de>, =?utf-8?q?J=C3=BCrgen?= <j@y.de>`, []Address{{Name: "Jürgen Müller", Email: "j@x.de"}, {Name: "Jürgen", Email: "j@y.de"}}},
+		{`bob@y.com (Bob, at work), `, []Address{{Name: "Bob, at work", Email: "bob@y.com"}}},
+		{``, nil},
+	}
+	for _, tt := range tests {
+		got, err := ParseAddresses(tt.in)
+		if err != nil {
+			t.Errorf("ParseAddresses(%q) error: %v", tt.in, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseAddresses(%q) = %+v, want %+v", tt.in, got, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("ParseAddresses(%q)[%d] = %+v, want %+v", tt.in, i, got[i], tt.want[i])
+			}
+		}
+	}
+
+	for _, in := range []string{`bob`, `alice@x.com, bob@`, `Alice <alice@x.com`} {
+		if _, err := ParseAddresses(in); err == nil {
+			t.Errorf("ParseAddresses(%q) succeeded, want an error", in)
+		}
+	}
+}
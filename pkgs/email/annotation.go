@@ -0,0 +1,112 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrMetadataUnsupported is returned for annotations on a server without
+// the METADATA extension (RFC 5464).
+var ErrMetadataUnsupported = errors.New("server does not support METADATA")
+
+// Annotations are values a client keeps on the server with the METADATA
+// extension, e.g. the processing state of a folder or message, rather than
+// in keyword flags. They are METADATA entries: a name starting with
+// "/private/" or "/shared/" is used as is, any other name is kept under
+// annotationRoot.
+//
+// Message annotations are entries of the message's folder under
+// annotationRoot/message/<UIDVALIDITY>/<UID>, as go-imap and most servers
+// lack the per-message ANNOTATE extension (RFC 5257). They stay with the
+// folder: a message moved elsewhere leaves them behind, and a renumbered
+// folder orphans them.
+const annotationRoot = "/private/vendor/emx-mail"
+
+// messageAnnotationRoot is the entry holding the annotations of all
+// messages of a folder.
+const messageAnnotationRoot = annotationRoot + "/message"
+
+// checkAnnotationName checks that name can be part of a METADATA entry:
+// entries are paths of non-empty components without wildcards.
+func checkAnnotationName(name string) error {
+	switch {
+	case name == "" || name == "/":
+		return fmt.Errorf("empty annotation name")
+	case strings.ContainsAny(name, "*%"):
+		return fmt.Errorf("annotation name %q: wildcards are not allowed", name)
+	case strings.Contains(name, "//") || strings.HasSuffix(name, "/"):
+		return fmt.Errorf("annotation name %q: empty path component", name)
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("annotation name %q: control characters are not allowed", name)
+		}
+	}
+	return nil
+}
+
+// annotationEntry returns the METADATA entry of the server or folder
+// annotation name.
+func annotationEntry(name string) (string, error) {
+	if err := checkAnnotationName(name); err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(name, "/") {
+		return annotationRoot + "/" + name, nil
+	}
+	lower := strings.ToLower(name)
+	if !strings.HasPrefix(lower, "/private/") && !strings.HasPrefix(lower, "/shared/") {
+		return "", fmt.Errorf("annotation name %q: entries start with /private/ or /shared/", name)
+	}
+	if strings.HasPrefix(lower, messageAnnotationRoot+"/") || lower == messageAnnotationRoot {
+		return "", fmt.Errorf("annotation name %q: reserved for message annotations", name)
+	}
+	return name, nil
+}
+
+// annotationName returns the name annotationEntry maps to entry.
+func annotationName(entry string) string {
+	if rest, ok := strings.CutPrefix(entry, annotationRoot+"/"); ok {
+		return rest
+	}
+	return entry
+}
+
+// messageAnnotationPrefix returns the entry holding the annotations of
+// message uid of a folder with UIDVALIDITY uidValidity.
+func messageAnnotationPrefix(uidValidity, uid uint32) string {
+	return messageAnnotationRoot + "/" + strconv.FormatUint(uint64(uidValidity), 10) + "/" + strconv.FormatUint(uint64(uid), 10)
+}
+
+// messageAnnotationEntry returns the METADATA entry of the annotation name
+// of a message. Message annotations are always emx-mail's own, so name
+// cannot be a full entry.
+func messageAnnotationEntry(uidValidity, uid uint32, name string) (string, error) {
+	if err := checkAnnotationName(name); err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("annotation name %q: message annotations take a plain name", name)
+	}
+	return messageAnnotationPrefix(uidValidity, uid) + "/" + name, nil
+}
+
+// annotationMutation describes setting values of the annotations of folder
+// ("" for the server) or of its message uid: Add lists the names set,
+// Remove those removed with an empty value.
+func annotationMutation(folder string, uid uint32, messageID string, values map[string]string) Mutation {
+	m := Mutation{Op: "annotate", Folder: folder, UID: uid, MessageID: messageID}
+	for name, v := range values {
+		if v == "" {
+			m.Remove = append(m.Remove, name)
+		} else {
+			m.Add = append(m.Add, name)
+		}
+	}
+	sort.Strings(m.Add)
+	sort.Strings(m.Remove)
+	return m
+}
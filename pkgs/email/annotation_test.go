@@ -0,0 +1,64 @@
+package email
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAnnotationEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string // "" if invalid
+	}{
+		{"state", "/private/vendor/emx-mail/state"},
+		{"sync/last-run", "/private/vendor/emx-mail/sync/last-run"},
+		{"/shared/comment", "/shared/comment"},
+		{"/private/vendor/other/x", "/private/vendor/other/x"},
+		{"", ""},
+		{"/", ""},
+		{"a*", ""},
+		{"a%b", ""},
+		{"a//b", ""},
+		{"a/", ""},
+		{"a\nb", ""},
+		{"/comment", ""},
+		{"/private/vendor/emx-mail/message/1/2/state", ""},
+	}
+	for _, tt := range tests {
+		entry, err := annotationEntry(tt.name)
+		if tt.entry == "" {
+			if err == nil {
+				t.Errorf("annotationEntry(%q) = %q, want an error", tt.name, entry)
+			}
+			continue
+		}
+		if err != nil || entry != tt.entry {
+			t.Errorf("annotationEntry(%q) = %q, %v; want %q", tt.name, entry, err, tt.entry)
+		}
+		if name := annotationName(entry); name != tt.name {
+			t.Errorf("annotationName(%q) = %q, want %q", entry, name, tt.name)
+		}
+	}
+}
+
+func TestMessageAnnotationEntry(t *testing.T) {
+	entry, err := messageAnnotationEntry(1700000000, 42, "state")
+	if err != nil || entry != "/private/vendor/emx-mail/message/1700000000/42/state" {
+		t.Errorf("messageAnnotationEntry() = %q, %v", entry, err)
+	}
+	for _, name := range []string{"", "/shared/comment", "a*"} {
+		if _, err := messageAnnotationEntry(1, 2, name); err == nil {
+			t.Errorf("messageAnnotationEntry(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestAnnotationMutation(t *testing.T) {
+	m := annotationMutation("INBOX", 7, "a@example.com", map[string]string{"b": "1", "a": "2", "old": ""})
+	if m.Op != "annotate" || m.Folder != "INBOX" || m.UID != 7 || m.MessageID != "a@example.com" {
+		t.Errorf("mutation = %+v", m)
+	}
+	if fmt.Sprint(m.Add) != "[a b]" || fmt.Sprint(m.Remove) != "[old]" {
+		t.Errorf("add = %v, remove = %v; want [a b] and [old]", m.Add, m.Remove)
+	}
+}
@@ -0,0 +1,155 @@
+package email
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveFolder is a folder of saved messages found by ScanArchive.
+type ArchiveFolder struct {
+	Name     string // "INBOX" for the top directory, else its path below it with "/"
+	Messages []ArchiveMessage
+}
+
+// ArchiveMessage is a saved message file.
+type ArchiveMessage struct {
+	Path  string
+	Time  time.Time   // Modification time of the file
+	Flags MessageFlag // From the maildir info (":2,FS"); a message in new/ has none
+}
+
+// ScanArchive lists the saved messages under dir: the .eml files written
+// by emx-save and export, and the messages of maildirs (their cur and new
+// directories). Every directory holding messages is a folder, dir itself
+// being INBOX, which is always listed. Folders are sorted by name after
+// INBOX, and their messages by file time.
+func ScanArchive(dir string) ([]ArchiveFolder, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	folders := map[string]*ArchiveFolder{"INBOX": {Name: "INBOX"}}
+	folder := func(path string) *ArchiveFolder {
+		name := "INBOX"
+		if rel, _ := filepath.Rel(dir, path); rel != "." {
+			name = filepath.ToSlash(rel)
+		}
+		f := folders[name]
+		if f == nil {
+			f = &ArchiveFolder{Name: name}
+			folders[name] = f
+		}
+		return f
+	}
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		parent := filepath.Dir(path)
+		if d.IsDir() {
+			if isMaildir(path) {
+				folder(path)
+			} else if d.Name() == "tmp" && isMaildir(parent) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		msg := ArchiveMessage{Path: path}
+		switch sub := filepath.Base(parent); {
+		case (sub == "cur" || sub == "new") && isMaildir(filepath.Dir(parent)):
+			parent = filepath.Dir(parent)
+			if sub == "cur" {
+				msg.Flags = maildirFlags(d.Name())
+			}
+		case strings.EqualFold(filepath.Ext(path), ".eml"):
+		default:
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		msg.Time = info.ModTime()
+		f := folder(parent)
+		f.Messages = append(f.Messages, msg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]ArchiveFolder, 0, len(folders))
+	for _, f := range folders {
+		sort.SliceStable(f.Messages, func(i, j int) bool {
+			a, b := f.Messages[i], f.Messages[j]
+			if !a.Time.Equal(b.Time) {
+				return a.Time.Before(b.Time)
+			}
+			return a.Path < b.Path
+		})
+		list = append(list, *f)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name == "INBOX" || list[j].Name == "INBOX" {
+			return list[i].Name == "INBOX"
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// isMaildir reports whether dir has the cur and new directories of a
+// maildir.
+func isMaildir(dir string) bool {
+	for _, sub := range []string{"cur", "new"} {
+		if info, err := os.Stat(filepath.Join(dir, sub)); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// maildirFlags returns the flags in the info part of a maildir file name,
+// e.g. "1700000000.M1P2.host:2,FS".
+func maildirFlags(name string) MessageFlag {
+	var flags MessageFlag
+	_, info, ok := strings.Cut(name, ":2,")
+	if !ok {
+		return flags
+	}
+	for _, c := range info {
+		switch c {
+		case 'S':
+			flags.Seen = true
+		case 'F':
+			flags.Flagged = true
+		case 'R':
+			flags.Answered = true
+		case 'D':
+			flags.Draft = true
+		case 'T':
+			flags.Deleted = true
+		}
+	}
+	return flags
+}
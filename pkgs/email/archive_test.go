@@ -0,0 +1,78 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScanArchive(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	write := func(name string, age int) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(testMailRFC822), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		when := base.Add(-time.Duration(age) * time.Hour)
+		if err := os.Chtimes(path, when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("new.eml", 1)
+	write("old.EML", 2)
+	write("notes.txt", 0)
+	write(".trash/gone.eml", 0)
+	write("Projects/x/a.eml", 0)
+	write("Lists/cur/1700000000.M1P1.host:2,FS", 3)
+	write("Lists/new/1700000001.M2P1.host", 2)
+	write("Lists/tmp/1700000002.M3P1.host", 1)
+	if err := os.MkdirAll(filepath.Join(dir, "Empty", "cur"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "Empty", "new"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	folders, err := ScanArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range folders {
+		names = append(names, f.Name)
+	}
+	if want := []string{"INBOX", "Empty", "Lists", "Projects/x"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("folders = %q, want %q", names, want)
+	}
+
+	inbox := folders[0].Messages
+	if len(inbox) != 2 || filepath.Base(inbox[0].Path) != "old.EML" || filepath.Base(inbox[1].Path) != "new.eml" {
+		t.Errorf("INBOX = %+v, want old.EML then new.eml", inbox)
+	}
+	if !inbox[0].Time.Equal(base.Add(-2 * time.Hour)) {
+		t.Errorf("time = %v, want the file time", inbox[0].Time)
+	}
+	if n := len(folders[1].Messages); n != 0 {
+		t.Errorf("Empty has %d messages", n)
+	}
+	lists := folders[2].Messages
+	if len(lists) != 2 {
+		t.Fatalf("Lists has %d messages, want 2 (tmp skipped)", len(lists))
+	}
+	if f := lists[0].Flags; !f.Seen || !f.Flagged || f.Answered {
+		t.Errorf("cur message flags = %+v, want seen and flagged", f)
+	}
+	if lists[1].Flags != (MessageFlag{}) {
+		t.Errorf("new message flags = %+v, want none", lists[1].Flags)
+	}
+
+	if _, err := ScanArchive(filepath.Join(dir, "new.eml")); err == nil {
+		t.Error("ScanArchive(file) succeeded")
+	}
+}
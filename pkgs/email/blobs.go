@@ -0,0 +1,84 @@
+package email
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// BlobStore saves attachments content-addressed, so an archive that receives
+// the same file many times stores it once. Each distinct content is written
+// once, read-only, to Dir as <first 2 hex digits>/<SHA-256>, and every place
+// it is saved to is a hard link to that blob: or, where the blob directory
+// is on another file system, a symbolic link, and failing that a copy.
+type BlobStore struct {
+	Dir string
+}
+
+// Save saves data as path, linked to the blob of its content, replacing
+// any file at path. It reports whether the blob existed already.
+func (s *BlobStore) Save(path string, data []byte) (existed bool, err error) {
+	blob, existed, err := s.put(data)
+	if err != nil {
+		return false, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return existed, err
+	}
+	if err := os.Link(blob, path); err == nil {
+		return existed, nil
+	}
+	if abs, err := filepath.Abs(blob); err == nil {
+		if err := os.Symlink(abs, path); err == nil {
+			return existed, nil
+		}
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return existed, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return existed, nil
+}
+
+// BlobPath returns where the blob of data is stored.
+func (s *BlobStore) BlobPath(data []byte) string {
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(s.Dir, name[:2], name)
+}
+
+// put writes the blob of data unless it exists, and returns its path.
+func (s *BlobStore) put(data []byte) (path string, existed bool, err error) {
+	path = s.BlobPath(data)
+	if _, err := os.Stat(path); err == nil {
+		return path, true, nil
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", false, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	// Write to a temp file and rename, so a blob is never seen half written
+	tmp, err := os.CreateTemp(dir, ".blob-*.tmp")
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", false, fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", false, fmt.Errorf("failed to write blob: %w", err)
+	}
+	// Blobs are shared by every link to them: keep them from being edited
+	if err := os.Chmod(tmp.Name(), 0o444); err != nil {
+		return "", false, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", false, fmt.Errorf("failed to store blob: %w", err)
+	}
+	return path, false, nil
+}
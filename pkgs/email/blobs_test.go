@@ -0,0 +1,63 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlobStoreSave(t *testing.T) {
+	dir := t.TempDir()
+	store := &BlobStore{Dir: filepath.Join(dir, "blobs")}
+	pdf := []byte("%PDF-1.4 quarterly report")
+
+	a := filepath.Join(dir, "a", "report.pdf")
+	b := filepath.Join(dir, "b", "report.pdf")
+	for _, d := range []string{filepath.Dir(a), filepath.Dir(b)} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	existed, err := store.Save(a, pdf)
+	if err != nil || existed {
+		t.Fatalf("first Save = %v, %v; want a new blob", existed, err)
+	}
+	existed, err = store.Save(b, pdf)
+	if err != nil || !existed {
+		t.Fatalf("second Save = %v, %v; want the existing blob", existed, err)
+	}
+
+	blob := store.BlobPath(pdf)
+	if !strings.HasPrefix(filepath.Base(blob), filepath.Base(filepath.Dir(blob))) {
+		t.Errorf("blob %s is not below its hash prefix", blob)
+	}
+	blobInfo, err := os.Stat(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{a, b} {
+		got, err := os.ReadFile(p)
+		if err != nil || string(got) != string(pdf) {
+			t.Errorf("%s = %q, %v", p, got, err)
+		}
+		if info, err := os.Stat(p); err != nil || !os.SameFile(info, blobInfo) {
+			t.Errorf("%s is not linked to the blob", p)
+		}
+	}
+
+	// Saving other content over a saved file replaces the link, not the blob
+	if _, err := store.Save(b, []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(a); string(got) != string(pdf) {
+		t.Errorf("replacing %s changed %s to %q", b, a, got)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(blob))
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Errorf("temp file %s left behind", e.Name())
+		}
+	}
+}
@@ -8,38 +8,58 @@ import (
 	"github.com/emersion/go-message/mail"
 )
 
+// ParseBody parses an RFC 5322 message into a Message with its text and
+// HTML bodies, charset and attachments, e.g. to save the attachments of a
+// message file. Headers and flags are left unset.
+func ParseBody(r io.Reader) (*Message, error) {
+	entity, err := gomessage.Read(r)
+	if !isRecoverableEntityError(err) {
+		return nil, err
+	}
+	msg := &Message{}
+	parseEntityBody(msg, entity)
+	return msg, nil
+}
+
 // parseEntityBody parses a go-message Entity into the Message's TextBody,
 // HTMLBody and Attachments fields. It handles both single-part and multipart
 // messages (including nested multipart).
 //
 // This function is used by both IMAPClient and POP3Client to avoid
-// duplicating the parsing logic.
+// duplicating the parsing logic. Text parts are converted to UTF-8 from
+// their declared charset; the original charset of the chosen text body is
+// recorded in msg.Charset, and msg.Preview is built from the bodies.
 func parseEntityBody(msg *Message, entity *gomessage.Entity) {
 	if mr := entity.MultipartReader(); mr != nil {
 		parseMultipart(msg, mr)
 	} else {
 		parseSinglePart(msg, entity)
 	}
+	msg.Preview = MakePreview(msg.TextBody, msg.HTMLBody)
 }
 
 // parseMultipart iterates over parts of a multipart message.
 func parseMultipart(msg *Message, mr gomessage.MultipartReader) {
 	for {
 		part, err := mr.NextPart()
-		if err != nil {
+		if !isRecoverableEntityError(err) {
 			break
 		}
 		ct, _, _ := part.Header.ContentType()
 
 		switch {
 		case strings.HasPrefix(ct, "text/plain") && msg.TextBody == "":
-			if body, err := io.ReadAll(part.Body); err == nil {
-				msg.TextBody = string(body)
+			if body, err := readTextBody(part.Body); err == nil {
+				msg.TextBody = body
+				msg.Charset = entityCharset(part.Header)
 			}
 
 		case strings.HasPrefix(ct, "text/html") && msg.HTMLBody == "":
-			if body, err := io.ReadAll(part.Body); err == nil {
-				msg.HTMLBody = string(body)
+			if body, err := readTextBody(part.Body); err == nil {
+				msg.HTMLBody = body
+				if msg.Charset == "" {
+					msg.Charset = entityCharset(part.Header)
+				}
 			}
 
 		case strings.HasPrefix(ct, "multipart/"):
@@ -60,6 +80,7 @@ func parseMultipart(msg *Message, mr gomessage.MultipartReader) {
 				Filename:    filename,
 				ContentType: ct,
 				Size:        int64(len(body)),
+				ContentID:   strings.Trim(strings.TrimSpace(part.Header.Get("Content-Id")), "<>"),
 				Data:        body,
 			})
 		}
@@ -69,13 +90,14 @@ func parseMultipart(msg *Message, mr gomessage.MultipartReader) {
 // parseSinglePart reads the body of a non-multipart entity.
 func parseSinglePart(msg *Message, entity *gomessage.Entity) {
 	ct, _, _ := entity.Header.ContentType()
-	body, err := io.ReadAll(entity.Body)
+	body, err := readTextBody(entity.Body)
 	if err != nil {
 		return
 	}
+	msg.Charset = entityCharset(entity.Header)
 	if strings.HasPrefix(ct, "text/html") {
-		msg.HTMLBody = string(body)
+		msg.HTMLBody = body
 	} else {
-		msg.TextBody = string(body)
+		msg.TextBody = body
 	}
 }
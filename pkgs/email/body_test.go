@@ -190,3 +190,118 @@ func TestParseEntityBody_AttachmentSize(t *testing.T) {
 		t.Errorf("attachment data length = %d, want %d", len(msg.Attachments[0].Data), len(payload))
 	}
 }
+
+func TestParseEntityBody_ContentID(t *testing.T) {
+	raw := "MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/related; boundary=\"REL\"\r\n" +
+		"\r\n" +
+		"--REL\r\n" +
+		"Content-Type: text/html\r\n\r\n" +
+		"<img src=\"cid:logo@example.com\">\r\n" +
+		"--REL\r\n" +
+		"Content-Type: image/png\r\n" +
+		"Content-ID: <logo@example.com>\r\n" +
+		"Content-Disposition: inline\r\n\r\n" +
+		"PNG\r\n" +
+		"--REL--\r\n"
+
+	entity := parseTestEntity(t, raw)
+	msg := &Message{}
+	parseEntityBody(msg, entity)
+
+	if len(msg.Attachments) != 1 {
+		t.Fatalf("expected 1 attachment, got %d", len(msg.Attachments))
+	}
+	if got := msg.Attachments[0].ContentID; got != "logo@example.com" {
+		t.Errorf("ContentID = %q, want %q", got, "logo@example.com")
+	}
+}
+
+func TestParseEntityBody_Charsets(t *testing.T) {
+	tests := []struct {
+		name    string
+		charset string
+		body    string
+		want    string
+	}{
+		{"GBK", "gbk", "\xd6\xd0\xce\xc4\xd3\xca\xbc\xfe", "中文邮件"},
+		{"GB2312", "gb2312", "\xc4\xe3\xba\xc3", "你好"},
+		{"ISO-2022-JP", "iso-2022-jp", "\x1b$BF|K\\8l\x1b(B", "日本語"},
+		{"KOI8-R", "koi8-r", "\xd0\xd2\xc9\xd7\xc5\xd4", "привет"},
+		{"Windows-1252", "windows-1252", "\x93quoted\x94 caf\xe9", "“quoted” café"},
+		{"UTF-8", "utf-8", "héllo", "héllo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "Content-Type: text/plain; charset=" + tt.charset + "\r\n\r\n" + tt.body
+			entity := parseTestEntity(t, raw)
+			msg := &Message{}
+			parseEntityBody(msg, entity)
+
+			if msg.TextBody != tt.want {
+				t.Errorf("TextBody = %q, want %q", msg.TextBody, tt.want)
+			}
+			if msg.Charset != tt.charset {
+				t.Errorf("Charset = %q, want %q", msg.Charset, tt.charset)
+			}
+		})
+	}
+}
+
+func TestParseEntityBody_CharsetInMultipart(t *testing.T) {
+	raw := "MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/alternative; boundary=\"CS\"\r\n" +
+		"\r\n" +
+		"--CS\r\n" +
+		"Content-Type: text/plain; charset=GBK\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\n" +
+		"1tDOxA==\r\n" +
+		"--CS\r\n" +
+		"Content-Type: text/html; charset=GBK\r\n\r\n" +
+		"<p>\xd6\xd0\xce\xc4</p>\r\n" +
+		"--CS--\r\n"
+
+	entity := parseTestEntity(t, raw)
+	msg := &Message{}
+	parseEntityBody(msg, entity)
+
+	if msg.TextBody != "中文" {
+		t.Errorf("TextBody = %q, want %q", msg.TextBody, "中文")
+	}
+	if !strings.Contains(msg.HTMLBody, "<p>中文</p>") {
+		t.Errorf("HTMLBody = %q, want decoded GBK", msg.HTMLBody)
+	}
+	if msg.Charset != "gbk" {
+		t.Errorf("Charset = %q, want %q", msg.Charset, "gbk")
+	}
+}
+
+func TestParseEntityBody_UnknownCharset(t *testing.T) {
+	raw := "MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"UC\"\r\n" +
+		"\r\n" +
+		"--UC\r\n" +
+		"Content-Type: text/plain; charset=x-no-such-charset\r\n\r\n" +
+		"ok \xff\xfe done\r\n" +
+		"--UC\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename=\"after.bin\"\r\n\r\n" +
+		"DATA\r\n" +
+		"--UC--\r\n"
+
+	entity := parseTestEntity(t, raw)
+	msg := &Message{}
+	parseEntityBody(msg, entity)
+
+	if msg.TextBody != "ok � done" {
+		t.Errorf("TextBody = %q, want replacement character", msg.TextBody)
+	}
+	if msg.Charset != "x-no-such-charset" {
+		t.Errorf("Charset = %q", msg.Charset)
+	}
+	// Parsing must continue past the undecodable part.
+	if len(msg.Attachments) != 1 || msg.Attachments[0].Filename != "after.bin" {
+		t.Errorf("expected attachment after unknown-charset part, got %+v", msg.Attachments)
+	}
+}
@@ -0,0 +1,167 @@
+package email
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap/v2"
+)
+
+// Capabilities describes what a server advertises and which emx-mail
+// features that enables.
+type Capabilities struct {
+	Protocol string    // "imap", "pop3" or "smtp"
+	List     []string  // Capabilities/extensions as advertised (sorted for IMAP)
+	Features []Feature // emx-mail features that depend on them
+}
+
+// Feature is an emx-mail feature that depends on a server capability
+type Feature struct {
+	Name       string // What the capability is used for
+	Capability string // Capability it requires
+	Enabled    bool   // Whether the server advertises it
+	Note       string // Extra detail, e.g. the fallback used or a limit
+}
+
+// smtpExtensions are the EHLO keywords probed for, since the SMTP client
+// only answers for named extensions.
+var smtpExtensions = []string{
+	"8BITMIME", "AUTH", "BINARYMIME", "CHUNKING", "DELIVERBY", "DSN",
+	"ENHANCEDSTATUSCODES", "LIMITS", "MT-PRIORITY", "PIPELINING", "REQUIRETLS",
+	"RRVS", "SIZE", "SMTPUTF8", "STARTTLS",
+}
+
+// Capabilities reports the server's capabilities after login.
+func (c *IMAPClient) Capabilities() (*Capabilities, error) {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	caps, err := c.client.Capability().Wait()
+	if err != nil {
+		return nil, fmt.Errorf("CAPABILITY failed: %w", err)
+	}
+	list := make([]string, 0, len(caps))
+	for cp := range caps {
+		list = append(list, string(cp))
+	}
+	sort.Strings(list)
+
+	rev2 := caps.Has(imap.CapIMAP4rev2)
+	return &Capabilities{
+		Protocol: "imap",
+		List:     list,
+		Features: []Feature{
+			{
+				Name:       "watch: push notification of new mail",
+				Capability: "IDLE",
+				Enabled:    caps.Has(imap.CapIdle) || rev2,
+				Note:       "polls instead when missing",
+			},
+			{
+				Name:       "folders: subscription status",
+				Capability: "LIST-EXTENDED",
+				Enabled:    caps.Has(imap.CapListExtended) || rev2,
+			},
+			{
+				Name:       "folders --counts: counts in a single LIST",
+				Capability: "LIST-STATUS",
+				Enabled:    caps.Has(imap.CapListStatus) || rev2,
+				Note:       "sends one STATUS per folder when missing",
+			},
+		},
+	}, nil
+}
+
+// Capabilities reports the server's CAPA response (RFC 2449) after login.
+func (c *POP3Client) Capabilities() (*Capabilities, error) {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	list, err := c.conn.capa()
+	if err != nil {
+		return nil, fmt.Errorf("POP3 CAPA failed: %w", err)
+	}
+	has := make(map[string]bool, len(list))
+	for _, line := range list {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			has[strings.ToUpper(fields[0])] = true
+		}
+	}
+
+	return &Capabilities{
+		Protocol: "pop3",
+		List:     list,
+		Features: []Feature{
+			{
+				Name:       "list: headers and previews without downloading whole messages",
+				Capability: "TOP",
+				Enabled:    has["TOP"],
+				Note:       "downloads each message with RETR when missing",
+			},
+			{
+				Name:       "starttls: upgrade the plain POP3 port to TLS",
+				Capability: "STLS",
+				Enabled:    has["STLS"],
+			},
+		},
+	}, nil
+}
+
+// Capabilities reports the server's EHLO extensions after login.
+func (c *SMTPClient) Capabilities() (*Capabilities, error) {
+	if c.client == nil {
+		if err := c.Connect(); err != nil {
+			return nil, err
+		}
+		defer c.Close()
+	}
+
+	var list []string
+	for _, ext := range smtpExtensions {
+		if ok, param := c.client.Extension(ext); ok {
+			list = append(list, strings.TrimSpace(ext+" "+param))
+		}
+	}
+
+	size := Feature{
+		Name:       "message size limit",
+		Capability: "SIZE",
+	}
+	if max, ok := c.client.MaxMessageSize(); ok {
+		size.Enabled = true
+		if max > 0 {
+			size.Note = fmt.Sprintf("%d bytes; set outgoing.max_message_size to upload larger attachments", max)
+		}
+	}
+	startTLS := Feature{
+		Name:       "starttls: upgrade the submission port to TLS",
+		Capability: "STARTTLS",
+	}
+	startTLS.Enabled, _ = c.client.Extension("STARTTLS")
+	if _, isTLS := c.client.TLSConnectionState(); isTLS && !startTLS.Enabled {
+		// Servers stop advertising STARTTLS once it has been used
+		startTLS.Enabled = c.config.StartTLS
+		startTLS.Note = "connection is already encrypted"
+	}
+
+	return &Capabilities{
+		Protocol: "smtp",
+		List:     list,
+		Features: []Feature{
+			{
+				Name:       "login (emx-mail uses AUTH PLAIN)",
+				Capability: "AUTH PLAIN",
+				Enabled:    c.client.SupportsAuth("PLAIN"),
+			},
+			startTLS,
+			size,
+		},
+	}, nil
+}
@@ -0,0 +1,131 @@
+package email
+
+import "sync"
+
+// MailboxChange reports a message that was expunged from the watched folder
+// or whose flags changed, or that the folder was renumbered (its
+// UIDVALIDITY changed) and every UID reported before is stale. Watch writes
+// one JSON line per change to stdout when WatchOptions.Changes is set, next
+// to the "email" notifications.
+type MailboxChange struct {
+	Type   string   `json:"type"` // "expunge", "flags" or "uidvalidity"
+	Folder string   `json:"folder"`
+	UID    uint32   `json:"uid,omitempty"` // 0 if the server's sequence number could not be mapped
+	SeqNum uint32   `json:"seq"`           // Sequence number before the change
+	Flags  []string `json:"flags"`         // Current flags ("flags" only)
+}
+
+// changeTracker maps the sequence numbers of the watched folder to UIDs.
+// Untagged EXPUNGE responses carry only a sequence number, and every
+// expunge renumbers the messages after it, so the map has to follow the
+// responses in the order the server sends them.
+type changeTracker struct {
+	mu     sync.Mutex
+	folder string
+	uids   []uint32 // UID of the message with sequence number i+1; 0 if not known yet
+	stale  bool     // uids needs reloading from the server
+	emit   func(MailboxChange)
+
+	// arrived is signalled when the folder grows, so IDLE can end early
+	arrived chan struct{}
+}
+
+func newChangeTracker(emit func(MailboxChange)) *changeTracker {
+	return &changeTracker{
+		stale:   true,
+		emit:    emit,
+		arrived: make(chan struct{}, 1),
+	}
+}
+
+// reset replaces the map with the UIDs of folder in ascending order, as
+// returned by UID SEARCH ALL.
+func (t *changeTracker) reset(folder string, uids []uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.folder, t.uids, t.stale = folder, uids, false
+}
+
+// invalidate marks the map for reloading, e.g. after a command whose
+// expunges may not have been reported to the tracker.
+func (t *changeTracker) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stale = true
+}
+
+// renumbered drops the UIDs of folder, whose UIDVALIDITY changed, and
+// reports the change.
+func (t *changeTracker) renumbered(folder string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.folder, t.uids, t.stale = folder, nil, true
+	t.emit(MailboxChange{Type: "uidvalidity", Folder: folder})
+}
+
+func (t *changeTracker) needsReload() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stale
+}
+
+// arrivals returns the channel signalled when messages arrive; nil (never
+// ready) for a nil tracker, so callers can select on it unconditionally.
+func (t *changeTracker) arrivals() <-chan struct{} {
+	if t == nil {
+		return nil
+	}
+	return t.arrived
+}
+
+// expunge handles an untagged EXPUNGE response.
+func (t *changeTracker) expunge(seq uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	change := MailboxChange{Type: "expunge", Folder: t.folder, SeqNum: seq}
+	if i := int(seq) - 1; i >= 0 && i < len(t.uids) {
+		change.UID = t.uids[i]
+		t.uids = append(t.uids[:i], t.uids[i+1:]...)
+	} else {
+		t.stale = true
+	}
+	t.emit(change)
+}
+
+// exists handles an untagged EXISTS response: the folder now holds n
+// messages. The UIDs of new messages are learnt on the next reload.
+func (t *changeTracker) exists(n uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case int(n) > len(t.uids):
+		t.uids = append(t.uids, make([]uint32, int(n)-len(t.uids))...)
+		t.stale = true
+		select {
+		case t.arrived <- struct{}{}:
+		default:
+		}
+	case int(n) < len(t.uids):
+		// EXISTS never shrinks without EXPUNGE; the map is out of step
+		t.uids = t.uids[:n]
+		t.stale = true
+	}
+}
+
+// flags handles an untagged FETCH response with FLAGS. uid is 0 if the
+// response did not include it.
+func (t *changeTracker) flags(seq, uid uint32, flags []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if i := int(seq) - 1; i >= 0 && i < len(t.uids) {
+		if uid == 0 {
+			uid = t.uids[i]
+		} else {
+			t.uids[i] = uid
+		}
+	}
+	if flags == nil {
+		flags = []string{}
+	}
+	t.emit(MailboxChange{Type: "flags", Folder: t.folder, UID: uid, SeqNum: seq, Flags: flags})
+}
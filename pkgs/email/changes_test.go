@@ -0,0 +1,84 @@
+package email
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangeTracker(t *testing.T) {
+	var got []MailboxChange
+	tr := newChangeTracker(func(ch MailboxChange) { got = append(got, ch) })
+	if !tr.needsReload() {
+		t.Error("new tracker does not need a reload")
+	}
+	tr.reset("INBOX", []uint32{10, 11, 12, 13})
+
+	// Expunging seq 2 (UID 11) renumbers UID 12 to seq 2
+	tr.expunge(2)
+	tr.expunge(2)
+	tr.flags(2, 0, []string{`\Seen`})
+	tr.flags(1, 10, nil)
+
+	want := []MailboxChange{
+		{Type: "expunge", Folder: "INBOX", UID: 11, SeqNum: 2},
+		{Type: "expunge", Folder: "INBOX", UID: 12, SeqNum: 2},
+		{Type: "flags", Folder: "INBOX", UID: 13, SeqNum: 2, Flags: []string{`\Seen`}},
+		{Type: "flags", Folder: "INBOX", UID: 10, SeqNum: 1, Flags: []string{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("changes = %+v, want %+v", got, want)
+	}
+	if tr.needsReload() {
+		t.Error("tracker needs a reload after in-range changes")
+	}
+
+	// New messages have unknown UIDs until the next reload
+	tr.exists(3)
+	select {
+	case <-tr.arrivals():
+	default:
+		t.Error("exists did not signal arrival")
+	}
+	if !tr.needsReload() {
+		t.Error("tracker does not need a reload after new messages")
+	}
+	got = nil
+	tr.expunge(3)
+	if len(got) != 1 || got[0].UID != 0 {
+		t.Errorf("expunge of unknown message = %+v, want UID 0", got)
+	}
+
+	// An out-of-range sequence number cannot be mapped
+	tr.reset("INBOX", []uint32{10})
+	got = nil
+	tr.expunge(5)
+	if len(got) != 1 || got[0].UID != 0 || !tr.needsReload() {
+		t.Errorf("out-of-range expunge = %+v, reload %v; want UID 0 and a reload", got, tr.needsReload())
+	}
+
+	var nilTracker *changeTracker
+	if nilTracker.arrivals() != nil {
+		t.Error("nil tracker arrivals is not nil")
+	}
+}
+
+func TestChangeTrackerRenumbered(t *testing.T) {
+	var got []MailboxChange
+	tr := newChangeTracker(func(ch MailboxChange) { got = append(got, ch) })
+	tr.reset("INBOX", []uint32{10, 11})
+
+	tr.renumbered("INBOX")
+	if want := []MailboxChange{{Type: "uidvalidity", Folder: "INBOX"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("changes = %+v, want %+v", got, want)
+	}
+	if !tr.needsReload() {
+		t.Error("tracker does not need a reload after renumbering")
+	}
+
+	// Old UIDs must not be reported for the renumbered folder
+	got = nil
+	tr.expunge(1)
+	if len(got) != 1 || got[0].UID != 0 {
+		t.Errorf("expunge after renumbering = %+v, want UID 0", got)
+	}
+}
@@ -0,0 +1,62 @@
+package email
+
+import (
+	"io"
+	"mime"
+	"strings"
+
+	gomessage "github.com/emersion/go-message"
+
+	// Registers decoders for legacy charsets (GBK, GB18030, Big5,
+	// ISO-2022-JP, Shift_JIS, EUC-KR, KOI8-R, Windows-125x, ISO-8859-x, ...)
+	// with go-message, so entity bodies and encoded-word headers are
+	// converted to UTF-8 automatically.
+	"github.com/emersion/go-message/charset"
+)
+
+// wordDecoder decodes RFC 2047 encoded-words in every charset supported by
+// go-message. The zero mime.WordDecoder only understands UTF-8, US-ASCII
+// and ISO-8859-1, which lets subjects like "=?gb2312?B?...?=" leak through.
+var wordDecoder = &mime.WordDecoder{CharsetReader: charset.Reader}
+
+// decodeHeaderValue decodes RFC 2047 encoded-words in a header value.
+// Values that are already decoded pass through unchanged; if decoding
+// fails the input is returned as-is.
+func decodeHeaderValue(s string) string {
+	if !strings.Contains(s, "=?") {
+		return s
+	}
+	decoded, err := wordDecoder.DecodeHeader(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+// entityCharset returns the lower-cased charset parameter declared in an
+// entity's Content-Type, or "" when none is declared.
+func entityCharset(h gomessage.Header) string {
+	_, params, err := h.ContentType()
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(params["charset"]))
+}
+
+// readTextBody reads a text part that go-message has already converted to
+// UTF-8. Parts in an unknown charset are passed through undecoded, so any
+// invalid UTF-8 sequences are replaced with U+FFFD rather than producing
+// mojibake or corrupting the output stream.
+func readTextBody(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToValidUTF8(string(data), "\uFFFD"), nil
+}
+
+// isRecoverableEntityError reports whether err from go-message still comes
+// with a readable entity (the charset was unknown; the body is left as-is).
+func isRecoverableEntityError(err error) bool {
+	return err == nil || gomessage.IsUnknownCharset(err)
+}
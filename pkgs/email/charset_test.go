@@ -0,0 +1,84 @@
+package email
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/emx-mail/cli/pkgs/testutil"
+)
+
+func TestDecodeHeaderValue(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"GB2312 B", "=?gb2312?B?xOO6ww==?=", "你好"},
+		{"GBK B", "=?GBK?B?1tDOxNPKvP4=?=", "中文邮件"},
+		{"Big5 B", "=?big5?B?p0Gmbg==?=", "你好"},
+		{"ISO-2022-JP B", "=?ISO-2022-JP?B?GyRCRnxLXDhsGyhC?=", "日本語"},
+		{"UTF-8 Q", "=?UTF-8?Q?=E4=B8=AD=E6=96=87?=", "中文"},
+		{"ISO-8859-1 Q", "=?ISO-8859-1?Q?caf=E9?=", "café"},
+		{"KOI8-R Q", "=?koi8-r?Q?=D0=D2=C9=D7=C5=D4?=", "привет"},
+		{"mixed with plain text", "Re: =?gb2312?B?xOO6ww==?= world", "Re: 你好 world"},
+		{"adjacent words", "=?gb2312?B?xOO6ww==?= =?UTF-8?Q?=E4=B8=AD?=", "你好中"},
+		{"plain", "Hello World", "Hello World"},
+		{"already decoded", "项目进展汇报", "项目进展汇报"},
+		{"malformed left as-is", "=?x-bogus?B?AAAA?=", "=?x-bogus?B?AAAA?="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeHeaderValue(tt.in); got != tt.want {
+				t.Errorf("decodeHeaderValue(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPOP3EntityToMessage_EncodedHeaders(t *testing.T) {
+	raw := "From: =?gb2312?B?1cXI/Q==?= <zhangsan@example.com>\r\n" +
+		"To: =?ISO-8859-1?Q?Ren=E9?= <rene@example.com>, plain@example.com\r\n" +
+		"Subject: =?GBK?B?1tDOxNPKvP4=?=\r\n" +
+		"Message-Id: <enc@example.com>\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"body"
+
+	entity := parseTestEntity(t, raw)
+	msg := pop3EntityToMessage(entity, 1)
+
+	if msg.Subject != "中文邮件" {
+		t.Errorf("Subject = %q, want %q", msg.Subject, "中文邮件")
+	}
+	if len(msg.From) != 1 || msg.From[0].Name != "张三" || msg.From[0].Email != "zhangsan@example.com" {
+		t.Errorf("From = %+v", msg.From)
+	}
+	if len(msg.To) != 2 || msg.To[0].Name != "René" {
+		t.Errorf("To = %+v", msg.To)
+	}
+	for _, a := range append(msg.From, msg.To...) {
+		if strings.Contains(a.Name, "=?") {
+			t.Errorf("encoded-word leaked into address name: %q", a.Name)
+		}
+	}
+}
+
+func TestIMAPFetchMessages_EncodedSubject(t *testing.T) {
+	// The subject decodes to text that looks like an encoded-word itself,
+	// which must not be decoded a second time. It is not ASCII, so the test
+	// server encodes its envelope again.
+	const want = "Über =?utf-8?q?x?= syntax"
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessage(t, addr, "INBOX", strings.Replace(testMailRFC822,
+		"Subject: Test Subject", "Subject: =?utf-8?B?w5xiZXIgPT91dGYtOD9xP3g/PSBzeW50YXg=?=", 1))
+	client := newIMAPTestClient(t, addr)
+
+	result, err := client.FetchMessages(FetchOptions{Folder: "INBOX", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Messages[0].Subject; got != want {
+		t.Errorf("Subject = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,136 @@
+package email
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WatchCheckpoint records how far watch --once got through the unprocessed
+// emails of a folder, so an interrupted or bounded run resumes after the
+// last email it handled instead of starting over from the oldest unseen
+// one. The checkpoint is a small JSON file, removed once a run gets
+// through the whole backlog.
+type WatchCheckpoint struct {
+	Path string
+}
+
+// DefaultWatchCheckpoint returns the checkpoint of an account's folder at
+// the default path (~/.emx-mail/watch/<account>/<folder>.json).
+func DefaultWatchCheckpoint(account, folder string) (*WatchCheckpoint, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return &WatchCheckpoint{
+		Path: filepath.Join(home, ".emx-mail", "watch", url.PathEscape(account), url.PathEscape(folder)+".json"),
+	}, nil
+}
+
+// checkpointState is the content of a checkpoint file.
+type checkpointState struct {
+	UIDValidity uint32     `json:"uidvalidity"`
+	LastUID     uint32     `json:"last_uid"`            // Last email handled, processed or failed
+	LastDate    *time.Time `json:"last_date,omitempty"` // Its INTERNALDATE, in a run ordered by date
+	Updated     time.Time  `json:"updated"`
+}
+
+// Load returns the last UID handled, or 0 if there is no checkpoint, it
+// was saved under a different UIDVALIDITY, whose UIDs mean nothing now, or
+// by a run ordered by date.
+func (c *WatchCheckpoint) Load(uidValidity uint32) (uint32, error) {
+	s, err := c.load(uidValidity)
+	if err != nil || s.LastDate != nil {
+		return 0, err
+	}
+	return s.LastUID, nil
+}
+
+// LoadDated is Load for runs ordered by date (WatchOptions.OrderByDate):
+// it returns the last email handled and its INTERNALDATE, or zero values
+// if the checkpoint was saved by a run in UID order.
+func (c *WatchCheckpoint) LoadDated(uidValidity uint32) (uint32, time.Time, error) {
+	s, err := c.load(uidValidity)
+	if err != nil || s.LastDate == nil {
+		return 0, time.Time{}, err
+	}
+	return s.LastUID, *s.LastDate, nil
+}
+
+func (c *WatchCheckpoint) load(uidValidity uint32) (checkpointState, error) {
+	data, err := os.ReadFile(c.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpointState{}, nil
+	}
+	if err != nil {
+		return checkpointState{}, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var s checkpointState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return checkpointState{}, fmt.Errorf("failed to parse checkpoint %s: %w", c.Path, err)
+	}
+	if s.UIDValidity != uidValidity {
+		return checkpointState{}, nil
+	}
+	return s, nil
+}
+
+// Save records uid as the last email handled.
+func (c *WatchCheckpoint) Save(uidValidity, uid uint32) error {
+	return c.save(checkpointState{UIDValidity: uidValidity, LastUID: uid})
+}
+
+// SaveDated records uid, received at date, as the last email handled by a
+// run ordered by date.
+func (c *WatchCheckpoint) SaveDated(uidValidity, uid uint32, date time.Time) error {
+	date = date.UTC()
+	return c.save(checkpointState{UIDValidity: uidValidity, LastUID: uid, LastDate: &date})
+}
+
+func (c *WatchCheckpoint) save(s checkpointState) error {
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	s.Updated = time.Now().UTC()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.Path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.Path)
+}
+
+// Clear removes the checkpoint, so the next run starts from the oldest
+// unseen email again.
+func (c *WatchCheckpoint) Clear() error {
+	if err := os.Remove(c.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// receivedBefore reports whether the email received at d1 with UID u1 is
+// ordered before the one received at d2 with UID u2, the order of a dated
+// checkpoint.
+func receivedBefore(d1 time.Time, u1 uint32, d2 time.Time, u2 uint32) bool {
+	if !d1.Equal(d2) {
+		return d1.Before(d2)
+	}
+	return u1 < u2
+}
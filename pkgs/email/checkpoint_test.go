@@ -0,0 +1,82 @@
+package email
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchCheckpoint(t *testing.T) {
+	cp := &WatchCheckpoint{Path: filepath.Join(t.TempDir(), "work", "INBOX.json")}
+
+	if uid, err := cp.Load(7); err != nil || uid != 0 {
+		t.Fatalf("Load without checkpoint = %d, %v", uid, err)
+	}
+	if err := cp.Save(7, 42); err != nil {
+		t.Fatal(err)
+	}
+	if uid, err := cp.Load(7); err != nil || uid != 42 {
+		t.Errorf("Load = %d, %v; want 42", uid, err)
+	}
+	if uid, _ := cp.Load(8); uid != 0 {
+		t.Errorf("Load after UIDVALIDITY change = %d, want 0", uid)
+	}
+
+	if err := cp.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if uid, _ := cp.Load(7); uid != 0 {
+		t.Errorf("Load after Clear = %d, want 0", uid)
+	}
+	if err := cp.Clear(); err != nil {
+		t.Errorf("Clear without checkpoint: %v", err)
+	}
+}
+
+func TestWatchCheckpointDated(t *testing.T) {
+	cp := &WatchCheckpoint{Path: filepath.Join(t.TempDir(), "INBOX.json")}
+	date := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	if err := cp.SaveDated(7, 42, date); err != nil {
+		t.Fatal(err)
+	}
+	uid, got, err := cp.LoadDated(7)
+	if err != nil || uid != 42 || !got.Equal(date) {
+		t.Errorf("LoadDated = %d, %v, %v; want 42, %v", uid, got, err, date)
+	}
+	if uid, _, _ := cp.LoadDated(8); uid != 0 {
+		t.Errorf("LoadDated after UIDVALIDITY change = %d, want 0", uid)
+	}
+	// Positions in the other order do not apply
+	if uid, _ := cp.Load(7); uid != 0 {
+		t.Errorf("Load of a dated checkpoint = %d, want 0", uid)
+	}
+	if err := cp.Save(7, 42); err != nil {
+		t.Fatal(err)
+	}
+	if uid, _, _ := cp.LoadDated(7); uid != 0 {
+		t.Errorf("LoadDated of a UID checkpoint = %d, want 0", uid)
+	}
+}
+
+func TestReceivedBefore(t *testing.T) {
+	early := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	late := early.Add(time.Minute)
+	tests := []struct {
+		d1   time.Time
+		u1   uint32
+		d2   time.Time
+		u2   uint32
+		want bool
+	}{
+		{early, 90, late, 10, true},
+		{late, 10, early, 90, false},
+		{early, 10, early, 90, true},
+		{early, 90, early, 90, false},
+	}
+	for _, tt := range tests {
+		if got := receivedBefore(tt.d1, tt.u1, tt.d2, tt.u2); got != tt.want {
+			t.Errorf("receivedBefore(%v, %d, %v, %d) = %v, want %v", tt.d1, tt.u1, tt.d2, tt.u2, got, tt.want)
+		}
+	}
+}
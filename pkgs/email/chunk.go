@@ -0,0 +1,72 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// defaultMaxResumes is how many times in a row a chunked fetch reconnects
+// after a network error before giving up, see IMAPConfig.MaxResumes.
+const defaultMaxResumes = 3
+
+// chunkReader reads a message source of known size with ranged fetches of
+// up to chunk bytes. After a network error it calls resume to reconnect and
+// fetches again from the first byte it does not have, so a dropped
+// connection costs one chunk rather than the whole message.
+type chunkReader struct {
+	size   int64 // Message size reported by the server
+	chunk  int64
+	offset int64 // Bytes fetched so far
+	buf    []byte
+
+	// fetch returns the n bytes of the source from offset; fewer at the
+	// end of the message.
+	fetch      func(offset, n int64) ([]byte, error)
+	resume     func() error
+	maxResumes int // In a row
+	resumes    int
+
+	progress func(done, total int64) // Optional, called after every chunk
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.offset >= r.size {
+			return 0, io.EOF
+		}
+		data, err := r.fetch(r.offset, min(r.chunk, r.size-r.offset))
+		if err != nil {
+			if !isNetworkError(err) || r.resumes >= r.maxResumes {
+				return 0, err
+			}
+			r.resumes++
+			if err := r.resume(); err != nil {
+				return 0, fmt.Errorf("failed to resume fetch at byte %d: %w", r.offset, err)
+			}
+			continue
+		}
+		r.resumes = 0
+		if len(data) == 0 {
+			// The source is shorter than its reported size
+			r.size = r.offset
+			return 0, io.EOF
+		}
+		r.buf = data
+		r.offset += int64(len(data))
+		if r.progress != nil {
+			r.progress(r.offset, r.size)
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// isNetworkError reports whether err comes from the connection to a server
+// rather than from the server: a reconnect may get past it.
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
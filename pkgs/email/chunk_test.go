@@ -0,0 +1,100 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestChunkReader(t *testing.T) {
+	source := strings.Repeat("0123456789", 10)
+	var fetches []string
+	drops := map[int64]int{30: 2} // Offset: connection drops before it arrives
+	resumes := 0
+	var progress []int64
+	r := &chunkReader{
+		size:  int64(len(source)),
+		chunk: 25,
+		fetch: func(offset, n int64) ([]byte, error) {
+			fetches = append(fetches, fmt.Sprintf("%d+%d", offset, n))
+			if offset > 0 && offset < 50 && drops[30] > 0 {
+				drops[30]--
+				return nil, &net.OpError{Op: "read", Err: io.ErrUnexpectedEOF}
+			}
+			return []byte(source[offset : offset+n]), nil
+		},
+		resume:     func() error { resumes++; return nil },
+		maxResumes: 2,
+		progress:   func(done, total int64) { progress = append(progress, done) },
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll() error: %v", err)
+	}
+	if string(got) != source {
+		t.Errorf("read %q, want %q", got, source)
+	}
+	// The dropped chunk is fetched again, not the ones before it
+	if want := "[0+25 25+25 25+25 25+25 50+25 75+25]"; fmt.Sprint(fetches) != want {
+		t.Errorf("fetches = %v, want %s", fetches, want)
+	}
+	if resumes != 2 || fmt.Sprint(progress) != "[25 50 75 100]" {
+		t.Errorf("resumes = %d, progress = %v", resumes, progress)
+	}
+}
+
+func TestChunkReader_Errors(t *testing.T) {
+	dropped := &net.OpError{Op: "read", Err: io.EOF}
+	r := &chunkReader{
+		size:       100,
+		chunk:      10,
+		fetch:      func(offset, n int64) ([]byte, error) { return nil, dropped },
+		resume:     func() error { return nil },
+		maxResumes: 3,
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, dropped) || r.resumes != 3 {
+		t.Errorf("ReadAll() = %v after %d resumes, want the network error after 3", err, r.resumes)
+	}
+
+	// Server errors are not retried
+	refused := errors.New("NO message gone")
+	r = &chunkReader{
+		size:   100,
+		chunk:  10,
+		fetch:  func(offset, n int64) ([]byte, error) { return nil, refused },
+		resume: func() error { t.Error("resume called for a server error"); return nil },
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, refused) {
+		t.Errorf("ReadAll() = %v, want %v", err, refused)
+	}
+
+	// A failed reconnect ends the read
+	r = &chunkReader{
+		size:       100,
+		chunk:      10,
+		fetch:      func(offset, n int64) ([]byte, error) { return nil, dropped },
+		resume:     func() error { return ErrUIDValidityChanged },
+		maxResumes: 3,
+	}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrUIDValidityChanged) {
+		t.Errorf("ReadAll() = %v, want %v", err, ErrUIDValidityChanged)
+	}
+
+	// A source shorter than its reported size ends early
+	r = &chunkReader{
+		size:  100,
+		chunk: 60,
+		fetch: func(offset, n int64) ([]byte, error) {
+			if offset > 0 {
+				return nil, nil
+			}
+			return make([]byte, n), nil
+		},
+	}
+	if got, err := io.ReadAll(r); err != nil || len(got) != 60 {
+		t.Errorf("ReadAll() = %d bytes, %v; want 60 bytes", len(got), err)
+	}
+}
@@ -0,0 +1,38 @@
+package email
+
+import (
+	"crypto/rand"
+	"io"
+	"time"
+)
+
+// Clock is the time source of SMTPClient and IMAPClient: the Date and
+// Message-ID of composed messages, retry and reconnect delays, and the
+// arrival times and uptime of Watch. Tests set a fake, such as
+// testutil.FakeClock, to make these deterministic.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the Clock used when none is configured.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// clockOrSystem returns c, or the system clock if c is nil.
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return systemClock{}
+	}
+	return c
+}
+
+// randOrCrypto returns r, or crypto/rand if r is nil.
+func randOrCrypto(r io.Reader) io.Reader {
+	if r == nil {
+		return rand.Reader
+	}
+	return r
+}
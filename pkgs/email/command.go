@@ -0,0 +1,308 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// maxCommandEmailSize bounds the size of command emails, which are read
+// into memory; larger ones are rejected.
+const maxCommandEmailSize = 1 << 20
+
+// Commands turn a watched folder into an automation inbox: an email whose
+// subject, or else the first line of its new text (see StripQuotedText),
+// is a directive such as "approve 1234" runs the command of that name,
+// provided the sender is allowed and authenticated by the receiving
+// server. Emails without a directive are handled as usual; commands from
+// unauthenticated senders are rejected and marked as processed.
+type Commands struct {
+	Commands []MailCommand
+
+	// AllowedSenders are the addresses ("ops@example.com") and domains
+	// ("@example.com") that may send commands. Required: there is no
+	// default.
+	AllowedSenders []string
+
+	// AuthServID is the receiving server. A command also needs a passing
+	// DKIM signature of the From domain (or a parent domain), or a
+	// passing DMARC check of it, recorded in an Authentication-Results
+	// header of this server above the first Received header, i.e. one the
+	// server added on delivery. The sender can write any header, this
+	// server's name included, so headers further down, of other hosts or
+	// of ARC sets, whose chain is not validated, are ignored. Required
+	// unless InsecureTrustFrom.
+	AuthServID string
+
+	// InsecureTrustFrom accepts allowed senders by their From address
+	// alone. Anyone can write any From address, so anyone who guesses an
+	// allowed one can then run the commands: only set it when the server
+	// rejects forged mail before it reaches the folder.
+	InsecureTrustFrom bool
+}
+
+// MailCommand is a command of an automation inbox, handled by Func or, for
+// configured commands, by the handler command Cmd.
+type MailCommand struct {
+	Name string // Directive word, matched case-insensitively
+
+	// Cmd is run like WatchOptions.HandlerCmd, with the email on stdin and
+	// EMX_COMMAND and EMX_COMMAND_ARGS (the arguments separated by spaces)
+	// added to the environment.
+	Cmd   string
+	Shell string // As WatchOptions.HandlerShell
+
+	Func CommandFunc
+}
+
+// CommandFunc handles a command email in process; raw is the full email.
+// Returning an error leaves the email unseen, to be tried again later.
+type CommandFunc func(ctx context.Context, cmd Command, raw []byte) error
+
+// Command is a directive found in an email by Commands.
+type Command struct {
+	Name      string   // MailCommand.Name of the command
+	Args      []string // Words after the name
+	From      string   // Authenticated sender address
+	UID       uint32
+	MessageID string
+}
+
+// Register adds a command handled by fn, for Go programs.
+func (c *Commands) Register(name string, fn CommandFunc) {
+	c.Commands = append(c.Commands, MailCommand{Name: name, Func: fn})
+}
+
+// validate checks the commands and the sender authentication settings.
+func (c *Commands) validate() error {
+	if len(c.AllowedSenders) == 0 {
+		return fmt.Errorf("commands need allowed senders")
+	}
+	if !c.InsecureTrustFrom && c.AuthServID == "" {
+		return fmt.Errorf("commands need the authserv-id of the receiving server to authenticate senders")
+	}
+	seen := make(map[string]bool)
+	for _, cmd := range c.Commands {
+		name := strings.ToLower(cmd.Name)
+		switch {
+		case name == "" || strings.ContainsAny(name, " \t"):
+			return fmt.Errorf("invalid command name %q", cmd.Name)
+		case seen[name]:
+			return fmt.Errorf("command %s is defined twice", cmd.Name)
+		case cmd.Func == nil && strings.TrimSpace(cmd.Cmd) == "":
+			return fmt.Errorf("command %s has no handler", cmd.Name)
+		}
+		seen[name] = true
+		if cmd.Func == nil {
+			if _, err := handlerCommand(cmd.Shell, cmd.Cmd); err != nil {
+				return fmt.Errorf("command %s: %w", cmd.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// find returns the command named by the first word of directive, and the
+// remaining words, or nil.
+func (c *Commands) find(directive string) (*MailCommand, []string) {
+	words := strings.Fields(directive)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	for i := range c.Commands {
+		if strings.EqualFold(c.Commands[i].Name, words[0]) {
+			return &c.Commands[i], words[1:]
+		}
+	}
+	return nil, nil
+}
+
+// Parse returns the command a directive in subject or, failing that, in
+// the first line of the new text of the email raw calls, with its
+// arguments, or nil. Reply and forward markers before the subject are
+// ignored. raw may be nil to look at the subject only.
+func (c *Commands) Parse(subject string, raw []byte) (*MailCommand, []string) {
+	if cmd, args := c.find(reReplyPrefix.ReplaceAllString(subject, "")); cmd != nil {
+		return cmd, args
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	msg, err := ParseBody(bytes.NewReader(raw))
+	if err != nil {
+		return nil, nil
+	}
+	text := msg.TextBody
+	if strings.TrimSpace(text) == "" {
+		text = htmlToPreviewText(msg.HTMLBody)
+	}
+	for _, line := range strings.Split(StripQuotedText(text), "\n") {
+		if strings.TrimSpace(line) != "" {
+			return c.find(line)
+		}
+	}
+	return nil, nil
+}
+
+// Authenticate returns why from may not send commands, or nil if it may.
+// h is the header of the email, read for the authentication results of
+// AuthServID.
+func (c *Commands) Authenticate(from string, h textproto.Header) error {
+	from = strings.ToLower(strings.TrimSpace(from))
+	at := strings.LastIndex(from, "@")
+	if at <= 0 {
+		return fmt.Errorf("invalid sender %q", from)
+	}
+	domain := from[at+1:]
+
+	allowed := false
+	for _, s := range c.AllowedSenders {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == from || (strings.HasPrefix(s, "@") && s[1:] == domain) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("sender %s is not allowed", from)
+	}
+
+	if !c.InsecureTrustFrom && !senderAuthenticated(h, c.AuthServID, domain) {
+		return fmt.Errorf("no passing DKIM signature or DMARC check of %s from %s", domain, c.AuthServID)
+	}
+	return nil
+}
+
+// senderAuthenticated reports whether an Authentication-Results header
+// (RFC 8601) of authServID in h records a passing DKIM signature whose
+// domain (header.d) is domain or a parent of it, or a passing DMARC check
+// of domain (header.from). Only the headers above the first Received
+// header count: the receiving server prepended those, while the ones
+// below came with the message (RFC 8601 section 5).
+func senderAuthenticated(h textproto.Header, authServID, domain string) bool {
+	fields := h.Fields()
+	for fields.Next() {
+		if strings.EqualFold(fields.Key(), "Received") {
+			return false
+		}
+		if !strings.EqualFold(fields.Key(), "Authentication-Results") {
+			continue
+		}
+		parts := strings.Split(stripHeaderComments(fields.Value()), ";")
+		// The authserv-id may carry a version: "mx.example.com 1"
+		if f := strings.Fields(parts[0]); len(f) == 0 || !strings.EqualFold(f[0], authServID) {
+			continue
+		}
+		for _, part := range parts[1:] {
+			if resultPasses(strings.Fields(part), domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resultPasses reports whether the words of one authentication result,
+// "dkim=pass header.d=example.com ...", pass for domain.
+func resultPasses(words []string, domain string) bool {
+	if len(words) == 0 {
+		return false
+	}
+	var prop string
+	switch strings.ToLower(words[0]) {
+	case "dkim=pass":
+		prop = "header.d="
+	case "dmarc=pass":
+		prop = "header.from="
+	default:
+		return false
+	}
+	for _, w := range words[1:] {
+		d, ok := strings.CutPrefix(strings.ToLower(w), prop)
+		if !ok {
+			continue
+		}
+		// DMARC already checked alignment: header.from is the From domain
+		if domain == d || (prop == "header.d=" && strings.HasSuffix(domain, "."+d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandEnv returns the handler environment of a command email.
+func commandEnv(env []string, cmd *MailCommand, args []string) []string {
+	clean := strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ", "\x00", " ")
+	return append(env,
+		"EMX_COMMAND="+strings.ToLower(cmd.Name),
+		"EMX_COMMAND_ARGS="+clean.Replace(strings.Join(args, " ")))
+}
+
+// runCommand runs the command directive of the email uid, if it has one,
+// and reports whether it did. raw is the start of the email, complete if
+// it has at most maxCommandEmailSize bytes. Rejected commands count as
+// handled, so the caller marks them as processed.
+func (c *IMAPClient) runCommand(ctx context.Context, uid uint32, opts WatchOptions, meta *EmailMetadata, raw []byte, statusWrite func(WatchStatus)) (bool, error) {
+	body := raw
+	if len(body) > maxCommandEmailSize {
+		body = nil
+	}
+	cmd, args := opts.Commands.Parse(meta.Subject, body)
+	if cmd == nil {
+		return false, nil
+	}
+
+	h, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return true, fmt.Errorf("failed to parse header: %w", err)
+	}
+	err = opts.Commands.Authenticate(meta.From, h)
+	if err == nil && body == nil {
+		err = fmt.Errorf("email is larger than %d bytes", maxCommandEmailSize)
+	}
+	if err != nil {
+		statusWrite(WatchStatus{
+			Type:    "command",
+			Level:   "warn",
+			Message: fmt.Sprintf("Rejected command %s in UID %d: %v", cmd.Name, uid, err),
+			UID:     uid,
+		})
+		return true, nil
+	}
+
+	statusWrite(WatchStatus{
+		Type:    "command",
+		Level:   "info",
+		Message: fmt.Sprintf("Running command %s %s from %s for UID %d", cmd.Name, strings.Join(args, " "), meta.From, uid),
+		UID:     uid,
+	})
+	if cmd.Func != nil {
+		err := cmd.Func(ctx, Command{
+			Name:      cmd.Name,
+			Args:      args,
+			From:      meta.From,
+			UID:       uid,
+			MessageID: meta.MessageID,
+		}, raw)
+		if err != nil {
+			return true, fmt.Errorf("command %s failed: %w", cmd.Name, err)
+		}
+		return true, nil
+	}
+
+	env := commandEnv(handlerEnv(opts.Account, opts.Folder, uid, meta), cmd, args)
+	grace := time.Duration(opts.ShutdownGrace) * time.Second
+	exitCode, err := c.runHandler(ctx, cmd.Shell, cmd.Cmd, env, grace, bytes.NewReader(raw))
+	if err != nil {
+		return true, fmt.Errorf("command %s failed: %w", cmd.Name, err)
+	}
+	if exitCode != 0 {
+		return true, fmt.Errorf("command %s failed with exit code %d", cmd.Name, exitCode)
+	}
+	return true, nil
+}
@@ -0,0 +1,126 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+func testCommands() *Commands {
+	c := &Commands{AllowedSenders: []string{"ops@example.com", "@corp.example"}, AuthServID: "mx.corp.example"}
+	c.Register("approve", func(context.Context, Command, []byte) error { return nil })
+	c.Commands = append(c.Commands, MailCommand{Name: "Deploy", Cmd: "deploy.sh"})
+	return c
+}
+
+func TestCommandsParse(t *testing.T) {
+	c := testCommands()
+	tests := []struct {
+		name    string
+		subject string
+		body    string
+		want    string // Command name and arguments, "" for none
+	}{
+		{"subject", "approve 1234", "", "approve 1234"},
+		{"reply subject", "Re: AW: APPROVE 1234 now", "", "approve 1234 now"},
+		{"case", "deploy staging", "", "Deploy staging"},
+		{"unknown", "approved 1234", "", ""},
+		{"body", "Order 1234", "\r\n  approve 1234\r\nThanks\r\n", "approve 1234"},
+		{"body quote", "Re: Order 1234", "deploy prod\r\n\r\nOn Mon, Bob wrote:\r\n> approve 1\r\n", "Deploy prod"},
+		{"body only first line", "Order 1234", "Looks good\r\napprove 1234\r\n", ""},
+	}
+	for _, tt := range tests {
+		raw := []byte("Subject: " + tt.subject + "\r\nContent-Type: text/plain\r\n\r\n" + tt.body)
+		cmd, args := c.Parse(tt.subject, raw)
+		got := ""
+		if cmd != nil {
+			got = strings.Join(append([]string{cmd.Name}, args...), " ")
+		}
+		if got != tt.want {
+			t.Errorf("%s: Parse() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if cmd, _ := c.Parse("Order", nil); cmd != nil {
+		t.Errorf("Parse() without body = %s, want none", cmd.Name)
+	}
+}
+
+func TestCommandsAuthenticate(t *testing.T) {
+	header := func(s string) textproto.Header {
+		h, err := textproto.ReadHeader(bufio.NewReader(strings.NewReader(s + "\r\n")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	const received = "Received: from mail.example.net by mx.corp.example; Mon, 1 Jan 2024 00:00:00 +0000\r\n"
+	signed := header("Authentication-Results: mx.corp.example;\r\n" +
+		" dkim=pass (2048-bit key) header.d=corp.example header.s=sel;\r\n spf=pass\r\n" + received)
+	forged := header("Authentication-Results: mx.corp.example; dkim=fail header.d=corp.example\r\n" +
+		"Authentication-Results: evil.example; dkim=pass header.d=corp.example\r\n" + received)
+	dmarc := header("Authentication-Results: mx.corp.example 1; dkim=pass header.d=mailer.example;\r\n" +
+		" dmarc=pass (p=reject) header.from=example.com\r\n" + received)
+	// Results the sender wrote: below the receiving server's Received
+	// header, or in an ARC set
+	injected := header(received + "Authentication-Results: mx.corp.example; dkim=pass header.d=corp.example\r\n")
+	arc := header("ARC-Authentication-Results: i=1; mx.corp.example; dkim=pass header.d=corp.example\r\n" + received)
+
+	c := testCommands()
+	if err := c.Authenticate("alice@corp.example", signed); err != nil {
+		t.Errorf("Authenticate(signed) error: %v", err)
+	}
+	if err := c.Authenticate("ops@example.com", dmarc); err != nil {
+		t.Errorf("Authenticate(dmarc) error: %v", err)
+	}
+	if err := c.Authenticate("ops@example.com", signed); err == nil {
+		t.Error("Authenticate() with a signature of another domain should fail")
+	}
+	if err := c.Authenticate("alice@corp.example", dmarc); err == nil {
+		t.Error("Authenticate() with a DMARC pass of another domain should fail")
+	}
+	if err := c.Authenticate("alice@corp.example", forged); err == nil {
+		t.Error("Authenticate() with a pass from another authserv-id should fail")
+	}
+	if err := c.Authenticate("alice@corp.example", injected); err == nil {
+		t.Error("Authenticate() with results below the Received header should fail")
+	}
+	if err := c.Authenticate("alice@corp.example", arc); err == nil {
+		t.Error("Authenticate() with ARC results should fail")
+	}
+	// The From address alone is not enough by default
+	if err := c.Authenticate("alice@corp.example", textproto.Header{}); err == nil {
+		t.Error("Authenticate() without authentication results should fail")
+	}
+
+	c.InsecureTrustFrom = true
+	for _, from := range []string{"ops@example.com", "OPS@Example.com", "alice@corp.example"} {
+		if err := c.Authenticate(from, textproto.Header{}); err != nil {
+			t.Errorf("Authenticate(%s) error: %v", from, err)
+		}
+	}
+	for _, from := range []string{"eve@example.com", "alice@sub.corp.example", "alice@corp.example.evil", ""} {
+		if err := c.Authenticate(from, textproto.Header{}); err == nil {
+			t.Errorf("Authenticate(%s) should fail", from)
+		}
+	}
+}
+
+func TestCommandsValidate(t *testing.T) {
+	if err := testCommands().validate(); err != nil {
+		t.Errorf("validate() error: %v", err)
+	}
+	for name, c := range map[string]*Commands{
+		"no senders":  {Commands: []MailCommand{{Name: "a", Cmd: "a"}}},
+		"no authserv": {AllowedSenders: []string{"@x"}},
+		"no handler":  {AllowedSenders: []string{"@x"}, Commands: []MailCommand{{Name: "a"}}},
+		"duplicate":   {AllowedSenders: []string{"@x"}, Commands: []MailCommand{{Name: "a", Cmd: "a"}, {Name: "A", Cmd: "b"}}},
+		"bad name":    {AllowedSenders: []string{"@x"}, Commands: []MailCommand{{Name: "a b", Cmd: "a"}}},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("validate(%s) should fail", name)
+		}
+	}
+}
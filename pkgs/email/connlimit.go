@@ -0,0 +1,174 @@
+package email
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/emx-mail/cli/pkgs/flock"
+)
+
+// DefaultMaxConnections is the per-server connection limit of a
+// ConnLimiter without Max. Gmail allows 15 IMAP connections per account.
+const DefaultMaxConnections = 10
+
+// ConnLimiter caps the connections open at once to each server, across
+// goroutines and emx-mail processes, and makes them back off together when
+// a server refuses a connection for having too many open. Its state lives
+// in files below Dir, one directory per server:
+//
+//	<Dir>/<host:port>/
+//	├── slot.1.lock ... slot.<Max>.lock   # Locked while a connection is open
+//	└── backoff.json                      # Recent refusals and when to retry
+type ConnLimiter struct {
+	Dir  string
+	Max  int           // Connections per server; <= 0 means DefaultMaxConnections
+	Wait time.Duration // How long Acquire waits for a slot or backoff; <= 0 means 2 minutes
+}
+
+// DefaultConnLimiter creates a ConnLimiter using the default path
+// (~/.emx-mail/conn/).
+func DefaultConnLimiter(max int) (*ConnLimiter, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return &ConnLimiter{Dir: filepath.Join(home, ".emx-mail", "conn"), Max: max}, nil
+}
+
+const (
+	connSlotRetryInterval = 200 * time.Millisecond
+	connBackoffBase       = 5 * time.Second
+	connBackoffMax        = 5 * time.Minute
+)
+
+// connBackoff is the content of backoff.json.
+type connBackoff struct {
+	Failures int       `json:"failures"` // Refusals in a row
+	Until    time.Time `json:"until"`    // No connection attempts before this
+}
+
+// Acquire waits until a backoff after refusals has passed and a connection
+// slot to addr is free, and takes the slot. release frees it again.
+func (l *ConnLimiter) Acquire(addr string) (release func(), err error) {
+	dir := l.dir(addr)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	max := l.Max
+	if max <= 0 {
+		max = DefaultMaxConnections
+	}
+	wait := l.Wait
+	if wait <= 0 {
+		wait = 2 * time.Minute
+	}
+	deadline := time.Now().Add(wait)
+
+	waiting := false
+	for {
+		if until := l.readBackoff(dir).Until; time.Now().Before(until) {
+			if until.After(deadline) {
+				return nil, fmt.Errorf("%s refused connections for too many open; backing off until %s",
+					addr, until.Local().Format("15:04:05"))
+			}
+			fmt.Fprintf(os.Stderr, "Waiting until %s: %s refused a connection for too many open\n",
+				until.Local().Format("15:04:05"), addr)
+			time.Sleep(time.Until(until))
+			continue
+		}
+
+		for i := 1; i <= max; i++ {
+			f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("slot.%d.lock", i)), os.O_CREATE|os.O_RDWR, 0o600)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open connection slot: %w", err)
+			}
+			ok, err := flock.TryLock(f, true)
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("failed to lock connection slot: %w", err)
+			}
+			if ok {
+				return func() {
+					flock.Unlock(f)
+					f.Close()
+				}, nil
+			}
+			f.Close()
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("all %d connections to %s are in use (raise max_connections if the server allows more)", max, addr)
+		}
+		if !waiting {
+			fmt.Fprintf(os.Stderr, "Waiting for one of %d connections to %s to close\n", max, addr)
+			waiting = true
+		}
+		time.Sleep(connSlotRetryInterval)
+	}
+}
+
+// Report records the outcome of a connection attempt to addr. A refusal
+// for too many connections starts or doubles the shared backoff, up to 5
+// minutes; a successful connection ends it. Other errors are ignored.
+func (l *ConnLimiter) Report(addr string, err error) {
+	dir := l.dir(addr)
+	if err == nil {
+		if rerr := os.Remove(filepath.Join(dir, "backoff.json")); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Warning: failed to clear connection backoff: %v\n", rerr)
+		}
+		return
+	}
+	if !IsTooManyConnections(err) {
+		return
+	}
+
+	b := l.readBackoff(dir)
+	b.Failures++
+	delay := connBackoffBase << (b.Failures - 1)
+	if b.Failures > 10 || delay > connBackoffMax {
+		delay = connBackoffMax
+	}
+	b.Until = time.Now().Add(delay)
+
+	data, _ := json.Marshal(b)
+	tmp := filepath.Join(dir, fmt.Sprintf(".backoff-%d", os.Getpid()))
+	if werr := os.WriteFile(tmp, data, 0o600); werr == nil {
+		werr = os.Rename(tmp, filepath.Join(dir, "backoff.json"))
+		if werr != nil {
+			os.Remove(tmp)
+		}
+	}
+}
+
+func (l *ConnLimiter) dir(addr string) string {
+	return filepath.Join(l.Dir, url.PathEscape(strings.ToLower(addr)))
+}
+
+// readBackoff returns the backoff state; a missing or unreadable file
+// means no backoff.
+func (l *ConnLimiter) readBackoff(dir string) connBackoff {
+	var b connBackoff
+	if data, err := os.ReadFile(filepath.Join(dir, "backoff.json")); err == nil {
+		json.Unmarshal(data, &b)
+	}
+	return b
+}
+
+// IsTooManyConnections reports whether err is a server refusing a
+// connection or login because too many connections are open: the RFC 5530
+// [LIMIT] response code, or the wording Gmail, Dovecot and others use.
+func IsTooManyConnections(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "[limit]") ||
+		strings.Contains(msg, "too many") && strings.Contains(msg, "connection") ||
+		strings.Contains(msg, "maximum number of connections")
+}
@@ -0,0 +1,94 @@
+package email
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConnLimiterSlots(t *testing.T) {
+	l := &ConnLimiter{Dir: t.TempDir(), Max: 2, Wait: 300 * time.Millisecond}
+
+	release1, err := l.Acquire("imap.example.com:993")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release2, err := l.Acquire("imap.example.com:993")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other servers have their own slots
+	releaseOther, err := l.Acquire("imap.example.org:993")
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseOther()
+
+	if _, err := l.Acquire("imap.example.com:993"); err == nil {
+		t.Fatal("third connection should not get a slot")
+	}
+
+	// A slot freed while waiting is taken
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		release1()
+	}()
+	release3, err := l.Acquire("IMAP.example.com:993")
+	if err != nil {
+		t.Fatalf("slot not taken after release: %v", err)
+	}
+	release2()
+	release3()
+}
+
+func TestConnLimiterBackoff(t *testing.T) {
+	l := &ConnLimiter{Dir: t.TempDir(), Wait: 100 * time.Millisecond}
+	addr := "imap.example.com:993"
+	if release, err := l.Acquire(addr); err != nil {
+		t.Fatal(err)
+	} else {
+		release()
+	}
+
+	// Other errors do not back off
+	l.Report(addr, errors.New("IMAP authentication failed: imap: NO [AUTHENTICATIONFAILED] Invalid credentials"))
+	if release, err := l.Acquire(addr); err != nil {
+		t.Fatalf("backoff after an unrelated error: %v", err)
+	} else {
+		release()
+	}
+
+	l.Report(addr, errors.New("IMAP authentication failed: imap: NO [ALERT] Too many simultaneous connections. (Failure)"))
+	if got := l.readBackoff(l.dir(addr)); got.Failures != 1 || time.Until(got.Until) < 4*time.Second {
+		t.Fatalf("backoff = %+v", got)
+	}
+	if _, err := l.Acquire(addr); err == nil {
+		t.Fatal("Acquire should fail during a backoff longer than Wait")
+	}
+
+	l.Report(addr, errors.New("imap: NO [LIMIT] too many"))
+	if got := l.readBackoff(l.dir(addr)); got.Failures != 2 || time.Until(got.Until) < 9*time.Second {
+		t.Errorf("second refusal should double the backoff: %+v", got)
+	}
+
+	l.Report(addr, nil)
+	if release, err := l.Acquire(addr); err != nil {
+		t.Fatalf("backoff not cleared by a successful connection: %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestIsTooManyConnections(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"imap: NO [LIMIT] Too many connections":                                       true,
+		"imap: NO [ALERT] Too many simultaneous connections. (Failure)":               true,
+		"imap: BYE Maximum number of connections from user+IP exceeded (mail_max=10)": true,
+		"imap: NO [AUTHENTICATIONFAILED] Invalid credentials (Failure)":               false,
+		"dial tcp: connection refused":                                                false,
+	} {
+		if got := IsTooManyConnections(errors.New(msg)); got != want {
+			t.Errorf("IsTooManyConnections(%q) = %v, want %v", msg, got, want)
+		}
+	}
+}
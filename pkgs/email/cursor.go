@@ -0,0 +1,48 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrCursorExpired is returned when a cursor refers to an earlier
+// incarnation of a folder (its IMAP UIDVALIDITY changed), so its UIDs no
+// longer identify the same messages.
+var ErrCursorExpired = errors.New("cursor expired")
+
+// Cursor marks a position in a folder for paginated listing. A page fetched
+// with FetchOptions.Before holds only messages older than UID, so new mail
+// arriving between invocations does not shift the pages.
+//
+// For IMAP, UID is a message UID and UIDValidity the folder's UIDVALIDITY
+// (0 means unchecked). For POP3, UID is the message number.
+type Cursor struct {
+	UIDValidity uint32
+	UID         uint32
+}
+
+// String formats the cursor as "<uidvalidity>:<uid>", the form accepted by
+// ParseCursor.
+func (c Cursor) String() string {
+	return fmt.Sprintf("%d:%d", c.UIDValidity, c.UID)
+}
+
+// ParseCursor parses a cursor produced by Cursor.String. A bare "<uid>" is
+// accepted too and leaves UIDValidity unchecked.
+func ParseCursor(s string) (Cursor, error) {
+	validity, uid, found := strings.Cut(strings.TrimSpace(s), ":")
+	if !found {
+		validity, uid = "0", validity
+	}
+	v, err := strconv.ParseUint(validity, 10, 32)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", s)
+	}
+	u, err := strconv.ParseUint(uid, 10, 32)
+	if err != nil || u == 0 {
+		return Cursor{}, fmt.Errorf("invalid cursor %q", s)
+	}
+	return Cursor{UIDValidity: uint32(v), UID: uint32(u)}, nil
+}
@@ -0,0 +1,35 @@
+package email
+
+import "testing"
+
+func TestParseCursor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Cursor
+		wantErr bool
+	}{
+		{in: "1700000000:4711", want: Cursor{UIDValidity: 1700000000, UID: 4711}},
+		{in: "42", want: Cursor{UID: 42}},
+		{in: " 0:7 ", want: Cursor{UID: 7}},
+		{in: "", wantErr: true},
+		{in: "1:0", wantErr: true},
+		{in: "x:1", wantErr: true},
+		{in: "1:2:3", wantErr: true},
+		{in: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCursor(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCursor(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseCursor(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	c := Cursor{UIDValidity: 9, UID: 3}
+	if back, err := ParseCursor(c.String()); err != nil || back != c {
+		t.Errorf("round trip of %v = %+v, %v", c, back, err)
+	}
+}
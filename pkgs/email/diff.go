@@ -0,0 +1,199 @@
+package email
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	gomessage "github.com/emersion/go-message"
+)
+
+// MessageDiff is what differs between two messages, see DiffMessages.
+type MessageDiff struct {
+	Headers     []HeaderDiff // Fields with different values, in order of appearance
+	Text        []DiffLine   // Line diff of the text bodies; nil if they are equal
+	HTML        []DiffLine   // Line diff of the HTML bodies; nil if they are equal
+	Attachments []DiffLine   // Diff of one line per attachment; nil if they are equal
+}
+
+// Empty reports whether the messages were found equal.
+func (d *MessageDiff) Empty() bool {
+	return len(d.Headers) == 0 && d.Text == nil && d.HTML == nil && d.Attachments == nil
+}
+
+// HeaderDiff is a header field whose values differ between two messages.
+type HeaderDiff struct {
+	Name string
+	A    []string // Decoded values in the first message; none if it lacks the field
+	B    []string // Decoded values in the second message
+}
+
+// DiffLine is a line of a line diff.
+type DiffLine struct {
+	Op   byte // ' ' in both, '-' only in the first, '+' only in the second
+	Text string
+}
+
+// maxDiffCells bounds the table of the line diff; larger inputs are shown
+// as entirely replaced.
+const maxDiffCells = 1 << 22
+
+// DiffMessages compares two RFC 5322 messages: their header fields, with
+// encoded words decoded and folding removed, and their bodies, decoded and
+// normalized (line endings and trailing whitespace ignored), so that only
+// differences a reader would notice remain. Header fields named in ignore
+// (case-insensitive) are skipped, e.g. Received or Date.
+func DiffMessages(a, b []byte, ignore []string) (*MessageDiff, error) {
+	ma, ha, err := parseDiffMessage(a)
+	if err != nil {
+		return nil, fmt.Errorf("first message: %w", err)
+	}
+	mb, hb, err := parseDiffMessage(b)
+	if err != nil {
+		return nil, fmt.Errorf("second message: %w", err)
+	}
+
+	d := &MessageDiff{}
+	skip := make(map[string]bool, len(ignore))
+	for _, name := range ignore {
+		skip[strings.ToLower(name)] = true
+	}
+	var names []string
+	values := map[string][2][]string{}
+	for i, h := range []gomessage.Header{ha, hb} {
+		for fs := h.Fields(); fs.Next(); {
+			name := fs.Key()
+			if skip[strings.ToLower(name)] {
+				continue
+			}
+			v, err := fs.Text()
+			if err != nil {
+				v = fs.Value()
+			}
+			pair, ok := values[name]
+			if !ok {
+				names = append(names, name)
+			}
+			pair[i] = append(pair[i], strings.Join(strings.Fields(v), " "))
+			values[name] = pair
+		}
+	}
+	for _, name := range names {
+		pair := values[name]
+		if !slices.Equal(pair[0], pair[1]) {
+			d.Headers = append(d.Headers, HeaderDiff{Name: name, A: pair[0], B: pair[1]})
+		}
+	}
+
+	d.Text = diffChanged(DiffLines(bodyLines(ma.TextBody), bodyLines(mb.TextBody)))
+	d.HTML = diffChanged(DiffLines(bodyLines(ma.HTMLBody), bodyLines(mb.HTMLBody)))
+	d.Attachments = diffChanged(DiffLines(attachmentLines(ma), attachmentLines(mb)))
+	return d, nil
+}
+
+// parseDiffMessage parses raw into its bodies and header.
+func parseDiffMessage(raw []byte) (*Message, gomessage.Header, error) {
+	entity, err := gomessage.Read(bytes.NewReader(raw))
+	if !isRecoverableEntityError(err) {
+		return nil, gomessage.Header{}, err
+	}
+	msg := &Message{}
+	parseEntityBody(msg, entity)
+	return msg, entity.Header, nil
+}
+
+// bodyLines splits a body into lines without trailing whitespace, dropping
+// trailing blank lines.
+func bodyLines(body string) []string {
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t\r")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// attachmentLines describes each attachment of msg on a line.
+func attachmentLines(msg *Message) []string {
+	var lines []string
+	for _, att := range msg.Attachments {
+		sum := sha256.Sum256(att.Data)
+		lines = append(lines, fmt.Sprintf("%s (%s, %d bytes, sha256 %s)",
+			att.Filename, att.ContentType, len(att.Data), hex.EncodeToString(sum[:6])))
+	}
+	return lines
+}
+
+// diffChanged returns lines, or nil if no line differs.
+func diffChanged(lines []DiffLine) []DiffLine {
+	for _, l := range lines {
+		if l.Op != ' ' {
+			return lines
+		}
+	}
+	return nil
+}
+
+// DiffLines returns a line diff turning a into b, keeping the longest
+// common subsequence of lines.
+func DiffLines(a, b []string) []DiffLine {
+	var out []DiffLine
+	// Lines shared at both ends need no table
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		out = append(out, DiffLine{' ', a[pre]})
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	if len(ma)*len(mb) > maxDiffCells {
+		for _, l := range ma {
+			out = append(out, DiffLine{'-', l})
+		}
+		for _, l := range mb {
+			out = append(out, DiffLine{'+', l})
+		}
+	} else {
+		// lcs[i][j] is the LCS length of ma[i:] and mb[j:]
+		w := len(mb) + 1
+		lcs := make([]int32, (len(ma)+1)*w)
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+				} else {
+					lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				out = append(out, DiffLine{' ', ma[i]})
+				i++
+				j++
+			case i < len(ma) && (j == len(mb) || lcs[(i+1)*w+j] >= lcs[i*w+j+1]):
+				out = append(out, DiffLine{'-', ma[i]})
+				i++
+			default:
+				out = append(out, DiffLine{'+', mb[j]})
+				j++
+			}
+		}
+	}
+
+	for _, l := range a[len(a)-suf:] {
+		out = append(out, DiffLine{' ', l})
+	}
+	return out
+}
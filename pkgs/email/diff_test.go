@@ -0,0 +1,79 @@
+package email
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string // Ops and lines, one per line
+	}{
+		{"a b c", "a b c", "  a|  b|  c"},
+		{"a b c", "a x c", "  a|- b|+ x|  c"},
+		{"a b", "a b c", "  a|  b|+ c"},
+		{"x a b", "a b", "- x|  a|  b"},
+		{"a b c d", "a c d e", "  a|- b|  c|  d|+ e"},
+		{"", "a", "+ a"},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, l := range DiffLines(strings.Fields(tt.a), strings.Fields(tt.b)) {
+			got = append(got, string(l.Op)+" "+l.Text)
+		}
+		if s := strings.Join(got, "|"); s != tt.want {
+			t.Errorf("DiffLines(%q, %q) = %q, want %q", tt.a, tt.b, s, tt.want)
+		}
+	}
+}
+
+func TestDiffMessages(t *testing.T) {
+	resent := strings.NewReplacer(
+		"Subject: Test Subject", "Subject: =?utf-8?q?Test_Subject?=",
+		"Message-Id: <test-1@example.com>", "Message-Id: <test-2@example.com>",
+		"Hello, World!", "Hello, World!  \r\n\r\n",
+	).Replace(testMailRFC822)
+
+	d, err := DiffMessages([]byte(testMailRFC822), []byte(resent), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HeaderDiff{{Name: "Message-Id", A: []string{"<test-1@example.com>"}, B: []string{"<test-2@example.com>"}}}
+	if !reflect.DeepEqual(d.Headers, want) {
+		t.Errorf("Headers = %+v, want %+v", d.Headers, want)
+	}
+	if d.Text != nil || d.HTML != nil || d.Attachments != nil {
+		t.Errorf("bodies differ: %+v", d)
+	}
+
+	d, err = DiffMessages([]byte(testMailRFC822), []byte(resent), []string{"message-id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Empty() {
+		t.Errorf("diff ignoring Message-Id = %+v, want empty", d)
+	}
+
+	changed := strings.Replace(testMailRFC822, "Hello, World!", "Hello, World!\r\nBye", 1)
+	changed = strings.Replace(changed, "Date: ", "X-Retry: 2\r\nDate: ", 1)
+	d, err = DiffMessages([]byte(testMailRFC822), []byte(changed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Headers) != 1 || d.Headers[0].Name != "X-Retry" || d.Headers[0].A != nil {
+		t.Errorf("Headers = %+v, want X-Retry only in the second", d.Headers)
+	}
+	if len(d.Text) != 2 || d.Text[1] != (DiffLine{'+', "Bye"}) {
+		t.Errorf("Text = %+v, want Bye added", d.Text)
+	}
+
+	d, err = DiffMessages([]byte(testMailMultipart), []byte(testMailRFC822), []string{"Subject", "Message-Id", "Content-Type"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Attachments) != 1 || d.Attachments[0].Op != '-' || !strings.HasPrefix(d.Attachments[0].Text, "test.bin (") {
+		t.Errorf("Attachments = %+v, want test.bin removed", d.Attachments)
+	}
+}
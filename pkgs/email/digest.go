@@ -0,0 +1,126 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Digest is the summary of the emails a DigestNotifier collected over one
+// window.
+type Digest struct {
+	Start  time.Time // When the first email arrived
+	End    time.Time // When the digest was sent
+	Emails []EmailNotification
+}
+
+// digestMaxLines bounds the emails a digest's text lists one by one.
+const digestMaxLines = 50
+
+// Text returns the title and body a digest is sent with: the number of
+// emails, then a "sender: subject" line for each.
+func (d Digest) Text() (title, body string) {
+	title = fmt.Sprintf("%d new emails", len(d.Emails))
+	if len(d.Emails) == 1 {
+		title = "1 new email"
+	}
+	var b strings.Builder
+	for i, n := range d.Emails {
+		if i == digestMaxLines {
+			fmt.Fprintf(&b, "... and %d more\n", len(d.Emails)-i)
+			break
+		}
+		subject := n.Subject
+		if subject == "" {
+			subject = "(no subject)"
+		}
+		fmt.Fprintf(&b, "%s: %s\n", n.From, subject)
+	}
+	return title, strings.TrimSuffix(b.String(), "\n")
+}
+
+// DigestSender sends a digest. The notifiers of this package implement it.
+type DigestSender interface {
+	SendDigest(ctx context.Context, d Digest) error
+}
+
+// DigestNotifier collects notifications and sends them through Sender as one
+// Digest per Window, instead of one notification per email. The first email
+// after a digest starts the next window. Digests are sent in the
+// background; Flush sends the pending one right away, and Watch calls it
+// when it stops.
+type DigestNotifier struct {
+	Window time.Duration
+	Sender DigestSender
+
+	// Error receives the errors of background sends. If nil, Watch sets it
+	// to report them as "notify" warnings.
+	Error func(error)
+
+	mu      sync.Mutex
+	pending *Digest
+	timer   *time.Timer
+}
+
+// Notify implements Notifier; it adds n to the pending digest.
+func (d *DigestNotifier) Notify(ctx context.Context, n EmailNotification) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending == nil {
+		digest := &Digest{Start: time.Now()}
+		d.pending = digest
+		d.timer = time.AfterFunc(d.Window, func() { d.sendPending(digest) })
+	}
+	d.pending.Emails = append(d.pending.Emails, n)
+	return nil
+}
+
+// Flush sends the pending digest, if any.
+func (d *DigestNotifier) Flush(ctx context.Context) error {
+	d.mu.Lock()
+	digest := d.pending
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.pending, d.timer = nil, nil
+	d.mu.Unlock()
+
+	if digest == nil {
+		return nil
+	}
+	return d.send(ctx, digest)
+}
+
+// sendPending sends digest at the end of its window, unless Flush sent it
+// already.
+func (d *DigestNotifier) sendPending(digest *Digest) {
+	d.mu.Lock()
+	if d.pending != digest {
+		d.mu.Unlock()
+		return
+	}
+	d.pending, d.timer = nil, nil
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	if err := d.send(ctx, digest); err != nil && d.Error != nil {
+		d.Error(err)
+	}
+}
+
+// SendAlert implements AlertSender: alerts are sent right away through
+// Sender, if it is an AlertSender, rather than collected.
+func (d *DigestNotifier) SendAlert(ctx context.Context, title, body string) error {
+	if s, ok := d.Sender.(AlertSender); ok {
+		return s.SendAlert(ctx, title, body)
+	}
+	return nil
+}
+
+func (d *DigestNotifier) send(ctx context.Context, digest *Digest) error {
+	digest.End = time.Now()
+	return d.Sender.SendDigest(ctx, *digest)
+}
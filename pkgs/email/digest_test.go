@@ -0,0 +1,90 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// digestRecorder is a DigestSender keeping the digests it was sent.
+type digestRecorder struct {
+	mu      sync.Mutex
+	digests []Digest
+	sent    chan struct{}
+}
+
+func (r *digestRecorder) SendDigest(ctx context.Context, d Digest) error {
+	r.mu.Lock()
+	r.digests = append(r.digests, d)
+	r.mu.Unlock()
+	r.sent <- struct{}{}
+	return nil
+}
+
+func TestDigestNotifier(t *testing.T) {
+	rec := &digestRecorder{sent: make(chan struct{}, 10)}
+	d := &DigestNotifier{Window: 50 * time.Millisecond, Sender: rec}
+
+	for i := 1; i <= 3; i++ {
+		n := testNotification
+		n.UID = uint32(i)
+		if err := d.Notify(context.Background(), n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-rec.sent:
+	case <-time.After(2 * time.Second):
+		t.Fatal("digest not sent at the end of the window")
+	}
+
+	// The next email starts a new window; Flush sends it right away
+	if err := d.Notify(context.Background(), testNotification); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-rec.sent
+	if err := d.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // The flushed window's timer must not send again
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.digests) != 2 {
+		t.Fatalf("sent %d digests, want 2", len(rec.digests))
+	}
+	if got := len(rec.digests[0].Emails); got != 3 {
+		t.Errorf("first digest has %d emails, want 3", got)
+	}
+	if got := len(rec.digests[1].Emails); got != 1 {
+		t.Errorf("second digest has %d emails, want 1", got)
+	}
+	if first := rec.digests[0]; first.End.Before(first.Start) {
+		t.Errorf("digest window %v to %v", first.Start, first.End)
+	}
+}
+
+func TestDigestText(t *testing.T) {
+	d := Digest{Emails: []EmailNotification{testNotification, {From: "boss@example.com"}}}
+	title, body := d.Text()
+	if title != "2 new emails" {
+		t.Errorf("title = %q", title)
+	}
+	if body != "alerts@example.com: Disk almost full\nboss@example.com: (no subject)" {
+		t.Errorf("body = %q", body)
+	}
+
+	d.Emails = nil
+	for i := 0; i < digestMaxLines+5; i++ {
+		d.Emails = append(d.Emails, EmailNotification{From: "a@example.com", Subject: fmt.Sprint(i)})
+	}
+	if _, body := d.Text(); strings.Count(body, "\n") != digestMaxLines || !strings.HasSuffix(body, "... and 5 more") {
+		t.Errorf("long digest body ends %q", body[len(body)-40:])
+	}
+}
@@ -0,0 +1,150 @@
+package email
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+
+	gomessage "github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+)
+
+// ErrNotDeliveryReport is returned by ParseDeliveryReport for emails that
+// are not a delivery status notification.
+var ErrNotDeliveryReport = errors.New("not a delivery status notification")
+
+// maxDeliveryStatusSize bounds the delivery-status part read into memory.
+const maxDeliveryStatusSize = 1 << 20
+
+// DeliveryReport is a delivery status notification (RFC 3464): the report
+// an MTA sends back about a message it could not deliver or, when asked,
+// delivered.
+type DeliveryReport struct {
+	ID           string // Message-ID of the report itself, without angle brackets
+	MessageID    string // Message-ID of the reported message; "" if the report leaves out its header
+	ReportingMTA string // e.g. "mx.example.com"
+	Recipients   []RecipientStatus
+}
+
+// RecipientStatus is the status of one recipient in a DeliveryReport.
+type RecipientStatus struct {
+	Recipient  string // Final-Recipient address
+	Original   string // Original-Recipient address, if reported
+	Action     string // "failed", "delayed", "delivered", "relayed" or "expanded"
+	Status     string // Enhanced status code (RFC 3463), e.g. "5.1.1"
+	Diagnostic string // Diagnostic-Code of the remote MTA, e.g. "550 5.1.1 User unknown"
+	RemoteMTA  string
+}
+
+// Final reports whether the status is the outcome of the delivery, rather
+// than a notice that it is delayed and still being tried.
+func (s RecipientStatus) Final() bool {
+	return s.Action != "delayed"
+}
+
+// ParseDeliveryReport parses a delivery status notification: a
+// multipart/report email with report-type delivery-status, whose parts
+// carry the status of each recipient and the original message or its
+// header. Other emails fail with ErrNotDeliveryReport.
+func ParseDeliveryReport(r io.Reader) (*DeliveryReport, error) {
+	entity, err := gomessage.Read(r)
+	if !isRecoverableEntityError(err) {
+		return nil, err
+	}
+	ct, params, _ := entity.Header.ContentType()
+	mr := entity.MultipartReader()
+	if ct != "multipart/report" || !strings.EqualFold(params["report-type"], "delivery-status") || mr == nil {
+		return nil, ErrNotDeliveryReport
+	}
+
+	report := &DeliveryReport{ID: trimMsgID(entity.Header.Get("Message-Id"))}
+	found := false
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if !isRecoverableEntityError(err) {
+			return nil, err
+		}
+		switch ct, _, _ := part.Header.ContentType(); ct {
+		case "message/delivery-status", "message/global-delivery-status":
+			if err := report.parseStatus(part.Body); err != nil {
+				return nil, err
+			}
+			found = true
+		case "message/rfc822", "text/rfc822-headers", "message/global", "message/global-headers":
+			if h, err := textproto.ReadHeader(bufio.NewReader(part.Body)); err == nil {
+				report.MessageID = trimMsgID(h.Get("Message-Id"))
+			}
+		}
+	}
+	if !found {
+		return nil, ErrNotDeliveryReport
+	}
+	return report, nil
+}
+
+// parseStatus parses the fields of a delivery-status part: a block of
+// per-message fields, then one block per recipient, separated by blank
+// lines.
+func (d *DeliveryReport) parseStatus(r io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(r, maxDeliveryStatusSize))
+	if err != nil {
+		return err
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+
+	var blocks []string
+	var block strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if block.Len() > 0 {
+				blocks = append(blocks, block.String())
+				block.Reset()
+			}
+			continue
+		}
+		block.WriteString(line + "\r\n")
+	}
+	if block.Len() > 0 {
+		blocks = append(blocks, block.String())
+	}
+
+	for i, b := range blocks {
+		h, err := textproto.ReadHeader(bufio.NewReader(strings.NewReader(b + "\r\n")))
+		if err != nil {
+			continue
+		}
+		if i == 0 {
+			d.ReportingMTA = dsnValue(h.Get("Reporting-MTA"))
+			continue
+		}
+		s := RecipientStatus{
+			Recipient:  strings.Trim(dsnValue(h.Get("Final-Recipient")), "<>"),
+			Original:   strings.Trim(dsnValue(h.Get("Original-Recipient")), "<>"),
+			Action:     strings.ToLower(strings.TrimSpace(h.Get("Action"))),
+			Diagnostic: dsnValue(h.Get("Diagnostic-Code")),
+			RemoteMTA:  dsnValue(h.Get("Remote-MTA")),
+		}
+		// "5.1.1 (user unknown)"
+		if status := strings.Fields(h.Get("Status")); len(status) > 0 {
+			s.Status = status[0]
+		}
+		if s.Recipient != "" && s.Action != "" {
+			d.Recipients = append(d.Recipients, s)
+		}
+	}
+	return nil
+}
+
+// dsnValue returns a typed delivery-status field, such as "rfc822;
+// user@example.com" or "smtp; 550 User unknown", without its type and with
+// folding whitespace collapsed.
+func dsnValue(v string) string {
+	if _, rest, ok := strings.Cut(v, ";"); ok {
+		v = rest
+	}
+	return strings.Join(strings.Fields(v), " ")
+}
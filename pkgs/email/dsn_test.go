@@ -0,0 +1,86 @@
+package email
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testBounce = "From: Mail Delivery System <MAILER-DAEMON@mx.example.com>\r\n" +
+	"To: sender@example.org\r\n" +
+	"Subject: Undelivered Mail Returned to Sender\r\n" +
+	"Message-ID: <bounce-1@mx.example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Your message could not be delivered.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example.com\r\n" +
+	"Arrival-Date: Mon, 1 Jan 2024 10:00:00 +0000\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; nobody@example.com\r\n" +
+	"Original-Recipient: rfc822;<Nobody@example.com>\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Remote-MTA: dns; in.example.com\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 <nobody@example.com>:\r\n" +
+	"    Recipient address rejected\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; late@example.net\r\n" +
+	"Action: Delayed\r\n" +
+	"Status: 4.4.1 (connection timed out)\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/rfc822-headers\r\n" +
+	"\r\n" +
+	"From: sender@example.org\r\n" +
+	"Message-ID: <orig-1@example.org>\r\n" +
+	"Subject: Hello\r\n" +
+	"\r\n" +
+	"--b1--\r\n"
+
+func TestParseDeliveryReport(t *testing.T) {
+	r, err := ParseDeliveryReport(strings.NewReader(testBounce))
+	if err != nil {
+		t.Fatalf("ParseDeliveryReport() error: %v", err)
+	}
+	if r.ID != "bounce-1@mx.example.com" || r.MessageID != "orig-1@example.org" || r.ReportingMTA != "mx.example.com" {
+		t.Errorf("report = %+v", r)
+	}
+	if len(r.Recipients) != 2 {
+		t.Fatalf("recipients = %+v, want 2", r.Recipients)
+	}
+	failed := r.Recipients[0]
+	want := RecipientStatus{
+		Recipient:  "nobody@example.com",
+		Original:   "Nobody@example.com",
+		Action:     "failed",
+		Status:     "5.1.1",
+		Diagnostic: "550 5.1.1 <nobody@example.com>: Recipient address rejected",
+		RemoteMTA:  "in.example.com",
+	}
+	if failed != want || !failed.Final() {
+		t.Errorf("recipient 1 = %+v, want %+v", failed, want)
+	}
+	delayed := r.Recipients[1]
+	if delayed.Recipient != "late@example.net" || delayed.Action != "delayed" || delayed.Status != "4.4.1" || delayed.Final() {
+		t.Errorf("recipient 2 = %+v", delayed)
+	}
+}
+
+func TestParseDeliveryReport_NotReport(t *testing.T) {
+	for name, raw := range map[string]string{
+		"plain":  testMailRFC822,
+		"mdn":    strings.Replace(testBounce, "report-type=delivery-status", "report-type=disposition-notification", 1),
+		"no dsn": strings.Replace(testBounce, "message/delivery-status", "text/plain", 1),
+	} {
+		if _, err := ParseDeliveryReport(strings.NewReader(raw)); !errors.Is(err, ErrNotDeliveryReport) {
+			t.Errorf("%s: ParseDeliveryReport() = %v, want ErrNotDeliveryReport", name, err)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package email
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,6 +21,8 @@ type Message struct {
 	// Content
 	TextBody string
 	HTMLBody string
+	Charset  string // Original charset of the body before UTF-8 conversion ("" if undeclared)
+	Preview  string // Single-line snippet of the new content, see MakePreview
 
 	// Metadata
 	MessageID   string
@@ -25,6 +31,11 @@ type Message struct {
 	Flags       MessageFlag
 	Labels      []string
 	Attachments []Attachment
+	Structure   *Part // MIME tree from IMAP BODYSTRUCTURE; nil unless fetched (IMAP FetchMessage)
+
+	// Server verdicts, from the headers; see FetchOptions.Spam for lists
+	Spam *SpamVerdict // nil if the message has no X-Spam-* headers
+	ARC  []ARCResult
 
 	// Server-specific
 	UID      uint32
@@ -33,29 +44,45 @@ type Message struct {
 	Internal bool // Internal flag for POP3
 }
 
+// Key returns a stable hash of the email's Message-ID, sender, subject and
+// date, usable as an idempotency key: the same email has the same key on
+// every fetch, in every folder and after UIDVALIDITY changes.
+func (m *Message) Key() string {
+	from := ""
+	if len(m.From) > 0 {
+		from = strings.ToLower(m.From[0].Email)
+	}
+	h := sha256.New()
+	for _, s := range []string{m.MessageID, from, m.Subject, strconv.FormatInt(m.Date.Unix(), 10)} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
 // Address represents an email address
 type Address struct {
-	Name    string `json:"name"`
-	Email   string `json:"email"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
 }
 
 // Attachment represents an email attachment
 type Attachment struct {
-	Filename    string
-	ContentType string
-	Size        int64
-	ContentID   string
-	Data        []byte // Actual attachment data
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	ContentID   string `json:"content_id,omitempty"`
+	Data        []byte `json:"data,omitempty"` // Actual attachment data, base64 in JSON
 }
 
 // MessageFlag represents message flags
 type MessageFlag struct {
-	Seen      bool
-	Flagged   bool
-	Answered  bool
-	Draft     bool
-	Deleted   bool
-	Recent    bool
+	Seen     bool
+	Flagged  bool
+	Answered bool
+	Draft    bool
+	Deleted  bool
+	Recent   bool
 }
 
 // SendOptions represents options for sending an email
@@ -64,12 +91,20 @@ type SendOptions struct {
 	To          []Address
 	Cc          []Address
 	Bcc         []Address
+	ReplyTo     []Address
 	Subject     string
 	TextBody    string
 	HTMLBody    string
 	Attachments []AttachmentPath
 	InReplyTo   string
 	References  []string
+
+	// AutoSubmitted marks mail sent without a person asking for it with
+	// an Auto-Submitted header (RFC 3834), "auto-generated" or
+	// "auto-replied", and an X-Loop header naming the sender, so that
+	// autoresponders, ours included, do not answer it; see
+	// SuppressAutoResponse.
+	AutoSubmitted string
 }
 
 // AttachmentPath represents a file attachment
@@ -80,24 +115,56 @@ type AttachmentPath struct {
 
 // FetchOptions represents options for fetching emails
 type FetchOptions struct {
-	Folder     string
-	Limit      int
-	MarkAsSeen bool
+	Folder              string
+	Limit               int
+	MarkAsSeen          bool
 	DeleteAfterRetrieve bool // For POP3
-	UnreadOnly  bool   // Only fetch unread messages (IMAP only)
+	UnreadOnly          bool // Only fetch unread messages (IMAP only)
+	Preview             bool // Download the start of each body to fill Message.Preview
+	Spam                bool // Fetch the spam headers to fill Message.Spam and Message.ARC (IMAP; POP3 always has them)
+
+	// Pagination, newest to oldest
+	Offset int     // Skip this many of the newest (matching) messages
+	Before *Cursor // Only list messages older than the cursor, see ListResult.Next
+
+	// After only lists messages newer than the cursor (IMAP): the oldest
+	// Limit of them, so that a caller remembering the newest one listed
+	// continues from there. ListResult.Next is then nil.
+	After *Cursor
 }
 
 // Folder represents an email folder
 type Folder struct {
 	Name     string
 	ReadOnly bool
-	Flags    []string
+	Flags    []string // Mailbox attributes as reported by LIST, e.g. \HasChildren
+
+	Delim      rune          // Hierarchy delimiter, 0 for a flat namespace
+	NoSelect   bool          // Only a parent of other folders; holds no messages
+	Subscribed bool          // Only reported by servers with LIST-EXTENDED or IMAP4rev2, or when listing subscribed folders
+	Role       string        // Logical folder name from SPECIAL-USE ("sent", "trash", ...) or "inbox"; "" if none
+	Status     *FolderStatus // Message counts, when requested with ListFoldersOptions.Counts
+}
+
+// FolderStatus holds the message counts of a folder
+type FolderStatus struct {
+	Messages int
+	Unseen   int
+}
+
+// ListFoldersOptions represents options for listing folders
+type ListFoldersOptions struct {
+	Counts     bool // Fetch message and unseen counts for every selectable folder
+	Subscribed bool // Only the subscribed folders (LIST-EXTENDED, or LSUB)
 }
 
 // ListResult represents the result of listing emails
 type ListResult struct {
-	Messages  []*Message
-	Total     int
-	Unread    int
-	Folder    string
+	Messages []*Message
+	Total    int
+	Unread   int
+	Folder   string
+
+	UIDValidity uint32  // IMAP UIDVALIDITY of the folder, for building cursors
+	Next        *Cursor // Pass as FetchOptions.Before for the next (older) page; nil on the last page
 }
@@ -0,0 +1,35 @@
+package email
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageKey(t *testing.T) {
+	date := time.Date(2026, 2, 10, 8, 0, 0, 0, time.UTC)
+	a := &Message{
+		MessageID: "test-1@example.com",
+		From:      []Address{{Name: "Sender", Email: "Sender@Example.com"}},
+		Subject:   "Test Subject",
+		Date:      date,
+		UID:       1,
+	}
+	// The same email in another folder, fetched again
+	b := *a
+	b.UID, b.SeqNum = 42, 7
+	b.From = []Address{{Email: "sender@example.com"}}
+	b.Flags.Seen = true
+	b.Date = date.In(time.FixedZone("CET", 3600))
+	if a.Key() != b.Key() {
+		t.Errorf("keys of the same email differ: %s, %s", a.Key(), b.Key())
+	}
+	if len(a.Key()) != 32 {
+		t.Errorf("len(Key()) = %d, want 32", len(a.Key()))
+	}
+
+	c := *a
+	c.Subject = "Re: Test Subject"
+	if a.Key() == c.Key() {
+		t.Error("key does not depend on the subject")
+	}
+}
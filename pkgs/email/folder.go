@@ -0,0 +1,144 @@
+package email
+
+import (
+	"sort"
+	"strings"
+)
+
+// Logical folder names. Commands accept them wherever a folder is
+// expected; IMAPClient.ResolveFolder maps them to the server's folders.
+const (
+	FolderInbox   = "inbox"
+	FolderSent    = "sent"
+	FolderDrafts  = "drafts"
+	FolderTrash   = "trash"
+	FolderJunk    = "junk"
+	FolderArchive = "archive"
+)
+
+// FolderRoles lists the logical folder names.
+var FolderRoles = []string{FolderInbox, FolderSent, FolderDrafts, FolderTrash, FolderJunk, FolderArchive}
+
+// IsFolderRole reports whether name is a logical folder name.
+func IsFolderRole(name string) bool {
+	for _, r := range FolderRoles {
+		if name == r {
+			return true
+		}
+	}
+	return false
+}
+
+// specialUseRoles maps RFC 6154 SPECIAL-USE attributes (lower-cased) to
+// logical folder names.
+var specialUseRoles = map[string]string{
+	`\sent`:    FolderSent,
+	`\drafts`:  FolderDrafts,
+	`\trash`:   FolderTrash,
+	`\junk`:    FolderJunk,
+	`\archive`: FolderArchive,
+}
+
+// folderRole returns the logical folder name a folder serves as: "inbox"
+// for INBOX, otherwise the role of its SPECIAL-USE attribute, or "".
+func folderRole(name string, attrs []string) string {
+	if strings.EqualFold(name, "INBOX") {
+		return FolderInbox
+	}
+	for _, attr := range attrs {
+		if role := specialUseRoles[strings.ToLower(attr)]; role != "" {
+			return role
+		}
+	}
+	return ""
+}
+
+// SpecialUseFolders maps logical folder names to the folders holding the
+// matching role. Without an \Archive folder, archive falls back to the
+// \All folder (Gmail's "All Mail"), where archived messages end up.
+func SpecialUseFolders(folders []Folder) map[string]string {
+	m := make(map[string]string)
+	for _, f := range folders {
+		if f.NoSelect {
+			continue
+		}
+		role := f.Role
+		if role == "" {
+			role = folderRole(f.Name, f.Flags)
+		}
+		if role != "" && m[role] == "" {
+			m[role] = f.Name
+		}
+	}
+	if m[FolderArchive] == "" {
+		for _, f := range folders {
+			for _, attr := range f.Flags {
+				if strings.EqualFold(attr, `\All`) && !f.NoSelect {
+					m[FolderArchive] = f.Name
+					break
+				}
+			}
+			if m[FolderArchive] != "" {
+				break
+			}
+		}
+	}
+	return m
+}
+
+// FolderNode is a folder in the tree built by BuildFolderTree
+type FolderNode struct {
+	Folder
+	Label    string // Last component of the folder name
+	Children []*FolderNode
+}
+
+// BuildFolderTree arranges a flat folder list into a tree using each
+// folder's hierarchy delimiter. Parents the server did not list (e.g.
+// "Archive" for "Archive/2024") are added as \NonExistent, NoSelect
+// folders. Siblings are sorted by name with INBOX first.
+func BuildFolderTree(folders []Folder) []*FolderNode {
+	var roots []*FolderNode
+	nodes := make(map[string]*FolderNode, len(folders))
+
+	// node returns the node for name, creating missing ancestors
+	var node func(name string, delim rune) *FolderNode
+	node = func(name string, delim rune) *FolderNode {
+		if n, ok := nodes[name]; ok {
+			return n
+		}
+		n := &FolderNode{
+			Folder: Folder{Name: name, Delim: delim, NoSelect: true, Flags: []string{"\\NonExistent"}},
+			Label:  name,
+		}
+		nodes[name] = n
+		if i := strings.LastIndex(name, string(delim)); delim != 0 && i > 0 && i < len(name)-1 {
+			n.Label = name[i+len(string(delim)):]
+			parent := node(name[:i], delim)
+			parent.Children = append(parent.Children, n)
+		} else {
+			roots = append(roots, n)
+		}
+		return n
+	}
+
+	for _, f := range folders {
+		node(f.Name, f.Delim).Folder = f
+	}
+
+	sortFolderNodes(roots)
+	return roots
+}
+
+func sortFolderNodes(nodes []*FolderNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		if inboxA, inboxB := strings.EqualFold(a.Name, "INBOX"), strings.EqualFold(b.Name, "INBOX"); inboxA != inboxB {
+			return inboxA
+		}
+		return a.Label < b.Label
+	})
+	for _, n := range nodes {
+		sortFolderNodes(n.Children)
+	}
+}
@@ -0,0 +1,98 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildFolderTree(t *testing.T) {
+	folders := []Folder{
+		{Name: "Sent", Delim: '/'},
+		{Name: "Archive/2024", Delim: '/'},
+		{Name: "Archive/2023", Delim: '/'},
+		{Name: "INBOX", Delim: '/'},
+		{Name: "INBOX/Lists/go-dev", Delim: '/'},
+		{Name: "INBOX/Lists", Delim: '/', NoSelect: true},
+		{Name: "Flat.Name", Delim: 0},
+	}
+	roots := BuildFolderTree(folders)
+
+	var lines []string
+	var walk func(nodes []*FolderNode, depth int)
+	walk = func(nodes []*FolderNode, depth int) {
+		for _, n := range nodes {
+			line := strings.Repeat("  ", depth) + n.Label
+			if n.NoSelect {
+				line += " (noselect)"
+			}
+			lines = append(lines, line)
+			walk(n.Children, depth+1)
+		}
+	}
+	walk(roots, 0)
+
+	want := []string{
+		"INBOX",
+		"  Lists (noselect)",
+		"    go-dev",
+		"Archive (noselect)",
+		"  2023",
+		"  2024",
+		"Flat.Name",
+		"Sent",
+	}
+	if got := strings.Join(lines, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("tree:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+
+	if archive := roots[1]; archive.Name != "Archive" || archive.Flags[0] != "\\NonExistent" {
+		t.Errorf("placeholder parent = %+v", archive.Folder)
+	}
+}
+
+func TestSpecialUseFolders(t *testing.T) {
+	folders := []Folder{
+		{Name: "INBOX"},
+		{Name: "[Gmail]", NoSelect: true},
+		{Name: "[Gmail]/All Mail", Flags: []string{`\HasNoChildren`, `\All`}},
+		{Name: "[Gmail]/Sent Mail", Flags: []string{`\HasNoChildren`, `\Sent`}},
+		{Name: "[Gmail]/Spam", Flags: []string{`\junk`}},
+		{Name: "[Gmail]/Trash", Flags: []string{`\Trash`}},
+		{Name: "Trash", Flags: []string{`\Trash`}},
+	}
+	got := SpecialUseFolders(folders)
+	want := map[string]string{
+		FolderInbox:   "INBOX",
+		FolderSent:    "[Gmail]/Sent Mail",
+		FolderJunk:    "[Gmail]/Spam",
+		FolderTrash:   "[Gmail]/Trash",
+		FolderArchive: "[Gmail]/All Mail",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("SpecialUseFolders() = %v, want %v", got, want)
+	}
+	for role, name := range want {
+		if got[role] != name {
+			t.Errorf("SpecialUseFolders()[%q] = %q, want %q", role, got[role], name)
+		}
+	}
+
+	// A real \Archive folder wins over \All
+	folders = append(folders, Folder{Name: "Archive", Flags: []string{`\Archive`}})
+	if got := SpecialUseFolders(folders)[FolderArchive]; got != "Archive" {
+		t.Errorf("archive = %q, want Archive", got)
+	}
+}
+
+func TestIsFolderRole(t *testing.T) {
+	for _, name := range FolderRoles {
+		if !IsFolderRole(name) {
+			t.Errorf("IsFolderRole(%q) = false", name)
+		}
+	}
+	for _, name := range []string{"", "INBOX", "Sent", "outbox"} {
+		if IsFolderRole(name) {
+			t.Errorf("IsFolderRole(%q) = true", name)
+		}
+	}
+}
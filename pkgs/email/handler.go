@@ -0,0 +1,189 @@
+package email
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Handler shells accepted by WatchOptions.HandlerShell.
+const (
+	HandlerShellSh         = "sh"         // sh -c <cmd>
+	HandlerShellCmd        = "cmd"        // cmd.exe /S /C <cmd>
+	HandlerShellPowerShell = "powershell" // powershell -NoProfile -NonInteractive -Command <cmd>
+	HandlerShellPwsh       = "pwsh"       // PowerShell 7+, same flags as powershell
+	HandlerShellNone       = "none"       // split <cmd> into argv and run it directly
+)
+
+// DefaultHandlerShell returns the shell used when WatchOptions.HandlerShell
+// is empty: cmd on Windows, sh everywhere else.
+func DefaultHandlerShell() string {
+	if runtime.GOOS == "windows" {
+		return HandlerShellCmd
+	}
+	return HandlerShellSh
+}
+
+// handlerCommand builds the process that runs the handler command line cmd
+// through the given shell ("" selects DefaultHandlerShell).
+func handlerCommand(shell, cmd string) (*exec.Cmd, error) {
+	if shell == "" {
+		shell = DefaultHandlerShell()
+	}
+
+	switch strings.ToLower(shell) {
+	case HandlerShellSh:
+		return exec.Command("sh", "-c", cmd), nil
+
+	case HandlerShellCmd:
+		c := exec.Command("cmd.exe", "/S", "/C", cmd)
+		// cmd.exe does not follow the argv quoting rules exec applies on
+		// Windows, so hand it the command line verbatim.
+		setRawCmdLine(c, `cmd.exe /S /C "`+cmd+`"`)
+		return c, nil
+
+	case HandlerShellPowerShell, HandlerShellPwsh:
+		return exec.Command(strings.ToLower(shell), "-NoProfile", "-NonInteractive", "-Command", cmd), nil
+
+	case HandlerShellNone:
+		args, err := splitHandlerArgs(cmd)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("empty handler command")
+		}
+		return exec.Command(args[0], args[1:]...), nil
+	}
+
+	return nil, fmt.Errorf("unknown handler shell %q (want sh, cmd, powershell, pwsh or none)", shell)
+}
+
+// HandlerCommand builds the process that runs cmd through shell the way
+// watch runs its handler, for other commands that hand emails to the same
+// programs. The caller sets its stdin, output and environment.
+func HandlerCommand(shell, cmd string) (*exec.Cmd, error) {
+	return handlerCommand(shell, cmd)
+}
+
+// shellBuiltins are sh commands that need no program on the PATH.
+var shellBuiltins = map[string]bool{
+	".": true, ":": true, "cd": true, "echo": true, "eval": true, "exec": true,
+	"export": true, "printf": true, "read": true, "set": true, "test": true, "[": true,
+}
+
+// FindHandlerProgram checks that the program a handler command line runs
+// exists: the shell, and the first word of cmd when it names a program
+// rather than shell syntax. Through cmd and PowerShell, whose builtins are
+// not known, the first word is only checked when it is a path.
+func FindHandlerProgram(shell, cmd string) error {
+	c, err := handlerCommand(shell, cmd)
+	if err != nil {
+		return err
+	}
+	if c.Err != nil {
+		return c.Err
+	}
+	if shell == "" {
+		shell = DefaultHandlerShell()
+	}
+	if strings.EqualFold(shell, HandlerShellNone) {
+		return nil
+	}
+
+	args, err := splitHandlerArgs(cmd)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("empty handler command")
+	}
+	prog := args[0]
+	switch {
+	case strings.ContainsAny(prog, "$`=;|&<>(){}*?~%"):
+		return nil
+	case strings.ContainsAny(prog, `/\`):
+		if _, err := os.Stat(prog); err != nil {
+			return err
+		}
+	case strings.EqualFold(shell, HandlerShellSh) && !shellBuiltins[prog]:
+		if _, err := exec.LookPath(prog); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handlerEnv returns the environment variables describing an email that
+// the handler gets on top of emx-mail's own environment, so simple scripts
+// need not parse the headers themselves. Line breaks and NUL bytes, which
+// some shells and the OS cannot pass through, become spaces.
+func handlerEnv(account, folder string, uid uint32, meta *EmailMetadata) []string {
+	clean := strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ", "\x00", " ")
+	env := []string{
+		"EMX_ACCOUNT=" + clean.Replace(account),
+		"EMX_FOLDER=" + clean.Replace(folder),
+		fmt.Sprintf("EMX_UID=%d", uid),
+		"EMX_MESSAGE_ID=" + clean.Replace(meta.MessageID),
+		"EMX_FROM=" + clean.Replace(meta.From),
+		"EMX_SUBJECT=" + clean.Replace(meta.Subject),
+	}
+	if meta.Message != nil {
+		env = append(env, "EMX_KEY="+meta.Message.Key())
+	}
+	if meta.SuppressAutoResponse != "" {
+		env = append(env, "EMX_SUPPRESS_AUTO_RESPONSE="+clean.Replace(meta.SuppressAutoResponse))
+	}
+	return env
+}
+
+// splitHandlerArgs splits a command line into arguments on unquoted
+// whitespace. Single and double quotes group words and are removed. A
+// backslash escapes a following quote or backslash and is kept literally
+// otherwise, so Windows paths like C:\Tools\handler.exe need no escaping.
+func splitHandlerArgs(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && quote != '\'' && i+1 < len(runes) &&
+			(runes[i+1] == '"' || runes[i+1] == '\'' || runes[i+1] == '\\'):
+			i++
+			cur.WriteRune(runes[i])
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inWord {
+				args = append(args, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in handler command", quote)
+	}
+	if inWord {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package email
+
+import "os/exec"
+
+// setRawCmdLine is a no-op outside Windows, where processes receive argv
+// rather than a single command line.
+func setRawCmdLine(c *exec.Cmd, line string) {}
@@ -0,0 +1,165 @@
+package email
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitHandlerArgs(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"emx-save ./emails", []string{"emx-save", "./emails"}},
+		{`  handler   --opt  `, []string{"handler", "--opt"}},
+		{`"C:\Program Files\emx\emx-save.exe" C:\mail`, []string{`C:\Program Files\emx\emx-save.exe`, `C:\mail`}},
+		{`h 'it''s' "a \"b\""`, []string{"h", "its", `a "b"`}},
+		{`h '' ""`, []string{"h", "", ""}},
+		{`h 'a\'`, []string{"h", `a\`}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := splitHandlerArgs(tt.input)
+		if err != nil {
+			t.Errorf("splitHandlerArgs(%q) error: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitHandlerArgs(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	if _, err := splitHandlerArgs(`h "open`); err == nil {
+		t.Error("expected error for unterminated quote")
+	}
+}
+
+func TestHandlerCommand(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  []string
+	}{
+		{"sh", []string{"sh", "-c", "emx-save ./emails"}},
+		{"cmd", []string{"cmd.exe", "/S", "/C", "emx-save ./emails"}},
+		{"PowerShell", []string{"powershell", "-NoProfile", "-NonInteractive", "-Command", "emx-save ./emails"}},
+		{"none", []string{"emx-save", "./emails"}},
+	}
+	for _, tt := range tests {
+		c, err := handlerCommand(tt.shell, "emx-save ./emails")
+		if err != nil {
+			t.Fatalf("handlerCommand(%q) error: %v", tt.shell, err)
+		}
+		if !reflect.DeepEqual(c.Args, tt.want) {
+			t.Errorf("handlerCommand(%q).Args = %q, want %q", tt.shell, c.Args, tt.want)
+		}
+	}
+
+	if _, err := handlerCommand("bash", "x"); err == nil {
+		t.Error("expected error for unknown shell")
+	}
+	if _, err := handlerCommand("none", "  "); err == nil {
+		t.Error("expected error for empty command")
+	}
+}
+
+func TestFindHandlerProgram(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	tests := []struct {
+		shell, cmd string
+		ok         bool
+	}{
+		{"sh", "cat > /dev/null", true},
+		{"sh", "echo done", true},
+		{"sh", "FOO=1 emx-missing-handler", true},
+		{"sh", "emx-missing-handler --opt", false},
+		{"sh", "./emx-missing-handler", false},
+		{"none", "cat -", true},
+		{"none", "emx-missing-handler", false},
+	}
+	for _, tt := range tests {
+		if err := FindHandlerProgram(tt.shell, tt.cmd); (err == nil) != tt.ok {
+			t.Errorf("FindHandlerProgram(%q, %q) = %v, want ok %v", tt.shell, tt.cmd, err, tt.ok)
+		}
+	}
+}
+
+func TestRunHandler(t *testing.T) {
+	c := &IMAPClient{}
+
+	// Reading stdin and the exit code must work through the platform
+	// default shell as well as without one.
+	exit1 := "exit 1"
+	if runtime.GOOS == "windows" {
+		exit1 = "exit /B 1"
+	}
+	for _, tt := range []struct {
+		shell, cmd string
+		want       int
+	}{
+		{"", exit1, 1},
+		{"", "sort", 0},
+		{"none", "sort", 0},
+	} {
+		code, err := c.runHandler(context.Background(), tt.shell, tt.cmd, nil, time.Second, strings.NewReader("From: a@b.c\r\n\r\nbody\r\n"))
+		if err != nil {
+			t.Fatalf("runHandler(%q, %q) error: %v", tt.shell, tt.cmd, err)
+		}
+		if code != tt.want {
+			t.Errorf("runHandler(%q, %q) = %d, want %d", tt.shell, tt.cmd, code, tt.want)
+		}
+	}
+}
+
+func TestRunHandler_Env(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	c := &IMAPClient{}
+
+	meta := &EmailMetadata{
+		MessageID: "abc@example.com",
+		From:      "Alice <alice@example.com>",
+		Subject:   "Invoice\r\n 42",
+	}
+	env := handlerEnv("work", "INBOX", 7, meta)
+	check := `cat >/dev/null; test "$EMX_ACCOUNT/$EMX_FOLDER/$EMX_UID" = work/INBOX/7 &&
+		test "$EMX_MESSAGE_ID" = abc@example.com &&
+		test "$EMX_FROM" = "Alice <alice@example.com>" &&
+		test "$EMX_SUBJECT" = "Invoice  42"`
+	code, err := c.runHandler(context.Background(), "sh", check, env, time.Second, strings.NewReader("x"))
+	if err != nil || code != 0 {
+		t.Errorf("runHandler with env = (%d, %v), want (0, nil)", code, err)
+	}
+}
+
+func TestRunHandler_ShutdownGrace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	c := &IMAPClient{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// A handler that finishes within the grace period still succeeds.
+	code, err := c.runHandler(ctx, "sh", "sleep 0.1; cat >/dev/null", nil, 5*time.Second, strings.NewReader("x"))
+	if err != nil || code != 0 {
+		t.Errorf("runHandler within grace = (%d, %v), want (0, nil)", code, err)
+	}
+
+	// One that outlives it is killed.
+	start := time.Now()
+	_, err = c.runHandler(ctx, "sh", "exec sleep 10", nil, 100*time.Millisecond, strings.NewReader("x"))
+	if err == nil {
+		t.Error("expected error for handler killed after grace period")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("handler was not killed promptly (%v)", time.Since(start))
+	}
+}
@@ -0,0 +1,13 @@
+//go:build windows
+
+package email
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setRawCmdLine makes c start with line as its exact command line.
+func setRawCmdLine(c *exec.Cmd, line string) {
+	c.SysProcAttr = &syscall.SysProcAttr{CmdLine: line}
+}
@@ -1,21 +1,36 @@
 package email
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
 	gomessage "github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-sasl"
 )
 
 // IMAPClient represents an IMAP client
 type IMAPClient struct {
 	config IMAPConfig
 	client *imapclient.Client
+
+	specialUse  map[string]string // SpecialUseFolders of the server, cached by resolveFolder
+	changes     *changeTracker    // Receives untagged responses while Watch reports changes
+	release     func()            // Frees the connection slot taken from IMAPConfig.Limiter
+	uidValidity map[string]uint32 // UIDVALIDITY of each folder when selectFolder last selected it
 }
 
 // IMAPConfig holds IMAP configuration
@@ -26,6 +41,109 @@ type IMAPConfig struct {
 	Password string
 	SSL      bool
 	StartTLS bool
+
+	// AuthzID, if set, is the SASL authorization identity to act as, e.g.
+	// a shared mailbox Username is a delegate of. It needs AUTH=PLAIN.
+	AuthzID string
+
+	// TLSConfig is optional; if nil a default config is used. ServerName
+	// defaults to Host.
+	TLSConfig *tls.Config
+
+	// Folders maps logical folder names (FolderInbox, FolderSent, ...) to
+	// the server's folders, e.g. "sent" to "[Gmail]/Sent Mail". Logical
+	// names missing here are looked up by their SPECIAL-USE attribute.
+	Folders map[string]string
+
+	// Limiter, if set, caps the connections open at once to the server
+	// and shares backoff after refusals with other clients and processes.
+	Limiter *ConnLimiter
+
+	// MaxBodyBytes makes fetching a message larger than this fail with
+	// ErrBodyTooLarge before its body is downloaded; 0 means no limit.
+	MaxBodyBytes int64
+
+	// ChunkSize, if > 0, fetches the source of messages larger than this
+	// in ranged chunks of that many bytes (BODY.PEEK[]<offset.size>).
+	// After a network error the client reconnects and resumes from the
+	// first missing byte, up to MaxResumes times in a row (default 3).
+	ChunkSize  int64
+	MaxResumes int
+
+	// FetchProgress, if set, is called after every chunk of a chunked
+	// fetch with the bytes of message uid received so far and its size.
+	// Every fetch of a message counts from its start again.
+	FetchProgress func(uid uint32, done, total int64)
+
+	// Mutated, if set, is called after every change the client makes to a
+	// mailbox, e.g. to keep an audit log.
+	Mutated func(Mutation)
+
+	// UIDValidityChanged, if set, is called when a folder's UIDVALIDITY
+	// differs from the one the client saw when it last selected the
+	// folder: the server renumbered it, and UIDs known from before are
+	// stale.
+	UIDValidityChanged func(folder string, old, current uint32)
+
+	// Clock, if set, replaces the system clock for the start time, uptime
+	// and reconnect delays of Watch, and for the arrival of emails whose
+	// INTERNALDATE is unknown.
+	Clock Clock
+
+	// Dial, if set, opens the connection to the server instead of a plain
+	// TCP dial, e.g. through a SOCKS proxy or an in-memory transport. The
+	// client still does TLS (SSL) or STARTTLS on top of the connection it
+	// returns, with TLSConfig.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// DebugWriter, if set, receives the raw protocol exchange with the
+	// server, credentials included.
+	DebugWriter io.Writer
+
+	// UnilateralDataHandler, if set, receives the untagged responses the
+	// server sends outside of commands, e.g. EXPUNGE and EXISTS during
+	// IDLE. Its handlers run on the client's reader goroutine and must not
+	// issue commands. While Watch reports changes (WatchOptions.Changes)
+	// the FETCH responses are consumed by the watch, so Fetch is not
+	// called then; the other handlers are.
+	UnilateralDataHandler *imapclient.UnilateralDataHandler
+}
+
+// ErrUIDValidityChanged is returned by commands on given UIDs of a folder
+// whose UIDVALIDITY changed since the client last selected it, as the UIDs
+// may name other messages now. The client then knows the new UIDVALIDITY,
+// so the command succeeds again with UIDs read after the change.
+var ErrUIDValidityChanged = errors.New("UIDVALIDITY changed")
+
+// Mutation describes a change IMAPClient made to a mailbox.
+type Mutation struct {
+	Op        string   // "flags", "delete" (\Deleted added), "expunge", "move" or "annotate"
+	Folder    string   // Server folder name; "" for annotations of the server
+	UID       uint32   // 0 for an "expunge" of every \Deleted message, or annotations of a folder
+	MessageID string   // Without angle brackets; "" if unknown
+	Dest      string   // "move": destination folder
+	Add       []string // "flags": flags added; "annotate": annotations set
+	Remove    []string // "flags": flags removed; "annotate": annotations removed
+}
+
+// mutationMessageID returns the Message-ID of uid in the selected folder if
+// mutations are reported. Call it before the change, while the message is
+// still there.
+func (c *IMAPClient) mutationMessageID(uid uint32) string {
+	if c.config.Mutated == nil {
+		return ""
+	}
+	msgs, err := c.client.Fetch(imap.UIDSetNum(imap.UID(uid)), &imap.FetchOptions{Envelope: true}).Collect()
+	if err != nil || len(msgs) == 0 || msgs[0].Envelope == nil {
+		return ""
+	}
+	return msgs[0].Envelope.MessageID
+}
+
+func (c *IMAPClient) mutated(m Mutation) {
+	if c.config.Mutated != nil {
+		c.config.Mutated(m)
+	}
 }
 
 // NewIMAPClient creates a new IMAP client
@@ -45,41 +163,107 @@ func (c *IMAPClient) Connect() error {
 	}
 
 	// Create TLS config with ServerName for proper certificate validation
-	tlsCfg := &tls.Config{ServerName: c.config.Host}
+	tlsCfg := clientTLSConfig(c.config.TLSConfig, c.config.Host)
 
 	var client *imapclient.Client
 	var err error
 
-	if c.config.SSL {
-		client, err = imapclient.DialTLS(addr, &imapclient.Options{
-			TLSConfig: tlsCfg,
-		})
-	} else if c.config.StartTLS {
-		client, err = imapclient.DialStartTLS(addr, &imapclient.Options{
-			TLSConfig: tlsCfg,
-		})
-	} else {
-		client, err = imapclient.DialInsecure(addr, &imapclient.Options{})
+	release := func() {}
+	if c.config.Limiter != nil {
+		if release, err = c.config.Limiter.Acquire(addr); err != nil {
+			return err
+		}
+	}
+	// report passes the outcome to the limiter and frees the slot on failure
+	report := func(err error) error {
+		if c.config.Limiter != nil {
+			c.config.Limiter.Report(addr, err)
+		}
+		if err != nil {
+			release()
+		}
+		return err
+	}
+
+	dataHandler := c.config.UnilateralDataHandler
+	if c.changes != nil {
+		dataHandler = changeHandler(c.changes, dataHandler)
 	}
+
+	client, err = c.dial(addr, &imapclient.Options{
+		TLSConfig:             tlsCfg,
+		DebugWriter:           c.config.DebugWriter,
+		WordDecoder:           wordDecoder,
+		UnilateralDataHandler: dataHandler,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to connect to IMAP server %s: %w", addr, err)
+		return report(fmt.Errorf("failed to connect to IMAP server %s: %w", addr, err))
 	}
 
 	// Authenticate
-	if err := client.Login(c.config.Username, c.config.Password).Wait(); err != nil {
+	if err := c.login(client); err != nil {
 		client.Close()
-		return fmt.Errorf("IMAP authentication failed: %w", err)
+		return report(fmt.Errorf("IMAP authentication failed: %w", err))
 	}
 
+	report(nil)
 	c.client = client
+	c.release = release
 	return nil
 }
 
+// dial connects to addr with TLS, STARTTLS or neither as configured, over
+// a connection from IMAPConfig.Dial if set.
+func (c *IMAPClient) dial(addr string, options *imapclient.Options) (*imapclient.Client, error) {
+	if c.config.Dial == nil {
+		switch {
+		case c.config.SSL:
+			return imapclient.DialTLS(addr, options)
+		case c.config.StartTLS:
+			return imapclient.DialStartTLS(addr, options)
+		default:
+			return imapclient.DialInsecure(addr, options)
+		}
+	}
+
+	conn, err := c.config.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c.config.SSL:
+		return imapclient.New(tls.Client(conn, options.TLSConfig), options), nil
+	case c.config.StartTLS:
+		client, err := imapclient.NewStartTLS(conn, options)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return client, nil
+	default:
+		return imapclient.New(conn, options), nil
+	}
+}
+
+// login authenticates with LOGIN, or with AUTHENTICATE PLAIN to act as
+// AuthzID.
+func (c *IMAPClient) login(client *imapclient.Client) error {
+	if c.config.AuthzID == "" {
+		return client.Login(c.config.Username, c.config.Password).Wait()
+	}
+	if !client.Caps().Has(imap.AuthCap(sasl.Plain)) {
+		return fmt.Errorf("server does not support AUTH=PLAIN, needed to act as %s", c.config.AuthzID)
+	}
+	return client.Authenticate(sasl.NewPlainClient(c.config.AuthzID, c.config.Username, c.config.Password))
+}
+
 // Close closes the IMAP connection
 func (c *IMAPClient) Close() error {
 	if c.client != nil {
 		err := c.client.Close()
 		c.client = nil
+		c.release()
+		c.release = nil
 		return err
 	}
 	return nil
@@ -96,28 +280,215 @@ func (c *IMAPClient) ensureConnected() (func(), error) {
 	return func() { c.Close() }, nil
 }
 
-// ListFolders lists all folders/mailboxes
-func (c *IMAPClient) ListFolders() ([]Folder, error) {
+// ListFolders lists all folders/mailboxes with their hierarchy delimiter
+// and attributes; see BuildFolderTree to arrange them as a tree.
+func (c *IMAPClient) ListFolders(opts ListFoldersOptions) ([]Folder, error) {
 	cleanup, err := c.ensureConnected()
 	if err != nil {
 		return nil, err
 	}
 	defer cleanup()
 
-	mailboxes, err := c.client.List("", "*", &imap.ListOptions{}).Collect()
+	// LIST return options need LIST-EXTENDED (part of IMAP4rev2); without
+	// them subscriptions are not reported and counts come from STATUS.
+	caps := c.client.Caps()
+	listOptions := &imap.ListOptions{}
+	if caps.Has(imap.CapListExtended) || caps.Has(imap.CapIMAP4rev2) {
+		listOptions.ReturnSubscribed = true
+	}
+	statusOptions := &imap.StatusOptions{NumMessages: true, NumUnseen: true}
+	if opts.Counts && (caps.Has(imap.CapListStatus) || caps.Has(imap.CapIMAP4rev2)) {
+		listOptions.ReturnStatus = statusOptions
+	}
+	// subscribed holds the LSUB names, when the server cannot select
+	// subscribed folders in LIST
+	var subscribed map[string]bool
+	if opts.Subscribed {
+		if listOptions.ReturnSubscribed {
+			listOptions.SelectSubscribed = true
+		} else if subscribed, err = c.lsub(); err != nil {
+			return nil, fmt.Errorf("failed to list subscribed folders: %w", err)
+		}
+	}
+
+	mailboxes, err := c.client.List("", "*", listOptions).Collect()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list folders: %w", err)
 	}
 
 	folders := make([]Folder, 0, len(mailboxes))
 	for _, mb := range mailboxes {
-		folders = append(folders, Folder{
-			Name: mb.Mailbox,
-		})
+		if subscribed != nil && !subscribed[mb.Mailbox] {
+			continue
+		}
+		f := Folder{
+			Name:  mb.Mailbox,
+			Delim: mb.Delim,
+			Flags: make([]string, 0, len(mb.Attrs)),
+		}
+		for _, attr := range mb.Attrs {
+			f.Flags = append(f.Flags, string(attr))
+			switch {
+			case strings.EqualFold(string(attr), string(imap.MailboxAttrNoSelect)),
+				strings.EqualFold(string(attr), string(imap.MailboxAttrNonExistent)):
+				f.NoSelect = true
+			case strings.EqualFold(string(attr), string(imap.MailboxAttrSubscribed)):
+				f.Subscribed = true
+			}
+		}
+		if subscribed != nil {
+			f.Subscribed = true
+		}
+		f.Role = folderRole(f.Name, f.Flags)
+		if mb.Status != nil {
+			f.Status = folderStatus(mb.Status)
+		}
+		folders = append(folders, f)
+	}
+
+	if opts.Counts && listOptions.ReturnStatus == nil {
+		for i := range folders {
+			if folders[i].NoSelect {
+				continue
+			}
+			// A folder that cannot be opened is still listed, without counts
+			if data, err := c.client.Status(folders[i].Name, statusOptions).Wait(); err == nil {
+				folders[i].Status = folderStatus(data)
+			}
+		}
 	}
 	return folders, nil
 }
 
+// Subscribe adds the folder or logical folder to the subscriptions, the
+// folders mail clients show.
+func (c *IMAPClient) Subscribe(folder string) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return err
+	}
+	if err := c.client.Subscribe(folder).Wait(); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", folder, err)
+	}
+	return nil
+}
+
+// Unsubscribe removes the folder or logical folder from the subscriptions.
+func (c *IMAPClient) Unsubscribe(folder string) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return err
+	}
+	if err := c.client.Unsubscribe(folder).Wait(); err != nil {
+		return fmt.Errorf("failed to unsubscribe from %s: %w", folder, err)
+	}
+	return nil
+}
+
+// ResolveFolder returns the server folder for name. Logical folder names
+// (FolderInbox, FolderSent, ..., matched case-insensitively) are mapped
+// through IMAPConfig.Folders, then through the server's SPECIAL-USE
+// attributes; "" is the inbox. Other names, and logical names neither
+// maps, are returned unchanged.
+func (c *IMAPClient) ResolveFolder(name string) (string, error) {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	return c.resolveFolder(name)
+}
+
+func (c *IMAPClient) resolveFolder(name string) (string, error) {
+	role := strings.ToLower(name)
+	if name == "" {
+		role = FolderInbox
+	}
+	if !IsFolderRole(role) {
+		return name, nil
+	}
+	if mapped := c.config.Folders[role]; mapped != "" {
+		return mapped, nil
+	}
+	if role == FolderInbox {
+		return "INBOX", nil
+	}
+
+	if c.specialUse == nil {
+		folders, err := c.ListFolders(ListFoldersOptions{})
+		if err != nil {
+			return "", err
+		}
+		c.specialUse = SpecialUseFolders(folders)
+	}
+	if mapped := c.specialUse[role]; mapped != "" {
+		return mapped, nil
+	}
+	return name, nil
+}
+
+// selectFolder selects folder and records its UIDVALIDITY. If that changed
+// since the client last selected the folder, old is the previous value and
+// IMAPConfig.UIDValidityChanged is called; otherwise old is 0.
+func (c *IMAPClient) selectFolder(folder string, readOnly bool) (data *imap.SelectData, old uint32, err error) {
+	var opts *imap.SelectOptions
+	if readOnly {
+		opts = &imap.SelectOptions{ReadOnly: true}
+	}
+	data, err = c.client.Select(folder, opts).Wait()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to select folder %s: %w", folder, err)
+	}
+	if c.uidValidity == nil {
+		c.uidValidity = make(map[string]uint32)
+	}
+	if prev := c.uidValidity[folder]; prev != 0 && prev != data.UIDValidity {
+		old = prev
+		if c.config.UIDValidityChanged != nil {
+			c.config.UIDValidityChanged(folder, old, data.UIDValidity)
+		}
+	}
+	c.uidValidity[folder] = data.UIDValidity
+	return data, old, nil
+}
+
+// selectForUIDs selects folder for a command on UIDs the caller has. It
+// fails with ErrUIDValidityChanged rather than let the command act on
+// other messages than the caller meant.
+func (c *IMAPClient) selectForUIDs(folder string) error {
+	data, old, err := c.selectFolder(folder, false)
+	if err != nil {
+		return err
+	}
+	if old != 0 {
+		return fmt.Errorf("%w: %s was renumbered (UIDVALIDITY %d, was %d), list it again",
+			ErrUIDValidityChanged, folder, data.UIDValidity, old)
+	}
+	return nil
+}
+
+func folderStatus(data *imap.StatusData) *FolderStatus {
+	st := &FolderStatus{}
+	if data.NumMessages != nil {
+		st.Messages = int(*data.NumMessages)
+	}
+	if data.NumUnseen != nil {
+		st.Unseen = int(*data.NumUnseen)
+	}
+	return st
+}
+
 // FetchMessages fetches message envelopes from a folder
 func (c *IMAPClient) FetchMessages(opts FetchOptions) (*ListResult, error) {
 	cleanup, err := c.ensureConnected()
@@ -126,15 +497,15 @@ func (c *IMAPClient) FetchMessages(opts FetchOptions) (*ListResult, error) {
 	}
 	defer cleanup()
 
-	folder := opts.Folder
-	if folder == "" {
-		folder = "INBOX"
+	folder, err := c.resolveFolder(opts.Folder)
+	if err != nil {
+		return nil, err
 	}
 
 	// Select mailbox
-	selectData, err := c.client.Select(folder, nil).Wait()
+	selectData, _, err := c.selectFolder(folder, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to select folder %s: %w", folder, err)
+		return nil, err
 	}
 
 	numMessages := selectData.NumMessages
@@ -157,100 +528,164 @@ func (c *IMAPClient) FetchMessages(opts FetchOptions) (*ListResult, error) {
 		unread = int(*statusData.NumUnseen)
 	}
 
-	// If UnreadOnly is true, use SEARCH UNSEEN to get unread UIDs
-	var uidSet imap.UIDSet
-	if opts.UnreadOnly {
-		searchData, err := c.client.UIDSearch(&imap.SearchCriteria{
-			NotFlag: []imap.Flag{imap.FlagSeen},
-		}, nil).Wait()
-		if err != nil {
-			return nil, fmt.Errorf("SEARCH UNSEEN failed: %w", err)
-		}
-		uids := searchData.AllUIDs()
-		if len(uids) == 0 {
-			return &ListResult{
-				Messages: []*Message{},
-				Total:    int(numMessages),
-				Unread:   0,
-				Folder:   folder,
-			}, nil
-		}
-		// Apply limit to unread UIDs (take newest)
-		limit := opts.Limit
-		if limit <= 0 {
-			limit = 20
-		}
-		// UIDs are returned in ascending order, take the last N for newest
-		startIdx := 0
-		if len(uids) > limit {
-			startIdx = len(uids) - limit
-		}
-		uidSet = imap.UIDSet{}
-		for _, uid := range uids[startIdx:] {
-			uidSet.AddNum(imap.UID(uid))
-		}
-	} else {
-		// Calculate the range of sequence numbers to fetch
-		limit := opts.Limit
-		if limit <= 0 {
-			limit = 20
+	fetchOptions := &imap.FetchOptions{
+		Envelope: true,
+		Flags:    true,
+		UID:      true,
+	}
+	var previewSection *imap.FetchItemBodySection
+	if opts.Preview {
+		// Only the start of each message is needed for a preview
+		previewSection = &imap.FetchItemBodySection{
+			Peek:    true,
+			Partial: &imap.SectionPartial{Offset: 0, Size: previewFetchSize},
 		}
-		start := uint32(1)
-		if numMessages > uint32(limit) {
-			start = numMessages - uint32(limit) + 1
+		fetchOptions.BodySection = []*imap.FetchItemBodySection{previewSection}
+	}
+	var spamSection *imap.FetchItemBodySection
+	if opts.Spam {
+		spamSection = &imap.FetchItemBodySection{
+			Specifier:    imap.PartSpecifierHeader,
+			HeaderFields: SpamHeaderFields,
+			Peek:         true,
 		}
+		fetchOptions.BodySection = append(fetchOptions.BodySection, spamSection)
+	}
 
-		// Fetch using sequence numbers, then convert to UID set
-		seqSet := imap.SeqSet{}
-		seqSet.AddRange(start, numMessages)
-
-		fetchOptions := &imap.FetchOptions{
-			Envelope: true,
-			Flags:    true,
-			UID:      true,
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	noMessages := func() *ListResult {
+		return &ListResult{
+			Messages:    []*Message{},
+			Total:       int(numMessages),
+			Unread:      unread,
+			Folder:      folder,
+			UIDValidity: selectData.UIDValidity,
 		}
+	}
 
-		msgs, err := c.client.Fetch(seqSet, fetchOptions).Collect()
+	var fetchCmd *imapclient.FetchCommand
+	var more bool
+	if opts.UnreadOnly || opts.Before != nil || opts.After != nil {
+		criteria := &imap.SearchCriteria{}
+		if opts.UnreadOnly {
+			// Use SEARCH UNSEEN to get unread UIDs
+			criteria.NotFlag = []imap.Flag{imap.FlagSeen}
+		}
+		if before := opts.Before; before != nil {
+			if before.UIDValidity != 0 && before.UIDValidity != selectData.UIDValidity {
+				return nil, fmt.Errorf("%w: UIDVALIDITY of %s changed from %d to %d",
+					ErrCursorExpired, folder, before.UIDValidity, selectData.UIDValidity)
+			}
+			if before.UID <= 1 {
+				return noMessages(), nil
+			}
+			// Anchoring on UIDs keeps pages stable while new mail arrives
+			uidRange := imap.UIDSet{}
+			uidRange.AddRange(1, imap.UID(before.UID-1))
+			criteria.UID = []imap.UIDSet{uidRange}
+		}
+		if after := opts.After; after != nil {
+			if after.UIDValidity != 0 && after.UIDValidity != selectData.UIDValidity {
+				return nil, fmt.Errorf("%w: UIDVALIDITY of %s changed from %d to %d",
+					ErrCursorExpired, folder, after.UIDValidity, selectData.UIDValidity)
+			}
+			uidRange := imap.UIDSet{}
+			uidRange.AddRange(imap.UID(after.UID+1), 0)
+			criteria.UID = append(criteria.UID, uidRange)
+		}
+		searchData, err := c.client.UIDSearch(criteria, nil).Wait()
 		if err != nil {
-			return nil, fmt.Errorf("failed to fetch messages: %w", err)
+			return nil, fmt.Errorf("SEARCH failed: %w", err)
 		}
 
-		// Build UID set from fetched messages
-		uidSet = imap.UIDSet{}
-		for _, msg := range msgs {
-			uidSet.AddNum(msg.UID)
+		// UIDs are returned in ascending order: skip Offset from the end,
+		// then take the last N for newest
+		uids := searchData.AllUIDs()
+		if opts.After != nil {
+			// "<uid>:*" matches the last message even when its UID is
+			// lower, and newer messages are taken oldest first
+			newer := uids[:0]
+			for _, uid := range uids {
+				if uint32(uid) > opts.After.UID {
+					newer = append(newer, uid)
+				}
+			}
+			uids = newer
+			if len(uids) > limit {
+				uids = uids[:limit]
+			}
 		}
-	}
+		end := len(uids) - max(opts.Offset, 0)
+		if end <= 0 {
+			return noMessages(), nil
+		}
+		startIdx := max(end-limit, 0)
+		more = startIdx > 0
 
-	// Fetch the actual messages using UID set
-	fetchOptions := &imap.FetchOptions{
-		Envelope: true,
-		Flags:    true,
-		UID:      true,
+		uidSet := imap.UIDSet{}
+		for _, uid := range uids[startIdx:end] {
+			uidSet.AddNum(imap.UID(uid))
+		}
+		fetchCmd = c.client.Fetch(uidSet, fetchOptions)
+	} else {
+		// Calculate the range of sequence numbers to fetch
+		end := int64(numMessages) - int64(max(opts.Offset, 0))
+		if end <= 0 {
+			return noMessages(), nil
+		}
+		start := max(end-int64(limit)+1, 1)
+		more = start > 1
+
+		// The sequence range already carries envelopes and UIDs, so the
+		// messages are fetched once rather than again by UID.
+		seqSet := imap.SeqSet{}
+		seqSet.AddRange(uint32(start), uint32(end))
+		fetchCmd = c.client.Fetch(seqSet, fetchOptions)
 	}
 
-	msgs, err := c.client.Fetch(uidSet, fetchOptions).Collect()
+	msgs, err := fetchCmd.Collect()
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch messages: %w", err)
 	}
 
-	messages := make([]*Message, 0, len(msgs))
-	for _, buf := range msgs {
-		msg := convertIMAPFetchBuffer(buf)
-		messages = append(messages, msg)
+	// Newest messages come first
+	messages := convertIMAPFetchBuffers(msgs)
+	if previewSection != nil {
+		for i, buf := range msgs {
+			if raw := buf.FindBodySection(previewSection); raw != nil {
+				messages[len(msgs)-1-i].Preview = previewFromRaw(raw)
+			}
+		}
 	}
-
-	// Reverse so newest messages come first
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
+	if spamSection != nil {
+		for i, buf := range msgs {
+			raw := buf.FindBodySection(spamSection)
+			if raw == nil {
+				continue
+			}
+			if h, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw))); err == nil {
+				parseSpamHeaders(messages[len(msgs)-1-i], gomessage.Header{Header: h})
+			}
+		}
 	}
 
-	return &ListResult{
-		Messages: messages,
-		Total:    int(numMessages),
-		Unread:   unread,
-		Folder:   folder,
-	}, nil
+	result := &ListResult{
+		Messages:    messages,
+		Total:       int(numMessages),
+		Unread:      unread,
+		Folder:      folder,
+		UIDValidity: selectData.UIDValidity,
+	}
+	if more && len(messages) > 0 {
+		result.Next = &Cursor{
+			UIDValidity: selectData.UIDValidity,
+			UID:         messages[len(messages)-1].UID,
+		}
+	}
+	return result, nil
 }
 
 // FetchMessage fetches a single message by UID, including body
@@ -261,12 +696,15 @@ func (c *IMAPClient) FetchMessage(folder string, uid uint32) (*Message, error) {
 	}
 	defer cleanup()
 
-	if folder == "" {
-		folder = "INBOX"
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return nil, err
 	}
 
-	if _, err := c.client.Select(folder, nil).Wait(); err != nil {
-		return nil, fmt.Errorf("failed to select folder %s: %w", folder, err)
+	if err := c.selectForUIDs(folder); err != nil {
+		return nil, err
+	}
+	if err := c.checkSize(uid); err != nil {
+		return nil, err
 	}
 
 	// Fetch envelope + full body
@@ -274,10 +712,11 @@ func (c *IMAPClient) FetchMessage(folder string, uid uint32) (*Message, error) {
 		Peek: true, // don't mark as read
 	}
 	fetchOptions := &imap.FetchOptions{
-		Envelope:    true,
-		Flags:       true,
-		UID:         true,
-		BodySection: []*imap.FetchItemBodySection{bodySection},
+		Envelope:      true,
+		Flags:         true,
+		UID:           true,
+		BodyStructure: &imap.FetchItemBodyStructure{Extended: true},
+		BodySection:   []*imap.FetchItemBodySection{bodySection},
 	}
 
 	uidSet := imap.UIDSetNum(imap.UID(uid))
@@ -302,80 +741,1266 @@ func (c *IMAPClient) FetchMessage(folder string, uid uint32) (*Message, error) {
 	return msg, nil
 }
 
-// DeleteMessage deletes a message by UID
-func (c *IMAPClient) DeleteMessage(folder string, uid uint32, expunge bool) error {
+// FindMessageByID returns the envelope of the message with the given
+// Message-ID in the first of folders (names or logical folders) having one,
+// with its References read from the header. Folders that cannot be
+// selected are skipped. It returns ErrMessageNotFound if no folder has the
+// message.
+func (c *IMAPClient) FindMessageByID(messageID string, folders ...string) (*Message, error) {
+	msg, _, err := c.LocateMessageByID(messageID, folders...)
+	return msg, err
+}
+
+// MessageLocation is where a message is on the server: its UID in a folder
+// with a UIDVALIDITY.
+type MessageLocation struct {
+	Folder      string
+	UIDValidity uint32
+	UID         uint32
+}
+
+// LocateMessageByID is FindMessageByID that also returns where the message
+// was found.
+func (c *IMAPClient) LocateMessageByID(messageID string, folders ...string) (*Message, MessageLocation, error) {
 	cleanup, err := c.ensureConnected()
 	if err != nil {
-		return err
+		return nil, MessageLocation{}, err
 	}
 	defer cleanup()
 
-	if folder == "" {
-		folder = "INBOX"
-	}
-
-	if _, err := c.client.Select(folder, nil).Wait(); err != nil {
-		return fmt.Errorf("failed to select folder %s: %w", folder, err)
+	id := trimMsgID(messageID)
+	if id == "" {
+		return nil, MessageLocation{}, ErrMessageNotFound
 	}
-
-	// Mark as deleted using UID
-	uidSet := imap.UIDSetNum(imap.UID(uid))
-	_, err = c.client.Store(uidSet, &imap.StoreFlags{
-		Op:    imap.StoreFlagsAdd,
-		Flags: []imap.Flag{imap.FlagDeleted},
-	}, nil).Collect()
-	if err != nil {
-		return fmt.Errorf("failed to mark message as deleted: %w", err)
+	refSection := &imap.FetchItemBodySection{
+		Specifier:    imap.PartSpecifierHeader,
+		HeaderFields: []string{"References"},
+		Peek:         true,
 	}
+	for _, name := range folders {
+		folder, err := c.resolveFolder(name)
+		if err != nil {
+			continue
+		}
+		selected, _, err := c.selectFolder(folder, false)
+		if err != nil {
+			continue
+		}
+		searchData, err := c.client.UIDSearch(&imap.SearchCriteria{
+			Header: []imap.SearchCriteriaHeaderField{{Key: "Message-ID", Value: id}},
+		}, nil).Wait()
+		if err != nil {
+			return nil, MessageLocation{}, fmt.Errorf("SEARCH failed: %w", err)
+		}
+		uids := searchData.AllUIDs()
+		if len(uids) == 0 {
+			continue
+		}
 
-	if expunge {
-		if _, err := c.client.Expunge().Collect(); err != nil {
-			return fmt.Errorf("failed to expunge messages: %w", err)
+		msgs, err := c.client.Fetch(imap.UIDSetNum(uids[0]), &imap.FetchOptions{
+			Envelope:    true,
+			UID:         true,
+			BodySection: []*imap.FetchItemBodySection{refSection},
+		}).Collect()
+		if err != nil {
+			return nil, MessageLocation{}, fmt.Errorf("failed to fetch message UID %d: %w", uids[0], err)
+		}
+		if len(msgs) == 0 {
+			continue
 		}
+		msg := convertIMAPFetchBuffer(msgs[0])
+		msg.References = nil
+		if raw := msgs[0].FindBodySection(refSection); raw != nil {
+			if h, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw))); err == nil {
+				mh := mail.Header{Header: gomessage.Header{Header: h}}
+				msg.References, _ = mh.MsgIDList("References")
+			}
+		}
+		return msg, MessageLocation{Folder: folder, UIDValidity: selected.UIDValidity, UID: uint32(uids[0])}, nil
 	}
-
-	return nil
-}
-
-// FetchMessageByID implements MailReceiver.
-func (c *IMAPClient) FetchMessageByID(folder string, uid uint32) (*Message, error) {
-	return c.FetchMessage(folder, uid)
+	return nil, MessageLocation{}, ErrMessageNotFound
 }
 
-// DeleteMessageByID implements MailReceiver.
-func (c *IMAPClient) DeleteMessageByID(folder string, uid uint32, expunge bool) error {
-	return c.DeleteMessage(folder, uid, expunge)
-}
-
-// MarkAsSeen marks a message as seen
-func (c *IMAPClient) MarkAsSeen(folder string, uid uint32) error {
+// FetchRawMessage returns the full RFC 5322 source of a message, without
+// marking it as read.
+func (c *IMAPClient) FetchRawMessage(folder string, uid uint32) ([]byte, error) {
 	cleanup, err := c.ensureConnected()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer cleanup()
 
-	if folder == "" {
-		folder = "INBOX"
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return nil, err
 	}
-
-	if _, err := c.client.Select(folder, nil).Wait(); err != nil {
-		return fmt.Errorf("failed to select folder %s: %w", folder, err)
+	if err := c.selectForUIDs(folder); err != nil {
+		return nil, err
+	}
+	if err := c.checkSize(uid); err != nil {
+		return nil, err
+	}
+	large, err := c.largeMessages([]uint32{uid})
+	if err != nil {
+		return nil, err
+	}
+	if size, ok := large[uid]; ok {
+		return io.ReadAll(c.chunkReader(folder, uid, size))
 	}
 
+	bodySection := &imap.FetchItemBodySection{Peek: true}
 	uidSet := imap.UIDSetNum(imap.UID(uid))
-	_, err = c.client.Store(uidSet, &imap.StoreFlags{
-		Op:    imap.StoreFlagsAdd,
-		Flags: []imap.Flag{imap.FlagSeen},
-	}, nil).Collect()
+	msgs, err := c.client.Fetch(uidSet, &imap.FetchOptions{
+		UID:         true,
+		BodySection: []*imap.FetchItemBodySection{bodySection},
+	}).Collect()
 	if err != nil {
-		return fmt.Errorf("failed to mark message as seen: %w", err)
+		return nil, fmt.Errorf("failed to fetch message UID %d: %w", uid, err)
 	}
-
-	return nil
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("message UID %d not found in %s", uid, folder)
+	}
+	return msgs[0].FindBodySection(bodySection), nil
 }
 
-// Ping sends a NOOP command to keep the connection alive
+// FetchMessageStream fetches a message like FetchMessage, but hands fn a
+// MessageStream reading its bodies and attachments from the server part by
+// part instead of holding them all in the Message. The stream is only
+// valid during fn; the parts fn does not read are skipped.
+func (c *IMAPClient) FetchMessageStream(folder string, uid uint32, fn func(*MessageStream) error) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return err
+	}
+	if err := c.selectForUIDs(folder); err != nil {
+		return err
+	}
+
+	meta, err := c.fetchEmailMetadata(uid)
+	if err != nil {
+		return fmt.Errorf("message UID %d not found in %s: %w", uid, folder, err)
+	}
+	if err := checkBodySize(int64(meta.Message.Size), c.config.MaxBodyBytes); err != nil {
+		return err
+	}
+
+	var r io.Reader
+	if size := int64(meta.Message.Size); c.config.ChunkSize > 0 && size > c.config.ChunkSize {
+		r = c.chunkReader(folder, uid, size)
+	} else {
+		var done func()
+		if r, done, err = c.fetchRawEmailReader(uid); err != nil {
+			return err
+		}
+		defer done()
+	}
+	stream, err := NewMessageStream(r, c.config.MaxBodyBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse message UID %d: %w", uid, err)
+	}
+
+	// The envelope is decoded by the server; the spam and ARC results only
+	// come with the header
+	msg := meta.Message
+	msg.Spam, msg.ARC = stream.Message.Spam, stream.Message.ARC
+	stream.Message = msg
+	return fn(stream)
+}
+
+// checkSize returns ErrBodyTooLarge if message uid of the selected folder
+// is larger than the MaxBodyBytes of the config.
+func (c *IMAPClient) checkSize(uid uint32) error {
+	if c.config.MaxBodyBytes <= 0 {
+		return nil
+	}
+	msgs, err := c.client.Fetch(imap.UIDSetNum(imap.UID(uid)), &imap.FetchOptions{
+		UID:        true,
+		RFC822Size: true,
+	}).Collect()
+	if err != nil {
+		return fmt.Errorf("failed to fetch size of message UID %d: %w", uid, err)
+	}
+	if len(msgs) == 0 {
+		return nil // Reported by the fetch of the message
+	}
+	return checkBodySize(msgs[0].RFC822Size, c.config.MaxBodyBytes)
+}
+
+// largeMessages returns the size of the messages with uids in the selected
+// folder that are fetched in chunks, see IMAPConfig.ChunkSize, by UID.
+func (c *IMAPClient) largeMessages(uids []uint32) (map[uint32]int64, error) {
+	large := make(map[uint32]int64)
+	if c.config.ChunkSize <= 0 || len(uids) == 0 {
+		return large, nil
+	}
+	set := make([]imap.UID, len(uids))
+	for i, uid := range uids {
+		set[i] = imap.UID(uid)
+	}
+	msgs, err := c.client.Fetch(imap.UIDSetNum(set...), &imap.FetchOptions{
+		UID:        true,
+		RFC822Size: true,
+	}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch message sizes: %w", err)
+	}
+	for _, msg := range msgs {
+		if msg.RFC822Size > c.config.ChunkSize {
+			large[uint32(msg.UID)] = msg.RFC822Size
+		}
+	}
+	return large, nil
+}
+
+// chunkReader returns a reader of the source of message uid of the
+// selected folder, size bytes long, fetched in chunks of ChunkSize bytes.
+// It reconnects after a network error and selects folder again, failing
+// with ErrUIDValidityChanged if it was renumbered in the meantime. A
+// message expunged before it is read fails with ErrMessageNotFound.
+func (c *IMAPClient) chunkReader(folder string, uid uint32, size int64) io.Reader {
+	r := &chunkReader{
+		size:       size,
+		chunk:      c.config.ChunkSize,
+		maxResumes: c.config.MaxResumes,
+		fetch: func(offset, n int64) ([]byte, error) {
+			section := &imap.FetchItemBodySection{
+				Peek:    true,
+				Partial: &imap.SectionPartial{Offset: offset, Size: n},
+			}
+			msgs, err := c.client.Fetch(imap.UIDSetNum(imap.UID(uid)), &imap.FetchOptions{
+				UID:         true,
+				BodySection: []*imap.FetchItemBodySection{section},
+			}).Collect()
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch message UID %d: %w", uid, err)
+			}
+			if len(msgs) == 0 {
+				return nil, fmt.Errorf("%w: UID %d in %s", ErrMessageNotFound, uid, folder)
+			}
+			if data := msgs[0].FindBodySection(section); data != nil {
+				return data, nil
+			}
+			// Servers answer BODY[]<offset> without the size
+			if len(msgs[0].BodySection) == 1 {
+				return msgs[0].BodySection[0].Bytes, nil
+			}
+			return nil, nil
+		},
+		resume: func() error {
+			c.Close()
+			if err := c.Connect(); err != nil {
+				return err
+			}
+			return c.selectForUIDs(folder)
+		},
+	}
+	if r.maxResumes <= 0 {
+		r.maxResumes = defaultMaxResumes
+	}
+	if c.config.FetchProgress != nil {
+		r.progress = func(done, total int64) { c.config.FetchProgress(uid, done, total) }
+	}
+	return r
+}
+
+// DefaultFetchWindow is how many FETCH commands FetchRawMessages keeps in
+// flight by default.
+const DefaultFetchWindow = 8
+
+// FetchRawMessages fetches the sources of the messages with uids in folder,
+// without marking them as read, and passes them to fn in the order of uids.
+// It keeps up to window UID FETCH commands, one per message, in flight at
+// once, so the round trips overlap on a high-latency link; window <= 0
+// means DefaultFetchWindow. Messages larger than the ChunkSize of the
+// config are fetched alone, in chunks. Messages expunged in the meantime
+// are skipped. An error from fn stops the fetch and is returned.
+func (c *IMAPClient) FetchRawMessages(folder string, uids []uint32, window int, fn func(uid uint32, raw []byte) error) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return err
+	}
+	if err := c.selectForUIDs(folder); err != nil {
+		return err
+	}
+	if window <= 0 {
+		window = DefaultFetchWindow
+	}
+	large, err := c.largeMessages(uids)
+	if err != nil {
+		return err
+	}
+
+	bodySection := &imap.FetchItemBodySection{Peek: true}
+	opts := &imap.FetchOptions{
+		UID:         true,
+		BodySection: []*imap.FetchItemBodySection{bodySection},
+	}
+	// pending are the commands in flight, for uids[next-len(pending):next]
+	pending := make([]*imapclient.FetchCommand, 0, window)
+	defer func() {
+		for _, cmd := range pending {
+			cmd.Close()
+		}
+	}()
+	next := 0
+	for next < len(uids) || len(pending) > 0 {
+		if len(pending) == 0 && large[uids[next]] > 0 {
+			// Alone on the connection, which a resume replaces
+			uid := uids[next]
+			next++
+			raw, err := io.ReadAll(c.chunkReader(folder, uid, large[uid]))
+			if errors.Is(err, ErrMessageNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := fn(uid, raw); err != nil {
+				return err
+			}
+			continue
+		}
+		for next < len(uids) && len(pending) < window && large[uids[next]] == 0 {
+			pending = append(pending, c.client.Fetch(imap.UIDSetNum(imap.UID(uids[next])), opts))
+			next++
+		}
+		uid := uids[next-len(pending)]
+		msgs, err := pending[0].Collect()
+		pending = pending[1:]
+		if err != nil {
+			return fmt.Errorf("failed to fetch message UID %d: %w", uid, err)
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		if err := fn(uid, msgs[0].FindBodySection(bodySection)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SeqUIDs returns the UIDs of the messages of folder with the sequence
+// numbers in set, in sequence order. set is an IMAP sequence set such as
+// "1:100", "42,50:60" or "990:*", where "*" is the last message, or "-N"
+// for the last N messages. Sequence numbers shift as messages are
+// expunged; the UIDs stay valid for the commands that take them.
+func (c *IMAPClient) SeqUIDs(folder, set string) ([]uint32, error) {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return nil, err
+	}
+	data, _, err := c.selectFolder(folder, true)
+	if err != nil {
+		return nil, err
+	}
+	seqSet, err := parseSeqSet(set, data.NumMessages)
+	if err != nil {
+		return nil, err
+	}
+	if data.NumMessages == 0 {
+		return nil, nil
+	}
+
+	msgs, err := c.client.Fetch(seqSet, &imap.FetchOptions{UID: true}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sequence numbers %s: %w", set, err)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].SeqNum < msgs[j].SeqNum })
+	uids := make([]uint32, len(msgs))
+	for i, msg := range msgs {
+		uids[i] = uint32(msg.UID)
+	}
+	return uids, nil
+}
+
+// parseSeqSet parses the set of SeqUIDs for a folder of n messages.
+func parseSeqSet(set string, n uint32) (imap.SeqSet, error) {
+	if last, ok := strings.CutPrefix(set, "-"); ok {
+		count, err := strconv.ParseUint(last, 10, 32)
+		if err != nil || count == 0 {
+			return nil, fmt.Errorf("invalid sequence set %q: want -N for the last N messages", set)
+		}
+		first := uint32(1)
+		if uint32(count) < n {
+			first = n - uint32(count) + 1
+		}
+		var s imap.SeqSet
+		s.AddRange(first, 0) // first:*
+		return s, nil
+	}
+	var s imap.SeqSet
+	for _, part := range strings.Split(set, ",") {
+		first, last, isRange := strings.Cut(part, ":")
+		start, err := parseSeqNum(first, n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sequence set %q: %w", set, err)
+		}
+		stop := start
+		if isRange {
+			if stop, err = parseSeqNum(last, n); err != nil {
+				return nil, fmt.Errorf("invalid sequence set %q: %w", set, err)
+			}
+		}
+		s.AddRange(start, stop)
+	}
+	return s, nil
+}
+
+// parseSeqNum parses a sequence number of a set: a positive number, or "*"
+// for the last message of a folder of n messages.
+func parseSeqNum(s string, n uint32) (uint32, error) {
+	if s == "*" {
+		return max(n, 1), nil
+	}
+	num, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || num == 0 {
+		return 0, fmt.Errorf("%q is not a sequence number", s)
+	}
+	return uint32(num), nil
+}
+
+// AppendMessage stores the RFC 5322 message raw in folder, e.g. to archive
+// it, with the given flags.
+func (c *IMAPClient) AppendMessage(folder string, raw []byte, flags []string) error {
+	return c.AppendMessageAt(folder, raw, flags, time.Time{})
+}
+
+// AppendMessageAt stores a message like AppendMessage with date as its
+// internal date, e.g. to restore it; a zero date lets the server use the
+// current time.
+func (c *IMAPClient) AppendMessageAt(folder string, raw []byte, flags []string, date time.Time) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return err
+	}
+
+	var opts *imap.AppendOptions
+	if len(flags) > 0 || !date.IsZero() {
+		opts = &imap.AppendOptions{Time: date}
+		for _, f := range flags {
+			opts.Flags = append(opts.Flags, imap.Flag(f))
+		}
+	}
+
+	appendCmd := c.client.Append(folder, int64(len(raw)), opts)
+	if _, err := appendCmd.Write(raw); err != nil {
+		appendCmd.Close()
+		return fmt.Errorf("failed to append message to %s: %w", folder, err)
+	}
+	if err := appendCmd.Close(); err != nil {
+		return fmt.Errorf("failed to append message to %s: %w", folder, err)
+	}
+	if _, err := appendCmd.Wait(); err != nil {
+		return fmt.Errorf("failed to append message to %s: %w", folder, err)
+	}
+	return nil
+}
+
+// DeleteMessage deletes a message by UID
+func (c *IMAPClient) DeleteMessage(folder string, uid uint32, expunge bool) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return err
+	}
+
+	if err := c.selectForUIDs(folder); err != nil {
+		return err
+	}
+
+	// Mark as deleted using UID
+	messageID := c.mutationMessageID(uid)
+	uidSet := imap.UIDSetNum(imap.UID(uid))
+	_, err = c.client.Store(uidSet, &imap.StoreFlags{
+		Op:    imap.StoreFlagsAdd,
+		Flags: []imap.Flag{imap.FlagDeleted},
+	}, nil).Collect()
+	if err != nil {
+		return fmt.Errorf("failed to mark message as deleted: %w", err)
+	}
+	c.mutated(Mutation{Op: "delete", Folder: folder, UID: uid, MessageID: messageID})
+
+	if expunge {
+		if _, err := c.client.Expunge().Collect(); err != nil {
+			return fmt.Errorf("failed to expunge messages: %w", err)
+		}
+		c.mutated(Mutation{Op: "expunge", Folder: folder})
+	}
+
+	return nil
+}
+
+// StripResult is the outcome of IMAPClient.StripAttachments.
+type StripResult struct {
+	Stripped []StrippedPart // Attachments removed; none if the message had none
+	OldSize  int            // Size of the original message
+	NewSize  int            // Size of the reduced message
+	NewUID   uint32         // UID of the reduced message; 0 if the server did not report it
+	Expunged bool           // The original was expunged, not just marked \Deleted
+}
+
+// StripAttachments replaces message uid of folder by a copy without its
+// attachments, see the StripAttachments function. The copy is appended with
+// the original's flags and internal date; then the original is marked
+// \Deleted and, if the server has UIDPLUS, expunged by UID. Without UIDPLUS
+// it stays marked \Deleted, as a plain EXPUNGE would also remove the other
+// deleted messages of the folder. A message without attachments and a dry
+// run change nothing.
+func (c *IMAPClient) StripAttachments(folder string, uid uint32, dryRun bool) (*StripResult, error) {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return nil, err
+	}
+	if err := c.selectForUIDs(folder); err != nil {
+		return nil, err
+	}
+	if err := c.checkSize(uid); err != nil {
+		return nil, err
+	}
+
+	bodySection := &imap.FetchItemBodySection{Peek: true}
+	uidSet := imap.UIDSetNum(imap.UID(uid))
+	msgs, err := c.client.Fetch(uidSet, &imap.FetchOptions{
+		UID:          true,
+		Flags:        true,
+		InternalDate: true,
+		Envelope:     true,
+		BodySection:  []*imap.FetchItemBodySection{bodySection},
+	}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch message UID %d: %w", uid, err)
+	}
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("message UID %d not found in %s", uid, folder)
+	}
+	msg := msgs[0]
+	raw := msg.FindBodySection(bodySection)
+
+	var reduced bytes.Buffer
+	stripped, err := StripAttachments(&reduced, bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to strip message UID %d: %w", uid, err)
+	}
+	result := &StripResult{Stripped: stripped, OldSize: len(raw), NewSize: reduced.Len()}
+	if len(stripped) == 0 || dryRun {
+		return result, nil
+	}
+
+	// \Recent cannot be set by clients
+	opts := &imap.AppendOptions{Time: msg.InternalDate}
+	for _, f := range msg.Flags {
+		if f != imap.Flag("\\Recent") {
+			opts.Flags = append(opts.Flags, f)
+		}
+	}
+	appendCmd := c.client.Append(folder, int64(reduced.Len()), opts)
+	if _, err := appendCmd.Write(reduced.Bytes()); err != nil {
+		appendCmd.Close()
+		return nil, fmt.Errorf("failed to append the stripped message to %s: %w", folder, err)
+	}
+	if err := appendCmd.Close(); err != nil {
+		return nil, fmt.Errorf("failed to append the stripped message to %s: %w", folder, err)
+	}
+	data, err := appendCmd.Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to append the stripped message to %s: %w", folder, err)
+	}
+	if data != nil {
+		result.NewUID = uint32(data.UID)
+	}
+
+	// From here on the folder holds both versions, so errors keep the result
+	var messageID string
+	if msg.Envelope != nil {
+		messageID = msg.Envelope.MessageID
+	}
+	_, err = c.client.Store(uidSet, &imap.StoreFlags{
+		Op:     imap.StoreFlagsAdd,
+		Silent: true,
+		Flags:  []imap.Flag{imap.FlagDeleted},
+	}, nil).Collect()
+	if err != nil {
+		return result, fmt.Errorf("stripped copy appended, but failed to mark the original UID %d as deleted: %w", uid, err)
+	}
+	c.mutated(Mutation{Op: "delete", Folder: folder, UID: uid, MessageID: messageID})
+	if !c.client.Caps().Has(imap.CapUIDPlus) {
+		return result, nil
+	}
+	if _, err := c.client.UIDExpunge(uidSet).Collect(); err != nil {
+		return result, fmt.Errorf("stripped copy appended, but failed to expunge the original UID %d: %w", uid, err)
+	}
+	c.mutated(Mutation{Op: "expunge", Folder: folder, UID: uid, MessageID: messageID})
+	result.Expunged = true
+	return result, nil
+}
+
+// ApplyScanPolicy handles a message found to carry an infected attachment:
+// it adds opts.Keyword and moves the message to opts.QuarantineFolder,
+// when those are set.
+func (c *IMAPClient) ApplyScanPolicy(folder string, uid uint32, opts *ScanOptions) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return err
+	}
+	if err := c.selectForUIDs(folder); err != nil {
+		return err
+	}
+
+	messageID := c.mutationMessageID(uid)
+	uidSet := imap.UIDSetNum(imap.UID(uid))
+	if opts.Keyword != "" {
+		_, err := c.client.Store(uidSet, &imap.StoreFlags{
+			Op:    imap.StoreFlagsAdd,
+			Flags: []imap.Flag{imap.Flag(opts.Keyword)},
+		}, nil).Collect()
+		if err != nil {
+			return fmt.Errorf("failed to add keyword %s: %w", opts.Keyword, err)
+		}
+		c.mutated(Mutation{Op: "flags", Folder: folder, UID: uid, MessageID: messageID, Add: []string{opts.Keyword}})
+	}
+	if opts.QuarantineFolder != "" {
+		return c.moveSelected(folder, uid, messageID, opts.QuarantineFolder)
+	}
+	return nil
+}
+
+// MoveMessage moves a message to the folder or logical folder dest.
+func (c *IMAPClient) MoveMessage(folder string, uid uint32, dest string) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return err
+	}
+	if err := c.selectForUIDs(folder); err != nil {
+		return err
+	}
+	return c.moveSelected(folder, uid, c.mutationMessageID(uid), dest)
+}
+
+// moveSelected moves uid of the selected folder to dest.
+func (c *IMAPClient) moveSelected(folder string, uid uint32, messageID, dest string) error {
+	dest, err := c.resolveFolder(dest)
+	if err != nil {
+		return err
+	}
+	// Falls back to COPY, STORE \Deleted and EXPUNGE without MOVE
+	if _, err := c.client.Move(imap.UIDSetNum(imap.UID(uid)), dest).Wait(); err != nil {
+		return fmt.Errorf("failed to move message to %s: %w", dest, err)
+	}
+	c.mutated(Mutation{Op: "move", Folder: folder, UID: uid, MessageID: messageID, Dest: dest})
+	return nil
+}
+
+// CreateFolder creates the folder name, with its parents if the server
+// needs them.
+func (c *IMAPClient) CreateFolder(name string) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if err := c.client.Create(name, nil).Wait(); err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", name, err)
+	}
+	return nil
+}
+
+// FetchSnapshotFolder returns the UIDVALIDITY of folder and its messages'
+// UIDs, Message-IDs, flags, internal dates and sizes, for a snapshot or to
+// verify a restore. The role and delimiter are left unset.
+func (c *IMAPClient) FetchSnapshotFolder(folder string) (*SnapshotFolder, error) {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return nil, err
+	}
+	data, _, err := c.selectFolder(folder, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SnapshotFolder{Name: folder, UIDValidity: data.UIDValidity}
+	if data.NumMessages == 0 {
+		return result, nil
+	}
+
+	var all imap.SeqSet
+	all.AddRange(1, 0) // 1:*
+	msgs, err := c.client.Fetch(all, &imap.FetchOptions{
+		UID:          true,
+		Flags:        true,
+		InternalDate: true,
+		RFC822Size:   true,
+		Envelope:     true,
+	}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages of %s: %w", folder, err)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].UID < msgs[j].UID })
+	for _, msg := range msgs {
+		m := SnapshotMessage{
+			UID:          uint32(msg.UID),
+			InternalDate: msg.InternalDate,
+			Size:         msg.RFC822Size,
+		}
+		if msg.Envelope != nil {
+			m.MessageID = msg.Envelope.MessageID
+		}
+		for _, f := range msg.Flags {
+			if f != imap.Flag("\\Recent") {
+				m.Flags = append(m.Flags, string(f))
+			}
+		}
+		result.Messages = append(result.Messages, m)
+	}
+	return result, nil
+}
+
+// FolderFlags are the flags of the messages in a folder, see
+// IMAPClient.FetchFolderFlags.
+type FolderFlags struct {
+	Folder      string              // Server folder name
+	UIDValidity uint32              // UIDs are only valid together with this value
+	Flags       map[uint32][]string // By UID; \Recent is left out
+}
+
+// SyncFolders returns the selectable folders filter selects, in LIST
+// order. Logical folder patterns match the folders ResolveFolder maps them
+// to.
+func (c *IMAPClient) SyncFolders(filter SyncFilter) ([]string, error) {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	folders, err := c.ListFolders(ListFoldersOptions{})
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[string]string)
+	for _, role := range FolderRoles {
+		name, err := c.resolveFolder(role)
+		if err != nil {
+			return nil, err
+		}
+		if roles[name] == "" {
+			roles[name] = role
+		}
+	}
+
+	var names []string
+	for _, f := range folders {
+		if role := roles[f.Name]; role != "" {
+			f.Role = role
+		}
+		if filter.MatchFolder(f) {
+			names = append(names, f.Name)
+		}
+	}
+	return names, nil
+}
+
+// FetchFolderFlags returns the flags of the messages in folder that filter
+// selects by date and size.
+func (c *IMAPClient) FetchFolderFlags(folder string, filter SyncFilter) (*FolderFlags, error) {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return nil, err
+	}
+	data, _, err := c.selectFolder(folder, true)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &FolderFlags{
+		Folder:      folder,
+		UIDValidity: data.UIDValidity,
+		Flags:       make(map[uint32][]string, data.NumMessages),
+	}
+	if data.NumMessages == 0 {
+		return result, nil
+	}
+
+	var all imap.SeqSet
+	all.AddRange(1, 0) // 1:*
+	msgs, err := c.client.Fetch(all, &imap.FetchOptions{
+		UID:          true,
+		Flags:        true,
+		InternalDate: true,
+		RFC822Size:   true,
+	}).Collect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch flags: %w", err)
+	}
+	for _, msg := range msgs {
+		if !filter.MatchMessage(msg.InternalDate, msg.RFC822Size) {
+			continue
+		}
+		flags := make([]string, 0, len(msg.Flags))
+		for _, f := range msg.Flags {
+			if f != imap.Flag("\\Recent") {
+				flags = append(flags, string(f))
+			}
+		}
+		result.Flags[uint32(msg.UID)] = flags
+	}
+	return result, nil
+}
+
+// StoreFlags adds and removes flags of a message.
+func (c *IMAPClient) StoreFlags(folder string, uid uint32, add, remove []string) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return err
+	}
+	if err := c.selectForUIDs(folder); err != nil {
+		return err
+	}
+
+	messageID := c.mutationMessageID(uid)
+	uidSet := imap.UIDSetNum(imap.UID(uid))
+	for _, change := range []struct {
+		op    imap.StoreFlagsOp
+		flags []string
+	}{
+		{imap.StoreFlagsAdd, add},
+		{imap.StoreFlagsDel, remove},
+	} {
+		if len(change.flags) == 0 {
+			continue
+		}
+		flags := make([]imap.Flag, len(change.flags))
+		for i, f := range change.flags {
+			flags[i] = imap.Flag(f)
+		}
+		_, err := c.client.Store(uidSet, &imap.StoreFlags{
+			Op:     change.op,
+			Silent: true,
+			Flags:  flags,
+		}, nil).Collect()
+		if err != nil {
+			return fmt.Errorf("failed to store flags on UID %d: %w", uid, err)
+		}
+	}
+	if len(add)+len(remove) > 0 {
+		c.mutated(Mutation{Op: "flags", Folder: folder, UID: uid, MessageID: messageID, Add: add, Remove: remove})
+	}
+	return nil
+}
+
+// Annotations returns the annotations names of folder, or with no names
+// every annotation emx-mail keeps there apart from those of messages. See
+// annotationRoot for names. Annotations not set are left out. Servers
+// without METADATA fail with ErrMetadataUnsupported.
+func (c *IMAPClient) Annotations(folder string, names ...string) (map[string]string, error) {
+	return c.annotations(folder, false, names)
+}
+
+// ServerAnnotations returns annotations of the server rather than of a
+// folder, like Annotations.
+func (c *IMAPClient) ServerAnnotations(names ...string) (map[string]string, error) {
+	return c.annotations("", true, names)
+}
+
+// SetAnnotations sets annotations of folder to values; an empty value
+// removes the annotation.
+func (c *IMAPClient) SetAnnotations(folder string, values map[string]string) error {
+	return c.setAnnotations(folder, false, values)
+}
+
+// SetServerAnnotations sets annotations of the server, like SetAnnotations.
+func (c *IMAPClient) SetServerAnnotations(values map[string]string) error {
+	return c.setAnnotations("", true, values)
+}
+
+func (c *IMAPClient) annotations(folder string, server bool, names []string) (map[string]string, error) {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	mailbox, err := c.metadataMailbox(folder, server)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]string, len(names))
+	for i, name := range names {
+		if entries[i], err = annotationEntry(name); err != nil {
+			return nil, err
+		}
+	}
+	values, err := c.getMetadata(mailbox, entries, annotationRoot)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(values))
+	for entry, v := range values {
+		if len(names) == 0 && strings.HasPrefix(entry, messageAnnotationRoot+"/") {
+			continue
+		}
+		result[annotationName(entry)] = v
+	}
+	return result, nil
+}
+
+func (c *IMAPClient) setAnnotations(folder string, server bool, values map[string]string) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	mailbox, err := c.metadataMailbox(folder, server)
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]string, len(values))
+	for name, v := range values {
+		entry, err := annotationEntry(name)
+		if err != nil {
+			return err
+		}
+		entries[entry] = v
+	}
+	if err := c.setMetadata(mailbox, entries); err != nil {
+		return err
+	}
+	c.mutated(annotationMutation(mailbox, 0, "", values))
+	return nil
+}
+
+// MessageAnnotations returns the annotations names of message uid in
+// folder, or with no names all of them. See annotationRoot for where they
+// are kept.
+func (c *IMAPClient) MessageAnnotations(folder string, uid uint32, names ...string) (map[string]string, error) {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	if folder, err = c.metadataMailbox(folder, false); err != nil {
+		return nil, err
+	}
+	if err := c.selectForUIDs(folder); err != nil {
+		return nil, err
+	}
+	uidValidity := c.uidValidity[folder]
+	entries := make([]string, len(names))
+	for i, name := range names {
+		if entries[i], err = messageAnnotationEntry(uidValidity, uid, name); err != nil {
+			return nil, err
+		}
+	}
+	prefix := messageAnnotationPrefix(uidValidity, uid)
+	values, err := c.getMetadata(folder, entries, prefix)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(values))
+	for entry, v := range values {
+		if name, ok := strings.CutPrefix(entry, prefix+"/"); ok {
+			result[name] = v
+		}
+	}
+	return result, nil
+}
+
+// SetMessageAnnotations sets annotations of message uid in folder to
+// values; an empty value removes the annotation.
+func (c *IMAPClient) SetMessageAnnotations(folder string, uid uint32, values map[string]string) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if folder, err = c.metadataMailbox(folder, false); err != nil {
+		return err
+	}
+	if err := c.selectForUIDs(folder); err != nil {
+		return err
+	}
+	// Annotations of a missing message would never be found again
+	msgs, err := c.client.Fetch(imap.UIDSetNum(imap.UID(uid)), &imap.FetchOptions{Envelope: true}).Collect()
+	if err != nil {
+		return fmt.Errorf("failed to fetch UID %d: %w", uid, err)
+	}
+	if len(msgs) == 0 {
+		return fmt.Errorf("%w: UID %d in %s", ErrMessageNotFound, uid, folder)
+	}
+	var messageID string
+	if msgs[0].Envelope != nil {
+		messageID = msgs[0].Envelope.MessageID
+	}
+
+	uidValidity := c.uidValidity[folder]
+	entries := make(map[string]string, len(values))
+	for name, v := range values {
+		entry, err := messageAnnotationEntry(uidValidity, uid, name)
+		if err != nil {
+			return err
+		}
+		entries[entry] = v
+	}
+	if err := c.setMetadata(folder, entries); err != nil {
+		return err
+	}
+	c.mutated(annotationMutation(folder, uid, messageID, values))
+	return nil
+}
+
+// metadataMailbox checks that the server supports METADATA and returns the
+// mailbox of the METADATA commands on folder, or "" for the server, which
+// needs only METADATA-SERVER.
+func (c *IMAPClient) metadataMailbox(folder string, server bool) (string, error) {
+	caps := c.client.Caps()
+	if server {
+		if !caps.Has(imap.Cap("METADATA")) && !caps.Has(imap.Cap("METADATA-SERVER")) {
+			return "", ErrMetadataUnsupported
+		}
+		return "", nil
+	}
+	if !caps.Has(imap.Cap("METADATA")) {
+		return "", ErrMetadataUnsupported
+	}
+	return c.resolveFolder(folder)
+}
+
+// getMetadata returns the values of entries of mailbox, or with no entries
+// of root and every entry below it.
+func (c *IMAPClient) getMetadata(mailbox string, entries []string, root string) (map[string]string, error) {
+	var cmd *imapclient.GetMetadataCommand
+	if len(entries) == 0 {
+		cmd = c.client.GetMetadata(mailbox, []string{root}, &imapclient.GetMetadataOptions{
+			Depth: imapclient.GetMetadataDepthInfinity,
+		})
+	} else {
+		cmd = c.client.GetMetadata(mailbox, entries, nil)
+	}
+	data, err := cmd.Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get annotations: %w", err)
+	}
+	values := make(map[string]string, len(data.Entries))
+	for entry, v := range data.Entries {
+		if v != nil {
+			values[entry] = string(*v)
+		}
+	}
+	return values, nil
+}
+
+// setMetadata sets entries of mailbox; an empty value removes the entry.
+func (c *IMAPClient) setMetadata(mailbox string, entries map[string]string) error {
+	values := make(map[string]*[]byte, len(entries))
+	for entry, v := range entries {
+		if v == "" {
+			values[entry] = nil
+			continue
+		}
+		b := []byte(v)
+		values[entry] = &b
+	}
+	if err := c.client.SetMetadata(mailbox, values).Wait(); err != nil {
+		return fmt.Errorf("failed to set annotations: %w", err)
+	}
+	return nil
+}
+
+// Search returns the UIDs of the messages in folder that filter selects,
+// in ascending order.
+func (c *IMAPClient) Search(folder string, filter SearchFilter) ([]uint32, error) {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return nil, err
+	}
+	if _, _, err := c.selectFolder(folder, true); err != nil {
+		return nil, err
+	}
+
+	criteria := &imap.SearchCriteria{Since: filter.Since, Before: filter.Before}
+	if filter.From != "" {
+		criteria.Header = append(criteria.Header, imap.SearchCriteriaHeaderField{Key: "From", Value: filter.From})
+	}
+	if filter.Subject != "" {
+		criteria.Header = append(criteria.Header, imap.SearchCriteriaHeaderField{Key: "Subject", Value: filter.Subject})
+	}
+	if filter.Type != "" {
+		criteria.Header = append(criteria.Header, imap.SearchCriteriaHeaderField{Key: "Content-Type", Value: filter.Type})
+	}
+	for _, f := range filter.Flags {
+		criteria.Flag = append(criteria.Flag, imap.Flag(f))
+	}
+	for _, f := range filter.NotFlags {
+		criteria.NotFlag = append(criteria.NotFlag, imap.Flag(f))
+	}
+	data, err := c.client.UIDSearch(criteria, nil).Wait()
+	if err != nil {
+		return nil, fmt.Errorf("SEARCH failed: %w", err)
+	}
+	uids := data.AllUIDs()
+	out := make([]uint32, len(uids))
+	for i, uid := range uids {
+		out[i] = uint32(uid)
+	}
+	return out, nil
+}
+
+// StoreFlagsBulk adds and removes flags of many messages with one UID STORE
+// command per change, however many messages there are.
+func (c *IMAPClient) StoreFlagsBulk(folder string, uids []uint32, add, remove []string) error {
+	if len(uids) == 0 || len(add)+len(remove) == 0 {
+		return nil
+	}
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return err
+	}
+	if err := c.selectForUIDs(folder); err != nil {
+		return err
+	}
+
+	var uidSet imap.UIDSet
+	for _, uid := range uids {
+		uidSet.AddNum(imap.UID(uid))
+	}
+	for _, change := range []struct {
+		op    imap.StoreFlagsOp
+		flags []string
+	}{
+		{imap.StoreFlagsAdd, add},
+		{imap.StoreFlagsDel, remove},
+	} {
+		if len(change.flags) == 0 {
+			continue
+		}
+		flags := make([]imap.Flag, len(change.flags))
+		for i, f := range change.flags {
+			flags[i] = imap.Flag(f)
+		}
+		_, err := c.client.Store(uidSet, &imap.StoreFlags{
+			Op:     change.op,
+			Silent: true,
+			Flags:  flags,
+		}, nil).Collect()
+		if err != nil {
+			return fmt.Errorf("failed to store flags on %d messages: %w", len(uids), err)
+		}
+	}
+	// Looking up every Message-ID would cost a FETCH per message
+	for _, uid := range uids {
+		c.mutated(Mutation{Op: "flags", Folder: folder, UID: uid, Add: add, Remove: remove})
+	}
+	return nil
+}
+
+// FetchMessageByID implements MailReceiver.
+func (c *IMAPClient) FetchMessageByID(folder string, uid uint32) (*Message, error) {
+	return c.FetchMessage(folder, uid)
+}
+
+// DeleteMessageByID implements MailReceiver.
+func (c *IMAPClient) DeleteMessageByID(folder string, uid uint32, expunge bool) error {
+	return c.DeleteMessage(folder, uid, expunge)
+}
+
+// MarkAsSeen marks a message as seen
+func (c *IMAPClient) MarkAsSeen(folder string, uid uint32) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if folder, err = c.resolveFolder(folder); err != nil {
+		return err
+	}
+
+	if err := c.selectForUIDs(folder); err != nil {
+		return err
+	}
+
+	messageID := c.mutationMessageID(uid)
+	uidSet := imap.UIDSetNum(imap.UID(uid))
+	_, err = c.client.Store(uidSet, &imap.StoreFlags{
+		Op:    imap.StoreFlagsAdd,
+		Flags: []imap.Flag{imap.FlagSeen},
+	}, nil).Collect()
+	if err != nil {
+		return fmt.Errorf("failed to mark message as seen: %w", err)
+	}
+	c.mutated(Mutation{Op: "flags", Folder: folder, UID: uid, MessageID: messageID, Add: []string{`\Seen`}})
+
+	return nil
+}
+
+// Ping sends a NOOP command to keep the connection alive
 func (c *IMAPClient) Ping() error {
 	if c.client == nil {
 		return nil
@@ -387,21 +2012,64 @@ func (c *IMAPClient) Ping() error {
 
 // convertIMAPFetchBuffer converts a FetchMessageBuffer to our Message
 func convertIMAPFetchBuffer(buf *imapclient.FetchMessageBuffer) *Message {
-	msg := &Message{
-		UID:    uint32(buf.UID),
-		SeqNum: buf.SeqNum,
+	msg := &Message{}
+	fillIMAPMessage(msg, buf, make([]Address, 0, imapAddressCount(buf)))
+	return msg
+}
+
+// convertIMAPFetchBuffers converts a fetched batch to Messages in reverse
+// order, so the newest message comes first. Messages and addresses are
+// carved out of two slices sized up front instead of being allocated one
+// by one, which dominates listing cost for large folders.
+func convertIMAPFetchBuffers(bufs []*imapclient.FetchMessageBuffer) []*Message {
+	nAddrs := 0
+	for _, buf := range bufs {
+		nAddrs += imapAddressCount(buf)
+	}
+
+	slab := make([]Message, len(bufs))
+	addrs := make([]Address, 0, nAddrs)
+	out := make([]*Message, len(bufs))
+	for i, buf := range bufs {
+		msg := &slab[i]
+		addrs = fillIMAPMessage(msg, buf, addrs)
+		out[len(bufs)-1-i] = msg
+	}
+	return out
+}
+
+// imapAddressCount returns the number of envelope addresses fillIMAPMessage
+// stores for buf.
+func imapAddressCount(buf *imapclient.FetchMessageBuffer) int {
+	env := buf.Envelope
+	if env == nil {
+		return 0
 	}
+	return len(env.From) + len(env.To) + len(env.Cc) + len(env.Bcc)
+}
+
+// fillIMAPMessage populates msg from buf. Addresses are appended to addrs,
+// whose spare capacity should hold imapAddressCount(buf) entries, and the
+// extended slice is returned.
+func fillIMAPMessage(msg *Message, buf *imapclient.FetchMessageBuffer, addrs []Address) []Address {
+	msg.UID = uint32(buf.UID)
+	msg.SeqNum = buf.SeqNum
 
 	if env := buf.Envelope; env != nil {
+		// The client decodes envelopes with wordDecoder already
 		msg.Subject = env.Subject
 		msg.Date = env.Date
 		msg.MessageID = env.MessageID
 		msg.InReplyTo = strings.Join(env.InReplyTo, " ")
 		msg.References = env.InReplyTo // best effort from envelope
-		msg.From = convertIMAPAddresses(env.From)
-		msg.To = convertIMAPAddresses(env.To)
-		msg.Cc = convertIMAPAddresses(env.Cc)
-		msg.Bcc = convertIMAPAddresses(env.Bcc)
+		msg.From, addrs = appendIMAPAddresses(addrs, env.From)
+		msg.To, addrs = appendIMAPAddresses(addrs, env.To)
+		msg.Cc, addrs = appendIMAPAddresses(addrs, env.Cc)
+		msg.Bcc, addrs = appendIMAPAddresses(addrs, env.Bcc)
+	}
+
+	if buf.BodyStructure != nil {
+		msg.Structure = convertIMAPBodyStructure(buf.BodyStructure, "")
 	}
 
 	// Convert flags
@@ -420,30 +2088,80 @@ func convertIMAPFetchBuffer(buf *imapclient.FetchMessageBuffer) *Message {
 		}
 	}
 
-	return msg
+	return addrs
+}
+
+// convertIMAPBodyStructure converts a BODYSTRUCTURE tree to Parts. number
+// is the part number of bs, "" for the message root.
+func convertIMAPBodyStructure(bs imap.BodyStructure, number string) *Part {
+	p := &Part{Number: number, ContentType: bs.MediaType()}
+	if d := bs.Disposition(); d != nil {
+		p.Disposition = strings.ToLower(d.Value)
+	}
+
+	switch bs := bs.(type) {
+	case *imap.BodyStructureMultiPart:
+		if bs.Extended != nil {
+			p.Params = bs.Extended.Params
+		}
+		for i, child := range bs.Children {
+			p.Parts = append(p.Parts, convertIMAPBodyStructure(child, childPartNumber(number, i+1)))
+		}
+	case *imap.BodyStructureSinglePart:
+		if p.Number == "" {
+			// A single-part message body is part 1
+			p.Number = "1"
+		}
+		p.Params = bs.Params
+		p.ID = bs.ID
+		p.Description = decodeHeaderValue(bs.Description)
+		p.Encoding = strings.ToLower(bs.Encoding)
+		p.Size = bs.Size
+		p.Filename = decodeHeaderValue(bs.Filename())
+		if bs.Text != nil {
+			p.Lines = bs.Text.NumLines
+		}
+		if rfc822 := bs.MessageRFC822; rfc822 != nil {
+			p.Lines = rfc822.NumLines
+			if inner := rfc822.BodyStructure; inner != nil {
+				// The parts of an encapsulated multipart are numbered
+				// directly below this part; a single-part body is <number>.1
+				innerNumber := p.Number
+				if _, ok := inner.(*imap.BodyStructureMultiPart); !ok {
+					innerNumber = childPartNumber(p.Number, 1)
+				}
+				p.Parts = []*Part{convertIMAPBodyStructure(inner, innerNumber)}
+			}
+		}
+	}
+	return p
 }
 
-// convertIMAPAddresses converts IMAP addresses to our Addresses
-func convertIMAPAddresses(addrs []imap.Address) []Address {
-	result := make([]Address, 0, len(addrs))
+// appendIMAPAddresses converts IMAP addresses to our Addresses, appending
+// them to dst. It returns the converted part, capped so that appending to it
+// cannot overwrite later entries of dst, and the extended dst.
+func appendIMAPAddresses(dst []Address, addrs []imap.Address) (part, rest []Address) {
+	start := len(dst)
 	for _, a := range addrs {
-		result = append(result, Address{
-			Name:  a.Name,
+		dst = append(dst, Address{
+			Name:  decodeHeaderValue(a.Name),
 			Email: a.Addr(),
 		})
 	}
-	return result
+	return dst[start:len(dst):len(dst)], dst
 }
 
 // parseIMAPMessageBody parses raw RFC 5322 message bytes into text/html body
 func parseIMAPMessageBody(msg *Message, raw []byte) {
 	r := bytes.NewReader(raw)
 	entity, err := gomessage.Read(r)
-	if err != nil {
+	if !isRecoverableEntityError(err) {
 		// Fallback: treat as plain text
-		msg.TextBody = string(raw)
+		msg.TextBody = strings.ToValidUTF8(string(raw), "\uFFFD")
+		msg.Preview = MakePreview(msg.TextBody, "")
 		return
 	}
 
+	parseSpamHeaders(msg, entity.Header)
 	parseEntityBody(msg, entity)
 }
@@ -1,91 +1,37 @@
 package email
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
-	"github.com/emersion/go-imap/v2/imapserver"
-	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+
+	"github.com/emx-mail/cli/pkgs/testutil"
 )
 
 // ---------------------------------------------------------------------------
-// IMAP mock server helper
+// IMAP test helpers
 // ---------------------------------------------------------------------------
 
-const (
-	imapTestUser = "testuser"
-	imapTestPass = "testpass"
-)
-
-// newTestIMAPServer starts an in-memory IMAP server and returns the listen
-// address.  Caller must eventually call srv.Close() (done via t.Cleanup).
-func newTestIMAPServer(t *testing.T) (addr string, memSrv *imapmemserver.Server) {
-	t.Helper()
-
-	memSrv = imapmemserver.New()
-	user := imapmemserver.NewUser(imapTestUser, imapTestPass)
-	user.Create("INBOX", nil)
-	memSrv.AddUser(user)
-
-	srv := imapserver.New(&imapserver.Options{
-		NewSession: func(_ *imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
-			return memSrv.NewSession(), nil, nil
-		},
-		InsecureAuth: true,
-		Caps: imap.CapSet{
-			imap.CapIMAP4rev1: {},
-		},
-	})
-
-	ln, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	go srv.Serve(ln)
-	t.Cleanup(func() { srv.Close() })
-
-	return ln.Addr().String(), memSrv
-}
-
-// appendTestMail appends a raw RFC 5322 message to the given mailbox via
-// a direct IMAP client (not through our wrapper).
-func appendTestMail(t *testing.T, addr, mailbox, rawMsg string) {
-	t.Helper()
-
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		t.Fatal(err)
-	}
-	c := imapclient.New(conn, nil)
-	if err := c.Login(imapTestUser, imapTestPass).Wait(); err != nil {
-		t.Fatal(err)
-	}
-
-	appendCmd := c.Append(mailbox, int64(len(rawMsg)), nil)
-	if _, err := appendCmd.Write([]byte(rawMsg)); err != nil {
-		t.Fatal(err)
-	}
-	if err := appendCmd.Close(); err != nil {
-		t.Fatal(err)
-	}
-	if _, err := appendCmd.Wait(); err != nil {
-		t.Fatal(err)
-	}
-	c.Close()
-}
-
 // newIMAPTestClient creates an IMAPClient pointed at the test server.
 func newIMAPTestClient(t *testing.T, addr string) *IMAPClient {
 	t.Helper()
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 	client := NewIMAPClient(IMAPConfig{
 		Host:     host,
 		Port:     port,
-		Username: imapTestUser,
-		Password: imapTestPass,
+		Username: testutil.Username,
+		Password: testutil.Password,
 	})
 	if err := client.Connect(); err != nil {
 		t.Fatal(err)
@@ -99,14 +45,14 @@ func newIMAPTestClient(t *testing.T, addr string) *IMAPClient {
 // ---------------------------------------------------------------------------
 
 func TestIMAPConnect(t *testing.T) {
-	addr, _ := newTestIMAPServer(t)
-	host, port := splitHostPort(t, addr)
+	addr, _ := testutil.NewIMAPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewIMAPClient(IMAPConfig{
 		Host:     host,
 		Port:     port,
-		Username: imapTestUser,
-		Password: imapTestPass,
+		Username: testutil.Username,
+		Password: testutil.Password,
 	})
 	if err := client.Connect(); err != nil {
 		t.Fatalf("Connect() error: %v", err)
@@ -115,8 +61,8 @@ func TestIMAPConnect(t *testing.T) {
 }
 
 func TestIMAPConnect_BadCredentials(t *testing.T) {
-	addr, _ := newTestIMAPServer(t)
-	host, port := splitHostPort(t, addr)
+	addr, _ := testutil.NewIMAPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewIMAPClient(IMAPConfig{
 		Host:     host,
@@ -130,11 +76,44 @@ func TestIMAPConnect_BadCredentials(t *testing.T) {
 	}
 }
 
+func TestIMAPConnect_DialAndDebugWriter(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
+
+	var dialed []string
+	var debug strings.Builder
+	client := NewIMAPClient(IMAPConfig{
+		Host:     host,
+		Port:     port,
+		Username: testutil.Username,
+		Password: testutil.Password,
+		Dial: func(network, addr string) (net.Conn, error) {
+			dialed = append(dialed, network+" "+addr)
+			return net.Dial(network, addr)
+		},
+		DebugWriter: &debug,
+	})
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect() error: %v", err)
+	}
+	if _, err := client.ListFolders(ListFoldersOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	if len(dialed) != 1 || dialed[0] != "tcp "+addr {
+		t.Errorf("dialed = %v, want [tcp %s]", dialed, addr)
+	}
+	if !strings.Contains(debug.String(), "LOGIN") || !strings.Contains(debug.String(), "LIST") {
+		t.Errorf("debug output lacks the LOGIN and LIST commands:\n%s", debug.String())
+	}
+}
+
 func TestIMAPListFolders(t *testing.T) {
-	addr, _ := newTestIMAPServer(t)
+	addr, _ := testutil.NewIMAPServer(t)
 	client := newIMAPTestClient(t, addr)
 
-	folders, err := client.ListFolders()
+	folders, err := client.ListFolders(ListFoldersOptions{})
 	if err != nil {
 		t.Fatalf("ListFolders() error: %v", err)
 	}
@@ -150,8 +129,152 @@ func TestIMAPListFolders(t *testing.T) {
 	}
 }
 
+func TestIMAPSubscribedFolders(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.CreateIMAPMailboxes(t, addr, "Lists", "Shared")
+	client := newIMAPTestClient(t, addr)
+
+	subscribed := func() map[string]bool {
+		t.Helper()
+		folders, err := client.ListFolders(ListFoldersOptions{Subscribed: true})
+		if err != nil {
+			t.Fatalf("ListFolders(Subscribed) error: %v", err)
+		}
+		names := make(map[string]bool)
+		for _, f := range folders {
+			if !f.Subscribed {
+				t.Errorf("%s listed but not marked subscribed", f.Name)
+			}
+			names[f.Name] = true
+		}
+		return names
+	}
+
+	if err := client.Subscribe("Lists"); err != nil {
+		t.Fatalf("Subscribe() error: %v", err)
+	}
+	if got := subscribed(); !got["Lists"] || got["Shared"] {
+		t.Errorf("subscribed = %v, want Lists and not Shared", got)
+	}
+	if err := client.Unsubscribe("Lists"); err != nil {
+		t.Fatalf("Unsubscribe() error: %v", err)
+	}
+	if got := subscribed(); got["Lists"] {
+		t.Errorf("subscribed = %v after unsubscribing from Lists", got)
+	}
+}
+
+func TestIMAPListFolders_Hierarchy(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.CreateIMAPMailboxes(t, addr, "Archive/2023", "Archive/2024")
+	testutil.AppendIMAPMessages(t, addr, "Archive/2024", testMailRFC822, testMailRFC822)
+
+	client := newIMAPTestClient(t, addr)
+	folders, err := client.ListFolders(ListFoldersOptions{Counts: true})
+	if err != nil {
+		t.Fatalf("ListFolders() error: %v", err)
+	}
+
+	byName := make(map[string]Folder)
+	for _, f := range folders {
+		byName[f.Name] = f
+	}
+	f, ok := byName["Archive/2024"]
+	if !ok {
+		t.Fatalf("Archive/2024 not listed: %v", folders)
+	}
+	if f.Delim != '/' {
+		t.Errorf("Delim = %q, want '/'", f.Delim)
+	}
+	if f.Status == nil || f.Status.Messages != 2 || f.Status.Unseen != 2 {
+		t.Errorf("Status = %+v, want 2 messages, 2 unseen", f.Status)
+	}
+
+	roots := BuildFolderTree(folders)
+	var archive *FolderNode
+	for _, n := range roots {
+		if n.Name == "Archive" {
+			archive = n
+		}
+	}
+	if archive == nil || len(archive.Children) != 2 || archive.Children[1].Label != "2024" {
+		t.Errorf("Archive is not the parent of 2023 and 2024")
+	}
+}
+
+func TestIMAPResolveFolder(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.CreateIMAPMailboxes(t, addr, "Sent Items")
+	testutil.AppendIMAPMessage(t, addr, "Sent Items", testMailRFC822)
+
+	host, port := testutil.SplitHostPort(t, addr)
+	client := NewIMAPClient(IMAPConfig{
+		Host: host, Port: port,
+		Username: testutil.Username, Password: testutil.Password,
+		Folders: map[string]string{FolderSent: "Sent Items"},
+	})
+	defer client.Close()
+
+	tests := []struct{ name, want string }{
+		{"", "INBOX"},
+		{"inbox", "INBOX"},
+		{"SENT", "Sent Items"},
+		{"Sent Items", "Sent Items"},
+		{"trash", "trash"}, // no mapping and no SPECIAL-USE folder
+	}
+	for _, tt := range tests {
+		got, err := client.ResolveFolder(tt.name)
+		if err != nil {
+			t.Fatalf("ResolveFolder(%q) error: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("ResolveFolder(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	result, err := client.FetchMessages(FetchOptions{Folder: "sent", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Folder != "Sent Items" || len(result.Messages) != 1 {
+		t.Errorf("FetchMessages(sent) = %s with %d messages, want Sent Items with 1", result.Folder, len(result.Messages))
+	}
+}
+
+func TestIMAPCapabilities(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	client := newIMAPTestClient(t, addr)
+
+	caps, err := client.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsString(caps.List, "IMAP4rev1") {
+		t.Errorf("capabilities = %v, want IMAP4rev1", caps.List)
+	}
+	for _, f := range caps.Features {
+		want := containsString(caps.List, f.Capability) || containsString(caps.List, "IMAP4rev2")
+		if f.Enabled != want {
+			t.Errorf("feature %s enabled = %v, want %v", f.Capability, f.Enabled, want)
+		}
+	}
+}
+
+func TestIMAPAnnotations_Unsupported(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	client := newIMAPTestClient(t, addr)
+
+	// The test server has no METADATA
+	if _, err := client.Annotations("INBOX"); !errors.Is(err, ErrMetadataUnsupported) {
+		t.Errorf("Annotations() error = %v, want ErrMetadataUnsupported", err)
+	}
+	if err := client.SetMessageAnnotations("INBOX", 1, map[string]string{"state": "done"}); !errors.Is(err, ErrMetadataUnsupported) {
+		t.Errorf("SetMessageAnnotations() error = %v, want ErrMetadataUnsupported", err)
+	}
+}
+
 func TestIMAPFetchMessages_Empty(t *testing.T) {
-	addr, _ := newTestIMAPServer(t)
+	addr, _ := testutil.NewIMAPServer(t)
 	client := newIMAPTestClient(t, addr)
 
 	result, err := client.FetchMessages(FetchOptions{Folder: "INBOX", Limit: 10})
@@ -164,8 +287,8 @@ func TestIMAPFetchMessages_Empty(t *testing.T) {
 }
 
 func TestIMAPFetchMessages_WithMail(t *testing.T) {
-	addr, _ := newTestIMAPServer(t)
-	appendTestMail(t, addr, "INBOX", testMailRFC822)
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessage(t, addr, "INBOX", testMailRFC822)
 
 	client := newIMAPTestClient(t, addr)
 
@@ -185,8 +308,8 @@ func TestIMAPFetchMessages_WithMail(t *testing.T) {
 }
 
 func TestIMAPFetchMessage_ByUID(t *testing.T) {
-	addr, _ := newTestIMAPServer(t)
-	appendTestMail(t, addr, "INBOX", testMailRFC822)
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessage(t, addr, "INBOX", testMailRFC822)
 
 	client := newIMAPTestClient(t, addr)
 
@@ -202,12 +325,12 @@ func TestIMAPFetchMessage_ByUID(t *testing.T) {
 
 	// Reconnect — FetchMessage calls ensureConnected
 	client.Close()
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 	client2 := NewIMAPClient(IMAPConfig{
 		Host:     host,
 		Port:     port,
-		Username: imapTestUser,
-		Password: imapTestPass,
+		Username: testutil.Username,
+		Password: testutil.Password,
 	})
 
 	msg, err := client2.FetchMessage("INBOX", uid)
@@ -225,8 +348,8 @@ func TestIMAPFetchMessage_ByUID(t *testing.T) {
 }
 
 func TestIMAPFetchMessage_Multipart(t *testing.T) {
-	addr, _ := newTestIMAPServer(t)
-	appendTestMail(t, addr, "INBOX", testMailMultipart)
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessage(t, addr, "INBOX", testMailMultipart)
 
 	client := newIMAPTestClient(t, addr)
 
@@ -237,10 +360,10 @@ func TestIMAPFetchMessage_Multipart(t *testing.T) {
 	uid := result.Messages[0].UID
 
 	client.Close()
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 	client2 := NewIMAPClient(IMAPConfig{
 		Host: host, Port: port,
-		Username: imapTestUser, Password: imapTestPass,
+		Username: testutil.Username, Password: testutil.Password,
 	})
 	defer client2.Close()
 
@@ -258,8 +381,8 @@ func TestIMAPFetchMessage_Multipart(t *testing.T) {
 }
 
 func TestIMAPFetchMessage_NestedMultipart(t *testing.T) {
-	addr, _ := newTestIMAPServer(t)
-	appendTestMail(t, addr, "INBOX", testMailNested)
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessage(t, addr, "INBOX", testMailNested)
 
 	client := newIMAPTestClient(t, addr)
 
@@ -267,10 +390,10 @@ func TestIMAPFetchMessage_NestedMultipart(t *testing.T) {
 	uid := result.Messages[0].UID
 
 	client.Close()
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 	c2 := NewIMAPClient(IMAPConfig{
 		Host: host, Port: port,
-		Username: imapTestUser, Password: imapTestPass,
+		Username: testutil.Username, Password: testutil.Password,
 	})
 	defer c2.Close()
 
@@ -290,9 +413,192 @@ func TestIMAPFetchMessage_NestedMultipart(t *testing.T) {
 	}
 }
 
+func TestIMAPFetchMessage_Structure(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessage(t, addr, "INBOX", testMailNested)
+	testutil.AppendIMAPMessage(t, addr, "INBOX", testMailRFC822)
+
+	client := newIMAPTestClient(t, addr)
+	result, err := client.FetchMessages(FetchOptions{Folder: "INBOX", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages come newest first
+	nested, err := client.FetchMessage("INBOX", result.Messages[1].UID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := nested.Structure
+	if root == nil {
+		t.Fatal("Structure not populated")
+	}
+	if root.Number != "" || root.ContentType != "multipart/mixed" || len(root.Parts) != 2 {
+		t.Fatalf("root = %+v", root)
+	}
+
+	tests := []struct {
+		number, contentType, disposition, filename string
+	}{
+		{"1", "multipart/alternative", "", ""},
+		{"1.1", "text/plain", "", ""},
+		{"1.2", "text/html", "", ""},
+		{"2", "image/png", "attachment", "image.png"},
+	}
+	for _, tt := range tests {
+		p := root.Find(tt.number)
+		if p == nil {
+			t.Errorf("part %s not found", tt.number)
+			continue
+		}
+		if p.ContentType != tt.contentType || p.Disposition != tt.disposition || p.Filename != tt.filename {
+			t.Errorf("part %s = %s %q %q, want %s %q %q", tt.number,
+				p.ContentType, p.Disposition, p.Filename, tt.contentType, tt.disposition, tt.filename)
+		}
+	}
+	if p := root.Find("1.1"); p.Size == 0 || p.Params["charset"] != "utf-8" {
+		t.Errorf("part 1.1 size = %d, params = %v", p.Size, p.Params)
+	}
+
+	single, err := client.FetchMessage("INBOX", result.Messages[0].UID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := single.Structure; p == nil || p.Number != "1" || p.ContentType != "text/plain" || len(p.Parts) != 0 {
+		t.Errorf("single-part structure = %+v", p)
+	}
+}
+
+func TestIMAPFetchRawMessages(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	sources := []string{testMailRFC822, testMailMultipart, testMailNested}
+	testutil.AppendIMAPMessages(t, addr, "INBOX", sources...)
+
+	client := newIMAPTestClient(t, addr)
+
+	var got []uint32
+	err := client.FetchRawMessages("inbox", []uint32{3, 1, 99, 2}, 2, func(uid uint32, raw []byte) error {
+		got = append(got, uid)
+		if string(raw) != sources[uid-1] {
+			t.Errorf("UID %d: got %d bytes, want message %d", uid, len(raw), uid)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("FetchRawMessages() error: %v", err)
+	}
+	// The missing UID 99 is skipped; the others come in the requested order
+	if fmt.Sprint(got) != "[3 1 2]" {
+		t.Errorf("fetched UIDs %v, want [3 1 2]", got)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = client.FetchRawMessages("INBOX", []uint32{1, 2, 3}, 0, func(uint32, []byte) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("FetchRawMessages() = %v after %d calls, want the callback's error after 1", err, calls)
+	}
+
+	// The connection is still usable after stopping with commands in flight
+	if _, err := client.FetchRawMessage("INBOX", 2); err != nil {
+		t.Errorf("FetchRawMessage() after stopping: %v", err)
+	}
+}
+
+func TestIMAPFetchRawMessages_Chunked(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	sources := []string{testMailRFC822, testMailMultipart, testMailNested}
+	testutil.AppendIMAPMessages(t, addr, "INBOX", sources...)
+
+	host, port := testutil.SplitHostPort(t, addr)
+	done := make(map[uint32]int64)
+	client := NewIMAPClient(IMAPConfig{
+		Host:      host,
+		Port:      port,
+		Username:  testutil.Username,
+		Password:  testutil.Password,
+		ChunkSize: 64,
+		FetchProgress: func(uid uint32, n, total int64) {
+			if n <= done[uid] || n > total {
+				t.Errorf("UID %d: progress %d of %d after %d", uid, n, total, done[uid])
+			}
+			done[uid] = n
+		},
+	})
+	defer client.Close()
+
+	err := client.FetchRawMessages("INBOX", []uint32{2, 1, 3}, 0, func(uid uint32, raw []byte) error {
+		if string(raw) != sources[uid-1] {
+			t.Errorf("UID %d: got %q, want message %d", uid, raw, uid)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("FetchRawMessages() error: %v", err)
+	}
+	for uid, src := range sources {
+		if done[uint32(uid+1)] != int64(len(src)) {
+			t.Errorf("UID %d: progress ended at %d of %d bytes", uid+1, done[uint32(uid+1)], len(src))
+		}
+	}
+
+	// Another fetch of the message reports its progress from the start
+	done[2] = 0
+	raw, err := client.FetchRawMessage("INBOX", 2)
+	if err != nil || string(raw) != sources[1] {
+		t.Errorf("FetchRawMessage() = %d bytes, %v", len(raw), err)
+	}
+	if done[2] != int64(len(sources[1])) {
+		t.Errorf("UID 2: progress of FetchRawMessage ended at %d of %d bytes", done[2], len(sources[1]))
+	}
+}
+
+func TestIMAPSeqUIDs(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessages(t, addr, "INBOX", testMailRFC822, testMailRFC822, testMailRFC822, testMailRFC822)
+
+	client := newIMAPTestClient(t, addr)
+	// Expunge the first message so sequence numbers and UIDs differ
+	if err := client.DeleteMessage("INBOX", 1, true); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		set  string
+		want string
+	}{
+		{"1", "[2]"},
+		{"2:*", "[3 4]"},
+		{"1,3", "[2 4]"},
+		{"*", "[4]"},
+		{"1:2,*", "[2 3 4]"},
+		{"-2", "[3 4]"},
+		{"-10", "[2 3 4]"},
+	}
+	for _, tt := range tests {
+		uids, err := client.SeqUIDs("inbox", tt.set)
+		if err != nil {
+			t.Errorf("SeqUIDs(%q) error: %v", tt.set, err)
+			continue
+		}
+		if got := fmt.Sprint(uids); got != tt.want {
+			t.Errorf("SeqUIDs(%q) = %s, want %s", tt.set, got, tt.want)
+		}
+	}
+
+	for _, set := range []string{"", "a:b", "-0", "-x", "0", "1:", "1,,2"} {
+		if _, err := client.SeqUIDs("INBOX", set); err == nil {
+			t.Errorf("SeqUIDs(%q) accepted an invalid set", set)
+		}
+	}
+}
+
 func TestIMAPDeleteMessage(t *testing.T) {
-	addr, _ := newTestIMAPServer(t)
-	appendTestMail(t, addr, "INBOX", testMailRFC822)
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessage(t, addr, "INBOX", testMailRFC822)
 
 	client := newIMAPTestClient(t, addr)
 
@@ -317,8 +623,8 @@ func TestIMAPDeleteMessage(t *testing.T) {
 }
 
 func TestIMAPMarkAsSeen(t *testing.T) {
-	addr, _ := newTestIMAPServer(t)
-	appendTestMail(t, addr, "INBOX", testMailRFC822)
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessage(t, addr, "INBOX", testMailRFC822)
 
 	client := newIMAPTestClient(t, addr)
 
@@ -336,8 +642,240 @@ func TestIMAPMarkAsSeen(t *testing.T) {
 	}
 }
 
+func TestIMAPStoreFlagsBulk(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	for i := 0; i < 3; i++ {
+		testutil.AppendIMAPMessage(t, addr, "INBOX", testMailRFC822)
+	}
+
+	client := newIMAPTestClient(t, addr)
+	unseen := SearchFilter{NotFlags: []string{`\Seen`}}
+	uids, err := client.Search("INBOX", unseen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uids) != 3 {
+		t.Fatalf("Search = %v, want 3 unread messages", uids)
+	}
+	if err := client.StoreFlagsBulk("INBOX", uids, []string{`\Seen`}, nil); err != nil {
+		t.Fatalf("StoreFlagsBulk() error: %v", err)
+	}
+
+	if uids, _ = client.Search("INBOX", unseen); len(uids) != 0 {
+		t.Errorf("unread after marking all read: %v", uids)
+	}
+	seen, _ := client.Search("INBOX", SearchFilter{Flags: []string{`\Seen`}})
+	if len(seen) != 3 {
+		t.Errorf("read after marking all read: %v, want 3", seen)
+	}
+}
+
+func TestIMAPUIDValidityChanged(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.CreateIMAPMailboxes(t, addr, "Work")
+	testutil.AppendIMAPMessage(t, addr, "Work", testMailRFC822)
+
+	host, port := testutil.SplitHostPort(t, addr)
+	var changed []uint32
+	client := NewIMAPClient(IMAPConfig{
+		Host:     host,
+		Port:     port,
+		Username: testutil.Username,
+		Password: testutil.Password,
+		UIDValidityChanged: func(folder string, old, new uint32) {
+			changed = append(changed, old, new)
+		},
+	})
+	list, err := client.FetchMessages(FetchOptions{Folder: "Work", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(list.Messages))
+	}
+	staleUID := list.Messages[0].UID
+
+	// Another client recreates the folder, which renumbers it
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := imapclient.New(conn, nil)
+	defer other.Close()
+	if err := other.Login(testutil.Username, testutil.Password).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Delete("Work").Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Create("Work", nil).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	testutil.AppendIMAPMessage(t, addr, "Work", testMailRFC822)
+
+	err = client.StoreFlags("Work", staleUID, []string{`\Flagged`}, nil)
+	if !errors.Is(err, ErrUIDValidityChanged) {
+		t.Fatalf("StoreFlags with a stale UID: err = %v, want ErrUIDValidityChanged", err)
+	}
+	if len(changed) != 2 || changed[0] != list.UIDValidity || changed[1] == changed[0] {
+		t.Errorf("UIDValidityChanged calls = %v, want old %d and a new value", changed, list.UIDValidity)
+	}
+
+	// UIDs read after the change work again
+	list, err = client.FetchMessages(FetchOptions{Folder: "Work", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.StoreFlags("Work", list.Messages[0].UID, []string{`\Flagged`}, nil); err != nil {
+		t.Errorf("StoreFlags after listing again: %v", err)
+	}
+	if len(changed) != 2 {
+		t.Errorf("UIDValidityChanged called again: %v", changed)
+	}
+}
+
+func TestIMAPWatchFunc(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessage(t, addr, "INBOX", testMailRFC822)
+
+	host, port := testutil.SplitHostPort(t, addr)
+	client := NewIMAPClient(IMAPConfig{
+		Host:     host,
+		Port:     port,
+		Username: testutil.Username,
+		Password: testutil.Password,
+	})
+
+	var msgs []*Message
+	var raws []string
+	opts := WatchOptions{Folder: "INBOX", Once: true, Status: func(WatchStatus) {}}
+	err := client.WatchFunc(context.Background(), opts, func(msg *Message, raw io.Reader) error {
+		data, err := io.ReadAll(raw)
+		msgs = append(msgs, msg)
+		raws = append(raws, string(data))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WatchFunc() error: %v", err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("handler called %d times, want 1", len(msgs))
+	}
+	if msgs[0].Subject != "Test Subject" || msgs[0].MessageID != "test-1@example.com" || msgs[0].UID == 0 {
+		t.Errorf("msg = %+v", msgs[0])
+	}
+	if !strings.Contains(raws[0], "Hello, World!") {
+		t.Errorf("raw email = %q", raws[0])
+	}
+
+	// The email was marked as processed
+	check := newIMAPTestClient(t, addr)
+	result, err := check.FetchMessages(FetchOptions{Folder: "INBOX", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Messages[0].Flags.Seen {
+		t.Error("expected Seen flag after the handler succeeded")
+	}
+
+	if err := client.WatchFunc(context.Background(), WatchOptions{HandlerCmd: "cat"}, func(*Message, io.Reader) error { return nil }); err == nil {
+		t.Error("expected error for HandlerCmd with a message handler")
+	}
+}
+
+func TestIMAPWatchFunc_AutoResponder(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	autoReply := strings.Replace(testMailRFC822, "Message-Id: <test-1@example.com>", "Message-Id: <test-2@example.com>\r\nAuto-Submitted: auto-replied", 1)
+	ownReply := strings.Replace(testMailRFC822, "Message-Id: <test-1@example.com>", "Message-Id: <test-3@example.com>\r\nX-Loop: rcpt@example.com", 1)
+	testutil.AppendIMAPMessages(t, addr, "INBOX", testMailRFC822, autoReply, ownReply)
+
+	host, port := testutil.SplitHostPort(t, addr)
+	client := NewIMAPClient(IMAPConfig{
+		Host:     host,
+		Port:     port,
+		Username: testutil.Username,
+		Password: testutil.Password,
+	})
+
+	var handled []string
+	var skipped int
+	opts := WatchOptions{Folder: "INBOX", Once: true, AutoResponder: true, Status: func(s WatchStatus) {
+		if strings.Contains(s.Message, "Not answering") {
+			skipped++
+		}
+	}}
+	err := client.WatchFunc(context.Background(), opts, func(msg *Message, raw io.Reader) error {
+		handled = append(handled, msg.MessageID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WatchFunc() error: %v", err)
+	}
+	// Mail from the account's own domain is answered; the auto-reply and
+	// our own looped mail are not
+	if len(handled) != 1 || handled[0] != "test-1@example.com" || skipped != 2 {
+		t.Errorf("handled = %v, skipped = %d; want [test-1@example.com] and 2", handled, skipped)
+	}
+
+	// The skipped emails were marked as processed too
+	check := newIMAPTestClient(t, addr)
+	result, err := check.FetchMessages(FetchOptions{Folder: "INBOX", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range result.Messages {
+		if !msg.Flags.Seen {
+			t.Errorf("UID %d not marked as processed", msg.UID)
+		}
+	}
+}
+
+func TestIMAPWatchFunc_NotifyOnce(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessage(t, addr, "INBOX", testMailRFC822)
+
+	var notified atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notified.Add(1)
+	}))
+	defer srv.Close()
+
+	host, port := testutil.SplitHostPort(t, addr)
+	client := NewIMAPClient(IMAPConfig{
+		Host:     host,
+		Port:     port,
+		Username: testutil.Username,
+		Password: testutil.Password,
+	})
+
+	// The failing handler leaves the email unseen, so every poll handles it
+	// again
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	calls := 0
+	opts := WatchOptions{
+		Folder:       "INBOX",
+		PollOnly:     true,
+		PollInterval: 1,
+		Notify:       []NotifyRule{{Notifier: &NtfyNotifier{URL: srv.URL}}},
+		Status:       func(WatchStatus) {},
+	}
+	err := client.WatchFunc(ctx, opts, func(msg *Message, raw io.Reader) error {
+		if calls++; calls == 3 {
+			cancel()
+		}
+		return errors.New("handler failed")
+	})
+	if err != nil {
+		t.Fatalf("WatchFunc() error: %v", err)
+	}
+	if calls != 3 || notified.Load() != 1 {
+		t.Errorf("handled %d times, notified %d times; want 3 and 1", calls, notified.Load())
+	}
+}
+
 func TestIMAPPing(t *testing.T) {
-	addr, _ := newTestIMAPServer(t)
+	addr, _ := testutil.NewIMAPServer(t)
 	client := newIMAPTestClient(t, addr)
 
 	if err := client.Ping(); err != nil {
@@ -349,13 +887,13 @@ func TestIMAPMailReceiver(t *testing.T) {
 	// Compile-time check
 	var _ MailReceiver = (*IMAPClient)(nil)
 
-	addr, _ := newTestIMAPServer(t)
-	appendTestMail(t, addr, "INBOX", testMailRFC822)
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessage(t, addr, "INBOX", testMailRFC822)
 
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 	var receiver MailReceiver = NewIMAPClient(IMAPConfig{
 		Host: host, Port: port,
-		Username: imapTestUser, Password: imapTestPass,
+		Username: testutil.Username, Password: testutil.Password,
 	})
 
 	result, err := receiver.FetchMessages(FetchOptions{Folder: "INBOX", Limit: 10})
@@ -381,11 +919,11 @@ func TestIMAPMailReceiver(t *testing.T) {
 }
 
 func TestIMAPMultipleMessages(t *testing.T) {
-	addr, _ := newTestIMAPServer(t)
+	addr, _ := testutil.NewIMAPServer(t)
 
 	// Append 3 messages
 	for i := 0; i < 3; i++ {
-		appendTestMail(t, addr, "INBOX", testMailRFC822)
+		testutil.AppendIMAPMessage(t, addr, "INBOX", testMailRFC822)
 	}
 
 	client := newIMAPTestClient(t, addr)
@@ -403,10 +941,10 @@ func TestIMAPMultipleMessages(t *testing.T) {
 }
 
 func TestIMAPFetchMessages_WithLimit(t *testing.T) {
-	addr, _ := newTestIMAPServer(t)
+	addr, _ := testutil.NewIMAPServer(t)
 
 	for i := 0; i < 5; i++ {
-		appendTestMail(t, addr, "INBOX", testMailRFC822)
+		testutil.AppendIMAPMessage(t, addr, "INBOX", testMailRFC822)
 	}
 
 	client := newIMAPTestClient(t, addr)
@@ -422,3 +960,181 @@ func TestIMAPFetchMessages_WithLimit(t *testing.T) {
 		t.Errorf("expected Total=5, got %d", result.Total)
 	}
 }
+
+func TestIMAPFetchMessages_Pages(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessages(t, addr, "INBOX",
+		testMailRFC822, testMailRFC822, testMailRFC822, testMailRFC822, testMailRFC822)
+
+	client := newIMAPTestClient(t, addr)
+
+	var got []uint32
+	opts := FetchOptions{Folder: "INBOX", Limit: 2}
+	for page := 0; page < 5; page++ {
+		result, err := client.FetchMessages(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range result.Messages {
+			got = append(got, m.UID)
+		}
+		if result.Next == nil {
+			break
+		}
+		if result.Next.UIDValidity != result.UIDValidity {
+			t.Errorf("Next.UIDValidity = %d, want %d", result.Next.UIDValidity, result.UIDValidity)
+		}
+		opts.Before = result.Next
+
+		// New mail between pages must not shift the cursor
+		testutil.AppendIMAPMessage(t, addr, "INBOX", testMailRFC822)
+	}
+	if fmt.Sprint(got) != "[5 4 3 2 1]" {
+		t.Errorf("paged UIDs = %v, want [5 4 3 2 1]", got)
+	}
+
+	result, err := client.FetchMessages(FetchOptions{Folder: "INBOX", Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Messages) != 2 || result.Messages[0].UID != 5 {
+		t.Errorf("offset 2: got %d messages, want UIDs 5 and 4", len(result.Messages))
+	}
+
+	stale := &Cursor{UIDValidity: result.UIDValidity + 1, UID: 3}
+	if _, err := client.FetchMessages(FetchOptions{Folder: "INBOX", Before: stale}); !errors.Is(err, ErrCursorExpired) {
+		t.Errorf("stale cursor: err = %v, want ErrCursorExpired", err)
+	}
+}
+
+func TestIMAPFetchMessages_After(t *testing.T) {
+	addr, _ := testutil.NewIMAPServer(t)
+	testutil.AppendIMAPMessages(t, addr, "INBOX",
+		testMailRFC822, testMailRFC822, testMailRFC822, testMailRFC822, testMailRFC822)
+
+	client := newIMAPTestClient(t, addr)
+	uids := func(opts FetchOptions) string {
+		t.Helper()
+		result, err := client.FetchMessages(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Next != nil {
+			t.Errorf("Next = %v, want nil", result.Next)
+		}
+		var got []uint32
+		for _, m := range result.Messages {
+			got = append(got, m.UID)
+		}
+		return fmt.Sprint(got)
+	}
+
+	// The oldest newer messages come first, newest first within the page
+	if got := uids(FetchOptions{Folder: "INBOX", Limit: 2, After: &Cursor{UID: 1}}); got != "[3 2]" {
+		t.Errorf("after 1: UIDs = %s, want [3 2]", got)
+	}
+	// "6:*" still matches UID 5, which is not newer
+	if got := uids(FetchOptions{Folder: "INBOX", After: &Cursor{UID: 5}}); got != "[]" {
+		t.Errorf("after 5: UIDs = %s, want []", got)
+	}
+	result, err := client.FetchMessages(FetchOptions{Folder: "INBOX"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := &Cursor{UIDValidity: result.UIDValidity + 1, UID: 3}
+	if _, err := client.FetchMessages(FetchOptions{Folder: "INBOX", After: stale}); !errors.Is(err, ErrCursorExpired) {
+		t.Errorf("stale cursor: err = %v, want ErrCursorExpired", err)
+	}
+}
+
+func TestConvertIMAPFetchBuffers(t *testing.T) {
+	bufs := newTestFetchBuffers(3)
+	msgs := convertIMAPFetchBuffers(bufs)
+	if len(msgs) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(msgs))
+	}
+	for i, msg := range msgs {
+		if want := uint32(3 - i); msg.UID != want {
+			t.Errorf("msgs[%d].UID = %d, want %d (newest first)", i, msg.UID, want)
+		}
+	}
+
+	// Address lists share one backing array; growing one must not clobber
+	// its neighbours.
+	msg := msgs[0]
+	msg.From = append(msg.From, Address{Email: "extra@example.com"})
+	if msg.To[0].Email != "bob@example.com" {
+		t.Errorf("To clobbered by append to From: %+v", msg.To)
+	}
+	if !msg.Flags.Seen || msg.Cc == nil || len(msg.Cc) != 0 {
+		t.Errorf("unexpected conversion: %+v", msg)
+	}
+}
+
+// newTestFetchBuffers returns n fetch results with ascending UIDs, shaped
+// like a typical envelope listing.
+func newTestFetchBuffers(n int) []*imapclient.FetchMessageBuffer {
+	bufs := make([]*imapclient.FetchMessageBuffer, n)
+	for i := range bufs {
+		bufs[i] = &imapclient.FetchMessageBuffer{
+			SeqNum: uint32(i + 1),
+			UID:    imap.UID(i + 1),
+			Flags:  []imap.Flag{imap.FlagSeen},
+			Envelope: &imap.Envelope{
+				Date:      time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC),
+				Subject:   fmt.Sprintf("Message %d", i+1),
+				From:      []imap.Address{{Name: "Alice", Mailbox: "alice", Host: "example.com"}},
+				To:        []imap.Address{{Name: "Bob", Mailbox: "bob", Host: "example.com"}},
+				MessageID: fmt.Sprintf("msg-%d@example.com", i+1),
+			},
+		}
+	}
+	return bufs
+}
+
+func BenchmarkConvertIMAPFetchBuffers(b *testing.B) {
+	bufs := newTestFetchBuffers(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		convertIMAPFetchBuffers(bufs)
+	}
+}
+
+func BenchmarkIMAPFetchMessages(b *testing.B) {
+	const total = 10000
+
+	addr, _ := testutil.NewIMAPServer(b)
+	msgs := make([]string, total)
+	for i := range msgs {
+		msgs[i] = testMailRFC822
+	}
+	testutil.AppendIMAPMessages(b, addr, "INBOX", msgs...)
+
+	host, port := testutil.SplitHostPort(b, addr)
+	client := NewIMAPClient(IMAPConfig{
+		Host:     host,
+		Port:     port,
+		Username: testutil.Username,
+		Password: testutil.Password,
+	})
+	if err := client.Connect(); err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, limit := range []int{20, total} {
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				result, err := client.FetchMessages(FetchOptions{Folder: "INBOX", Limit: limit})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(result.Messages) != limit {
+					b.Fatalf("expected %d messages, got %d", limit, len(result.Messages))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+)
+
+// NewArchiveIMAPServer returns an IMAP server offering folders, as listed
+// by ScanArchive, to a single user. The messages are loaded into memory:
+// their files are never changed, and what clients change (flags, deleted
+// or added messages) is gone when the server stops. Logins are accepted
+// without TLS, so the server is meant for localhost and test fixtures.
+func NewArchiveIMAPServer(folders []ArchiveFolder, username, password string) (*imapserver.Server, error) {
+	user := imapmemserver.NewUser(username, password)
+	for _, f := range folders {
+		if err := user.Create(f.Name, nil); err != nil {
+			return nil, fmt.Errorf("failed to create folder %s: %w", f.Name, err)
+		}
+		for _, m := range f.Messages {
+			data, err := os.ReadFile(m.Path)
+			if err != nil {
+				return nil, err
+			}
+			_, err = user.Append(f.Name, bytes.NewReader(data), &imap.AppendOptions{
+				Flags: archiveIMAPFlags(m.Flags),
+				Time:  m.Time,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to load %s: %w", m.Path, err)
+			}
+		}
+	}
+
+	mem := imapmemserver.New()
+	mem.AddUser(user)
+	return imapserver.New(&imapserver.Options{
+		NewSession: func(_ *imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
+			return mem.NewSession(), nil, nil
+		},
+		InsecureAuth: true,
+		Caps: imap.CapSet{
+			imap.CapIMAP4rev1: {},
+		},
+	}), nil
+}
+
+// archiveIMAPFlags returns the IMAP flags of flags.
+func archiveIMAPFlags(flags MessageFlag) []imap.Flag {
+	var list []imap.Flag
+	for _, f := range []struct {
+		set  bool
+		flag imap.Flag
+	}{
+		{flags.Seen, imap.FlagSeen},
+		{flags.Flagged, imap.FlagFlagged},
+		{flags.Answered, imap.FlagAnswered},
+		{flags.Draft, imap.FlagDraft},
+		{flags.Deleted, imap.FlagDeleted},
+	} {
+		if f.set {
+			list = append(list, f.flag)
+		}
+	}
+	return list
+}
@@ -0,0 +1,63 @@
+package email
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/emx-mail/cli/pkgs/testutil"
+)
+
+func TestArchiveIMAPServer(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "saved.eml"), []byte(testMailRFC822), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	md := Maildir{Path: filepath.Join(dir, "Lists")}
+	path, err := md.Deliver([]byte(testMailMultipart))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := filepath.Join(dir, "Lists", "cur", filepath.Base(path)+":2,S")
+	if err := os.Rename(path, seen); err != nil {
+		t.Fatal(err)
+	}
+
+	folders, err := ScanArchive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := NewArchiveIMAPServer(folders, testutil.Username, testutil.Password)
+	if err != nil {
+		t.Fatalf("NewArchiveIMAPServer() error: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	client := newIMAPTestClient(t, ln.Addr().String())
+	for _, tt := range []struct {
+		folder  string
+		subject string
+		seen    bool
+	}{
+		{"INBOX", "Test Subject", false},
+		{"Lists", "Multipart Test", true},
+	} {
+		result, err := client.FetchMessages(FetchOptions{Folder: tt.folder, Limit: 10})
+		if err != nil {
+			t.Fatalf("FetchMessages(%s) error: %v", tt.folder, err)
+		}
+		if len(result.Messages) != 1 {
+			t.Fatalf("%s: expected 1 message, got %d", tt.folder, len(result.Messages))
+		}
+		msg := result.Messages[0]
+		if msg.Subject != tt.subject || msg.Flags.Seen != tt.seen {
+			t.Errorf("%s: subject %q, seen %v; want %q, %v", tt.folder, msg.Subject, msg.Flags.Seen, tt.subject, tt.seen)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package email
+
+import (
+	"encoding/base64"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// reCID matches a cid: URL (RFC 2392) in an HTML attribute or CSS url().
+var reCID = regexp.MustCompile(`(?i)cid:([^"'\s()<>]+)`)
+
+// ResolveCIDs rewrites the cid: references of html to the URL resolve
+// returns for the attachment with that Content-ID. References to parts the
+// message does not have are left alone; resolve is called once per
+// attachment. An error from resolve aborts the rewrite.
+func ResolveCIDs(html string, atts []Attachment, resolve func(Attachment) (string, error)) (string, error) {
+	byID := make(map[string]int, len(atts))
+	for i, att := range atts {
+		if att.ContentID != "" && att.Data != nil {
+			byID[strings.ToLower(att.ContentID)] = i
+		}
+	}
+	if len(byID) == 0 {
+		return html, nil
+	}
+
+	resolved := make(map[int]string)
+	var rerr error
+	out := reCID.ReplaceAllStringFunc(html, func(ref string) string {
+		if rerr != nil {
+			return ref
+		}
+		id := ref[len("cid:"):]
+		if unescaped, err := url.PathUnescape(id); err == nil {
+			id = unescaped
+		}
+		i, ok := byID[strings.ToLower(id)]
+		if !ok {
+			return ref
+		}
+		if u, ok := resolved[i]; ok {
+			return u
+		}
+		u, err := resolve(atts[i])
+		if err != nil {
+			rerr = err
+			return ref
+		}
+		resolved[i] = u
+		return u
+	})
+	if rerr != nil {
+		return "", rerr
+	}
+	return out, nil
+}
+
+// DataURI returns the attachment's content as a base64 data: URI.
+func DataURI(att Attachment) string {
+	ct := att.ContentType
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	return "data:" + ct + ";base64," + base64.StdEncoding.EncodeToString(att.Data)
+}
@@ -0,0 +1,48 @@
+package email
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolveCIDs(t *testing.T) {
+	atts := []Attachment{
+		{Filename: "logo.png", ContentType: "image/png", ContentID: "logo@x", Data: []byte("PNG")},
+		{Filename: "a b.gif", ContentType: "image/gif", ContentID: "a b@x", Data: []byte("GIF")},
+		{Filename: "nodata.png", ContentID: "nodata@x"},
+	}
+	html := `<img src="cid:logo@x"><img src='CID:logo@x'><div style="background:url(cid:a%20b@x)"></div>` +
+		`<img src="cid:missing@x"><img src="cid:nodata@x">`
+
+	calls := 0
+	got, err := ResolveCIDs(html, atts, func(att Attachment) (string, error) {
+		calls++
+		return "files/" + att.Filename, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<img src="files/logo.png"><img src='files/logo.png'><div style="background:url(files/a b.gif)"></div>` +
+		`<img src="cid:missing@x"><img src="cid:nodata@x">`
+	if got != want {
+		t.Errorf("ResolveCIDs =\n%s\nwant\n%s", got, want)
+	}
+	if calls != 2 {
+		t.Errorf("resolve called %d times, want 2", calls)
+	}
+
+	fail := errors.New("disk full")
+	if _, err := ResolveCIDs(html, atts, func(Attachment) (string, error) { return "", fail }); !errors.Is(err, fail) {
+		t.Errorf("err = %v, want %v", err, fail)
+	}
+}
+
+func TestDataURI(t *testing.T) {
+	got := DataURI(Attachment{ContentType: "image/png", Data: []byte("PNG")})
+	if want := "data:image/png;base64,UE5H"; got != want {
+		t.Errorf("DataURI = %q, want %q", got, want)
+	}
+	if got := DataURI(Attachment{Data: []byte{}}); got != "data:application/octet-stream;base64," {
+		t.Errorf("DataURI without type = %q", got)
+	}
+}
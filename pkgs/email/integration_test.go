@@ -0,0 +1,75 @@
+//go:build integration
+
+package email
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/emx-mail/cli/pkgs/testutil"
+)
+
+// Integration tests against real servers; see pkgs/testutil/docker.
+// Run with: go test -tags integration ./pkgs/email/
+
+func TestIntegration_SendAndReceive(t *testing.T) {
+	smtpHost, smtpPort := testutil.IntegrationAddr(t, "smtp")
+	imapHost, imapPort := testutil.IntegrationAddr(t, "imap")
+	pop3Host, pop3Port := testutil.IntegrationAddr(t, "pop3")
+	user, pass := testutil.IntegrationCredentials()
+
+	subject := fmt.Sprintf("emx integration %d", time.Now().UnixNano())
+
+	// Postfix accepts mail for the local domain without authentication
+	sender := NewSMTPClient(SMTPConfig{Host: smtpHost, Port: smtpPort})
+	err := sender.Send(SendOptions{
+		From:     Address{Email: "sender@example.test"},
+		To:       []Address{{Email: user + "@example.test"}},
+		Subject:  subject,
+		TextBody: "integration body",
+	})
+	if err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+
+	imapClient := NewIMAPClient(IMAPConfig{Host: imapHost, Port: imapPort, Username: user, Password: pass})
+	defer imapClient.Close()
+
+	// Delivery through LMTP is asynchronous
+	var found *Message
+	deadline := time.Now().Add(30 * time.Second)
+	for found == nil && time.Now().Before(deadline) {
+		result, err := imapClient.FetchMessages(FetchOptions{Folder: "INBOX", Limit: 50})
+		if err != nil {
+			t.Fatalf("IMAP FetchMessages() error: %v", err)
+		}
+		for _, m := range result.Messages {
+			if m.Subject == subject {
+				found = m
+			}
+		}
+		if found == nil {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+	if found == nil {
+		t.Fatalf("message %q not delivered to IMAP INBOX", subject)
+	}
+
+	pop3Client := NewPOP3Client(POP3Config{Host: pop3Host, Port: pop3Port, Username: user, Password: pass})
+	defer pop3Client.Close()
+	result, err := pop3Client.FetchMessages(FetchOptions{Limit: 50})
+	if err != nil {
+		t.Fatalf("POP3 FetchMessages() error: %v", err)
+	}
+	seen := false
+	for _, m := range result.Messages {
+		if m.Subject == subject {
+			seen = true
+		}
+	}
+	if !seen {
+		t.Errorf("message %q not visible over POP3", subject)
+	}
+}
@@ -0,0 +1,59 @@
+package email
+
+import (
+	"mime"
+	"strings"
+)
+
+// LoopHeaderFields are the headers SuppressAutoResponse reads, for
+// fetching just those.
+var LoopHeaderFields = []string{
+	"Auto-Submitted", "Precedence", "X-Loop", "X-Auto-Response-Suppress",
+	"Content-Type", "Return-Path",
+}
+
+// HeaderGetter reads a message header. net/mail.Header,
+// net/textproto.MIMEHeader and go-message's Header implement it.
+type HeaderGetter interface {
+	Get(key string) string
+}
+
+// SuppressAutoResponse reports why no mail may be sent automatically in
+// response to the message with header h, or "" if it may. Answering these
+// messages is how mail loops start:
+//
+//   - Auto-Submitted other than "no" (RFC 3834): auto-replies and other
+//     generated mail
+//   - Precedence bulk, list, junk or auto_reply: mailing lists and
+//     newsletters
+//   - any X-Loop header: mail that already went through an autoresponder,
+//     including our own automatic mail coming back, which gets one (see
+//     SendOptions.AutoSubmitted)
+//   - X-Auto-Response-Suppress asking for no auto replies (Exchange)
+//   - multipart/report: read receipts and delivery reports
+//   - an empty Return-Path: bounces
+func SuppressAutoResponse(h HeaderGetter) string {
+	if v := strings.ToLower(strings.TrimSpace(h.Get("Auto-Submitted"))); v != "" && !strings.HasPrefix(v, "no") {
+		return "Auto-Submitted: " + v
+	}
+	switch v := strings.ToLower(strings.TrimSpace(h.Get("Precedence"))); v {
+	case "bulk", "list", "junk", "auto_reply":
+		return "Precedence: " + v
+	}
+	if v := strings.TrimSpace(h.Get("X-Loop")); v != "" {
+		return "X-Loop: " + v
+	}
+	for _, v := range strings.Split(h.Get("X-Auto-Response-Suppress"), ",") {
+		switch v := strings.ToLower(strings.TrimSpace(v)); v {
+		case "all", "autoreply", "oof":
+			return "X-Auto-Response-Suppress: " + v
+		}
+	}
+	if mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type")); err == nil && mediaType == "multipart/report" {
+		return "Content-Type: multipart/report"
+	}
+	if strings.TrimSpace(h.Get("Return-Path")) == "<>" {
+		return "bounce (empty Return-Path)"
+	}
+	return ""
+}
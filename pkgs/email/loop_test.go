@@ -0,0 +1,38 @@
+package email
+
+import (
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestSuppressAutoResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   string // Substring of the reason, "" to allow a response
+	}{
+		{"plain", map[string]string{"Message-ID": "<1@mail.example.org>"}, ""},
+		{"auto-submitted no", map[string]string{"Auto-Submitted": "no"}, ""},
+		{"auto-replied", map[string]string{"Auto-Submitted": "auto-replied"}, "Auto-Submitted"},
+		{"bulk", map[string]string{"Precedence": "Bulk"}, "Precedence: bulk"},
+		{"first-class", map[string]string{"Precedence": "first-class"}, ""},
+		{"x-loop", map[string]string{"X-Loop": "helpdesk@example.com"}, "X-Loop"},
+		{"exchange", map[string]string{"X-Auto-Response-Suppress": "DR, OOF"}, "oof"},
+		{"exchange receipts only", map[string]string{"X-Auto-Response-Suppress": "DR, RN"}, ""},
+		{"read receipt", map[string]string{"Content-Type": `multipart/report; report-type=disposition-notification; boundary="b"`}, "multipart/report"},
+		{"bounce", map[string]string{"Return-Path": "<>"}, "bounce"},
+		// Colleagues' mail shares the account's Message-ID domain
+		{"same domain", map[string]string{"Message-ID": "<1700000000.ab12@Example.COM>"}, ""},
+	}
+	for _, tt := range tests {
+		h := textproto.MIMEHeader{}
+		for k, v := range tt.header {
+			h.Set(k, v)
+		}
+		got := SuppressAutoResponse(h)
+		if (got == "") != (tt.want == "") || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: SuppressAutoResponse() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package email
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// lsubTimeout bounds the whole LSUB exchange.
+const lsubTimeout = time.Minute
+
+// lsub returns the names of the subscribed folders, listed with the
+// IMAP4rev1 LSUB command for servers without LIST-EXTENDED. The client
+// library does not implement LSUB, which IMAP4rev2 dropped, so it runs on
+// a short connection of its own, not counted by IMAPConfig.Limiter.
+func (c *IMAPClient) lsub() (map[string]bool, error) {
+	addr := net.JoinHostPort(c.config.Host, strconv.Itoa(c.config.Port))
+	dial := c.config.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 10 * time.Second}).Dial
+	}
+	netConn, err := dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to IMAP server %s: %w", addr, err)
+	}
+	tlsCfg := clientTLSConfig(c.config.TLSConfig, c.config.Host)
+	if c.config.SSL {
+		netConn = tls.Client(netConn, tlsCfg)
+	}
+	netConn.SetDeadline(time.Now().Add(lsubTimeout))
+	conn := &lsubConn{conn: netConn, r: bufio.NewReader(netConn)}
+	defer func() { conn.conn.Close() }()
+
+	greeting, err := conn.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("IMAP greeting failed: %w", err)
+	}
+	if c.config.StartTLS && !c.config.SSL {
+		if _, err := conn.cmd("STARTTLS"); err != nil {
+			return nil, fmt.Errorf("IMAP STARTTLS failed: %w", err)
+		}
+		tlsConn := tls.Client(netConn, tlsCfg)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, fmt.Errorf("IMAP TLS handshake failed: %w", err)
+		}
+		conn.conn = tlsConn
+		conn.r = bufio.NewReader(tlsConn)
+	}
+	if !strings.HasPrefix(strings.ToUpper(greeting), "* PREAUTH") {
+		if err := conn.login(c.config); err != nil {
+			return nil, fmt.Errorf("IMAP authentication failed: %w", err)
+		}
+	}
+
+	lines, err := conn.cmd(`LSUB "" "*"`)
+	if err != nil {
+		return nil, fmt.Errorf("LSUB failed: %w", err)
+	}
+	names := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		if name, ok := parseLSUB(line); ok {
+			names[name] = true
+		}
+	}
+	conn.cmd("LOGOUT")
+	return names, nil
+}
+
+// lsubConn is a raw IMAP connection, enough for lsub.
+type lsubConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// cmd sends a command and returns its untagged responses, or an error
+// for a NO or BAD completion. Continuation requests are answered with
+// cont in order.
+func (c *lsubConn) cmd(cmd string, cont ...string) ([]string, error) {
+	c.tag++
+	tag := "L" + strconv.Itoa(c.tag)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, cmd); err != nil {
+		return nil, err
+	}
+	var untagged []string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.HasPrefix(line, "+"):
+			if len(cont) == 0 {
+				return nil, fmt.Errorf("unexpected continuation request: %s", line)
+			}
+			if _, err := fmt.Fprintf(c.conn, "%s\r\n", cont[0]); err != nil {
+				return nil, err
+			}
+			cont = cont[1:]
+		case strings.HasPrefix(line, tag+" "):
+			status, text, _ := strings.Cut(line[len(tag)+1:], " ")
+			if !strings.EqualFold(status, "OK") {
+				return nil, fmt.Errorf("%s %s", status, text)
+			}
+			return untagged, nil
+		default:
+			untagged = append(untagged, line)
+		}
+	}
+}
+
+// login authenticates like IMAPClient.login: with LOGIN, or with
+// AUTHENTICATE PLAIN to act as AuthzID.
+func (c *lsubConn) login(config IMAPConfig) error {
+	if config.AuthzID == "" {
+		_, err := c.cmd("LOGIN " + imapQuote(config.Username) + " " + imapQuote(config.Password))
+		return err
+	}
+	resp := base64.StdEncoding.EncodeToString([]byte(config.AuthzID + "\x00" + config.Username + "\x00" + config.Password))
+	_, err := c.cmd("AUTHENTICATE PLAIN", resp)
+	return err
+}
+
+// readLine reads a response line without its line ending. A literal
+// ({n} at the end of the line) is read and put back as a quoted string.
+func (c *lsubConn) readLine() (string, error) {
+	var b strings.Builder
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		i := strings.LastIndexByte(line, '{')
+		if i < 0 || !strings.HasSuffix(line, "}") {
+			b.WriteString(line)
+			return b.String(), nil
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(line[i+1:len(line)-1], "+"))
+		if err != nil || n < 0 || n > maxIMAPLiteral {
+			b.WriteString(line)
+			return b.String(), nil
+		}
+		lit := make([]byte, n)
+		if _, err := io.ReadFull(c.r, lit); err != nil {
+			return "", err
+		}
+		b.WriteString(line[:i])
+		b.WriteString(imapQuote(string(lit)))
+	}
+}
+
+// maxIMAPLiteral bounds the literals readLine reads: LSUB responses only
+// carry folder names.
+const maxIMAPLiteral = 64 << 10
+
+// imapQuote returns s as an IMAP quoted string.
+func imapQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// parseLSUB returns the folder name of an untagged LSUB response. A
+// \Noselect name is only the parent of subscribed folders and not
+// reported.
+func parseLSUB(line string) (string, bool) {
+	const prefix = "* LSUB ("
+	if len(line) < len(prefix) || !strings.EqualFold(line[:len(prefix)], prefix) {
+		return "", false
+	}
+	attrs, rest, ok := strings.Cut(line[len(prefix):], ") ")
+	if !ok {
+		return "", false
+	}
+	for _, attr := range strings.Fields(attrs) {
+		if strings.EqualFold(attr, `\Noselect`) {
+			return "", false
+		}
+	}
+	if _, rest, ok = imapString(rest); !ok || !strings.HasPrefix(rest, " ") {
+		return "", false
+	}
+	name, _, ok := imapString(rest[1:])
+	if !ok {
+		return "", false
+	}
+	if decoded, err := decodeMUTF7(name); err == nil {
+		name = decoded
+	}
+	return name, true
+}
+
+// imapString parses a quoted string or an atom (NIL included) at the
+// start of s and returns it with the rest of s.
+func imapString(s string) (string, string, bool) {
+	if !strings.HasPrefix(s, `"`) {
+		i := strings.IndexByte(s, ' ')
+		if i < 0 {
+			i = len(s)
+		}
+		return s[:i], s[i:], i > 0
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", false
+			}
+			b.WriteByte(s[i])
+		case '"':
+			return b.String(), s[i+1:], true
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", "", false
+}
+
+// decodeMUTF7 decodes a folder name in the modified UTF-7 of RFC 3501
+// section 5.1.3.
+func decodeMUTF7(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '&')
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		s = s[i+1:]
+		j := strings.IndexByte(s, '-')
+		if j < 0 {
+			return "", fmt.Errorf("unterminated modified UTF-7 shift")
+		}
+		if j == 0 {
+			b.WriteByte('&')
+		} else {
+			raw, err := base64.RawStdEncoding.DecodeString(strings.ReplaceAll(s[:j], ",", "/"))
+			if err != nil || len(raw)%2 != 0 {
+				return "", fmt.Errorf("invalid modified UTF-7 %q", s[:j])
+			}
+			units := make([]uint16, len(raw)/2)
+			for k := range units {
+				units[k] = binary.BigEndian.Uint16(raw[2*k:])
+			}
+			b.WriteString(string(utf16.Decode(units)))
+		}
+		s = s[j+1:]
+	}
+}
@@ -0,0 +1,27 @@
+package email
+
+import "testing"
+
+func TestParseLSUB(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+		ok   bool
+	}{
+		{`* LSUB () "/" INBOX`, "INBOX", true},
+		{`* lsub (\HasNoChildren) "." "Lists.go \"dev\""`, `Lists.go "dev"`, true},
+		{`* LSUB () NIL Flat`, "Flat", true},
+		{`* LSUB () "/" "Entw&APw-rfe"`, "Entwürfe", true},
+		{`* LSUB () "/" "Bad&-Name"`, "Bad&Name", true},
+		{`* LSUB (\Noselect) "/" Archive`, "", false},
+		{`* LIST () "/" INBOX`, "", false},
+		{`* LSUB () "/" "open`, "", false},
+		{`* LSUB () "/"`, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := parseLSUB(tt.line)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseLSUB(%q) = %q, %v; want %q, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package email
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Maildir is a local mail directory in the maildir format: one file per
+// message in the cur, new and tmp subdirectories of Path.
+type Maildir struct {
+	Path string
+}
+
+// maildirSeq makes the names of messages delivered by this process unique.
+var maildirSeq atomic.Uint64
+
+// Deliver stores raw as a new message, creating the maildir if needed. The
+// message is written to tmp and then moved to new, so readers never see it
+// half written. It returns the path of the message file.
+func (m Maildir) Deliver(raw []byte) (string, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Path, sub), 0o700); err != nil {
+			return "", fmt.Errorf("failed to create maildir: %w", err)
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	// "/" and ":" are reserved in maildir names
+	host = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+	now := time.Now()
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), maildirSeq.Add(1), host)
+
+	tmp := filepath.Join(m.Path, "tmp", name)
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write message: %w", err)
+	}
+	path := filepath.Join(m.Path, "new", name)
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to deliver message: %w", err)
+	}
+	return path, nil
+}
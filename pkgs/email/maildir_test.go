@@ -0,0 +1,40 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaildirDeliver(t *testing.T) {
+	md := Maildir{Path: filepath.Join(t.TempDir(), "patches")}
+
+	raw := []byte("Subject: [PATCH 1/2] a\n\nbody\n")
+	p1, err := md.Deliver(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := md.Deliver([]byte("Subject: [PATCH 2/2] b\n\nbody\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p1 == p2 {
+		t.Errorf("both messages delivered to %s", p1)
+	}
+	if filepath.Dir(p1) != filepath.Join(md.Path, "new") {
+		t.Errorf("delivered to %s, want new/", p1)
+	}
+	if data, err := os.ReadFile(p1); err != nil || string(data) != string(raw) {
+		t.Errorf("message = %q, %v", data, err)
+	}
+
+	for _, sub := range []string{"cur", "tmp"} {
+		entries, err := os.ReadDir(filepath.Join(md.Path, sub))
+		if err != nil {
+			t.Fatalf("%s: %v", sub, err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("%s has %d files, want 0", sub, len(entries))
+		}
+	}
+}
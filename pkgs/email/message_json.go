@@ -0,0 +1,195 @@
+package email
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Message JSON schema, as written by fetch --format json and read back by
+// UnmarshalJSON. Field names are stable; fields marked omitempty are left
+// out when empty.
+//
+//	uid, seq_num, size   Server numbers (omitempty)
+//	key                  Stable idempotency key, see Message.Key
+//	message_id           Message-ID, without angle brackets
+//	in_reply_to          In-Reply-To (omitempty)
+//	references           References (omitempty)
+//	from, to             Lists of {"name","email"}, [] when empty
+//	cc, bcc              Lists of {"name","email"} (omitempty)
+//	subject              Decoded subject
+//	date                 Date header in RFC 3339, omitted if missing
+//	flags                IMAP system flags such as "\Seen", [] when none
+//	labels               Gmail labels (omitempty)
+//	charset, preview     See Message (omitempty)
+//	text_body, html_body Decoded bodies (omitempty)
+//	attachments          {"filename","content_type","size","content_id","data"},
+//	                     data only with MessageJSONOptions.AttachmentData
+//	structure            MIME tree, see Part (omitempty)
+//	spam, arc            Server verdicts, see SpamVerdict and ARCResult (omitempty)
+type messageJSON struct {
+	UID         uint32       `json:"uid,omitempty"`
+	SeqNum      uint32       `json:"seq_num,omitempty"`
+	Size        uint32       `json:"size,omitempty"`
+	Key         string       `json:"key"`
+	MessageID   string       `json:"message_id"`
+	InReplyTo   string       `json:"in_reply_to,omitempty"`
+	References  []string     `json:"references,omitempty"`
+	From        []Address    `json:"from"`
+	To          []Address    `json:"to"`
+	Cc          []Address    `json:"cc,omitempty"`
+	Bcc         []Address    `json:"bcc,omitempty"`
+	Subject     string       `json:"subject"`
+	Date        string       `json:"date,omitempty"`
+	Flags       []string     `json:"flags"`
+	Labels      []string     `json:"labels,omitempty"`
+	Charset     string       `json:"charset,omitempty"`
+	Preview     string       `json:"preview,omitempty"`
+	TextBody    string       `json:"text_body,omitempty"`
+	HTMLBody    string       `json:"html_body,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Structure   *Part        `json:"structure,omitempty"`
+	Spam        *SpamVerdict `json:"spam,omitempty"`
+	ARC         []ARCResult  `json:"arc,omitempty"`
+}
+
+// MessageJSONOptions controls MarshalMessage.
+type MessageJSONOptions struct {
+	// AttachmentData includes the attachment contents, base64-encoded.
+	// Without it only their metadata is written.
+	AttachmentData bool
+}
+
+// Names returns the IMAP system flags that are set, e.g. ["\Seen"].
+func (f MessageFlag) Names() []string {
+	names := []string{}
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{f.Seen, `\Seen`},
+		{f.Answered, `\Answered`},
+		{f.Flagged, `\Flagged`},
+		{f.Deleted, `\Deleted`},
+		{f.Draft, `\Draft`},
+		{f.Recent, `\Recent`},
+	} {
+		if flag.set {
+			names = append(names, flag.name)
+		}
+	}
+	return names
+}
+
+// setName sets the flag with the IMAP name, ignoring other flags.
+func (f *MessageFlag) setName(name string) {
+	switch name {
+	case `\Seen`:
+		f.Seen = true
+	case `\Answered`:
+		f.Answered = true
+	case `\Flagged`:
+		f.Flagged = true
+	case `\Deleted`:
+		f.Deleted = true
+	case `\Draft`:
+		f.Draft = true
+	case `\Recent`:
+		f.Recent = true
+	}
+}
+
+// MarshalMessage encodes m in the Message JSON schema.
+func MarshalMessage(m *Message, opts MessageJSONOptions) ([]byte, error) {
+	j := messageJSON{
+		UID:        m.UID,
+		SeqNum:     m.SeqNum,
+		Size:       m.Size,
+		Key:        m.Key(),
+		MessageID:  m.MessageID,
+		InReplyTo:  m.InReplyTo,
+		References: m.References,
+		From:       m.From,
+		To:         m.To,
+		Cc:         m.Cc,
+		Bcc:        m.Bcc,
+		Subject:    m.Subject,
+		Flags:      m.Flags.Names(),
+		Labels:     m.Labels,
+		Charset:    m.Charset,
+		Preview:    m.Preview,
+		TextBody:   m.TextBody,
+		HTMLBody:   m.HTMLBody,
+		Structure:  m.Structure,
+		Spam:       m.Spam,
+		ARC:        m.ARC,
+	}
+	if j.From == nil {
+		j.From = []Address{}
+	}
+	if j.To == nil {
+		j.To = []Address{}
+	}
+	if !m.Date.IsZero() {
+		j.Date = m.Date.Format(time.RFC3339)
+	}
+	if len(m.Attachments) > 0 {
+		j.Attachments = make([]Attachment, len(m.Attachments))
+		copy(j.Attachments, m.Attachments)
+		if !opts.AttachmentData {
+			for i := range j.Attachments {
+				j.Attachments[i].Data = nil
+			}
+		}
+	}
+	return json.Marshal(j)
+}
+
+// MarshalJSON encodes m in the Message JSON schema, without attachment
+// data; see MarshalMessage to include it.
+func (m Message) MarshalJSON() ([]byte, error) {
+	return MarshalMessage(&m, MessageJSONOptions{})
+}
+
+// UnmarshalJSON decodes a message in the Message JSON schema. The key is
+// derived from the other fields and is not read; unknown flags are ignored.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var j messageJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	msg := Message{
+		UID:         j.UID,
+		SeqNum:      j.SeqNum,
+		Size:        j.Size,
+		MessageID:   j.MessageID,
+		InReplyTo:   j.InReplyTo,
+		References:  j.References,
+		From:        j.From,
+		To:          j.To,
+		Cc:          j.Cc,
+		Bcc:         j.Bcc,
+		Subject:     j.Subject,
+		Labels:      j.Labels,
+		Charset:     j.Charset,
+		Preview:     j.Preview,
+		TextBody:    j.TextBody,
+		HTMLBody:    j.HTMLBody,
+		Attachments: j.Attachments,
+		Structure:   j.Structure,
+		Spam:        j.Spam,
+		ARC:         j.ARC,
+	}
+	if j.Date != "" {
+		date, err := time.Parse(time.RFC3339, j.Date)
+		if err != nil {
+			return fmt.Errorf("invalid date: %w", err)
+		}
+		msg.Date = date
+	}
+	for _, name := range j.Flags {
+		msg.Flags.setName(name)
+	}
+	*m = msg
+	return nil
+}
@@ -0,0 +1,119 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenMessage is a message with every field of the JSON schema set.
+func goldenMessage() *Message {
+	return &Message{
+		From:       []Address{{Name: "Alice", Email: "alice@example.com"}},
+		To:         []Address{{Email: "bob@example.com"}},
+		Cc:         []Address{{Name: "Carol", Email: "carol@example.com"}},
+		Subject:    "Quarterly report",
+		Date:       time.Date(2024, 3, 1, 9, 30, 0, 0, time.FixedZone("", 3600)),
+		TextBody:   "See attached.\n",
+		HTMLBody:   "<p>See attached.</p>",
+		Charset:    "iso-8859-1",
+		Preview:    "See attached.",
+		MessageID:  "report-1@example.com",
+		References: []string{"thread-1@example.com"},
+		InReplyTo:  "thread-1@example.com",
+		Flags:      MessageFlag{Seen: true, Flagged: true},
+		Labels:     []string{"Work"},
+		Attachments: []Attachment{
+			{Filename: "report.csv", ContentType: "text/csv", Size: 8, Data: []byte("a,b\n1,2\n")},
+		},
+		Structure: &Part{ContentType: "multipart/mixed", Params: map[string]string{"boundary": "b1"}, Parts: []*Part{
+			{Number: "1", ContentType: "text/plain", Params: map[string]string{"charset": "iso-8859-1"}, Encoding: "7bit", Size: 14, Lines: 1},
+			{Number: "2", ContentType: "text/csv", Encoding: "base64", Size: 12, Disposition: "attachment", Filename: "report.csv"},
+		}},
+		Spam:   &SpamVerdict{Score: 1.5, HasScore: true, Threshold: 5},
+		ARC:    []ARCResult{{Instance: 1, AuthServID: "mx.example.com", Results: map[string]string{"dkim": "pass"}}},
+		UID:    42,
+		SeqNum: 7,
+		Size:   2048,
+	}
+}
+
+// checkGolden compares got, indented, with testdata/name, or rewrites the
+// file with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, got, "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteByte('\n')
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("%s differs, run go test -update to rewrite it:\n%s", name, buf.Bytes())
+	}
+}
+
+func TestMarshalMessageGolden(t *testing.T) {
+	msg := goldenMessage()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "message.json", data)
+
+	data, err = MarshalMessage(msg, MessageJSONOptions{AttachmentData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "message_data.json", data)
+
+	// An empty message still has the required fields
+	data, err = json.Marshal(Message{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "message_empty.json", data)
+}
+
+func TestUnmarshalMessage(t *testing.T) {
+	msg := goldenMessage()
+	data, err := MarshalMessage(msg, MessageJSONOptions{AttachmentData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Message
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Date.Equal(msg.Date) {
+		t.Errorf("Date = %v, want %v", got.Date, msg.Date)
+	}
+	got.Date = msg.Date
+	// HasScore is not part of the schema
+	got.Spam.HasScore = true
+	if !reflect.DeepEqual(&got, msg) {
+		t.Errorf("round trip = %+v, want %+v", got, *msg)
+	}
+
+	if err := json.Unmarshal([]byte(`{"date":"Fri, 01 Mar 2024 09:30:00 +0100"}`), &got); err == nil {
+		t.Error("RFC 1123 date accepted")
+	}
+}
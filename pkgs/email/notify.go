@@ -0,0 +1,267 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Notifier tells someone about a new email seen by Watch.
+type Notifier interface {
+	Notify(ctx context.Context, n EmailNotification) error
+}
+
+// AlertSender sends an alert about the watched folder rather than about
+// one email, such as a Flood. The notifiers of this package implement it.
+type AlertSender interface {
+	SendAlert(ctx context.Context, title, body string) error
+}
+
+// NotifyRule sends new emails matching its filters through Notifier. Empty
+// filters match everything; set filters must all match.
+type NotifyRule struct {
+	Notifier Notifier
+	From     string // Case-insensitive substring of the sender address
+	Subject  string // Case-insensitive substring of the subject
+}
+
+// Matches reports whether n passes the rule's filters.
+func (r NotifyRule) Matches(n EmailNotification) bool {
+	return containsFold(n.From, r.From) && containsFold(n.Subject, r.Subject)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// notificationText returns the title and body used by the notifiers.
+func notificationText(n EmailNotification) (title, body string) {
+	title = "New mail from " + n.From
+	body = n.Subject
+	if body == "" {
+		body = "(no subject)"
+	}
+	return title, body
+}
+
+// DesktopNotifier shows a desktop notification: notify-send on Linux and
+// the BSDs, osascript on macOS and a tray balloon via PowerShell on Windows.
+// The command is started in the background and not waited for.
+type DesktopNotifier struct{}
+
+// Notify implements Notifier.
+func (d DesktopNotifier) Notify(ctx context.Context, n EmailNotification) error {
+	return d.send(notificationText(n))
+}
+
+// SendDigest implements DigestSender.
+func (d DesktopNotifier) SendDigest(ctx context.Context, digest Digest) error {
+	return d.send(digest.Text())
+}
+
+// SendAlert implements AlertSender.
+func (d DesktopNotifier) SendAlert(ctx context.Context, title, body string) error {
+	return d.send(title, body)
+}
+
+func (DesktopNotifier) send(title, body string) error {
+	name, args := desktopCommand(runtime.GOOS, title, body)
+	cmd := exec.Command(name, args...)
+	// Title and body reach osascript and PowerShell through the
+	// environment, so they are never parsed as script.
+	cmd.Env = append(os.Environ(), "EMX_NOTIFY_TITLE="+title, "EMX_NOTIFY_BODY="+body)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("desktop notification failed: %w", err)
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// desktopCommand returns the command showing a notification on goos.
+func desktopCommand(goos, title, body string) (name string, args []string) {
+	switch goos {
+	case "darwin":
+		return "osascript", []string{"-e",
+			`display notification (system attribute "EMX_NOTIFY_BODY") with title (system attribute "EMX_NOTIFY_TITLE")`}
+	case "windows":
+		return "powershell", []string{"-NoProfile", "-NonInteractive", "-Command",
+			"Add-Type -AssemblyName System.Windows.Forms, System.Drawing; " +
+				"$n = New-Object System.Windows.Forms.NotifyIcon; " +
+				"$n.Icon = [System.Drawing.SystemIcons]::Information; $n.Visible = $true; " +
+				"$n.ShowBalloonTip(10000, $env:EMX_NOTIFY_TITLE, $env:EMX_NOTIFY_BODY, 'Info'); " +
+				"Start-Sleep -Seconds 10; $n.Dispose()"}
+	default:
+		return "notify-send", []string{"--app-name=emx-mail", "--", title, body}
+	}
+}
+
+// NtfyNotifier publishes to an ntfy topic (https://ntfy.sh or self-hosted).
+type NtfyNotifier struct {
+	URL      string // Topic URL, e.g. "https://ntfy.sh/my-mail"
+	Token    string // Access token for protected topics
+	Priority string // ntfy priority: min, low, default, high or urgent
+
+	Client *http.Client // Defaults to http.DefaultClient
+}
+
+// Notify implements Notifier.
+func (u *NtfyNotifier) Notify(ctx context.Context, n EmailNotification) error {
+	title, body := notificationText(n)
+	return u.send(ctx, title, body)
+}
+
+// SendDigest implements DigestSender.
+func (u *NtfyNotifier) SendDigest(ctx context.Context, d Digest) error {
+	title, body := d.Text()
+	return u.send(ctx, title, body)
+}
+
+// SendAlert implements AlertSender.
+func (u *NtfyNotifier) SendAlert(ctx context.Context, title, body string) error {
+	return u.send(ctx, title, body)
+}
+
+func (u *NtfyNotifier) send(ctx context.Context, title, body string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.URL, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Tags", "email")
+	if u.Priority != "" {
+		req.Header.Set("Priority", u.Priority)
+	}
+	if u.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+u.Token)
+	}
+	return doNotify(u.Client, req, "ntfy")
+}
+
+// SlackNotifier posts to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+	Client     *http.Client // Defaults to http.DefaultClient
+}
+
+// Notify implements Notifier.
+func (s *SlackNotifier) Notify(ctx context.Context, n EmailNotification) error {
+	title, body := notificationText(n)
+	return s.send(ctx, title, body)
+}
+
+// SendDigest implements DigestSender.
+func (s *SlackNotifier) SendDigest(ctx context.Context, d Digest) error {
+	title, body := d.Text()
+	return s.send(ctx, title, body)
+}
+
+// SendAlert implements AlertSender.
+func (s *SlackNotifier) SendAlert(ctx context.Context, title, body string) error {
+	return s.send(ctx, title, body)
+}
+
+func (s *SlackNotifier) send(ctx context.Context, title, body string) error {
+	return postWebhook(ctx, s.Client, s.WebhookURL, "slack", map[string]string{
+		"text": "*" + title + "*\n" + body,
+	})
+}
+
+// DiscordNotifier posts to a Discord webhook.
+type DiscordNotifier struct {
+	WebhookURL string
+	Client     *http.Client // Defaults to http.DefaultClient
+}
+
+// Notify implements Notifier.
+func (d *DiscordNotifier) Notify(ctx context.Context, n EmailNotification) error {
+	title, body := notificationText(n)
+	return d.send(ctx, title, body)
+}
+
+// SendDigest implements DigestSender.
+func (d *DiscordNotifier) SendDigest(ctx context.Context, digest Digest) error {
+	title, body := digest.Text()
+	return d.send(ctx, title, body)
+}
+
+// SendAlert implements AlertSender.
+func (d *DiscordNotifier) SendAlert(ctx context.Context, title, body string) error {
+	return d.send(ctx, title, body)
+}
+
+func (d *DiscordNotifier) send(ctx context.Context, title, body string) error {
+	return postWebhook(ctx, d.Client, d.WebhookURL, "discord", map[string]string{
+		"content": "**" + title + "**\n" + body,
+	})
+}
+
+// MailNotifier sends notifications as emails through an SMTP client,
+// connecting for each one. They are marked Auto-Submitted, and Watch does
+// not send them for emails SuppressAutoResponse rejects, so a notification
+// reaching the watched folder does not start a loop.
+type MailNotifier struct {
+	Client *SMTPClient
+	From   Address
+	To     []Address
+}
+
+// Notify implements Notifier.
+func (m *MailNotifier) Notify(ctx context.Context, n EmailNotification) error {
+	title, body := notificationText(n)
+	return m.send(title, body)
+}
+
+// SendDigest implements DigestSender.
+func (m *MailNotifier) SendDigest(ctx context.Context, d Digest) error {
+	title, body := d.Text()
+	return m.send(title, body)
+}
+
+// SendAlert implements AlertSender.
+func (m *MailNotifier) SendAlert(ctx context.Context, title, body string) error {
+	return m.send(title, body)
+}
+
+func (m *MailNotifier) send(title, body string) error {
+	err := m.Client.Send(SendOptions{From: m.From, To: m.To, Subject: title, TextBody: body, AutoSubmitted: "auto-generated"})
+	if err != nil {
+		return fmt.Errorf("email notification failed: %w", err)
+	}
+	return nil
+}
+
+func postWebhook(ctx context.Context, client *http.Client, url, service string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doNotify(client, req, service)
+}
+
+func doNotify(client *http.Client, req *http.Request, service string) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s notification failed: %w", service, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s notification failed: %s", service, resp.Status)
+	}
+	return nil
+}
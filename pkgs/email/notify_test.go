@@ -0,0 +1,135 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emx-mail/cli/pkgs/testutil"
+)
+
+var testNotification = EmailNotification{
+	Type:    "email",
+	UID:     7,
+	From:    "alerts@example.com",
+	Subject: "Disk almost full",
+}
+
+func TestNotifyRuleMatches(t *testing.T) {
+	tests := []struct {
+		rule NotifyRule
+		want bool
+	}{
+		{NotifyRule{}, true},
+		{NotifyRule{From: "ALERTS@"}, true},
+		{NotifyRule{Subject: "disk"}, true},
+		{NotifyRule{From: "alerts@", Subject: "full"}, true},
+		{NotifyRule{From: "boss@"}, false},
+		{NotifyRule{From: "alerts@", Subject: "invoice"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.rule.Matches(testNotification); got != tt.want {
+			t.Errorf("%+v.Matches() = %v, want %v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestNtfyNotifier(t *testing.T) {
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, body = r, string(b)
+	}))
+	defer srv.Close()
+
+	n := &NtfyNotifier{URL: srv.URL + "/mail", Token: "tk", Priority: "high"}
+	if err := n.Notify(context.Background(), testNotification); err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != http.MethodPost || got.URL.Path != "/mail" {
+		t.Errorf("request = %s %s", got.Method, got.URL.Path)
+	}
+	if body != "Disk almost full" {
+		t.Errorf("body = %q", body)
+	}
+	if got.Header.Get("Title") != "New mail from alerts@example.com" ||
+		got.Header.Get("Priority") != "high" ||
+		got.Header.Get("Authorization") != "Bearer tk" {
+		t.Errorf("headers = %v", got.Header)
+	}
+}
+
+func TestWebhookNotifiers(t *testing.T) {
+	var payload map[string]string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload = nil
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	if err := (&SlackNotifier{WebhookURL: srv.URL}).Notify(context.Background(), testNotification); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(payload["text"], "alerts@example.com") || !strings.Contains(payload["text"], "Disk almost full") {
+		t.Errorf("slack payload = %v", payload)
+	}
+
+	status = http.StatusNoContent // Discord answers 204
+	if err := (&DiscordNotifier{WebhookURL: srv.URL}).Notify(context.Background(), testNotification); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(payload["content"], "Disk almost full") {
+		t.Errorf("discord payload = %v", payload)
+	}
+
+	status = http.StatusNotFound
+	err := (&SlackNotifier{WebhookURL: srv.URL}).Notify(context.Background(), testNotification)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Notify() error = %v, want 404", err)
+	}
+}
+
+func TestMailNotifier(t *testing.T) {
+	be, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
+	n := &MailNotifier{
+		Client: NewSMTPClient(SMTPConfig{Host: host, Port: port, Username: testutil.Username, Password: testutil.Password}),
+		From:   Address{Email: "watch@example.com"},
+		To:     []Address{{Email: "ops@example.com"}},
+	}
+
+	digest := Digest{Emails: []EmailNotification{testNotification, testNotification}}
+	if err := n.SendDigest(context.Background(), digest); err != nil {
+		t.Fatal(err)
+	}
+	msgs := be.Messages()
+	if len(msgs) != 1 || msgs[0].To[0] != "ops@example.com" {
+		t.Fatalf("messages = %+v", msgs)
+	}
+	data := string(msgs[0].Data)
+	if !strings.Contains(data, "Subject: 2 new emails") || !strings.Contains(data, "alerts@example.com: Disk almost full") ||
+		!strings.Contains(data, "Auto-Submitted: auto-generated") || !strings.Contains(data, "X-Loop: watch@example.com") {
+		t.Errorf("digest email:\n%s", data)
+	}
+}
+
+func TestDesktopCommand(t *testing.T) {
+	name, args := desktopCommand("linux", "New mail from a@b", "-rf subject")
+	if name != "notify-send" || args[len(args)-3] != "--" || args[len(args)-1] != "-rf subject" {
+		t.Errorf("linux command = %s %q", name, args)
+	}
+	// Title and body are passed in the environment, never in the script
+	for _, goos := range []string{"darwin", "windows"} {
+		name, args := desktopCommand(goos, "New mail from a@b", `"; rm -rf /`)
+		if strings.Contains(strings.Join(args, " "), "rm -rf") {
+			t.Errorf("%s command %s %q embeds the body", goos, name, args)
+		}
+	}
+}
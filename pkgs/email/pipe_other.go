@@ -0,0 +1,14 @@
+//go:build !plan9
+
+package email
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isBrokenPipe reports whether err is a write to a pipe whose reader is
+// gone.
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,11 @@
+//go:build plan9
+
+package email
+
+import "strings"
+
+// isBrokenPipe reports whether err is a write to a pipe whose reader is
+// gone, which Plan 9 reports as a hungup channel.
+func isBrokenPipe(err error) bool {
+	return strings.Contains(err.Error(), "hungup")
+}
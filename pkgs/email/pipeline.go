@@ -0,0 +1,165 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Failure policies of a PipelineStage.
+const (
+	OnErrorFail     = "fail"     // Abort the pipeline; the email stays unseen and is tried again
+	OnErrorContinue = "continue" // Run the next stage anyway
+	OnErrorStop     = "stop"     // Skip the remaining stages and mark the email as processed
+)
+
+// Pipeline is an ordered list of handler commands run for every new email
+// matching its filters, in place of WatchOptions.HandlerCmd. Each stage
+// gets the raw email on stdin, the environment of a handler (see
+// handlerEnv), and the stdout of the stage before it:
+//
+//	EMX_STAGE        name of the running stage
+//	EMX_PREV_STAGE   name of the previous stage ("" for the first)
+//	EMX_PREV_EXIT    its exit code
+//	EMX_PREV_OUTPUT  path of a file holding its stdout, e.g. JSON
+type Pipeline struct {
+	Name    string
+	From    string // Case-insensitive substring of the sender address
+	Subject string // Case-insensitive substring of the subject
+	Stages  []PipelineStage
+}
+
+// PipelineStage is one handler command of a Pipeline.
+type PipelineStage struct {
+	Name    string // Defaults to "stage <n>"
+	Cmd     string
+	Shell   string // As WatchOptions.HandlerShell
+	OnError string // OnErrorFail (default), OnErrorContinue or OnErrorStop
+}
+
+// Matches reports whether n passes the pipeline's filters.
+func (p Pipeline) Matches(n EmailNotification) bool {
+	return containsFold(n.From, p.From) && containsFold(n.Subject, p.Subject)
+}
+
+// validate checks the stages' commands and failure policies.
+func (p Pipeline) validate() error {
+	if len(p.Stages) == 0 {
+		return fmt.Errorf("pipeline %s has no stages", p.Name)
+	}
+	for i, s := range p.Stages {
+		if strings.TrimSpace(s.Cmd) == "" {
+			return fmt.Errorf("pipeline %s, %s has no command", p.Name, s.name(i))
+		}
+		if _, err := handlerCommand(s.Shell, s.Cmd); err != nil {
+			return fmt.Errorf("pipeline %s, %s: %w", p.Name, s.name(i), err)
+		}
+		switch s.OnError {
+		case "", OnErrorFail, OnErrorContinue, OnErrorStop:
+		default:
+			return fmt.Errorf("pipeline %s, %s: unknown on_error %q (want fail, continue or stop)", p.Name, s.name(i), s.OnError)
+		}
+	}
+	return nil
+}
+
+func (s PipelineStage) name(i int) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return fmt.Sprintf("stage %d", i+1)
+}
+
+// matchPipeline returns the first pipeline matching n, or nil.
+func matchPipeline(pipelines []Pipeline, n EmailNotification) *Pipeline {
+	for i := range pipelines {
+		if pipelines[i].Matches(n) {
+			return &pipelines[i]
+		}
+	}
+	return nil
+}
+
+// runPipeline runs the stages of p for one email. The raw email is spooled
+// to a temporary file first, so every stage can read it. It fails when a
+// stage with OnErrorFail fails, or when ctx is cancelled between stages.
+func (c *IMAPClient) runPipeline(ctx context.Context, p *Pipeline, uid uint32, raw io.Reader, env []string, grace time.Duration, statusWrite func(WatchStatus)) error {
+	dir, err := os.MkdirTemp("", "emx-pipeline-")
+	if err != nil {
+		return fmt.Errorf("failed to create pipeline directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := os.Create(filepath.Join(dir, "email.eml"))
+	if err != nil {
+		return fmt.Errorf("failed to spool email: %w", err)
+	}
+	defer spool.Close()
+	if _, err := io.Copy(spool, raw); err != nil {
+		return fmt.Errorf("failed to spool email: %w", err)
+	}
+
+	prevStage, prevExit, prevOutput := "", 0, ""
+	for i, stage := range p.Stages {
+		name := stage.name(i)
+		if ctx.Err() != nil {
+			return fmt.Errorf("pipeline %s stopped before %s: %w", p.Name, name, ctx.Err())
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to rewind spooled email: %w", err)
+		}
+		outPath := filepath.Join(dir, fmt.Sprintf("stage-%d.out", i+1))
+		out, err := os.Create(outPath)
+		if err != nil {
+			return fmt.Errorf("failed to create stage output: %w", err)
+		}
+
+		statusWrite(WatchStatus{
+			Type:    "process",
+			Level:   "info",
+			Message: fmt.Sprintf("Pipeline %s: running %s for UID %d", p.Name, name, uid),
+			UID:     uid,
+		})
+		stageEnv := append(env[:len(env):len(env)],
+			"EMX_STAGE="+name,
+			"EMX_PREV_STAGE="+prevStage,
+			"EMX_PREV_EXIT="+strconv.Itoa(prevExit),
+			"EMX_PREV_OUTPUT="+prevOutput,
+		)
+		code, err := c.runHandlerTo(ctx, stage.Shell, stage.Cmd, stageEnv, grace, spool, out)
+		out.Close()
+		if err == nil && code != 0 {
+			err = fmt.Errorf("exit code %d", code)
+		}
+		prevStage, prevExit, prevOutput = name, code, outPath
+		if err == nil {
+			continue
+		}
+
+		switch stage.OnError {
+		case OnErrorContinue:
+			statusWrite(WatchStatus{
+				Type:    "process",
+				Level:   "warn",
+				Message: fmt.Sprintf("Pipeline %s: %s failed for UID %d, continuing: %v", p.Name, name, uid, err),
+				UID:     uid,
+			})
+		case OnErrorStop:
+			statusWrite(WatchStatus{
+				Type:    "process",
+				Level:   "warn",
+				Message: fmt.Sprintf("Pipeline %s: %s failed for UID %d, skipping the remaining stages: %v", p.Name, name, uid, err),
+				UID:     uid,
+			})
+			return nil
+		default:
+			return fmt.Errorf("pipeline %s: %s failed: %w", p.Name, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package email
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipelineValidate(t *testing.T) {
+	ok := Pipeline{Name: "p", Stages: []PipelineStage{{Cmd: "save"}, {Cmd: "notify", OnError: OnErrorStop}}}
+	if err := ok.validate(); err != nil {
+		t.Errorf("validate() error: %v", err)
+	}
+	for _, p := range []Pipeline{
+		{Name: "empty"},
+		{Name: "no-cmd", Stages: []PipelineStage{{Name: "save"}}},
+		{Name: "policy", Stages: []PipelineStage{{Cmd: "save", OnError: "retry"}}},
+	} {
+		if err := p.validate(); err == nil {
+			t.Errorf("validate(%s) should fail", p.Name)
+		}
+	}
+}
+
+func TestMatchPipeline(t *testing.T) {
+	pipelines := []Pipeline{
+		{Name: "invoices", From: "billing@"},
+		{Name: "all"},
+	}
+	if p := matchPipeline(pipelines, EmailNotification{From: "Billing@shop.example"}); p == nil || p.Name != "invoices" {
+		t.Errorf("matchPipeline(billing) = %v, want invoices", p)
+	}
+	if p := matchPipeline(pipelines, EmailNotification{From: "bob@example.com"}); p == nil || p.Name != "all" {
+		t.Errorf("matchPipeline(bob) = %v, want all", p)
+	}
+	if p := matchPipeline(pipelines[:1], EmailNotification{From: "bob@example.com"}); p != nil {
+		t.Errorf("matchPipeline without match = %v, want nil", p)
+	}
+}
+
+func TestRunPipeline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	c := &IMAPClient{}
+	out := filepath.Join(t.TempDir(), "out")
+	run := func(stages ...PipelineStage) error {
+		p := &Pipeline{Name: "test", Stages: stages}
+		return c.runPipeline(context.Background(), p, 1, strings.NewReader("body"), []string{"OUT=" + out}, time.Second, func(WatchStatus) {})
+	}
+
+	// Every stage reads the email; the next one sees the previous output
+	err := run(
+		PipelineStage{Name: "first", Shell: "sh", Cmd: `cat; echo '{"n":1}'`},
+		PipelineStage{Shell: "sh", Cmd: `cat >/dev/null; echo "$EMX_STAGE $EMX_PREV_STAGE $EMX_PREV_EXIT $(cat "$EMX_PREV_OUTPUT")" >"$OUT"`},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "stage 2 first 0 body{\"n\":1}\n" {
+		t.Errorf("second stage wrote %q", data)
+	}
+
+	fail := PipelineStage{Shell: "sh", Cmd: "exit 3"}
+	last := PipelineStage{Shell: "sh", Cmd: `echo "$EMX_PREV_EXIT" >"$OUT"`}
+	os.Remove(out)
+	if err := run(fail, last); err == nil {
+		t.Error("failing stage should fail the pipeline")
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("stage after a failed one ran")
+	}
+
+	fail.OnError = OnErrorContinue
+	if err := run(fail, last); err != nil {
+		t.Errorf("continue: %v", err)
+	}
+	if data, _ := os.ReadFile(out); string(data) != "3\n" {
+		t.Errorf("continue: next stage saw EMX_PREV_EXIT %q, want 3", data)
+	}
+
+	fail.OnError = OnErrorStop
+	os.Remove(out)
+	if err := run(fail, last); err != nil {
+		t.Errorf("stop: %v", err)
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("stop: remaining stages ran")
+	}
+}
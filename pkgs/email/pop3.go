@@ -6,7 +6,6 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"mime"
 	"net"
 	"strconv"
 	"strings"
@@ -32,6 +31,14 @@ type POP3Config struct {
 	SSL       bool
 	StartTLS  bool
 	TLSConfig *tls.Config // optional; if nil a default config is used
+
+	// Dial, if set, opens the connection to the server instead of a plain
+	// TCP dial, as IMAPConfig.Dial.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// MaxBodyBytes makes fetching a message larger than this fail with
+	// ErrBodyTooLarge before it is downloaded; 0 means no limit.
+	MaxBodyBytes int64
 }
 
 // NewPOP3Client creates a new POP3 client
@@ -102,21 +109,36 @@ func (c *POP3Client) FetchMessages(opts FetchOptions) (*ListResult, error) {
 		}, nil
 	}
 
-	// Determine range to fetch
+	// Determine range to fetch. Message numbers stand in for UIDs in
+	// cursors; new mail is appended, so they stay stable across pages.
 	limit := opts.Limit
 	if limit <= 0 {
 		limit = 20
 	}
-	start := 1
-	if count > limit {
-		start = count - limit + 1
+	end := count
+	if opts.Before != nil {
+		end = min(end, int(opts.Before.UID)-1)
+	}
+	end -= max(opts.Offset, 0)
+	if end <= 0 {
+		return &ListResult{
+			Messages: []*Message{},
+			Total:    count,
+			Folder:   "INBOX",
+		}, nil
 	}
+	start := max(end-limit+1, 1)
 
-	messages := make([]*Message, 0, count-start+1)
+	messages := make([]*Message, 0, end-start+1)
 
-	for id := start; id <= count; id++ {
-		// Use TOP to fetch headers + 0 body lines for listing
-		entity, err := c.conn.top(id, 0)
+	// Use TOP to fetch headers and, for previews, the first body lines
+	topLines := 0
+	if opts.Preview {
+		topLines = previewTopLines
+	}
+
+	for id := start; id <= end; id++ {
+		entity, err := c.conn.top(id, topLines)
 		if err != nil {
 			// If TOP is not supported, fall back to RETR
 			entity, err = c.conn.retr(id)
@@ -126,6 +148,10 @@ func (c *POP3Client) FetchMessages(opts FetchOptions) (*ListResult, error) {
 		}
 
 		msg := pop3EntityToMessage(entity, uint32(id))
+		if opts.Preview {
+			text, htmlBody := previewBodies(entity)
+			msg.Preview = MakePreview(text, htmlBody)
+		}
 		messages = append(messages, msg)
 	}
 
@@ -134,11 +160,15 @@ func (c *POP3Client) FetchMessages(opts FetchOptions) (*ListResult, error) {
 		messages[i], messages[j] = messages[j], messages[i]
 	}
 
-	return &ListResult{
+	result := &ListResult{
 		Messages: messages,
 		Total:    count,
 		Folder:   "INBOX",
-	}, nil
+	}
+	if start > 1 {
+		result.Next = &Cursor{UID: uint32(start)}
+	}
+	return result, nil
 }
 
 // FetchMessage fetches a single message by its sequence number (1-based).
@@ -150,6 +180,9 @@ func (c *POP3Client) FetchMessage(msgID uint32) (*Message, error) {
 	}
 	defer cleanup()
 
+	if err := c.checkSize(msgID); err != nil {
+		return nil, err
+	}
 	entity, err := c.conn.retr(int(msgID))
 	if err != nil {
 		return nil, fmt.Errorf("POP3 RETR %d failed: %w", msgID, err)
@@ -161,6 +194,71 @@ func (c *POP3Client) FetchMessage(msgID uint32) (*Message, error) {
 	return msg, nil
 }
 
+// FetchMessageStream fetches a message like FetchMessage, but hands fn a
+// MessageStream to read its bodies and attachments part by part instead
+// of decoding them all into the Message. POP3 has no partial fetch, so the
+// source is still downloaded whole; the decoded parts are not held in
+// memory. The stream is only valid during fn.
+func (c *POP3Client) FetchMessageStream(msgID uint32, fn func(*MessageStream) error) error {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if err := c.checkSize(msgID); err != nil {
+		return err
+	}
+	b, err := c.conn.cmd("RETR", true, int(msgID))
+	if err != nil {
+		return fmt.Errorf("POP3 RETR %d failed: %w", msgID, err)
+	}
+	size := b.Len()
+	stream, err := NewMessageStream(b, c.config.MaxBodyBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse message %d: %w", msgID, err)
+	}
+	stream.Message.UID = msgID
+	stream.Message.SeqNum = msgID
+	stream.Message.Size = uint32(size)
+	stream.Message.Internal = true
+	return fn(stream)
+}
+
+// FetchRawMessage returns the full RFC 5322 source of a message.
+func (c *POP3Client) FetchRawMessage(msgID uint32) ([]byte, error) {
+	cleanup, err := c.ensureConnected()
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	if err := c.checkSize(msgID); err != nil {
+		return nil, err
+	}
+	b, err := c.conn.cmd("RETR", true, int(msgID))
+	if err != nil {
+		return nil, fmt.Errorf("POP3 RETR %d failed: %w", msgID, err)
+	}
+	return b.Bytes(), nil
+}
+
+// checkSize returns ErrBodyTooLarge if message msgID is larger than the
+// MaxBodyBytes of the config, as reported by LIST.
+func (c *POP3Client) checkSize(msgID uint32) error {
+	if c.config.MaxBodyBytes <= 0 {
+		return nil
+	}
+	ids, err := c.conn.list(int(msgID))
+	if err != nil {
+		return fmt.Errorf("POP3 LIST %d failed: %w", msgID, err)
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("POP3 LIST %d: no such message", msgID)
+	}
+	return checkBodySize(int64(ids[0].Size), c.config.MaxBodyBytes)
+}
+
 // DeleteMessage deletes a message by its sequence number.
 // POP3 deletions are only finalized on a successful QUIT.
 func (c *POP3Client) DeleteMessage(msgID uint32) error {
@@ -196,14 +294,7 @@ func (c *POP3Client) DeleteMessageByID(_ string, uid uint32, _ bool) error {
 // tlsConfig returns the TLS configuration to use. If none is set in the
 // config, a sensible default with the server name is returned.
 func (c *POP3Client) tlsConfig() *tls.Config {
-	if c.config.TLSConfig != nil {
-		cfg := c.config.TLSConfig.Clone()
-		if cfg.ServerName == "" {
-			cfg.ServerName = c.config.Host
-		}
-		return cfg
-	}
-	return &tls.Config{ServerName: c.config.Host}
+	return clientTLSConfig(c.config.TLSConfig, c.config.Host)
 }
 
 // ListMessageIDs returns all message (id, size) pairs.
@@ -231,10 +322,19 @@ func (c *POP3Client) dial() (*pop3Conn, error) {
 
 	dialer := &net.Dialer{Timeout: 10 * time.Second}
 
-	if c.config.SSL {
+	switch {
+	case c.config.Dial != nil:
+		if netConn, err = c.config.Dial("tcp", addr); err == nil && c.config.SSL {
+			tlsConn := tls.Client(netConn, c.tlsConfig())
+			if err = tlsConn.Handshake(); err != nil {
+				netConn.Close()
+			}
+			netConn = tlsConn
+		}
+	case c.config.SSL:
 		tlsCfg := c.tlsConfig()
 		netConn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsCfg)
-	} else {
+	default:
 		netConn, err = dialer.Dial("tcp", addr)
 	}
 	if err != nil {
@@ -358,7 +458,7 @@ func (c *pop3Conn) cmd(cmd string, isMulti bool, args ...interface{}) (*bytes.Bu
 
 // readOne reads a single-line response and checks +OK/-ERR.
 func (c *pop3Conn) readOne() ([]byte, error) {
-	b, _, err := c.r.ReadLine()
+	b, err := c.readLine()
 	if err != nil {
 		return nil, err
 	}
@@ -367,11 +467,33 @@ func (c *pop3Conn) readOne() ([]byte, error) {
 
 const maxPOP3ResponseSize = 100 << 20 // 100MB maximum POP3 response size
 
+// readLine reads one complete line without its line ending. Lines longer
+// than the bufio buffer are reassembled instead of being split into
+// fragments, so a fragment can never be mistaken for the "." terminator.
+func (c *pop3Conn) readLine() ([]byte, error) {
+	b, isPrefix, err := c.r.ReadLine()
+	if err != nil || !isPrefix {
+		return b, err
+	}
+	line := append([]byte(nil), b...)
+	for isPrefix {
+		b, isPrefix, err = c.r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b...)
+		if len(line) > maxPOP3ResponseSize {
+			return nil, fmt.Errorf("POP3 response line exceeds maximum size (%d bytes)", maxPOP3ResponseSize)
+		}
+	}
+	return line, nil
+}
+
 // readAll reads lines until the POP3 multiline terminator ".".
 func (c *pop3Conn) readAll() (*bytes.Buffer, error) {
 	buf := &bytes.Buffer{}
 	for {
-		b, _, err := c.r.ReadLine()
+		b, err := c.readLine()
 		if err != nil {
 			return nil, err
 		}
@@ -413,25 +535,22 @@ func (c *pop3Conn) auth(user, password string) error {
 	return err
 }
 
+// capa returns the lines of the CAPA response.
+func (c *pop3Conn) capa() ([]string, error) {
+	buf, err := c.cmd("CAPA", true)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(buf.String(), "\r\n"), "\r\n"), nil
+}
+
 // stat returns message count and total size.
 func (c *pop3Conn) stat() (count, size int, err error) {
 	b, err := c.cmd("STAT", false)
 	if err != nil {
 		return 0, 0, err
 	}
-	f := bytes.Fields(b.Bytes())
-	if len(f) < 2 {
-		return 0, 0, fmt.Errorf("POP3 STAT: unexpected response format")
-	}
-	count, err = strconv.Atoi(string(f[0]))
-	if err != nil {
-		return 0, 0, fmt.Errorf("POP3 STAT: invalid count %q: %w", f[0], err)
-	}
-	size, err = strconv.Atoi(string(f[1]))
-	if err != nil {
-		return 0, 0, fmt.Errorf("POP3 STAT: invalid size %q: %w", f[1], err)
-	}
-	return count, size, nil
+	return parsePOP3Stat(b.Bytes())
 }
 
 // list returns message IDs and sizes. If msgID > 0, only that message.
@@ -447,24 +566,7 @@ func (c *pop3Conn) list(msgID int) ([]POP3MessageID, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	var out []POP3MessageID
-	for _, l := range bytes.Split(buf.Bytes(), pop3LineBreak) {
-		f := bytes.Fields(l)
-		if len(f) < 2 {
-			continue
-		}
-		id, err := strconv.Atoi(string(f[0]))
-		if err != nil {
-			continue // skip unparseable lines
-		}
-		sz, err := strconv.Atoi(string(f[1]))
-		if err != nil {
-			continue
-		}
-		out = append(out, POP3MessageID{ID: id, Size: sz})
-	}
-	return out, nil
+	return parsePOP3List(buf.Bytes()), nil
 }
 
 // uidl returns message IDs and UIDs.
@@ -480,20 +582,7 @@ func (c *pop3Conn) uidl(msgID int) ([]POP3MessageID, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	var out []POP3MessageID
-	for _, l := range bytes.Split(buf.Bytes(), pop3LineBreak) {
-		f := bytes.Fields(l)
-		if len(f) < 2 {
-			continue
-		}
-		id, err := strconv.Atoi(string(f[0]))
-		if err != nil {
-			continue
-		}
-		out = append(out, POP3MessageID{ID: id, UID: string(f[1])})
-	}
-	return out, nil
+	return parsePOP3UIDL(buf.Bytes()), nil
 }
 
 // retr downloads and parses a message.
@@ -503,7 +592,7 @@ func (c *pop3Conn) retr(msgID int) (*gomessage.Entity, error) {
 		return nil, err
 	}
 	m, err := gomessage.Read(b)
-	if err != nil && !gomessage.IsUnknownCharset(err) {
+	if !isRecoverableEntityError(err) {
 		return nil, err
 	}
 	return m, nil
@@ -516,7 +605,7 @@ func (c *pop3Conn) top(msgID, numLines int) (*gomessage.Entity, error) {
 		return nil, err
 	}
 	m, err := gomessage.Read(b)
-	if err != nil {
+	if !isRecoverableEntityError(err) {
 		return nil, err
 	}
 	return m, nil
@@ -538,7 +627,7 @@ func (c *pop3Conn) quit() error {
 
 func parsePOP3Resp(b []byte) ([]byte, error) {
 	if len(b) == 0 {
-		return nil, nil
+		return nil, errors.New("POP3: empty response")
 	}
 	if bytes.Equal(b, pop3RespOK) {
 		return nil, nil
@@ -555,6 +644,63 @@ func parsePOP3Resp(b []byte) ([]byte, error) {
 	return nil, fmt.Errorf("POP3: unexpected response: %s", string(b))
 }
 
+// parsePOP3Stat parses the "count size" payload of a STAT response.
+// Negative values are rejected so callers can size slices from them.
+func parsePOP3Stat(b []byte) (count, size int, err error) {
+	f := bytes.Fields(b)
+	if len(f) < 2 {
+		return 0, 0, fmt.Errorf("POP3 STAT: unexpected response format")
+	}
+	count, err = strconv.Atoi(string(f[0]))
+	if err != nil || count < 0 {
+		return 0, 0, fmt.Errorf("POP3 STAT: invalid count %q", f[0])
+	}
+	size, err = strconv.Atoi(string(f[1]))
+	if err != nil || size < 0 {
+		return 0, 0, fmt.Errorf("POP3 STAT: invalid size %q", f[1])
+	}
+	return count, size, nil
+}
+
+// parsePOP3List parses "id size" lines from a LIST response, skipping
+// lines that are malformed or carry a non-positive message number.
+func parsePOP3List(b []byte) []POP3MessageID {
+	var out []POP3MessageID
+	for _, l := range bytes.Split(b, pop3LineBreak) {
+		f := bytes.Fields(l)
+		if len(f) < 2 {
+			continue
+		}
+		id, err := strconv.Atoi(string(f[0]))
+		if err != nil || id <= 0 {
+			continue // skip unparseable lines
+		}
+		sz, err := strconv.Atoi(string(f[1]))
+		if err != nil || sz < 0 {
+			continue
+		}
+		out = append(out, POP3MessageID{ID: id, Size: sz})
+	}
+	return out
+}
+
+// parsePOP3UIDL parses "id uid" lines from a UIDL response.
+func parsePOP3UIDL(b []byte) []POP3MessageID {
+	var out []POP3MessageID
+	for _, l := range bytes.Split(b, pop3LineBreak) {
+		f := bytes.Fields(l)
+		if len(f) < 2 {
+			continue
+		}
+		id, err := strconv.Atoi(string(f[0]))
+		if err != nil || id <= 0 {
+			continue
+		}
+		out = append(out, POP3MessageID{ID: id, UID: string(f[1])})
+	}
+	return out
+}
+
 // ---------- message conversion ----------
 
 // pop3EntityToMessage converts a go-message Entity to our Message,
@@ -565,10 +711,17 @@ func pop3EntityToMessage(entity *gomessage.Entity, seqNum uint32) *Message {
 		SeqNum:   seqNum,
 		Internal: true,
 	}
+	parseHeaderFields(msg, entity.Header)
+	return msg
+}
 
-	h := mail.Header{Header: entity.Header}
+// parseHeaderFields sets the envelope and spam fields of msg from the
+// header of a message source.
+func parseHeaderFields(msg *Message, header gomessage.Header) {
+	h := mail.Header{Header: header}
 
 	msg.Subject, _ = h.Subject()
+	msg.Subject = decodeHeaderValue(msg.Subject)
 	msg.Date, _ = h.Date()
 	msg.MessageID = h.Get("Message-Id")
 	msg.InReplyTo = h.Get("In-Reply-To")
@@ -586,19 +739,13 @@ func pop3EntityToMessage(entity *gomessage.Entity, seqNum uint32) *Message {
 	if cc, err := h.AddressList("Cc"); err == nil {
 		msg.Cc = pop3MailAddrsToEmail(cc)
 	}
-
-	return msg
+	parseSpamHeaders(msg, header)
 }
 
 func pop3MailAddrsToEmail(addrs []*mail.Address) []Address {
-	dec := &mime.WordDecoder{}
 	out := make([]Address, len(addrs))
 	for i, a := range addrs {
-		name := a.Name
-		if decoded, err := dec.DecodeHeader(name); err == nil {
-			name = decoded
-		}
-		out[i] = Address{Name: name, Email: a.Address}
+		out[i] = Address{Name: decodeHeaderValue(a.Name), Email: a.Address}
 	}
 	return out
 }
@@ -2,315 +2,101 @@ package email
 
 import (
 	"bufio"
-	"crypto/tls"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 	"testing"
+
+	gomessage "github.com/emersion/go-message"
+
+	"github.com/emx-mail/cli/pkgs/testutil"
 )
 
 // ---------------------------------------------------------------------------
-// POP3 mock server (raw TCP, RFC 1939)
+// Tests
 // ---------------------------------------------------------------------------
 
-type pop3MockMsg struct {
-	ID   int
-	UIDL string
-	Data string // RFC 5322 raw
-}
-
-type pop3MockOpts struct {
-	Messages    []pop3MockMsg
-	UseTLS      bool // implicit TLS (POP3S)
-	SupportSTLS bool // advertise and handle STLS
-	RejectAuth  bool
-}
-
-func newTestPOP3Server(t *testing.T, opts pop3MockOpts) string {
-	t.Helper()
+func TestPOP3Connect_SSL(t *testing.T) {
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
+		UseTLS: true,
+		Messages: []testutil.POP3Message{
+			{ID: 1, UIDL: "u1", Data: testMailRFC822},
+		},
+	})
+	host, port := testutil.SplitHostPort(t, addr)
 
-	var tlsConfig *tls.Config
-	if opts.UseTLS || opts.SupportSTLS {
-		tlsConfig = newTestTLSConfig(t)
-	}
+	client := NewPOP3Client(POP3Config{
+		Host:      host,
+		Port:      port,
+		Username:  testutil.Username,
+		Password:  testutil.Password,
+		SSL:       true,
+		TLSConfig: testutil.InsecureTLSConfig(),
+	})
 
-	var ln net.Listener
-	var err error
-	if opts.UseTLS {
-		ln, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
-	} else {
-		ln, err = net.Listen("tcp", "127.0.0.1:0")
-	}
+	result, err := client.FetchMessages(FetchOptions{Limit: 10})
 	if err != nil {
-		t.Fatal(err)
-	}
-	t.Cleanup(func() { ln.Close() })
-
-	go func() {
-		for {
-			raw, err := ln.Accept()
-			if err != nil {
-				return
-			}
-			go handlePOP3MockConn(raw, opts, tlsConfig)
-		}
-	}()
-
-	return ln.Addr().String()
-}
-
-func handlePOP3MockConn(conn net.Conn, opts pop3MockOpts, tlsCfg *tls.Config) {
-	defer conn.Close()
-
-	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
-	writeLine := func(s string) {
-		fmt.Fprintf(rw, "%s\r\n", s)
-		rw.Flush()
+		t.Fatalf("FetchMessages() via SSL error: %v", err)
 	}
-
-	writeLine("+OK POP3 server ready")
-
-	authed := false
-	deleted := map[int]bool{}
-
-	for {
-		line, err := rw.ReadString('\n')
-		if err != nil {
-			return
-		}
-		line = strings.TrimRight(line, "\r\n")
-		fields := strings.Fields(line)
-		if len(fields) == 0 {
-			continue
-		}
-		cmd := strings.ToUpper(fields[0])
-
-		switch cmd {
-		case "CAPA":
-			writeLine("+OK")
-			if opts.SupportSTLS {
-				writeLine("STLS")
-			}
-			writeLine("UIDL")
-			writeLine("TOP")
-			writeLine(".")
-
-		case "STLS":
-			if !opts.SupportSTLS || tlsCfg == nil {
-				writeLine("-ERR STLS not supported")
-				continue
-			}
-			writeLine("+OK Begin TLS")
-			rw.Flush()
-			tlsConn := tls.Server(conn, tlsCfg)
-			if err := tlsConn.Handshake(); err != nil {
-				return
-			}
-			conn = tlsConn
-			rw = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
-
-		case "USER":
-			writeLine("+OK")
-
-		case "PASS":
-			if opts.RejectAuth {
-				writeLine("-ERR auth failed")
-				continue
-			}
-			authed = true
-			writeLine("+OK Logged in")
-
-		case "NOOP":
-			if !authed {
-				writeLine("-ERR not authenticated")
-				continue
-			}
-			writeLine("+OK")
-
-		case "STAT":
-			if !authed {
-				writeLine("-ERR not authenticated")
-				continue
-			}
-			total := 0
-			totalSize := 0
-			for _, m := range opts.Messages {
-				if !deleted[m.ID] {
-					total++
-					totalSize += len(m.Data)
-				}
-			}
-			writeLine(fmt.Sprintf("+OK %d %d", total, totalSize))
-
-		case "LIST":
-			if !authed {
-				writeLine("-ERR not authenticated")
-				continue
-			}
-			if len(fields) > 1 {
-				// single-message LIST
-				idx := 0
-				fmt.Sscanf(fields[1], "%d", &idx)
-				for _, m := range opts.Messages {
-					if m.ID == idx && !deleted[idx] {
-						writeLine(fmt.Sprintf("+OK %d %d", m.ID, len(m.Data)))
-						goto listDone
-					}
-				}
-				writeLine("-ERR no such message")
-			listDone:
-			} else {
-				writeLine("+OK")
-				for _, m := range opts.Messages {
-					if !deleted[m.ID] {
-						writeLine(fmt.Sprintf("%d %d", m.ID, len(m.Data)))
-					}
-				}
-				writeLine(".")
-			}
-
-		case "UIDL":
-			if !authed {
-				writeLine("-ERR not authenticated")
-				continue
-			}
-			writeLine("+OK")
-			for _, m := range opts.Messages {
-				if !deleted[m.ID] {
-					uid := m.UIDL
-					if uid == "" {
-						uid = fmt.Sprintf("msg-%d", m.ID)
-					}
-					writeLine(fmt.Sprintf("%d %s", m.ID, uid))
-				}
-			}
-			writeLine(".")
-
-		case "RETR":
-			if !authed {
-				writeLine("-ERR not authenticated")
-				continue
-			}
-			idx := 0
-			if len(fields) > 1 {
-				fmt.Sscanf(fields[1], "%d", &idx)
-			}
-			if idx < 1 || idx > len(opts.Messages) || deleted[idx] {
-				writeLine("-ERR no such message")
-				continue
-			}
-			writeLine("+OK")
-			// Write message data line by line
-			for _, dataLine := range strings.Split(opts.Messages[idx-1].Data, "\r\n") {
-				// Byte-stuff lines starting with "."
-				if strings.HasPrefix(dataLine, ".") {
-					writeLine("." + dataLine)
-				} else {
-					writeLine(dataLine)
-				}
-			}
-			writeLine(".")
-
-		case "TOP":
-			if !authed {
-				writeLine("-ERR not authenticated")
-				continue
-			}
-			idx, numLines := 0, 0
-			if len(fields) > 1 {
-				fmt.Sscanf(fields[1], "%d", &idx)
-			}
-			if len(fields) > 2 {
-				fmt.Sscanf(fields[2], "%d", &numLines)
-			}
-			if idx < 1 || idx > len(opts.Messages) {
-				writeLine("-ERR no such message")
-				continue
-			}
-			writeLine("+OK")
-			parts := strings.SplitN(opts.Messages[idx-1].Data, "\r\n\r\n", 2)
-			// Headers
-			for _, hl := range strings.Split(parts[0], "\r\n") {
-				writeLine(hl)
-			}
-			writeLine("") // empty line between headers and body
-			if len(parts) > 1 && numLines > 0 {
-				bodyLines := strings.Split(parts[1], "\r\n")
-				for i := 0; i < numLines && i < len(bodyLines); i++ {
-					writeLine(bodyLines[i])
-				}
-			}
-			writeLine(".")
-
-		case "DELE":
-			if !authed {
-				writeLine("-ERR not authenticated")
-				continue
-			}
-			idx := 0
-			if len(fields) > 1 {
-				fmt.Sscanf(fields[1], "%d", &idx)
-			}
-			deleted[idx] = true
-			writeLine("+OK")
-
-		case "QUIT":
-			writeLine("+OK Bye")
-			return
-
-		default:
-			writeLine("-ERR unknown command")
-		}
+	if len(result.Messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(result.Messages))
 	}
 }
 
-// ---------------------------------------------------------------------------
-// Tests
-// ---------------------------------------------------------------------------
-
-func TestPOP3Connect_SSL(t *testing.T) {
-	addr := newTestPOP3Server(t, pop3MockOpts{
+func TestPOP3Connect_Dial(t *testing.T) {
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
 		UseTLS: true,
-		Messages: []pop3MockMsg{
+		Messages: []testutil.POP3Message{
 			{ID: 1, UIDL: "u1", Data: testMailRFC822},
 		},
 	})
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 
+	var dialed []string
 	client := NewPOP3Client(POP3Config{
 		Host:      host,
 		Port:      port,
-		Username:  "testuser",
-		Password:  "testpass",
+		Username:  testutil.Username,
+		Password:  testutil.Password,
 		SSL:       true,
-		TLSConfig: insecureTLSConfig(),
+		TLSConfig: testutil.InsecureTLSConfig(),
+		Dial: func(network, addr string) (net.Conn, error) {
+			dialed = append(dialed, network+" "+addr)
+			return net.Dial(network, addr)
+		},
 	})
 
 	result, err := client.FetchMessages(FetchOptions{Limit: 10})
 	if err != nil {
-		t.Fatalf("FetchMessages() via SSL error: %v", err)
+		t.Fatalf("FetchMessages() over a dialed connection error: %v", err)
 	}
 	if len(result.Messages) != 1 {
 		t.Fatalf("expected 1 message, got %d", len(result.Messages))
 	}
+	if len(dialed) != 1 || dialed[0] != "tcp "+addr {
+		t.Errorf("dialed = %v, want [tcp %s]", dialed, addr)
+	}
 }
 
 func TestPOP3Connect_STARTTLS(t *testing.T) {
-	addr := newTestPOP3Server(t, pop3MockOpts{
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
 		SupportSTLS: true,
-		Messages: []pop3MockMsg{
+		Messages: []testutil.POP3Message{
 			{ID: 1, UIDL: "u1", Data: testMailRFC822},
 		},
 	})
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewPOP3Client(POP3Config{
 		Host:      host,
 		Port:      port,
-		Username:  "testuser",
-		Password:  "testpass",
+		Username:  testutil.Username,
+		Password:  testutil.Password,
 		StartTLS:  true,
-		TLSConfig: insecureTLSConfig(),
+		TLSConfig: testutil.InsecureTLSConfig(),
 	})
 
 	result, err := client.FetchMessages(FetchOptions{Limit: 10})
@@ -324,18 +110,18 @@ func TestPOP3Connect_STARTTLS(t *testing.T) {
 
 func TestPOP3Connect_Plaintext_Rejected(t *testing.T) {
 	// Server is available, but client should refuse plaintext
-	addr := newTestPOP3Server(t, pop3MockOpts{
-		Messages: []pop3MockMsg{
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
+		Messages: []testutil.POP3Message{
 			{ID: 1, Data: testMailRFC822},
 		},
 	})
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewPOP3Client(POP3Config{
 		Host:     host,
 		Port:     port,
-		Username: "testuser",
-		Password: "testpass",
+		Username: testutil.Username,
+		Password: testutil.Password,
 		SSL:      false,
 		StartTLS: false,
 	})
@@ -351,20 +137,20 @@ func TestPOP3Connect_Plaintext_Rejected(t *testing.T) {
 }
 
 func TestPOP3Connect_BadAuth(t *testing.T) {
-	addr := newTestPOP3Server(t, pop3MockOpts{
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
 		UseTLS:     true,
 		RejectAuth: true,
-		Messages:   []pop3MockMsg{{ID: 1, Data: testMailRFC822}},
+		Messages:   []testutil.POP3Message{{ID: 1, Data: testMailRFC822}},
 	})
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewPOP3Client(POP3Config{
 		Host:      host,
 		Port:      port,
-		Username:  "testuser",
-		Password:  "testpass",
+		Username:  testutil.Username,
+		Password:  testutil.Password,
 		SSL:       true,
-		TLSConfig: insecureTLSConfig(),
+		TLSConfig: testutil.InsecureTLSConfig(),
 	})
 
 	_, err := client.FetchMessages(FetchOptions{Limit: 10})
@@ -374,20 +160,20 @@ func TestPOP3Connect_BadAuth(t *testing.T) {
 }
 
 func TestPOP3FetchMessages(t *testing.T) {
-	addr := newTestPOP3Server(t, pop3MockOpts{
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
 		UseTLS: true,
-		Messages: []pop3MockMsg{
+		Messages: []testutil.POP3Message{
 			{ID: 1, UIDL: "uid-1", Data: testMailRFC822},
 			{ID: 2, UIDL: "uid-2", Data: testMailRFC822},
 			{ID: 3, UIDL: "uid-3", Data: testMailRFC822},
 		},
 	})
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewPOP3Client(POP3Config{
 		Host: host, Port: port,
-		Username: "testuser", Password: "testpass",
-		SSL: true, TLSConfig: insecureTLSConfig(),
+		Username: testutil.Username, Password: testutil.Password,
+		SSL: true, TLSConfig: testutil.InsecureTLSConfig(),
 	})
 
 	result, err := client.FetchMessages(FetchOptions{Limit: 10})
@@ -403,18 +189,18 @@ func TestPOP3FetchMessages(t *testing.T) {
 }
 
 func TestPOP3FetchMessage_Single(t *testing.T) {
-	addr := newTestPOP3Server(t, pop3MockOpts{
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
 		UseTLS: true,
-		Messages: []pop3MockMsg{
+		Messages: []testutil.POP3Message{
 			{ID: 1, UIDL: "uid-1", Data: testMailRFC822},
 		},
 	})
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewPOP3Client(POP3Config{
 		Host: host, Port: port,
-		Username: "testuser", Password: "testpass",
-		SSL: true, TLSConfig: insecureTLSConfig(),
+		Username: testutil.Username, Password: testutil.Password,
+		SSL: true, TLSConfig: testutil.InsecureTLSConfig(),
 	})
 
 	msg, err := client.FetchMessage(1)
@@ -430,18 +216,18 @@ func TestPOP3FetchMessage_Single(t *testing.T) {
 }
 
 func TestPOP3FetchMessage_Multipart(t *testing.T) {
-	addr := newTestPOP3Server(t, pop3MockOpts{
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
 		UseTLS: true,
-		Messages: []pop3MockMsg{
+		Messages: []testutil.POP3Message{
 			{ID: 1, UIDL: "uid-mp", Data: testMailMultipart},
 		},
 	})
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewPOP3Client(POP3Config{
 		Host: host, Port: port,
-		Username: "testuser", Password: "testpass",
-		SSL: true, TLSConfig: insecureTLSConfig(),
+		Username: testutil.Username, Password: testutil.Password,
+		SSL: true, TLSConfig: testutil.InsecureTLSConfig(),
 	})
 
 	msg, err := client.FetchMessage(1)
@@ -456,19 +242,62 @@ func TestPOP3FetchMessage_Multipart(t *testing.T) {
 	}
 }
 
+func TestPOP3FetchMessageStream(t *testing.T) {
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
+		UseTLS: true,
+		Messages: []testutil.POP3Message{
+			{ID: 1, UIDL: "uid-mp", Data: testMailMultipart},
+		},
+	})
+	host, port := testutil.SplitHostPort(t, addr)
+
+	config := POP3Config{
+		Host: host, Port: port,
+		Username: testutil.Username, Password: testutil.Password,
+		SSL: true, TLSConfig: testutil.InsecureTLSConfig(),
+	}
+	var kinds []string
+	err := NewPOP3Client(config).FetchMessageStream(1, func(s *MessageStream) error {
+		if s.Message.Subject != "Multipart Test" || s.Message.Size == 0 {
+			t.Errorf("Message = %+v", s.Message)
+		}
+		for {
+			p, err := s.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			kinds = append(kinds, p.Kind)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(kinds, ",") != "text,attachment" {
+		t.Errorf("parts = %v, want text,attachment", kinds)
+	}
+
+	config.MaxBodyBytes = 100
+	if _, err := NewPOP3Client(config).FetchMessage(1); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("FetchMessage() over MaxBodyBytes: error = %v, want ErrBodyTooLarge", err)
+	}
+}
+
 func TestPOP3DeleteMessage(t *testing.T) {
-	addr := newTestPOP3Server(t, pop3MockOpts{
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
 		UseTLS: true,
-		Messages: []pop3MockMsg{
+		Messages: []testutil.POP3Message{
 			{ID: 1, UIDL: "uid-del", Data: testMailRFC822},
 		},
 	})
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewPOP3Client(POP3Config{
 		Host: host, Port: port,
-		Username: "testuser", Password: "testpass",
-		SSL: true, TLSConfig: insecureTLSConfig(),
+		Username: testutil.Username, Password: testutil.Password,
+		SSL: true, TLSConfig: testutil.InsecureTLSConfig(),
 	})
 
 	err := client.DeleteMessage(1)
@@ -478,19 +307,19 @@ func TestPOP3DeleteMessage(t *testing.T) {
 }
 
 func TestPOP3ListMessageIDs(t *testing.T) {
-	addr := newTestPOP3Server(t, pop3MockOpts{
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
 		UseTLS: true,
-		Messages: []pop3MockMsg{
+		Messages: []testutil.POP3Message{
 			{ID: 1, UIDL: "uid-1", Data: testMailRFC822},
 			{ID: 2, UIDL: "uid-2", Data: testMailRFC822},
 		},
 	})
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewPOP3Client(POP3Config{
 		Host: host, Port: port,
-		Username: "testuser", Password: "testpass",
-		SSL: true, TLSConfig: insecureTLSConfig(),
+		Username: testutil.Username, Password: testutil.Password,
+		SSL: true, TLSConfig: testutil.InsecureTLSConfig(),
 	})
 
 	ids, err := client.ListMessageIDs()
@@ -506,18 +335,18 @@ func TestPOP3MailReceiver(t *testing.T) {
 	// Compile-time check
 	var _ MailReceiver = (*POP3Client)(nil)
 
-	addr := newTestPOP3Server(t, pop3MockOpts{
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
 		UseTLS: true,
-		Messages: []pop3MockMsg{
+		Messages: []testutil.POP3Message{
 			{ID: 1, UIDL: "uid-mr", Data: testMailRFC822},
 		},
 	})
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	var receiver MailReceiver = NewPOP3Client(POP3Config{
 		Host: host, Port: port,
-		Username: "testuser", Password: "testpass",
-		SSL: true, TLSConfig: insecureTLSConfig(),
+		Username: testutil.Username, Password: testutil.Password,
+		SSL: true, TLSConfig: testutil.InsecureTLSConfig(),
 	})
 
 	result, err := receiver.FetchMessages(FetchOptions{Limit: 10})
@@ -542,9 +371,9 @@ func TestPOP3MailReceiver(t *testing.T) {
 }
 
 func TestPOP3FetchMessages_WithLimit(t *testing.T) {
-	addr := newTestPOP3Server(t, pop3MockOpts{
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
 		UseTLS: true,
-		Messages: []pop3MockMsg{
+		Messages: []testutil.POP3Message{
 			{ID: 1, UIDL: "u1", Data: testMailRFC822},
 			{ID: 2, UIDL: "u2", Data: testMailRFC822},
 			{ID: 3, UIDL: "u3", Data: testMailRFC822},
@@ -552,12 +381,12 @@ func TestPOP3FetchMessages_WithLimit(t *testing.T) {
 			{ID: 5, UIDL: "u5", Data: testMailRFC822},
 		},
 	})
-	host, port := splitHostPort(t, addr)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewPOP3Client(POP3Config{
 		Host: host, Port: port,
-		Username: "testuser", Password: "testpass",
-		SSL: true, TLSConfig: insecureTLSConfig(),
+		Username: testutil.Username, Password: testutil.Password,
+		SSL: true, TLSConfig: testutil.InsecureTLSConfig(),
 	})
 
 	result, err := client.FetchMessages(FetchOptions{Limit: 2})
@@ -571,3 +400,162 @@ func TestPOP3FetchMessages_WithLimit(t *testing.T) {
 		t.Errorf("expected Total=5, got %d", result.Total)
 	}
 }
+
+func TestPOP3FetchMessages_Pages(t *testing.T) {
+	msgs := make([]testutil.POP3Message, 5)
+	for i := range msgs {
+		msgs[i] = testutil.POP3Message{ID: i + 1, UIDL: fmt.Sprintf("u%d", i+1), Data: testMailRFC822}
+	}
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{UseTLS: true, Messages: msgs})
+	host, port := testutil.SplitHostPort(t, addr)
+
+	client := NewPOP3Client(POP3Config{
+		Host: host, Port: port,
+		Username: testutil.Username, Password: testutil.Password,
+		SSL: true, TLSConfig: testutil.InsecureTLSConfig(),
+	})
+
+	var got []uint32
+	opts := FetchOptions{Limit: 2}
+	for page := 0; page < 5; page++ {
+		result, err := client.FetchMessages(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range result.Messages {
+			got = append(got, m.UID)
+		}
+		if result.Next == nil {
+			break
+		}
+		opts.Before = result.Next
+	}
+	if fmt.Sprint(got) != "[5 4 3 2 1]" {
+		t.Errorf("paged IDs = %v, want [5 4 3 2 1]", got)
+	}
+
+	result, err := client.FetchMessages(FetchOptions{Limit: 2, Offset: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Messages) != 1 || result.Messages[0].UID != 1 || result.Next != nil {
+		t.Errorf("offset 4: got %d messages, Next=%v", len(result.Messages), result.Next)
+	}
+}
+
+func TestPOP3Capabilities(t *testing.T) {
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{UseTLS: true})
+	host, port := testutil.SplitHostPort(t, addr)
+
+	client := NewPOP3Client(POP3Config{
+		Host: host, Port: port,
+		Username: testutil.Username, Password: testutil.Password,
+		SSL: true, TLSConfig: testutil.InsecureTLSConfig(),
+	})
+	caps, err := client.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(caps.List, ",") != "UIDL,TOP" {
+		t.Errorf("List = %v, want [UIDL TOP]", caps.List)
+	}
+	for _, f := range caps.Features {
+		if want := f.Capability == "TOP"; f.Enabled != want {
+			t.Errorf("feature %s enabled = %v, want %v", f.Capability, f.Enabled, want)
+		}
+	}
+}
+
+func TestPOP3FetchMessages_Preview(t *testing.T) {
+	addr := testutil.NewPOP3Server(t, testutil.POP3Options{
+		UseTLS: true,
+		Messages: []testutil.POP3Message{
+			{ID: 1, UIDL: "u1", Data: testMailNested},
+		},
+	})
+	host, port := testutil.SplitHostPort(t, addr)
+
+	client := NewPOP3Client(POP3Config{
+		Host: host, Port: port,
+		Username: testutil.Username, Password: testutil.Password,
+		SSL: true, TLSConfig: testutil.InsecureTLSConfig(),
+	})
+
+	result, err := client.FetchMessages(FetchOptions{Limit: 1, Preview: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(result.Messages))
+	}
+	if got := result.Messages[0].Preview; got != "Plain version" {
+		t.Errorf("Preview = %q, want %q", got, "Plain version")
+	}
+	if result.Messages[0].TextBody != "" {
+		t.Errorf("listing should not fill TextBody, got %q", result.Messages[0].TextBody)
+	}
+}
+
+func TestPOP3ReadAll_LongLine(t *testing.T) {
+	// A line longer than the bufio buffer must come back intact; the
+	// fragment boundary must not be treated as a line break or terminator.
+	long := strings.Repeat("x", 5000) + "."
+	c := &pop3Conn{r: bufio.NewReaderSize(strings.NewReader(long+"\r\n.\r\n"), 16)}
+
+	buf, err := c.readAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != long+"\r\n" {
+		t.Errorf("got %d bytes, want %d", len(got), len(long)+2)
+	}
+}
+
+func TestParsePOP3Stat_Negative(t *testing.T) {
+	if _, _, err := parsePOP3Stat([]byte("-5 100")); err == nil {
+		t.Error("expected error for negative count")
+	}
+	if _, _, err := parsePOP3Stat([]byte("5 -1")); err == nil {
+		t.Error("expected error for negative size")
+	}
+	count, size, err := parsePOP3Stat([]byte("3 1024"))
+	if err != nil || count != 3 || size != 1024 {
+		t.Errorf("got (%d, %d, %v), want (3, 1024, nil)", count, size, err)
+	}
+}
+
+// FuzzPOP3Response feeds arbitrary server output through the response
+// readers and the message conversion used by FetchMessages.
+func FuzzPOP3Response(f *testing.F) {
+	f.Add([]byte("+OK 2 320\r\n"))
+	f.Add([]byte("-ERR no such message\r\n"))
+	f.Add([]byte("+OK\r\n1 120\r\n2 200\r\n.\r\n"))
+	f.Add([]byte("+OK\r\n1 abc\r\n..dotted\r\n.\r\n"))
+	f.Add([]byte("+OK\r\n" + strings.ReplaceAll(testMailRFC822, "\n", "\r\n") + "\r\n.\r\n"))
+	f.Add([]byte("+OK\r\nSubject: =?utf-8?q?folded?=\r\n =?utf-8?b?!!!?=\r\n\t\r\n\r\n.\r\n"))
+	f.Add([]byte("\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := &pop3Conn{r: bufio.NewReaderSize(bytes.NewReader(data), 16)}
+		if b, err := c.readOne(); err == nil {
+			parsePOP3Stat(b)
+		}
+
+		c = &pop3Conn{r: bufio.NewReaderSize(bytes.NewReader(data), 16)}
+		if _, err := c.readOne(); err != nil {
+			return
+		}
+		buf, err := c.readAll()
+		if err != nil {
+			return
+		}
+		parsePOP3List(buf.Bytes())
+		parsePOP3UIDL(buf.Bytes())
+
+		entity, err := gomessage.Read(bytes.NewReader(buf.Bytes()))
+		if !isRecoverableEntityError(err) {
+			return
+		}
+		pop3EntityToMessage(entity, 1)
+	})
+}
@@ -0,0 +1,136 @@
+package email
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	gomessage "github.com/emersion/go-message"
+)
+
+// PreviewMaxLen is the maximum length of Message.Preview in runes.
+const PreviewMaxLen = 200
+
+// previewFetchSize is how many bytes of a message are downloaded to build a
+// preview when listing. It covers the headers plus the first lines of the
+// body for typical mail.
+const previewFetchSize = 8 << 10
+
+// previewTopLines is the number of body lines requested with POP3 TOP when
+// listing with previews.
+const previewTopLines = 40
+
+// MakePreview builds a single-line preview of a message body. Quoted reply
+// blocks, forwarded originals and the signature are dropped (see
+// StripQuotedText), whitespace is collapsed and the result is cut to
+// PreviewMaxLen runes. The HTML body is used only when there is no text body.
+func MakePreview(textBody, htmlBody string) string {
+	text := textBody
+	if strings.TrimSpace(text) == "" {
+		text = htmlToPreviewText(htmlBody)
+	}
+
+	preview := strings.Join(strings.Fields(StripQuotedText(text)), " ")
+	if utf8.RuneCountInString(preview) > PreviewMaxLen {
+		preview = string([]rune(preview)[:PreviewMaxLen])
+	}
+	return preview
+}
+
+// htmlToPreviewText reduces an HTML body to plain text for previews: tags
+// are removed, the contents of head, style, script and blockquote elements
+// are skipped and entities are decoded. Block-level tags become line breaks.
+func htmlToPreviewText(s string) string {
+	var b strings.Builder
+	skip := ""
+	for len(s) > 0 {
+		lt := strings.IndexByte(s, '<')
+		if lt < 0 {
+			if skip == "" {
+				b.WriteString(s)
+			}
+			break
+		}
+		if skip == "" {
+			b.WriteString(s[:lt])
+		}
+		s = s[lt:]
+		gt := strings.IndexByte(s, '>')
+		if gt < 0 {
+			break
+		}
+		tag := strings.ToLower(strings.TrimSpace(s[1:gt]))
+		s = s[gt+1:]
+
+		closing := strings.HasPrefix(tag, "/")
+		name := strings.TrimPrefix(tag, "/")
+		if i := strings.IndexAny(name, " \t\r\n/"); i >= 0 {
+			name = name[:i]
+		}
+
+		if skip != "" {
+			if closing && name == skip {
+				skip = ""
+			}
+			continue
+		}
+		switch name {
+		case "head", "style", "script", "blockquote":
+			if !closing {
+				skip = name
+			}
+		case "br", "p", "div", "tr", "li", "h1", "h2", "h3", "h4", "h5", "h6":
+			b.WriteByte('\n')
+		}
+	}
+	return html.UnescapeString(b.String())
+}
+
+// previewFromRaw builds a preview from the start of a raw RFC 5322 message,
+// which may be cut off anywhere.
+func previewFromRaw(raw []byte) string {
+	entity, err := gomessage.Read(bytes.NewReader(raw))
+	if !isRecoverableEntityError(err) {
+		return ""
+	}
+	text, htmlBody := previewBodies(entity)
+	return MakePreview(text, htmlBody)
+}
+
+// previewBodies returns the first text/plain and text/html bodies found in
+// entity, skipping attachments. Unlike parseEntityBody it keeps whatever
+// could be read from a part that is cut off by a partial fetch.
+func previewBodies(entity *gomessage.Entity) (text, htmlBody string) {
+	if mr := entity.MultipartReader(); mr != nil {
+		for text == "" {
+			part, err := mr.NextPart()
+			if !isRecoverableEntityError(err) {
+				break
+			}
+			t, h := previewBodies(part)
+			if text == "" {
+				text = t
+			}
+			if htmlBody == "" {
+				htmlBody = h
+			}
+		}
+		return text, htmlBody
+	}
+
+	if disp, _, _ := entity.Header.ContentDisposition(); disp == "attachment" {
+		return "", ""
+	}
+	ct, _, _ := entity.Header.ContentType()
+	if ct != "" && !strings.HasPrefix(ct, "text/") {
+		return "", ""
+	}
+	data, _ := io.ReadAll(entity.Body)
+	body := strings.ToValidUTF8(string(data), "\uFFFD")
+	if strings.HasPrefix(ct, "text/html") {
+		return "", body
+	}
+	return body, ""
+}
@@ -0,0 +1,83 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMakePreview(t *testing.T) {
+	tests := []struct {
+		name, text, html, want string
+	}{
+		{
+			name: "collapses whitespace",
+			text: "Hi all,\r\n\r\n  the   build\tis green.\r\n",
+			want: "Hi all, the build is green.",
+		},
+		{
+			name: "drops quote and attribution",
+			text: "Sounds good to me.\n\nOn Tue, 5 Mar 2024, Alice <alice@example.com> wrote:\n> Shall we ship?\n> Yes.\n",
+			want: "Sounds good to me.",
+		},
+		{
+			name: "reply below quote",
+			text: "On Tue, Alice wrote:\n> Shall we ship?\n\nYes, tomorrow.",
+			want: "Yes, tomorrow.",
+		},
+		{
+			name: "wrapped attribution",
+			text: "On Tue, 5 Mar 2024 at 10:00, Alice Example <alice@example.com>\nwrote:\n\n> question\nanswer",
+			want: "answer",
+		},
+		{
+			name: "keeps wrote: without quote",
+			text: "Bob wrote:\nthe fix is in.",
+			want: "Bob wrote: the fix is in.",
+		},
+		{
+			name: "strips signature",
+			text: "Thanks!\n-- \nBob\nACME Corp",
+			want: "Thanks!",
+		},
+		{
+			name: "strips forwarded original",
+			text: "FYI\n\n-----Original Message-----\nFrom: x",
+			want: "FYI",
+		},
+		{
+			name: "html fallback",
+			html: "<html><head><style>p{}</style></head><body><p>Hello&nbsp;there</p><blockquote>old</blockquote><div>new &amp; shiny</div></body></html>",
+			want: "Hello there new & shiny",
+		},
+		{
+			name: "empty",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MakePreview(tt.text, tt.html); got != tt.want {
+				t.Errorf("MakePreview() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakePreview_Truncates(t *testing.T) {
+	got := MakePreview(strings.Repeat("é ", PreviewMaxLen), "")
+	if n := len([]rune(got)); n != PreviewMaxLen {
+		t.Errorf("preview length = %d runes, want %d", n, PreviewMaxLen)
+	}
+}
+
+func TestPreviewFromRaw_Truncated(t *testing.T) {
+	// A partial fetch cuts the message inside the first part.
+	raw := testMailNested[:strings.Index(testMailNested, "version\r\n--INNER")]
+	if got := previewFromRaw([]byte(raw)); got != "Plain" {
+		t.Errorf("previewFromRaw() = %q, want %q", got, "Plain")
+	}
+
+	if got := previewFromRaw([]byte(testMailMultipart)); got != "Plain text body" {
+		t.Errorf("previewFromRaw() = %q, want %q", got, "Plain text body")
+	}
+}
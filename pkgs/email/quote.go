@@ -0,0 +1,105 @@
+package email
+
+import "strings"
+
+// IsSignatureDelimiter reports whether line is the RFC 3676 signature
+// separator "-- " (a trailing "\r" is ignored).
+func IsSignatureDelimiter(line string) bool {
+	return strings.TrimSuffix(line, "\r") == "-- "
+}
+
+// SplitSignature splits body at its first signature delimiter line. content
+// is everything before the delimiter without the line break that ends it;
+// signature is everything after the delimiter line. If there is no
+// delimiter, content is body and signature is "".
+func SplitSignature(body string) (content, signature string) {
+	pos := 0
+	for pos <= len(body) {
+		end := strings.IndexByte(body[pos:], '\n')
+		line := body[pos:]
+		next := len(body) + 1
+		if end >= 0 {
+			line = body[pos : pos+end]
+			next = pos + end + 1
+		}
+		if IsSignatureDelimiter(line) {
+			content = strings.TrimSuffix(strings.TrimSuffix(body[:pos], "\n"), "\r")
+			if next <= len(body) {
+				signature = body[next:]
+			}
+			return content, signature
+		}
+		pos = next
+	}
+	return body, ""
+}
+
+// StripQuotedText returns only the new content of a reply: quoted lines
+// ("> ..."), the attribution line introducing them ("On ..., X wrote:"),
+// forwarded or replied-to originals below an "-----Original Message-----"
+// style separator, and the signature are removed. Runs of blank lines left
+// behind are collapsed and the result is trimmed. Line breaks are
+// normalized to "\n".
+func StripQuotedText(body string) string {
+	body, _ = SplitSignature(strings.ReplaceAll(body, "\r\n", "\n"))
+
+	lines := strings.Split(body, "\n")
+	kept := make([]string, 0, len(lines))
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if isOriginalMessageSeparator(trimmed) {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		if isAttributionLine(trimmed) && quoteFollows(lines[i+1:]) {
+			// Attributions are sometimes wrapped: "On Tue, ... Alice\n<a@b> wrote:"
+			if !strings.HasPrefix(trimmed, "On ") && len(kept) > 0 &&
+				strings.HasPrefix(strings.TrimSpace(kept[len(kept)-1]), "On ") {
+				kept = kept[:len(kept)-1]
+			}
+			continue
+		}
+		kept = append(kept, strings.TrimRight(line, " \t"))
+	}
+
+	// Collapse the blank lines left where quote blocks were removed
+	out := kept[:0]
+	for _, line := range kept {
+		if line == "" && (len(out) == 0 || out[len(out)-1] == "") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// isOriginalMessageSeparator reports whether trimmed introduces a quoted
+// original in the style of Outlook or a forwarding client.
+func isOriginalMessageSeparator(trimmed string) bool {
+	switch strings.ToLower(strings.Trim(trimmed, "- ")) {
+	case "original message", "forwarded message", "begin forwarded message:":
+		return true
+	}
+	return false
+}
+
+// isAttributionLine reports whether trimmed looks like a reply attribution
+// such as "On Tue, 5 Mar 2024, Alice <alice@example.com> wrote:".
+func isAttributionLine(trimmed string) bool {
+	return strings.HasSuffix(trimmed, "wrote:") || strings.HasSuffix(trimmed, "writes:")
+}
+
+// quoteFollows reports whether the next non-blank line is quoted, or there
+// is none (the quote was trimmed by the sender).
+func quoteFollows(rest []string) bool {
+	for _, line := range rest {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		return strings.HasPrefix(trimmed, ">")
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package email
+
+import "testing"
+
+func TestSplitSignature(t *testing.T) {
+	tests := []struct {
+		name, body, wantContent, wantSig string
+	}{
+		{"none", "just text\n", "just text\n", ""},
+		{"standard", "Thanks\n-- \nBob\nACME\n", "Thanks", "Bob\nACME\n"},
+		{"crlf", "Thanks\r\n-- \r\nBob\r\n", "Thanks", "Bob\r\n"},
+		{"at start", "-- \nBob", "", "Bob"},
+		{"at end", "Thanks\n-- ", "Thanks", ""},
+		{"first wins", "a\n-- \nb\n-- \nc", "a", "b\n-- \nc"},
+		{"needs trailing space", "a\n--\nb", "a\n--\nb", ""},
+		{"quoted is not a delimiter", "a\n> -- \n> sig\nb", "a\n> -- \n> sig\nb", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, sig := SplitSignature(tt.body)
+			if content != tt.wantContent || sig != tt.wantSig {
+				t.Errorf("SplitSignature(%q) = (%q, %q), want (%q, %q)",
+					tt.body, content, sig, tt.wantContent, tt.wantSig)
+			}
+		})
+	}
+}
+
+func TestStripQuotedText(t *testing.T) {
+	tests := []struct {
+		name, body, want string
+	}{
+		{
+			name: "top posting",
+			body: "Looks good.\r\n\r\nOn Tue, 5 Mar 2024, Alice <alice@example.com> wrote:\r\n> Shall we ship?\r\n>\r\n> Alice\r\n",
+			want: "Looks good.",
+		},
+		{
+			name: "interleaved",
+			body: "Alice wrote:\n> first question\n\nfirst answer\n\n> second question\n\nsecond answer\n",
+			want: "first answer\n\nsecond answer",
+		},
+		{
+			name: "wrapped attribution",
+			body: "On Tue, 5 Mar 2024 at 10:00, Alice Example\n<alice@example.com> wrote:\n> q\nanswer",
+			want: "answer",
+		},
+		{
+			name: "outlook original",
+			body: "See below.\n\n-----Original Message-----\nFrom: Alice\nSubject: q\n\nquestion",
+			want: "See below.",
+		},
+		{
+			name: "signature",
+			body: "Thanks!\n\n-- \nBob",
+			want: "Thanks!",
+		},
+		{
+			name: "unquoted wrote: is content",
+			body: "As Bob wrote:\nthe fix is in.",
+			want: "As Bob wrote:\nthe fix is in.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StripQuotedText(tt.body); got != tt.want {
+				t.Errorf("StripQuotedText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
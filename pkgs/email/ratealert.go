@@ -0,0 +1,198 @@
+package email
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RateAlert raises a Flood when more than Max new emails with the same
+// sender, or the same subject, arrive within Window, to catch mail loops
+// and misbehaving automation early. Arrival is the time the server
+// received the email, so a backlog handled at once does not count as a
+// flood.
+type RateAlert struct {
+	Name   string // Shown in the alert; defaults to a description of the rule
+	By     string // "sender" or "subject"
+	Max    int
+	Window time.Duration
+
+	From    string // Only count emails whose sender contains this (case-insensitive)
+	Subject string // Only count emails whose subject contains this (case-insensitive)
+
+	// Notify also sends the alert through the notifiers of
+	// WatchOptions.Notify that implement AlertSender.
+	Notify bool
+}
+
+// Flood is raised by a RateAlert, once per flood: the same sender or
+// subject raises it again only after its rate fell back to at most Max.
+type Flood struct {
+	Type   string    `json:"type"` // "mail.flood"
+	Rule   string    `json:"rule"`
+	By     string    `json:"by"`
+	Key    string    `json:"key"`    // Sender address or subject, reply markers removed
+	Count  int       `json:"count"`  // Emails within the window when raised
+	Window float64   `json:"window"` // Seconds
+	Since  time.Time `json:"since"`  // Arrival of the first email counted
+	UID    uint32    `json:"uid"`    // The email that crossed the threshold
+}
+
+func (r RateAlert) validate() error {
+	if r.By != "sender" && r.By != "subject" {
+		return fmt.Errorf("rate alert %s: by must be sender or subject, not %q", r.name(), r.By)
+	}
+	if r.Max <= 0 || r.Window <= 0 {
+		return fmt.Errorf("rate alert %s: max and window must be positive", r.name())
+	}
+	return nil
+}
+
+func (r RateAlert) name() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	if r.By == "subject" {
+		return fmt.Sprintf("more than %d emails with one subject in %s", r.Max, r.Window)
+	}
+	return fmt.Sprintf("more than %d emails from one sender in %s", r.Max, r.Window)
+}
+
+// rateAlertPruneEvery is how many emails rateAlerts counts between
+// dropping the senders and subjects not seen within their window.
+const rateAlertPruneEvery = 1000
+
+// rateAlerts counts the new emails of a watch for its RateAlerts.
+type rateAlerts struct {
+	rules   []RateAlert
+	counts  []map[string]*rateCount // By rule, then by key
+	counted map[uint32]time.Time    // Arrival by UID, so retried emails count once
+	seen    int
+}
+
+// rateCount are the arrivals of one sender or subject within the window of
+// a rule.
+type rateCount struct {
+	times   []time.Time // Ascending
+	alerted bool        // A Flood was raised and the rate is still above Max
+}
+
+func newRateAlerts(rules []RateAlert) *rateAlerts {
+	a := &rateAlerts{
+		rules:   rules,
+		counts:  make([]map[string]*rateCount, len(rules)),
+		counted: make(map[uint32]time.Time),
+	}
+	for i := range a.counts {
+		a.counts[i] = make(map[string]*rateCount)
+	}
+	return a
+}
+
+// observe counts the email n, which arrived at t, and returns the rules it
+// makes raise a Flood with the floods. An email already counted, handled
+// again after a failure, is not counted twice.
+func (a *rateAlerts) observe(n EmailNotification, t time.Time) ([]RateAlert, []Flood) {
+	if _, ok := a.counted[n.UID]; ok {
+		return nil, nil
+	}
+	a.counted[n.UID] = t
+
+	var rules []RateAlert
+	var floods []Flood
+	for i, r := range a.rules {
+		if !containsFold(n.From, r.From) || !containsFold(n.Subject, r.Subject) {
+			continue
+		}
+		key := rateKey(r.By, n)
+		if key == "" {
+			continue
+		}
+		c := a.counts[i][key]
+		if c == nil {
+			c = &rateCount{}
+			a.counts[i][key] = c
+		}
+		c.add(t, r.Window)
+		if len(c.times) <= r.Max {
+			c.alerted = false
+			continue
+		}
+		if c.alerted {
+			continue
+		}
+		c.alerted = true
+		rules = append(rules, r)
+		floods = append(floods, Flood{
+			Type:   "mail.flood",
+			Rule:   r.name(),
+			By:     r.By,
+			Key:    key,
+			Count:  len(c.times),
+			Window: r.Window.Seconds(),
+			Since:  c.times[0],
+			UID:    n.UID,
+		})
+	}
+
+	a.seen++
+	if a.seen%rateAlertPruneEvery == 0 {
+		a.prune(t)
+	}
+	return rules, floods
+}
+
+// prune drops the counts with no arrival within their window before now,
+// and the UIDs counted before every window.
+func (a *rateAlerts) prune(now time.Time) {
+	var longest time.Duration
+	for i, r := range a.rules {
+		longest = max(longest, r.Window)
+		for key, c := range a.counts[i] {
+			if !c.times[len(c.times)-1].After(now.Add(-r.Window)) {
+				delete(a.counts[i], key)
+			}
+		}
+	}
+	for uid, t := range a.counted {
+		if !t.After(now.Add(-longest)) {
+			delete(a.counted, uid)
+		}
+	}
+}
+
+// add records an arrival at t and drops those more than window before the
+// latest one.
+func (c *rateCount) add(t time.Time, window time.Duration) {
+	i := sort.Search(len(c.times), func(i int) bool { return c.times[i].After(t) })
+	c.times = append(c.times, time.Time{})
+	copy(c.times[i+1:], c.times[i:])
+	c.times[i] = t
+
+	start := c.times[len(c.times)-1].Add(-window)
+	drop := 0
+	for drop < len(c.times) && !c.times[drop].After(start) {
+		drop++
+	}
+	c.times = c.times[drop:]
+}
+
+// rateKey returns what rule type by counts n under: its sender address or
+// its subject without reply and forward markers, case-folded.
+func rateKey(by string, n EmailNotification) string {
+	if by == "subject" {
+		return strings.ToLower(strings.Join(strings.Fields(reReplyPrefix.ReplaceAllString(n.Subject, "")), " "))
+	}
+	return strings.ToLower(strings.TrimSpace(n.From))
+}
+
+// floodText returns the title and body of the notification of f.
+func floodText(f Flood) (title, body string) {
+	window := time.Duration(f.Window * float64(time.Second))
+	title = fmt.Sprintf("Mail flood: %d emails in %s", f.Count, window)
+	if f.By == "subject" {
+		return title, fmt.Sprintf("Subject %q (%s)", f.Key, f.Rule)
+	}
+	return title, fmt.Sprintf("From %s (%s)", f.Key, f.Rule)
+}
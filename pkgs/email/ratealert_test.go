@@ -0,0 +1,71 @@
+package email
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateAlerts(t *testing.T) {
+	a := newRateAlerts([]RateAlert{
+		{By: "sender", Max: 3, Window: 10 * time.Minute},
+		{Name: "ticket loop", By: "subject", Max: 2, Window: time.Minute, From: "@helpdesk.example"},
+	})
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	uid := uint32(0)
+	observe := func(from, subject string, at time.Duration) []Flood {
+		uid++
+		_, floods := a.observe(EmailNotification{UID: uid, From: from, Subject: subject}, start.Add(at))
+		return floods
+	}
+
+	// Three emails within the window are fine, the fourth raises a flood
+	for i := 0; i < 3; i++ {
+		if f := observe("bot@example.com", "Report", time.Duration(i)*time.Minute); len(f) != 0 {
+			t.Fatalf("email %d: flood %+v", i+1, f)
+		}
+	}
+	f := observe("BOT@example.com", "Report", 5*time.Minute)
+	if len(f) != 1 || f[0].Type != "mail.flood" || f[0].Key != "bot@example.com" || f[0].Count != 4 ||
+		!f[0].Since.Equal(start) || f[0].UID != 4 {
+		t.Fatalf("4th email: floods %+v", f)
+	}
+	// Once per flood
+	if f := observe("bot@example.com", "Report", 6*time.Minute); len(f) != 0 {
+		t.Errorf("5th email: flood raised again: %+v", f)
+	}
+	// An email handled again after a failure counts once
+	if _, f := a.observe(EmailNotification{UID: 5, From: "bot@example.com"}, start.Add(6*time.Minute)); len(f) != 0 {
+		t.Errorf("retried email: %+v", f)
+	}
+	// After the rate dropped, a new burst raises it again
+	if f := observe("bot@example.com", "Report", 30*time.Minute); len(f) != 0 {
+		t.Errorf("after the flood: %+v", f)
+	}
+	for i := 1; i <= 3; i++ {
+		f = observe("bot@example.com", "Report", 30*time.Minute+time.Duration(i)*time.Second)
+	}
+	if len(f) != 1 || f[0].Count != 4 {
+		t.Errorf("second burst: floods %+v", f)
+	}
+
+	// Subjects count without reply markers, and only from the filtered senders
+	observe("a@helpdesk.example", "Ticket #1", 0)
+	observe("b@example.com", "Ticket #1", 0)
+	observe("a@helpdesk.example", "RE: Ticket #1", time.Second)
+	f = observe("b@helpdesk.example", "Re: AW: ticket #1", 2*time.Second)
+	if len(f) != 1 || f[0].Rule != "ticket loop" || f[0].Key != "ticket #1" || f[0].Count != 3 {
+		t.Errorf("subject floods %+v", f)
+	}
+}
+
+func TestRateAlertValidate(t *testing.T) {
+	for _, r := range []RateAlert{
+		{By: "recipient", Max: 1, Window: time.Minute},
+		{By: "sender", Window: time.Minute},
+		{By: "subject", Max: 1},
+	} {
+		if err := r.validate(); err == nil {
+			t.Errorf("validate(%+v) should fail", r)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package email
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"path"
+	"regexp"
+	"strings"
+	"unicode"
+
+	gomessage "github.com/emersion/go-message"
+)
+
+// RedactOptions configures RedactMessage.
+type RedactOptions struct {
+	// Salt is mixed into every hash. Messages redacted with the same salt
+	// map an address or Message-ID to the same token, so threads and
+	// repeated senders stay recognizable; a secret salt keeps the tokens
+	// from being matched against guessed addresses.
+	Salt string
+}
+
+// RedactMessage copies the RFC 5322 message from src to dst with personal
+// data removed while keeping its MIME structure, for sharing problem
+// messages in bug reports:
+//
+//   - addresses are replaced by hashed tokens, and Message-IDs (also in
+//     In-Reply-To and References) by hashed IDs;
+//   - Received headers keep only their date;
+//   - the subject and text parts keep their length and layout, but every
+//     letter becomes "x" and every digit "0"; HTML keeps its tags;
+//   - other leaf parts are replaced by filler of the same decoded size, and
+//     attachment filenames keep only their extension;
+//   - encapsulated messages (message/rfc822) are redacted the same way.
+//
+// Headers describing the MIME structure, dates and the sending software
+// are kept; the values of all other headers are replaced.
+func RedactMessage(dst io.Writer, src io.Reader, opts RedactOptions) error {
+	r := &redactor{salt: opts.Salt}
+	return r.message(dst, src)
+}
+
+type redactor struct {
+	salt string
+}
+
+func (r *redactor) message(dst io.Writer, src io.Reader) error {
+	entity, err := gomessage.Read(src)
+	if !isRecoverableEntityError(err) {
+		return fmt.Errorf("failed to parse message: %w", err)
+	}
+	return r.entity(func(h gomessage.Header) (*gomessage.Writer, error) {
+		return gomessage.CreateWriter(dst, h)
+	}, entity)
+}
+
+// entity writes the redacted e through a writer made by create: either the
+// top-level writer or a part of the enclosing multipart writer.
+func (r *redactor) entity(create func(gomessage.Header) (*gomessage.Writer, error), e *gomessage.Entity) error {
+	w, err := create(r.header(e.Header))
+	if err != nil {
+		return err
+	}
+
+	if mr := e.MultipartReader(); mr != nil {
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if !isRecoverableEntityError(err) {
+				w.Close()
+				return fmt.Errorf("failed to read part: %w", err)
+			}
+			if err := r.entity(w.CreatePart, part); err != nil {
+				w.Close()
+				return err
+			}
+		}
+		return w.Close()
+	}
+
+	mediaType, _, _ := e.Header.ContentType()
+	switch {
+	case mediaType == "message/rfc822" || mediaType == "message/global":
+		err = r.message(w, e.Body)
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "":
+		var body []byte
+		if body, err = io.ReadAll(e.Body); err == nil {
+			if mediaType == "text/html" {
+				_, err = io.WriteString(w, maskHTML(string(body)))
+			} else {
+				_, err = io.WriteString(w, maskText(string(body)))
+			}
+		}
+	default:
+		var n int64
+		if n, err = io.Copy(io.Discard, e.Body); err == nil {
+			_, err = w.Write(bytes.Repeat([]byte("x"), int(n)))
+		}
+	}
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// redactKeptHeaders are copied unchanged: they describe the MIME structure,
+// the dates and the sending software, which bug reports usually need.
+var redactKeptHeaders = map[string]bool{
+	"date":                      true,
+	"mime-version":              true,
+	"content-transfer-encoding": true,
+	"content-language":          true,
+	"x-mailer":                  true,
+	"user-agent":                true,
+	"importance":                true,
+	"priority":                  true,
+	"x-priority":                true,
+	"x-msmail-priority":         true,
+	"auto-submitted":            true,
+	"precedence":                true,
+}
+
+var redactAddressHeaders = map[string]bool{
+	"from": true, "sender": true, "reply-to": true,
+	"to": true, "cc": true, "bcc": true,
+	"resent-from": true, "resent-sender": true, "resent-to": true, "resent-cc": true, "resent-bcc": true,
+	"return-path": true, "delivered-to": true, "x-original-to": true, "envelope-to": true,
+	"errors-to": true, "disposition-notification-to": true, "return-receipt-to": true,
+}
+
+var redactIDHeaders = map[string]bool{
+	"message-id": true, "in-reply-to": true, "references": true,
+	"resent-message-id": true, "content-id": true,
+}
+
+// header returns a redacted copy of h with the fields in their original
+// order.
+func (r *redactor) header(h gomessage.Header) gomessage.Header {
+	type field struct{ k, v string }
+	var fields []field
+	for fs := h.Fields(); fs.Next(); {
+		// Key returns the canonical form; take the original from the raw field
+		key := fs.Key()
+		if raw, err := fs.Raw(); err == nil {
+			if i := bytes.IndexByte(raw, ':'); i > 0 {
+				key = strings.TrimSpace(string(raw[:i]))
+			}
+		}
+		fields = append(fields, field{key, r.headerValue(strings.ToLower(key), fs.Value())})
+	}
+
+	var out gomessage.Header
+	// AddRaw inserts at the top, so add the fields last to first. Unlike
+	// Add, it keeps the original capitalization of the keys.
+	for i := len(fields) - 1; i >= 0; i-- {
+		out.AddRaw([]byte(fields[i].k + ": " + fields[i].v + "\r\n"))
+	}
+	return out
+}
+
+func (r *redactor) headerValue(key, value string) string {
+	switch {
+	case redactKeptHeaders[key]:
+		return value
+	case key == "content-type" || key == "content-disposition":
+		return redactParams(value)
+	case key == "subject":
+		return maskText(decodeHeaderValue(value))
+	case key == "received" || key == "x-received":
+		// Keep the hop and its timestamp, which delivery bugs often need
+		if i := strings.LastIndex(value, ";"); i >= 0 {
+			return "from redacted by redacted" + value[i:]
+		}
+		return "redacted"
+	case redactAddressHeaders[key]:
+		return r.addresses(value)
+	case redactIDHeaders[key]:
+		return r.msgIDs(value)
+	default:
+		return "redacted"
+	}
+}
+
+// redactParams masks the filename parameters of a Content-Type or
+// Content-Disposition value, keeping the extension.
+func redactParams(value string) string {
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return value
+	}
+	for _, k := range []string{"name", "filename"} {
+		if name, ok := params[k]; ok {
+			name = decodeHeaderValue(name)
+			ext := path.Ext(name)
+			params[k] = maskText(strings.TrimSuffix(name, ext)) + ext
+		}
+	}
+	if formatted := mime.FormatMediaType(mediaType, params); formatted != "" {
+		return formatted
+	}
+	return mediaType
+}
+
+func (r *redactor) addresses(value string) string {
+	if strings.TrimSpace(value) == "<>" {
+		return value // Null return path
+	}
+	parser := mail.AddressParser{WordDecoder: wordDecoder}
+	list, err := parser.ParseList(value)
+	if err != nil {
+		return "redacted"
+	}
+	out := make([]string, len(list))
+	for i, a := range list {
+		token := mail.Address{Address: r.address(a.Address)}
+		if a.Name != "" {
+			token.Name = "Name " + r.hash(a.Name)[:6]
+		}
+		out[i] = token.String()
+	}
+	return strings.Join(out, ", ")
+}
+
+// address hashes the whole address into the local part and the domain
+// separately, so addresses at the same domain share a domain token.
+func (r *redactor) address(addr string) string {
+	addr = strings.ToLower(addr)
+	domain := ""
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		domain = addr[i+1:]
+	}
+	return "u" + r.hash(addr)[:10] + "@d" + r.hash(domain)[:8] + ".invalid"
+}
+
+var msgIDPattern = regexp.MustCompile(`<[^<>]*>`)
+
+func (r *redactor) msgIDs(value string) string {
+	ids := msgIDPattern.FindAllString(value, -1)
+	if len(ids) == 0 {
+		return "redacted"
+	}
+	for i, id := range ids {
+		ids[i] = "<" + r.hash(strings.Trim(id, "<>"))[:16] + "@redacted.invalid>"
+	}
+	return strings.Join(ids, " ")
+}
+
+func (r *redactor) hash(s string) string {
+	sum := sha256.Sum256([]byte(r.salt + "\x00" + s))
+	return hex.EncodeToString(sum[:])
+}
+
+// maskText replaces letters with "x" and digits with "0", keeping spaces,
+// line breaks and ASCII punctuation so the text keeps its layout. Other
+// characters become "x", so the result is plain ASCII in any charset.
+func maskText(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, c := range s {
+		switch {
+		case unicode.IsDigit(c):
+			b.WriteByte('0')
+		case c < unicode.MaxASCII && !unicode.IsLetter(c):
+			b.WriteRune(c)
+		default:
+			b.WriteByte('x')
+		}
+	}
+	return b.String()
+}
+
+// maskHTML masks the text of an HTML document and its quoted attribute
+// values, but not the tag and attribute names.
+func maskHTML(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for len(s) > 0 {
+		open := strings.IndexByte(s, '<')
+		if open < 0 {
+			b.WriteString(maskText(s))
+			break
+		}
+		b.WriteString(maskText(s[:open]))
+		s = s[open:]
+
+		var quote byte
+		end := len(s)
+		for i := 1; i < len(s); i++ {
+			if c := s[i]; quote != 0 {
+				if c == quote {
+					quote = 0
+				}
+			} else if c == '"' || c == '\'' {
+				quote = c
+			} else if c == '>' {
+				end = i + 1
+				break
+			}
+		}
+		b.WriteString(maskTag(s[:end]))
+		s = s[end:]
+	}
+	return b.String()
+}
+
+// maskTag masks the quoted attribute values in a tag.
+func maskTag(tag string) string {
+	var b strings.Builder
+	var quote byte
+	start := 0
+	for i := 0; i < len(tag); i++ {
+		c := tag[i]
+		switch {
+		case quote != 0 && c == quote:
+			b.WriteString(maskText(tag[start:i]))
+			b.WriteByte(c)
+			quote = 0
+		case quote != 0:
+		case c == '"' || c == '\'':
+			b.WriteByte(c)
+			quote, start = c, i+1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if quote != 0 {
+		b.WriteString(maskText(tag[start:]))
+	}
+	return b.String()
+}
@@ -0,0 +1,122 @@
+package email
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	gomessage "github.com/emersion/go-message"
+)
+
+const redactSample = "Received: from mx.customer.example (mx.customer.example [203.0.113.7])\r\n" +
+	"\tby mail.example.net; Tue, 2 Jan 2024 10:00:00 +0000\r\n" +
+	"From: Alice Customer <alice@customer.example>\r\n" +
+	"To: support@example.net, Bob <bob@customer.example>\r\n" +
+	"Subject: =?utf-8?q?Order_4711_f=C3=BCr_Alice?=\r\n" +
+	"Date: Tue, 2 Jan 2024 10:00:00 +0000\r\n" +
+	"Message-ID: <abc123@customer.example>\r\n" +
+	"References: <first@customer.example> <abc123@customer.example>\r\n" +
+	"X-Customer-Ref: ACME-4711\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Hi, my card is 4111 1111.\r\n" +
+	"Call me =E2=80=93 Alice\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p class=\"note\">Secret plan</p><a href=\"https://alice.example/\">link</a>\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=\"alice-contract.pdf\"\r\n" +
+	"Content-Disposition: attachment; filename=\"alice-contract.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQKc2VjcmV0\r\n" +
+	"--outer--\r\n"
+
+func redact(t *testing.T, salt string) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := RedactMessage(&out, strings.NewReader(redactSample), RedactOptions{Salt: salt}); err != nil {
+		t.Fatalf("RedactMessage() error: %v", err)
+	}
+	return out.String()
+}
+
+func TestRedactMessage(t *testing.T) {
+	got := redact(t, "s1")
+
+	for _, secret := range []string{"alice", "Alice", "bob", "customer", "203.0.113.7", "4711", "4111", "Secret", "ACME", "abc123", "c2VjcmV0"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("redacted message still contains %q:\n%s", secret, got)
+		}
+	}
+	for _, kept := range []string{
+		"Date: Tue, 2 Jan 2024 10:00:00 +0000",
+		"; Tue, 2 Jan 2024 10:00:00 +0000", // Received timestamp
+		"X-Customer-Ref: redacted",
+		"MIME-Version: 1.0",
+		"--outer\r\n",
+		`<p class="xxxx">xxxxxx xxxx</p>`,
+		"xx, xx xxxx xx 0000 0000.",
+		`filename=xxxxx-xxxxxxxx.pdf`,
+	} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("redacted message lacks %q:\n%s", kept, got)
+		}
+	}
+
+	// The result is still a well-formed message with the same structure
+	// and attachment size.
+	entity, err := gomessage.Read(strings.NewReader(got))
+	if err != nil {
+		t.Fatalf("redacted message does not parse: %v", err)
+	}
+	var types []string
+	var pdfSize int
+	entity.Walk(func(_ []int, part *gomessage.Entity, err error) error {
+		mediaType, _, _ := part.Header.ContentType()
+		types = append(types, mediaType)
+		if mediaType == "application/pdf" {
+			b, _ := io.ReadAll(part.Body)
+			pdfSize = len(b)
+		}
+		return nil
+	})
+	if want := "multipart/mixed text/plain text/html application/pdf"; strings.Join(types, " ") != want {
+		t.Errorf("parts = %v, want %s", types, want)
+	}
+	if pdfSize != 15 {
+		t.Errorf("attachment size = %d, want 15", pdfSize)
+	}
+
+	// Tokens are stable for a salt and differ between salts
+	if again := redact(t, "s1"); again != got {
+		t.Error("same salt gave different output")
+	}
+	if other := redact(t, "s2"); other == got {
+		t.Error("different salts gave the same output")
+	}
+}
+
+func TestRedactMessage_ThreadIDs(t *testing.T) {
+	got := redact(t, "")
+	var id string
+	for _, line := range strings.Split(got, "\r\n") {
+		if strings.HasPrefix(line, "Message-ID: ") {
+			id = line[strings.Index(line, "<"):]
+		}
+	}
+	if id == "" {
+		t.Fatalf("no Message-ID in:\n%s", got)
+	}
+	// The message's own ID reappears as the last reference
+	if !strings.Contains(got, " "+id+"\r\n") {
+		t.Errorf("References does not end with the redacted Message-ID %s:\n%s", id, got)
+	}
+}
@@ -0,0 +1,223 @@
+package email
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Default backoff of a RetryQueue.
+const (
+	defaultRetryBaseDelay = time.Minute
+	defaultRetryMaxDelay  = 6 * time.Hour
+)
+
+// RetryQueue records the emails a watch failed to handle, with the reason
+// and when to try each again. Watch retries them on its schedule with
+// exponential backoff per email, whether or not they are still unseen, and
+// leaves them out of the unseen emails it handles meanwhile. So after a
+// handler outage the failed emails are handled once it is fixed, without
+// reprocessing them by hand, and a broken email does not run the handler
+// on every wake-up. The queue is a small JSON file per folder, like
+// WatchCheckpoint; the emails of a renumbered folder are dropped from it.
+type RetryQueue struct {
+	Path string
+
+	// BaseDelay is the wait before the first retry (default 1 minute),
+	// doubled after each further failure up to MaxDelay (default 6 hours).
+	// After MaxAttempts failures (0 means no limit) the email is given up:
+	// it stays in the queue, and out of the unseen emails handled, until
+	// Reset.
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int
+}
+
+// RetryEntry is an email in a RetryQueue.
+type RetryEntry struct {
+	UID      uint32    `json:"uid"`
+	Reason   string    `json:"reason"` // Error of the last attempt
+	Attempts int       `json:"attempts"`
+	First    time.Time `json:"first_failure"`
+	Next     time.Time `json:"next_attempt"`
+	GaveUp   bool      `json:"gave_up,omitempty"` // MaxAttempts reached; not retried until Reset
+}
+
+// retryState is the content of a retry queue file.
+type retryState struct {
+	UIDValidity uint32       `json:"uidvalidity"`
+	Entries     []RetryEntry `json:"entries"` // By UID
+}
+
+// DefaultRetryQueue returns the retry queue of an account's folder at the
+// default path (~/.emx-mail/watch/<account>/retry/<folder>.json).
+func DefaultRetryQueue(account, folder string) (*RetryQueue, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return &RetryQueue{
+		Path: filepath.Join(home, ".emx-mail", "watch", url.PathEscape(account), "retry", url.PathEscape(folder)+".json"),
+	}, nil
+}
+
+// Entries returns the emails in the queue and the UIDVALIDITY of the
+// folder their UIDs belong to.
+func (q *RetryQueue) Entries() (uidValidity uint32, entries []RetryEntry, err error) {
+	s, err := q.load()
+	return s.UIDValidity, s.Entries, err
+}
+
+// Reset makes the emails uids, or every email if none are given, due for
+// a retry now with their attempts counted from zero, including those given
+// up. It returns how many emails it reset.
+func (q *RetryQueue) Reset(uids ...uint32) (int, error) {
+	s, err := q.load()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := range s.Entries {
+		e := &s.Entries[i]
+		if len(uids) > 0 && !containsUID(uids, e.UID) {
+			continue
+		}
+		e.Attempts, e.Next, e.GaveUp = 0, time.Time{}, false
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, q.save(s)
+}
+
+// queued returns the emails in the queue by UID. Entries recorded under
+// another UIDVALIDITY are stale and left out.
+func (q *RetryQueue) queued(uidValidity uint32) (map[uint32]RetryEntry, error) {
+	s, err := q.load()
+	if err != nil {
+		return nil, err
+	}
+	queued := make(map[uint32]RetryEntry)
+	if s.UIDValidity != uidValidity {
+		return queued, nil
+	}
+	for _, e := range s.Entries {
+		queued[e.UID] = e
+	}
+	return queued, nil
+}
+
+// failed records a failed attempt at uid at now, and returns its entry with
+// the time of the next attempt.
+func (q *RetryQueue) failed(uidValidity, uid uint32, reason string, now time.Time) (RetryEntry, error) {
+	s, err := q.load()
+	if err != nil {
+		return RetryEntry{}, err
+	}
+	if s.UIDValidity != uidValidity {
+		s = retryState{UIDValidity: uidValidity}
+	}
+	i := sort.Search(len(s.Entries), func(i int) bool { return s.Entries[i].UID >= uid })
+	if i == len(s.Entries) || s.Entries[i].UID != uid {
+		s.Entries = append(s.Entries, RetryEntry{})
+		copy(s.Entries[i+1:], s.Entries[i:])
+		s.Entries[i] = RetryEntry{UID: uid, First: now.UTC()}
+	}
+	e := &s.Entries[i]
+	e.Reason = reason
+	e.Attempts++
+	e.Next = now.Add(q.backoff(e.Attempts)).UTC()
+	e.GaveUp = q.MaxAttempts > 0 && e.Attempts >= q.MaxAttempts
+	return *e, q.save(s)
+}
+
+// remove drops uid from the queue, after it was handled or is gone.
+func (q *RetryQueue) remove(uidValidity, uid uint32) error {
+	s, err := q.load()
+	if err != nil || s.UIDValidity != uidValidity {
+		return err
+	}
+	for i, e := range s.Entries {
+		if e.UID == uid {
+			s.Entries = append(s.Entries[:i], s.Entries[i+1:]...)
+			return q.save(s)
+		}
+	}
+	return nil
+}
+
+// backoff returns the wait after the attempts-th failure.
+func (q *RetryQueue) backoff(attempts int) time.Duration {
+	delay, limit := q.BaseDelay, q.MaxDelay
+	if delay <= 0 {
+		delay = defaultRetryBaseDelay
+	}
+	if limit <= 0 {
+		limit = defaultRetryMaxDelay
+	}
+	for i := 1; i < attempts && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
+
+func (q *RetryQueue) load() (retryState, error) {
+	data, err := os.ReadFile(q.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return retryState{}, nil
+	}
+	if err != nil {
+		return retryState{}, fmt.Errorf("failed to read retry queue: %w", err)
+	}
+	var s retryState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return retryState{}, fmt.Errorf("failed to parse retry queue %s: %w", q.Path, err)
+	}
+	return s, nil
+}
+
+// save writes s, or removes the file once the queue is empty.
+func (q *RetryQueue) save(s retryState) error {
+	if len(s.Entries) == 0 {
+		if err := os.Remove(q.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(q.Path), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.Path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), q.Path)
+}
+
+func containsUID(uids []uint32, uid uint32) bool {
+	for _, u := range uids {
+		if u == uid {
+			return true
+		}
+	}
+	return false
+}
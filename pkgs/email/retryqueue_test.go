@@ -0,0 +1,70 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetryQueue(t *testing.T) {
+	q := &RetryQueue{Path: filepath.Join(t.TempDir(), "retry", "INBOX.json"), MaxAttempts: 3}
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	e, err := q.failed(7, 42, "handler exited with status 1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Attempts != 1 || !e.Next.Equal(now.Add(time.Minute)) || e.GaveUp {
+		t.Errorf("first failure = %+v, want a retry in 1m", e)
+	}
+	// The wait doubles with each failure
+	if e, _ = q.failed(7, 42, "still failing", now); !e.Next.Equal(now.Add(2*time.Minute)) || e.Reason != "still failing" {
+		t.Errorf("second failure = %+v, want a retry in 2m", e)
+	}
+	if _, err := q.failed(7, 12, "other", now); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := q.queued(7)
+	if err != nil || len(queued) != 2 || queued[42].Attempts != 2 {
+		t.Fatalf("queued() = %+v, %v", queued, err)
+	}
+	if _, entries, _ := q.Entries(); entries[0].UID != 12 || entries[1].UID != 42 {
+		t.Errorf("entries = %+v, want them by UID", entries)
+	}
+	// Entries of a renumbered folder are stale
+	if queued, _ := q.queued(8); len(queued) != 0 {
+		t.Errorf("queued() under another UIDVALIDITY = %+v", queued)
+	}
+
+	if e, _ = q.failed(7, 42, "broken", now); !e.GaveUp {
+		t.Errorf("third failure = %+v, want given up", e)
+	}
+	if n, err := q.Reset(42); err != nil || n != 1 {
+		t.Fatalf("Reset() = %d, %v", n, err)
+	}
+	if queued, _ = q.queued(7); queued[42].GaveUp || queued[42].Attempts != 0 || !queued[42].Next.IsZero() {
+		t.Errorf("reset entry = %+v", queued[42])
+	}
+
+	// The file is removed with the last entry
+	if err := q.remove(7, 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.remove(7, 12); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(q.Path); !os.IsNotExist(err) {
+		t.Errorf("queue file left behind: %v", err)
+	}
+}
+
+func TestRetryQueueBackoff(t *testing.T) {
+	q := &RetryQueue{BaseDelay: time.Minute, MaxDelay: 10 * time.Minute}
+	for attempts, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 5: 10 * time.Minute, 100: 10 * time.Minute} {
+		if got := q.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
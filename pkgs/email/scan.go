@@ -0,0 +1,111 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Scanner checks attachment content for malware.
+type Scanner interface {
+	Scan(ctx context.Context, name string, data []byte) (ScanResult, error)
+}
+
+// ScanResult is the verdict for one attachment.
+type ScanResult struct {
+	Attachment string // Attachment filename
+	Infected   bool
+	Detail     string // Scanner output, e.g. the signature name
+}
+
+// ScanOptions configures attachment scanning and what happens to an email
+// with an infected attachment.
+type ScanOptions struct {
+	Scanner Scanner
+
+	Reject           bool   // Watch: fail the email instead of running the handler
+	QuarantineFolder string // Move the email to this folder (or logical folder)
+	Keyword          string // Add this IMAP keyword, e.g. "$Infected"
+}
+
+// ScanAttachments scans every attachment with data and returns the
+// infected ones. A scanner failure is an error: nothing is assumed clean.
+func ScanAttachments(ctx context.Context, s Scanner, atts []Attachment) ([]ScanResult, error) {
+	var infected []ScanResult
+	for _, att := range atts {
+		if att.Data == nil {
+			continue
+		}
+		res, err := s.Scan(ctx, att.Filename, att.Data)
+		if err != nil {
+			return nil, fmt.Errorf("scanning %s: %w", att.Filename, err)
+		}
+		if res.Infected {
+			infected = append(infected, res)
+		}
+	}
+	return infected, nil
+}
+
+// CommandScanner scans by running Command with the path of a temporary
+// copy of the attachment as its last argument. Command is split into
+// arguments like a handler run with HandlerShellNone. Exit status 0 means
+// clean and 1 infected, as with clamscan and clamdscan; anything else is
+// an error.
+type CommandScanner struct {
+	Command string // e.g. "clamdscan --no-summary --fdpass"
+}
+
+// Scan implements Scanner.
+func (s *CommandScanner) Scan(ctx context.Context, name string, data []byte) (ScanResult, error) {
+	res := ScanResult{Attachment: name}
+	args, err := splitHandlerArgs(s.Command)
+	if err != nil {
+		return res, err
+	}
+	if len(args) == 0 {
+		return res, fmt.Errorf("empty scanner command")
+	}
+
+	// Keep the extension: some scanners use it to pick a file type
+	ext := filepath.Ext(strings.NewReplacer("/", "_", "\\", "_").Replace(name))
+	f, err := os.CreateTemp("", "emx-scan-*"+ext)
+	if err != nil {
+		return res, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return res, err
+	}
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], append(args[1:], f.Name())...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = cmd.Run()
+	// Report the attachment name rather than the temporary file
+	res.Detail = strings.TrimSpace(strings.ReplaceAll(out.String(), f.Name(), name))
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return res, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		res.Infected = true
+		return res, nil
+	default:
+		if res.Detail != "" {
+			return res, fmt.Errorf("%w: %s", err, res.Detail)
+		}
+		return res, err
+	}
+}
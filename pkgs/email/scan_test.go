@@ -0,0 +1,79 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type fakeScanner struct {
+	infected string // Data containing this is infected
+	err      error
+	scanned  []string
+}
+
+func (s *fakeScanner) Scan(_ context.Context, name string, data []byte) (ScanResult, error) {
+	s.scanned = append(s.scanned, name)
+	if s.err != nil {
+		return ScanResult{}, s.err
+	}
+	res := ScanResult{Attachment: name}
+	if strings.Contains(string(data), s.infected) {
+		res.Infected, res.Detail = true, "Eicar-Signature FOUND"
+	}
+	return res, nil
+}
+
+func TestScanAttachments(t *testing.T) {
+	atts := []Attachment{
+		{Filename: "report.pdf", Data: []byte("%PDF-1.4")},
+		{Filename: "invoice.exe", Data: []byte("X5O!P%@AP EICAR")},
+		{Filename: "remote.bin"}, // not downloaded
+	}
+
+	s := &fakeScanner{infected: "EICAR"}
+	infected, err := ScanAttachments(context.Background(), s, atts)
+	if err != nil {
+		t.Fatalf("ScanAttachments() error: %v", err)
+	}
+	if len(s.scanned) != 2 {
+		t.Errorf("scanned %v, want the two attachments with data", s.scanned)
+	}
+	if len(infected) != 1 || infected[0].Attachment != "invoice.exe" {
+		t.Errorf("infected = %+v, want invoice.exe only", infected)
+	}
+
+	// A scanner failure must not be treated as clean
+	s = &fakeScanner{err: errors.New("clamd not running")}
+	if _, err := ScanAttachments(context.Background(), s, atts); err == nil {
+		t.Error("ScanAttachments() with failing scanner: want error")
+	}
+}
+
+func TestCommandScanner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	s := &CommandScanner{Command: `sh -c 'if grep -q EICAR "$1"; then echo "$1: Eicar FOUND"; exit 1; fi' sh`}
+	ctx := context.Background()
+
+	res, err := s.Scan(ctx, "clean.txt", []byte("hello"))
+	if err != nil || res.Infected {
+		t.Errorf("Scan(clean) = (%+v, %v), want clean", res, err)
+	}
+
+	res, err = s.Scan(ctx, "bad.exe", []byte("EICAR"))
+	if err != nil {
+		t.Fatalf("Scan(infected) error: %v", err)
+	}
+	if !res.Infected || res.Detail != "bad.exe: Eicar FOUND" {
+		t.Errorf("Scan(infected) = %+v, want infected with detail naming bad.exe", res)
+	}
+
+	s = &CommandScanner{Command: "sh -c 'echo cannot connect; exit 2' sh"}
+	if _, err := s.Scan(ctx, "a.txt", []byte("x")); err == nil || !strings.Contains(err.Error(), "cannot connect") {
+		t.Errorf("Scan() with exit 2 = %v, want error with scanner output", err)
+	}
+}
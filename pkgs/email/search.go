@@ -0,0 +1,15 @@
+package email
+
+import "time"
+
+// SearchFilter selects messages of a folder for a server-side search. The
+// zero filter selects every message; set fields must all match.
+type SearchFilter struct {
+	From     string    // Sender contains this, case-insensitively
+	Subject  string    // Subject contains this, case-insensitively
+	Type     string    // Content-Type header contains this, e.g. "delivery-status"
+	Since    time.Time // Received on or after this day
+	Before   time.Time // Received before this day
+	Flags    []string  // Has every one of these flags, e.g. `\Seen`
+	NotFlags []string  // Has none of these flags
+}
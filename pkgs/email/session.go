@@ -0,0 +1,112 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// SMTPSession sends a run of messages, such as a patch series or a mail
+// merge, over one authenticated SMTP connection instead of dialing for each
+// message. Every transaction after the first on a connection starts with
+// RSET, so a message the server refused cannot leave it mid-transaction.
+type SMTPSession struct {
+	// Retries is how often a message is retried after a temporary failure
+	// (4xx reply, dropped connection), reconnecting first. RetryDelay is the
+	// wait before the first retry, doubled after each.
+	Retries    int
+	RetryDelay time.Duration
+
+	// MaxPerConnection, if positive, opens a new connection after that many
+	// messages, for servers capping the messages of a connection.
+	MaxPerConnection int
+
+	client *SMTPClient
+	count  int // Transactions on the current connection
+}
+
+// NewSession returns a session sending through c. It connects with the
+// first message; Close it when done.
+func (c *SMTPClient) NewSession() *SMTPSession {
+	return &SMTPSession{client: c}
+}
+
+// Send composes opts with the client's defaults and sends the message.
+func (s *SMTPSession) Send(ctx context.Context, opts SendOptions) (*ComposedMessage, error) {
+	m, err := s.client.Compose(opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.SendComposed(ctx, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SendComposed sends a message built by Compose, retrying temporary
+// failures, and returns the number of attempts made. Attachments Compose
+// left out for their size are uploaded once, before the first attempt.
+func (s *SMTPSession) SendComposed(ctx context.Context, m *ComposedMessage) (attempts int, err error) {
+	if err := s.client.Upload(m); err != nil {
+		return 0, err
+	}
+	delay := s.RetryDelay
+	for {
+		attempts++
+		err = s.prepare()
+		if err == nil {
+			s.count++
+			if err = s.client.client.SendMail(m.From, m.Recipients, bytes.NewReader(m.Data)); err != nil {
+				err = fmt.Errorf("failed to send email: %w", err)
+			}
+		}
+		if err == nil {
+			return attempts, nil
+		}
+
+		if !IsTemporarySMTPError(err) {
+			// The next transaction starts with RSET; a connection that
+			// cannot reset is replaced then
+			return attempts, err
+		}
+		// The connection may be dead or mid-transaction: start afresh
+		s.Close()
+		if attempts > s.Retries {
+			return attempts, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempts, err
+		case <-clockOrSystem(s.client.config.Clock).After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// prepare readies the connection for a transaction: it connects if needed,
+// reconnects after MaxPerConnection messages, and otherwise resets the
+// previous transaction.
+func (s *SMTPSession) prepare() error {
+	c := s.client
+	if c.client != nil && s.MaxPerConnection > 0 && s.count >= s.MaxPerConnection {
+		s.Close()
+	}
+	if c.client != nil && s.count > 0 {
+		if err := c.client.Reset(); err != nil {
+			s.Close()
+		}
+	}
+	if c.client == nil {
+		s.count = 0
+		return c.Connect()
+	}
+	return nil
+}
+
+// Close closes the session's connection.
+func (s *SMTPSession) Close() error {
+	s.count = 0
+	return s.client.Close()
+}
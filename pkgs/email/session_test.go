@@ -0,0 +1,47 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/emx-mail/cli/pkgs/testutil"
+)
+
+func TestSMTPSession(t *testing.T) {
+	be, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
+
+	client := NewSMTPClient(SMTPConfig{
+		Host: host, Port: port,
+		Username: testutil.Username, Password: testutil.Password,
+	})
+	s := client.NewSession()
+	s.MaxPerConnection = 2
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := s.Send(context.Background(), SendOptions{
+			From:     Address{Email: "sender@example.com"},
+			To:       []Address{{Email: fmt.Sprintf("rcpt%d@example.com", i)}},
+			Subject:  fmt.Sprintf("[PATCH %d/3] part", i+1),
+			TextBody: "Hello",
+		})
+		if err != nil {
+			t.Fatalf("message %d: %v", i+1, err)
+		}
+	}
+
+	msgs := be.Messages()
+	if len(msgs) != 3 {
+		t.Fatalf("delivered %d messages, want 3", len(msgs))
+	}
+	for i, m := range msgs {
+		if want := fmt.Sprintf("rcpt%d@example.com", i); len(m.To) != 1 || m.To[0] != want {
+			t.Errorf("message %d To = %v, want %s", i+1, m.To, want)
+		}
+	}
+	if n := be.Conns(); n != 2 {
+		t.Errorf("connections = %d, want 2 (two messages per connection)", n)
+	}
+}
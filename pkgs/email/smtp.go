@@ -1,17 +1,23 @@
 package email
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"strings"
 	"time"
 
+	gomessage "github.com/emersion/go-message"
 	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
 	"github.com/emersion/go-sasl"
 	"github.com/emersion/go-smtp"
 )
@@ -30,6 +36,38 @@ type SMTPConfig struct {
 	Password string
 	SSL      bool
 	StartTLS bool
+
+	// AuthzID, if set, is the SASL authorization identity to act as, e.g.
+	// a shared mailbox Username is a delegate of.
+	AuthzID string
+
+	// TLSConfig is optional; if nil a default config is used. ServerName
+	// defaults to Host.
+	TLSConfig *tls.Config
+
+	// Dial, if set, opens the connection to the server instead of a plain
+	// TCP dial, as IMAPConfig.Dial.
+	Dial func(network, addr string) (net.Conn, error)
+
+	// Defaults applied to every message sent through the client
+	AlwaysCc  []Address // Added to Cc unless already a recipient
+	AlwaysBcc []Address // Added to Bcc unless already a recipient (e.g. an archive mailbox)
+	ReplyTo   []Address // Reply-To when SendOptions.ReplyTo is empty
+	XMailer   string    // X-Mailer header value; omitted when empty
+	Sender    Address   // Sender header when it differs from From, for sending on behalf of From
+
+	// MaxMessageSize is the largest message, in bytes, Compose produces; 0
+	// means no limit. Larger messages fail with ErrMessageTooLarge unless
+	// Uploader is set, in which case attachments are replaced by download
+	// links in the body and uploaded when the message is sent.
+	MaxMessageSize int64
+	Uploader       Uploader
+
+	// Clock and Rand, if set, replace the system clock and crypto/rand as
+	// the source of the Date and Message-ID of composed messages and of
+	// retry delays, for deterministic tests. MIME boundaries stay random.
+	Clock Clock
+	Rand  io.Reader
 }
 
 // NewSMTPClient creates a new SMTP client
@@ -48,7 +86,7 @@ func (c *SMTPClient) Connect() error {
 
 	var dialFn func(addr string, tlsConfig *tls.Config) (*smtp.Client, error)
 
-	tlsCfg := &tls.Config{ServerName: c.config.Host}
+	tlsCfg := clientTLSConfig(c.config.TLSConfig, c.config.Host)
 
 	if c.config.SSL {
 		dialFn = smtp.DialTLS
@@ -61,6 +99,9 @@ func (c *SMTPClient) Connect() error {
 	}
 
 	addr := fmt.Sprintf("%s:%d", c.config.Host, c.config.Port)
+	if c.config.Dial != nil {
+		dialFn = c.dialWith
+	}
 	client, err := dialFn(addr, tlsCfg)
 	if err != nil {
 		return fmt.Errorf("failed to connect to SMTP server: %w", err)
@@ -68,7 +109,7 @@ func (c *SMTPClient) Connect() error {
 
 	// Authenticate
 	if c.config.Password != "" {
-		auth := sasl.NewPlainClient("", c.config.Username, c.config.Password)
+		auth := sasl.NewPlainClient(c.config.AuthzID, c.config.Username, c.config.Password)
 		if err := client.Auth(auth); err != nil {
 			client.Close()
 			return fmt.Errorf("SMTP authentication failed: %w", err)
@@ -79,19 +120,84 @@ func (c *SMTPClient) Connect() error {
 	return nil
 }
 
+// dialWith connects to addr over a connection from SMTPConfig.Dial, with
+// TLS, STARTTLS or neither as configured.
+func (c *SMTPClient) dialWith(addr string, tlsConfig *tls.Config) (*smtp.Client, error) {
+	conn, err := c.config.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c.config.SSL:
+		return smtp.NewClient(tls.Client(conn, tlsConfig)), nil
+	case c.config.StartTLS:
+		client, err := smtp.NewClientStartTLS(conn, tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return client, nil
+	default:
+		return smtp.NewClient(conn), nil
+	}
+}
+
 // Send sends an email
 func (c *SMTPClient) Send(opts SendOptions) error {
-	if c.client == nil {
-		if err := c.Connect(); err != nil {
-			return err
-		}
-		defer c.Close()
+	m, err := c.Compose(opts)
+	if err != nil {
+		return err
+	}
+	return c.SendComposed(m)
+}
+
+// ComposedMessage is a message built by Compose, ready for transmission.
+type ComposedMessage struct {
+	From       string   // Envelope sender
+	Recipients []string // Envelope recipients: To, Cc and Bcc
+	Data       []byte   // RFC 5322 message as it will be transmitted
+
+	// pending is the message to rebuild once its attachments are
+	// uploaded, see SMTPClient.Upload; nil if there is nothing to upload.
+	pending *pendingUpload
+}
+
+// pendingUpload is a message whose attachments go to SMTPConfig.Uploader,
+// with the Date and Message-ID it was composed with.
+type pendingUpload struct {
+	opts      SendOptions
+	date      time.Time
+	messageID string
+}
+
+// Compose applies the client's defaults to opts and builds the message
+// exactly as Send would transmit it, without connecting. Use it with
+// SendComposed to inspect a message before sending it. If the message
+// exceeds MaxMessageSize, its attachments are listed as links to be
+// uploaded (see Uploads), but nothing is uploaded before sending.
+func (c *SMTPClient) Compose(opts SendOptions) (*ComposedMessage, error) {
+	opts = c.ApplyDefaults(opts)
+	if err := checkEnvelope(opts); err != nil {
+		return nil, err
 	}
 
 	// Build email message
-	msg, err := c.buildMessage(opts)
+	date := clockOrSystem(c.config.Clock).Now()
+	messageID := c.generateMessageID(opts.From.Email)
+	msg, err := c.buildMessage(opts, date, messageID)
 	if err != nil {
-		return fmt.Errorf("failed to build message: %w", err)
+		return nil, fmt.Errorf("failed to build message: %w", err)
+	}
+
+	var pending *pendingUpload
+	if limit := c.config.MaxMessageSize; limit > 0 && int64(msg.Len()) > limit {
+		if c.config.Uploader == nil || len(opts.Attachments) == 0 {
+			return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrMessageTooLarge, msg.Len(), limit)
+		}
+		pending = &pendingUpload{opts: opts, date: date, messageID: messageID}
+		if msg, err = c.buildUploaded(pending, nil); err != nil {
+			return nil, err
+		}
 	}
 
 	// Extract recipients
@@ -106,26 +212,210 @@ func (c *SMTPClient) Send(opts SendOptions) error {
 		recipients = append(recipients, addr.Email)
 	}
 
-	// Send email
-	from := opts.From.Email
-	if err := c.client.SendMail(from, recipients, msg); err != nil {
+	return &ComposedMessage{
+		From:       opts.From.Email,
+		Recipients: recipients,
+		Data:       msg.Bytes(),
+		pending:    pending,
+	}, nil
+}
+
+// Uploads returns the attachments of m that go to SMTPConfig.Uploader
+// when it is sent, nil if none do. Until then Data links to them with a
+// placeholder.
+func (m *ComposedMessage) Uploads() []AttachmentPath {
+	if m.pending == nil {
+		return nil
+	}
+	return m.pending.opts.Attachments
+}
+
+// Upload uploads the attachments Compose left out of m for their size and
+// puts their download links in m.Data. It does nothing if m.Uploads is
+// empty; SendComposed calls it before transmitting.
+func (c *SMTPClient) Upload(m *ComposedMessage) error {
+	if m.pending == nil {
+		return nil
+	}
+	urls, err := c.uploadAttachments(m.pending.opts.Attachments)
+	if err != nil {
+		return err
+	}
+	msg, err := c.buildUploaded(m.pending, urls)
+	if err != nil {
+		return err
+	}
+	m.Data, m.pending = msg.Bytes(), nil
+	return nil
+}
+
+// buildUploaded builds the message of p with its attachments replaced by
+// links to urls, or to a placeholder if urls is nil, and checks it against
+// MaxMessageSize.
+func (c *SMTPClient) buildUploaded(p *pendingUpload, urls []string) (*bytes.Buffer, error) {
+	opts, err := attachmentLinks(p.opts, urls)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := c.buildMessage(opts, p.date, p.messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build message: %w", err)
+	}
+	if limit := c.config.MaxMessageSize; int64(msg.Len()) > limit {
+		return nil, fmt.Errorf("%w: %d bytes without attachments, limit %d", ErrMessageTooLarge, msg.Len(), limit)
+	}
+	return msg, nil
+}
+
+// checkEnvelope checks the addresses of opts, so a typo in a recipient
+// fails before connecting rather than at RCPT TO, after the others were
+// accepted. An empty From is the null sender.
+func checkEnvelope(opts SendOptions) error {
+	if opts.From.Email != "" {
+		if err := checkAddress(opts.From.Email); err != nil {
+			return fmt.Errorf("invalid From address %q: %w", opts.From.Email, err)
+		}
+	}
+	for _, list := range []struct {
+		field string
+		addrs []Address
+	}{{"To", opts.To}, {"Cc", opts.Cc}, {"Bcc", opts.Bcc}, {"Reply-To", opts.ReplyTo}} {
+		for _, addr := range list.addrs {
+			if err := checkAddress(addr.Email); err != nil {
+				return fmt.Errorf("invalid %s address %q: %w", list.field, addr.Email, err)
+			}
+		}
+	}
+	return nil
+}
+
+// SendComposed transmits a message built by Compose, uploading its
+// attachments first if Compose left them out for their size.
+func (c *SMTPClient) SendComposed(m *ComposedMessage) error {
+	if err := c.Upload(m); err != nil {
+		return err
+	}
+	if c.client == nil {
+		if err := c.Connect(); err != nil {
+			return err
+		}
+		defer c.Close()
+	}
+
+	if err := c.client.SendMail(m.From, m.Recipients, bytes.NewReader(m.Data)); err != nil {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
 
 	return nil
 }
 
-// buildMessage builds an email message from SendOptions
-func (c *SMTPClient) buildMessage(opts SendOptions) (*bytes.Buffer, error) {
+// SendComposedRetry sends m over the client's connection and leaves it open
+// for further messages, so callers sending many messages should Close the
+// client when done. Temporary failures (4xx replies, dropped connections)
+// reconnect and retry up to retries more times, waiting delay before the
+// first retry and doubling it after each. It returns the number of attempts
+// made. A connection used before is reset first, so it stays usable after
+// a permanent failure. NewSession does the same for a run of messages.
+func (c *SMTPClient) SendComposedRetry(ctx context.Context, m *ComposedMessage, retries int, delay time.Duration) (attempts int, err error) {
+	s := &SMTPSession{client: c, Retries: retries, RetryDelay: delay}
+	if c.client != nil {
+		s.count = 1
+	}
+	return s.SendComposed(ctx, m)
+}
+
+// IsTemporarySMTPError reports whether err is a failure worth retrying: a
+// 4xx SMTP reply or a network error.
+func IsTemporarySMTPError(err error) bool {
+	var smtpErr *smtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code >= 400 && smtpErr.Code < 500
+	}
+	return isNetworkError(err)
+}
+
+// Header returns the header block of the message, without the blank line
+// that ends it.
+func (m *ComposedMessage) Header() string {
+	data := string(m.Data)
+	if i := strings.Index(data, "\r\n\r\n"); i >= 0 {
+		return data[:i]
+	}
+	return data
+}
+
+// MessageID returns the message's Message-ID without angle brackets, or ""
+// if it has none.
+func (m *ComposedMessage) MessageID() string {
+	h, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(m.Data)))
+	if err != nil {
+		return ""
+	}
+	mh := mail.Header{Header: gomessage.Header{Header: h}}
+	id, _ := mh.MessageID()
+	return id
+}
+
+// Decode parses the composed message back into a Message with decoded
+// text and HTML bodies and attachments, for display.
+func (m *ComposedMessage) Decode() (*Message, error) {
+	entity, err := gomessage.Read(bytes.NewReader(m.Data))
+	if !isRecoverableEntityError(err) {
+		return nil, err
+	}
+	msg := &Message{}
+	parseEntityBody(msg, entity)
+	return msg, nil
+}
+
+// ApplyDefaults returns opts with the client's configured defaults applied:
+// AlwaysCc and AlwaysBcc addresses that are not already recipients are
+// appended and ReplyTo fills an empty SendOptions.ReplyTo. Send calls it
+// itself; it is exported so callers can show what will actually be sent.
+func (c *SMTPClient) ApplyDefaults(opts SendOptions) SendOptions {
+	seen := make(map[string]bool)
+	for _, list := range [][]Address{opts.To, opts.Cc, opts.Bcc} {
+		for _, a := range list {
+			seen[strings.ToLower(a.Email)] = true
+		}
+	}
+	add := func(dst, extra []Address) []Address {
+		// Cap dst so appending copies instead of writing into the caller's array
+		dst = dst[:len(dst):len(dst)]
+		for _, a := range extra {
+			key := strings.ToLower(a.Email)
+			if a.Email == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			dst = append(dst, a)
+		}
+		return dst
+	}
+	opts.Cc = add(opts.Cc, c.config.AlwaysCc)
+	opts.Bcc = add(opts.Bcc, c.config.AlwaysBcc)
+
+	if len(opts.ReplyTo) == 0 {
+		opts.ReplyTo = c.config.ReplyTo
+	}
+	return opts
+}
+
+// buildMessage builds an email message from SendOptions, with the given
+// Date and Message-ID
+func (c *SMTPClient) buildMessage(opts SendOptions, date time.Time, messageID string) (*bytes.Buffer, error) {
 	var buf bytes.Buffer
 
 	var header mail.Header
-	header.SetDate(time.Now())
+	header.SetDate(date)
 	header.SetSubject(opts.Subject)
 	header.SetAddressList("From", []*mail.Address{{
 		Name:    opts.From.Name,
 		Address: opts.From.Email,
 	}})
+	if s := c.config.Sender; s.Email != "" && !strings.EqualFold(s.Email, opts.From.Email) {
+		header.SetAddressList("Sender", []*mail.Address{{Name: s.Name, Address: s.Email}})
+	}
 
 	if len(opts.To) > 0 {
 		toAddrs := make([]*mail.Address, len(opts.To))
@@ -149,18 +439,41 @@ func (c *SMTPClient) buildMessage(opts SendOptions) (*bytes.Buffer, error) {
 		header.SetAddressList("Cc", ccAddrs)
 	}
 
-	// Handle reply and references
-	if opts.InReplyTo != "" {
-		header.SetMsgIDList("In-Reply-To", []string{opts.InReplyTo})
+	if len(opts.ReplyTo) > 0 {
+		replyAddrs := make([]*mail.Address, len(opts.ReplyTo))
+		for i, addr := range opts.ReplyTo {
+			replyAddrs[i] = &mail.Address{
+				Name:    addr.Name,
+				Address: addr.Email,
+			}
+		}
+		header.SetAddressList("Reply-To", replyAddrs)
 	}
-	if len(opts.References) > 0 {
-		header.SetMsgIDList("References", opts.References)
+
+	if c.config.XMailer != "" {
+		header.Set("X-Mailer", c.config.XMailer)
+	}
+	if opts.AutoSubmitted != "" {
+		header.Set("Auto-Submitted", opts.AutoSubmitted)
+		header.Set("X-Loop", opts.From.Email)
 	}
 
-	// Generate Message-ID
-	if opts.InReplyTo == "" {
-		header.Set("Message-ID", GenerateMessageID(opts.From.Email))
+	// Handle reply and references; IDs may be given with or without <>
+	if id := trimMsgID(opts.InReplyTo); id != "" {
+		header.SetMsgIDList("In-Reply-To", []string{id})
 	}
+	if len(opts.References) > 0 {
+		refs := make([]string, 0, len(opts.References))
+		for _, ref := range opts.References {
+			if id := trimMsgID(ref); id != "" {
+				refs = append(refs, id)
+			}
+		}
+		header.SetMsgIDList("References", refs)
+	}
+
+	// Replies need their own Message-ID too, for replies to them to thread
+	header.Set("Message-ID", messageID)
 
 	// Create multipart writer
 	var mw *mail.Writer
@@ -264,6 +577,63 @@ func (c *SMTPClient) Close() error {
 	return nil
 }
 
+// SMTPVerifyOptions represents options for SMTPClient.Verify
+type SMTPVerifyOptions struct {
+	From      string // Envelope sender for the recipient check
+	Recipient string // Optional recipient to check with VRFY and a MAIL/RCPT dry run
+}
+
+// SMTPVerifyResult reports how the server answered the recipient checks
+type SMTPVerifyResult struct {
+	Verified    bool   // VRFY confirmed the recipient
+	VerifyReply string // Why VRFY did not confirm it; many servers disable VRFY
+	Accepted    bool   // RCPT TO was accepted
+	RcptReply   string // Why RCPT TO was rejected
+}
+
+// Verify checks that the server is reachable and accepts the configured
+// credentials (connect, EHLO, STARTTLS/TLS, AUTH, NOOP) without sending a
+// message. With opts.Recipient it also tries VRFY and a MAIL FROM/RCPT TO
+// exchange that is reset before any data is sent. Connection and
+// authentication failures are returned as errors; recipient rejections are
+// reported in the result.
+func (c *SMTPClient) Verify(opts SMTPVerifyOptions) (*SMTPVerifyResult, error) {
+	if c.client == nil {
+		if err := c.Connect(); err != nil {
+			return nil, err
+		}
+		defer c.Close()
+	}
+
+	if err := c.client.Noop(); err != nil {
+		return nil, fmt.Errorf("SMTP NOOP failed: %w", err)
+	}
+
+	result := &SMTPVerifyResult{}
+	if opts.Recipient == "" {
+		return result, nil
+	}
+
+	if err := c.client.Verify(opts.Recipient); err != nil {
+		result.VerifyReply = err.Error()
+	} else {
+		result.Verified = true
+	}
+
+	if err := c.client.Mail(opts.From, nil); err != nil {
+		return nil, fmt.Errorf("SMTP MAIL FROM <%s> rejected: %w", opts.From, err)
+	}
+	if err := c.client.Rcpt(opts.Recipient, nil); err != nil {
+		result.RcptReply = err.Error()
+	} else {
+		result.Accepted = true
+	}
+	if err := c.client.Reset(); err != nil {
+		return nil, fmt.Errorf("SMTP RSET failed: %w", err)
+	}
+	return result, nil
+}
+
 // SendQuick sends an email with a simple configuration (helper function)
 func SendQuickSMTP(host string, port int, username, password string, useSSL bool, opts SendOptions) error {
 	client := NewSMTPClient(SMTPConfig{
@@ -281,14 +651,23 @@ func SendQuickSMTP(host string, port int, username, password string, useSSL bool
 // domain extracted from the sender's email address.
 // Format: <timestamp.random@domain>
 func GenerateMessageID(fromEmail string) string {
+	return newMessageID(fromEmail, time.Now(), rand.Reader)
+}
+
+// generateMessageID is GenerateMessageID with the client's Clock and Rand.
+func (c *SMTPClient) generateMessageID(fromEmail string) string {
+	return newMessageID(fromEmail, clockOrSystem(c.config.Clock).Now(), randOrCrypto(c.config.Rand))
+}
+
+func newMessageID(fromEmail string, now time.Time, random io.Reader) string {
 	domain := "localhost"
 	if idx := strings.Index(fromEmail, "@"); idx >= 0 {
 		domain = fromEmail[idx+1:]
 	}
 
 	b := make([]byte, 8)
-	_, _ = rand.Read(b)
+	_, _ = io.ReadFull(random, b)
 	randomPart := hex.EncodeToString(b)
 
-	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), randomPart, domain)
+	return fmt.Sprintf("<%d.%s@%s>", now.UnixNano(), randomPart, domain)
 }
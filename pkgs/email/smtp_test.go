@@ -1,120 +1,36 @@
 package email
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
-	"sync"
 	"testing"
+	"time"
 
-	"github.com/emersion/go-sasl"
-	gosmtp "github.com/emersion/go-smtp"
-)
-
-// ---------------------------------------------------------------------------
-// SMTP mock server
-// ---------------------------------------------------------------------------
-
-type smtpTestMessage struct {
-	From string
-	To   []string
-	Data []byte
-}
-
-type smtpTestBackend struct {
-	mu       sync.Mutex
-	messages []*smtpTestMessage
-}
-
-func (be *smtpTestBackend) NewSession(_ *gosmtp.Conn) (gosmtp.Session, error) {
-	return &smtpTestSession{backend: be}, nil
-}
-
-func (be *smtpTestBackend) Messages() []*smtpTestMessage {
-	be.mu.Lock()
-	defer be.mu.Unlock()
-	return append([]*smtpTestMessage(nil), be.messages...)
-}
-
-type smtpTestSession struct {
-	backend *smtpTestBackend
-	msg     *smtpTestMessage
-}
-
-func (s *smtpTestSession) AuthMechanisms() []string { return []string{"PLAIN"} }
-
-func (s *smtpTestSession) Auth(mech string) (sasl.Server, error) {
-	return sasl.NewPlainServer(func(_, username, password string) error {
-		if username != "testuser" || password != "testpass" {
-			return errors.New("invalid credentials")
-		}
-		return nil
-	}), nil
-}
-
-func (s *smtpTestSession) Mail(from string, _ *gosmtp.MailOptions) error {
-	s.msg = &smtpTestMessage{From: from}
-	return nil
-}
-
-func (s *smtpTestSession) Rcpt(to string, _ *gosmtp.RcptOptions) error {
-	s.msg.To = append(s.msg.To, to)
-	return nil
-}
-
-func (s *smtpTestSession) Data(r io.Reader) error {
-	b, err := io.ReadAll(r)
-	if err != nil {
-		return err
-	}
-	s.msg.Data = b
-	s.backend.mu.Lock()
-	s.backend.messages = append(s.backend.messages, s.msg)
-	s.backend.mu.Unlock()
-	return nil
-}
-
-func (s *smtpTestSession) Reset()        { s.msg = nil }
-func (s *smtpTestSession) Logout() error { return nil }
-
-// Ensure interface conformance
-var _ gosmtp.AuthSession = (*smtpTestSession)(nil)
+	"github.com/emersion/go-smtp"
 
-// newTestSMTPServer starts a mock SMTP server.  Returns the backend (to
-// inspect received mail) and the listen address.
-func newTestSMTPServer(t *testing.T) (*smtpTestBackend, string) {
-	t.Helper()
-
-	be := &smtpTestBackend{}
-	srv := gosmtp.NewServer(be)
-	srv.Domain = "localhost"
-	srv.AllowInsecureAuth = true
-
-	ln, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	go srv.Serve(ln)
-	t.Cleanup(func() { srv.Close() })
-
-	return be, ln.Addr().String()
-}
+	"github.com/emx-mail/cli/pkgs/testutil"
+)
 
 // ---------------------------------------------------------------------------
 // Tests
 // ---------------------------------------------------------------------------
 
 func TestSMTPSend_PlainText(t *testing.T) {
-	be, addr := newTestSMTPServer(t)
-	host, port := splitHostPort(t, addr)
+	be, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewSMTPClient(SMTPConfig{
 		Host:     host,
 		Port:     port,
-		Username: "testuser",
-		Password: "testpass",
+		Username: testutil.Username,
+		Password: testutil.Password,
 	})
 
 	err := client.Send(SendOptions{
@@ -143,13 +59,46 @@ func TestSMTPSend_PlainText(t *testing.T) {
 	}
 }
 
+func TestSMTPSend_Dial(t *testing.T) {
+	be, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
+
+	var dialed []string
+	client := NewSMTPClient(SMTPConfig{
+		Host:     host,
+		Port:     port,
+		Username: testutil.Username,
+		Password: testutil.Password,
+		Dial: func(network, addr string) (net.Conn, error) {
+			dialed = append(dialed, network+" "+addr)
+			return net.Dial(network, addr)
+		},
+	})
+
+	err := client.Send(SendOptions{
+		From:     Address{Email: "sender@example.com"},
+		To:       []Address{{Email: "rcpt@example.com"}},
+		Subject:  "Dialed",
+		TextBody: "Hello",
+	})
+	if err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+	if len(be.Messages()) != 1 {
+		t.Errorf("expected 1 message, got %d", len(be.Messages()))
+	}
+	if len(dialed) != 1 || dialed[0] != "tcp "+addr {
+		t.Errorf("dialed = %v, want [tcp %s]", dialed, addr)
+	}
+}
+
 func TestSMTPSend_HTMLBody(t *testing.T) {
-	be, addr := newTestSMTPServer(t)
-	host, port := splitHostPort(t, addr)
+	be, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewSMTPClient(SMTPConfig{
 		Host: host, Port: port,
-		Username: "testuser", Password: "testpass",
+		Username: testutil.Username, Password: testutil.Password,
 	})
 
 	err := client.Send(SendOptions{
@@ -169,12 +118,12 @@ func TestSMTPSend_HTMLBody(t *testing.T) {
 }
 
 func TestSMTPSend_MultipleRecipients(t *testing.T) {
-	be, addr := newTestSMTPServer(t)
-	host, port := splitHostPort(t, addr)
+	be, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewSMTPClient(SMTPConfig{
 		Host: host, Port: port,
-		Username: "testuser", Password: "testpass",
+		Username: testutil.Username, Password: testutil.Password,
 	})
 
 	err := client.Send(SendOptions{
@@ -203,8 +152,8 @@ func TestSMTPSend_MultipleRecipients(t *testing.T) {
 }
 
 func TestSMTPSend_BadAuth(t *testing.T) {
-	_, addr := newTestSMTPServer(t)
-	host, port := splitHostPort(t, addr)
+	_, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewSMTPClient(SMTPConfig{
 		Host:     host,
@@ -225,12 +174,12 @@ func TestSMTPSend_BadAuth(t *testing.T) {
 }
 
 func TestSMTPSend_MessageIDPresent(t *testing.T) {
-	be, addr := newTestSMTPServer(t)
-	host, port := splitHostPort(t, addr)
+	be, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewSMTPClient(SMTPConfig{
 		Host: host, Port: port,
-		Username: "testuser", Password: "testpass",
+		Username: testutil.Username, Password: testutil.Password,
 	})
 
 	err := client.Send(SendOptions{
@@ -253,12 +202,12 @@ func TestSMTPSend_MessageIDPresent(t *testing.T) {
 }
 
 func TestSMTPSend_Reply(t *testing.T) {
-	be, addr := newTestSMTPServer(t)
-	host, port := splitHostPort(t, addr)
+	be, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewSMTPClient(SMTPConfig{
 		Host: host, Port: port,
-		Username: "testuser", Password: "testpass",
+		Username: testutil.Username, Password: testutil.Password,
 	})
 
 	err := client.Send(SendOptions{
@@ -280,6 +229,12 @@ func TestSMTPSend_Reply(t *testing.T) {
 	if !strings.Contains(data, "References") {
 		t.Error("References header not found")
 	}
+	if strings.Contains(data, "<<") {
+		t.Error("Message-IDs given with angle brackets were bracketed twice")
+	}
+	if !strings.Contains(data, "Message-Id:") && !strings.Contains(data, "Message-ID:") {
+		t.Error("reply has no Message-ID")
+	}
 }
 
 func TestSMTPGenerateMessageID(t *testing.T) {
@@ -314,6 +269,397 @@ func TestSMTPGenerateMessageID_DifferentDomains(t *testing.T) {
 	}
 }
 
+func TestSMTPSend_AccountDefaults(t *testing.T) {
+	be, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
+
+	client := NewSMTPClient(SMTPConfig{
+		Host: host, Port: port,
+		Username: testutil.Username, Password: testutil.Password,
+		AlwaysCc:  []Address{{Email: "team@example.com"}},
+		AlwaysBcc: []Address{{Email: "archive@example.com"}, {Email: "RCPT@example.com"}},
+		ReplyTo:   []Address{{Name: "Support", Email: "support@example.com"}},
+		XMailer:   "ACME Mailer 2.0",
+	})
+
+	err := client.Send(SendOptions{
+		From:     Address{Email: "sender@example.com"},
+		To:       []Address{{Email: "rcpt@example.com"}},
+		Subject:  "Defaults",
+		TextBody: "body",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := be.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	// The recipient already in To is not added again via AlwaysBcc
+	want := []string{"rcpt@example.com", "team@example.com", "archive@example.com"}
+	if strings.Join(msgs[0].To, ",") != strings.Join(want, ",") {
+		t.Errorf("envelope recipients = %v, want %v", msgs[0].To, want)
+	}
+	data := string(msgs[0].Data)
+	for _, s := range []string{"Cc: <team@example.com>", "Reply-To: \"Support\" <support@example.com>", "X-Mailer: ACME Mailer 2.0"} {
+		if !strings.Contains(data, s) {
+			t.Errorf("expected %q in message data", s)
+		}
+	}
+	if strings.Contains(data, "archive@example.com") {
+		t.Error("Bcc address leaked into message headers")
+	}
+}
+
+func TestSMTPSend_Delegate(t *testing.T) {
+	be, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
+
+	client := NewSMTPClient(SMTPConfig{
+		Host: host, Port: port,
+		Username: testutil.Username, Password: testutil.Password,
+		AuthzID: "support@example.com",
+		Sender:  Address{Name: "Agent", Email: "agent@example.com"},
+	})
+	err := client.Send(SendOptions{
+		From:     Address{Name: "Support", Email: "support@example.com"},
+		To:       []Address{{Email: "customer@example.com"}},
+		Subject:  "Your ticket",
+		TextBody: "body",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := be.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	if msgs[0].Identity != "support@example.com" {
+		t.Errorf("authorization identity = %q, want support@example.com", msgs[0].Identity)
+	}
+	data := string(msgs[0].Data)
+	for _, s := range []string{"From: \"Support\" <support@example.com>", "Sender: \"Agent\" <agent@example.com>"} {
+		if !strings.Contains(data, s) {
+			t.Errorf("expected %q in message data", s)
+		}
+	}
+}
+
+func TestSMTPApplyDefaults_ExplicitReplyTo(t *testing.T) {
+	client := NewSMTPClient(SMTPConfig{
+		ReplyTo:   []Address{{Email: "default@example.com"}},
+		AlwaysBcc: []Address{{Email: "archive@example.com"}},
+	})
+	bcc := make([]Address, 1, 4)
+	bcc[0] = Address{Email: "b@example.com"}
+
+	opts := client.ApplyDefaults(SendOptions{
+		ReplyTo: []Address{{Email: "me@example.com"}},
+		Bcc:     bcc,
+	})
+	if len(opts.ReplyTo) != 1 || opts.ReplyTo[0].Email != "me@example.com" {
+		t.Errorf("explicit Reply-To overridden: %v", opts.ReplyTo)
+	}
+	if len(opts.Bcc) != 2 || bcc[:2][1].Email != "" {
+		t.Errorf("unexpected Bcc %v (caller array modified: %v)", opts.Bcc, bcc[:2])
+	}
+}
+
+func TestSMTPComposeThenSend(t *testing.T) {
+	be, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
+
+	client := NewSMTPClient(SMTPConfig{
+		Host: host, Port: port,
+		Username: testutil.Username, Password: testutil.Password,
+	})
+
+	m, err := client.Compose(SendOptions{
+		From:     Address{Email: "sender@example.com"},
+		To:       []Address{{Email: "rcpt@example.com"}},
+		Bcc:      []Address{{Email: "hidden@example.com"}},
+		Subject:  "Preview me",
+		TextBody: "Hello, World!",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(m.Header(), "Subject: Preview me") || strings.Contains(m.Header(), "hidden@") {
+		t.Errorf("unexpected header block:\n%s", m.Header())
+	}
+	decoded, err := m.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.TextBody != "Hello, World!" {
+		t.Errorf("decoded TextBody = %q", decoded.TextBody)
+	}
+	if id := m.MessageID(); !strings.HasSuffix(id, "@example.com") || strings.ContainsAny(id, "<>") {
+		t.Errorf("MessageID() = %q", id)
+	}
+
+	// Nothing is transmitted until SendComposed, which sends the same bytes
+	if len(be.Messages()) != 0 {
+		t.Fatal("Compose must not send")
+	}
+	if err := client.SendComposed(m); err != nil {
+		t.Fatal(err)
+	}
+	msgs := be.Messages()
+	if len(msgs) != 1 || string(msgs[0].Data) != string(m.Data) {
+		t.Fatal("transmitted message differs from the composed one")
+	}
+	if strings.Join(msgs[0].To, ",") != "rcpt@example.com,hidden@example.com" {
+		t.Errorf("envelope recipients = %v", msgs[0].To)
+	}
+}
+
+func TestSMTPCompose_MaxMessageSize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "big.bin")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("x"), 4096), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := SendOptions{
+		From:        Address{Email: "sender@example.com"},
+		To:          []Address{{Email: "rcpt@example.com"}},
+		Subject:     "Big",
+		TextBody:    "See attached.",
+		Attachments: []AttachmentPath{{Filename: "big.bin", Path: path}},
+	}
+
+	client := NewSMTPClient(SMTPConfig{MaxMessageSize: 2048})
+	if _, err := client.Compose(opts); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("Compose() error = %v, want ErrMessageTooLarge", err)
+	}
+
+	up := &fakeUploader{}
+	client = NewSMTPClient(SMTPConfig{MaxMessageSize: 2048, Uploader: up})
+	m, err := client.Compose(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is uploaded before sending, e.g. for a preview
+	if len(up.uploads) != 0 || len(m.Uploads()) != 1 || !strings.Contains(string(m.Data), uploadPlaceholder) {
+		t.Fatalf("composed: uploads = %v, Uploads() = %v", up.uploads, m.Uploads())
+	}
+	id := m.MessageID()
+	if err := client.Upload(m); err != nil {
+		t.Fatal(err)
+	}
+	if len(up.uploads) != 1 || up.uploads["big.bin"] != 4096 || m.Uploads() != nil {
+		t.Errorf("uploads = %v", up.uploads)
+	}
+	if m.MessageID() != id {
+		t.Errorf("Message-ID changed by Upload: %s, was %s", m.MessageID(), id)
+	}
+	decoded, err := m.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Attachments) != 0 {
+		t.Errorf("attachments still present: %d", len(decoded.Attachments))
+	}
+	if !strings.HasPrefix(decoded.TextBody, "See attached.") ||
+		!strings.Contains(decoded.TextBody, "big.bin (4096 bytes): https://files.example.com/big.bin") {
+		t.Errorf("TextBody = %q", decoded.TextBody)
+	}
+}
+
+type fakeUploader struct {
+	uploads map[string]int64
+}
+
+func (u *fakeUploader) Upload(name string, r io.Reader, size int64) (string, error) {
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", fmt.Errorf("read %d bytes, size %d", n, size)
+	}
+	if u.uploads == nil {
+		u.uploads = make(map[string]int64)
+	}
+	u.uploads[name] = n
+	return "https://files.example.com/" + name, nil
+}
+
+func TestSMTPSendComposedRetry(t *testing.T) {
+	be, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
+
+	client := NewSMTPClient(SMTPConfig{
+		Host: host, Port: port,
+		Username: testutil.Username, Password: testutil.Password,
+	})
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		m, err := client.Compose(SendOptions{
+			From:     Address{Email: "sender@example.com"},
+			To:       []Address{{Email: fmt.Sprintf("rcpt%d@example.com", i)}},
+			Subject:  "Bulk",
+			TextBody: "Hello",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		attempts, err := client.SendComposedRetry(context.Background(), m, 2, time.Millisecond)
+		if err != nil || attempts != 1 {
+			t.Fatalf("SendComposedRetry() = %d, %v", attempts, err)
+		}
+		if client.client == nil {
+			t.Fatal("connection not kept open between messages")
+		}
+	}
+	if n := len(be.Messages()); n != 2 {
+		t.Errorf("delivered %d messages, want 2", n)
+	}
+}
+
+func TestSMTPSendComposedRetry_Unreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port := testutil.SplitHostPort(t, ln.Addr().String())
+	ln.Close()
+
+	clock := testutil.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC))
+	client := NewSMTPClient(SMTPConfig{Host: host, Port: port, Clock: clock})
+	m := &ComposedMessage{From: "a@example.com", Recipients: []string{"b@example.com"}, Data: []byte("Subject: x\r\n\r\nx\r\n")}
+	attempts, err := client.SendComposedRetry(context.Background(), m, 2, time.Minute)
+	if err == nil || attempts != 3 {
+		t.Errorf("SendComposedRetry() = %d, %v; want 3 attempts and an error", attempts, err)
+	}
+	// The delay doubles after each retry
+	if waits := fmt.Sprint(clock.Waits()); waits != "[1m0s 2m0s]" {
+		t.Errorf("waits = %s, want [1m0s 2m0s]", waits)
+	}
+}
+
+func TestSMTPCompose_ClockAndRand(t *testing.T) {
+	date := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	opts := SendOptions{
+		From:     Address{Email: "sender@example.com"},
+		To:       []Address{{Email: "rcpt@example.com"}},
+		Subject:  "Fixed",
+		TextBody: "Hello",
+	}
+	compose := func() *ComposedMessage {
+		client := NewSMTPClient(SMTPConfig{Clock: testutil.NewFakeClock(date), Rand: testutil.NewRand(1)})
+		m, err := client.Compose(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	m := compose()
+	if !strings.Contains(m.Header(), "Date: Mon, 01 Jan 2024 10:00:00 +0000") {
+		t.Errorf("header has no fake Date:\n%s", m.Header())
+	}
+	id := m.MessageID()
+	if !strings.HasPrefix(id, fmt.Sprintf("%d.", date.UnixNano())) {
+		t.Errorf("MessageID() = %q, want the fake time", id)
+	}
+	if again := compose().MessageID(); again != id {
+		t.Errorf("MessageID() = %q, then %q with the same seed", id, again)
+	}
+}
+
+func TestIsTemporarySMTPError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&smtp.SMTPError{Code: 451, Message: "try again"}, true},
+		{fmt.Errorf("failed to send email: %w", &smtp.SMTPError{Code: 421}), true},
+		{&smtp.SMTPError{Code: 550, Message: "no such user"}, false},
+		{io.EOF, true},
+		{&net.OpError{Op: "dial", Err: errors.New("refused")}, true},
+		{errors.New("failed to open attachment"), false},
+	}
+	for _, tt := range tests {
+		if got := IsTemporarySMTPError(tt.err); got != tt.want {
+			t.Errorf("IsTemporarySMTPError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSMTPVerify(t *testing.T) {
+	be, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
+
+	client := NewSMTPClient(SMTPConfig{
+		Host: host, Port: port,
+		Username: testutil.Username, Password: testutil.Password,
+	})
+	result, err := client.Verify(SMTPVerifyOptions{})
+	if err != nil {
+		t.Fatalf("Verify() error: %v", err)
+	}
+	if result.Accepted {
+		t.Error("Accepted set without a recipient")
+	}
+
+	result, err = client.Verify(SMTPVerifyOptions{From: "sender@example.com", Recipient: "rcpt@example.com"})
+	if err != nil {
+		t.Fatalf("Verify() error: %v", err)
+	}
+	if !result.Accepted || result.RcptReply != "" {
+		t.Errorf("recipient not accepted: %+v", result)
+	}
+	if len(be.Messages()) != 0 {
+		t.Error("Verify must not send a message")
+	}
+}
+
+func TestSMTPVerify_BadAuth(t *testing.T) {
+	_, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
+
+	client := NewSMTPClient(SMTPConfig{
+		Host: host, Port: port,
+		Username: testutil.Username, Password: "wrong",
+	})
+	if _, err := client.Verify(SMTPVerifyOptions{}); err == nil {
+		t.Fatal("expected auth error, got nil")
+	}
+}
+
+func TestSMTPCapabilities(t *testing.T) {
+	_, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
+
+	client := NewSMTPClient(SMTPConfig{
+		Host: host, Port: port,
+		Username: testutil.Username, Password: testutil.Password,
+	})
+	caps, err := client.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.Protocol != "smtp" || !containsString(caps.List, "PIPELINING") {
+		t.Errorf("capabilities = %+v", caps)
+	}
+	if !caps.Features[0].Enabled {
+		t.Errorf("AUTH PLAIN not detected: %+v", caps.Features[0])
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 func TestSMTPGenerateMessageID_Uniqueness(t *testing.T) {
 	ids := make(map[string]struct{}, 100)
 	for i := 0; i < 100; i++ {
@@ -326,12 +672,12 @@ func TestSMTPGenerateMessageID_Uniqueness(t *testing.T) {
 }
 
 func TestSMTPClose(t *testing.T) {
-	_, addr := newTestSMTPServer(t)
-	host, port := splitHostPort(t, addr)
+	_, addr := testutil.NewSMTPServer(t)
+	host, port := testutil.SplitHostPort(t, addr)
 
 	client := NewSMTPClient(SMTPConfig{
 		Host: host, Port: port,
-		Username: "testuser", Password: "testpass",
+		Username: testutil.Username, Password: testutil.Password,
 	})
 	if err := client.Connect(); err != nil {
 		t.Fatal(err)
@@ -344,3 +690,41 @@ func TestSMTPClose(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestSMTPComposeAddresses(t *testing.T) {
+	client := NewSMTPClient(SMTPConfig{Host: "localhost", Port: 1})
+
+	m, err := client.Compose(SendOptions{
+		From:     Address{Name: "Sender", Email: "sender@example.com"},
+		To:       []Address{{Name: "Müller, Jürgen", Email: "j@example.de"}},
+		Subject:  "Hi",
+		TextBody: "Hello",
+	})
+	if err != nil {
+		t.Fatalf("Compose() error: %v", err)
+	}
+	var to string
+	for _, line := range strings.Split(m.Header(), "\r\n") {
+		if strings.HasPrefix(line, "To: ") {
+			to = strings.TrimPrefix(line, "To: ")
+		}
+	}
+	if !strings.HasPrefix(to, "=?utf-8?") {
+		t.Errorf("non-ASCII display name not encoded:\n%s", m.Header())
+	}
+	if addrs, err := ParseAddresses(to); err != nil || len(addrs) != 1 || addrs[0].Name != "Müller, Jürgen" {
+		t.Errorf("To %q parses as %+v, %v", to, addrs, err)
+	}
+
+	// Invalid addresses fail without connecting (port 1 would refuse)
+	for _, opts := range []SendOptions{
+		{From: Address{Email: "sender@example.com"}, To: []Address{{Email: "bob"}}},
+		{From: Address{Email: "sender@example.com"}, To: []Address{{Email: "a@x.com"}}, Bcc: []Address{{Email: "a@x.com>, <b@y.com"}}},
+		{From: Address{Email: "sender@"}, To: []Address{{Email: "a@x.com"}}},
+	} {
+		opts.Subject, opts.TextBody = "Hi", "Hello"
+		if err := client.Send(opts); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("Send(%+v) = %v, want an invalid address error", opts, err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// DefaultRelayAddr is where a RelayServer listens unless told otherwise:
+// localhost only, since it accepts mail from anyone who can connect.
+const DefaultRelayAddr = "127.0.0.1:2525"
+
+// IsLoopbackAddr reports whether the listen address addr (host:port) only
+// accepts connections from this machine: its host is a loopback IP or
+// "localhost". An empty or unspecified host listens on every interface.
+func IsLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// DefaultRelayMaxMessageSize is the largest message, in bytes, a
+// RelayServer accepts unless told otherwise.
+const DefaultRelayMaxMessageSize = 32 << 20
+
+// RelayMessage is a message accepted by a RelayServer.
+type RelayMessage struct {
+	From     string    // Envelope sender (MAIL FROM), "" for bounces
+	To       []string  // Envelope recipients (RCPT TO), including Bcc ones
+	Data     []byte    // The message, with a Received header prepended
+	Received time.Time // When the message was accepted
+}
+
+// Journal returns the message as it should be archived: Data with
+// Return-Path and X-Envelope-To headers recording the envelope, so Bcc
+// recipients, which no header names, are kept.
+func (m *RelayMessage) Journal() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Return-Path: <%s>\r\n", m.From)
+	b.WriteString("X-Envelope-To: " + strings.Join(m.To, ",\r\n ") + "\r\n")
+	b.Write(m.Data)
+	return b.Bytes()
+}
+
+// RelayServer is a minimal SMTP server for applications that can only
+// "send email": it accepts every message and hands it to Handler. It has
+// no authentication, so it should only listen on localhost, see
+// IsLoopbackAddr.
+type RelayServer struct {
+	Addr           string // Listen address; DefaultRelayAddr if empty
+	Domain         string // Name in the greeting and Received headers; "localhost" if empty
+	MaxMessageSize int64  // Largest message accepted; DefaultRelayMaxMessageSize if <= 0
+
+	// Handler archives or forwards a message. It may run for several
+	// connections at once. An error is answered with a temporary failure
+	// (451), so the application keeps the message and tries again later.
+	Handler func(*RelayMessage) error
+
+	once   sync.Once
+	server *smtp.Server
+}
+
+// smtpServer returns the underlying server, creating it on first use.
+func (s *RelayServer) smtpServer() *smtp.Server {
+	s.once.Do(func() {
+		s.server = smtp.NewServer(smtp.BackendFunc(func(c *smtp.Conn) (smtp.Session, error) {
+			return &relaySession{relay: s, conn: c}, nil
+		}))
+		s.server.Domain = s.domain()
+		s.server.MaxMessageBytes = s.MaxMessageSize
+		if s.server.MaxMessageBytes <= 0 {
+			s.server.MaxMessageBytes = DefaultRelayMaxMessageSize
+		}
+		s.server.ReadTimeout = 5 * time.Minute
+		s.server.WriteTimeout = time.Minute
+	})
+	return s.server
+}
+
+func (s *RelayServer) domain() string {
+	if s.Domain == "" {
+		return "localhost"
+	}
+	return s.Domain
+}
+
+// ListenAndServe listens on Addr and serves connections until Shutdown.
+func (s *RelayServer) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = DefaultRelayAddr
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return s.Serve(l)
+}
+
+// Serve serves connections accepted on l until Shutdown, when it returns
+// nil.
+func (s *RelayServer) Serve(l net.Listener) error {
+	return s.smtpServer().Serve(l)
+}
+
+// Shutdown stops accepting connections and waits for the open ones to
+// finish, or until ctx is done.
+func (s *RelayServer) Shutdown(ctx context.Context) error {
+	return s.smtpServer().Shutdown(ctx)
+}
+
+// relaySession collects the envelope of one message after another on a
+// connection.
+type relaySession struct {
+	relay *RelayServer
+	conn  *smtp.Conn
+	from  string
+	to    []string
+}
+
+func (s *relaySession) Reset() { s.from, s.to = "", nil }
+
+func (s *relaySession) Logout() error { return nil }
+
+func (s *relaySession) Mail(from string, _ *smtp.MailOptions) error {
+	s.from, s.to = from, nil
+	return nil
+}
+
+func (s *relaySession) Rcpt(to string, _ *smtp.RcptOptions) error {
+	s.to = append(s.to, to)
+	return nil
+}
+
+func (s *relaySession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	msg := &RelayMessage{From: s.from, To: s.to, Received: time.Now()}
+	msg.Data = append([]byte(s.receivedHeader(msg.Received)), data...)
+	if err := s.relay.Handler(msg); err != nil {
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Message not accepted, try again later: " + err.Error(),
+		}
+	}
+	return nil
+}
+
+// receivedHeader returns the Received trace header of a message accepted
+// at t, ending in CRLF.
+func (s *relaySession) receivedHeader(t time.Time) string {
+	from := s.conn.Hostname()
+	if addr := s.conn.Conn().RemoteAddr(); addr != nil {
+		host := addr.String()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		from += " ([" + host + "])"
+	}
+	return fmt.Sprintf("Received: from %s\r\n\tby %s (emx-mail smtpd) with SMTP;\r\n\t%s\r\n",
+		strings.TrimSpace(from), s.relay.domain(), t.Format(time.RFC1123Z))
+}
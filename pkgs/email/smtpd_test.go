@@ -0,0 +1,119 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+// startRelay serves a RelayServer with handler on a free local port and
+// returns its address.
+func startRelay(t *testing.T, handler func(*RelayMessage) error) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RelayServer{Domain: "relay.test", Handler: handler}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Shutdown(context.Background())
+		if err := <-done; err != nil {
+			t.Errorf("Serve() error: %v", err)
+		}
+	})
+	return l.Addr().String()
+}
+
+// relaySend sends msg to the relay at addr, without TLS.
+func relaySend(addr, from string, to []string, msg string) error {
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.SendMail(from, to, strings.NewReader(msg))
+}
+
+func TestRelayServer(t *testing.T) {
+	var mu sync.Mutex
+	var got []*RelayMessage
+	addr := startRelay(t, func(m *RelayMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, m)
+		return nil
+	})
+
+	to := []string{"rcpt@example.com", "hidden@example.com"}
+	if err := relaySend(addr, "app@example.com", to, testMailRFC822); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 1 {
+		t.Fatalf("got %d messages, want 1", len(got))
+	}
+	m := got[0]
+	if m.From != "app@example.com" || strings.Join(m.To, " ") != "rcpt@example.com hidden@example.com" {
+		t.Errorf("envelope = %q -> %q", m.From, m.To)
+	}
+	data := string(m.Data)
+	if !strings.HasPrefix(data, "Received: from ") || !strings.Contains(data, "by relay.test (emx-mail smtpd)") {
+		t.Errorf("no Received header:\n%s", data)
+	}
+	if !strings.HasSuffix(data, testMailRFC822+"\r\n") {
+		t.Errorf("message not kept as sent:\n%s", data)
+	}
+
+	journal := string(m.Journal())
+	want := "Return-Path: <app@example.com>\r\nX-Envelope-To: rcpt@example.com,\r\n hidden@example.com\r\nReceived: "
+	if !strings.HasPrefix(journal, want) {
+		t.Errorf("Journal() starts with %q, want %q", journal[:len(want)], want)
+	}
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1:2525", true},
+		{"127.8.0.1:25", true},
+		{"[::1]:2525", true},
+		{"localhost:2525", true},
+		{":2525", false},
+		{"0.0.0.0:2525", false},
+		{"[::]:2525", false},
+		{"192.168.1.10:2525", false},
+		{"mail.example.com:25", false},
+		{"127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := IsLoopbackAddr(tt.addr); got != tt.want {
+			t.Errorf("IsLoopbackAddr(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestRelayServer_HandlerError(t *testing.T) {
+	addr := startRelay(t, func(*RelayMessage) error {
+		return errors.New("archive full")
+	})
+
+	err := relaySend(addr, "app@example.com", []string{"rcpt@example.com"}, testMailRFC822)
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 451 {
+		t.Fatalf("send error = %v, want a 451 reply", err)
+	}
+	if !IsTemporarySMTPError(err) {
+		t.Error("handler failure is not a temporary error")
+	}
+}
@@ -0,0 +1,263 @@
+package email
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// SnapshotVersion is the format version of the snapshots SnapshotWriter
+// writes.
+const SnapshotVersion = 1
+
+// snapshotManifestName is the archive entry of the SnapshotManifest. It is
+// written last, once every message is in.
+const snapshotManifestName = "manifest.json"
+
+// SnapshotManifest describes a mailbox snapshot: a gzip- or
+// zstd-compressed tar archive holding every message as folders/<folder>/<uid>.eml, followed by
+// this manifest as manifest.json.
+type SnapshotManifest struct {
+	Version int              `json:"version"`
+	Created time.Time        `json:"created"`
+	Account string           `json:"account,omitempty"`
+	Folders []SnapshotFolder `json:"folders"`
+}
+
+// SnapshotFolder is a folder of a snapshot.
+type SnapshotFolder struct {
+	Name        string            `json:"name"`           // Server folder name
+	Role        string            `json:"role,omitempty"` // Logical folder, e.g. "sent"; "" if none
+	Delim       string            `json:"delim,omitempty"`
+	UIDValidity uint32            `json:"uidvalidity"`
+	Messages    []SnapshotMessage `json:"messages"`
+}
+
+// SnapshotMessage is a message of a SnapshotFolder.
+type SnapshotMessage struct {
+	UID          uint32    `json:"uid"`
+	MessageID    string    `json:"message_id,omitempty"` // Without angle brackets
+	Flags        []string  `json:"flags,omitempty"`      // Without \Recent
+	InternalDate time.Time `json:"internal_date"`
+	Size         int64     `json:"size"`
+	File         string    `json:"file"` // Archive entry of the message
+}
+
+// SnapshotWriter writes a snapshot archive. Add the folders with
+// AddFolder, each followed by its messages, then Close it.
+type SnapshotWriter struct {
+	zw       io.WriteCloser // Compressor
+	tw       *tar.Writer
+	manifest SnapshotManifest
+}
+
+// NewSnapshotWriter starts a gzip-compressed snapshot of account on w.
+func NewSnapshotWriter(w io.Writer, account string) *SnapshotWriter {
+	return newSnapshotWriter(gzip.NewWriter(w), account)
+}
+
+// NewZstdSnapshotWriter starts a zstd-compressed snapshot of account on w.
+func NewZstdSnapshotWriter(w io.Writer, account string) (*SnapshotWriter, error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return newSnapshotWriter(zw, account), nil
+}
+
+func newSnapshotWriter(zw io.WriteCloser, account string) *SnapshotWriter {
+	return &SnapshotWriter{
+		zw:       zw,
+		tw:       tar.NewWriter(zw),
+		manifest: SnapshotManifest{Version: SnapshotVersion, Created: time.Now().UTC(), Account: account},
+	}
+}
+
+// AddFolder starts a folder; f.Messages is ignored, the messages come from
+// AddMessage.
+func (s *SnapshotWriter) AddFolder(f SnapshotFolder) {
+	f.Messages = nil
+	s.manifest.Folders = append(s.manifest.Folders, f)
+}
+
+// AddMessage adds a message, described by m, to the folder added last.
+func (s *SnapshotWriter) AddMessage(m SnapshotMessage, raw []byte) error {
+	if len(s.manifest.Folders) == 0 {
+		return errors.New("snapshot: message added before a folder")
+	}
+	f := &s.manifest.Folders[len(s.manifest.Folders)-1]
+	m.File = path.Join("folders", url.PathEscape(f.Name), fmt.Sprintf("%d.eml", m.UID))
+	m.Size = int64(len(raw))
+	if err := s.writeFile(m.File, m.InternalDate, raw); err != nil {
+		return err
+	}
+	f.Messages = append(f.Messages, m)
+	return nil
+}
+
+func (s *SnapshotWriter) writeFile(name string, modTime time.Time, data []byte) error {
+	if modTime.IsZero() {
+		modTime = s.manifest.Created
+	}
+	hdr := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := s.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if _, err := s.tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// Close writes the manifest and finishes the archive. It does not close
+// the underlying writer.
+func (s *SnapshotWriter) Close() error {
+	data, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := s.writeFile(snapshotManifestName, s.manifest.Created, data); err != nil {
+		return err
+	}
+	if err := s.tw.Close(); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return s.zw.Close()
+}
+
+// Snapshot is a snapshot file opened with OpenSnapshot.
+type Snapshot struct {
+	Manifest SnapshotManifest
+	path     string
+}
+
+// OpenSnapshot reads the manifest of the snapshot file at path.
+func OpenSnapshot(path string) (*Snapshot, error) {
+	s := &Snapshot{path: path}
+	found := false
+	err := s.walk(func(name string, r io.Reader) error {
+		if name != snapshotManifestName {
+			return nil
+		}
+		found = true
+		if err := json.NewDecoder(r).Decode(&s.Manifest); err != nil {
+			return fmt.Errorf("invalid snapshot manifest: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%s is not a snapshot: no %s (incomplete?)", path, snapshotManifestName)
+	}
+	if s.Manifest.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.Manifest.Version)
+	}
+	return s, nil
+}
+
+// Messages calls fn for every message of the snapshot with its folder, in
+// the order they were added. An error from fn stops and is returned.
+func (s *Snapshot) Messages(fn func(f *SnapshotFolder, m *SnapshotMessage, raw []byte) error) error {
+	type ref struct {
+		f *SnapshotFolder
+		m *SnapshotMessage
+	}
+	byFile := make(map[string]ref)
+	for i := range s.Manifest.Folders {
+		f := &s.Manifest.Folders[i]
+		for j := range f.Messages {
+			byFile[f.Messages[j].File] = ref{f, &f.Messages[j]}
+		}
+	}
+	return s.walk(func(name string, r io.Reader) error {
+		ref, ok := byFile[name]
+		if !ok {
+			return nil
+		}
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read %s from snapshot: %w", name, err)
+		}
+		return fn(ref.f, ref.m, raw)
+	})
+}
+
+// zstdMagic starts every zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// walk calls fn for every file in the snapshot archive.
+func (s *Snapshot) walk(fn func(name string, r io.Reader) error) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The compression is told by the content, not the file name
+	br := bufio.NewReader(file)
+	var zr io.ReadCloser
+	if magic, _ := br.Peek(len(zstdMagic)); bytes.Equal(magic, zstdMagic) {
+		var d *zstd.Decoder
+		if d, err = zstd.NewReader(br); err == nil {
+			zr = d.IOReadCloser()
+		}
+	} else {
+		zr, err = gzip.NewReader(br)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot %s: %w", s.path, err)
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot %s: %w", s.path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// SnapshotCoverage compares the messages of f with the Message-IDs found
+// in a folder after a restore, present counting the messages of each: it
+// returns the messages whose Message-ID is missing from present, or is
+// there fewer times than in f, and how many have no Message-ID to check.
+func SnapshotCoverage(f *SnapshotFolder, present map[string]int) (missing []SnapshotMessage, unverifiable int) {
+	left := make(map[string]int, len(present))
+	for id, n := range present {
+		left[id] = n
+	}
+	for _, m := range f.Messages {
+		switch {
+		case m.MessageID == "":
+			unverifiable++
+		case left[m.MessageID] > 0:
+			left[m.MessageID]--
+		default:
+			missing = append(missing, m)
+		}
+	}
+	return missing, unverifiable
+}
@@ -0,0 +1,143 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.tar.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	w := NewSnapshotWriter(file, "work")
+	w.AddFolder(SnapshotFolder{Name: "INBOX", Role: "inbox", Delim: "/", UIDValidity: 7})
+	if err := w.AddMessage(SnapshotMessage{UID: 1, MessageID: "a@example.org", Flags: []string{`\Seen`}, InternalDate: date}, []byte("Subject: a\r\n\r\nA\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	w.AddFolder(SnapshotFolder{Name: "Lists/Go", Delim: "/", UIDValidity: 9})
+	if err := w.AddMessage(SnapshotMessage{UID: 4, InternalDate: date}, []byte("Subject: b\r\n\r\nB\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	snap, err := OpenSnapshot(path)
+	if err != nil {
+		t.Fatalf("OpenSnapshot() error: %v", err)
+	}
+	m := snap.Manifest
+	if m.Account != "work" || len(m.Folders) != 2 || m.Folders[1].Name != "Lists/Go" || m.Folders[1].UIDValidity != 9 {
+		t.Fatalf("manifest = %+v", m)
+	}
+	inbox := m.Folders[0]
+	if len(inbox.Messages) != 1 || inbox.Messages[0].File != "folders/INBOX/1.eml" || inbox.Messages[0].Size != 17 ||
+		!inbox.Messages[0].InternalDate.Equal(date) || inbox.Messages[0].Flags[0] != `\Seen` {
+		t.Errorf("INBOX messages = %+v", inbox.Messages)
+	}
+
+	var got []string
+	err = snap.Messages(func(f *SnapshotFolder, m *SnapshotMessage, raw []byte) error {
+		got = append(got, f.Name+" "+m.File+" "+string(raw))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Messages() error: %v", err)
+	}
+	want := []string{
+		"INBOX folders/INBOX/1.eml Subject: a\r\n\r\nA\r\n",
+		"Lists/Go folders/Lists%2FGo/4.eml Subject: b\r\n\r\nB\r\n",
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Messages() = %q, want %q", got, want)
+	}
+
+	missing, unverifiable := SnapshotCoverage(&inbox, map[string]int{})
+	if len(missing) != 1 || unverifiable != 0 {
+		t.Errorf("SnapshotCoverage(empty) = %v, %d", missing, unverifiable)
+	}
+	missing, unverifiable = SnapshotCoverage(&m.Folders[1], map[string]int{})
+	if len(missing) != 0 || unverifiable != 1 {
+		t.Errorf("SnapshotCoverage(no Message-ID) = %v, %d", missing, unverifiable)
+	}
+}
+
+func TestOpenSnapshot_Incomplete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.tar.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewSnapshotWriter(file, "work")
+	w.AddFolder(SnapshotFolder{Name: "INBOX"})
+	if err := w.AddMessage(SnapshotMessage{UID: 1}, []byte("Subject: a\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	// Not closed: no manifest
+	w.tw.Flush()
+	w.zw.Close()
+	file.Close()
+
+	if _, err := OpenSnapshot(path); err == nil {
+		t.Error("OpenSnapshot() of a snapshot without manifest should fail")
+	}
+}
+
+func TestSnapshotZstd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.tar.zst")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewZstdSnapshotWriter(file, "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.AddFolder(SnapshotFolder{Name: "INBOX"})
+	if err := w.AddMessage(SnapshotMessage{UID: 1, MessageID: "a@example.org"}, []byte("Subject: a\r\n\r\nA\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	snap, err := OpenSnapshot(path)
+	if err != nil {
+		t.Fatalf("OpenSnapshot() error: %v", err)
+	}
+	var got []string
+	err = snap.Messages(func(f *SnapshotFolder, m *SnapshotMessage, raw []byte) error {
+		got = append(got, m.MessageID+" "+string(raw))
+		return nil
+	})
+	if err != nil || len(got) != 1 || got[0] != "a@example.org Subject: a\r\n\r\nA\r\n" {
+		t.Errorf("Messages() = %q, %v", got, err)
+	}
+}
+
+func TestSnapshotCoverage_Duplicates(t *testing.T) {
+	f := &SnapshotFolder{Messages: []SnapshotMessage{
+		{UID: 1, MessageID: "a@example.org"},
+		{UID: 2, MessageID: "a@example.org"},
+		{UID: 3, MessageID: "b@example.org"},
+	}}
+	present := map[string]int{"a@example.org": 1, "b@example.org": 1}
+	missing, _ := SnapshotCoverage(f, present)
+	if len(missing) != 1 || missing[0].UID != 2 {
+		t.Errorf("SnapshotCoverage() missing = %+v, want the second copy of a@example.org", missing)
+	}
+	if present["a@example.org"] != 1 {
+		t.Error("SnapshotCoverage() changed present")
+	}
+	present["a@example.org"] = 2
+	if missing, _ := SnapshotCoverage(f, present); len(missing) != 0 {
+		t.Errorf("SnapshotCoverage() with both copies = %+v", missing)
+	}
+}
@@ -0,0 +1,146 @@
+package email
+
+import (
+	"strconv"
+	"strings"
+
+	gomessage "github.com/emersion/go-message"
+)
+
+// SpamHeaderFields are the headers parseSpamHeaders reads, for fetching
+// just those.
+var SpamHeaderFields = []string{"X-Spam-Status", "X-Spam-Score", "X-Spam-Flag", "ARC-Authentication-Results"}
+
+// SpamVerdict is the spam filter verdict a server recorded in the
+// X-Spam-Status, X-Spam-Score and X-Spam-Flag headers (SpamAssassin style).
+type SpamVerdict struct {
+	Flagged   bool     `json:"flagged"`             // Classified as spam
+	Score     float64  `json:"score"`               // Score, higher is spammier
+	HasScore  bool     `json:"-"`                   // Score was reported
+	Threshold float64  `json:"threshold,omitempty"` // Score from which mail is spam, 0 if unknown
+	Tests     []string `json:"tests,omitempty"`     // Rules that matched
+}
+
+// ARCResult is one ARC-Authentication-Results header (RFC 8617): the
+// authentication results an intermediary saw when the message passed it.
+type ARCResult struct {
+	Instance   int               `json:"instance"`    // i=, 1 for the first hop
+	AuthServID string            `json:"authserv_id"` // Host that authenticated
+	Results    map[string]string `json:"results"`     // Method to result, e.g. "dkim": "pass"
+}
+
+// parseSpamHeaders fills msg.Spam and msg.ARC from h.
+func parseSpamHeaders(msg *Message, h gomessage.Header) {
+	status := strings.Join(strings.Fields(h.Get("X-Spam-Status")), " ")
+	score := strings.TrimSpace(h.Get("X-Spam-Score"))
+	flag := strings.TrimSpace(h.Get("X-Spam-Flag"))
+	if status != "" || score != "" || flag != "" {
+		msg.Spam = parseSpamVerdict(status, score, flag)
+	}
+
+	fields := h.FieldsByKey("ARC-Authentication-Results")
+	for fields.Next() {
+		if r, ok := parseARCResult(fields.Value()); ok {
+			msg.ARC = append(msg.ARC, r)
+		}
+	}
+}
+
+// parseSpamVerdict parses the values of the X-Spam-Status ("Yes,
+// score=7.3 required=5.0 tests=A,B autolearn=no"), X-Spam-Score and
+// X-Spam-Flag headers, any of which may be empty.
+func parseSpamVerdict(status, score, flag string) *SpamVerdict {
+	v := &SpamVerdict{}
+	verdict, rest, _ := strings.Cut(status, ",")
+	v.Flagged = strings.EqualFold(strings.TrimSpace(verdict), "yes") || strings.EqualFold(flag, "yes")
+	// Long test lists are folded after a comma
+	rest = strings.ReplaceAll(rest, ", ", ",")
+
+	for _, field := range strings.Fields(rest) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "score", "hits":
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				v.Score, v.HasScore = f, true
+			}
+		case "required":
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				v.Threshold = f
+			}
+		case "tests":
+			for _, t := range strings.Split(value, ",") {
+				if t = strings.TrimSpace(t); t != "" && t != "none" {
+					v.Tests = append(v.Tests, t)
+				}
+			}
+		}
+	}
+
+	// X-Spam-Score is more precise where both are set
+	if f, err := strconv.ParseFloat(score, 64); err == nil {
+		v.Score, v.HasScore = f, true
+	}
+	return v
+}
+
+// parseARCResult parses an ARC-Authentication-Results value such as
+// "i=1; mx.example.com; dkim=pass header.d=example.org; spf=pass
+// smtp.mailfrom=example.org". Comments in parentheses are dropped.
+func parseARCResult(value string) (ARCResult, bool) {
+	value = stripHeaderComments(value)
+	parts := strings.Split(value, ";")
+	if len(parts) < 2 {
+		return ARCResult{}, false
+	}
+	inst, ok := strings.CutPrefix(strings.TrimSpace(parts[0]), "i=")
+	if !ok {
+		return ARCResult{}, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(inst))
+	if err != nil {
+		return ARCResult{}, false
+	}
+
+	r := ARCResult{Instance: n, Results: make(map[string]string)}
+	// The authserv-id may carry a version: "mx.example.com 1"
+	if f := strings.Fields(parts[1]); len(f) > 0 {
+		r.AuthServID = f[0]
+	}
+	for _, part := range parts[2:] {
+		f := strings.Fields(part)
+		if len(f) == 0 {
+			continue
+		}
+		method, result, ok := strings.Cut(f[0], "=")
+		if !ok || strings.EqualFold(method, "none") {
+			continue
+		}
+		// "dkim/1=pass" names a method version
+		method, _, _ = strings.Cut(method, "/")
+		method = strings.ToLower(method)
+		if _, dup := r.Results[method]; !dup {
+			r.Results[method] = strings.ToLower(result)
+		}
+	}
+	return r, true
+}
+
+// stripHeaderComments removes (possibly nested) RFC 5322 comments.
+func stripHeaderComments(s string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range s {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
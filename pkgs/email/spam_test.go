@@ -0,0 +1,58 @@
+package email
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSpamHeaders(t *testing.T) {
+	raw := "X-Spam-Status: Yes, score=7.3 required=5.0 tests=BAYES_99,\r\n" +
+		"\tURIBL_BLACK autolearn=no version=3.4.6\r\n" +
+		"X-Spam-Flag: YES\r\n" +
+		"ARC-Authentication-Results: i=2; relay.example.net;\r\n" +
+		" dkim=pass (2048-bit key) header.d=example.org; spf=softfail smtp.mailfrom=example.org\r\n" +
+		"ARC-Authentication-Results: i=1; mx.example.com 1; dmarc=FAIL (p=none) header.from=example.org\r\n" +
+		"Content-Type: text/plain\r\n\r\nbody"
+	msg := &Message{}
+	parseSpamHeaders(msg, parseTestEntity(t, raw).Header)
+
+	want := &SpamVerdict{Flagged: true, Score: 7.3, HasScore: true, Threshold: 5, Tests: []string{"BAYES_99", "URIBL_BLACK"}}
+	if !reflect.DeepEqual(msg.Spam, want) {
+		t.Errorf("Spam = %+v, want %+v", msg.Spam, want)
+	}
+	wantARC := []ARCResult{
+		{Instance: 2, AuthServID: "relay.example.net", Results: map[string]string{"dkim": "pass", "spf": "softfail"}},
+		{Instance: 1, AuthServID: "mx.example.com", Results: map[string]string{"dmarc": "fail"}},
+	}
+	if !reflect.DeepEqual(msg.ARC, wantARC) {
+		t.Errorf("ARC = %+v, want %+v", msg.ARC, wantARC)
+	}
+}
+
+func TestParseSpamHeadersNone(t *testing.T) {
+	msg := &Message{}
+	parseSpamHeaders(msg, parseTestEntity(t, "Subject: hi\r\n\r\nbody").Header)
+	if msg.Spam != nil || msg.ARC != nil {
+		t.Errorf("Spam = %+v, ARC = %+v, want none", msg.Spam, msg.ARC)
+	}
+}
+
+func TestParseSpamVerdict(t *testing.T) {
+	tests := []struct {
+		status, score, flag string
+		want                SpamVerdict
+	}{
+		{"No, score=-0.1 required=5.0 tests=none", "", "", SpamVerdict{Score: -0.1, HasScore: true, Threshold: 5}},
+		{"No, hits=2.5 required=5.0", "", "", SpamVerdict{Score: 2.5, HasScore: true, Threshold: 5}},
+		{"No, score=2.5", "2.54", "", SpamVerdict{Score: 2.54, HasScore: true}},
+		{"", "+12", "", SpamVerdict{Score: 12, HasScore: true}},
+		{"", "", "YES", SpamVerdict{Flagged: true}},
+		{"", "***", "NO", SpamVerdict{}},
+	}
+	for _, tt := range tests {
+		got := parseSpamVerdict(tt.status, tt.score, tt.flag)
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("parseSpamVerdict(%q, %q, %q) = %+v, want %+v", tt.status, tt.score, tt.flag, *got, tt.want)
+		}
+	}
+}
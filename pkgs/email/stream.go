@@ -0,0 +1,159 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	gomessage "github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+)
+
+// ErrBodyTooLarge is returned for messages larger than the MaxBodyBytes of
+// the client fetching them.
+var ErrBodyTooLarge = errors.New("message larger than MaxBodyBytes")
+
+// Kinds of StreamPart. As in Message, the first text/plain and the first
+// text/html part are the bodies and every other part is an attachment.
+const (
+	StreamPartText       = "text"
+	StreamPartHTML       = "html"
+	StreamPartAttachment = "attachment"
+)
+
+// StreamPart is a body or attachment of a message read with a
+// MessageStream.
+type StreamPart struct {
+	Kind        string // StreamPartText, StreamPartHTML or StreamPartAttachment
+	ContentType string
+	Charset     string // Original charset of a body; Body is converted to UTF-8 if it is known
+	Filename    string // Attachments only
+	ContentID   string
+
+	// Body is the decoded content. It is read from the message as the
+	// caller reads it, and only until the next call to NextPart.
+	Body io.Reader
+}
+
+// MessageStream reads a message one part at a time, without holding its
+// bodies and attachments in memory, for consumers that write them
+// elsewhere or only need some of them.
+type MessageStream struct {
+	// Message has the header fields set, as FetchMessage does; TextBody,
+	// HTMLBody, Preview and Attachments stay empty.
+	Message *Message
+
+	single  *gomessage.Entity           // A non-multipart message, until NextPart returns it
+	readers []gomessage.MultipartReader // Nested multiparts being read, innermost last
+	text    bool                        // Whether the text body was returned
+	html    bool                        // Whether the HTML body was returned
+}
+
+// NewMessageStream starts reading the RFC 5322 message r. If maxBytes is
+// positive, reading more than maxBytes bytes of r fails with
+// ErrBodyTooLarge.
+func NewMessageStream(r io.Reader, maxBytes int64) (*MessageStream, error) {
+	if maxBytes > 0 {
+		r = &maxBytesReader{r: r, n: maxBytes}
+	}
+	entity, err := gomessage.Read(r)
+	if !isRecoverableEntityError(err) {
+		return nil, err
+	}
+
+	s := &MessageStream{Message: &Message{}}
+	parseHeaderFields(s.Message, entity.Header)
+	if mr := entity.MultipartReader(); mr != nil {
+		s.readers = []gomessage.MultipartReader{mr}
+	} else {
+		s.single = entity
+	}
+	return s, nil
+}
+
+// NextPart returns the next body or attachment of the message, descending
+// into nested multiparts. It returns io.EOF after the last part.
+func (s *MessageStream) NextPart() (*StreamPart, error) {
+	if e := s.single; e != nil {
+		s.single = nil
+		ct, _, _ := e.Header.ContentType()
+		return s.part(e, ct), nil
+	}
+
+	for len(s.readers) > 0 {
+		part, err := s.readers[len(s.readers)-1].NextPart()
+		if err == io.EOF {
+			s.readers = s.readers[:len(s.readers)-1]
+			continue
+		}
+		if !isRecoverableEntityError(err) {
+			return nil, err
+		}
+		ct, _, _ := part.Header.ContentType()
+		if strings.HasPrefix(ct, "multipart/") {
+			if nested := part.MultipartReader(); nested != nil {
+				s.readers = append(s.readers, nested)
+			}
+			continue
+		}
+		return s.part(part, ct), nil
+	}
+	return nil, io.EOF
+}
+
+// part describes the leaf entity e of content type ct.
+func (s *MessageStream) part(e *gomessage.Entity, ct string) *StreamPart {
+	p := &StreamPart{
+		ContentType: ct,
+		ContentID:   strings.Trim(strings.TrimSpace(e.Header.Get("Content-Id")), "<>"),
+		Body:        e.Body,
+	}
+	switch {
+	case strings.HasPrefix(ct, "text/plain") && !s.text:
+		s.text = true
+		p.Kind, p.Charset = StreamPartText, entityCharset(e.Header)
+	case strings.HasPrefix(ct, "text/html") && !s.html:
+		s.html = true
+		p.Kind, p.Charset = StreamPartHTML, entityCharset(e.Header)
+	default:
+		h := mail.AttachmentHeader{Header: e.Header}
+		p.Kind = StreamPartAttachment
+		p.Filename, _ = h.Filename()
+	}
+	return p
+}
+
+// maxBytesReader reads at most n more bytes from r, failing with
+// ErrBodyTooLarge if r has more.
+type maxBytesReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *maxBytesReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// At the limit: only an empty remainder is fine
+		var b [1]byte
+		n, err := l.r.Read(b[:])
+		if n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// checkBodySize returns ErrBodyTooLarge if a message of size bytes exceeds
+// maxBytes; maxBytes <= 0 means no limit.
+func checkBodySize(size, maxBytes int64) error {
+	if maxBytes > 0 && size > maxBytes {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrBodyTooLarge, size, maxBytes)
+	}
+	return nil
+}
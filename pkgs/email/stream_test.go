@@ -0,0 +1,88 @@
+package email
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMessageStream(t *testing.T) {
+	tests := []struct {
+		name string
+		mail string
+		want []string // Kind, Filename and Body of each part
+	}{
+		{"single", testMailRFC822, []string{"text  Hello, World!"}},
+		{"multipart", testMailMultipart, []string{"text  Plain text body", "attachment test.bin BINARYDATA"}},
+		{"nested", testMailNested, []string{"text  Plain version", "html  <p>HTML version</p>", "attachment image.png PNG-DATA"}},
+	}
+	for _, tt := range tests {
+		s, err := NewMessageStream(strings.NewReader(tt.mail), 0)
+		if err != nil {
+			t.Fatalf("%s: NewMessageStream() error: %v", tt.name, err)
+		}
+		if s.Message.Subject == "" || len(s.Message.From) != 1 {
+			t.Errorf("%s: Message = %+v, want the header fields", tt.name, s.Message)
+		}
+
+		var got []string
+		for {
+			p, err := s.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: NextPart() error: %v", tt.name, err)
+			}
+			b, err := io.ReadAll(p.Body)
+			if err != nil {
+				t.Fatalf("%s: reading %s part: %v", tt.name, p.Kind, err)
+			}
+			got = append(got, p.Kind+" "+p.Filename+" "+strings.TrimSpace(string(b)))
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: parts = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMessageStreamMaxBytes(t *testing.T) {
+	size := int64(len(testMailMultipart))
+	if _, err := readAllParts(testMailMultipart, size); err != nil {
+		t.Errorf("message of exactly MaxBodyBytes: %v", err)
+	}
+	if _, err := readAllParts(testMailMultipart, size-10); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("message over MaxBodyBytes: error = %v, want ErrBodyTooLarge", err)
+	}
+
+	if err := checkBodySize(size, size-1); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("checkBodySize() = %v, want ErrBodyTooLarge", err)
+	}
+	if err := checkBodySize(size, 0); err != nil {
+		t.Errorf("checkBodySize() without limit = %v", err)
+	}
+}
+
+// readAllParts reads every part of mail with a MessageStream limited to
+// maxBytes, returning how many there are.
+func readAllParts(mail string, maxBytes int64) (int, error) {
+	s, err := NewMessageStream(strings.NewReader(mail), maxBytes)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for {
+		p, err := s.NextPart()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if _, err := io.Copy(io.Discard, p.Body); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
@@ -0,0 +1,169 @@
+package email
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"strings"
+
+	gomessage "github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+)
+
+// StrippedPart describes an attachment removed by StripAttachments.
+type StrippedPart struct {
+	Filename    string // "" if the part had none
+	ContentType string
+	Size        int64 // Decoded size
+}
+
+// StripAttachments copies the RFC 5322 message from src to dst with its
+// attachments replaced by short text/plain parts naming the filename, type
+// and size of what was removed, to slim down a mailbox. It returns the
+// removed parts; none means dst is a copy of src.
+//
+// A part is an attachment if its Content-Disposition says so or if it is
+// neither text, multipart nor an encapsulated message, which includes
+// inline images. Everything else is copied byte for byte, headers included.
+// Signed and encrypted multiparts are kept whole, as removing anything from
+// them would break them; a single-part message is never stripped.
+func StripAttachments(dst io.Writer, src io.Reader) ([]StrippedPart, error) {
+	br := bufio.NewReader(src)
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse message header: %w", err)
+	}
+	if err := textproto.WriteHeader(dst, h); err != nil {
+		return nil, err
+	}
+	var stripped []StrippedPart
+	if err := stripBody(dst, h, br, &stripped); err != nil {
+		return nil, err
+	}
+	return stripped, nil
+}
+
+// stripBody copies the body of the entity with header h from r to w,
+// replacing the attachments of a multipart body and adding them to
+// stripped.
+func stripBody(w io.Writer, h textproto.Header, r io.Reader, stripped *[]StrippedPart) error {
+	mediaType, params, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	boundary := params["boundary"]
+	if !strings.HasPrefix(mediaType, "multipart/") || boundary == "" ||
+		mediaType == "multipart/signed" || mediaType == "multipart/encrypted" {
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	mr := textproto.NewMultipartReader(r, boundary)
+	mw := textproto.NewMultipartWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read part: %w", err)
+		}
+		if !isStrippable(part.Header) {
+			pw, err := mw.CreatePart(part.Header)
+			if err != nil {
+				return err
+			}
+			if err := stripBody(pw, part.Header, part, stripped); err != nil {
+				return err
+			}
+			continue
+		}
+
+		sp, err := strippedPart(part.Header, part)
+		if err != nil {
+			return fmt.Errorf("failed to read attachment: %w", err)
+		}
+		*stripped = append(*stripped, sp)
+		var ph textproto.Header
+		ph.Set("Content-Type", "text/plain; charset=utf-8")
+		ph.Set("Content-Disposition", "inline")
+		text := sp.placeholder()
+		if isASCII(text) {
+			ph.Set("Content-Transfer-Encoding", "7bit")
+		} else {
+			ph.Set("Content-Transfer-Encoding", "quoted-printable")
+		}
+		pw, err := mw.CreatePart(ph)
+		if err != nil {
+			return err
+		}
+		if err := writeEncoded(pw, ph.Get("Content-Transfer-Encoding"), text); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// isStrippable reports whether the part with header h is an attachment
+// StripAttachments removes.
+func isStrippable(h textproto.Header) bool {
+	if disposition, _, _ := mime.ParseMediaType(h.Get("Content-Disposition")); disposition == "attachment" {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	switch {
+	case mediaType == "", strings.HasPrefix(mediaType, "text/"),
+		strings.HasPrefix(mediaType, "multipart/"), strings.HasPrefix(mediaType, "message/"):
+		return false
+	}
+	return true
+}
+
+// strippedPart reads the attachment with header h from r and describes it.
+func strippedPart(h textproto.Header, r io.Reader) (StrippedPart, error) {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType = "text/plain"
+	}
+	ah := mail.AttachmentHeader{Header: gomessage.Header{Header: h}}
+	filename, _ := ah.Filename()
+
+	switch strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	}
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return StrippedPart{}, err
+	}
+	return StrippedPart{Filename: filename, ContentType: mediaType, Size: n}, nil
+}
+
+// placeholder returns the text that takes the place of p.
+func (p StrippedPart) placeholder() string {
+	name := p.Filename
+	if name == "" {
+		name = "(no filename)"
+	}
+	return fmt.Sprintf("[Attachment removed: %s, %s, %d bytes]\r\n", name, p.ContentType, p.Size)
+}
+
+// writeEncoded writes text to w in the Content-Transfer-Encoding enc,
+// "7bit" or "quoted-printable".
+func writeEncoded(w io.Writer, enc, text string) error {
+	if enc != "quoted-printable" {
+		_, err := io.WriteString(w, text)
+		return err
+	}
+	qw := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qw, text); err != nil {
+		return err
+	}
+	return qw.Close()
+}
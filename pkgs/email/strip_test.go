@@ -0,0 +1,105 @@
+package email
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const stripSample = "From: alice@example.org\r\n" +
+	"Subject: Report\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"See attached.\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>See attached.</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf; name=\"report.pdf\"\r\n" +
+	"Content-Disposition: attachment; filename=\"report.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0xLjQKc2VjcmV0\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Disposition: attachment; filename*=utf-8''%C3%BCbersicht.png\r\n" +
+	"\r\n" +
+	"PNG\r\n" +
+	"--outer--\r\n"
+
+func TestStripAttachments(t *testing.T) {
+	var out bytes.Buffer
+	stripped, err := StripAttachments(&out, strings.NewReader(stripSample))
+	if err != nil {
+		t.Fatalf("StripAttachments() error: %v", err)
+	}
+
+	want := []StrippedPart{
+		{Filename: "report.pdf", ContentType: "application/pdf", Size: 15},
+		{Filename: "übersicht.png", ContentType: "image/png", Size: 3},
+	}
+	if len(stripped) != len(want) {
+		t.Fatalf("stripped = %+v, want %+v", stripped, want)
+	}
+	for i := range want {
+		if stripped[i] != want[i] {
+			t.Errorf("stripped[%d] = %+v, want %+v", i, stripped[i], want[i])
+		}
+	}
+
+	got := out.String()
+	for _, kept := range []string{
+		"From: alice@example.org\r\nSubject: Report\r\n",
+		"--inner\r\nContent-Type: text/plain; charset=utf-8\r\n\r\nSee attached.\r\n--inner\r\n",
+		"<p>See attached.</p>\r\n--inner--",
+		"[Attachment removed: report.pdf, application/pdf, 15 bytes]",
+		"=C3=BCbersicht.png",
+	} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("stripped message does not contain %q:\n%s", kept, got)
+		}
+	}
+	if strings.Contains(got, "JVBERi0") || strings.Contains(got, "PNG\r\n") {
+		t.Errorf("stripped message still contains an attachment:\n%s", got)
+	}
+
+	// The result parses, with the placeholders as the only other parts
+	msg, err := ParseBody(strings.NewReader(got))
+	if err != nil {
+		t.Fatalf("ParseBody() error: %v", err)
+	}
+	if strings.TrimSpace(msg.TextBody) != "See attached." {
+		t.Errorf("parsed text body = %q", msg.TextBody)
+	}
+	for _, a := range msg.Attachments {
+		if a.ContentType != "text/plain" || a.Filename != "" {
+			t.Errorf("parsed stripped message has attachment %s (%s)", a.Filename, a.ContentType)
+		}
+	}
+}
+
+func TestStripAttachments_Unchanged(t *testing.T) {
+	for _, src := range []string{
+		"Subject: plain\r\nContent-Type: application/pdf\r\n\r\nJVBERi0=\r\n",
+		"Subject: signed\r\nContent-Type: multipart/signed; boundary=b; protocol=\"application/pgp-signature\"\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain\r\n\r\nhi\r\n--b\r\nContent-Type: application/pgp-signature\r\n\r\nsig\r\n--b--\r\n",
+	} {
+		var out bytes.Buffer
+		stripped, err := StripAttachments(&out, strings.NewReader(src))
+		if err != nil {
+			t.Fatalf("StripAttachments() error: %v", err)
+		}
+		if len(stripped) != 0 || out.String() != src {
+			t.Errorf("StripAttachments() = %+v, %q, want the message unchanged", stripped, out.String())
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package email
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Part is a node of a message's MIME tree, as described by the IMAP
+// BODYSTRUCTURE response.
+type Part struct {
+	// Number is the IMAP part specifier ("1", "2.1", ...) usable in
+	// BODY[<number>] fetches. It is "" for a multipart message root; the
+	// body of a single-part message is part "1".
+	Number string `json:"number"`
+
+	ContentType string            `json:"content_type"`          // Lower-case media type, e.g. "text/plain" or "multipart/alternative"
+	Params      map[string]string `json:"params,omitempty"`      // Content-Type parameters such as charset or boundary
+	ID          string            `json:"id,omitempty"`          // Content-ID
+	Description string            `json:"description,omitempty"` // Content-Description
+	Encoding    string            `json:"encoding,omitempty"`    // Content-Transfer-Encoding (single parts)
+	Size        uint32            `json:"size,omitempty"`        // Encoded size in bytes (single parts)
+	Lines       int64             `json:"lines,omitempty"`       // Line count of text and message/rfc822 parts
+
+	// Content-Disposition, if the server reports extension data
+	Disposition string `json:"disposition,omitempty"` // "inline", "attachment" or ""
+	Filename    string `json:"filename,omitempty"`    // From the disposition or the Content-Type name parameter
+
+	// Parts holds the children of a multipart part, or the body of an
+	// encapsulated message/rfc822 part.
+	Parts []*Part `json:"parts,omitempty"`
+}
+
+// IsMultipart reports whether the part is a multipart container.
+func (p *Part) IsMultipart() bool {
+	return strings.HasPrefix(p.ContentType, "multipart/")
+}
+
+// Walk calls fn for p and then for every descendant, depth first. depth is
+// 0 for p itself.
+func (p *Part) Walk(fn func(part *Part, depth int)) {
+	p.walk(fn, 0)
+}
+
+func (p *Part) walk(fn func(part *Part, depth int), depth int) {
+	fn(p, depth)
+	for _, child := range p.Parts {
+		child.walk(fn, depth+1)
+	}
+}
+
+// Find returns the part with the given number, or nil.
+func (p *Part) Find(number string) *Part {
+	var found *Part
+	p.Walk(func(part *Part, _ int) {
+		// An encapsulated multipart shares its number with the
+		// message/rfc822 part: the outer one wins.
+		if found == nil && part.Number == number {
+			found = part
+		}
+	})
+	return found
+}
+
+// childPartNumber returns the number of the n-th (1-based) child of the
+// part numbered parent.
+func childPartNumber(parent string, n int) string {
+	if parent == "" {
+		return strconv.Itoa(n)
+	}
+	return parent + "." + strconv.Itoa(n)
+}
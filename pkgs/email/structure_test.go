@@ -0,0 +1,56 @@
+package email
+
+import "testing"
+
+func TestPartFind(t *testing.T) {
+	// multipart/mixed with a forwarded multipart message as part 2
+	root := &Part{ContentType: "multipart/mixed", Parts: []*Part{
+		{Number: "1", ContentType: "text/plain"},
+		{Number: "2", ContentType: "message/rfc822", Parts: []*Part{
+			{Number: "2", ContentType: "multipart/alternative", Parts: []*Part{
+				{Number: "2.1", ContentType: "text/plain"},
+				{Number: "2.2", ContentType: "text/html"},
+			}},
+		}},
+	}}
+
+	if p := root.Find("2"); p == nil || p.ContentType != "message/rfc822" {
+		t.Errorf("Find(2) = %+v, want the message/rfc822 part", p)
+	}
+	if p := root.Find("2.2"); p == nil || p.ContentType != "text/html" {
+		t.Errorf("Find(2.2) = %+v", p)
+	}
+	if p := root.Find("3"); p != nil {
+		t.Errorf("Find(3) = %+v, want nil", p)
+	}
+
+	var depths []int
+	root.Walk(func(_ *Part, depth int) { depths = append(depths, depth) })
+	want := []int{0, 1, 1, 2, 3, 3}
+	if len(depths) != len(want) {
+		t.Fatalf("Walk depths = %v, want %v", depths, want)
+	}
+	for i := range want {
+		if depths[i] != want[i] {
+			t.Fatalf("Walk depths = %v, want %v", depths, want)
+		}
+	}
+}
+
+func TestChildPartNumber(t *testing.T) {
+	tests := []struct {
+		parent string
+		n      int
+		want   string
+	}{
+		{"", 1, "1"},
+		{"", 3, "3"},
+		{"2", 1, "2.1"},
+		{"1.2", 4, "1.2.4"},
+	}
+	for _, tt := range tests {
+		if got := childPartNumber(tt.parent, tt.n); got != tt.want {
+			t.Errorf("childPartNumber(%q, %d) = %q, want %q", tt.parent, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package email
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// SyncFilter selects what sync and export copy from the server: folders by
+// name, and messages by age and size.
+type SyncFilter struct {
+	Include []string  // Folder patterns to copy; empty selects every folder
+	Exclude []string  // Folder patterns to skip, even if included
+	Since   time.Time // Skip messages received before this; zero for no limit
+	MaxSize int64     // Skip messages larger than this many bytes; 0 for no limit
+}
+
+// MatchFolder reports whether the filter selects f. A pattern is a logical
+// folder name matching f.Role, or a glob matched against the folder name as
+// by path.Match. A pattern matching a folder also matches its subfolders,
+// so excluding "Archive" skips "Archive/2024" as well.
+func (s SyncFilter) MatchFolder(f Folder) bool {
+	if f.NoSelect {
+		return false
+	}
+	if len(s.Include) > 0 && !matchFolderPatterns(s.Include, f) {
+		return false
+	}
+	return !matchFolderPatterns(s.Exclude, f)
+}
+
+// MatchMessage reports whether the filter selects a message received at
+// date with size bytes.
+func (s SyncFilter) MatchMessage(date time.Time, size int64) bool {
+	if !s.Since.IsZero() && !date.IsZero() && date.Before(s.Since) {
+		return false
+	}
+	return s.MaxSize <= 0 || size <= s.MaxSize
+}
+
+func matchFolderPatterns(patterns []string, f Folder) bool {
+	// The folder and its parents: "A/B/C", "A/B", "A"
+	names := []string{f.Name}
+	if f.Delim != 0 {
+		for name := f.Name; ; {
+			i := strings.LastIndex(name, string(f.Delim))
+			if i <= 0 {
+				break
+			}
+			name = name[:i]
+			names = append(names, name)
+		}
+	}
+
+	for _, pattern := range patterns {
+		if role := strings.ToLower(pattern); IsFolderRole(role) && role == f.Role {
+			return true
+		}
+		for _, name := range names {
+			if pattern == name || strings.EqualFold(name, "INBOX") && strings.EqualFold(pattern, "INBOX") {
+				return true
+			}
+			// Names like "[Gmail]/Sent Mail" are not valid globs; compared above
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
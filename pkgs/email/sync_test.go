@@ -0,0 +1,58 @@
+package email
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSyncFilterMatchFolder(t *testing.T) {
+	filter := SyncFilter{
+		Include: []string{"inbox", "sent", "Lists/*", "[Gmail]/Starred"},
+		Exclude: []string{"Lists/spam*"},
+	}
+	tests := []struct {
+		folder Folder
+		want   bool
+	}{
+		{Folder{Name: "INBOX", Role: FolderInbox}, true},
+		{Folder{Name: "Sent Items", Role: FolderSent}, true},
+		{Folder{Name: "Lists/golang", Delim: '/'}, true},
+		{Folder{Name: "Lists/golang/nuts", Delim: '/'}, true}, // Subfolder of an included folder
+		{Folder{Name: "Lists/spam-reports", Delim: '/'}, false},
+		{Folder{Name: "[Gmail]/Starred", Delim: '/'}, true}, // Not a valid glob, compared as a name
+		{Folder{Name: "Archive", Delim: '/', Role: FolderArchive}, false},
+		{Folder{Name: "Lists", Delim: '/', NoSelect: true}, false},
+	}
+	for _, tt := range tests {
+		if got := filter.MatchFolder(tt.folder); got != tt.want {
+			t.Errorf("MatchFolder(%q) = %v, want %v", tt.folder.Name, got, tt.want)
+		}
+	}
+
+	// Without include patterns everything not excluded is selected
+	skipArchive := SyncFilter{Exclude: []string{"Archive"}}
+	if !skipArchive.MatchFolder(Folder{Name: "INBOX"}) {
+		t.Error("INBOX should be selected")
+	}
+	if skipArchive.MatchFolder(Folder{Name: "Archive.2024", Delim: '.'}) {
+		t.Error("subfolder of an excluded folder should be skipped")
+	}
+}
+
+func TestSyncFilterMatchMessage(t *testing.T) {
+	now := time.Now()
+	filter := SyncFilter{Since: now.AddDate(0, 0, -30), MaxSize: 1000}
+
+	if !filter.MatchMessage(now, 1000) {
+		t.Error("recent message at the size limit should match")
+	}
+	if filter.MatchMessage(now, 1001) {
+		t.Error("oversized message should not match")
+	}
+	if filter.MatchMessage(now.AddDate(0, 0, -31), 10) {
+		t.Error("old message should not match")
+	}
+	if !(SyncFilter{}).MatchMessage(time.Time{}, 1<<40) {
+		t.Error("zero filter should match everything")
+	}
+}
@@ -1,67 +1,5 @@
 package email
 
-import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/tls"
-	"crypto/x509"
-	"math/big"
-	"net"
-	"strconv"
-	"testing"
-	"time"
-)
-
-// newTestTLSConfig generates a self-signed TLS config for mock servers.
-func newTestTLSConfig(t *testing.T) *tls.Config {
-	t.Helper()
-
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	tmpl := &x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		DNSNames:     []string{"localhost", "127.0.0.1"},
-		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
-		NotBefore:    time.Now().Add(-time.Hour),
-		NotAfter:     time.Now().Add(24 * time.Hour),
-	}
-	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	cert := tls.Certificate{
-		Certificate: [][]byte{certDER},
-		PrivateKey:  key,
-	}
-	return &tls.Config{
-		Certificates: []tls.Certificate{cert},
-	}
-}
-
-// insecureTLSConfig returns a client-side TLS config that skips verification.
-func insecureTLSConfig() *tls.Config {
-	return &tls.Config{InsecureSkipVerify: true}
-}
-
-// splitHostPort splits "host:port" into (host, int port).
-func splitHostPort(t *testing.T, addr string) (string, int) {
-	t.Helper()
-	host, portStr, err := net.SplitHostPort(addr)
-	if err != nil {
-		t.Fatal(err)
-	}
-	port, err := strconv.Atoi(portStr)
-	if err != nil {
-		t.Fatal(err)
-	}
-	return host, port
-}
-
 // testMailRFC822 is a minimal RFC 5322 message for testing.
 const testMailRFC822 = "MIME-Version: 1.0\r\n" +
 	"From: sender@example.com\r\n" +
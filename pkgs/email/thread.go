@@ -0,0 +1,68 @@
+package email
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// ErrMessageNotFound is returned when a message looked up by Message-ID
+// does not exist.
+var ErrMessageNotFound = errors.New("message not found")
+
+// ReplyFolders are the folders searched for the message a reply answers:
+// it was received, or it is our own earlier message of the thread.
+var ReplyFolders = []string{FolderInbox, FolderSent, FolderArchive}
+
+// maxReferences bounds the References of a reply. Longer chains keep the
+// thread root and the most recent messages, as RFC 5322 suggests.
+const maxReferences = 20
+
+// reReplyPrefix matches the reply and forward markers clients put before a
+// subject, repeated: "Re: ", "RE[2]: ", "Aw: Fwd: ".
+var reReplyPrefix = regexp.MustCompile(`(?i)^\s*((re|aw|sv|fwd?)(\[\d+\])?\s*:\s*)+`)
+
+// ReplySubject returns the subject of a reply to a message with subject:
+// a single "Re: " before it, replacing the markers it already has.
+func ReplySubject(subject string) string {
+	return "Re: " + reReplyPrefix.ReplaceAllString(strings.TrimSpace(subject), "")
+}
+
+// ThreadReply fills the threading headers of a reply to parent: In-Reply-To
+// is parent's Message-ID, References is parent's References followed by
+// its Message-ID, and an empty Subject becomes ReplySubject of parent's.
+// References already in opts are kept.
+func ThreadReply(opts SendOptions, parent *Message) SendOptions {
+	id := trimMsgID(parent.MessageID)
+	if id != "" {
+		opts.InReplyTo = id
+	}
+	if len(opts.References) == 0 {
+		refs := make([]string, 0, len(parent.References)+1)
+		seen := make(map[string]bool)
+		for _, ref := range parent.References {
+			ref = trimMsgID(ref)
+			if ref != "" && !seen[ref] {
+				seen[ref] = true
+				refs = append(refs, ref)
+			}
+		}
+		if id != "" && !seen[id] {
+			refs = append(refs, id)
+		}
+		if len(refs) > maxReferences {
+			refs = append(refs[:1], refs[len(refs)-maxReferences+1:]...)
+		}
+		opts.References = refs
+	}
+	if opts.Subject == "" && parent.Subject != "" {
+		opts.Subject = ReplySubject(parent.Subject)
+	}
+	return opts
+}
+
+// trimMsgID returns a Message-ID without surrounding space and angle
+// brackets.
+func trimMsgID(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
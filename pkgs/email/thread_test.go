@@ -0,0 +1,62 @@
+package email
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestReplySubject(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Hello", "Re: Hello"},
+		{"Re: Hello", "Re: Hello"},
+		{"RE: re: Hello", "Re: Hello"},
+		{"Re[2]: Hello", "Re: Hello"},
+		{"Aw: Fwd: Hello", "Re: Hello"},
+		{"  Fw: Hello ", "Re: Hello"},
+		{"Regarding: Hello", "Re: Regarding: Hello"},
+	}
+	for _, tt := range tests {
+		if got := ReplySubject(tt.in); got != tt.want {
+			t.Errorf("ReplySubject(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestThreadReply(t *testing.T) {
+	parent := &Message{
+		MessageID:  "<c@x>",
+		References: []string{"a@x", "<b@x>", "a@x"},
+		Subject:    "Re: Plans",
+	}
+	got := ThreadReply(SendOptions{InReplyTo: "c@x"}, parent)
+	if got.InReplyTo != "c@x" {
+		t.Errorf("InReplyTo = %q, want c@x", got.InReplyTo)
+	}
+	if want := []string{"a@x", "b@x", "c@x"}; !reflect.DeepEqual(got.References, want) {
+		t.Errorf("References = %q, want %q", got.References, want)
+	}
+	if got.Subject != "Re: Plans" {
+		t.Errorf("Subject = %q, want %q", got.Subject, "Re: Plans")
+	}
+
+	// The caller's subject and references win
+	got = ThreadReply(SendOptions{Subject: "New topic", References: []string{"z@x"}}, parent)
+	if got.Subject != "New topic" || !reflect.DeepEqual(got.References, []string{"z@x"}) {
+		t.Errorf("ThreadReply overrode the caller: %q %q", got.Subject, got.References)
+	}
+}
+
+func TestThreadReplyLongChain(t *testing.T) {
+	parent := &Message{MessageID: "last@x"}
+	for i := 0; i < 30; i++ {
+		parent.References = append(parent.References, fmt.Sprintf("m%d@x", i))
+	}
+	refs := ThreadReply(SendOptions{}, parent).References
+	if len(refs) != maxReferences {
+		t.Fatalf("%d references, want %d", len(refs), maxReferences)
+	}
+	if refs[0] != "m0@x" || refs[1] != "m12@x" || refs[len(refs)-1] != "last@x" {
+		t.Errorf("References = %q, want the root and the latest", refs)
+	}
+}
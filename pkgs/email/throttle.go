@@ -0,0 +1,139 @@
+package email
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Throttle is a token bucket limiting the IMAP commands Watch sends while
+// it works through unprocessed emails, so catching up on a large backlog
+// does not trip the provider's rate limits. It adapts to the server: a
+// throttling response halves the rate, and successful commands raise it
+// back to the configured one step by step.
+type Throttle struct {
+	mu     sync.Mutex
+	max    float64 // Configured rate, commands per second
+	rate   float64 // Current rate, lowered after throttling responses
+	burst  float64
+	tokens float64
+	last   time.Time // Last refill
+	pause  time.Time // No commands before this, after a suggested backoff
+	now    func() time.Time
+}
+
+// NewThrottle creates a Throttle allowing rate commands per second on
+// average and burst commands at once.
+func NewThrottle(rate float64, burst int) *Throttle {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Throttle{
+		max:    rate,
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// Wait blocks until the next command may be sent, or ctx is done.
+func (t *Throttle) Wait(ctx context.Context) error {
+	d := t.reserve()
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (t *Throttle) reserve() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if !t.last.IsZero() {
+		t.tokens = math.Min(t.burst, t.tokens+now.Sub(t.last).Seconds()*t.rate)
+	}
+	t.last = now
+	t.tokens--
+
+	var wait time.Duration
+	if t.tokens < 0 {
+		wait = time.Duration(-t.tokens / t.rate * float64(time.Second))
+	}
+	if p := t.pause.Sub(now); p > wait {
+		wait = p
+	}
+	return wait
+}
+
+// Report adapts the rate to the outcome of a command: a throttling
+// response (see IsThrottled) halves it, down to 1/32 of the configured
+// rate, and pauses for the backoff the server suggests, if any; a success
+// raises it by 1/20 of the configured rate. It reports whether the rate
+// was lowered.
+func (t *Throttle) Report(err error) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil {
+		t.rate = math.Min(t.max, t.rate+t.max/20)
+		return false
+	}
+	if !IsThrottled(err) {
+		return false
+	}
+	t.rate = math.Max(t.rate/2, t.max/32)
+	t.tokens = math.Min(t.tokens, 0)
+	if d := suggestedBackoff(err); d > 0 {
+		t.pause = t.now().Add(d)
+	}
+	return true
+}
+
+// Rate returns the current rate in commands per second.
+func (t *Throttle) Rate() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rate
+}
+
+// IsThrottled reports whether err is a server refusing a command because
+// of rate or bandwidth limits: the RFC 5530 [UNAVAILABLE] and [LIMIT]
+// response codes, or the wording of Gmail ("exceeded command or bandwidth
+// limits") and Outlook ("Request is throttled").
+func IsThrottled(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"throttl", "[unavailable]", "[limit]", "bandwidth limit", "rate limit", "too many requests"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+var backoffPattern = regexp.MustCompile(`(?i)backoff time:\s*(\d+)\s*milliseconds`)
+
+// suggestedBackoff returns the backoff a throttling response asks for, as
+// in Outlook's "Suggested Backoff Time: 1234 milliseconds", or 0.
+func suggestedBackoff(err error) time.Duration {
+	m := backoffPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	ms, _ := strconv.Atoi(m[1])
+	return time.Duration(ms) * time.Millisecond
+}
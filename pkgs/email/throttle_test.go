@@ -0,0 +1,81 @@
+package email
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestThrottleReserve(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	th := NewThrottle(2, 2)
+	th.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if d := th.reserve(); d != 0 {
+			t.Fatalf("burst command %d waits %v", i, d)
+		}
+	}
+	if d := th.reserve(); d != 500*time.Millisecond {
+		t.Errorf("wait after burst = %v, want 500ms", d)
+	}
+
+	now = now.Add(2 * time.Second)
+	if d := th.reserve(); d != 0 {
+		t.Errorf("wait after refill = %v, want 0", d)
+	}
+}
+
+func TestThrottleReport(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	th := NewThrottle(8, 1)
+	th.now = func() time.Time { return now }
+
+	if th.Report(errors.New("NO [NONEXISTENT] no such mailbox")) {
+		t.Error("unrelated error lowered the rate")
+	}
+	if !th.Report(errors.New("NO [UNAVAILABLE] Request is throttled. Suggested Backoff Time: 1500 milliseconds")) {
+		t.Fatal("throttling response did not lower the rate")
+	}
+	if r := th.Rate(); r != 4 {
+		t.Errorf("rate = %v, want 4", r)
+	}
+	if d := th.reserve(); d != 1500*time.Millisecond {
+		t.Errorf("wait = %v, want the suggested 1.5s", d)
+	}
+
+	for i := 0; i < 10; i++ {
+		th.Report(errors.New("NO [LIMIT] too many requests"))
+	}
+	if r := th.Rate(); r != 0.25 {
+		t.Errorf("rate = %v, want floor 0.25", r)
+	}
+	for i := 0; i < 30; i++ {
+		th.Report(nil)
+	}
+	if r := th.Rate(); r != 8 {
+		t.Errorf("rate = %v, want restored 8", r)
+	}
+}
+
+func TestIsThrottled(t *testing.T) {
+	tests := []struct {
+		err  string
+		want bool
+	}{
+		{"NO [UNAVAILABLE] Temporary failure", true},
+		{"NO [LIMIT] Too many commands", true},
+		{"NO Account exceeded command or bandwidth limits", true},
+		{"BAD Request is throttled", true},
+		{"NO [NONEXISTENT] Unknown mailbox", false},
+		{"connection reset by peer", false},
+	}
+	for _, tt := range tests {
+		if got := IsThrottled(errors.New(tt.err)); got != tt.want {
+			t.Errorf("IsThrottled(%q) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+	if IsThrottled(nil) {
+		t.Error("IsThrottled(nil) = true")
+	}
+}
@@ -0,0 +1,54 @@
+package email
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCertificateMismatch is returned when a server's certificate does not
+// have the fingerprint pinned with PinCertificate.
+var ErrCertificateMismatch = errors.New("server certificate does not match the pinned fingerprint")
+
+// PinCertificate returns a copy of cfg, or of the default config if cfg is
+// nil, that only accepts servers whose leaf certificate has the SHA-256
+// fingerprint. The standard verification of the chain and host name still
+// applies, unless pinOnly is set for servers with self-signed certificates.
+func PinCertificate(cfg *tls.Config, fingerprint []byte, pinOnly bool) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if pinOnly {
+		cfg.InsecureSkipVerify = true
+	}
+	verify := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return fmt.Errorf("%w: no certificate presented", ErrCertificateMismatch)
+		}
+		sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+		if !bytes.Equal(sum[:], fingerprint) {
+			return fmt.Errorf("%w: the server presented %s", ErrCertificateMismatch, formatFingerprint(sum[:]))
+		}
+		if verify != nil {
+			return verify(cs)
+		}
+		return nil
+	}
+	return cfg
+}
+
+// formatFingerprint formats a fingerprint as colon-separated hex, like
+// openssl, so it can be compared with the configured one.
+func formatFingerprint(fp []byte) string {
+	parts := make([]string, len(fp))
+	for i, b := range fp {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
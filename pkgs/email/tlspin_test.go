@@ -0,0 +1,50 @@
+package email
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/emx-mail/cli/pkgs/testutil"
+)
+
+func TestPinCertificate(t *testing.T) {
+	serverCfg := testutil.NewTLSConfig(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	sum := sha256.Sum256(serverCfg.Certificates[0].Certificate[0])
+	wrong := sha256.Sum256([]byte("another certificate"))
+	dial := func(cfg *tls.Config) error {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), clientTLSConfig(cfg, "localhost"))
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	if err := dial(PinCertificate(nil, sum[:], true)); err != nil {
+		t.Errorf("pinned self-signed certificate: %v", err)
+	}
+	if err := dial(PinCertificate(nil, wrong[:], true)); !errors.Is(err, ErrCertificateMismatch) {
+		t.Errorf("wrong fingerprint: error = %v, want ErrCertificateMismatch", err)
+	}
+	// Without pinOnly the self-signed certificate still fails verification
+	if err := dial(PinCertificate(nil, sum[:], false)); err == nil || errors.Is(err, ErrCertificateMismatch) {
+		t.Errorf("pinned certificate failing verification: error = %v", err)
+	}
+}
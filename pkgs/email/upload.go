@@ -0,0 +1,279 @@
+package email
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrMessageTooLarge is returned when a composed message exceeds
+// SMTPConfig.MaxMessageSize and its attachments cannot be uploaded instead.
+var ErrMessageTooLarge = errors.New("message exceeds maximum size")
+
+// Uploader stores an attachment outside the message and returns the URL
+// recipients can download it from. It is used when a message with
+// attachments exceeds SMTPConfig.MaxMessageSize.
+type Uploader interface {
+	Upload(name string, r io.Reader, size int64) (url string, err error)
+}
+
+// uploadPlaceholder stands for the download link of an attachment until
+// it is uploaded.
+const uploadPlaceholder = "(link added when sent)"
+
+// uploadAttachments uploads atts with the configured Uploader and returns
+// their download URLs.
+func (c *SMTPClient) uploadAttachments(atts []AttachmentPath) ([]string, error) {
+	urls := make([]string, len(atts))
+	for i, att := range atts {
+		url, err := uploadFile(c.config.Uploader, attachmentName(att), att.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload attachment %s: %w", att.Path, err)
+		}
+		urls[i] = url
+	}
+	return urls, nil
+}
+
+// attachmentLinks returns opts without attachments and with a list of
+// links to them, urls in order, appended to the text and HTML bodies. With
+// nil urls the links are uploadPlaceholder.
+func attachmentLinks(opts SendOptions, urls []string) (SendOptions, error) {
+	var text, htm strings.Builder
+	text.WriteString("\n\nAttachments:\n")
+	htm.WriteString("\n<p>Attachments:</p>\n<ul>\n")
+	for i, att := range opts.Attachments {
+		name := attachmentName(att)
+		fi, err := os.Stat(att.Path)
+		if err != nil {
+			return opts, fmt.Errorf("failed to read attachment %s: %w", att.Path, err)
+		}
+		size := fi.Size()
+		url := uploadPlaceholder
+		if urls != nil {
+			url = urls[i]
+		}
+		fmt.Fprintf(&text, "  %s (%d bytes): %s\n", name, size, url)
+		fmt.Fprintf(&htm, "<li><a href=\"%s\">%s</a> (%d bytes)</li>\n",
+			html.EscapeString(url), html.EscapeString(name), size)
+	}
+	htm.WriteString("</ul>\n")
+
+	opts.Attachments = nil
+	if opts.TextBody != "" || opts.HTMLBody == "" {
+		opts.TextBody += text.String()
+	}
+	if opts.HTMLBody != "" {
+		opts.HTMLBody += htm.String()
+	}
+	return opts, nil
+}
+
+// attachmentName returns the file name recipients see for att.
+func attachmentName(att AttachmentPath) string {
+	if att.Filename != "" {
+		return att.Filename
+	}
+	return filepath.Base(att.Path)
+}
+
+func uploadFile(u Uploader, name, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return u.Upload(name, f, fi.Size())
+}
+
+// HTTPPutUploader uploads with a plain HTTP PUT to BaseURL/<random>/<name>.
+// It works with WebDAV servers and any endpoint accepting PUT.
+type HTTPPutUploader struct {
+	BaseURL   string // Upload location, e.g. "https://dav.example.com/outgoing"
+	PublicURL string // Base of the download links; defaults to BaseURL
+	Username  string // Basic auth user (WebDAV)
+	Password  string
+	Token     string // Bearer token, used instead of basic auth when set
+
+	Client *http.Client // Defaults to http.DefaultClient
+}
+
+// Upload implements Uploader.
+func (u *HTTPPutUploader) Upload(name string, r io.Reader, size int64) (string, error) {
+	key := awsURIEscape(uploadKey(name))
+	req, err := http.NewRequest(http.MethodPut, joinURL(u.BaseURL, key), r)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	switch {
+	case u.Token != "":
+		req.Header.Set("Authorization", "Bearer "+u.Token)
+	case u.Username != "":
+		req.SetBasicAuth(u.Username, u.Password)
+	}
+
+	if err := doUpload(u.Client, req); err != nil {
+		return "", err
+	}
+
+	base := u.PublicURL
+	if base == "" {
+		base = u.BaseURL
+	}
+	return joinURL(base, key), nil
+}
+
+// S3Uploader uploads to an S3-compatible bucket with a SigV4-signed PUT,
+// using path-style addressing so custom endpoints (MinIO, R2, ...) work.
+type S3Uploader struct {
+	Endpoint  string // e.g. "https://s3.eu-west-1.amazonaws.com"; defaults to AWS for Region
+	Region    string
+	Bucket    string
+	Prefix    string // Key prefix, e.g. "mail/"
+	AccessKey string
+	SecretKey string
+	PublicURL string // Base of the download links; defaults to Endpoint/Bucket
+
+	Client *http.Client // Defaults to http.DefaultClient
+	now    func() time.Time
+}
+
+// Upload implements Uploader.
+func (u *S3Uploader) Upload(name string, r io.Reader, size int64) (string, error) {
+	endpoint := strings.TrimRight(u.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://s3." + u.Region + ".amazonaws.com"
+	}
+	key := u.Prefix + uploadKey(name)
+	path := "/" + awsURIEscape(u.Bucket) + "/" + awsURIEscape(key)
+
+	req, err := http.NewRequest(http.MethodPut, endpoint+path, r)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	u.sign(req, path)
+
+	if err := doUpload(u.Client, req); err != nil {
+		return "", err
+	}
+
+	if u.PublicURL != "" {
+		return joinURL(u.PublicURL, awsURIEscape(key)), nil
+	}
+	return endpoint + path, nil
+}
+
+// sign adds AWS Signature Version 4 headers for an unsigned-payload PUT.
+func (u *S3Uploader) sign(req *http.Request, path string) {
+	now := time.Now
+	if u.now != nil {
+		now = u.now
+	}
+	t := now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	day := t.Format("20060102")
+
+	const payloadHash = "UNSIGNED-PAYLOAD"
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		"", // no query string
+		"content-type:" + req.Header.Get("Content-Type"),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + u.Region + "/s3/aws4_request"
+	crHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+u.SecretKey), day)
+	key = hmacSHA256(key, u.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		u.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsURIEscape percent-encodes s as SigV4 requires: everything except
+// unreserved characters, keeping "/" as the path separator.
+func awsURIEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// uploadKey returns "<random>/<name>": the random directory keeps uploads
+// from colliding and makes links hard to guess.
+func uploadKey(name string) string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	name = strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	if name == "" {
+		name = "attachment"
+	}
+	return hex.EncodeToString(b) + "/" + name
+}
+
+// joinURL joins base and an already escaped path with exactly one slash.
+func joinURL(base, path string) string {
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+func doUpload(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("upload failed: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package email
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPPutUploader(t *testing.T) {
+	var gotPath, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("method = %s, want PUT", r.Method)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "u" || pass != "p" {
+			t.Errorf("basic auth = %q %q %v", user, pass, ok)
+		}
+		b, _ := io.ReadAll(r.Body)
+		gotPath, gotBody = r.URL.EscapedPath(), string(b)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	u := &HTTPPutUploader{
+		BaseURL:   srv.URL + "/dav/",
+		PublicURL: "https://files.example.com/",
+		Username:  "u",
+		Password:  "p",
+	}
+	url, err := u.Upload("my report.pdf", strings.NewReader("data"), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotBody != "data" {
+		t.Errorf("uploaded body = %q", gotBody)
+	}
+	if !strings.HasPrefix(gotPath, "/dav/") || !strings.HasSuffix(gotPath, "/my%20report.pdf") {
+		t.Errorf("upload path = %q", gotPath)
+	}
+	if want := "https://files.example.com" + strings.TrimPrefix(gotPath, "/dav"); url != want {
+		t.Errorf("url = %q, want %q", url, want)
+	}
+}
+
+func TestHTTPPutUploader_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	u := &HTTPPutUploader{BaseURL: srv.URL}
+	if _, err := u.Upload("a.txt", strings.NewReader("x"), 1); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Upload() error = %v, want 403", err)
+	}
+}
+
+func TestS3Uploader(t *testing.T) {
+	var gotReq *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotReq = r
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer srv.Close()
+
+	u := &S3Uploader{
+		Endpoint:  srv.URL,
+		Region:    "eu-west-1",
+		Bucket:    "mail",
+		Prefix:    "out/",
+		AccessKey: "AKID",
+		SecretKey: "secret",
+		now:       func() time.Time { return time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC) },
+	}
+	url, err := u.Upload("a b.txt", strings.NewReader("x"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := gotReq.URL.EscapedPath()
+	if !strings.HasPrefix(path, "/mail/out/") || !strings.HasSuffix(path, "/a%20b.txt") {
+		t.Errorf("path = %q", path)
+	}
+	if url != srv.URL+path {
+		t.Errorf("url = %q, want %q", url, srv.URL+path)
+	}
+	if got := gotReq.Header.Get("X-Amz-Date"); got != "20240305T100000Z" {
+		t.Errorf("X-Amz-Date = %q", got)
+	}
+	auth := gotReq.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/20240305/eu-west-1/s3/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, Signature=") {
+		t.Errorf("Authorization = %q", auth)
+	}
+}
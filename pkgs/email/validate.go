@@ -0,0 +1,500 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Issue severities reported by Validate.
+const (
+	SeverityError   = "error"   // The message violates RFC 5322 or MIME
+	SeverityWarning = "warning" // Allowed, but likely to cause trouble
+)
+
+// Issue is a problem found by Validate.
+type Issue struct {
+	Severity string `json:"severity"`       // SeverityError or SeverityWarning
+	Line     int    `json:"line,omitempty"` // 1-based input line, 0 if it concerns the whole message
+	Part     string `json:"part,omitempty"` // IMAP part number; "" for the top-level message
+	Message  string `json:"message"`
+}
+
+func (i Issue) String() string {
+	s := i.Severity + ": " + i.Message
+	if i.Part != "" {
+		s += " (part " + i.Part + ")"
+	}
+	if i.Line > 0 {
+		s = fmt.Sprintf("line %d: %s", i.Line, s)
+	}
+	return s
+}
+
+// Validate checks an RFC 5322 message: header syntax, the required and
+// single-instance headers, the MIME structure (boundaries, transfer
+// encodings, encapsulated messages), line lengths, and 8-bit data in parts
+// not declared as 8bit or binary. Issues are sorted by line. The error is
+// only for failing to read r.
+func Validate(r io.Reader) ([]Issue, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	v := &validator{}
+	if len(data) == 0 {
+		v.add(SeverityError, 0, "", "empty message")
+		return v.issues, nil
+	}
+
+	lines, bareLF := splitLines(data)
+	if bareLF > 0 {
+		v.add(SeverityWarning, 0, "", "%d lines end in a bare LF instead of CRLF", bareLF)
+	}
+	v.entity(lines, "", true)
+
+	sort.SliceStable(v.issues, func(i, j int) bool {
+		return v.issues[i].Line < v.issues[j].Line
+	})
+	return v.issues, nil
+}
+
+// Line length limits from RFC 5322 section 2.1.1.
+const (
+	maxLineLength         = 998
+	recommendedLineLength = 78
+)
+
+// messageSingletons may appear at most once in a message header
+// (RFC 5322 section 3.6).
+var messageSingletons = []string{
+	"date", "from", "sender", "reply-to", "to", "cc", "bcc",
+	"message-id", "in-reply-to", "references", "subject",
+}
+
+var msgIDForm = regexp.MustCompile(`^<[^<>@\s]+@[^<>@\s]+>$`)
+
+type validator struct {
+	issues []Issue
+}
+
+func (v *validator) add(severity string, line int, part, format string, args ...interface{}) {
+	v.issues = append(v.issues, Issue{
+		Severity: severity,
+		Line:     line,
+		Part:     part,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// line is an input line without its line ending.
+type line struct {
+	text []byte
+	num  int
+}
+
+// splitLines splits data into lines and counts those ending in a bare LF.
+func splitLines(data []byte) (lines []line, bareLF int) {
+	for num := 1; len(data) > 0; num++ {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, line{data, num})
+			break
+		}
+		text := data[:i]
+		if n := len(text); n > 0 && text[n-1] == '\r' {
+			text = text[:n-1]
+		} else {
+			bareLF++
+		}
+		lines = append(lines, line{text, num})
+		data = data[i+1:]
+	}
+	return lines, bareLF
+}
+
+// headerField is an unfolded header field.
+type headerField struct {
+	name  string // Lower-case field name
+	value string
+	line  int
+}
+
+// entity checks a message or body part. message is true for a top-level
+// or encapsulated message, whose header must satisfy RFC 5322.
+func (v *validator) entity(lines []line, part string, message bool) {
+	end := len(lines)
+	for i, l := range lines {
+		if len(l.text) == 0 {
+			end = i
+			break
+		}
+	}
+	fields := v.header(lines[:end], part)
+	var body []line
+	if end < len(lines) {
+		body = lines[end+1:]
+	}
+
+	if message {
+		v.messageHeader(fields, firstLine(lines), part)
+	}
+	v.mime(fields, body, part)
+}
+
+// header parses and checks the syntax of header lines.
+func (v *validator) header(lines []line, part string) []headerField {
+	var fields []headerField
+	for _, l := range lines {
+		if l.text[0] == ' ' || l.text[0] == '\t' {
+			if len(fields) == 0 {
+				v.add(SeverityError, l.num, part, "continuation line without a header field")
+				continue
+			}
+			fields[len(fields)-1].value += string(l.text)
+			continue
+		}
+
+		colon := bytes.IndexByte(l.text, ':')
+		if colon <= 0 {
+			v.add(SeverityError, l.num, part, "malformed header line: no field name and colon")
+			continue
+		}
+		name := string(l.text[:colon])
+		if !isFieldName(name) {
+			v.add(SeverityError, l.num, part, "invalid header field name %q", name)
+			continue
+		}
+		fields = append(fields, headerField{
+			name:  strings.ToLower(name),
+			value: string(l.text[colon+1:]),
+			line:  l.num,
+		})
+	}
+
+	for _, f := range fields {
+		if !isASCII(f.value) {
+			v.add(SeverityWarning, f.line, part, "non-ASCII characters in %s header need SMTPUTF8 (RFC 6532); use RFC 2047 encoded words", canonicalName(f.name))
+		}
+	}
+	v.lineLengths(lines, part, "header", false)
+	return fields
+}
+
+// messageHeader checks the RFC 5322 requirements of a message header.
+func (v *validator) messageHeader(fields []headerField, first int, part string) {
+	byName := make(map[string][]headerField)
+	for _, f := range fields {
+		byName[f.name] = append(byName[f.name], f)
+	}
+
+	for _, name := range []string{"date", "from"} {
+		if len(byName[name]) == 0 {
+			v.add(SeverityError, first, part, "missing required %s header", canonicalName(name))
+		}
+	}
+	for _, name := range messageSingletons {
+		if fs := byName[name]; len(fs) > 1 {
+			v.add(SeverityError, fs[1].line, part, "%s header appears %d times", canonicalName(name), len(fs))
+		}
+	}
+
+	if fs := byName["date"]; len(fs) > 0 {
+		if _, err := mail.ParseDate(strings.TrimSpace(fs[0].value)); err != nil {
+			v.add(SeverityError, fs[0].line, part, "invalid Date: %v", err)
+		}
+	}
+
+	parser := mail.AddressParser{WordDecoder: wordDecoder}
+	for _, name := range []string{"from", "sender", "reply-to", "to", "cc", "bcc"} {
+		for _, f := range byName[name] {
+			if strings.TrimSpace(f.value) == "" {
+				if name != "bcc" {
+					v.add(SeverityError, f.line, part, "empty %s header", canonicalName(name))
+				}
+				continue
+			}
+			addrs, err := parser.ParseList(f.value)
+			if err != nil {
+				v.add(SeverityError, f.line, part, "invalid %s address list: %v", canonicalName(name), err)
+				continue
+			}
+			if name == "from" && len(addrs) > 1 && len(byName["sender"]) == 0 {
+				v.add(SeverityError, f.line, part, "From has %d mailboxes but there is no Sender header", len(addrs))
+			}
+			if name == "sender" && len(addrs) != 1 {
+				v.add(SeverityError, f.line, part, "Sender must be a single mailbox")
+			}
+		}
+	}
+
+	if fs := byName["message-id"]; len(fs) == 0 {
+		v.add(SeverityWarning, first, part, "no Message-ID header")
+	} else if !msgIDForm.MatchString(strings.TrimSpace(fs[0].value)) {
+		v.add(SeverityWarning, fs[0].line, part, "Message-ID %q is not of the form <id@domain>", strings.TrimSpace(fs[0].value))
+	}
+
+	hasMIME := len(byName["content-type"]) > 0 || len(byName["content-transfer-encoding"]) > 0
+	if fs := byName["mime-version"]; len(fs) == 0 && hasMIME {
+		v.add(SeverityWarning, first, part, "MIME headers without MIME-Version: 1.0")
+	} else if len(fs) > 0 && !strings.HasPrefix(strings.TrimSpace(fs[0].value), "1.0") {
+		v.add(SeverityWarning, fs[0].line, part, "MIME-Version is %q, want 1.0", strings.TrimSpace(fs[0].value))
+	}
+}
+
+// mime checks the Content-Type and Content-Transfer-Encoding of an entity
+// and then its body: the parts of a multipart, the encapsulated message of
+// a message/rfc822, or the content of a leaf part.
+func (v *validator) mime(fields []headerField, body []line, part string) {
+	var ctField, cteField *headerField
+	for i := range fields {
+		var seen **headerField
+		switch fields[i].name {
+		case "content-type":
+			seen = &ctField
+		case "content-transfer-encoding":
+			seen = &cteField
+		default:
+			continue
+		}
+		if *seen != nil {
+			v.add(SeverityError, fields[i].line, part, "duplicate %s header", canonicalName(fields[i].name))
+			continue
+		}
+		*seen = &fields[i]
+	}
+
+	mediaType, params := "text/plain", map[string]string(nil)
+	if ctField != nil {
+		mt, p, err := mime.ParseMediaType(ctField.value)
+		if err != nil {
+			v.add(SeverityError, ctField.line, part, "invalid Content-Type: %v", err)
+		} else {
+			mediaType, params = mt, p
+		}
+	}
+
+	cte := ""
+	if cteField != nil {
+		cte = strings.ToLower(strings.TrimSpace(cteField.value))
+		switch cte {
+		case "7bit", "8bit", "binary", "quoted-printable", "base64":
+		default:
+			v.add(SeverityError, cteField.line, part, "unknown Content-Transfer-Encoding %q", cte)
+			cte = "binary" // Skip the content checks
+		}
+	}
+	identity := cte == "" || cte == "7bit" || cte == "8bit" || cte == "binary"
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		if !identity {
+			v.add(SeverityError, cteField.line, part, "%s must not use Content-Transfer-Encoding %s", mediaType, cte)
+		}
+		v.multipart(body, part, params["boundary"], ctField.line)
+	case mediaType == "message/rfc822" || mediaType == "message/global":
+		if !identity {
+			v.add(SeverityError, cteField.line, part, "%s must not use Content-Transfer-Encoding %s", mediaType, cte)
+		}
+		if len(body) == 0 {
+			v.add(SeverityError, ctField.line, part, "empty encapsulated message")
+			return
+		}
+		v.entity(body, part, true)
+	default:
+		v.content(body, part, cte)
+	}
+}
+
+// multipart splits a multipart body at the boundary and checks each part.
+func (v *validator) multipart(body []line, part, boundary string, ctLine int) {
+	if boundary == "" {
+		v.add(SeverityError, ctLine, part, "multipart Content-Type without boundary parameter")
+		return
+	}
+	if len(boundary) > 70 {
+		v.add(SeverityError, ctLine, part, "boundary is longer than 70 characters")
+	}
+
+	delim := []byte("--" + boundary)
+	var parts [][]line
+	var cur []line
+	inPart, closed := false, false
+	for _, l := range body {
+		// Delimiter lines may have trailing whitespace (RFC 2046 5.1.1)
+		text := bytes.TrimRight(l.text, " \t")
+		if bytes.HasPrefix(text, delim) {
+			rest := string(text[len(delim):])
+			if rest == "--" {
+				closed = true
+				break
+			}
+			if rest == "" {
+				if inPart {
+					parts = append(parts, cur)
+				}
+				cur, inPart = nil, true
+				continue
+			}
+		}
+		if inPart {
+			cur = append(cur, l)
+		}
+	}
+	if inPart {
+		parts = append(parts, cur)
+	}
+
+	if len(parts) == 0 {
+		v.add(SeverityError, ctLine, part, "multipart body has no parts: boundary %q not found", boundary)
+		return
+	}
+	if !closed {
+		v.add(SeverityError, ctLine, part, "multipart body has no closing boundary --%s--", boundary)
+	}
+	for i, p := range parts {
+		v.entity(p, childPartNumber(part, i+1), false)
+	}
+}
+
+// content checks the body of a leaf part against its transfer encoding.
+func (v *validator) content(body []line, part, cte string) {
+	if cte == "binary" {
+		return
+	}
+	v.lineLengths(body, part, "body", true)
+
+	for _, l := range body {
+		if bytes.IndexByte(l.text, 0) >= 0 {
+			v.add(SeverityError, l.num, part, "NUL byte outside a binary part")
+			break
+		}
+	}
+	for _, l := range body {
+		if bytes.IndexByte(l.text, '\r') >= 0 {
+			v.add(SeverityWarning, l.num, part, "bare CR in body")
+			break
+		}
+	}
+	if cte != "8bit" {
+		for _, l := range body {
+			if !isASCII(string(l.text)) {
+				name := cte
+				if name == "" {
+					name = "7bit (the default)"
+				}
+				v.add(SeverityError, l.num, part, "8-bit data in a part with Content-Transfer-Encoding %s; use 8bit, quoted-printable or base64", name)
+				break
+			}
+		}
+	}
+
+	switch cte {
+	case "base64":
+		var enc []byte
+		for _, l := range body {
+			enc = append(enc, bytes.TrimSpace(l.text)...)
+		}
+		if _, err := base64.StdEncoding.DecodeString(string(enc)); err != nil {
+			v.add(SeverityError, firstLine(body), part, "invalid base64 content: %v", err)
+		}
+	case "quoted-printable":
+		for _, l := range body {
+			if !isQuotedPrintable(l.text) {
+				v.add(SeverityError, l.num, part, "invalid quoted-printable content: \"=\" not followed by two hex digits or a line break")
+				break
+			}
+		}
+	}
+}
+
+// lineLengths reports lines over the 998 octet limit as errors and, if
+// recommended is set, lines over 78 characters as one warning.
+func (v *validator) lineLengths(lines []line, part, where string, recommended bool) {
+	var long, first int
+	for _, l := range lines {
+		switch n := len(l.text); {
+		case n > maxLineLength:
+			v.add(SeverityError, l.num, part, "%s line is %d octets long, over the limit of %d", where, n, maxLineLength)
+		case n > recommendedLineLength && recommended:
+			if long == 0 {
+				first = l.num
+			}
+			long++
+		}
+	}
+	if long > 0 {
+		v.add(SeverityWarning, first, part, "%d %s lines are longer than %d characters", long, where, recommendedLineLength)
+	}
+}
+
+// isQuotedPrintable reports whether every "=" in a line starts a hex
+// escape or is a soft line break. Decoders such as mime/quotedprintable
+// accept broken escapes, so this is checked separately.
+func isQuotedPrintable(text []byte) bool {
+	text = bytes.TrimRight(text, " \t")
+	for i := 0; i < len(text); i++ {
+		if text[i] != '=' || i == len(text)-1 {
+			continue
+		}
+		if i+2 >= len(text) || !isHexDigit(text[i+1]) || !isHexDigit(text[i+2]) {
+			return false
+		}
+		i += 2
+	}
+	return true
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'A' <= c && c <= 'F' || 'a' <= c && c <= 'f'
+}
+
+func firstLine(lines []line) int {
+	if len(lines) == 0 {
+		return 0
+	}
+	return lines[0].num
+}
+
+// isFieldName reports whether name is a valid header field name: printable
+// US-ASCII except colon (RFC 5322 section 2.2).
+func isFieldName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < 33 || c > 126 {
+			return false
+		}
+	}
+	return true
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalName returns the usual spelling of a lower-case field name.
+func canonicalName(name string) string {
+	switch name {
+	case "message-id":
+		return "Message-ID"
+	case "mime-version":
+		return "MIME-Version"
+	}
+	parts := strings.Split(name, "-")
+	for i, p := range parts {
+		if p != "" {
+			parts[i] = strings.ToUpper(p[:1]) + p[1:]
+		}
+	}
+	return strings.Join(parts, "-")
+}
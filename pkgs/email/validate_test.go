@@ -0,0 +1,101 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+const validMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: Report\r\n" +
+	"Date: Tue, 2 Jan 2024 10:00:00 +0000\r\n" +
+	"Message-ID: <report-1@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Gr=C3=BC=C3=9Fe\r\n" +
+	"--b1\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"From: carol@example.com\r\n" +
+	"Date: Mon, 1 Jan 2024 09:00:00 +0000\r\n" +
+	"Message-ID: <inner@example.com>\r\n" +
+	"\r\n" +
+	"Inner body\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"aGVsbG8=\r\n" +
+	"--b1--\r\n"
+
+func validate(t *testing.T, msg string) []Issue {
+	t.Helper()
+	issues, err := Validate(strings.NewReader(msg))
+	if err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	return issues
+}
+
+func TestValidate_Valid(t *testing.T) {
+	if issues := validate(t, validMessage); len(issues) != 0 {
+		t.Errorf("Validate(valid message) = %v, want no issues", issues)
+	}
+}
+
+func TestValidate_Issues(t *testing.T) {
+	tests := []struct {
+		name, old, new string
+		severity       string
+		line           int
+		part, contains string
+	}{
+		{"missing Date", "Date: Tue, 2 Jan 2024 10:00:00 +0000\r\n", "", SeverityError, 1, "", "missing required Date"},
+		{"bad Date", "Tue, 2 Jan 2024", "Someday", SeverityError, 4, "", "invalid Date"},
+		{"duplicate Subject", "Subject: Report\r\n", "Subject: Report\r\nSubject: Again\r\n", SeverityError, 4, "", "Subject header appears 2 times"},
+		{"bad address", "To: bob@example.com", "To: bob@", SeverityError, 2, "", "invalid To address list"},
+		{"two From without Sender", "From: Alice <alice@example.com>", "From: alice@example.com, dave@example.com", SeverityError, 1, "", "no Sender header"},
+		{"bad field name", "Subject: Report", "Sub ject: Report", SeverityError, 3, "", "invalid header field name"},
+		{"no colon", "Subject: Report", "Subject Report", SeverityError, 3, "", "no field name and colon"},
+		{"bad Message-ID", "<report-1@example.com>", "report-1", SeverityWarning, 5, "", "not of the form"},
+		{"no MIME-Version", "MIME-Version: 1.0\r\n", "", SeverityWarning, 1, "", "without MIME-Version"},
+		{"8-bit header", "Subject: Report", "Subject: Grüße", SeverityWarning, 3, "", "non-ASCII characters in Subject"},
+		{"missing boundary", "boundary=b1", "charset=utf-8", SeverityError, 7, "", "without boundary"},
+		{"no closing boundary", "--b1--\r\n", "", SeverityError, 7, "", "no closing boundary"},
+		{"encoded multipart", "MIME-Version: 1.0\r\n", "MIME-Version: 1.0\r\nContent-Transfer-Encoding: base64\r\n", SeverityError, 7, "", "must not use Content-Transfer-Encoding base64"},
+		{"8-bit in 7bit part", "Inner body", "Inner bödy", SeverityError, 21, "2", "8-bit data"},
+		{"8-bit in quoted-printable", "Gr=C3=BC=C3=9Fe", "Grüße", SeverityError, 13, "1", "8-bit data"},
+		{"bad quoted-printable", "Gr=C3=BC", "Gr=ZZ=BC", SeverityError, 13, "1", "invalid quoted-printable"},
+		{"bad base64", "aGVsbG8=", "aGVsbG8=!!", SeverityError, 26, "3", "invalid base64"},
+		{"unknown encoding", "Content-Transfer-Encoding: base64", "Content-Transfer-Encoding: uuencode", SeverityError, 24, "3", "unknown Content-Transfer-Encoding"},
+		{"inner message without From", "From: carol@example.com\r\n", "", SeverityError, 17, "2", "missing required From"},
+		{"long line", "Inner body", strings.Repeat("x", 1000), SeverityError, 21, "2", "over the limit of 998"},
+		{"long-ish line", "Inner body", strings.Repeat("x", 100), SeverityWarning, 21, "2", "longer than 78"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(validMessage, tt.old) {
+				t.Fatalf("test message lacks %q", tt.old)
+			}
+			issues := validate(t, strings.Replace(validMessage, tt.old, tt.new, 1))
+			for _, is := range issues {
+				if is.Severity == tt.severity && is.Line == tt.line && is.Part == tt.part && strings.Contains(is.Message, tt.contains) {
+					return
+				}
+			}
+			t.Errorf("issues = %v, want %s at line %d (part %q) containing %q", issues, tt.severity, tt.line, tt.part, tt.contains)
+		})
+	}
+}
+
+func TestValidate_BareLF(t *testing.T) {
+	issues := validate(t, strings.ReplaceAll(validMessage, "\r\n", "\n"))
+	if len(issues) != 1 || issues[0].Severity != SeverityWarning || !strings.Contains(issues[0].Message, "bare LF") {
+		t.Errorf("Validate(LF message) = %v, want a single bare LF warning", issues)
+	}
+}
@@ -1,58 +1,227 @@
 package email
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/emersion/go-imap/v2"
 	"github.com/emersion/go-imap/v2/imapclient"
+	"github.com/emersion/go-message/textproto"
 )
 
 // WatchOptions holds options for watch mode
 type WatchOptions struct {
-	Folder        string
+	Folder        string // Folder or logical folder name (see IMAPClient.ResolveFolder); "" = inbox
+	Account       string // Account name, passed to the handler as EMX_ACCOUNT
 	HandlerCmd    string
-	KeepAlive     int // seconds
-	PollInterval  int // seconds
+	HandlerShell  string // "sh", "cmd", "powershell", "pwsh" or "none"; "" = DefaultHandlerShell()
+	KeepAlive     int    // seconds
+	PollInterval  int    // seconds
 	MaxRetries    int
 	PollOnly      bool
 	Once          bool
 	IdleKeepAlive int // seconds, NOOP interval during IDLE
+	ShutdownGrace int // seconds a running handler may finish after shutdown is requested
+
+	// Pipelines run for the emails matching them instead of HandlerCmd;
+	// the first matching pipeline is used.
+	Pipelines []Pipeline
+
+	// Commands, if set, run for the emails carrying a command directive
+	// instead of the handler, pipelines or WatchFunc handler.
+	Commands *Commands
+
+	// Backlog sets which of the emails already unseen when the watch starts
+	// are processed: "all" (the default, also ""), "none" to handle only
+	// emails arriving from now on, or "since:<duration>" (e.g. "since:72h")
+	// for those received in that time before the start. Emails left out
+	// stay unseen and are not handled by this watch, however long it runs.
+	Backlog string
+
+	// OrderByDate processes the unprocessed emails in the order the server
+	// received them (INTERNALDATE) instead of by UID, for folders where
+	// migrations delivered old mail with high UIDs.
+	OrderByDate bool
+
+	// Notify rules run for every new email before the handler, once even
+	// if the handler fails and the email is handled again; a failed
+	// notification is reported as a warning and does not fail the email.
+	// Notifiers that send mail skip emails SuppressAutoResponse rejects.
+	Notify []NotifyRule
+
+	// RateAlerts raise a Flood, reported as a "flood" warning and passed
+	// to Flood if set, when too many emails from one sender or with one
+	// subject arrive, e.g. from a mail loop.
+	RateAlerts []RateAlert
+	Flood      func(Flood)
+
+	// AutoResponder declares that the handler, pipelines or WatchFunc
+	// handler send mail in response to emails (auto-replies, tickets).
+	// Emails SuppressAutoResponse rejects are then marked as processed
+	// without running them.
+	AutoResponder bool
+
+	// Scan checks the attachments of every new email before the handler
+	// runs. An email that cannot be scanned fails.
+	Scan *ScanOptions
+
+	// Changes also reports expunged messages and flag changes in the
+	// folder, as MailboxChange lines on stdout.
+	Changes bool
+
+	// Throttle, if set, limits the FETCH and SEARCH commands sent while
+	// processing emails, and slows down when the server throttles.
+	Throttle *Throttle
+
+	// With Once, Checkpoint (if set) records progress through the
+	// unprocessed emails so an interrupted run resumes after the last one
+	// handled, and Max (if > 0) bounds how many are handled in this run.
+	Checkpoint *WatchCheckpoint
+	Max        int
+
+	// RetryQueue, if set, records the emails that failed and retries
+	// them with backoff, instead of on every check for new emails.
+	RetryQueue *RetryQueue
+
+	// Status, if set, receives the status messages that are otherwise
+	// written to stderr as JSON lines.
+	Status func(WatchStatus)
+
+	// Stats, if set, receives the counters of the watch every
+	// StatsInterval seconds (default 60) and once more when it stops, e.g.
+	// to publish them for dashboards. It is called from its own goroutine.
+	Stats         func(WatchStats)
+	StatsInterval int
+
+	// handlerFunc is the in-process handler of WatchFunc, run instead of
+	// HandlerCmd.
+	handlerFunc MessageHandler
+
+	// backlog is where the Backlog policy starts processing, set when the
+	// folder is selected.
+	backlog *backlogFloor
+
+	// rateAlerts counts the new emails for RateAlerts.
+	rateAlerts *rateAlerts
+
+	// notified holds the emails Notify already ran for.
+	notified *notifiedEmails
 }
 
+// MessageHandler handles a new email in WatchFunc. msg holds the envelope
+// and flags; raw streams the full RFC 5322 email from the server and is
+// only valid until the handler returns. Returning nil marks the email as
+// processed; an error leaves it unseen, to be tried again later.
+type MessageHandler func(msg *Message, raw io.Reader) error
+
 // WatchStatus represents a status message type
 type WatchStatus struct {
-	Type    string `json:"type"`            // "connection", "idle", "process", "mark", "error"
-	Level   string `json:"level,omitempty"` // "info", "warn", "error"
-	Message string `json:"message"`
-	UID     uint32 `json:"uid,omitempty"`
+	Type    string      `json:"type"`            // "connection", "idle", "process", "command", "notify", "flood", "scan", "mark", "retry", "changes", "throttle", "uidvalidity", "stats", "error", "summary"
+	Level   string      `json:"level,omitempty"` // "info", "warn", "error"
+	Message string      `json:"message"`
+	UID     uint32      `json:"uid,omitempty"`
+	Stats   *WatchStats `json:"stats,omitempty"` // Set on the final "summary" status
+}
+
+// WatchStats counts the emails handled during one Watch call.
+type WatchStats struct {
+	Processed int     `json:"processed"`          // Handler succeeded (or none configured) and the email was marked
+	Failed    int     `json:"failed"`             // Fetching, the handler or marking failed; the email stays unseen
+	Pending   int     `json:"pending"`            // Unseen emails found and not handled yet
+	LastUID   uint32  `json:"last_uid,omitempty"` // The email handled last
+	Uptime    float64 `json:"uptime"`             // Seconds since Watch started
+}
+
+// watchStats are the WatchStats of a running watch, which the Stats
+// callback reads while emails are handled.
+type watchStats struct {
+	mu      sync.Mutex
+	stats   WatchStats
+	started time.Time
+	now     func() time.Time
+}
+
+// queued records that n unseen emails are about to be handled.
+func (s *watchStats) queued(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Pending = n
+}
+
+// handled counts the email uid, which failed if err is not nil.
+func (s *watchStats) handled(uid uint32, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.stats.Failed++
+	} else {
+		s.stats.Processed++
+	}
+	s.stats.LastUID = uid
+	if s.stats.Pending > 0 {
+		s.stats.Pending--
+	}
+}
+
+func (s *watchStats) snapshot() WatchStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats
+	st.Uptime = s.now().Sub(s.started).Seconds()
+	return st
+}
+
+// reportStats passes the stats to opts.Stats every StatsInterval seconds
+// until stop is closed, then once more before closing done.
+func reportStats(opts WatchOptions, stats *watchStats, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(time.Duration(opts.StatsInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			opts.Stats(stats.snapshot())
+		case <-stop:
+			opts.Stats(stats.snapshot())
+			return
+		}
+	}
 }
 
 // EmailNotification represents a new email notification
 type EmailNotification struct {
 	Type      string   `json:"type"` // "email"
 	UID       uint32   `json:"uid"`
+	Key       string   `json:"key"` // Stable idempotency key, see Message.Key
 	MessageID string   `json:"message_id"`
 	From      string   `json:"from"`
 	To        []string `json:"to"`
 	Subject   string   `json:"subject"`
-	Date      string   `json:"date"`
+	Date      string   `json:"date"`          // Date header in RFC 3339, "" if missing
+	Received  string   `json:"internal_date"` // INTERNALDATE in RFC 3339, when the server received it
 	Flags     []string `json:"flags"`
 }
 
 // Watch starts watching for new emails on the IMAP server.
 // The provided context controls the lifetime of the watch loop; cancel it
-// (e.g. on SIGINT/SIGTERM) for a graceful shutdown.
+// (e.g. on SIGINT/SIGTERM) for a graceful shutdown. A handler that is running
+// at that point gets ShutdownGrace seconds to finish before it is killed, and
+// no further emails are started. A "summary" status is written on exit.
 func (c *IMAPClient) Watch(ctx context.Context, opts WatchOptions) error {
+	clock := clockOrSystem(c.config.Clock)
+	started := clock.Now()
 	// Set defaults
-	if opts.Folder == "" {
-		opts.Folder = "INBOX"
-	}
 	if opts.KeepAlive <= 0 {
 		opts.KeepAlive = 30
 	}
@@ -65,6 +234,12 @@ func (c *IMAPClient) Watch(ctx context.Context, opts WatchOptions) error {
 	if opts.IdleKeepAlive <= 0 {
 		opts.IdleKeepAlive = 300 // 5 minutes default
 	}
+	if opts.ShutdownGrace <= 0 {
+		opts.ShutdownGrace = 30
+	}
+	if opts.StatsInterval <= 0 {
+		opts.StatsInterval = 60
+	}
 	// Validate IDLE keep-alive range (min 1 minute, max 29 minutes per RFC 2177)
 	if opts.IdleKeepAlive < 60 {
 		opts.IdleKeepAlive = 60 // minimum 1 minute
@@ -72,6 +247,44 @@ func (c *IMAPClient) Watch(ctx context.Context, opts WatchOptions) error {
 	if opts.IdleKeepAlive > 1740 {
 		opts.IdleKeepAlive = 1740 // maximum 29 minutes
 	}
+	if opts.HandlerCmd != "" {
+		if _, err := handlerCommand(opts.HandlerShell, opts.HandlerCmd); err != nil {
+			return fmt.Errorf("invalid handler: %w", err)
+		}
+	}
+	for _, p := range opts.Pipelines {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("invalid handler: %w", err)
+		}
+	}
+	if opts.Commands != nil {
+		if err := opts.Commands.validate(); err != nil {
+			return fmt.Errorf("invalid commands: %w", err)
+		}
+	}
+	for _, r := range opts.RateAlerts {
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+	if len(opts.RateAlerts) > 0 {
+		opts.rateAlerts = newRateAlerts(opts.RateAlerts)
+	}
+	if len(opts.Notify) > 0 {
+		opts.notified = &notifiedEmails{at: make(map[uint32]time.Time)}
+	}
+	backlogAll, backlogSince, err := parseBacklog(opts.Backlog)
+	if err != nil {
+		return err
+	}
+
+	if opts.Changes {
+		c.changes = newChangeTracker(func(ch MailboxChange) {
+			data, _ := json.Marshal(ch)
+			fmt.Fprintln(os.Stdout, string(data))
+		})
+		defer func() { c.changes = nil }()
+	}
 
 	// Connect
 	if err := c.Connect(); err != nil {
@@ -79,11 +292,44 @@ func (c *IMAPClient) Watch(ctx context.Context, opts WatchOptions) error {
 	}
 	defer c.Close()
 
-	statusWrite := func(s WatchStatus) {
-		data, _ := json.Marshal(s)
-		fmt.Fprintln(os.Stderr, string(data))
+	statusWrite := opts.Status
+	if statusWrite == nil {
+		statusWrite = func(s WatchStatus) {
+			data, _ := json.Marshal(s)
+			fmt.Fprintln(os.Stderr, string(data))
+		}
+	}
+
+	// Digests report the failures of their background sends as warnings,
+	// and the pending ones go out when the watch stops
+	for _, rule := range opts.Notify {
+		if d, ok := rule.Notifier.(*DigestNotifier); ok && d.Error == nil {
+			d.Error = func(err error) {
+				statusWrite(WatchStatus{Type: "notify", Level: "warn", Message: fmt.Sprintf("Notification digest failed: %v", err)})
+			}
+		}
 	}
 
+	stats := &watchStats{started: started, now: clock.Now}
+	defer func() {
+		final := stats.snapshot()
+		statusWrite(WatchStatus{
+			Type:    "summary",
+			Level:   "info",
+			Message: fmt.Sprintf("Watch stopped: %d processed, %d failed", final.Processed, final.Failed),
+			Stats:   &final,
+		})
+	}()
+	if opts.Stats != nil {
+		stop, done := make(chan struct{}), make(chan struct{})
+		go reportStats(opts, stats, stop, done)
+		defer func() {
+			close(stop)
+			<-done
+		}()
+	}
+	defer c.flushDigests(opts.Notify, statusWrite)
+
 	statusWrite(WatchStatus{
 		Type:    "connection",
 		Level:   "info",
@@ -91,8 +337,44 @@ func (c *IMAPClient) Watch(ctx context.Context, opts WatchOptions) error {
 	})
 
 	// Select folder
-	if _, err := c.client.Select(opts.Folder, nil).Wait(); err != nil {
-		return fmt.Errorf("failed to select folder %s: %w", opts.Folder, err)
+	folder, err := c.resolveFolder(opts.Folder)
+	if err != nil {
+		return err
+	}
+	opts.Folder = folder
+	selectData, old, err := c.selectFolder(opts.Folder, false)
+	if err != nil {
+		return err
+	}
+	if old != 0 {
+		c.uidValidityChanged(opts.Folder, old, selectData.UIDValidity, statusWrite)
+	}
+
+	c.syncChanges(opts.Folder, statusWrite)
+
+	if !backlogAll {
+		opts.backlog = newBacklogFloor(started, backlogSince, selectData.UIDNext)
+		statusWrite(WatchStatus{
+			Type:    "process",
+			Level:   "info",
+			Message: fmt.Sprintf("Backlog %s: skipping unseen emails %s", opts.Backlog, opts.backlog),
+		})
+	}
+
+	// A one-time run may be bounded, and resumes an interrupted one
+	var run *backlogRun
+	if opts.Once && (opts.Checkpoint != nil || opts.Max > 0) {
+		run = &backlogRun{checkpoint: opts.Checkpoint, uidValidity: selectData.UIDValidity, max: opts.Max, byDate: opts.OrderByDate}
+		if run.checkpoint != nil {
+			if run.byDate {
+				run.after, run.afterDate, err = run.checkpoint.LoadDated(selectData.UIDValidity)
+			} else {
+				run.after, err = run.checkpoint.Load(selectData.UIDValidity)
+			}
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	// Check for IDLE support
@@ -106,7 +388,7 @@ func (c *IMAPClient) Watch(ctx context.Context, opts WatchOptions) error {
 	}
 
 	// Process existing unprocessed emails
-	if err := c.processUnprocessed(opts, statusWrite); err != nil {
+	if err := c.processUnprocessed(ctx, opts, run, stats, statusWrite); err != nil {
 		statusWrite(WatchStatus{
 			Type:    "error",
 			Level:   "error",
@@ -126,9 +408,25 @@ func (c *IMAPClient) Watch(ctx context.Context, opts WatchOptions) error {
 
 	// Enter watch loop
 	if supportsIDLE && !opts.PollOnly {
-		return c.watchIDLE(ctx, opts, statusWrite)
+		return c.watchIDLE(ctx, opts, stats, statusWrite)
+	}
+	return c.watchPoll(ctx, opts, stats, statusWrite)
+}
+
+// WatchFunc watches for new emails like Watch, but hands each one to fn in
+// process instead of running a handler command, for Go programs embedding
+// this package. New emails are not announced on stdout; use opts.Status to
+// receive status messages rather than JSON lines on stderr. A running fn is
+// not interrupted by cancelling ctx: Watch returns once it is done.
+func (c *IMAPClient) WatchFunc(ctx context.Context, opts WatchOptions, fn MessageHandler) error {
+	if fn == nil {
+		return fmt.Errorf("watch: nil message handler")
+	}
+	if opts.HandlerCmd != "" {
+		return fmt.Errorf("watch: HandlerCmd and a message handler are mutually exclusive")
 	}
-	return c.watchPoll(ctx, opts, statusWrite)
+	opts.handlerFunc = fn
+	return c.Watch(ctx, opts)
 }
 
 // checkIDLESupport checks if the server supports IDLE
@@ -140,24 +438,204 @@ func (c *IMAPClient) checkIDLESupport() bool {
 	return caps.Has("IDLE")
 }
 
-// processUnprocessed processes emails that are not yet Seen
-func (c *IMAPClient) processUnprocessed(opts WatchOptions, statusWrite func(WatchStatus)) error {
+// backlogRun bounds and checkpoints the processing of unprocessed emails
+// in a one-time run.
+type backlogRun struct {
+	checkpoint  *WatchCheckpoint // nil: no checkpoint
+	uidValidity uint32
+	after       uint32 // Skip emails up to this UID, handled by an earlier run
+	max         int    // Handle at most this many emails; 0 = all
+
+	// byDate orders the emails by INTERNALDATE; emails up to afterDate
+	// (and after, among those received at that time) are skipped
+	byDate    bool
+	afterDate time.Time
+}
+
+// parseBacklog parses a WatchOptions.Backlog policy. all is set for "all"
+// and ""; otherwise emails received up to since before the start are
+// processed, none for "none".
+func parseBacklog(policy string) (all bool, since time.Duration, err error) {
+	switch {
+	case policy == "" || policy == "all":
+		return true, 0, nil
+	case policy == "none":
+		return false, 0, nil
+	case strings.HasPrefix(policy, "since:"):
+		d, err := time.ParseDuration(strings.TrimPrefix(policy, "since:"))
+		if err == nil && d > 0 {
+			return false, d, nil
+		}
+	}
+	return false, 0, fmt.Errorf("invalid backlog policy %q: want all, none or since:<duration> (e.g. since:72h)", policy)
+}
+
+// backlogFloor leaves alone the unseen emails that were in the folder when
+// the watch started and are outside its Backlog policy.
+type backlogFloor struct {
+	uid   imap.UID  // Skip UIDs below this one (Backlog "none"); 0 = no limit
+	since time.Time // Skip emails received before this time; zero = no limit
+
+	started time.Time
+}
+
+// newBacklogFloor returns the floor of a watch started at started that
+// processes the emails received up to since before it, or none of them if
+// since is 0. uidNext is the UIDNEXT of the folder at the start.
+func newBacklogFloor(started time.Time, since time.Duration, uidNext imap.UID) *backlogFloor {
+	if since > 0 {
+		return &backlogFloor{since: started.Add(-since), started: started}
+	}
+	if uidNext == 0 {
+		// The server did not send UIDNEXT
+		return &backlogFloor{since: started, started: started}
+	}
+	// UIDs are exact where the clocks of client and server may disagree
+	return &backlogFloor{uid: uidNext, started: started}
+}
+
+func (f *backlogFloor) String() string {
+	if f.uid > 0 {
+		return fmt.Sprintf("below UID %d", f.uid)
+	}
+	return fmt.Sprintf("received before %s", f.since.Format(time.RFC3339))
+}
+
+// renumbered falls back to the start time of the watch once the folder's
+// UIDVALIDITY changed, as the UID floor then means nothing.
+func (f *backlogFloor) renumbered() {
+	if f != nil && f.uid > 0 {
+		f.uid = 0
+		f.since = f.started
+	}
+}
+
+// narrow restricts the search for unseen emails to those near or above the
+// floor; filter then drops the rest exactly.
+func (f *backlogFloor) narrow(criteria *imap.SearchCriteria) {
+	if f.uid > 0 {
+		uidRange := imap.UIDSet{}
+		uidRange.AddRange(f.uid, 0) // <uid>:*
+		criteria.UID = []imap.UIDSet{uidRange}
+	}
+	if !f.since.IsZero() {
+		// SINCE compares dates in the server's time zone, so search from
+		// the day before
+		criteria.Since = f.since.AddDate(0, 0, -1)
+	}
+}
+
+// filter drops the emails below the floor from uids. dates has the
+// INTERNALDATE of each email if the floor is a time.
+func (f *backlogFloor) filter(uids []imap.UID, dates map[imap.UID]time.Time) []imap.UID {
+	kept := uids[:0]
+	for _, uid := range uids {
+		// "<uid>:*" matches the last email even when uid is above it
+		if uid < f.uid {
+			continue
+		}
+		if !f.since.IsZero() && dates[uid].Before(f.since) {
+			continue
+		}
+		kept = append(kept, uid)
+	}
+	return kept
+}
+
+// processUnprocessed processes emails that are not yet Seen, counting the
+// outcomes in stats. It stops before the next email once ctx is cancelled.
+// run, if not nil, bounds the emails processed and records progress.
+func (c *IMAPClient) processUnprocessed(ctx context.Context, opts WatchOptions, run *backlogRun, stats *watchStats, statusWrite func(WatchStatus)) error {
+	if c.changes != nil && c.changes.needsReload() {
+		c.syncChanges(opts.Folder, statusWrite)
+	}
+
 	// Use SEARCH UNSEEN to directly fetch unseen emails (avoids N+1 query problem)
-	searchData, err := c.client.UIDSearch(&imap.SearchCriteria{
+	criteria := &imap.SearchCriteria{
 		NotFlag: []imap.Flag{imap.FlagSeen},
-	}, nil).Wait()
-
+	}
+	if opts.backlog != nil {
+		opts.backlog.narrow(criteria)
+	}
+	var searchData *imap.SearchData
+	err := throttled(ctx, opts, statusWrite, func() (err error) {
+		searchData, err = c.client.UIDSearch(criteria, nil).Wait()
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("search failed: %w", err)
 	}
 
 	uids := searchData.AllUIDs()
+	var dates map[imap.UID]time.Time
+	needDates := opts.OrderByDate || (opts.backlog != nil && !opts.backlog.since.IsZero())
+	if needDates && len(uids) > 0 {
+		err := throttled(ctx, opts, statusWrite, func() (err error) {
+			dates, err = c.internalDates(uids)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to fetch received dates: %w", err)
+		}
+	}
+	if opts.backlog != nil {
+		uids = opts.backlog.filter(uids, dates)
+	}
+	if opts.RetryQueue != nil {
+		queued := c.processRetries(ctx, opts, stats, statusWrite)
+		// The queue retries its emails when they are due
+		kept := uids[:0]
+		for _, uid := range uids {
+			if _, ok := queued[uint32(uid)]; !ok {
+				kept = append(kept, uid)
+			}
+		}
+		uids = kept
+	}
+	if opts.OrderByDate {
+		sort.Slice(uids, func(i, j int) bool {
+			return receivedBefore(dates[uids[i]], uint32(uids[i]), dates[uids[j]], uint32(uids[j]))
+		})
+	}
+	// handled reports whether an earlier run got past uid
+	handled := func(uid imap.UID) bool {
+		if run.byDate {
+			return !receivedBefore(run.afterDate, run.after, dates[uid], uint32(uid))
+		}
+		return uint32(uid) <= run.after
+	}
+
+	bounded := false
+	if run != nil {
+		if run.after > 0 {
+			i := 0
+			for i < len(uids) && handled(uids[i]) {
+				i++
+			}
+			statusWrite(WatchStatus{
+				Type:    "process",
+				Level:   "info",
+				Message: fmt.Sprintf("Resuming after UID %d, skipping %d emails handled by an earlier run", run.after, i),
+			})
+			uids = uids[i:]
+		}
+		if run.max > 0 && len(uids) > run.max {
+			statusWrite(WatchStatus{
+				Type:    "process",
+				Level:   "info",
+				Message: fmt.Sprintf("Limiting this run to %d of %d unprocessed emails", run.max, len(uids)),
+			})
+			uids = uids[:run.max]
+			bounded = true
+		}
+	}
 	if len(uids) == 0 {
 		statusWrite(WatchStatus{
 			Type:    "process",
 			Level:   "info",
 			Message: "No unprocessed emails found",
 		})
+		run.finish(statusWrite)
 		return nil
 	}
 
@@ -166,24 +644,185 @@ func (c *IMAPClient) processUnprocessed(opts WatchOptions, statusWrite func(Watc
 		Level:   "info",
 		Message: fmt.Sprintf("Processing %d unprocessed emails", len(uids)),
 	})
+	stats.queued(len(uids))
 
 	// Process each email
 	for _, uid := range uids {
-		if err := c.processEmail(uint32(uid), opts, statusWrite); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		err := c.processEmail(ctx, uint32(uid), opts, statusWrite)
+		// An email interrupted by shutdown is tried again on resume
+		if run != nil && (err == nil || ctx.Err() == nil) {
+			run.save(uint32(uid), dates[uid], statusWrite)
+		}
+		stats.handled(uint32(uid), err)
+		if err != nil {
 			statusWrite(WatchStatus{
 				Type:    "error",
 				Level:   "error",
 				Message: fmt.Sprintf("Failed to process UID %d: %v", uid, err),
 				UID:     uint32(uid),
 			})
+			if opts.RetryQueue != nil && ctx.Err() == nil {
+				c.recordRetry(opts, uint32(uid), err, statusWrite)
+			}
 			// Continue with next email (sequential processing)
-			continue
 		}
 	}
 
+	if !bounded && ctx.Err() == nil {
+		run.finish(statusWrite)
+	}
 	return nil
 }
 
+// processRetries handles the emails of opts.RetryQueue that are due, seen
+// or not, and returns the emails queued afterwards.
+func (c *IMAPClient) processRetries(ctx context.Context, opts WatchOptions, stats *watchStats, statusWrite func(WatchStatus)) map[uint32]RetryEntry {
+	queued, err := opts.RetryQueue.queued(c.uidValidity[opts.Folder])
+	if err != nil {
+		statusWrite(WatchStatus{
+			Type:    "retry",
+			Level:   "warn",
+			Message: fmt.Sprintf("Failed to read retry queue: %v", err),
+		})
+		return nil
+	}
+
+	now := clockOrSystem(c.config.Clock).Now()
+	var due []uint32
+	for uid, e := range queued {
+		if !e.GaveUp && !e.Next.After(now) {
+			due = append(due, uid)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i] < due[j] })
+
+	for _, uid := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		e := queued[uid]
+		statusWrite(WatchStatus{
+			Type:    "retry",
+			Level:   "info",
+			Message: fmt.Sprintf("Retrying UID %d after %d failed attempts, last: %s", uid, e.Attempts, e.Reason),
+			UID:     uid,
+		})
+		err := c.processEmail(ctx, uid, opts, statusWrite)
+		stats.handled(uid, err)
+		if err != nil && ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			statusWrite(WatchStatus{
+				Type:    "error",
+				Level:   "error",
+				Message: fmt.Sprintf("Failed to process UID %d: %v", uid, err),
+				UID:     uid,
+			})
+		}
+		if next, ok := c.recordRetry(opts, uid, err, statusWrite); ok {
+			queued[uid] = next
+		} else {
+			delete(queued, uid)
+		}
+	}
+	return queued
+}
+
+// recordRetry updates opts.RetryQueue with the outcome err of handling uid:
+// a failure is queued for another attempt, and a handled or vanished email
+// leaves the queue. It returns the queue entry of a failure.
+func (c *IMAPClient) recordRetry(opts WatchOptions, uid uint32, err error, statusWrite func(WatchStatus)) (RetryEntry, bool) {
+	q := opts.RetryQueue
+	uidValidity := c.uidValidity[opts.Folder]
+	warn := func(err error) {
+		statusWrite(WatchStatus{
+			Type:    "retry",
+			Level:   "warn",
+			Message: fmt.Sprintf("Failed to update retry queue: %v", err),
+			UID:     uid,
+		})
+	}
+
+	if err == nil || errors.Is(err, ErrMessageNotFound) {
+		if err != nil {
+			statusWrite(WatchStatus{
+				Type:    "retry",
+				Level:   "info",
+				Message: fmt.Sprintf("UID %d is gone, removing it from the retry queue", uid),
+				UID:     uid,
+			})
+		}
+		if err := q.remove(uidValidity, uid); err != nil {
+			warn(err)
+		}
+		return RetryEntry{}, false
+	}
+
+	e, qerr := q.failed(uidValidity, uid, err.Error(), clockOrSystem(c.config.Clock).Now())
+	if qerr != nil {
+		warn(qerr)
+		return RetryEntry{}, false
+	}
+	if e.GaveUp {
+		statusWrite(WatchStatus{
+			Type:    "retry",
+			Level:   "warn",
+			Message: fmt.Sprintf("Giving up on UID %d after %d failed attempts; reset the retry queue to try it again", uid, e.Attempts),
+			UID:     uid,
+		})
+	} else {
+		statusWrite(WatchStatus{
+			Type:    "retry",
+			Level:   "info",
+			Message: fmt.Sprintf("UID %d failed %d times, retrying at %s", uid, e.Attempts, e.Next.Local().Format(time.RFC3339)),
+			UID:     uid,
+		})
+	}
+	return e, true
+}
+
+// save records uid, received at date, as handled. A checkpoint that cannot
+// be written only costs a resumed run some repeated work, so it is a
+// warning.
+func (r *backlogRun) save(uid uint32, date time.Time, statusWrite func(WatchStatus)) {
+	if r.checkpoint == nil {
+		return
+	}
+	var err error
+	if r.byDate {
+		err = r.checkpoint.SaveDated(r.uidValidity, uid, date)
+	} else {
+		err = r.checkpoint.Save(r.uidValidity, uid)
+	}
+	if err != nil {
+		statusWrite(WatchStatus{
+			Type:    "process",
+			Level:   "warn",
+			Message: fmt.Sprintf("Failed to save checkpoint: %v", err),
+			UID:     uid,
+		})
+	}
+}
+
+// finish clears the checkpoint once the whole backlog has been handled, so
+// the next run starts from the oldest unseen email and retries failures.
+func (r *backlogRun) finish(statusWrite func(WatchStatus)) {
+	if r == nil || r.checkpoint == nil {
+		return
+	}
+	if err := r.checkpoint.Clear(); err != nil {
+		statusWrite(WatchStatus{
+			Type:    "process",
+			Level:   "warn",
+			Message: fmt.Sprintf("Failed to clear checkpoint: %v", err),
+		})
+	}
+}
+
 // emailIsSeen checks if an email has the \Seen flag
 func (c *IMAPClient) emailIsSeen(uid uint32) (bool, error) {
 	uidSet := imap.UIDSetNum(imap.UID(uid))
@@ -210,35 +849,136 @@ func (c *IMAPClient) emailIsSeen(uid uint32) (bool, error) {
 }
 
 // processEmail processes a single email
-func (c *IMAPClient) processEmail(uid uint32, opts WatchOptions, statusWrite func(WatchStatus)) error {
+func (c *IMAPClient) processEmail(ctx context.Context, uid uint32, opts WatchOptions, statusWrite func(WatchStatus)) error {
 	// Fetch email metadata
-	metadata, err := c.fetchEmailMetadata(uid)
+	var metadata *EmailMetadata
+	err := throttled(ctx, opts, statusWrite, func() (err error) {
+		metadata, err = c.fetchEmailMetadata(uid)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to fetch metadata: %w", err)
 	}
+	metadata.SuppressAutoResponse = SuppressAutoResponse(&metadata.loopHeader)
+
+	if opts.Scan != nil {
+		if err := c.scanEmail(ctx, uid, opts, statusWrite); err != nil {
+			return err
+		}
+	}
 
 	// Fetch full email as a streaming reader (RFC 5322 format).
 	// The reader is backed by the IMAP connection and does not buffer the
 	// entire message in memory.
-	emailReader, cleanup, err := c.fetchRawEmailReader(uid)
+	var emailReader io.Reader
+	var cleanup func()
+	err = throttled(ctx, opts, statusWrite, func() (err error) {
+		emailReader, cleanup, err = c.fetchRawEmailReader(uid)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("failed to fetch email: %w", err)
 	}
 	defer cleanup()
+	// processed marks the email as processed once the fetch is released,
+	// which also covers handlers that did not read the whole email
+	processed := func() error {
+		cleanup()
+		return c.markAsProcessed(opts.Folder, uid, statusWrite)
+	}
 
 	// Notify stdout about new email
 	notification := EmailNotification{
 		Type:      "email",
 		UID:       uid,
+		Key:       metadata.Message.Key(),
 		MessageID: metadata.MessageID,
 		From:      metadata.From,
 		To:        metadata.To,
 		Subject:   metadata.Subject,
 		Date:      metadata.Date,
+		Received:  metadata.Received,
 		Flags:     metadata.Flags,
 	}
-	notifData, _ := json.Marshal(notification)
-	fmt.Fprintln(os.Stdout, string(notifData))
+	if opts.handlerFunc == nil {
+		notifData, _ := json.Marshal(notification)
+		fmt.Fprintln(os.Stdout, string(notifData))
+	}
+	if opts.notified != nil && opts.notified.first(uid, clockOrSystem(c.config.Clock).Now()) {
+		c.notify(ctx, opts.Notify, notification, metadata.SuppressAutoResponse, statusWrite)
+	}
+	if opts.rateAlerts != nil {
+		received, err := time.Parse(time.RFC3339, metadata.Received)
+		if err != nil {
+			received = clockOrSystem(c.config.Clock).Now()
+		}
+		rules, floods := opts.rateAlerts.observe(notification, received)
+		for i, f := range floods {
+			c.alertFlood(ctx, opts, rules[i], f, statusWrite)
+		}
+	}
+
+	if opts.AutoResponder && metadata.SuppressAutoResponse != "" {
+		statusWrite(WatchStatus{
+			Type:    "process",
+			Level:   "info",
+			Message: fmt.Sprintf("Not answering UID %d automatically (%s), marking as processed", uid, metadata.SuppressAutoResponse),
+			UID:     uid,
+		})
+		return processed()
+	}
+
+	if opts.Commands != nil {
+		// Emails without a directive continue to the handler from where
+		// the command check stopped reading
+		raw, err := io.ReadAll(io.LimitReader(emailReader, maxCommandEmailSize+1))
+		if err != nil {
+			return fmt.Errorf("failed to read email: %w", err)
+		}
+		ran, err := c.runCommand(ctx, uid, opts, metadata, raw, statusWrite)
+		if err != nil {
+			return err
+		}
+		if ran {
+			return processed()
+		}
+		emailReader = io.MultiReader(bytes.NewReader(raw), emailReader)
+	}
+
+	if opts.handlerFunc != nil {
+		statusWrite(WatchStatus{
+			Type:    "process",
+			Level:   "info",
+			Message: fmt.Sprintf("Processing UID %d with message handler", uid),
+			UID:     uid,
+		})
+		if err := opts.handlerFunc(metadata.Message, emailReader); err != nil {
+			return fmt.Errorf("message handler failed: %w", err)
+		}
+		statusWrite(WatchStatus{
+			Type:    "process",
+			Level:   "info",
+			Message: fmt.Sprintf("Message handler succeeded for UID %d, marking as processed", uid),
+			UID:     uid,
+		})
+		return processed()
+	}
+
+	grace := time.Duration(opts.ShutdownGrace) * time.Second
+	env := handlerEnv(opts.Account, opts.Folder, uid, metadata)
+
+	if p := matchPipeline(opts.Pipelines, notification); p != nil {
+		if err := c.runPipeline(ctx, p, uid, emailReader, env, grace, statusWrite); err != nil {
+			return err
+		}
+		statusWrite(WatchStatus{
+			Type:    "process",
+			Level:   "info",
+			Message: fmt.Sprintf("Pipeline %s finished for UID %d, marking as processed", p.Name, uid),
+			UID:     uid,
+		})
+		return processed()
+	}
 
 	// If no handler, just mark as processed
 	if opts.HandlerCmd == "" {
@@ -248,7 +988,7 @@ func (c *IMAPClient) processEmail(uid uint32, opts WatchOptions, statusWrite fun
 			Message: fmt.Sprintf("No handler configured, marking UID %d as processed", uid),
 			UID:     uid,
 		})
-		return c.markAsProcessed(uid, statusWrite)
+		return processed()
 	}
 
 	// Run handler
@@ -259,7 +999,7 @@ func (c *IMAPClient) processEmail(uid uint32, opts WatchOptions, statusWrite fun
 		UID:     uid,
 	})
 
-	exitCode, err := c.runHandler(opts.HandlerCmd, emailReader)
+	exitCode, err := c.runHandler(ctx, opts.HandlerShell, opts.HandlerCmd, env, grace, emailReader)
 	if err != nil {
 		return fmt.Errorf("handler execution failed: %w", err)
 	}
@@ -276,7 +1016,206 @@ func (c *IMAPClient) processEmail(uid uint32, opts WatchOptions, statusWrite fun
 		UID:     uid,
 	})
 
-	return c.markAsProcessed(uid, statusWrite)
+	return processed()
+}
+
+// maxThrottleRetries is how often throttled sends a command the server
+// keeps refusing for rate limits before giving up.
+const maxThrottleRetries = 5
+
+// throttled runs an IMAP command under opts.Throttle, if set: it waits for
+// the throttle first and then tells it how the command went, retrying the
+// command at the lowered rate while the server throttles it.
+func throttled(ctx context.Context, opts WatchOptions, statusWrite func(WatchStatus), cmd func() error) error {
+	t := opts.Throttle
+	if t == nil {
+		return cmd()
+	}
+	for attempt := 1; ; attempt++ {
+		if err := t.Wait(ctx); err != nil {
+			return err
+		}
+		err := cmd()
+		if !t.Report(err) || attempt == maxThrottleRetries {
+			return err
+		}
+		statusWrite(WatchStatus{
+			Type:    "throttle",
+			Level:   "warn",
+			Message: fmt.Sprintf("Server is throttling, slowing down to %.2g commands/s: %v", t.Rate(), err),
+		})
+	}
+}
+
+// scanEmail scans the attachments of an email and applies the scan policy
+// if one is infected. It fails when the email must not reach the handler:
+// scanning failed, opts.Scan.Reject is set, or the email was quarantined.
+func (c *IMAPClient) scanEmail(ctx context.Context, uid uint32, opts WatchOptions, statusWrite func(WatchStatus)) error {
+	var msg *Message
+	err := throttled(ctx, opts, statusWrite, func() (err error) {
+		msg, err = c.FetchMessage(opts.Folder, uid)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch email for scanning: %w", err)
+	}
+	infected, err := ScanAttachments(ctx, opts.Scan.Scanner, msg.Attachments)
+	if err != nil {
+		return fmt.Errorf("attachment scan failed: %w", err)
+	}
+	if len(infected) == 0 {
+		return nil
+	}
+
+	for _, res := range infected {
+		statusWrite(WatchStatus{
+			Type:    "scan",
+			Level:   "warn",
+			Message: fmt.Sprintf("Infected attachment %s in UID %d: %s", res.Attachment, uid, res.Detail),
+			UID:     uid,
+		})
+	}
+	if err := c.ApplyScanPolicy(opts.Folder, uid, opts.Scan); err != nil {
+		return err
+	}
+	if opts.Scan.QuarantineFolder != "" {
+		if c.changes != nil {
+			// MOVE may consume its own EXPUNGE responses
+			c.changes.invalidate()
+		}
+		return fmt.Errorf("infected attachment, moved to %s", opts.Scan.QuarantineFolder)
+	}
+	if opts.Scan.Reject {
+		return fmt.Errorf("infected attachment %s", infected[0].Attachment)
+	}
+	return nil
+}
+
+// notifyTimeout bounds each notification sent by Watch.
+const notifyTimeout = 30 * time.Second
+
+// notify sends n through every matching rule. suppress is the reason no
+// mail may be sent in response to the email, see SuppressAutoResponse; the
+// notifiers sending mail then skip it.
+func (c *IMAPClient) notify(ctx context.Context, rules []NotifyRule, n EmailNotification, suppress string, statusWrite func(WatchStatus)) {
+	for _, rule := range rules {
+		if !rule.Matches(n) || (suppress != "" && sendsMail(rule.Notifier)) {
+			continue
+		}
+		// A slow notification service must not stall the watch loop
+		nctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		err := rule.Notifier.Notify(nctx, n)
+		cancel()
+		if err != nil {
+			statusWrite(WatchStatus{
+				Type:    "notify",
+				Level:   "warn",
+				Message: fmt.Sprintf("Notification for UID %d failed: %v", n.UID, err),
+				UID:     n.UID,
+			})
+		}
+	}
+}
+
+// Every notifiedPruneEvery emails, notifiedEmails drops those notified
+// more than notifiedKeep ago.
+const (
+	notifiedPruneEvery = 1000
+	notifiedKeep       = 24 * time.Hour
+)
+
+// notifiedEmails are the emails a watch sent notifications for. An email
+// whose handler fails stays unseen and is handled again on the next poll
+// or retry, but notified once.
+type notifiedEmails struct {
+	at   map[uint32]time.Time // By UID
+	seen int
+}
+
+// first records the email uid as notified at t and reports whether it was
+// not already.
+func (n *notifiedEmails) first(uid uint32, t time.Time) bool {
+	if _, ok := n.at[uid]; ok {
+		return false
+	}
+	n.at[uid] = t
+
+	n.seen++
+	if n.seen%notifiedPruneEvery == 0 {
+		for uid, at := range n.at {
+			if !at.After(t.Add(-notifiedKeep)) {
+				delete(n.at, uid)
+			}
+		}
+	}
+	return true
+}
+
+// alertFlood reports f as a "flood" warning, passes it to opts.Flood and,
+// if rule asks for it, sends it through the notifiers of opts.Notify.
+func (c *IMAPClient) alertFlood(ctx context.Context, opts WatchOptions, rule RateAlert, f Flood, statusWrite func(WatchStatus)) {
+	title, body := floodText(f)
+	statusWrite(WatchStatus{
+		Type:    "flood",
+		Level:   "warn",
+		Message: title + ": " + body,
+		UID:     f.UID,
+	})
+	if opts.Flood != nil {
+		opts.Flood(f)
+	}
+	if !rule.Notify {
+		return
+	}
+	for _, n := range opts.Notify {
+		s, ok := n.Notifier.(AlertSender)
+		if !ok {
+			continue
+		}
+		nctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+		err := s.SendAlert(nctx, title, body)
+		cancel()
+		if err != nil {
+			statusWrite(WatchStatus{
+				Type:    "notify",
+				Level:   "warn",
+				Message: fmt.Sprintf("Flood alert failed: %v", err),
+				UID:     f.UID,
+			})
+		}
+	}
+}
+
+// sendsMail reports whether notifier sends its notifications as emails.
+func sendsMail(notifier Notifier) bool {
+	switch n := notifier.(type) {
+	case *MailNotifier:
+		return true
+	case *DigestNotifier:
+		_, ok := n.Sender.(*MailNotifier)
+		return ok
+	}
+	return false
+}
+
+// flushDigests sends the pending digests of the rules.
+func (c *IMAPClient) flushDigests(rules []NotifyRule, statusWrite func(WatchStatus)) {
+	for _, rule := range rules {
+		d, ok := rule.Notifier.(*DigestNotifier)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		err := d.Flush(ctx)
+		cancel()
+		if err != nil {
+			statusWrite(WatchStatus{
+				Type:    "notify",
+				Level:   "warn",
+				Message: fmt.Sprintf("Notification digest failed: %v", err),
+			})
+		}
+	}
 }
 
 // EmailMetadata holds email metadata
@@ -286,15 +1225,32 @@ type EmailMetadata struct {
 	To        []string
 	Subject   string
 	Date      string
+	Received  string // INTERNALDATE
 	Flags     []string
+	Message   *Message // Envelope and flags, for a MessageHandler
+
+	// SuppressAutoResponse is why no mail may be sent in response to the
+	// email, "" if it may; see SuppressAutoResponse.
+	SuppressAutoResponse string
+
+	loopHeader textproto.Header // LoopHeaderFields
 }
 
 // fetchEmailMetadata fetches email metadata
 func (c *IMAPClient) fetchEmailMetadata(uid uint32) (*EmailMetadata, error) {
 	uidSet := imap.UIDSetNum(imap.UID(uid))
+	loopSection := &imap.FetchItemBodySection{
+		Specifier:    imap.PartSpecifierHeader,
+		HeaderFields: LoopHeaderFields,
+		Peek:         true,
+	}
 	msgs, err := c.client.Fetch(uidSet, &imap.FetchOptions{
-		Envelope: true,
-		Flags:    true,
+		Envelope:     true,
+		Flags:        true,
+		UID:          true,
+		RFC822Size:   true,
+		InternalDate: true,
+		BodySection:  []*imap.FetchItemBodySection{loopSection},
 	}).Collect()
 
 	if err != nil {
@@ -302,19 +1258,31 @@ func (c *IMAPClient) fetchEmailMetadata(uid uint32) (*EmailMetadata, error) {
 	}
 
 	if len(msgs) == 0 {
-		return nil, fmt.Errorf("no messages returned for UID %d", uid)
+		return nil, fmt.Errorf("%w: UID %d", ErrMessageNotFound, uid)
 	}
 
 	msg := msgs[0]
 
 	metadata := &EmailMetadata{
-		Flags: convertFlags(msg.Flags),
+		Flags:   convertFlags(msg.Flags),
+		Message: &Message{Size: uint32(msg.RFC822Size)},
+	}
+	fillIMAPMessage(metadata.Message, msg, make([]Address, 0, imapAddressCount(msg)))
+	if !msg.InternalDate.IsZero() {
+		metadata.Received = msg.InternalDate.Format(time.RFC3339)
+	}
+	if raw := msg.FindBodySection(loopSection); raw != nil {
+		if h, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(raw))); err == nil {
+			metadata.loopHeader = h
+		}
 	}
 
 	if env := msg.Envelope; env != nil {
 		metadata.MessageID = env.MessageID
 		metadata.Subject = env.Subject
-		metadata.Date = env.Date.Format(time.RFC1123)
+		if !env.Date.IsZero() {
+			metadata.Date = env.Date.Format(time.RFC3339)
+		}
 		if len(env.From) > 0 {
 			metadata.From = env.From[0].Addr()
 		}
@@ -328,6 +1296,90 @@ func (c *IMAPClient) fetchEmailMetadata(uid uint32) (*EmailMetadata, error) {
 	return metadata, nil
 }
 
+// internalDates returns the INTERNALDATE of the emails with uids, in one
+// UID FETCH.
+func (c *IMAPClient) internalDates(uids []imap.UID) (map[imap.UID]time.Time, error) {
+	msgs, err := c.client.Fetch(imap.UIDSetNum(uids...), &imap.FetchOptions{
+		UID:          true,
+		InternalDate: true,
+	}).Collect()
+	if err != nil {
+		return nil, err
+	}
+	dates := make(map[imap.UID]time.Time, len(msgs))
+	for _, msg := range msgs {
+		dates[msg.UID] = msg.InternalDate
+	}
+	return dates, nil
+}
+
+// syncChanges loads the UIDs of the selected folder into the change
+// tracker, if Watch reports changes.
+func (c *IMAPClient) syncChanges(folder string, statusWrite func(WatchStatus)) {
+	if c.changes == nil {
+		return
+	}
+	data, err := c.client.UIDSearch(&imap.SearchCriteria{}, nil).Wait()
+	if err != nil {
+		c.changes.invalidate()
+		statusWrite(WatchStatus{
+			Type:    "changes",
+			Level:   "warn",
+			Message: fmt.Sprintf("Failed to list UIDs, expunges may be reported without UID: %v", err),
+		})
+		return
+	}
+	all := data.AllUIDs()
+	uids := make([]uint32, len(all))
+	for i, uid := range all {
+		uids[i] = uint32(uid)
+	}
+	c.changes.reset(folder, uids)
+}
+
+// changeHandler passes the untagged EXPUNGE, EXISTS and FETCH responses the
+// server sends outside of commands (during IDLE or NOOP) to t, and then to
+// next (IMAPConfig.UnilateralDataHandler, may be nil) except for FETCH,
+// whose data t consumes. It runs on the client's reader goroutine and must
+// not issue commands.
+func changeHandler(t *changeTracker, next *imapclient.UnilateralDataHandler) *imapclient.UnilateralDataHandler {
+	var h imapclient.UnilateralDataHandler
+	if next != nil {
+		h = *next
+	}
+	h.Expunge = func(seqNum uint32) {
+		t.expunge(seqNum)
+		if next != nil && next.Expunge != nil {
+			next.Expunge(seqNum)
+		}
+	}
+	h.Mailbox = func(data *imapclient.UnilateralDataMailbox) {
+		if data.NumMessages != nil {
+			t.exists(*data.NumMessages)
+		}
+		if next != nil && next.Mailbox != nil {
+			next.Mailbox(data)
+		}
+	}
+	h.Fetch = func(msg *imapclient.FetchMessageData) {
+		var uid uint32
+		var flags []imap.Flag
+		hasFlags := false
+		for item := msg.Next(); item != nil; item = msg.Next() {
+			switch item := item.(type) {
+			case imapclient.FetchItemDataUID:
+				uid = uint32(item.UID)
+			case imapclient.FetchItemDataFlags:
+				flags, hasFlags = item.Flags, true
+			}
+		}
+		if hasFlags {
+			t.flags(msg.SeqNum, uid, convertFlags(flags))
+		}
+	}
+	return &h
+}
+
 // convertFlags converts imap.Flags to string slice
 func convertFlags(flags []imap.Flag) []string {
 	result := make([]string, 0, len(flags))
@@ -341,8 +1393,8 @@ func convertFlags(flags []imap.Flag) []string {
 // fetchRawEmailReader fetches the raw RFC 5322 email as a streaming reader.
 // It returns:
 //   - reader: an io.Reader backed by the IMAP literal (OS-pipe friendly).
-//   - cleanup: must be called after the reader is fully consumed to release
-//     the underlying IMAP fetch command.
+//   - cleanup: must be called before the next IMAP command to release the
+//     underlying IMAP fetch command, whether or not the reader was consumed.
 //   - err: any error from the IMAP FETCH.
 //
 // This avoids buffering the entire message in memory. The caller should pipe
@@ -381,10 +1433,15 @@ func (c *IMAPClient) fetchRawEmailReader(uid uint32) (io.Reader, func(), error)
 		return nil, func() {}, fmt.Errorf("no body section returned for UID %d", uid)
 	}
 
-	// cleanup drains remaining items and closes the fetch command so that the
-	// IMAP client can proceed with subsequent commands.
+	// cleanup discards what the caller left of the literal, drains the
+	// remaining items and closes the fetch command so that the IMAP client
+	// can proceed with subsequent commands. Calling it again does nothing.
+	var once sync.Once
 	cleanup := func() {
-		fetchCmd.Close()
+		once.Do(func() {
+			io.Copy(io.Discard, literal)
+			fetchCmd.Close()
+		})
 	}
 
 	return literal, cleanup, nil
@@ -394,10 +1451,24 @@ func (c *IMAPClient) fetchRawEmailReader(uid uint32) (io.Reader, func(), error)
 // process's stdin through an OS pipe. The kernel pipe buffer (~64 KB on
 // Linux, ~1 MB on macOS) provides automatic back-pressure so peak memory
 // usage stays bounded regardless of email size.
-func (c *IMAPClient) runHandler(cmd string, emailReader io.Reader) (int, error) {
-	// Use sh -c to wrap the command, supporting spaces and quotes in paths/args
-	cmdObj := exec.Command("sh", "-c", cmd)
-	cmdObj.Stdout = os.Stderr // Handler stdout goes to stderr
+// The command line runs through shell (see handlerCommand), so spaces and
+// quotes in paths and arguments work on every platform. env is added to
+// the environment emx-mail itself runs with.
+// Once ctx is cancelled the handler has grace to exit on its own before it
+// is killed, in which case an error is returned.
+func (c *IMAPClient) runHandler(ctx context.Context, shell, cmd string, env []string, grace time.Duration, emailReader io.Reader) (int, error) {
+	// Handler stdout goes to stderr
+	return c.runHandlerTo(ctx, shell, cmd, env, grace, emailReader, os.Stderr)
+}
+
+// runHandlerTo is runHandler writing the handler's stdout to stdout.
+func (c *IMAPClient) runHandlerTo(ctx context.Context, shell, cmd string, env []string, grace time.Duration, emailReader io.Reader, stdout io.Writer) (int, error) {
+	cmdObj, err := handlerCommand(shell, cmd)
+	if err != nil {
+		return 0, err
+	}
+	cmdObj.Env = append(os.Environ(), env...)
+	cmdObj.Stdout = stdout
 	cmdObj.Stderr = os.Stderr
 
 	stdinPipe, err := cmdObj.StdinPipe()
@@ -419,11 +1490,30 @@ func (c *IMAPClient) runHandler(cmd string, emailReader io.Reader) (int, error)
 		writeErr <- werr
 	}()
 
-	waitErr := cmdObj.Wait()
+	waitDone := make(chan error, 1)
+	go func() {
+		waitDone <- cmdObj.Wait()
+	}()
+
+	var waitErr error
+	select {
+	case waitErr = <-waitDone:
+	case <-ctx.Done():
+		timer := time.NewTimer(grace)
+		select {
+		case waitErr = <-waitDone:
+			timer.Stop()
+		case <-timer.C:
+			cmdObj.Process.Kill()
+			<-waitDone
+			return 0, fmt.Errorf("handler killed: still running %v after shutdown was requested", grace)
+		}
+	}
 
 	// Prefer the process exit error; surface write errors only if the
-	// process itself succeeded (e.g. broken pipe is expected when the
-	// handler exits early).
+	// process itself succeeded. A handler may exit successfully without
+	// reading the whole email: the broken pipe, or the pipe Wait closed
+	// under the copy, is not an error then.
 	if waitErr != nil {
 		if exitErr, ok := waitErr.(*exec.ExitError); ok {
 			return exitErr.ExitCode(), nil
@@ -431,15 +1521,16 @@ func (c *IMAPClient) runHandler(cmd string, emailReader io.Reader) (int, error)
 		return 1, waitErr
 	}
 
-	if wErr := <-writeErr; wErr != nil {
+	if wErr := <-writeErr; wErr != nil && !isBrokenPipe(wErr) && !errors.Is(wErr, os.ErrClosed) {
 		return 1, fmt.Errorf("failed writing to handler stdin: %w", wErr)
 	}
 
 	return 0, nil
 }
 
-// markAsProcessed marks an email as Seen
-func (c *IMAPClient) markAsProcessed(uid uint32, statusWrite func(WatchStatus)) error {
+// markAsProcessed marks an email in the selected folder as Seen
+func (c *IMAPClient) markAsProcessed(folder string, uid uint32, statusWrite func(WatchStatus)) error {
+	messageID := c.mutationMessageID(uid)
 	uidSet := imap.UIDSetNum(imap.UID(uid))
 
 	// Store flags: add Seen flag
@@ -451,6 +1542,7 @@ func (c *IMAPClient) markAsProcessed(uid uint32, statusWrite func(WatchStatus))
 	if err != nil {
 		return fmt.Errorf("failed to mark UID %d: %w", uid, err)
 	}
+	c.mutated(Mutation{Op: "flags", Folder: folder, UID: uid, MessageID: messageID, Add: []string{`\Seen`}})
 
 	statusWrite(WatchStatus{
 		Type:    "mark",
@@ -463,7 +1555,7 @@ func (c *IMAPClient) markAsProcessed(uid uint32, statusWrite func(WatchStatus))
 }
 
 // watchIDLE watches for new emails using IMAP IDLE
-func (c *IMAPClient) watchIDLE(ctx context.Context, opts WatchOptions, statusWrite func(WatchStatus)) error {
+func (c *IMAPClient) watchIDLE(ctx context.Context, opts WatchOptions, stats *watchStats, statusWrite func(WatchStatus)) error {
 	statusWrite(WatchStatus{
 		Type:    "idle",
 		Level:   "info",
@@ -513,6 +1605,17 @@ func (c *IMAPClient) watchIDLE(ctx context.Context, opts WatchOptions, statusWri
 
 		timer := time.NewTimer(idleTimeout)
 		select {
+		case <-c.changes.arrivals():
+			// EXISTS reported by the change tracker: new emails
+			timer.Stop()
+			idleCmd.Close()
+			<-done
+			statusWrite(WatchStatus{
+				Type:    "idle",
+				Level:   "info",
+				Message: "IDLE response received, new emails detected",
+			})
+
 		case <-ctx.Done():
 			timer.Stop()
 			idleCmd.Close()
@@ -558,7 +1661,7 @@ func (c *IMAPClient) watchIDLE(ctx context.Context, opts WatchOptions, statusWri
 		}
 
 		// Process new emails
-		if err := c.processUnprocessed(opts, statusWrite); err != nil {
+		if err := c.processUnprocessed(ctx, opts, nil, stats, statusWrite); err != nil {
 			statusWrite(WatchStatus{
 				Type:    "error",
 				Level:   "error",
@@ -582,7 +1685,7 @@ func (c *IMAPClient) watchIDLE(ctx context.Context, opts WatchOptions, statusWri
 }
 
 // watchPoll watches for new emails using polling
-func (c *IMAPClient) watchPoll(ctx context.Context, opts WatchOptions, statusWrite func(WatchStatus)) error {
+func (c *IMAPClient) watchPoll(ctx context.Context, opts WatchOptions, stats *watchStats, statusWrite func(WatchStatus)) error {
 	interval := time.Duration(opts.PollInterval) * time.Second
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -605,7 +1708,7 @@ func (c *IMAPClient) watchPoll(ctx context.Context, opts WatchOptions, statusWri
 
 		case <-ticker.C:
 			// Check for new emails
-			if err := c.processUnprocessed(opts, statusWrite); err != nil {
+			if err := c.processUnprocessed(ctx, opts, nil, stats, statusWrite); err != nil {
 				statusWrite(WatchStatus{
 					Type:    "error",
 					Level:   "error",
@@ -647,7 +1750,7 @@ func (c *IMAPClient) reconnect(ctx context.Context, opts WatchOptions, statusWri
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-time.After(waitTime):
+		case <-clockOrSystem(c.config.Clock).After(waitTime):
 		}
 
 		c.Close()
@@ -660,7 +1763,8 @@ func (c *IMAPClient) reconnect(ctx context.Context, opts WatchOptions, statusWri
 			continue
 		}
 
-		if _, err := c.client.Select(opts.Folder, nil).Wait(); err != nil {
+		data, old, err := c.selectFolder(opts.Folder, false)
+		if err != nil {
 			c.Close()
 			statusWrite(WatchStatus{
 				Type:    "connection",
@@ -675,8 +1779,27 @@ func (c *IMAPClient) reconnect(ctx context.Context, opts WatchOptions, statusWri
 			Level:   "info",
 			Message: "Reconnected successfully",
 		})
+		if old != 0 {
+			c.uidValidityChanged(opts.Folder, old, data.UIDValidity, statusWrite)
+			opts.backlog.renumbered()
+		}
+		c.syncChanges(opts.Folder, statusWrite)
 		return nil
 	}
 
 	return fmt.Errorf("failed to reconnect after %d attempts", opts.MaxRetries)
 }
+
+// uidValidityChanged reports that the watched folder was renumbered while
+// the watch was disconnected. The unseen emails are searched for again on
+// every check, so only the UIDs the change tracker holds are dropped.
+func (c *IMAPClient) uidValidityChanged(folder string, old, current uint32, statusWrite func(WatchStatus)) {
+	statusWrite(WatchStatus{
+		Type:    "uidvalidity",
+		Level:   "warn",
+		Message: fmt.Sprintf("UIDVALIDITY of %s changed from %d to %d, UIDs reported before are stale", folder, old, current),
+	})
+	if c.changes != nil {
+		c.changes.renumbered(folder)
+	}
+}
@@ -0,0 +1,91 @@
+package email
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emx-mail/cli/pkgs/testutil"
+)
+
+func TestParseBacklog(t *testing.T) {
+	tests := []struct {
+		policy string
+		all    bool
+		since  time.Duration
+		valid  bool
+	}{
+		{"", true, 0, true},
+		{"all", true, 0, true},
+		{"none", false, 0, true},
+		{"since:72h", false, 72 * time.Hour, true},
+		{"since:", false, 0, false},
+		{"since:-1h", false, 0, false},
+		{"recent", false, 0, false},
+	}
+	for _, tt := range tests {
+		all, since, err := parseBacklog(tt.policy)
+		if (err == nil) != tt.valid || all != tt.all || since != tt.since {
+			t.Errorf("parseBacklog(%q) = %v, %v, %v", tt.policy, all, since, err)
+		}
+	}
+}
+
+func TestBacklogFloor(t *testing.T) {
+	started := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	uids := func() []imap.UID { return []imap.UID{3, 7, 10, 12} }
+
+	// "none" skips the UIDs below UIDNEXT; "<uid>:*" may still return the last one
+	none := newBacklogFloor(started, 0, 11)
+	if got := none.filter(uids(), nil); len(got) != 1 || got[0] != 12 {
+		t.Errorf("none: filter() = %v, want [12]", got)
+	}
+
+	dates := map[imap.UID]time.Time{
+		3:  started.Add(-100 * time.Hour),
+		7:  started.Add(-30 * time.Hour),
+		10: started.Add(-2 * time.Hour),
+		12: started.Add(time.Minute),
+	}
+	since := newBacklogFloor(started, 24*time.Hour, 11)
+	if got := since.filter(uids(), dates); len(got) != 2 || got[0] != 10 || got[1] != 12 {
+		t.Errorf("since:24h: filter() = %v, want [10 12]", got)
+	}
+
+	// A renumbered folder keeps only what was received after the start
+	none.renumbered()
+	if got := none.filter(uids(), dates); len(got) != 1 || got[0] != 12 {
+		t.Errorf("renumbered none: filter() = %v, want [12]", got)
+	}
+	var nilFloor *backlogFloor
+	nilFloor.renumbered()
+}
+
+func TestReportStats(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC))
+	stats := &watchStats{started: clock.Now(), now: clock.Now}
+	stats.queued(3)
+	stats.handled(7, nil)
+	stats.handled(9, errors.New("handler failed"))
+
+	var got []WatchStats
+	opts := WatchOptions{StatsInterval: 3600, Stats: func(s WatchStats) { got = append(got, s) }}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go reportStats(opts, stats, stop, done)
+	close(stop)
+	<-done
+
+	// Stopping reports the final counters even before the first interval
+	if len(got) != 1 {
+		t.Fatalf("Stats called %d times, want 1", len(got))
+	}
+	if s := got[0]; s.Processed != 1 || s.Failed != 1 || s.Pending != 1 || s.LastUID != 9 {
+		t.Errorf("stats = %+v, want 1 processed, 1 failed, 1 pending, last UID 9", s)
+	}
+
+	clock.Advance(90 * time.Second)
+	if s := stats.snapshot(); s.Uptime != 90 {
+		t.Errorf("Uptime = %v, want 90", s.Uptime)
+	}
+}
@@ -0,0 +1,184 @@
+package event
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// blobsDir is the subdirectory holding content-addressed payload blobs.
+const blobsDir = "blobs"
+
+// BlobRef references a payload stored outside the events file, in
+// blobs/<sha256[:2]>/<sha256>. Identical content is stored once.
+type BlobRef struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	Binary bool   `json:"binary,omitempty"` // Content is raw bytes rather than JSON
+}
+
+// blobPath returns the file path of a blob.
+func (b *Bus) blobPath(sum string) string {
+	return filepath.Join(b.Dir, blobsDir, sum[:2], sum)
+}
+
+// AddBlob adds an event whose payload is arbitrary binary data read from r,
+// such as a raw email or an attachment. The data is streamed into blob
+// storage and the event only carries a BlobRef; use ResolvePayload or
+// OpenBlob to read it back.
+func (b *Bus) AddBlob(typ, channel string, r io.Reader) (*Event, error) {
+	ref, err := b.putBlob(r, true)
+	if err != nil {
+		return nil, err
+	}
+	return b.add(typ, channel, json.RawMessage("null"), ref)
+}
+
+// ResolvePayload returns the payload of evt, reading it from blob storage
+// when the event carries a BlobRef.
+func (b *Bus) ResolvePayload(evt *Event) ([]byte, error) {
+	if evt.Blob == nil {
+		return evt.Payload, nil
+	}
+	rc, err := b.OpenBlob(evt.Blob)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", evt.Blob.SHA256, err)
+	}
+	if int64(len(data)) != evt.Blob.Size {
+		return nil, fmt.Errorf("blob %s: size %d, want %d", evt.Blob.SHA256, len(data), evt.Blob.Size)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != evt.Blob.SHA256 {
+		return nil, fmt.Errorf("blob %s: checksum mismatch", evt.Blob.SHA256)
+	}
+	return data, nil
+}
+
+// OpenBlob opens a blob for streaming. The caller must close it.
+// Unlike ResolvePayload, the content is not verified against the reference.
+func (b *Bus) OpenBlob(ref *BlobRef) (io.ReadCloser, error) {
+	if !isHexDigest(ref.SHA256) {
+		return nil, fmt.Errorf("invalid blob reference %q", ref.SHA256)
+	}
+	f, err := os.Open(b.blobPath(ref.SHA256))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	return f, nil
+}
+
+// putBlob streams r into blob storage and returns its reference.
+func (b *Bus) putBlob(r io.Reader, binary bool) (*BlobRef, error) {
+	w, err := b.newBlobWriter(binary)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.abort()
+		return nil, fmt.Errorf("failed to write blob: %w", err)
+	}
+	return w.commit()
+}
+
+// blobWriter writes a blob to a temporary file while hashing it; commit
+// then renames it into place, so no lock is needed and readers never see
+// partial blobs.
+type blobWriter struct {
+	b      *Bus
+	tmp    *os.File
+	h      hash.Hash
+	size   int64
+	binary bool
+}
+
+func (b *Bus) newBlobWriter(binary bool) (*blobWriter, error) {
+	dir := filepath.Join(b.Dir, blobsDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob: %w", err)
+	}
+	return &blobWriter{b: b, tmp: tmp, h: sha256.New(), binary: binary}, nil
+}
+
+func (w *blobWriter) Write(p []byte) (int, error) {
+	n, err := w.tmp.Write(p)
+	w.h.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+// abort removes the unfinished blob.
+func (w *blobWriter) abort() {
+	w.tmp.Close()
+	os.Remove(w.tmp.Name())
+}
+
+// commit stores the blob written and returns its reference.
+func (w *blobWriter) commit() (*BlobRef, error) {
+	b := w.b
+	defer os.Remove(w.tmp.Name())
+	if err := w.tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	ref := &BlobRef{SHA256: hex.EncodeToString(w.h.Sum(nil)), Size: w.size, Binary: w.binary}
+	dst := b.blobPath(ref.SHA256)
+	if _, err := os.Stat(dst); err == nil {
+		return ref, nil // Already stored
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Rename(w.tmp.Name(), dst); err != nil {
+		// A concurrent writer may have stored the same content first
+		if _, serr := os.Stat(dst); serr == nil {
+			return ref, nil
+		}
+		return nil, fmt.Errorf("failed to store blob: %w", err)
+	}
+	return ref, nil
+}
+
+// offloadPayload moves a JSON payload larger than the blob threshold into
+// blob storage. Small payloads are returned unchanged with a nil reference.
+func (b *Bus) offloadPayload(payload json.RawMessage) (json.RawMessage, *BlobRef, error) {
+	if int64(len(payload)) <= b.blobThreshold() {
+		return payload, nil, nil
+	}
+	ref, err := b.putBlob(bytes.NewReader(payload), false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return json.RawMessage("null"), ref, nil
+}
+
+// blobThreshold returns the effective inline payload limit.
+func (b *Bus) blobThreshold() int64 {
+	if b.BlobThreshold <= 0 {
+		return DefaultBlobThreshold
+	}
+	return b.BlobThreshold
+}
+
+// isHexDigest reports whether s looks like a hex-encoded SHA-256 digest.
+func isHexDigest(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,125 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBusAddSmallPayloadInline(t *testing.T) {
+	bus := setupTestBus(t)
+
+	evt, err := bus.Add("test", "ch", json.RawMessage(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt.Blob != nil {
+		t.Fatalf("small payload should stay inline, got blob %+v", evt.Blob)
+	}
+	data, err := bus.ResolvePayload(evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"a":1}` {
+		t.Errorf("ResolvePayload = %s", data)
+	}
+}
+
+func TestBusAddLargePayloadOffloaded(t *testing.T) {
+	bus := setupTestBus(t)
+	bus.BlobThreshold = 64
+
+	big := `{"body":"` + strings.Repeat("x", 200) + `"}`
+	evt, err := bus.Add("email.received", "inbox", json.RawMessage(big))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt.Blob == nil {
+		t.Fatal("large payload should be stored as a blob")
+	}
+	if evt.Blob.Binary {
+		t.Error("JSON payload blob should not be marked binary")
+	}
+	if string(evt.Payload) != "null" {
+		t.Errorf("inline Payload = %s, want null", evt.Payload)
+	}
+	if _, err := os.Stat(filepath.Join(bus.Dir, "blobs", evt.Blob.SHA256[:2], evt.Blob.SHA256)); err != nil {
+		t.Fatalf("blob file missing: %v", err)
+	}
+
+	// The reference survives the round-trip through the events file
+	entries, err := bus.List("reader", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Blob == nil {
+		t.Fatalf("entries = %+v, want one event with a blob", entries)
+	}
+	data, err := bus.ResolvePayload(&entries[0].Event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != big {
+		t.Errorf("ResolvePayload returned %d bytes, want original payload", len(data))
+	}
+}
+
+func TestBusAddBlob(t *testing.T) {
+	bus := setupTestBus(t)
+
+	raw := []byte("From: alice@example.com\r\nSubject: hi\r\n\r\n\x00\x01\xff binary")
+	evt, err := bus.AddBlob("email.raw", "inbox", bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt.Blob == nil || !evt.Blob.Binary || evt.Blob.Size != int64(len(raw)) {
+		t.Fatalf("Blob = %+v", evt.Blob)
+	}
+
+	rc, err := bus.OpenBlob(evt.Blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, raw) {
+		t.Error("OpenBlob content mismatch")
+	}
+
+	// Identical content is stored once
+	evt2, err := bus.AddBlob("email.raw", "inbox", bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt2.Blob.SHA256 != evt.Blob.SHA256 {
+		t.Error("same content should produce the same reference")
+	}
+	dirs, _ := os.ReadDir(filepath.Join(bus.Dir, "blobs"))
+	if len(dirs) != 1 {
+		t.Errorf("blobs/ entries = %d, want 1 (no temp files left)", len(dirs))
+	}
+}
+
+func TestBusResolvePayloadCorrupt(t *testing.T) {
+	bus := setupTestBus(t)
+
+	evt, err := bus.AddBlob("x", "ch", strings.NewReader("original"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(bus.Dir, "blobs", evt.Blob.SHA256[:2], evt.Blob.SHA256)
+	if err := os.WriteFile(path, []byte("tampered"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bus.ResolvePayload(evt); err == nil {
+		t.Error("ResolvePayload should detect a checksum mismatch")
+	}
+
+	if _, err := bus.OpenBlob(&BlobRef{SHA256: "../../latest"}); err == nil {
+		t.Error("OpenBlob should reject an invalid reference")
+	}
+}
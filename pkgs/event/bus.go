@@ -13,6 +13,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,40 +21,107 @@ import (
 type fileTracking struct {
 	uncompressedSize int64
 	lineCount        int64
+	created          time.Time // Timestamp of the rotate event, zero until known
+	exact            bool      // uncompressedSize is the file's, not only what the bus appended; see catchUp
+	sinceIndex       int64     // Records after the last entry of the file's index, if exact
+	compressedSize   int64     // Size of the file when the bus last wrote it, if exact
 }
 
-// Bus is a file-based EventBus.
+// Bus is an EventBus. Its events and markers are kept by Store, by default
+// in files in Dir.
 type Bus struct {
-	Dir string // Event storage directory
+	Dir string // Event storage directory, also holding blobs
 
-	// In-memory tracking for current file (only valid during lock lifetime)
+	// Store keeps the events and markers; nil means the files in Dir.
+	Store Store
+
+	// MaxPayloadSize limits the payload size in bytes (<= 0 uses DefaultMaxPayloadSize).
+	MaxPayloadSize int64
+
+	// BlobThreshold is the payload size above which payloads are stored in
+	// blobs/ instead of inline (<= 0 uses DefaultBlobThreshold).
+	BlobThreshold int64
+
+	// Rotation starts new events files at time boundaries as well as on
+	// size; the zero policy rotates on size only.
+	Rotation RotationPolicy
+
+	// In-memory tracking of the files written, by path; shared with the
+	// buses of isolated channels. It stays valid while no other bus
+	// appends to a file, see appendEvents.
 	tracking map[string]*fileTracking
+
+	// Events files kept open between appends, by directory; the bus of an
+	// isolated channel keeps them in its parent's.
+	mu     sync.Mutex // Guards files
+	files  map[string]*eventFile
+	parent *Bus
 }
 
+// eventFile is an events file open for appending.
+type eventFile struct {
+	path string
+	f    *os.File
+	w    *bufio.Writer
+}
+
+// gzipWriters pools the gzip writers of appends: each holds several hundred
+// KB of compressor state, too much to allocate per event.
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
 // NewBus creates an EventBus using the specified directory.
 func NewBus(dir string) *Bus {
 	return &Bus{
-		Dir:      dir,
-		tracking: make(map[string]*fileTracking),
+		Dir:            dir,
+		MaxPayloadSize: DefaultMaxPayloadSize,
+		tracking:       make(map[string]*fileTracking),
 	}
 }
 
-// DefaultBus creates an EventBus using the default path (~/.emx-mail/events/).
+// DefaultBus creates an EventBus using the default path (~/.emx-mail/events/)
+// and the store configured there (see OpenBus).
 func DefaultBus() (*Bus, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user home directory: %w", err)
 	}
 	dir := filepath.Join(home, ".emx-mail", "events")
-	return NewBus(dir), nil
+	return OpenBus(dir)
 }
 
-// Init initializes the event directory, creating necessary subdirectories and the first events file.
+// store returns the Store of the bus.
+func (b *Bus) store() Store {
+	if b.Store != nil {
+		return b.Store
+	}
+	return fileStore{b}
+}
+
+// filesOnly fails for operations on the events files when the bus uses
+// another store.
+func (b *Bus) filesOnly(op string) error {
+	if b.Store != nil {
+		return fmt.Errorf("%s needs the file event store", op)
+	}
+	return nil
+}
+
+// Init initializes the store. For the file store it creates the event
+// directory, its subdirectories and the first events file.
 func (b *Bus) Init() error {
+	return b.store().Init()
+}
+
+// initFiles initializes the event directory of the file store.
+func (b *Bus) initFiles() error {
 	if err := os.MkdirAll(filepath.Join(b.Dir, "markers"), 0o755); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
-	// If there's no latest file yet, create the first file
+	return b.ensureLatest()
+}
+
+// ensureLatest creates the first events file if there's no latest file yet.
+func (b *Bus) ensureLatest() error {
 	_, err := b.latestName()
 	if err != nil {
 		_, err = b.createNewFile(1)
@@ -63,8 +131,22 @@ func (b *Bus) Init() error {
 }
 
 // Add adds an event to the EventBus. Protected by exclusive lock.
+// Payloads larger than BlobThreshold are moved to blob storage (see ResolvePayload).
 func (b *Bus) Add(typ, channel string, payload json.RawMessage) (*Event, error) {
-	unlock, err := b.lock()
+	if limit := b.maxPayloadSize(); int64(len(payload)) > limit {
+		return nil, fmt.Errorf("%w: %d bytes (limit %d)", ErrPayloadTooLarge, len(payload), limit)
+	}
+
+	payload, ref, err := b.offloadPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return b.add(typ, channel, payload, ref)
+}
+
+// add appends an event with an inline payload or blob reference.
+func (b *Bus) add(typ, channel string, payload json.RawMessage, blob *BlobRef) (*Event, error) {
+	unlock, err := b.store().Lock(true)
 	if err != nil {
 		return nil, err
 	}
@@ -73,101 +155,557 @@ func (b *Bus) Add(typ, channel string, payload json.RawMessage) (*Event, error)
 	if err := b.Init(); err != nil {
 		return nil, err
 	}
+	return b.addLocked(typ, channel, payload, blob)
+}
 
+// addLocked appends an event to the store. Caller must hold the exclusive
+// lock.
+func (b *Bus) addLocked(typ, channel string, payload json.RawMessage, blob *BlobRef) (*Event, error) {
 	evt := &Event{
 		ID:        generateID(),
 		Timestamp: time.Now().UTC(),
 		Type:      typ,
 		Channel:   channel,
 		Payload:   payload,
+		Blob:      blob,
+	}
+
+	if err := b.store().AppendEvent(evt); err != nil {
+		return nil, err
 	}
 
-	line, err := json.Marshal(evt)
+	return evt, nil
+}
+
+// AddBatch adds events in order, taking the lock once and, with the file
+// store, writing them in one gzip member and syncing the file once, which
+// is much faster than an Add per event for bulk publishers. The Type,
+// Channel and Payload of each event are used; AddBatch sets its ID and
+// Timestamp, and moves large payloads to blobs as Add does. If a payload
+// is over MaxPayloadSize, no event is added.
+func (b *Bus) AddBatch(events []Event) error {
+	limit := b.maxPayloadSize()
+	for i, evt := range events {
+		if int64(len(evt.Payload)) > limit {
+			return fmt.Errorf("event %d: %w: %d bytes (limit %d)", i, ErrPayloadTooLarge, len(evt.Payload), limit)
+		}
+	}
+	for i := range events {
+		payload, ref, err := b.offloadPayload(events[i].Payload)
+		if err != nil {
+			return err
+		}
+		events[i].Payload, events[i].Blob = payload, ref
+	}
+
+	unlock, err := b.store().Lock(true)
 	if err != nil {
-		return nil, fmt.Errorf("failed to serialize event: %w", err)
+		return err
 	}
-	line = append(line, '\n')
+	defer unlock()
 
-	// Check if rotation is needed
-	latestFile, err := b.latestName()
+	if err := b.Init(); err != nil {
+		return err
+	}
+	evts := make([]*Event, len(events))
+	for i := range events {
+		events[i].ID = generateID()
+		events[i].Timestamp = time.Now().UTC()
+		evts[i] = &events[i]
+	}
+	if s, ok := b.store().(BatchStore); ok {
+		return s.AppendEvents(evts)
+	}
+	for _, evt := range evts {
+		if err := b.store().AppendEvent(evt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddLinked adds an event whose payload is built from the last event of
+// the channel, e.g. to chain events by hash. build gets nil if the channel
+// has no events yet. The exclusive lock is held from reading the last event
+// to appending the new one, so concurrent writers cannot fork the chain.
+// Every call reads the channel's whole history; isolate busy channels.
+func (b *Bus) AddLinked(typ, channel string, build func(last *Event) (json.RawMessage, error)) (*Event, error) {
+	unlock, err := b.store().Lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := b.Init(); err != nil {
+		return nil, err
+	}
+
+	entries, err := b.store().ReadFrom(channel, Position{}, 0)
+	if err != nil {
+		return nil, err
+	}
+	var last *Event
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Channel == channel {
+			last = &entries[i].Event
+			break
+		}
+	}
+
+	payload, err := build(last)
 	if err != nil {
 		return nil, err
 	}
+	if limit := b.maxPayloadSize(); int64(len(payload)) > limit {
+		return nil, fmt.Errorf("%w: %d bytes (limit %d)", ErrPayloadTooLarge, len(payload), limit)
+	}
+	payload, ref, err := b.offloadPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return b.addLocked(typ, channel, payload, ref)
+}
+
+// appendEvents writes evts to the latest events file in one gzip member,
+// or a few to start one at each index entry, rotating first or in between
+// as needed, then flushes and syncs the file and extends its index. The
+// file stays open for the next append. appended, if set, is called with the
+// index, file name and uncompressed offset just after each event; the
+// offset is exact as long as tracking for that file is, which catchUp
+// ensures unless the file can't be read. Caller must hold the exclusive
+// lock.
+func (b *Bus) appendEvents(evts []*Event, appended func(i int, name string, end int64)) error {
+	owner := b.owner()
+	owner.mu.Lock()
+	defer owner.mu.Unlock()
 
-	tracking := b.getTracking(latestFile)
-	if tracking.uncompressedSize+int64(len(line))+RotationHeadroom >= MaxUncompressedSize {
-		// Need to rotate
-		seq := parseSeq(latestFile)
-		newFile, err := b.createNewFile(seq + 1)
+	latestFile, err := b.latestName()
+	if err != nil {
+		return err
+	}
+
+	// A failed write may leave a partial member in the buffer; reopen the
+	// file for the next append instead
+	fail := func(format string, err error) error {
+		owner.closeFile(b.Dir)
+		return fmt.Errorf(format, err)
+	}
+
+	gw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(gw)
+	cw := &countingWriter{w: gw}
+	enc := json.NewEncoder(cw)
+
+	var out *eventFile
+	var tracking *fileTracking
+	var written *countingWriter // Compressed size of out, with what is buffered
+	var index []indexEntry      // Entries to add to the index of out
+	member := false             // Whether gw has a member open on out
+	open := func() error {
+		var err error
+		if out, err = b.openFile(owner, latestFile); err != nil {
+			return err
+		}
+		fi, err := out.f.Stat()
 		if err != nil {
-			return nil, fmt.Errorf("rotation failed: %w", err)
+			return fail("failed to open event file: %w", err)
+		}
+		written = &countingWriter{w: out.w, n: fi.Size()}
+		index = nil
+		return nil
+	}
+	// finish ends the member, writes out and then its index entries, so
+	// they never point past the events. The index only speeds up reads:
+	// the events are written even if it can't be.
+	finish := func() error {
+		if member {
+			if err := gw.Close(); err != nil {
+				return fail("failed to close gzip writer: %w", err)
+			}
+			member = false
 		}
-		latestFile = newFile
-		tracking = b.getTracking(latestFile)
+		if err := out.sync(); err != nil {
+			return fail("failed to write event file: %w", err)
+		}
+		tracking.compressedSize = written.n
+		appendIndex(out.path, index, false)
+		return nil
+	}
+
+	if err := open(); err != nil {
+		return err
+	}
+	// Another bus appending since makes the tracking stale
+	tracking = b.getTracking(latestFile)
+	if !tracking.exact || tracking.compressedSize != written.n {
+		*tracking = fileTracking{created: tracking.created}
+		b.catchUp(latestFile, tracking)
+	}
+	for i, evt := range evts {
+		// The event envelope (id, type, channel...) is small enough to be
+		// covered by RotationHeadroom.
+		if tracking.uncompressedSize+int64(len(evt.Payload))+RotationHeadroom >= MaxUncompressedSize ||
+			b.rotationDue(latestFile) {
+			if err := finish(); err != nil {
+				return err
+			}
+			newFile, err := b.createNewFile(parseSeq(latestFile) + 1)
+			if err != nil {
+				return fmt.Errorf("rotation failed: %w", err)
+			}
+			latestFile = newFile
+			tracking = b.getTracking(latestFile)
+			if err := open(); err != nil {
+				return err
+			}
+		}
+
+		// Index entries start new members, where readers can seek to
+		indexDue := tracking.exact && tracking.sinceIndex >= IndexInterval
+		if member && indexDue {
+			if err := gw.Close(); err != nil {
+				return fail("failed to close gzip writer: %w", err)
+			}
+			member = false
+		}
+		if !member {
+			if indexDue {
+				index = append(index, indexEntry{Offset: tracking.uncompressedSize, Compressed: written.n})
+				tracking.sinceIndex = 0
+			}
+			gw.Reset(written)
+			member = true
+		}
+
+		// Encode straight into the gzip writer so large payloads are not
+		// copied into an intermediate line buffer.
+		n := cw.n
+		if err := enc.Encode(evt); err != nil {
+			return fail("failed to write event: %w", err)
+		}
+		tracking.uncompressedSize += cw.n - n
+		tracking.lineCount++
+		tracking.sinceIndex++
+		if appended != nil {
+			appended(i, latestFile, tracking.uncompressedSize)
+		}
+	}
+	return finish()
+}
+
+// owner returns the bus keeping the open events files of b.
+func (b *Bus) owner() *Bus {
+	if b.parent != nil {
+		return b.parent
+	}
+	return b
+}
+
+// openFile returns the events file name of b.Dir open for appending. The
+// file is kept open in owner, and reused until another file is written in
+// b.Dir or it is replaced on disk. Caller must hold owner.mu.
+func (b *Bus) openFile(owner *Bus, name string) (*eventFile, error) {
+	fpath := filepath.Join(b.Dir, name)
+	if out := owner.files[b.Dir]; out != nil {
+		if out.path == fpath && out.current() {
+			return out, nil
+		}
+		owner.closeFile(b.Dir)
 	}
 
-	// Append event to gzip file (concatenate new gzip member)
-	fpath := filepath.Join(b.Dir, latestFile)
 	f, err := os.OpenFile(fpath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open event file: %w", err)
 	}
-	defer f.Close()
+	out := &eventFile{path: fpath, f: f, w: bufio.NewWriterSize(f, 64*1024)}
+	if owner.files == nil {
+		owner.files = make(map[string]*eventFile)
+	}
+	owner.files[b.Dir] = out
+	return out, nil
+}
 
-	gw := gzip.NewWriter(f)
-	if _, err := gw.Write(line); err != nil {
-		return nil, fmt.Errorf("failed to write event: %w", err)
+// closeFile closes the events file kept open for dir, if any. Caller must
+// hold b.mu.
+func (b *Bus) closeFile(dir string) error {
+	out := b.files[dir]
+	if out == nil {
+		return nil
 	}
-	if err := gw.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close gzip writer: %w", err)
+	delete(b.files, dir)
+	return out.f.Close()
+}
+
+// Close closes the events files the bus keeps open between appends. The
+// bus stays usable; the next append opens the latest file again.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var firstErr error
+	for dir := range b.files {
+		if err := b.closeFile(dir); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
+}
 
-	// Update tracking
-	tracking.uncompressedSize += int64(len(line))
-	tracking.lineCount++
+// current reports whether the open file is still the one at its path,
+// rather than one removed or replaced since.
+func (out *eventFile) current() bool {
+	fi, err := out.f.Stat()
+	if err != nil {
+		return false
+	}
+	onDisk, err := os.Stat(out.path)
+	return err == nil && os.SameFile(fi, onDisk)
+}
 
-	return evt, nil
+// sync writes the buffered data to the file and its storage.
+func (out *eventFile) sync() error {
+	if err := out.w.Flush(); err != nil {
+		return err
+	}
+	return out.f.Sync()
+}
+
+// AddReader adds an event whose JSON payload is read from r (a file, stdin...).
+// At most MaxPayloadSize bytes are read; larger input fails with ErrPayloadTooLarge.
+// The payload is validated and compacted onto a single line as it is read,
+// and streamed into blob storage once it exceeds BlobThreshold, so only up
+// to BlobThreshold bytes of it are held in memory.
+func (b *Bus) AddReader(typ, channel string, r io.Reader) (*Event, error) {
+	limit := b.maxPayloadSize()
+	in := &countingReader{r: io.LimitReader(r, limit+1)}
+	out := &payloadWriter{b: b}
+	err := validateJSON(io.TeeReader(in, &compactWriter{w: out}))
+	if err != nil {
+		// Report oversized input as such, even when it breaks off mid-value
+		io.Copy(io.Discard, in)
+	}
+	switch {
+	case in.n > limit:
+		err = fmt.Errorf("%w: more than %d bytes", ErrPayloadTooLarge, limit)
+	case err != nil:
+		err = fmt.Errorf("invalid JSON payload: %w", err)
+	}
+	if err != nil {
+		out.abort()
+		return nil, err
+	}
+
+	if out.blob == nil {
+		return b.add(typ, channel, out.buf.Bytes(), nil)
+	}
+	ref, err := out.blob.commit()
+	if err != nil {
+		return nil, err
+	}
+	return b.add(typ, channel, json.RawMessage("null"), ref)
+}
+
+// validateJSON reads a single JSON value from r, token by token, and
+// returns an error if it is not valid or followed by anything but
+// whitespace.
+func validateJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if d, ok := tok.(json.Delim); ok {
+			if d == '{' || d == '[' {
+				depth++
+			} else {
+				depth--
+			}
+		}
+		if depth == 0 {
+			break
+		}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = fmt.Errorf("unexpected data after the payload")
+		}
+		return err
+	}
+	return nil
+}
+
+// compactWriter writes JSON text to w without the whitespace between
+// tokens, like json.Compact. The text is validated separately.
+type compactWriter struct {
+	w        io.Writer
+	inString bool
+	escaped  bool
+	out      []byte
+}
+
+func (c *compactWriter) Write(p []byte) (int, error) {
+	c.out = c.out[:0]
+	for _, ch := range p {
+		switch {
+		case c.inString:
+			switch {
+			case c.escaped:
+				c.escaped = false
+			case ch == '\\':
+				c.escaped = true
+			case ch == '"':
+				c.inString = false
+			}
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			continue
+		case ch == '"':
+			c.inString = true
+		}
+		c.out = append(c.out, ch)
+	}
+	if _, err := c.w.Write(c.out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// payloadWriter keeps a payload in buf up to the blob threshold and moves
+// it into a blob once it grows past it.
+type payloadWriter struct {
+	b    *Bus
+	buf  bytes.Buffer
+	blob *blobWriter
+}
+
+func (w *payloadWriter) Write(p []byte) (int, error) {
+	if w.blob == nil && int64(w.buf.Len()+len(p)) > w.b.blobThreshold() {
+		blob, err := w.b.newBlobWriter(false)
+		if err != nil {
+			return 0, err
+		}
+		w.blob = blob
+		if _, err := w.blob.Write(w.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		w.buf.Reset()
+	}
+	if w.blob != nil {
+		return w.blob.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// abort removes the blob started, if any.
+func (w *payloadWriter) abort() {
+	if w.blob != nil {
+		w.blob.abort()
+	}
 }
 
 // List lists new events from the specified channel starting from the marker position.
-// If the channel has no marker, starts from the earliest file.
+// If the channel has no marker, or its marker points at an events file that
+// was regenerated since, starts from the earliest file.
+// Isolated channels are read from their own directory, other channels from the shared files.
 // limit <= 0 means no limit.
 func (b *Bus) List(channel string, limit int) ([]EventEntry, error) {
-	unlock, err := b.lock()
+	return b.ListWith(channel, ListOptions{Limit: limit})
+}
+
+// ListOptions selects the events returned by ListWith.
+type ListOptions struct {
+	Limit   int       // At most this many events, counted in output order; <= 0 means no limit
+	Tail    int       // Only the last N events of the whole history, ignoring the marker
+	Since   time.Time // Only events from this time on, from the whole history, ignoring the marker
+	Reverse bool      // Newest first
+}
+
+// ListWith lists the events of the specified channel like List, with
+// options to inspect recent history without reading or moving the marker.
+func (b *Bus) ListWith(channel string, opts ListOptions) ([]EventEntry, error) {
+	unlock, err := b.store().Lock(false)
 	if err != nil {
 		return nil, err
 	}
 	defer unlock()
 
-	marker, err := b.LoadMarker(channel)
-	if err != nil && !os.IsNotExist(err) {
-		return nil, err
+	history := opts.Tail > 0 || !opts.Since.IsZero()
+	var pos Position
+	if !history {
+		if pos, err = b.markerPosition(channel); err != nil {
+			return nil, err
+		}
 	}
 
-	files, err := b.listFiles()
+	// Without filters or reordering the store can stop at the limit
+	readLimit := opts.Limit
+	if history || opts.Reverse {
+		readLimit = 0
+	}
+	entries, err := b.store().ReadFrom(channel, pos, readLimit)
 	if err != nil {
 		return nil, err
 	}
-	if len(files) == 0 {
-		return nil, nil
+
+	if !opts.Since.IsZero() {
+		kept := entries[:0]
+		for _, e := range entries {
+			if !e.Timestamp.Before(opts.Since) {
+				kept = append(kept, e)
+			}
+		}
+		entries = kept
+	}
+	if opts.Tail > 0 && len(entries) > opts.Tail {
+		entries = entries[len(entries)-opts.Tail:]
+	}
+	if opts.Reverse {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
 	}
+	if opts.Limit > 0 && len(entries) > opts.Limit {
+		entries = entries[:opts.Limit]
+	}
+	return entries, nil
+}
 
-	var startFile string
-	var startOffset int64
+// ListFrom lists the events of the specified channel after pos, ignoring
+// and leaving alone the channel's marker. A zero Position starts from the
+// earliest file, so it reads the whole history.
+// limit <= 0 means no limit.
+func (b *Bus) ListFrom(channel string, pos Position, limit int) ([]EventEntry, error) {
+	unlock, err := b.store().Lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return b.store().ReadFrom(channel, pos, limit)
+}
 
-	if marker != nil {
-		startFile = marker.File
-		startOffset = marker.Offset
-	} else {
-		startFile = files[0]
-		startOffset = 0
+func (b *Bus) listFrom(channel string, pos Position, limit int) ([]EventEntry, error) {
+	files, err := b.channelFiles(channel)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
 	}
 
-	// Find starting file index
+	// Find starting file index. A position pointing at a file outside this
+	// layout (e.g. before the channel was isolated) starts from the beginning.
 	startIdx := 0
+	var startOffset int64
 	for i, f := range files {
-		if f == startFile {
+		if f == pos.File {
 			startIdx = i
+			startOffset = pos.Offset
 			break
 		}
 	}
@@ -196,16 +734,18 @@ func (b *Bus) List(channel string, limit int) ([]EventEntry, error) {
 
 // Mark updates the consumption position for a channel.
 func (b *Bus) Mark(channel string, pos Position) error {
-	unlock, err := b.lock()
+	unlock, err := b.store().Lock(true)
 	if err != nil {
 		return err
 	}
 	defer unlock()
 
-	// Verify file exists
-	fpath := filepath.Join(b.Dir, pos.File)
-	if _, err := os.Stat(fpath); err != nil {
-		return fmt.Errorf("event file %s does not exist: %w", pos.File, err)
+	// File store positions name an events file; verify it exists
+	if b.Store == nil {
+		fpath := filepath.Join(b.Dir, pos.File)
+		if _, err := os.Stat(fpath); err != nil {
+			return fmt.Errorf("event file %s does not exist: %w", pos.File, err)
+		}
 	}
 
 	m := &Marker{
@@ -219,8 +759,16 @@ func (b *Bus) Mark(channel string, pos Position) error {
 
 // Status returns the status of the specified file, empty name means latest.
 func (b *Bus) Status(name string) (*FileStatus, error) {
+	if err := b.filesOnly("status"); err != nil {
+		return nil, err
+	}
+	unlock, err := b.rlock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	if name == "" {
-		var err error
 		name, err = b.latestName()
 		if err != nil {
 			return nil, fmt.Errorf("no active event file: %w", err)
@@ -253,68 +801,29 @@ func (b *Bus) Status(name string) (*FileStatus, error) {
 
 // ListFiles returns all event file names (in sequence order).
 func (b *Bus) ListFiles() ([]string, error) {
+	if err := b.filesOnly("listing files"); err != nil {
+		return nil, err
+	}
 	return b.listFiles()
 }
 
 // --- Internal methods ---
 
-// getTracking returns the tracking info for a file, creating it if needed.
-func (b *Bus) getTracking(file string) *fileTracking {
-	if b.tracking[file] == nil {
-		b.tracking[file] = &fileTracking{}
+// maxPayloadSize returns the effective payload size limit.
+func (b *Bus) maxPayloadSize() int64 {
+	if b.MaxPayloadSize <= 0 {
+		return DefaultMaxPayloadSize
 	}
-	return b.tracking[file]
+	return b.MaxPayloadSize
 }
 
-// lock acquires an exclusive lock. Returns an unlock function.
-func (b *Bus) lock() (func(), error) {
-	lockPath := filepath.Join(b.Dir, "events.lock")
-	if err := os.MkdirAll(b.Dir, 0o755); err != nil {
-		return nil, fmt.Errorf("failed to create directory: %w", err)
-	}
-
-	// Try to exclusively create lock file, with retry
-	var f *os.File
-	var err error
-	for attempts := 0; attempts < 50; attempts++ {
-		f, err = os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
-		if err == nil {
-			break
-		}
-		if os.IsExist(err) {
-			// Check if lock holder is still alive by reading PID
-			if data, rerr := os.ReadFile(lockPath); rerr == nil {
-				if pid, perr := strconv.Atoi(strings.TrimSpace(string(data))); perr == nil {
-					proc, _ := os.FindProcess(pid)
-					// On Unix, FindProcess always succeeds; use Signal(0) to check.
-					// On Windows, FindProcess fails for non-existent PIDs.
-					if proc != nil && proc.Signal(nil) == nil {
-						// Process exists — lock is held; wait and retry
-						time.Sleep(100 * time.Millisecond)
-						continue
-					}
-				}
-			}
-			// PID missing, unparseable, or process dead — stale lock
-			os.Remove(lockPath)
-			continue
-		}
-		return nil, fmt.Errorf("failed to create lock file: %w", err)
-	}
-	if f == nil {
-		return nil, fmt.Errorf("failed to acquire lock: %s", lockPath)
+// getTracking returns the tracking info for a file, creating it if needed.
+func (b *Bus) getTracking(file string) *fileTracking {
+	key := filepath.Join(b.Dir, file)
+	if b.tracking[key] == nil {
+		b.tracking[key] = &fileTracking{}
 	}
-	// Write our PID into the lock file
-	fmt.Fprintf(f, "%d", os.Getpid())
-	f.Close()
-
-	// Clear tracking on lock acquisition
-	b.tracking = make(map[string]*fileTracking)
-
-	return func() {
-		os.Remove(lockPath)
-		b.tracking = make(map[string]*fileTracking)
-	}, nil
+	return b.tracking[key]
 }
 
 // latestName reads the latest file and returns the currently active events file name.
@@ -336,9 +845,10 @@ func (b *Bus) setLatest(name string) error {
 func (b *Bus) createNewFile(seq int) (string, error) {
 	// Create rotate event
 	uuid := generateUUID()
+	created := time.Now().UTC()
 	rotateEvt := &Event{
 		ID:        generateID(),
-		Timestamp: time.Now().UTC(),
+		Timestamp: created,
 		Type:      RotateEventType,
 		Channel:   "",
 	}
@@ -363,18 +873,28 @@ func (b *Bus) createNewFile(seq int) (string, error) {
 	}
 	defer f.Close()
 
-	gw := gzip.NewWriter(f)
+	gw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(gw)
+	gw.Reset(f)
 	if _, err := gw.Write(rotateLine); err != nil {
 		return "", fmt.Errorf("failed to write rotate event: %w", err)
 	}
 	if err := gw.Close(); err != nil {
 		return "", fmt.Errorf("failed to close gzip writer: %w", err)
 	}
+	fi, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to create event file: %w", err)
+	}
 
 	// Initialize tracking for this file
-	b.tracking[name] = &fileTracking{
+	*b.getTracking(name) = fileTracking{
 		uncompressedSize: int64(len(rotateLine)),
 		lineCount:        1,
+		created:          created,
+		exact:            true,
+		sinceIndex:       1,
+		compressedSize:   fi.Size(),
 	}
 
 	if err := b.setLatest(name); err != nil {
@@ -466,7 +986,10 @@ func (b *Bus) getFileStats(name string) (uncompressedSize int64, lineCount int64
 		}
 	}
 
-	return cr.n, lc, firstLine, scanner.Err()
+	if err := scanner.Err(); err != nil && err != io.ErrUnexpectedEOF {
+		return cr.n, lc, firstLine, err
+	}
+	return cr.n, lc, firstLine, nil
 }
 
 // countingReader wraps an io.Reader and counts bytes read.
@@ -481,6 +1004,18 @@ func (c *countingReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// countingWriter wraps an io.Writer and counts bytes written.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
 // readFile reads events from a gzip file, starting from the specified uncompressed byte offset.
 // It streams line by line without loading the entire file into memory.
 func (b *Bus) readFile(name string, fromOffset int64) ([]EventEntry, error) {
@@ -500,7 +1035,33 @@ func (b *Bus) readFile(name string, fromOffset int64) ([]EventEntry, error) {
 		return nil, nil
 	}
 
-	gr, err := gzip.NewReader(f)
+	// Start from the last indexed member before fromOffset instead of
+	// decompressing the file from the beginning
+	if start := indexStart(loadIndex(fpath), fromOffset); start.Compressed > 0 {
+		if _, err := f.Seek(start.Compressed, io.SeekStart); err != nil {
+			return nil, err
+		}
+		entries, err := readEvents(f, name, fromOffset-start.Offset)
+		if err == nil {
+			for i := range entries {
+				entries[i].Offset += start.Offset
+			}
+			return entries, nil
+		}
+		// The index does not match the file; read it whole
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return readEvents(f, name, fromOffset)
+}
+
+// readEvents decodes a gzip-compressed JSONL stream, returning the events
+// after the uncompressed byte offset fromOffset. A stream cut short inside
+// its last gzip member (e.g. by a crash during append) yields the events
+// decoded up to that point instead of an error.
+func readEvents(r io.Reader, name string, fromOffset int64) ([]EventEntry, error) {
+	gr, err := gzip.NewReader(r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open gzip: %w", err)
 	}
@@ -512,7 +1073,7 @@ func (b *Bus) readFile(name string, fromOffset int64) ([]EventEntry, error) {
 	// Skip to fromOffset by discarding bytes
 	if fromOffset > 0 {
 		if _, err := io.CopyN(io.Discard, gr, fromOffset); err != nil {
-			if err == io.EOF {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
 				return nil, nil
 			}
 			return nil, fmt.Errorf("failed to seek to offset: %w", err)
@@ -555,5 +1116,8 @@ func (b *Bus) readFile(name string, fromOffset int64) ([]EventEntry, error) {
 		currentOffset = endOffset
 	}
 
-	return entries, scanner.Err()
+	if err := scanner.Err(); err != nil && err != io.ErrUnexpectedEOF {
+		return entries, err
+	}
+	return entries, nil
 }
@@ -2,13 +2,17 @@ package event
 
 import (
 	"bufio"
+	"bytes"
 	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 // --- Event type tests ---
@@ -121,6 +125,7 @@ func setupTestBus(t *testing.T) *Bus {
 	if err := bus.Init(); err != nil {
 		t.Fatalf("Init failed: %v", err)
 	}
+	t.Cleanup(func() { bus.Close() })
 	return bus
 }
 
@@ -319,6 +324,51 @@ func TestBusListWithMarker(t *testing.T) {
 	}
 }
 
+func TestBusListFrom(t *testing.T) {
+	bus := setupTestBus(t)
+
+	for i := 0; i < 5; i++ {
+		_, err := bus.Add("test", "ch1", json.RawMessage(`{"i":`+itoa(i)+`}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := bus.List("reader", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Mark("reader", Position{File: all[4].File, Offset: all[4].Offset}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A zero position reads everything despite the marker
+	entries, err := bus.ListFrom("reader", Position{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("len(entries) = %d, want 5", len(entries))
+	}
+
+	entries, err = bus.ListFrom("reader", Position{File: all[1].File, Offset: all[1].Offset}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ID != all[2].ID || entries[1].ID != all[3].ID {
+		t.Fatalf("ListFrom after 2nd event = %v, want events 3 and 4", entries)
+	}
+
+	// The marker is left alone
+	remaining, err := bus.List("reader", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 0 {
+		t.Fatalf("len(remaining) = %d, want 0", len(remaining))
+	}
+}
+
 func TestBusListLimit(t *testing.T) {
 	bus := setupTestBus(t)
 
@@ -562,6 +612,50 @@ func TestBusMultipleChannels(t *testing.T) {
 	}
 }
 
+func TestBusAddLinked(t *testing.T) {
+	bus := setupTestBus(t)
+
+	link := func(last *Event) (json.RawMessage, error) {
+		n := 0
+		if last != nil {
+			var prev struct{ N int }
+			if err := json.Unmarshal(last.Payload, &prev); err != nil {
+				return nil, err
+			}
+			n = prev.N + 1
+		}
+		return json.RawMessage(fmt.Sprintf(`{"n":%d}`, n)), nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := bus.AddLinked("a", "chain", link); err != nil {
+			t.Fatal(err)
+		}
+		// Events of other channels are not the chain's last event
+		bus.Add("b", "other", json.RawMessage(`{"n":100}`))
+	}
+
+	entries, err := bus.ListFrom("chain", Position{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		if e.Channel == "chain" {
+			got = append(got, string(e.Payload))
+		}
+	}
+	if want := []string{`{"n":0}`, `{"n":1}`, `{"n":2}`}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("chain = %v, want %v", got, want)
+	}
+
+	if _, err := bus.AddLinked("a", "chain", func(*Event) (json.RawMessage, error) {
+		return nil, errors.New("refused")
+	}); err == nil {
+		t.Error("build error not returned")
+	}
+}
+
 func TestBusEmptyList(t *testing.T) {
 	bus := setupTestBus(t)
 
@@ -574,6 +668,205 @@ func TestBusEmptyList(t *testing.T) {
 	}
 }
 
+func TestBusAddReader(t *testing.T) {
+	bus := setupTestBus(t)
+
+	// Pretty-printed JSON (as read from a file) must be stored on a single line
+	pretty := "{\n  \"from\": \"alice@example.com\",\n  \"tags\": [1, 2]\n}\n"
+	evt, err := bus.AddReader("email.received", "inbox", strings.NewReader(pretty))
+	if err != nil {
+		t.Fatalf("AddReader failed: %v", err)
+	}
+	want := `{"from":"alice@example.com","tags":[1,2]}`
+	if string(evt.Payload) != want {
+		t.Errorf("Payload = %s, want %s", evt.Payload, want)
+	}
+
+	entries, err := bus.List("inbox", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("len(entries) = %d, want 1", len(entries))
+	}
+	if string(entries[0].Payload) != want {
+		t.Errorf("stored Payload = %s, want %s", entries[0].Payload, want)
+	}
+}
+
+func TestBusAddReaderCompact(t *testing.T) {
+	// Compacted as json.Compact would, strings untouched
+	for _, input := range []string{
+		`"a b"`,
+		" [ 1 , -2.5e3 , true , null ] ",
+		"{\"s\": \"tab\\t \\\" quote \\\\\" , \"u\" : \"\\u00e9 <&>\"}",
+	} {
+		var want bytes.Buffer
+		if err := json.Compact(&want, []byte(input)); err != nil {
+			t.Fatal(err)
+		}
+		var got bytes.Buffer
+		if err := validateJSON(io.TeeReader(strings.NewReader(input), &compactWriter{w: &got})); err != nil {
+			t.Errorf("validateJSON(%q) error: %v", input, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("compacted %q = %s, want %s", input, got.String(), want.String())
+		}
+	}
+}
+
+func TestBusAddReaderBlob(t *testing.T) {
+	bus := setupTestBus(t)
+	bus.BlobThreshold = 16
+
+	// Larger than BlobThreshold: streamed into a blob
+	evt, err := bus.AddReader("test", "test", strings.NewReader("{\n  \"data\": \""+strings.Repeat("x", 64)+"\"\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt.Blob == nil {
+		t.Fatalf("payload not moved to a blob: %s", evt.Payload)
+	}
+	payload, err := bus.ResolvePayload(evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"data":"` + strings.Repeat("x", 64) + `"}`; string(payload) != want {
+		t.Errorf("payload = %s, want %s", payload, want)
+	}
+
+	// An invalid payload leaves no blob behind
+	if _, err := bus.AddReader("test", "test", strings.NewReader(`{"data":"`+strings.Repeat("x", 64))); err == nil {
+		t.Error("AddReader() of a truncated payload should error")
+	}
+	tmp, _ := filepath.Glob(filepath.Join(bus.Dir, blobsDir, "tmp-*"))
+	if len(tmp) != 0 {
+		t.Errorf("temporary blobs left: %v", tmp)
+	}
+}
+
+func TestBusAddReaderInvalidJSON(t *testing.T) {
+	bus := setupTestBus(t)
+
+	for _, input := range []string{"", "{not json}", `{"a":1} trailing`} {
+		if _, err := bus.AddReader("test", "test", strings.NewReader(input)); err == nil {
+			t.Errorf("AddReader(%q) should error", input)
+		}
+	}
+}
+
+func TestBusPayloadSizeLimit(t *testing.T) {
+	bus := setupTestBus(t)
+	bus.MaxPayloadSize = 32
+
+	big := `{"data":"` + strings.Repeat("x", 64) + `"}`
+	if _, err := bus.AddReader("test", "test", strings.NewReader(big)); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("AddReader error = %v, want ErrPayloadTooLarge", err)
+	}
+	if _, err := bus.Add("test", "test", json.RawMessage(big)); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("Add error = %v, want ErrPayloadTooLarge", err)
+	}
+
+	// Exactly at the limit is accepted
+	exact := `{"d":"` + strings.Repeat("x", 32-8) + `"}`
+	if _, err := bus.AddReader("test", "test", strings.NewReader(exact)); err != nil {
+		t.Errorf("AddReader at limit failed: %v", err)
+	}
+
+	entries, err := bus.List("test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("len(entries) = %d, want 1", len(entries))
+	}
+}
+
+func TestBusAddBatch(t *testing.T) {
+	bus := setupTestBus(t)
+	if _, err := bus.IsolateChannel("busy", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []Event
+	for i := 0; i < 100; i++ {
+		channel := "ch1"
+		if i%10 == 0 {
+			channel = "busy"
+		}
+		events = append(events, Event{Type: "test", Channel: channel, Payload: json.RawMessage(`{"i":` + itoa(i) + `}`)})
+	}
+	if err := bus.AddBatch(events); err != nil {
+		t.Fatalf("AddBatch failed: %v", err)
+	}
+	if events[0].ID == "" || events[0].Timestamp.IsZero() {
+		t.Errorf("AddBatch did not set ID and Timestamp: %+v", events[0])
+	}
+
+	for _, channel := range []string{"ch1", "busy"} {
+		var want []string
+		for _, evt := range events {
+			if evt.Channel == channel {
+				want = append(want, evt.ID)
+			}
+		}
+		entries, err := bus.List(channel, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.ID)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: listed %d events, want the %d of the batch in order", channel, len(got), len(want))
+		}
+	}
+
+	// One payload over the limit adds none of the batch
+	bus.MaxPayloadSize = 16
+	err := bus.AddBatch([]Event{{Type: "test", Channel: "ch1", Payload: json.RawMessage(`{}`)},
+		{Type: "test", Channel: "ch1", Payload: json.RawMessage(`{"large":"` + strings.Repeat("x", 32) + `"}`)}})
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("AddBatch error = %v, want ErrPayloadTooLarge", err)
+	}
+	if entries, _ := bus.List("ch1", 0); len(entries) != 90 {
+		t.Errorf("len(entries) = %d after a rejected batch, want 90", len(entries))
+	}
+}
+
+func TestBusAddReopensReplacedFile(t *testing.T) {
+	bus := setupTestBus(t)
+	if _, err := bus.Add("test", "ch1", json.RawMessage(`{"i":0}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replace the events file the bus keeps open, as a copy would
+	name, _ := bus.latestName()
+	fpath := filepath.Join(bus.Dir, name)
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fpath+".tmp", data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(fpath+".tmp", fpath); err != nil {
+		t.Skipf("cannot replace an open file: %v", err) // Windows
+	}
+
+	if _, err := bus.Add("test", "ch1", json.RawMessage(`{"i":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := bus.List("ch1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || string(entries[1].Payload) != `{"i":1}` {
+		t.Errorf("entries = %v, want both events", entries)
+	}
+}
+
 func TestBusMarkInvalidFile(t *testing.T) {
 	bus := setupTestBus(t)
 
@@ -655,6 +948,186 @@ func TestFilenameContainsHash(t *testing.T) {
 	}
 }
 
+func TestBusListTruncatedFile(t *testing.T) {
+	bus := setupTestBus(t)
+
+	for i := 0; i < 2; i++ {
+		if _, err := bus.Add("test", "ch1", json.RawMessage(`{"i": `+itoa(i)+`}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Simulate a crash in the middle of appending a third gzip member.
+	name, _ := bus.latestName()
+	var member bytes.Buffer
+	gw := gzip.NewWriter(&member)
+	gw.Write([]byte(`{"id":"x","type":"test","channel":"ch1","payload":{"i": 2}}` + "\n"))
+	gw.Close()
+	f, err := os.OpenFile(filepath.Join(bus.Dir, name), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(member.Bytes()[:member.Len()/2])
+	f.Close()
+
+	entries, err := bus.List("ch1", 0)
+	if err != nil {
+		t.Fatalf("List on truncated file: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("len(entries) = %d, want 2", len(entries))
+	}
+	if _, err := bus.Status(name); err != nil {
+		t.Errorf("Status on truncated file: %v", err)
+	}
+}
+
+// FuzzReadEvents feeds arbitrary bytes through the gzip JSONL reader.
+func FuzzReadEvents(f *testing.F) {
+	var valid bytes.Buffer
+	gw := gzip.NewWriter(&valid)
+	gw.Write([]byte(`{"id":"a","type":"__rotate__","payload":{"uuid":"u"}}` + "\n"))
+	gw.Write([]byte(`{"id":"b","type":"t","channel":"c","payload":{"k":1}}` + "\n\n"))
+	gw.Write([]byte(`{"id":"c","type":"t","blob":{"sha256":"../../etc","size":-1}}` + "\n"))
+	gw.Close()
+
+	f.Add(valid.Bytes(), int64(0))
+	f.Add(valid.Bytes(), int64(10))
+	f.Add(valid.Bytes()[:valid.Len()-5], int64(0))
+	f.Add([]byte{0x1f, 0x8b}, int64(0))
+	f.Add([]byte("not gzip"), int64(-1))
+
+	f.Fuzz(func(t *testing.T, data []byte, offset int64) {
+		entries, _ := readEvents(bytes.NewReader(data), "events.001-00000000.jsonl.gz", offset)
+		prev := offset
+		for _, e := range entries {
+			if e.Offset <= prev {
+				t.Fatalf("offset %d not after %d", e.Offset, prev)
+			}
+			prev = e.Offset
+		}
+	})
+}
+
 func itoa(i int) string {
 	return fmt.Sprintf("%d", i)
 }
+
+func TestBusListWith(t *testing.T) {
+	b := NewBus(t.TempDir())
+	for i := 1; i <= 5; i++ {
+		if _, err := b.Add("test", "ch", json.RawMessage(fmt.Sprintf(`{"n":%d}`, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := b.List("ch", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Mark("ch", Position{File: all[3].File, Offset: all[3].Offset}); err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := func(entries []EventEntry) string {
+		var s []string
+		for _, e := range entries {
+			s = append(s, string(e.Payload))
+		}
+		return strings.Join(s, " ")
+	}
+	tests := []struct {
+		name string
+		opts ListOptions
+		want string
+	}{
+		{"marker", ListOptions{}, `{"n":5}`},
+		{"tail ignores marker", ListOptions{Tail: 2}, `{"n":4} {"n":5}`},
+		{"reverse", ListOptions{Tail: 3, Reverse: true}, `{"n":5} {"n":4} {"n":3}`},
+		{"reverse limit", ListOptions{Since: all[0].Timestamp, Reverse: true, Limit: 2}, `{"n":5} {"n":4}`},
+		{"since", ListOptions{Since: all[4].Timestamp}, `{"n":5}`},
+		{"since future", ListOptions{Since: time.Now().Add(time.Hour)}, ``},
+	}
+	for _, tt := range tests {
+		entries, err := b.ListWith("ch", tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := payloads(entries); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// The marker is left alone
+	if m, _ := b.LoadMarker("ch"); m.Offset != all[3].Offset {
+		t.Error("ListWith moved the marker")
+	}
+}
+
+func TestBusTimeRotation(t *testing.T) {
+	dir := t.TempDir()
+	bus := NewBus(filepath.Join(dir, "events"))
+	bus.Rotation = RotationPolicy{MaxAge: 200 * time.Millisecond}
+	if err := bus.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bus.Add("a", "ch", json.RawMessage(`1`)); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := bus.latestName()
+
+	// Younger than MaxAge: no rotation
+	if _, err := bus.Add("a", "ch", json.RawMessage(`2`)); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := bus.latestName(); name != first {
+		t.Fatalf("rotated within the window: %q", name)
+	}
+
+	// The creation time is read back from the rotate event
+	time.Sleep(250 * time.Millisecond)
+	if _, err := bus.Add("a", "ch", json.RawMessage(`3`)); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := bus.latestName()
+	if !strings.HasPrefix(second, "events.002-") {
+		t.Fatalf("latest = %q, want events.002-<hash>.jsonl.gz", second)
+	}
+	entries, err := bus.List("ch", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[2].File != second {
+		t.Fatalf("entries = %+v", entries)
+	}
+}
+
+func TestRotationPolicy(t *testing.T) {
+	created := time.Date(2024, 3, 1, 23, 10, 0, 0, time.UTC)
+	tests := []struct {
+		policy string
+		now    time.Time
+		want   bool
+	}{
+		{"", created.Add(48 * time.Hour), false},
+		{"hourly", created.Add(49 * time.Minute), false},
+		{"hourly", created.Add(50 * time.Minute), true},
+		{"daily", created.Add(49 * time.Minute), false},
+		{"daily", created.Add(50 * time.Minute), true},
+		{"max-age=6h", created.Add(5 * time.Hour), false},
+		{"max-age=6h", created.Add(6 * time.Hour), true},
+	}
+	for _, tt := range tests {
+		p, err := ParseRotation(tt.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.due(created, tt.now); got != tt.want {
+			t.Errorf("%q: due(%v) = %v, want %v", tt.policy, tt.now, got, tt.want)
+		}
+	}
+	for _, bad := range []string{"weekly", "max-age=x", "max-age=-1h"} {
+		if _, err := ParseRotation(bad); err == nil {
+			t.Errorf("ParseRotation(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package event
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// channelsDir is the subdirectory holding isolated channels.
+const channelsDir = "channels"
+
+// channelRel returns the slash-separated directory of an isolated channel,
+// relative to the bus directory (e.g. "channels/inbox").
+func channelRel(channel string) string {
+	return path.Join(channelsDir, sanitizeChannel(channel))
+}
+
+// isIsolated reports whether a channel has its own events directory.
+func (b *Bus) isIsolated(channel string) bool {
+	fi, err := os.Stat(filepath.Join(b.Dir, filepath.FromSlash(channelRel(channel))))
+	return err == nil && fi.IsDir()
+}
+
+// channelBus returns a Bus rooted at the channel's isolated directory.
+// Locking is always done on the parent bus.
+func (b *Bus) channelBus(channel string) *Bus {
+	sub := NewBus(filepath.Join(b.Dir, filepath.FromSlash(channelRel(channel))))
+	sub.MaxPayloadSize = b.MaxPayloadSize
+	sub.Rotation = b.Rotation
+	sub.tracking = b.tracking
+	sub.parent = b
+	return sub
+}
+
+// channelFiles returns the events files a channel reads, in sequence order.
+// Names of isolated files are relative to b.Dir ("channels/inbox/events.001-....jsonl.gz"),
+// so they can be used in positions and markers like shared files.
+func (b *Bus) channelFiles(channel string) ([]string, error) {
+	if !b.isIsolated(channel) {
+		return b.listFiles()
+	}
+	names, err := b.channelBus(channel).listFiles()
+	if err != nil {
+		return nil, err
+	}
+	rel := channelRel(channel)
+	for i, n := range names {
+		names[i] = path.Join(rel, n)
+	}
+	return names, nil
+}
+
+// IsolatedChannels lists the (sanitized) names of channels with their own directory.
+func (b *Bus) IsolatedChannels() ([]string, error) {
+	if b.Store != nil {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(filepath.Join(b.Dir, channelsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var channels []string
+	for _, e := range entries {
+		if e.IsDir() {
+			channels = append(channels, e.Name())
+		}
+	}
+	return channels, nil
+}
+
+// IsolateChannel moves a channel to its own directory (channels/<channel>/).
+// Afterwards, Add writes the channel's events there and List reads only those,
+// so high-volume channels no longer force other consumers to scan their data.
+//
+// perm is applied to the channel directory and can be used to restrict
+// access to the channel (e.g. 0o700); 0 means 0o755.
+//
+// Existing events of the channel are copied out of the shared files, which
+// are append-only and keep their copy. The channel marker, if any, is
+// translated to the matching position in the new files. Returns the number
+// of events migrated.
+func (b *Bus) IsolateChannel(channel string, perm os.FileMode) (int, error) {
+	if err := b.filesOnly("isolating a channel"); err != nil {
+		return 0, err
+	}
+	unlock, err := b.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if b.isIsolated(channel) {
+		return 0, fmt.Errorf("channel %s is already isolated", channel)
+	}
+	if err := b.Init(); err != nil {
+		return 0, err
+	}
+
+	marker, err := b.LoadMarker(channel)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	files, err := b.listFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	// Create the channel directory with its first file; tracking for a
+	// new file is exact, so appendEvents reports accurate offsets.
+	if perm == 0 {
+		perm = 0o755
+	}
+	sub := b.channelBus(channel)
+	if err := os.MkdirAll(filepath.Join(b.Dir, channelsDir), 0o755); err != nil {
+		return 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Mkdir(sub.Dir, perm); err != nil {
+		return 0, fmt.Errorf("failed to create channel directory: %w", err)
+	}
+	// Roll back on failure so a half-migrated channel is not treated as isolated
+	done := false
+	defer func() {
+		if !done {
+			b.mu.Lock()
+			b.closeFile(sub.Dir)
+			b.mu.Unlock()
+			os.RemoveAll(sub.Dir)
+		}
+	}()
+	// Mkdir is subject to umask; apply the requested permissions explicitly
+	if err := os.Chmod(sub.Dir, perm); err != nil {
+		return 0, fmt.Errorf("failed to set channel directory permissions: %w", err)
+	}
+	if err := sub.ensureLatest(); err != nil {
+		return 0, err
+	}
+
+	// Locate the marker in the shared layout
+	markerIdx := -1
+	if marker != nil {
+		for i, f := range files {
+			if f == marker.File {
+				markerIdx = i
+				break
+			}
+		}
+	}
+
+	rel := channelRel(channel)
+	var newMarker *Marker
+	if markerIdx >= 0 {
+		// Nothing consumed yet maps to the start of the first isolated file
+		first, err := sub.latestName()
+		if err != nil {
+			return 0, err
+		}
+		newMarker = &Marker{File: path.Join(rel, first), UpdatedAt: marker.UpdatedAt}
+	}
+
+	migrated := 0
+	for i, f := range files {
+		entries, err := b.readFile(f, 0)
+		if err != nil {
+			return migrated, fmt.Errorf("failed to read %s: %w", f, err)
+		}
+		var evts []*Event
+		var offsets []int64 // Offsets of evts in f
+		for _, e := range entries {
+			if e.Channel != channel {
+				continue
+			}
+			evt := e.Event
+			evts = append(evts, &evt)
+			offsets = append(offsets, e.Offset)
+		}
+		if len(evts) == 0 {
+			continue
+		}
+
+		err = sub.appendEvents(evts, func(j int, name string, end int64) {
+			consumed := i < markerIdx || (i == markerIdx && offsets[j] <= marker.Offset)
+			if consumed {
+				newMarker.File = path.Join(rel, name)
+				newMarker.Offset = end
+			}
+		})
+		if err != nil {
+			return migrated, err
+		}
+		migrated += len(evts)
+	}
+
+	if newMarker != nil {
+		if err := b.SaveMarker(channel, newMarker); err != nil {
+			return migrated, err
+		}
+	}
+
+	done = true
+	return migrated, nil
+}
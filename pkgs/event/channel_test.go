@@ -0,0 +1,151 @@
+package event
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestIsolateChannelRouting(t *testing.T) {
+	bus := setupTestBus(t)
+
+	if _, err := bus.IsolateChannel("busy", 0); err != nil {
+		t.Fatalf("IsolateChannel failed: %v", err)
+	}
+
+	bus.Add("a", "busy", json.RawMessage(`{"n":1}`))
+	bus.Add("b", "other", json.RawMessage(`{"n":2}`))
+	bus.Add("c", "busy", json.RawMessage(`{"n":3}`))
+
+	// The isolated channel only sees its own events, from its own directory
+	busy, err := bus.List("busy", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(busy) != 2 {
+		t.Fatalf("busy: len = %d, want 2", len(busy))
+	}
+	for _, e := range busy {
+		if !strings.HasPrefix(e.File, "channels/busy/events.001-") {
+			t.Errorf("busy File = %q, want channels/busy/events.001-*", e.File)
+		}
+	}
+
+	// Other consumers no longer scan the isolated channel's data
+	shared, err := bus.List("reader", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != 1 || shared[0].Type != "b" {
+		t.Fatalf("shared = %+v, want only event b", shared)
+	}
+
+	// Positions of isolated files round-trip through Mark
+	if err := bus.Mark("busy", Position{File: busy[0].File, Offset: busy[0].Offset}); err != nil {
+		t.Fatalf("Mark failed: %v", err)
+	}
+	rest, err := bus.List("busy", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 1 || rest[0].Type != "c" {
+		t.Fatalf("after mark = %+v, want only event c", rest)
+	}
+}
+
+func TestIsolateChannelMigration(t *testing.T) {
+	bus := setupTestBus(t)
+
+	bus.Add("m1", "busy", json.RawMessage(`{}`))
+	bus.Add("x", "other", json.RawMessage(`{}`))
+	bus.Add("m2", "busy", json.RawMessage(`{}`))
+	bus.Add("m3", "busy", json.RawMessage(`{}`))
+
+	// busy has consumed up to m2 in the shared layout
+	all, _ := bus.List("busy", 0)
+	if len(all) != 4 {
+		t.Fatalf("len(all) = %d, want 4", len(all))
+	}
+	bus.Mark("busy", Position{File: all[2].File, Offset: all[2].Offset})
+
+	n, err := bus.IsolateChannel("busy", 0)
+	if err != nil {
+		t.Fatalf("IsolateChannel failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("migrated = %d, want 3", n)
+	}
+
+	// The marker was translated, so only m3 is still pending
+	pending, err := bus.List("busy", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Type != "m3" {
+		t.Fatalf("pending = %+v, want only m3", pending)
+	}
+
+	m, err := bus.LoadMarker("busy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(m.File, "channels/busy/") {
+		t.Errorf("marker File = %q, want channels/busy/...", m.File)
+	}
+
+	// Isolating twice is an error
+	if _, err := bus.IsolateChannel("busy", 0); err == nil {
+		t.Error("second IsolateChannel should error")
+	}
+
+	chans, err := bus.IsolatedChannels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chans) != 1 || chans[0] != "busy" {
+		t.Errorf("IsolatedChannels = %v, want [busy]", chans)
+	}
+}
+
+func TestIsolateChannelWithoutMarker(t *testing.T) {
+	bus := setupTestBus(t)
+
+	bus.Add("m1", "busy", json.RawMessage(`{}`))
+	bus.Add("m2", "busy", json.RawMessage(`{}`))
+
+	if _, err := bus.IsolateChannel("busy", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bus.LoadMarker("busy"); !os.IsNotExist(err) {
+		t.Errorf("LoadMarker error = %v, want not exist", err)
+	}
+
+	entries, err := bus.List("busy", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("len(entries) = %d, want 2", len(entries))
+	}
+}
+
+func TestIsolateChannelPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix permissions only")
+	}
+	bus := setupTestBus(t)
+
+	if _, err := bus.IsolateChannel("private", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filepath.Join(bus.Dir, "channels", "private"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o700 {
+		t.Errorf("mode = %o, want 700", fi.Mode().Perm())
+	}
+}
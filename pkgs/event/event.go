@@ -8,14 +8,38 @@
 //	~/.emx-mail/events/
 //	├── events.001-a1b2c3d4.jsonl.gz       # Currently active file
 //	├── events.002-e5f6g7h8.jsonl.gz       # Archived
+//	├── events.002-e5f6g7h8.jsonl.gz.idx   # Offset index of the file
 //	├── latest                             # Text file containing the active file name
-//	├── events.lock                        # Exclusive lock file (temporary)
-//	└── markers/
-//	    ├── my-channel.json               # channel marker
-//	    └── other-channel.json
+//	├── events.lock                        # Advisory lock file (flock / LockFileEx)
+//	├── blobs/                             # Large/binary payloads by SHA-256
+//	│   └── 3f/3fa9...e1
+//	├── markers/
+//	│   ├── my-channel.json               # channel marker
+//	│   └── other-channel.json
+//	└── channels/                          # Isolated channels (optional)
+//	    └── busy-channel/
+//	        ├── events.001-i9j0k1l2.jsonl.gz
+//	        └── latest
 //
 // Each events file starts with a "rotate" event containing a UUID, and the filename includes
-// the hash of this rotate event line for identity verification.
+// the hash of this rotate event line for identity verification. A file is
+// rotated when it would exceed MaxUncompressedSize, and also hourly, daily or
+// after a maximum age when Bus.Rotation says so.
+//
+// Every IndexInterval records a new gzip member starts, and the index next
+// to the file records its uncompressed and compressed offsets, one
+// "<offset> <compressed>" line each. List then decompresses a file from the
+// last entry before the marker rather than from the start. Appends extend
+// the index, and a bus writing a file it did not write last rebuilds the
+// index if it doesn't match the file, so indexes can be deleted.
+//
+// A channel can be isolated into channels/<channel>/ (see Bus.IsolateChannel).
+// Its events are then written and listed there only, and its positions carry
+// the relative path, e.g. "channels/busy-channel/events.001-i9j0k1l2.jsonl.gz:512".
+//
+// The files are the default Store. A store.json in the directory selects a
+// backend registered with RegisterStore instead (see OpenBus); blobs/ is
+// used with every store.
 package event
 
 import (
@@ -23,6 +47,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -36,6 +61,17 @@ const MaxUncompressedSize = 64 * 1024 * 1024 // 64 MB
 // RotationHeadroom is the reserved space for rotation judgment.
 const RotationHeadroom = 64 * 1024 // 64 KB
 
+// DefaultMaxPayloadSize is the default upper bound for a single event payload.
+// It stays well below the 10 MB line limit used when reading events back.
+const DefaultMaxPayloadSize = 4 * 1024 * 1024 // 4 MB
+
+// DefaultBlobThreshold is the default size above which payloads are moved to
+// blob storage. Inline payloads then always fit within RotationHeadroom.
+const DefaultBlobThreshold = 64 * 1024 // 64 KB
+
+// ErrPayloadTooLarge is returned when a payload exceeds the bus size limit.
+var ErrPayloadTooLarge = errors.New("payload exceeds size limit")
+
 // RotateEventType is the event type for rotation marker events.
 const RotateEventType = "__rotate__"
 
@@ -51,6 +87,23 @@ type Event struct {
 	Type      string          `json:"type"`
 	Channel   string          `json:"channel"`
 	Payload   json.RawMessage `json:"payload"`
+	Blob      *BlobRef        `json:"blob,omitempty"` // Set when the payload lives in blobs/ (Payload is null)
+}
+
+// Key returns a stable hash of the event's type, channel and payload, usable
+// as an idempotency key (see Bus.Seen). Unlike ID it is the same for events
+// published twice with the same content, and it does not depend on whether
+// the payload was moved to blob storage.
+func (e *Event) Key() string {
+	payload := ""
+	if e.Blob != nil {
+		payload = e.Blob.SHA256
+	} else {
+		sum := sha256.Sum256(e.Payload)
+		payload = hex.EncodeToString(sum[:])
+	}
+	h := sha256.Sum256([]byte(e.Type + "\x00" + e.Channel + "\x00" + payload))
+	return hex.EncodeToString(h[:16])
 }
 
 // EventEntry is an event read from a file with positional information.
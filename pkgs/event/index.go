@@ -0,0 +1,198 @@
+package event
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// IndexInterval is the number of records between the entries of the index
+// of an events file.
+const IndexInterval = 256
+
+// indexSuffix names the index of an events file, e.g.
+// "events.001-a1b2c3d4.jsonl.gz.idx".
+const indexSuffix = ".idx"
+
+// indexEntry is a gzip member boundary of an events file: decompressing the
+// file from byte Compressed yields its uncompressed data from Offset.
+type indexEntry struct {
+	Offset     int64
+	Compressed int64
+}
+
+// loadIndex reads the index of the events file fpath, one "<offset>
+// <compressed>" line per entry. A missing index is empty; malformed lines
+// and entries not after the previous one are skipped.
+func loadIndex(fpath string) []indexEntry {
+	data, err := os.ReadFile(fpath + indexSuffix)
+	if err != nil {
+		return nil
+	}
+	var entries []indexEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		offset, compressed, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		var e indexEntry
+		var err1, err2 error
+		e.Offset, err1 = strconv.ParseInt(offset, 10, 64)
+		e.Compressed, err2 = strconv.ParseInt(compressed, 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if n := len(entries); n > 0 && (e.Offset <= entries[n-1].Offset || e.Compressed <= entries[n-1].Compressed) {
+			continue
+		}
+		if e.Offset > 0 && e.Compressed > 0 {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// appendIndex adds entries to the index of the events file fpath, or
+// replaces the index with them if truncate is set.
+func appendIndex(fpath string, entries []indexEntry, truncate bool) error {
+	if len(entries) == 0 && !truncate {
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, e := range entries {
+		fmt.Fprintf(&buf, "%d %d\n", e.Offset, e.Compressed)
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if truncate {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(fpath+indexSuffix, flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open index: %w", err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return f.Close()
+}
+
+// indexStart returns the last entry of index at or before the uncompressed
+// offset, the zero entry (the start of the file) if there is none.
+func indexStart(index []indexEntry, offset int64) indexEntry {
+	var start indexEntry
+	for _, e := range index {
+		if e.Offset > offset {
+			break
+		}
+		start = e
+	}
+	return start
+}
+
+// scanMembers decompresses r, the events file from the member boundary
+// start on, one gzip member at a time. It returns the uncompressed size of
+// the file, the boundaries to index every IndexInterval records after
+// start, and the records after the last of them. A member cut short (by a
+// crash during append) ends the data where it could be decoded.
+func scanMembers(r io.Reader, start indexEntry) (size, lines int64, entries []indexEntry, err error) {
+	cr := &countingReader{r: r}
+	br := bufio.NewReader(cr)
+	size = start.Offset
+	var gr *gzip.Reader
+	for {
+		// gzip reads exactly its members from a bufio.Reader, so the
+		// position is what was read minus what is still buffered
+		member := indexEntry{Offset: size, Compressed: start.Compressed + cr.n - int64(br.Buffered())}
+		if gr == nil {
+			gr, err = gzip.NewReader(br)
+		} else {
+			err = gr.Reset(br)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return size, lines, entries, nil
+		}
+		if err != nil {
+			return size, lines, entries, err
+		}
+		gr.Multistream(false)
+
+		if lines >= IndexInterval {
+			entries = append(entries, member)
+			lines = 0
+		}
+		lc := &lineCounter{}
+		_, err = io.Copy(lc, gr)
+		size += lc.n
+		lines += lc.lines
+		if err == io.ErrUnexpectedEOF {
+			return size, lines, entries, nil
+		}
+		if err != nil {
+			return size, lines, entries, err
+		}
+	}
+}
+
+// lineCounter counts the bytes and lines written to it.
+type lineCounter struct {
+	n     int64
+	lines int64
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	c.lines += int64(bytes.Count(p, []byte{'\n'}))
+	return len(p), nil
+}
+
+// catchUp makes the tracking of the events file exact: it scans the file
+// from the last entry of its index, indexing what it passes. An index not
+// matching the file is rebuilt. If the file can't be read, the tracking
+// stays inexact and the file is not indexed further. Caller must hold the
+// exclusive lock.
+func (b *Bus) catchUp(file string, tracking *fileTracking) {
+	fpath := filepath.Join(b.Dir, file)
+	f, err := os.Open(fpath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	scan := func(start indexEntry) (int64, int64, []indexEntry, error) {
+		if start.Compressed > fi.Size() {
+			return 0, 0, nil, fmt.Errorf("index entry past the end of %s", file)
+		}
+		if _, err := f.Seek(start.Compressed, io.SeekStart); err != nil {
+			return 0, 0, nil, err
+		}
+		return scanMembers(f, start)
+	}
+
+	var start indexEntry
+	if index := loadIndex(fpath); len(index) > 0 {
+		start = index[len(index)-1]
+	}
+	size, lines, entries, err := scan(start)
+	rebuild := err != nil && start != (indexEntry{})
+	if rebuild {
+		size, lines, entries, err = scan(indexEntry{})
+	}
+	if err != nil || appendIndex(fpath, entries, rebuild) != nil {
+		return
+	}
+
+	tracking.uncompressedSize = size
+	tracking.sinceIndex = lines
+	tracking.exact = true
+}
@@ -0,0 +1,121 @@
+package event
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// addIndexed adds n events to ch1 of bus, in batches and one by one.
+func addIndexed(t *testing.T, bus *Bus, n int) {
+	t.Helper()
+	var batch []Event
+	for i := 0; i < n-10; i++ {
+		batch = append(batch, Event{Type: "test", Channel: "ch1", Payload: json.RawMessage(`{"i":` + itoa(i) + `}`)})
+	}
+	if err := bus.AddBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	for i := n - 10; i < n; i++ {
+		if _, err := bus.Add("test", "ch1", json.RawMessage(`{"i":`+itoa(i)+`}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkListFrom checks that listing from the position of each of all
+// returns the events after it.
+func checkListFrom(t *testing.T, bus *Bus, all []EventEntry) {
+	t.Helper()
+	for _, k := range []int{0, IndexInterval - 2, IndexInterval, 2*IndexInterval + 7, len(all) - 3} {
+		pos := Position{File: all[k].File, Offset: all[k].Offset}
+		entries, err := bus.ListFrom("ch1", pos, 0)
+		if err != nil {
+			t.Fatalf("ListFrom(%v) failed: %v", pos, err)
+		}
+		want := all[k+1:]
+		if len(entries) != len(want) {
+			t.Fatalf("ListFrom(%v) = %d events, want %d", pos, len(entries), len(want))
+		}
+		for i := range want {
+			if entries[i].ID != want[i].ID || entries[i].Offset != want[i].Offset {
+				t.Fatalf("ListFrom(%v)[%d] = %s at %d, want %s at %d", pos, i, entries[i].ID, entries[i].Offset, want[i].ID, want[i].Offset)
+			}
+		}
+	}
+}
+
+func TestBusIndex(t *testing.T) {
+	bus := setupTestBus(t)
+	addIndexed(t, bus, 3*IndexInterval+20)
+
+	name, _ := bus.latestName()
+	fpath := filepath.Join(bus.Dir, name)
+	index := loadIndex(fpath)
+	if len(index) != 3 {
+		t.Fatalf("index = %v, want 3 entries", index)
+	}
+	// Each entry starts a gzip member at the offset of a record
+	all, err := bus.readFile(name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range index {
+		f, _ := os.Open(fpath)
+		f.Seek(e.Compressed, 0)
+		entries, err := readEvents(f, name, 0)
+		f.Close()
+		if err != nil || len(entries) == 0 {
+			t.Fatalf("reading from entry %v: %d events, %v", e, len(entries), err)
+		}
+		if last := all[len(all)-1]; e.Offset+entries[len(entries)-1].Offset != last.Offset {
+			t.Errorf("entry %v: last event ends at %d, want %d", e, e.Offset+entries[len(entries)-1].Offset, last.Offset)
+		}
+	}
+
+	checkListFrom(t, bus, all)
+}
+
+func TestBusIndexRebuild(t *testing.T) {
+	bus := setupTestBus(t)
+	addIndexed(t, bus, 2*IndexInterval+20)
+	name, _ := bus.latestName()
+	fpath := filepath.Join(bus.Dir, name)
+	reopen := func() *Bus {
+		b := NewBus(bus.Dir)
+		t.Cleanup(func() { b.Close() })
+		return b
+	}
+
+	// A missing index is built by the next bus appending, e.g. in another
+	// process
+	if err := os.Remove(fpath + indexSuffix); err != nil {
+		t.Fatal(err)
+	}
+	bus = reopen()
+	if _, err := bus.Add("test", "ch1", json.RawMessage(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if index := loadIndex(fpath); len(index) != 2 {
+		t.Errorf("rebuilt index = %v, want 2 entries", index)
+	}
+
+	// Reads ignore an index not matching the file, and appends replace it
+	if err := os.WriteFile(fpath+indexSuffix, []byte("1 999999999\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	all, err := bus.readFile(name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkListFrom(t, bus, all)
+
+	bus = reopen()
+	if _, err := bus.Add("test", "ch1", json.RawMessage(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if index := loadIndex(fpath); len(index) != 2 || index[0].Compressed == 999999999 {
+		t.Errorf("rebuilt index = %v, want 2 entries", index)
+	}
+}
@@ -0,0 +1,76 @@
+package event
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/emx-mail/cli/pkgs/flock"
+)
+
+// lockTimeout bounds how long lock acquisition waits for another process.
+var lockTimeout = 5 * time.Second
+
+// lockRetryInterval is the delay between non-blocking lock attempts.
+const lockRetryInterval = 20 * time.Millisecond
+
+// lock acquires an exclusive lock for writers. Returns an unlock function.
+func (b *Bus) lock() (func(), error) {
+	release, err := b.acquireLock(true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tracking is kept between locks: appends check that no other bus
+	// wrote the file since
+	if b.tracking == nil {
+		b.tracking = make(map[string]*fileTracking)
+	}
+	return release, nil
+}
+
+// rlock acquires a shared lock for readers, so concurrent readers do not
+// serialize behind each other. Returns an unlock function.
+func (b *Bus) rlock() (func(), error) {
+	return b.acquireLock(false)
+}
+
+// acquireLock takes an OS advisory lock (flock / LockFileEx) on events.lock.
+//
+// The lock file itself is never removed: the lock belongs to the open file,
+// so the kernel drops it when the holder exits or crashes. There is no stale
+// lock to detect and no PID liveness check that could race between processes.
+func (b *Bus) acquireLock(exclusive bool) (func(), error) {
+	lockPath := filepath.Join(b.Dir, "events.lock")
+	if err := os.MkdirAll(b.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		ok, err := flock.TryLock(f, exclusive)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", lockPath, err)
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("failed to acquire lock: %s (held by another process)", lockPath)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+
+	return func() {
+		flock.Unlock(f)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,109 @@
+package event
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func withLockTimeout(t *testing.T, d time.Duration) {
+	t.Helper()
+	old := lockTimeout
+	lockTimeout = d
+	t.Cleanup(func() { lockTimeout = old })
+}
+
+func TestLockSharedReaders(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "events")
+	a, b := NewBus(dir), NewBus(dir)
+	withLockTimeout(t, 200*time.Millisecond)
+
+	unlockA, err := a.rlock()
+	if err != nil {
+		t.Fatalf("first rlock failed: %v", err)
+	}
+	defer unlockA()
+
+	// A second reader must not wait behind the first
+	unlockB, err := b.rlock()
+	if err != nil {
+		t.Fatalf("concurrent rlock failed: %v", err)
+	}
+	unlockB()
+}
+
+func TestLockExclusive(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "events")
+	a, b := NewBus(dir), NewBus(dir)
+	withLockTimeout(t, 100*time.Millisecond)
+
+	unlock, err := a.lock()
+	if err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+
+	if _, err := b.rlock(); err == nil {
+		t.Fatal("rlock should fail while an exclusive lock is held")
+	}
+	if _, err := b.lock(); err == nil {
+		t.Fatal("lock should fail while an exclusive lock is held")
+	}
+
+	unlock()
+
+	unlock, err = b.lock()
+	if err != nil {
+		t.Fatalf("lock after release failed: %v", err)
+	}
+	unlock()
+}
+
+func TestLockWriterWaitsForReader(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "events")
+	a, b := NewBus(dir), NewBus(dir)
+	withLockTimeout(t, 2*time.Second)
+
+	unlockR, err := a.rlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		unlockR()
+	}()
+
+	start := time.Now()
+	unlock, err := b.lock()
+	if err != nil {
+		t.Fatalf("lock should succeed once the reader releases: %v", err)
+	}
+	unlock()
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("writer did not wait for the reader")
+	}
+}
+
+func TestLockIgnoresLeftoverLockFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "events")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// A lock file left behind by a crashed process (or the old PID scheme)
+	// carries no lock and must not block anyone.
+	if err := os.WriteFile(filepath.Join(dir, "events.lock"), []byte("999999"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withLockTimeout(t, 100*time.Millisecond)
+
+	bus := NewBus(dir)
+	unlock, err := bus.lock()
+	if err != nil {
+		t.Fatalf("lock failed with leftover lock file: %v", err)
+	}
+	unlock()
+
+	if _, err := os.Stat(filepath.Join(dir, "events.lock")); err != nil {
+		t.Errorf("lock file should persist after unlock: %v", err)
+	}
+}
@@ -1,8 +1,12 @@
 package event
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,8 +15,9 @@ import (
 
 // Marker is the consumption position record for a channel.
 type Marker struct {
-	File      string    `json:"file"`   // Event file name (e.g., events.001-a1b2c3d4.jsonl.gz)
-	Offset    int64     `json:"offset"` // Byte offset in uncompressed data (line end position)
+	File      string    `json:"file"`           // Event file name (e.g., events.001-a1b2c3d4.jsonl.gz)
+	Offset    int64     `json:"offset"`         // Byte offset in uncompressed data (line end position)
+	UUID      string    `json:"uuid,omitempty"` // UUID of File's rotate event, set by the file store
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
@@ -23,9 +28,164 @@ func (b *Bus) markerPath(channel string) string {
 }
 
 // LoadMarker loads the marker for the specified channel.
-// If the marker does not exist, returns nil and os.IsNotExist error.
+// If the marker does not exist, returns nil and an error matching
+// fs.ErrNotExist (os.IsNotExist for the file store).
 func (b *Bus) LoadMarker(channel string) (*Marker, error) {
-	data, err := os.ReadFile(b.markerPath(channel))
+	return b.store().Markers().LoadMarker(channel)
+}
+
+// SaveMarker saves the marker for a channel.
+func (b *Bus) SaveMarker(channel string, m *Marker) error {
+	return b.store().Markers().SaveMarker(channel, m)
+}
+
+// ListChannels lists all registered channels (those with markers).
+func (b *Bus) ListChannels() ([]string, error) {
+	return b.store().Markers().ListChannels()
+}
+
+// ChannelStatus is the consumption state of a channel with a marker.
+type ChannelStatus struct {
+	Channel  string
+	Marker   Marker
+	Lag      int  // Events after the marker, which List would return
+	Isolated bool // Whether the channel has its own events directory
+}
+
+// Channels returns the status of every channel with a marker. Counting the
+// lag reads the events after each marker.
+func (b *Bus) Channels() ([]ChannelStatus, error) {
+	unlock, err := b.store().Lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return b.channels()
+}
+
+// channels returns the status of every channel with a marker. The caller
+// holds a lock.
+func (b *Bus) channels() ([]ChannelStatus, error) {
+	names, err := b.ListChannels()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]ChannelStatus, 0, len(names))
+	for _, ch := range names {
+		m, err := b.LoadMarker(ch)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", ch, err)
+		}
+		pos, err := b.markerPosition(ch)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", ch, err)
+		}
+		entries, err := b.store().ReadFrom(ch, pos, 0)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", ch, err)
+		}
+		statuses = append(statuses, ChannelStatus{
+			Channel:  ch,
+			Marker:   *m,
+			Lag:      len(entries),
+			Isolated: b.Store == nil && b.isIsolated(ch),
+		})
+	}
+	return statuses, nil
+}
+
+// PruneMarkers deletes the markers not updated for olderThan whose channel
+// still has events to list, and returns the status of those channels. Their
+// consumers are presumed gone; left in place, the markers would hold back
+// retention of the events after them forever. A caught-up marker holds
+// nothing back and is kept however old. A consumer coming back after its
+// marker was pruned starts over from the earliest events.
+func (b *Bus) PruneMarkers(olderThan time.Duration) ([]ChannelStatus, error) {
+	deleter, ok := b.store().Markers().(MarkerDeleter)
+	if !ok {
+		return nil, fmt.Errorf("the event store cannot delete markers")
+	}
+	unlock, err := b.store().Lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	statuses, err := b.channels()
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-olderThan)
+	var pruned []ChannelStatus
+	for _, st := range statuses {
+		if st.Lag == 0 || !st.Marker.UpdatedAt.Before(cutoff) {
+			continue
+		}
+		if err := deleter.DeleteMarker(st.Channel); err != nil {
+			return pruned, fmt.Errorf("failed to delete marker of %s: %w", st.Channel, err)
+		}
+		pruned = append(pruned, st)
+	}
+	return pruned, nil
+}
+
+// markerPosition returns the position of channel's marker, or the zero
+// Position without one. A file store marker whose file was regenerated
+// under the same name (its rotate UUID changed) is stale: reading starts
+// over from the earliest file, redelivering events rather than skipping
+// any.
+func (b *Bus) markerPosition(channel string) (Position, error) {
+	m, err := b.LoadMarker(channel)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Position{}, nil
+	}
+	if err != nil {
+		return Position{}, err
+	}
+	if b.Store == nil && m.UUID != "" {
+		if uuid, err := b.fileUUID(m.File); err == nil && uuid != m.UUID {
+			return Position{}, nil
+		}
+	}
+	return Position{File: m.File, Offset: m.Offset}, nil
+}
+
+// fileUUID returns the UUID of the rotate event starting an events file.
+func (b *Bus) fileUUID(name string) (string, error) {
+	_, rot, err := b.readRotateEvent(name)
+	return rot.UUID, err
+}
+
+// readRotateEvent reads the rotate event starting an events file.
+func (b *Bus) readRotateEvent(name string) (Event, RotateEvent, error) {
+	f, err := os.Open(filepath.Join(b.Dir, name))
+	if err != nil {
+		return Event{}, RotateEvent{}, err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return Event{}, RotateEvent{}, fmt.Errorf("failed to open gzip: %w", err)
+	}
+	defer gr.Close()
+
+	line, err := bufio.NewReader(gr).ReadBytes('\n')
+	if err != nil {
+		return Event{}, RotateEvent{}, fmt.Errorf("failed to read rotate event of %s: %w", name, err)
+	}
+	var evt Event
+	var rot RotateEvent
+	if err := json.Unmarshal(line, &evt); err != nil || evt.Type != RotateEventType {
+		return Event{}, RotateEvent{}, fmt.Errorf("%s does not start with a rotate event", name)
+	}
+	if err := json.Unmarshal(evt.Payload, &rot); err != nil || rot.UUID == "" {
+		return Event{}, RotateEvent{}, fmt.Errorf("%s has an invalid rotate event", name)
+	}
+	return evt, rot, nil
+}
+
+func (s fileStore) LoadMarker(channel string) (*Marker, error) {
+	data, err := os.ReadFile(s.b.markerPath(channel))
 	if err != nil {
 		return nil, err
 	}
@@ -36,21 +196,68 @@ func (b *Bus) LoadMarker(channel string) (*Marker, error) {
 	return &m, nil
 }
 
-// SaveMarker saves the marker for a channel.
-func (b *Bus) SaveMarker(channel string, m *Marker) error {
-	if err := os.MkdirAll(filepath.Join(b.Dir, "markers"), 0o755); err != nil {
+// SaveMarker records the rotate UUID of the marker's file with it, and
+// replaces the marker file atomically: a crash leaves the old marker or
+// the new one, never a torn write.
+func (s fileStore) SaveMarker(channel string, m *Marker) error {
+	if err := os.MkdirAll(filepath.Join(s.b.Dir, "markers"), 0o755); err != nil {
 		return err
 	}
+	if m.UUID == "" && m.File != "" {
+		if uuid, err := s.b.fileUUID(m.File); err == nil {
+			cp := *m
+			cp.UUID = uuid
+			m = &cp
+		}
+	}
 	data, err := json.MarshalIndent(m, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to serialize marker: %w", err)
 	}
-	return os.WriteFile(b.markerPath(channel), data, 0o644)
+	if err := writeFileSync(s.b.markerPath(channel), data, 0o644); err != nil {
+		return fmt.Errorf("failed to write marker: %w", err)
+	}
+	return nil
 }
 
-// ListChannels lists all registered channels (those with marker files).
-func (b *Bus) ListChannels() ([]string, error) {
-	markersDir := filepath.Join(b.Dir, "markers")
+// writeFileSync replaces path with data durably: it writes a temporary
+// file in the same directory, syncs it, renames it over path and syncs
+// the directory.
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Persist the rename; directories cannot be synced on every platform
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// ListChannels lists the channels with marker files.
+func (s fileStore) ListChannels() ([]string, error) {
+	markersDir := filepath.Join(s.b.Dir, "markers")
 	entries, err := os.ReadDir(markersDir)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -72,6 +279,14 @@ func (b *Bus) ListChannels() ([]string, error) {
 	return channels, nil
 }
 
+func (s fileStore) DeleteMarker(channel string) error {
+	err := os.Remove(s.b.markerPath(channel))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 // sanitizeChannel converts a channel name to a safe filename.
 func sanitizeChannel(channel string) string {
 	replacer := strings.NewReplacer(
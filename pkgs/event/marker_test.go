@@ -64,6 +64,49 @@ func TestMarkerOverwrite(t *testing.T) {
 	}
 }
 
+func TestMarkerUUID(t *testing.T) {
+	bus := setupTestBus(t)
+	bus.Add("test", "ch", json.RawMessage(`{"n":1}`))
+	bus.Add("test", "ch", json.RawMessage(`{"n":2}`))
+
+	entries, err := bus.List("reader", 0)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("List = %d entries, %v", len(entries), err)
+	}
+	if err := bus.Mark("reader", Position{File: entries[0].File, Offset: entries[0].Offset}); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := bus.LoadMarker("reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uuid, err := bus.fileUUID(entries[0].File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.UUID != uuid {
+		t.Errorf("UUID = %q, want %q", m.UUID, uuid)
+	}
+	files, _ := os.ReadDir(filepath.Join(bus.Dir, "markers"))
+	if len(files) != 1 {
+		t.Errorf("markers/ has %d files, want only the marker", len(files))
+	}
+
+	if entries, _ = bus.List("reader", 0); len(entries) != 1 {
+		t.Fatalf("List after Mark = %d entries, want 1", len(entries))
+	}
+
+	// A marker for an earlier file of the same name is reset to the start
+	m.UUID = "0123456789abcdef0123456789abcdef"
+	if err := bus.SaveMarker("reader", m); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ = bus.List("reader", 0); len(entries) != 2 {
+		t.Errorf("List with stale marker = %d entries, want 2", len(entries))
+	}
+}
+
 func TestListChannels(t *testing.T) {
 	bus := setupTestBus(t)
 
@@ -192,3 +235,55 @@ func TestMarkerWithRotateEvent(t *testing.T) {
 		t.Error("marker should not contain first_line_hash field")
 	}
 }
+
+func TestBusChannelsPrune(t *testing.T) {
+	mem := NewBus(t.TempDir())
+	mem.Store = NewMemoryStore()
+	for _, bus := range []*Bus{setupTestBus(t), mem} {
+		for i := 0; i < 3; i++ {
+			if _, err := bus.Add("test", "ch1", json.RawMessage(`{"i":`+itoa(i)+`}`)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		entries, err := bus.List("ch1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, last := entries[0], entries[len(entries)-1]
+		old := time.Now().Add(-48 * time.Hour)
+		markers := map[string]*Marker{
+			"done": {File: last.File, Offset: last.Offset, UpdatedAt: old},   // Caught up
+			"gone": {File: first.File, Offset: first.Offset, UpdatedAt: old}, // Abandoned
+			"busy": {File: first.File, Offset: first.Offset, UpdatedAt: time.Now()},
+		}
+		for ch, m := range markers {
+			if err := bus.SaveMarker(ch, m); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		statuses, err := bus.Channels()
+		if err != nil {
+			t.Fatalf("Channels failed: %v", err)
+		}
+		lags := map[string]int{}
+		for _, st := range statuses {
+			lags[st.Channel] = st.Lag
+		}
+		if len(lags) != 3 || lags["done"] != 0 || lags["gone"] != len(entries)-1 || lags["busy"] != len(entries)-1 {
+			t.Errorf("lags = %v, want done 0, gone and busy %d", lags, len(entries)-1)
+		}
+
+		pruned, err := bus.PruneMarkers(24 * time.Hour)
+		if err != nil {
+			t.Fatalf("PruneMarkers failed: %v", err)
+		}
+		if len(pruned) != 1 || pruned[0].Channel != "gone" {
+			t.Errorf("pruned = %v, want gone", pruned)
+		}
+		channels, _ := bus.ListChannels()
+		if strings.Join(channels, ",") != "busy,done" {
+			t.Errorf("channels after pruning = %v, want busy,done", channels)
+		}
+	}
+}
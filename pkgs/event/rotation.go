@@ -0,0 +1,80 @@
+package event
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RotationPolicy starts a new events file at time boundaries, in addition
+// to the size limit (MaxUncompressedSize), so each file covers a
+// predictable window for retention and log shipping. The zero policy
+// rotates on size only.
+type RotationPolicy struct {
+	// Interval starts a new file for the first event of each window of
+	// this length, aligned to UTC: time.Hour rotates hourly, 24*time.Hour
+	// daily at midnight UTC.
+	Interval time.Duration
+
+	// MaxAge starts a new file once the current one is older than this.
+	MaxAge time.Duration
+}
+
+// Common rotation policies.
+var (
+	RotateHourly = RotationPolicy{Interval: time.Hour}
+	RotateDaily  = RotationPolicy{Interval: 24 * time.Hour}
+)
+
+// ParseRotation parses a rotation policy: "hourly", "daily", "max-age=<duration>"
+// (e.g. "max-age=6h"), or "size" and "" for the default size-only rotation.
+func ParseRotation(s string) (RotationPolicy, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "", "size":
+		return RotationPolicy{}, nil
+	case "hourly":
+		return RotateHourly, nil
+	case "daily":
+		return RotateDaily, nil
+	}
+	if age, ok := strings.CutPrefix(s, "max-age="); ok {
+		d, err := time.ParseDuration(age)
+		if err != nil || d <= 0 {
+			return RotationPolicy{}, fmt.Errorf("invalid rotation max-age %q", age)
+		}
+		return RotationPolicy{MaxAge: d}, nil
+	}
+	return RotationPolicy{}, fmt.Errorf("unknown rotation policy %q (want hourly, daily, max-age=<duration> or size)", s)
+}
+
+// due reports whether a file created at created must be rotated before an
+// event is written at now.
+func (p RotationPolicy) due(created, now time.Time) bool {
+	if created.IsZero() {
+		return false
+	}
+	if p.Interval > 0 && now.Truncate(p.Interval).After(created.Truncate(p.Interval)) {
+		return true
+	}
+	return p.MaxAge > 0 && now.Sub(created) >= p.MaxAge
+}
+
+// rotationDue reports whether the Rotation policy ends file now.
+func (b *Bus) rotationDue(file string) bool {
+	if b.Rotation == (RotationPolicy{}) {
+		return false
+	}
+	return b.Rotation.due(b.fileCreated(file), time.Now())
+}
+
+// fileCreated returns when file was started, from its tracking or else its
+// rotate event. It is the zero time if that can't be read.
+func (b *Bus) fileCreated(file string) time.Time {
+	tracking := b.getTracking(file)
+	if tracking.created.IsZero() {
+		if evt, _, err := b.readRotateEvent(file); err == nil {
+			tracking.created = evt.Timestamp
+		}
+	}
+	return tracking.created
+}
@@ -0,0 +1,141 @@
+package event
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// seenFileLimit is the size above which a channel's seen keys file is cut
+// to its newer half, so it cannot grow without bounds. With event keys that
+// is some 60,000 keys remembered at least.
+const seenFileLimit = 4 * 1024 * 1024 // 4 MB
+
+// Seen reports whether key, e.g. an Event.Key or an email's key, was
+// recorded for channel with MarkSeen. Together they let a consumer skip
+// events it already processed, e.g. after a crash between processing an
+// event and moving its marker, without a dedupe store of its own:
+//
+//	if seen, _ := bus.Seen(ch, e.Key()); !seen {
+//		process(e)
+//		bus.MarkSeen(ch, e.Key())
+//	}
+func (b *Bus) Seen(channel, key string) (bool, error) {
+	if err := checkSeenKey(key); err != nil {
+		return false, err
+	}
+	unlock, err := b.store().Lock(false)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	return b.store().Markers().HasSeen(channel, key)
+}
+
+// MarkSeen records key as processed by channel (see Seen). The file store
+// forgets the oldest keys of a channel once they take more than 4 MB.
+func (b *Bus) MarkSeen(channel, key string) error {
+	if err := checkSeenKey(key); err != nil {
+		return err
+	}
+	unlock, err := b.store().Lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return b.store().Markers().AddSeen(channel, key)
+}
+
+func checkSeenKey(key string) error {
+	if key == "" || strings.ContainsAny(key, "\r\n") {
+		return fmt.Errorf("invalid key %q: must be a non-empty single line", key)
+	}
+	return nil
+}
+
+// seenPath returns the file holding a channel's seen keys, one per line.
+func (b *Bus) seenPath(channel string) string {
+	return filepath.Join(b.Dir, "markers", sanitizeChannel(channel)+".seen")
+}
+
+func (s fileStore) HasSeen(channel, key string) (bool, error) {
+	f, err := os.Open(s.b.seenPath(channel))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if scanner.Text() == key {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func (s fileStore) AddSeen(channel, key string) error {
+	path := s.b.seenPath(channel)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(key + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(path); err == nil && fi.Size() > seenFileLimit {
+		return pruneSeen(path)
+	}
+	return nil
+}
+
+// pruneSeen drops the older half of a seen keys file.
+func pruneSeen(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	half := data[len(data)/2:]
+	if i := bytes.IndexByte(half, '\n'); i >= 0 {
+		half = half[i+1:]
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, half, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *MemoryStore) HasSeen(channel, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen[channel][key], nil
+}
+
+func (s *MemoryStore) AddSeen(channel, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen[channel] == nil {
+		s.seen[channel] = make(map[string]bool)
+	}
+	s.seen[channel][key] = true
+	return nil
+}
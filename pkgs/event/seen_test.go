@@ -0,0 +1,93 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBusSeen(t *testing.T) {
+	b := NewBus(t.TempDir())
+
+	if seen, err := b.Seen("ch", "k1"); err != nil || seen {
+		t.Fatalf("Seen before MarkSeen = %v, %v", seen, err)
+	}
+	if err := b.MarkSeen("ch", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if seen, _ := b.Seen("ch", "k1"); !seen {
+		t.Error("key not seen after MarkSeen")
+	}
+	if seen, _ := b.Seen("other", "k1"); seen {
+		t.Error("key seen by another channel")
+	}
+	if channels, _ := b.ListChannels(); len(channels) != 0 {
+		t.Errorf("seen keys listed as channels: %v", channels)
+	}
+	for _, key := range []string{"", "a\nb"} {
+		if err := b.MarkSeen("ch", key); err == nil {
+			t.Errorf("MarkSeen(%q) should fail", key)
+		}
+	}
+}
+
+func TestPruneSeen(t *testing.T) {
+	b := NewBus(t.TempDir())
+	if err := b.MarkSeen("ch", "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Grow the file past the limit, then let the next MarkSeen prune it
+	filler := strings.Repeat(fmt.Sprintf("%032d\n", 0), seenFileLimit/33+1)
+	f, err := os.OpenFile(b.seenPath("ch"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(filler)
+	f.Close()
+	if err := b.MarkSeen("ch", "last"); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(b.seenPath("ch"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() > seenFileLimit/2+64 {
+		t.Errorf("seen file not pruned: %d bytes", fi.Size())
+	}
+	if seen, _ := b.Seen("ch", "first"); seen {
+		t.Error("oldest key kept after pruning")
+	}
+	if seen, _ := b.Seen("ch", "last"); !seen {
+		t.Error("newest key lost after pruning")
+	}
+}
+
+func TestEventKey(t *testing.T) {
+	b := NewBus(t.TempDir())
+	b.BlobThreshold = 16
+
+	small := json.RawMessage(`{"n":1}`)
+	e1, _ := b.Add("test", "ch", small)
+	e2, _ := b.Add("test", "ch", small)
+	if e1.ID == e2.ID || e1.Key() != e2.Key() {
+		t.Errorf("same content: IDs %s, %s; keys %s, %s", e1.ID, e2.ID, e1.Key(), e2.Key())
+	}
+	if other, _ := b.Add("test", "other", small); other.Key() == e1.Key() {
+		t.Error("key does not depend on the channel")
+	}
+
+	// A payload offloaded to a blob keys like the same payload inline
+	large := json.RawMessage(`{"text":"` + strings.Repeat("x", 64) + `"}`)
+	blob, _ := b.Add("test", "ch", large)
+	if blob.Blob == nil {
+		t.Fatal("payload not offloaded")
+	}
+	inline := Event{Type: "test", Channel: "ch", Payload: large}
+	if blob.Key() != inline.Key() {
+		t.Error("blob and inline payload keys differ")
+	}
+}
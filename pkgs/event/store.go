@@ -0,0 +1,313 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store is the storage backend of a Bus: the event log, the channel
+// markers and the lock guarding them. The default, used when Bus.Store is
+// nil, is the gzip-compressed JSONL files in Bus.Dir described in the
+// package documentation, locked with OS advisory locks. Those locks are not
+// honoured by some network filesystems; other backends (SQLite, a Redis
+// stream...) can be registered with RegisterStore and selected in
+// <Dir>/store.json, see OpenBus.
+//
+// Positions are opaque to the Bus: a Store hands them out in the File and
+// Offset of the entries it reads, and must accept them back in ReadFrom and
+// in markers. Blobs stay in Bus.Dir whatever the store.
+type Store interface {
+	// Init prepares the store, e.g. creates its first file or its tables.
+	// It is called with the exclusive lock held and must be idempotent.
+	Init() error
+
+	// Lock takes the store-wide lock, exclusive for writers and shared for
+	// readers, and returns the function releasing it.
+	Lock(exclusive bool) (unlock func(), err error)
+
+	// AppendEvent appends evt to the log. The caller holds the exclusive lock.
+	AppendEvent(evt *Event) error
+
+	// ReadFrom returns the events the consumers of channel read after pos,
+	// in order; a zero Position reads from the start. Events of other
+	// channels may be included, as in the shared files. limit <= 0 means
+	// no limit. The caller holds a lock.
+	ReadFrom(channel string, pos Position, limit int) ([]EventEntry, error)
+
+	// Markers returns the store of the channels' consumption positions.
+	Markers() MarkerStore
+}
+
+// BatchStore is a Store that appends several events at once more cheaply
+// than one at a time, e.g. in one transaction. Bus.AddBatch uses it when
+// the store implements it.
+type BatchStore interface {
+	Store
+
+	// AppendEvents appends evts to the log, in order. The caller holds the
+	// exclusive lock.
+	AppendEvents(evts []*Event) error
+}
+
+// MarkerStore keeps the consumption position of each channel, and the
+// keys it has processed (see Bus.Seen).
+type MarkerStore interface {
+	// LoadMarker returns the marker of channel; without one, the error
+	// matches fs.ErrNotExist.
+	LoadMarker(channel string) (*Marker, error)
+	SaveMarker(channel string, m *Marker) error
+	// ListChannels lists the channels that have a marker.
+	ListChannels() ([]string, error)
+
+	// HasSeen reports whether AddSeen recorded key for channel. A store
+	// may forget old keys.
+	HasSeen(channel, key string) (bool, error)
+	AddSeen(channel, key string) error
+}
+
+// MarkerDeleter is a MarkerStore that can delete markers, as
+// Bus.PruneMarkers requires.
+type MarkerDeleter interface {
+	// DeleteMarker deletes the marker of channel; a missing marker is
+	// not an error.
+	DeleteMarker(channel string) error
+}
+
+// StoreConfig selects the store of a bus. OpenBus reads it from
+// <Dir>/store.json, e.g. {"backend": "sqlite", "dsn": "/var/lib/emx/events.db"},
+// or {"rotate": "daily"} for the file store with a new events file each day.
+type StoreConfig struct {
+	Backend string `json:"backend"`          // "file" (default) or a backend passed to RegisterStore
+	DSN     string `json:"dsn,omitempty"`    // Backend-specific location and options
+	Rotate  string `json:"rotate,omitempty"` // Time-based rotation of the file store, see ParseRotation
+}
+
+// storeConfigFile is the name of the store configuration in the bus directory.
+const storeConfigFile = "store.json"
+
+var (
+	backendsMu sync.Mutex
+	backends   = make(map[string]func(dsn string) (Store, error))
+)
+
+// RegisterStore makes a store backend available to OpenBus under name.
+// open creates the store from the configured DSN. It panics if name is
+// "file" or already registered.
+func RegisterStore(name string, open func(dsn string) (Store, error)) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if name == "file" || backends[name] != nil {
+		panic("event: store backend " + name + " registered twice")
+	}
+	backends[name] = open
+}
+
+// OpenBus creates an EventBus in dir with the store selected by
+// <dir>/store.json, or the file store if there is none.
+func OpenBus(dir string) (*Bus, error) {
+	b := NewBus(dir)
+	data, err := os.ReadFile(filepath.Join(dir, storeConfigFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store config: %w", err)
+	}
+	var cfg StoreConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", storeConfigFile, err)
+	}
+	if cfg.Backend == "" || cfg.Backend == "file" {
+		if b.Rotation, err = ParseRotation(cfg.Rotate); err != nil {
+			return nil, fmt.Errorf("%s: %w", storeConfigFile, err)
+		}
+		return b, nil
+	}
+
+	backendsMu.Lock()
+	open := backends[cfg.Backend]
+	names := []string{"file"}
+	for name := range backends {
+		names = append(names, name)
+	}
+	backendsMu.Unlock()
+	if open == nil {
+		sort.Strings(names[1:])
+		return nil, fmt.Errorf("unknown event store backend %q (available: %s)", cfg.Backend, strings.Join(names, ", "))
+	}
+	if b.Store, err = open(cfg.DSN); err != nil {
+		return nil, fmt.Errorf("failed to open %s event store: %w", cfg.Backend, err)
+	}
+	return b, nil
+}
+
+// fileStore is the default Store, the events files in the bus directory.
+type fileStore struct {
+	b *Bus
+}
+
+func (s fileStore) Init() error {
+	return s.b.initFiles()
+}
+
+func (s fileStore) Lock(exclusive bool) (func(), error) {
+	if exclusive {
+		return s.b.lock()
+	}
+	return s.b.rlock()
+}
+
+// AppendEvent writes evt to the latest events file; isolated channels
+// write into their own directory.
+func (s fileStore) AppendEvent(evt *Event) error {
+	return s.AppendEvents([]*Event{evt})
+}
+
+// AppendEvents writes evts like AppendEvent, each run of events bound for
+// the same directory in one gzip member.
+func (s fileStore) AppendEvents(evts []*Event) error {
+	buses := make(map[string]*Bus) // Bus writing the events of each channel
+	busOf := func(channel string) (*Bus, error) {
+		if buses[channel] == nil {
+			buses[channel] = s.b
+			if s.b.isIsolated(channel) {
+				sub := s.b.channelBus(channel)
+				if err := sub.ensureLatest(); err != nil {
+					return nil, err
+				}
+				buses[channel] = sub
+			}
+		}
+		return buses[channel], nil
+	}
+
+	for start := 0; start < len(evts); {
+		files, err := busOf(evts[start].Channel)
+		if err != nil {
+			return err
+		}
+		end := start + 1
+		for ; end < len(evts); end++ {
+			next, err := busOf(evts[end].Channel)
+			if err != nil {
+				return err
+			}
+			if next.Dir != files.Dir {
+				break
+			}
+		}
+		if err := files.appendEvents(evts[start:end], nil); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+func (s fileStore) ReadFrom(channel string, pos Position, limit int) ([]EventEntry, error) {
+	return s.b.listFrom(channel, pos, limit)
+}
+
+func (s fileStore) Markers() MarkerStore {
+	return s
+}
+
+// MemoryStore is a Store keeping events and markers in memory, for tests
+// and programs embedding a bus that need no persistence. Positions are
+// {File: "memory", Offset: <number of events up to and including the entry>}.
+type MemoryStore struct {
+	lock    sync.RWMutex
+	mu      sync.Mutex // Guards events, markers and seen
+	events  []Event
+	markers map[string]*Marker
+	seen    map[string]map[string]bool
+}
+
+// memoryFile is the file name of every MemoryStore position.
+const memoryFile = "memory"
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{markers: make(map[string]*Marker), seen: make(map[string]map[string]bool)}
+}
+
+func (s *MemoryStore) Init() error { return nil }
+
+func (s *MemoryStore) Lock(exclusive bool) (func(), error) {
+	if exclusive {
+		s.lock.Lock()
+		return s.lock.Unlock, nil
+	}
+	s.lock.RLock()
+	return s.lock.RUnlock, nil
+}
+
+func (s *MemoryStore) AppendEvent(evt *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, *evt)
+	return nil
+}
+
+func (s *MemoryStore) ReadFrom(channel string, pos Position, limit int) ([]EventEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := 0
+	if pos.File == memoryFile && pos.Offset > 0 && pos.Offset <= int64(len(s.events)) {
+		start = int(pos.Offset)
+	}
+	var entries []EventEntry
+	for i := start; i < len(s.events); i++ {
+		if limit > 0 && len(entries) >= limit {
+			break
+		}
+		entries = append(entries, EventEntry{Event: s.events[i], File: memoryFile, Offset: int64(i + 1)})
+	}
+	return entries, nil
+}
+
+func (s *MemoryStore) Markers() MarkerStore { return s }
+
+func (s *MemoryStore) LoadMarker(channel string) (*Marker, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.markers[channel]
+	if !ok {
+		return nil, fmt.Errorf("marker %s: %w", channel, fs.ErrNotExist)
+	}
+	c := *m
+	return &c, nil
+}
+
+func (s *MemoryStore) SaveMarker(channel string, m *Marker) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *m
+	s.markers[channel] = &c
+	return nil
+}
+
+func (s *MemoryStore) DeleteMarker(channel string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.markers, channel)
+	return nil
+}
+
+func (s *MemoryStore) ListChannels() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channels := make([]string, 0, len(s.markers))
+	for c := range s.markers {
+		channels = append(channels, c)
+	}
+	sort.Strings(channels)
+	return channels, nil
+}
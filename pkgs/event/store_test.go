@@ -0,0 +1,105 @@
+package event
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBusMemoryStore(t *testing.T) {
+	b := NewBus(t.TempDir())
+	b.Store = NewMemoryStore()
+
+	for i := 0; i < 3; i++ {
+		if _, err := b.Add("test", "ch", json.RawMessage(`{"n":1}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := b.List("ch", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("len(entries) = %d, want 3", len(entries))
+	}
+
+	if err := b.Mark("ch", Position{File: entries[1].File, Offset: entries[1].Offset}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err = b.List("ch", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("after mark: len(entries) = %d, want 1", len(entries))
+	}
+	if channels, _ := b.ListChannels(); len(channels) != 1 || channels[0] != "ch" {
+		t.Errorf("ListChannels() = %v", channels)
+	}
+
+	evt, err := b.AddLinked("test", "ch", func(last *Event) (json.RawMessage, error) {
+		if last == nil {
+			t.Error("AddLinked got no last event")
+			return json.RawMessage(`null`), nil
+		}
+		return json.Marshal(last.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt.Payload == nil {
+		t.Error("AddLinked event has no payload")
+	}
+
+	// Events stay out of the directory; file-only operations refuse
+	if files, _ := filepath.Glob(filepath.Join(b.Dir, "events.*")); len(files) != 0 {
+		t.Errorf("memory store wrote files: %v", files)
+	}
+	if _, err := b.IsolateChannel("ch", 0); err == nil {
+		t.Error("IsolateChannel should fail with a memory store")
+	}
+	if _, err := b.Status(""); err == nil {
+		t.Error("Status should fail with a memory store")
+	}
+}
+
+func TestOpenBus(t *testing.T) {
+	dir := t.TempDir()
+
+	b, err := OpenBus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Store != nil {
+		t.Error("bus without store.json should use the file store")
+	}
+
+	mem := NewMemoryStore()
+	RegisterStore("test-open-bus", func(dsn string) (Store, error) {
+		if dsn != "mem://x" {
+			t.Errorf("dsn = %q", dsn)
+		}
+		return mem, nil
+	})
+	writeStoreConfig(t, dir, `{"backend": "test-open-bus", "dsn": "mem://x"}`)
+	if b, err = OpenBus(dir); err != nil {
+		t.Fatal(err)
+	}
+	if b.Store != mem {
+		t.Errorf("Store = %T, want the registered store", b.Store)
+	}
+
+	writeStoreConfig(t, dir, `{"backend": "redis"}`)
+	if _, err := OpenBus(dir); err == nil || !strings.Contains(err.Error(), "available: file") {
+		t.Errorf("unknown backend: err = %v", err)
+	}
+}
+
+func writeStoreConfig(t *testing.T, dir, data string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, storeConfigFile), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
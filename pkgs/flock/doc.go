@@ -0,0 +1,5 @@
+// Package flock takes advisory locks on open files: flock on Unix,
+// LockFileEx on Windows. A lock belongs to the open file, so the kernel
+// drops it when the holder exits or crashes, and two opens of the same file
+// exclude each other even within one process.
+package flock
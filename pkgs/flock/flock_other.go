@@ -0,0 +1,16 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package flock
+
+import "os"
+
+// TryLock is a no-op on platforms without flock or LockFileEx support;
+// callers get no cross-process exclusion there.
+func TryLock(f *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
+
+// Unlock is a no-op counterpart to TryLock.
+func Unlock(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,51 @@
+//go:build windows
+
+package flock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+
+	errorLockViolation syscall.Errno = 33 // ERROR_LOCK_VIOLATION
+)
+
+// TryLock attempts a non-blocking LockFileEx on the first byte of f.
+// It reports false without error when the lock is held by someone else.
+func TryLock(f *os.File, exclusive bool) (bool, error) {
+	flags := uint32(lockfileFailImmediately)
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+	ol := new(syscall.Overlapped)
+	r1, _, e1 := procLockFileEx.Call(f.Fd(), uintptr(flags), 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r1 != 0 {
+		return true, nil
+	}
+	if errors.Is(e1, errorLockViolation) {
+		return false, nil
+	}
+	return false, e1
+}
+
+// Unlock releases a lock taken by TryLock.
+func Unlock(f *os.File) error {
+	ol := new(syscall.Overlapped)
+	r1, _, e1 := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r1 == 0 {
+		return e1
+	}
+	return nil
+}
@@ -24,6 +24,41 @@ type AMReadyOptions struct {
 
 	// ApplyCoverTrailers copies cover letter trailers to all patches.
 	ApplyCoverTrailers bool
+
+	// TrailerOrder arranges the trailers of each patch.
+	TrailerOrder TrailerOrder
+
+	// DedupTrailers drops trailers that repeat an earlier one, comparing
+	// names and values case-insensitively and ignoring spacing.
+	DedupTrailers bool
+
+	// DropTrailers names trailers to leave out (case-insensitive), e.g.
+	// "Change-Id".
+	DropTrailers []string
+}
+
+// TrailerOrder is how GetAMReady orders the trailers of a patch.
+type TrailerOrder int
+
+const (
+	// TrailerOrderChronological keeps the order the trailers were
+	// collected in: the patch's own, follow-ups, the cover letter's, then
+	// Link and Message-Id.
+	TrailerOrderChronological TrailerOrder = iota
+	// TrailerOrderGrouped keeps the trailers of a name together, the
+	// groups in the order their names first appear.
+	TrailerOrderGrouped
+)
+
+// ParseTrailerOrder parses "chronological" or "grouped".
+func ParseTrailerOrder(s string) (TrailerOrder, error) {
+	switch strings.ToLower(s) {
+	case "", "chronological":
+		return TrailerOrderChronological, nil
+	case "grouped":
+		return TrailerOrderGrouped, nil
+	}
+	return 0, fmt.Errorf("invalid trailer order %q (want chronological or grouped)", s)
 }
 
 // GetAMReady produces a git-am-ready mbox from the patch series.
@@ -144,6 +179,8 @@ func buildAMMessage(patch *PatchMessage, coverTrailers []*Trailer, opts AMReadyO
 		allTrailers = append(allTrailers, msgIdTrailer)
 	}
 
+	allTrailers = normalizeTrailers(allTrailers, opts)
+
 	// Write trailers
 	if len(allTrailers) > 0 {
 		b.WriteString("\n")
@@ -162,6 +199,47 @@ func buildAMMessage(patch *PatchMessage, coverTrailers []*Trailer, opts AMReadyO
 	return b.String()
 }
 
+// normalizeTrailers applies the DropTrailers, DedupTrailers and
+// TrailerOrder options to trailers.
+func normalizeTrailers(trailers []*Trailer, opts AMReadyOptions) []*Trailer {
+	drop := make(map[string]bool, len(opts.DropTrailers))
+	for _, name := range opts.DropTrailers {
+		drop[strings.ToLower(name)] = true
+	}
+	seen := make(map[string]bool)
+	kept := make([]*Trailer, 0, len(trailers))
+	for _, t := range trailers {
+		if drop[strings.ToLower(t.Name)] {
+			continue
+		}
+		if opts.DedupTrailers {
+			key := strings.ToLower(t.Name) + ":" + strings.ToLower(strings.Join(strings.Fields(t.Value), " "))
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		kept = append(kept, t)
+	}
+
+	if opts.TrailerOrder == TrailerOrderGrouped {
+		var names []string
+		groups := make(map[string][]*Trailer)
+		for _, t := range kept {
+			name := strings.ToLower(t.Name)
+			if _, ok := groups[name]; !ok {
+				names = append(names, name)
+			}
+			groups[name] = append(groups[name], t)
+		}
+		kept = kept[:0]
+		for _, name := range names {
+			kept = append(kept, groups[name]...)
+		}
+	}
+	return kept
+}
+
 // formatAddress formats a mail.Address to a string.
 func formatAddress(addr *mail.Address) string {
 	if addr.Name != "" {
@@ -0,0 +1,207 @@
+package patchwork
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Names of the per-patch checks run by PrepBranch.Check.
+const (
+	// CheckSubjectLength flags commit subjects longer than
+	// CheckConfig.MaxSubjectLength.
+	CheckSubjectLength = "subject-length"
+
+	// CheckLineLength flags commit message lines longer than
+	// CheckConfig.MaxLineLength. Trailers and lines without a space, such
+	// as URLs, are exempt.
+	CheckLineLength = "line-length"
+
+	// CheckSignoff flags commits without a Signed-off-by: trailer of their
+	// author.
+	CheckSignoff = "signoff"
+
+	// CheckTrailingWhitespace flags added diff lines ending in whitespace.
+	CheckTrailingWhitespace = "trailing-whitespace"
+)
+
+// CheckNames lists all checks, in the order they are run.
+var CheckNames = []string{CheckSubjectLength, CheckLineLength, CheckSignoff, CheckTrailingWhitespace}
+
+const (
+	// DefaultMaxSubjectLength and DefaultMaxLineLength are the limits
+	// used when the CheckConfig leaves them at zero.
+	DefaultMaxSubjectLength = 75
+	DefaultMaxLineLength    = 75
+)
+
+// CheckConfig configures the checks of a series; it is kept in the
+// tracking data.
+type CheckConfig struct {
+	// Disabled lists the names of checks that are not run.
+	Disabled []string `json:"disabled,omitempty"`
+
+	// MaxSubjectLength and MaxLineLength default to
+	// DefaultMaxSubjectLength and DefaultMaxLineLength when zero.
+	MaxSubjectLength int `json:"max-subject-length,omitempty"`
+	MaxLineLength    int `json:"max-line-length,omitempty"`
+}
+
+// Enabled reports whether the named check is run.
+func (c CheckConfig) Enabled(name string) bool {
+	return !containsIgnoreCase(c.Disabled, name)
+}
+
+// CheckProblem is a check failed by one patch of a series.
+type CheckProblem struct {
+	// Patch is the patch number, starting at 1.
+	Patch int `json:"patch"`
+
+	// Subject is the commit subject.
+	Subject string `json:"subject"`
+
+	// Check is the name of the failed check, e.g. CheckSignoff.
+	Check string `json:"check"`
+
+	// Message describes the problem.
+	Message string `json:"message"`
+}
+
+// CheckReport is the result of checking a series, stored in the tracking
+// data by PrepBranch.Check.
+type CheckReport struct {
+	// Head is the commit the series was checked at.
+	Head string `json:"head"`
+
+	// Time is when the checks ran.
+	Time time.Time `json:"time"`
+
+	// Patches is the number of patches checked.
+	Patches int `json:"patches"`
+
+	// Problems lists the failed checks, in patch order.
+	Problems []CheckProblem `json:"problems,omitempty"`
+}
+
+// Passed reports whether no check failed.
+func (r *CheckReport) Passed() bool {
+	return len(r.Problems) == 0
+}
+
+// Check runs the enabled checks on every commit of the series, oldest
+// first, and saves the report in the tracking data as LastCheck.
+func (pb *PrepBranch) Check() (*CheckReport, error) {
+	if pb.BaseBranch == "" {
+		return nil, fmt.Errorf("no base branch set")
+	}
+
+	head, err := pb.git.RevParse("HEAD")
+	if err != nil {
+		return nil, err
+	}
+	out, err := pb.git.Log("%H%x1f%ae%x1f%s%x1f%b%x1e", "--reverse", "--no-merges", pb.BaseBranch+"..HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CheckReport{Head: head, Time: time.Now().UTC()}
+	for _, record := range strings.Split(out, "\x1e") {
+		record = strings.TrimLeft(record, "\n")
+		if record == "" {
+			continue
+		}
+		fields := strings.SplitN(record, "\x1f", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("unexpected git log output")
+		}
+		hash, author, subject, body := fields[0], fields[1], fields[2], fields[3]
+
+		diff := ""
+		if pb.CheckConfig.Enabled(CheckTrailingWhitespace) {
+			if diff, err = pb.git.Run("show", "--format=", "--no-color", hash); err != nil {
+				return nil, err
+			}
+		}
+
+		report.Patches++
+		for _, p := range checkCommit(pb.CheckConfig, author, subject, body, diff) {
+			p.Patch, p.Subject = report.Patches, subject
+			report.Problems = append(report.Problems, p)
+		}
+	}
+
+	pb.LastCheck = report
+	if err := pb.saveTracking(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// checkCommit runs the enabled checks on a commit by the author address,
+// given its subject, the rest of its message and its diff. The problems
+// have only Check and Message set.
+func checkCommit(cfg CheckConfig, author, subject, body, diff string) []CheckProblem {
+	var problems []CheckProblem
+	problem := func(check, format string, args ...interface{}) {
+		problems = append(problems, CheckProblem{Check: check, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if cfg.Enabled(CheckSubjectLength) {
+		limit := cfg.MaxSubjectLength
+		if limit <= 0 {
+			limit = DefaultMaxSubjectLength
+		}
+		if n := len([]rune(subject)); n > limit {
+			problem(CheckSubjectLength, "subject is %d characters long, over %d", n, limit)
+		}
+	}
+
+	parts := ParseMessageBody(body)
+
+	if cfg.Enabled(CheckLineLength) {
+		limit := cfg.MaxLineLength
+		if limit <= 0 {
+			limit = DefaultMaxLineLength
+		}
+		for _, line := range strings.Split(parts.Body, "\n") {
+			if n := len([]rune(line)); n > limit && strings.Contains(strings.TrimSpace(line), " ") {
+				problem(CheckLineLength, "line is %d characters long, over %d: %q", n, limit, truncateLine(line, 40))
+			}
+		}
+	}
+
+	if cfg.Enabled(CheckSignoff) {
+		signed := false
+		for _, t := range parts.Trailers {
+			if strings.EqualFold(t.Name, "Signed-off-by") && strings.EqualFold(t.Email, author) {
+				signed = true
+				break
+			}
+		}
+		if !signed {
+			problem(CheckSignoff, "no Signed-off-by: trailer of the author <%s>", author)
+		}
+	}
+
+	if cfg.Enabled(CheckTrailingWhitespace) {
+		file := ""
+		for _, line := range strings.Split(diff, "\n") {
+			switch {
+			case strings.HasPrefix(line, "+++ "):
+				file = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			case strings.HasPrefix(line, "+") && strings.TrimRight(line, " \t") != line:
+				problem(CheckTrailingWhitespace, "%s: trailing whitespace: %q", file, truncateLine(line[1:], 40))
+			}
+		}
+	}
+
+	return problems
+}
+
+// truncateLine shortens line to at most n runes, marking the cut.
+func truncateLine(line string, n int) string {
+	if r := []rune(line); len(r) > n {
+		return string(r[:n]) + "..."
+	}
+	return line
+}
@@ -0,0 +1,93 @@
+package patchwork
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckCommit(t *testing.T) {
+	const signoff = "Signed-off-by: Test User <test@example.com>"
+	long := strings.Repeat("word ", 20)
+
+	tests := []struct {
+		name    string
+		cfg     CheckConfig
+		subject string
+		body    string
+		diff    string
+		want    []string
+	}{
+		{"clean", CheckConfig{}, "Fix bug", "Explain.\n\n" + signoff, "+ok\n", nil},
+		{"long subject", CheckConfig{}, long, signoff, "", []string{CheckSubjectLength}},
+		{"custom subject limit", CheckConfig{MaxSubjectLength: 5}, "Fix bug", signoff, "", []string{CheckSubjectLength}},
+		{"long line", CheckConfig{}, "Fix bug", long + "\n\n" + signoff, "", []string{CheckLineLength}},
+		{"long URL", CheckConfig{}, "Fix bug", "Link:\n" + strings.Repeat("x", 100) + "\n\n" + signoff, "", nil},
+		{"no signoff", CheckConfig{}, "Fix bug", "Explain.", "", []string{CheckSignoff}},
+		{"signoff of another", CheckConfig{}, "Fix bug", "Signed-off-by: Other <other@example.com>", "", []string{CheckSignoff}},
+		{"whitespace", CheckConfig{}, "Fix bug", signoff, "+++ b/a.c\n+x \n-y \n", []string{CheckTrailingWhitespace}},
+		{"disabled", CheckConfig{Disabled: []string{CheckSignoff, CheckTrailingWhitespace}}, "Fix bug", "", "+x\t\n", nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, p := range checkCommit(tt.cfg, "TEST@example.com", tt.subject, tt.body, tt.diff) {
+			got = append(got, p.Check)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: checks = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrepBranchCheck(t *testing.T) {
+	dir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	g := NewGit(dir)
+	baseBranch, _ := g.CurrentBranch()
+
+	pb, err := NewPrepBranch(g, "check-test", baseBranch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pb.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := []string{
+		"Add a.txt\n\nSigned-off-by: Test User <test@example.com>",
+		"Add b.txt",
+	}
+	for i, name := range []string{"a.txt", "b.txt"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("content \n"), 0644)
+		g.Run("add", name)
+		g.Run("commit", "-m", messages[i])
+	}
+
+	if err := pb.SaveCheckConfig(CheckConfig{Disabled: []string{CheckTrailingWhitespace}}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := pb.Check()
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	want := []CheckProblem{{Patch: 2, Subject: "Add b.txt", Check: CheckSignoff,
+		Message: "no Signed-off-by: trailer of the author <test@example.com>"}}
+	if report.Patches != 2 || !reflect.DeepEqual(report.Problems, want) {
+		t.Errorf("Check() = %d patches, %+v; want 2, %+v", report.Patches, report.Problems, want)
+	}
+
+	// The configuration and the report are kept in the tracking data
+	loaded, err := LoadPrepBranch(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.CheckConfig.Enabled(CheckTrailingWhitespace) {
+		t.Error("loaded CheckConfig enables trailing-whitespace")
+	}
+	if loaded.LastCheck == nil || loaded.LastCheck.Head != report.Head || loaded.LastCheck.Passed() {
+		t.Errorf("loaded LastCheck = %+v", loaded.LastCheck)
+	}
+}
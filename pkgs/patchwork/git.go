@@ -216,9 +216,10 @@ func (g *Git) Log(format string, args ...string) (string, error) {
 	return g.Run(cmdArgs...)
 }
 
-// FormatPatch generates patches from a commit range using git format-patch.
+// FormatPatch generates patches from a commit range using git format-patch,
+// passing it extraArgs (e.g., "--subject-prefix=PATCH RFC").
 // Returns the paths to the generated patch files.
-func (g *Git) FormatPatch(revRange string, outputDir string) ([]string, error) {
+func (g *Git) FormatPatch(revRange string, outputDir string, extraArgs ...string) ([]string, error) {
 	if outputDir == "" {
 		var err error
 		outputDir, err = os.MkdirTemp("", "patchwork-")
@@ -227,7 +228,8 @@ func (g *Git) FormatPatch(revRange string, outputDir string) ([]string, error) {
 		}
 	}
 
-	out, err := g.Run("format-patch", "-o", outputDir, revRange)
+	args := append([]string{"format-patch", "-o", outputDir}, extraArgs...)
+	out, err := g.Run(append(args, revRange)...)
 	if err != nil {
 		return nil, err
 	}
@@ -83,22 +83,42 @@ type Mailbox struct {
 
 	// Unknowns contains messages that couldn't be classified.
 	Unknowns []*PatchMessage
+
+	// Duplicates counts the messages skipped because one with the same
+	// Message-ID was added before, e.g. from another mbox.
+	Duplicates int
+
+	seen      map[string]bool // Message-IDs added so far
+	followups []*PatchMessage // Replies, for series created after them
 }
 
 // NewMailbox creates a new empty Mailbox.
 func NewMailbox() *Mailbox {
 	return &Mailbox{
 		Series: make(map[int]*PatchSeries),
+		seen:   make(map[string]bool),
 	}
 }
 
-// AddMessage parses and adds an email message to the mailbox.
+// AddMessage parses and adds an email message to the mailbox. A message
+// with the Message-ID of one added before is skipped, so a series can be
+// completed from several partial downloads.
 func (mb *Mailbox) AddMessage(msg *mail.Message) error {
 	pm, err := parseMailMessage(msg)
 	if err != nil {
 		return fmt.Errorf("parsing message: %w", err)
 	}
 
+	if pm.MessageID != "" {
+		if mb.seen[pm.MessageID] {
+			mb.Duplicates++
+			return nil
+		}
+		if mb.seen == nil {
+			mb.seen = make(map[string]bool)
+		}
+		mb.seen[pm.MessageID] = true
+	}
 	mb.Messages = append(mb.Messages, pm)
 
 	// Classify the message
@@ -106,6 +126,7 @@ func (mb *Mailbox) AddMessage(msg *mail.Message) error {
 		// It's a follow-up reply — extract trailers for the referenced patch
 		pm.FollowupTrailers = ParseTrailers(pm.Body)
 		// Find which series this reply belongs to and add as followup
+		mb.followups = append(mb.followups, pm)
 		for _, series := range mb.Series {
 			series.Followups = append(series.Followups, pm)
 		}
@@ -115,7 +136,8 @@ func (mb *Mailbox) AddMessage(msg *mail.Message) error {
 	rev := pm.Parsed.Revision
 	series, ok := mb.Series[rev]
 	if !ok {
-		series = &PatchSeries{Revision: rev}
+		// Replies may come before their patches when reading several mboxes
+		series = &PatchSeries{Revision: rev, Followups: append([]*PatchMessage(nil), mb.followups...)}
 		mb.Series[rev] = series
 	}
 
@@ -139,30 +161,38 @@ func (mb *Mailbox) AddMessage(msg *mail.Message) error {
 	return nil
 }
 
-// ReadMbox reads an mbox file and adds all messages to the mailbox.
+// ReadMbox reads an mbox file and adds all messages to the mailbox. It may
+// be called for several mboxes, which are merged.
 func (mb *Mailbox) ReadMbox(r io.Reader) error {
+	return WalkMbox(r, func(msgReader io.Reader) error {
+		msg, err := mail.ReadMessage(msgReader)
+		if err != nil {
+			return fmt.Errorf("parsing mail message: %w", err)
+		}
+		return mb.AddMessage(msg)
+	})
+}
+
+// WalkMbox streams an mbox, calling fn with the raw RFC 5322 content of each
+// message in order, without the "From " separator line.
+// The reader passed to fn is only valid until fn returns. Walking stops at
+// the first error returned by fn.
+func WalkMbox(r io.Reader, fn func(msg io.Reader) error) error {
 	mr := mbox.NewReader(r)
 
 	for {
 		msgReader, err := mr.NextMessage()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
 			return fmt.Errorf("reading mbox message: %w", err)
 		}
 
-		msg, err := mail.ReadMessage(msgReader)
-		if err != nil {
-			return fmt.Errorf("parsing mail message: %w", err)
-		}
-
-		if err := mb.AddMessage(msg); err != nil {
+		if err := fn(msgReader); err != nil {
 			return err
 		}
 	}
-
-	return nil
 }
 
 // GetSeries returns the patch series for the given revision.
@@ -199,6 +229,33 @@ func (mb *Mailbox) GetSeries(revision int) *PatchSeries {
 	return series
 }
 
+// Revisions returns the revisions of the series in the mailbox, in
+// ascending order.
+func (mb *Mailbox) Revisions() []int {
+	revs := make([]int, 0, len(mb.Series))
+	for rev := range mb.Series {
+		revs = append(revs, rev)
+	}
+	sort.Ints(revs)
+	return revs
+}
+
+// Missing returns the numbers of the expected patches that are not in the
+// series, in ascending order; nil if the expected count is unknown.
+func (series *PatchSeries) Missing() []int {
+	have := make(map[int]bool, len(series.Patches))
+	for _, p := range series.Patches {
+		have[p.Parsed.Counter] = true
+	}
+	var missing []int
+	for n := 1; n <= series.Expected; n++ {
+		if !have[n] {
+			missing = append(missing, n)
+		}
+	}
+	return missing
+}
+
 // GetLatestSeries returns the latest revision of the patch series
 // with follow-up trailers applied.
 func (mb *Mailbox) GetLatestSeries() *PatchSeries {
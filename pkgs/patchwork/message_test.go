@@ -2,7 +2,11 @@ package patchwork
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"net/mail"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -289,6 +293,113 @@ diff --git a/a.c b/a.c
 	}
 }
 
+func TestMailboxMergeMboxes(t *testing.T) {
+	patch := func(n int) string {
+		return fmt.Sprintf(`From: Author <author@example.com>
+Date: Mon, 01 Jan 2024 00:00:0%d +0000
+Subject: [PATCH v2 %d/4] Change %d
+Message-Id: <patch%d@example.com>
+
+Change %d.
+
+Signed-off-by: Author <author@example.com>
+---
+diff --git a/a.c b/a.c
+--- a/a.c
++++ b/a.c
+@@ -1 +1 @@
+-old
++new%d`, n, n, n, n, n, n)
+	}
+	// The review of patch 2 comes before the patch itself
+	part1 := buildTestMbox(patch(1), `From: Reviewer <reviewer@example.com>
+Date: Mon, 01 Jan 2024 01:00:00 +0000
+Subject: Re: [PATCH v2 2/4] Change 2
+Message-Id: <review@example.com>
+In-Reply-To: <patch2@example.com>
+
+Reviewed-by: Reviewer <reviewer@example.com>`)
+	part2 := buildTestMbox(patch(1), patch(2))
+
+	mb := NewMailbox()
+	for _, part := range []string{part1, part2} {
+		if err := mb.ReadMbox(strings.NewReader(part)); err != nil {
+			t.Fatalf("ReadMbox() error = %v", err)
+		}
+	}
+
+	if mb.Duplicates != 1 || len(mb.Messages) != 3 {
+		t.Errorf("Duplicates = %d, len(Messages) = %d; want 1, 3", mb.Duplicates, len(mb.Messages))
+	}
+	series := mb.GetLatestSeries()
+	if series == nil || series.Revision != 2 {
+		t.Fatalf("GetLatestSeries() = %v, want v2", series)
+	}
+	if series.Complete {
+		t.Error("series should be incomplete")
+	}
+	if got := series.Missing(); !reflect.DeepEqual(got, []int{3, 4}) {
+		t.Errorf("Missing() = %v, want [3 4]", got)
+	}
+	if got := len(series.Patches[1].BodyParts.Trailers); got != 2 {
+		t.Errorf("patch 2 has %d trailers, want 2 with the earlier review", got)
+	}
+	if got := mb.Revisions(); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("Revisions() = %v, want [2]", got)
+	}
+
+	if err := mb.ReadMbox(strings.NewReader(buildTestMbox(patch(3), patch(4)))); err != nil {
+		t.Fatalf("ReadMbox() error = %v", err)
+	}
+	series = mb.GetSeries(2)
+	if !series.Complete || series.Missing() != nil {
+		t.Errorf("Complete = %v, Missing() = %v; want complete", series.Complete, series.Missing())
+	}
+}
+
+func TestWalkMbox(t *testing.T) {
+	mboxData := buildTestMbox(
+		"Message-ID: <one@test>\nSubject: first\n\nhello",
+		"Message-ID: <two@test>\nSubject: second\n\nworld",
+	)
+
+	var got []string
+	err := WalkMbox(strings.NewReader(mboxData), func(r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		got = append(got, string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkMbox() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d messages, want 2", len(got))
+	}
+	if !strings.HasPrefix(got[0], "Message-ID: <one@test>") {
+		t.Errorf("message 1 = %q, want it to start with its headers", got[0])
+	}
+	if !strings.Contains(got[0], "hello") || strings.Contains(got[0], "second") {
+		t.Errorf("message 1 = %q, want only its own body", got[0])
+	}
+	if !strings.HasPrefix(got[1], "Message-ID: <two@test>") {
+		t.Errorf("message 2 = %q", got[1])
+	}
+
+	// Errors from the callback stop the walk
+	calls := 0
+	stop := errors.New("stop")
+	err = WalkMbox(strings.NewReader(mboxData), func(io.Reader) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("WalkMbox() = %v after %d calls, want stop after 1", err, calls)
+	}
+}
+
 func TestParseMailMessage(t *testing.T) {
 	raw := `From: 测试者 <test@example.com>
 Date: Mon, 01 Jan 2024 00:00:00 +0000
@@ -495,6 +606,76 @@ diff --git a/foo.c b/foo.c
 	}
 }
 
+func TestGetAMReadyTrailerOptions(t *testing.T) {
+	mboxData := buildTestMbox(
+		`From: Author <author@example.com>
+Date: Mon, 01 Jan 2024 00:00:00 +0000
+Subject: [PATCH 1/1] Test patch
+Message-Id: <patch@example.com>
+
+Test commit message.
+
+Signed-off-by: Author <author@example.com>
+Change-Id: I0123456789abcdef
+Reviewed-by: Reviewer <reviewer@example.com>
+signed-off-by:  Author  <AUTHOR@example.com>
+Acked-by: Acker <acker@example.com>
+Reviewed-by: Second <second@example.com>
+---
+diff --git a/a.c b/a.c
+--- a/a.c
++++ b/a.c
+@@ -1 +1 @@
++test`,
+	)
+
+	mb := NewMailbox()
+	if err := mb.ReadMbox(strings.NewReader(mboxData)); err != nil {
+		t.Fatalf("ReadMbox() error = %v", err)
+	}
+	series := mb.GetSeries(0)
+	if series == nil {
+		t.Fatal("no series found")
+	}
+
+	data, err := series.GetAMReady(AMReadyOptions{
+		TrailerOrder:  TrailerOrderGrouped,
+		DedupTrailers: true,
+		DropTrailers:  []string{"change-id"},
+	})
+	if err != nil {
+		t.Fatalf("GetAMReady() error = %v", err)
+	}
+	want := "Signed-off-by: Author <author@example.com>\n" +
+		"Reviewed-by: Reviewer <reviewer@example.com>\n" +
+		"Reviewed-by: Second <second@example.com>\n" +
+		"Acked-by: Acker <acker@example.com>\n---\n"
+	if !strings.Contains(string(data), want) {
+		t.Errorf("trailers not normalized, want:\n%s\ngot:\n%s", want, data)
+	}
+
+	// The defaults keep every trailer in order
+	data, err = series.GetAMReady(AMReadyOptions{})
+	if err != nil {
+		t.Fatalf("GetAMReady() error = %v", err)
+	}
+	if !strings.Contains(string(data), "Change-Id: I0123456789abcdef\nReviewed-by: Reviewer") ||
+		!strings.Contains(string(data), "signed-off-by: Author  <AUTHOR@example.com>") {
+		t.Errorf("default output changed trailers:\n%s", data)
+	}
+}
+
+func TestParseTrailerOrder(t *testing.T) {
+	for s, want := range map[string]TrailerOrder{"": TrailerOrderChronological, "chronological": TrailerOrderChronological, "Grouped": TrailerOrderGrouped} {
+		if got, err := ParseTrailerOrder(s); err != nil || got != want {
+			t.Errorf("ParseTrailerOrder(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseTrailerOrder("by-type"); err == nil {
+		t.Error("ParseTrailerOrder(by-type) succeeded")
+	}
+}
+
 func TestWriteSeries(t *testing.T) {
 	mboxData := buildTestMbox(
 		`From: Author <author@example.com>
@@ -533,3 +714,23 @@ diff --git a/a.c b/a.c
 		t.Error("WriteSeries() produced empty output")
 	}
 }
+
+func FuzzReadMbox(f *testing.F) {
+	f.Add([]byte(buildTestMbox(
+		"Message-ID: <one@test>\nSubject: [PATCH 1/2] first\n\nbody\n---\ndiff --git a/x b/x\n",
+		"Message-ID: <two@test>\nIn-Reply-To: <one@test>\nSubject: Re: [PATCH 1/2] first\n\nAcked-by: A <a@b.c>\n",
+	)))
+	f.Add([]byte("From x\nSubject: =?utf-8?q?broken\n =?x?b?\n\n"))
+	f.Add([]byte("From x\n\n"))
+	f.Add([]byte("not an mbox"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		mb := NewMailbox()
+		mb.ReadMbox(bytes.NewReader(data))
+		if series := mb.GetLatestSeries(); series != nil {
+			for _, p := range series.Patches {
+				p.Parsed.Rebuild()
+			}
+		}
+	})
+}
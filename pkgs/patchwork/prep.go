@@ -5,10 +5,13 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"mime"
+	"net/mail"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // PrepBranch represents a prepared patch series branch for mailing list submission.
@@ -34,6 +37,17 @@ type PrepBranch struct {
 	// CoverBody is the cover letter body text.
 	CoverBody string
 
+	// To and Cc are the addresses every message of the series is sent to.
+	// Each patch is also copied to the Cc: trailers of its commit message.
+	To []string
+	Cc []string
+
+	// CheckConfig configures the checks run by Check.
+	CheckConfig CheckConfig
+
+	// LastCheck is the report of the latest Check, nil if none ran.
+	LastCheck *CheckReport
+
 	// git is the Git instance.
 	git *Git
 }
@@ -45,7 +59,11 @@ type PrepTrackingData struct {
 		ChangeID   string   `json:"change-id"`
 		BaseBranch string   `json:"base-branch"`
 		Prefixes   []string `json:"prefixes,omitempty"`
+		To         []string `json:"to,omitempty"`
+		Cc         []string `json:"cc,omitempty"`
 	} `json:"series"`
+	Check     *CheckConfig `json:"check,omitempty"`
+	LastCheck *CheckReport `json:"last-check,omitempty"`
 }
 
 const (
@@ -60,6 +78,12 @@ const (
 
 	// coverFile is the file name for cover letter content.
 	coverFile = "cover"
+
+	// CoverShortlogMarker and CoverDiffstatMarker in the cover letter body
+	// are replaced by the series shortlog and diffstat when the patches
+	// are generated.
+	CoverShortlogMarker = "${shortlog}"
+	CoverDiffstatMarker = "${diffstat}"
 )
 
 // NewPrepBranch creates a new prep branch for a patch series.
@@ -160,6 +184,12 @@ func (pb *PrepBranch) saveTracking() error {
 	data.Series.ChangeID = pb.ChangeID
 	data.Series.BaseBranch = pb.BaseBranch
 	data.Series.Prefixes = pb.Prefixes
+	data.Series.To = pb.To
+	data.Series.Cc = pb.Cc
+	if pb.CheckConfig.Disabled != nil || pb.CheckConfig.MaxSubjectLength != 0 || pb.CheckConfig.MaxLineLength != 0 {
+		data.Check = &pb.CheckConfig
+	}
+	data.LastCheck = pb.LastCheck
 
 	jsonData, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
@@ -192,6 +222,12 @@ func (pb *PrepBranch) loadTracking() error {
 	pb.ChangeID = data.Series.ChangeID
 	pb.BaseBranch = data.Series.BaseBranch
 	pb.Prefixes = data.Series.Prefixes
+	pb.To = data.Series.To
+	pb.Cc = data.Series.Cc
+	if data.Check != nil {
+		pb.CheckConfig = *data.Check
+	}
+	pb.LastCheck = data.LastCheck
 
 	return nil
 }
@@ -239,14 +275,245 @@ func (pb *PrepBranch) SaveCover(subject, body string) error {
 	return os.WriteFile(path, []byte(content), 0644)
 }
 
-// GetPatches generates patches from the prep branch using git format-patch.
+// SaveCheckConfig sets and saves the configuration of the checks.
+func (pb *PrepBranch) SaveCheckConfig(cfg CheckConfig) error {
+	pb.CheckConfig = cfg
+	return pb.saveTracking()
+}
+
+// SaveRecipients sets and saves the series To and Cc addresses.
+func (pb *PrepBranch) SaveRecipients(to, cc []string) error {
+	pb.To = to
+	pb.Cc = cc
+	return pb.saveTracking()
+}
+
+// GetPatches generates patches from the prep branch using git format-patch,
+// numbered as FormatSeriesSubject does. With a cover letter, its 0000 file
+// comes first (see writeCover). Each patch gets To: and Cc: headers with
+// its recipients (see Recipients).
 func (pb *PrepBranch) GetPatches(outputDir string) ([]string, error) {
 	if pb.BaseBranch == "" {
 		return nil, fmt.Errorf("no base branch set")
 	}
 
+	args := []string{"--subject-prefix=" + pb.subjectPrefix()}
+	if pb.Revision > 1 {
+		args = append(args, fmt.Sprintf("-v%d", pb.Revision))
+	}
+	if pb.CoverSubject != "" {
+		args = append(args, "--numbered")
+	}
+
 	revRange := pb.BaseBranch + "..HEAD"
-	return pb.git.FormatPatch(revRange, outputDir)
+	paths, err := pb.git.FormatPatch(revRange, outputDir, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients, err := pb.Recipients()
+	if err != nil {
+		return nil, err
+	}
+	if len(recipients)-1 != len(paths) {
+		return nil, fmt.Errorf("generated %d patches for %d commits", len(paths), len(recipients)-1)
+	}
+	for i, path := range paths {
+		if err := addRecipientHeaders(path, recipients[i+1]); err != nil {
+			return nil, err
+		}
+	}
+
+	if pb.CoverSubject != "" && len(paths) > 0 {
+		cover, err := pb.writeCover(filepath.Dir(paths[0]), len(paths))
+		if err != nil {
+			return nil, err
+		}
+		if err := addRecipientHeaders(cover, recipients[0]); err != nil {
+			return nil, err
+		}
+		paths = append([]string{cover}, paths...)
+	}
+
+	return paths, nil
+}
+
+// subjectPrefix returns the bracket prefix of the series subjects without
+// version and counter, e.g. "PATCH RFC".
+func (pb *PrepBranch) subjectPrefix() string {
+	if containsIgnoreCase(pb.Prefixes, "RFC") {
+		return "PATCH RFC"
+	}
+	return "PATCH"
+}
+
+// writeCover writes the cover letter of a series of total patches to dir as
+// a format-patch style 0000 file and returns its path. The shortlog and
+// diffstat are generated from the commits (see expandCoverBody).
+func (pb *PrepBranch) writeCover(dir string, total int) (string, error) {
+	shortlog, err := pb.ShortLog()
+	if err != nil {
+		return "", fmt.Errorf("generating shortlog: %w", err)
+	}
+	diffstat, err := pb.DiffStat()
+	if err != nil {
+		return "", fmt.Errorf("generating diffstat: %w", err)
+	}
+	head, err := pb.git.RevParse("HEAD")
+	if err != nil {
+		return "", err
+	}
+	name, _ := pb.git.Config("user.name")
+	addr, _ := pb.git.Config("user.email")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From %s Mon Sep 17 00:00:00 2001\n", head)
+	fmt.Fprintf(&b, "From: %s\n", (&mail.Address{Name: name, Address: addr}).String())
+	fmt.Fprintf(&b, "Date: %s\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Subject: %s\n", mime.QEncoding.Encode("utf-8", pb.FormatSeriesSubject(0, total, pb.CoverSubject)))
+	b.WriteString("MIME-Version: 1.0\nContent-Type: text/plain; charset=UTF-8\nContent-Transfer-Encoding: 8bit\n\n")
+	b.WriteString(expandCoverBody(pb.CoverBody, shortlog, diffstat))
+
+	file := "0000-cover-letter.patch"
+	if pb.Revision > 1 {
+		file = fmt.Sprintf("v%d-%s", pb.Revision, file)
+	}
+	path := filepath.Join(dir, file)
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return "", fmt.Errorf("writing cover letter: %w", err)
+	}
+	return path, nil
+}
+
+// expandCoverBody replaces the shortlog and diffstat markers in a cover
+// letter body. A body without markers gets both appended, as in the cover
+// letters of git format-patch.
+func expandCoverBody(body, shortlog, diffstat string) string {
+	body = strings.TrimRight(body, "\n")
+	if !strings.Contains(body, CoverShortlogMarker) && !strings.Contains(body, CoverDiffstatMarker) {
+		if body != "" {
+			body += "\n\n"
+		}
+		body += CoverShortlogMarker + "\n\n" + CoverDiffstatMarker
+	}
+	return strings.NewReplacer(
+		CoverShortlogMarker, strings.TrimRight(shortlog, "\n"),
+		CoverDiffstatMarker, strings.TrimRight(diffstat, "\n"),
+	).Replace(body) + "\n"
+}
+
+// PatchRecipients are the addresses one message of a series is sent to.
+type PatchRecipients struct {
+	// Patch is the patch number, or 0 for the cover letter.
+	Patch int
+
+	// Subject is the commit subject, or the cover letter subject.
+	Subject string
+
+	To []string
+	Cc []string
+}
+
+// Recipients returns the recipient matrix of the series: the cover letter
+// first, then one entry per commit, oldest first. Every patch goes to the
+// series To and Cc plus the Cc: trailers of its own commit message, as the
+// kernel workflow expects; the cover letter is copied to all of them.
+func (pb *PrepBranch) Recipients() ([]PatchRecipients, error) {
+	if pb.BaseBranch == "" {
+		return nil, fmt.Errorf("no base branch set")
+	}
+
+	out, err := pb.git.Log("%s%x1f%B%x1e", "--reverse", "--no-merges", pb.BaseBranch+"..HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	cover := PatchRecipients{Subject: pb.CoverSubject, To: pb.To}
+	coverSeen := addressSet(pb.To)
+	cover.Cc = appendAddresses(nil, coverSeen, pb.Cc...)
+
+	matrix := []PatchRecipients{cover}
+	for _, record := range strings.Split(out, "\x1e") {
+		record = strings.TrimLeft(record, "\n")
+		if record == "" {
+			continue
+		}
+		subject, message, _ := strings.Cut(record, "\x1f")
+
+		var trailerCc []string
+		for _, t := range ParseMessageBody(message).Trailers {
+			if strings.EqualFold(t.Name, "Cc") && t.Email != "" {
+				trailerCc = append(trailerCc, t.Value)
+			}
+		}
+
+		patch := PatchRecipients{Patch: len(matrix), Subject: subject, To: pb.To}
+		seen := addressSet(pb.To)
+		patch.Cc = appendAddresses(nil, seen, pb.Cc...)
+		patch.Cc = appendAddresses(patch.Cc, seen, trailerCc...)
+		matrix = append(matrix, patch)
+
+		matrix[0].Cc = appendAddresses(matrix[0].Cc, coverSeen, trailerCc...)
+	}
+
+	return matrix, nil
+}
+
+// addressKey returns the lower-cased email address of a recipient such as
+// "Name <addr>", for de-duplication.
+func addressKey(recipient string) string {
+	if addr, err := mail.ParseAddress(recipient); err == nil {
+		return strings.ToLower(addr.Address)
+	}
+	return strings.ToLower(strings.TrimSpace(recipient))
+}
+
+// addressSet returns the addressKey set of recipients.
+func addressSet(recipients []string) map[string]bool {
+	seen := make(map[string]bool, len(recipients))
+	for _, r := range recipients {
+		seen[addressKey(r)] = true
+	}
+	return seen
+}
+
+// appendAddresses appends the recipients whose address is not in seen yet.
+func appendAddresses(list []string, seen map[string]bool, recipients ...string) []string {
+	for _, r := range recipients {
+		if key := addressKey(r); key != "" && !seen[key] {
+			seen[key] = true
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+// addRecipientHeaders adds To: and Cc: headers to a format-patch file.
+func addRecipientHeaders(path string, r PatchRecipients) error {
+	if len(r.To) == 0 && len(r.Cc) == 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading patch: %w", err)
+	}
+	content := string(data)
+	end := strings.Index(content, "\n\n")
+	if end < 0 {
+		return fmt.Errorf("%s: no header block", path)
+	}
+
+	var headers strings.Builder
+	if len(r.To) > 0 {
+		headers.WriteString("\nTo: " + strings.Join(r.To, ",\n    "))
+	}
+	if len(r.Cc) > 0 {
+		headers.WriteString("\nCc: " + strings.Join(r.Cc, ",\n    "))
+	}
+	content = content[:end] + headers.String() + content[end:]
+
+	return os.WriteFile(path, []byte(content), 0644)
 }
 
 // Reroll bumps the revision number for a new version of the series.
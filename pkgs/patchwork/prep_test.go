@@ -1,8 +1,12 @@
 package patchwork
 
 import (
+	"bytes"
+	"io"
+	"net/mail"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -249,6 +253,173 @@ func TestPrepBranchGetPatches(t *testing.T) {
 	}
 }
 
+func TestPrepBranchGetPatchesCover(t *testing.T) {
+	dir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	g := NewGit(dir)
+
+	baseBranch, _ := g.CurrentBranch()
+
+	pb, err := NewPrepBranch(g, "cover-patches", baseBranch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pb.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pb.Reroll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pb.SaveCover("Add two files", "Two files.\n\n${shortlog}\n\n${diffstat}\n\nThanks"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("content\n"), 0644)
+		g.Run("add", name)
+		g.Run("commit", "-m", "Add "+name)
+	}
+
+	paths, err := pb.GetPatches(t.TempDir())
+	if err != nil {
+		t.Fatalf("GetPatches() error = %v", err)
+	}
+	if len(paths) != 3 {
+		t.Fatalf("len(paths) = %d, want 3", len(paths))
+	}
+	if filepath.Base(paths[0]) != "v2-0000-cover-letter.patch" {
+		t.Errorf("cover letter file = %s", paths[0])
+	}
+
+	subjects := []string{"[PATCH v2 0/2] Add two files", "[PATCH v2 1/2] Add a.txt", "[PATCH v2 2/2] Add b.txt"}
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if got := msg.Header.Get("Subject"); got != subjects[i] {
+			t.Errorf("Subject = %q, want %q", got, subjects[i])
+		}
+		if i == 0 {
+			body, _ := io.ReadAll(msg.Body)
+			for _, want := range []string{"Two files.\n\nTest User (2):\n", "Add b.txt", "2 files changed", "\n\nThanks\n"} {
+				if !bytes.Contains(body, []byte(want)) {
+					t.Errorf("cover letter body lacks %q:\n%s", want, body)
+				}
+			}
+		}
+	}
+}
+
+func TestExpandCoverBody(t *testing.T) {
+	const shortlog = "A (1):\n      fix\n\n"
+	const diffstat = " a | 1 +\n 1 file changed\n"
+
+	got := expandCoverBody("Intro\n\n${diffstat}\n", shortlog, diffstat)
+	if want := "Intro\n\n a | 1 +\n 1 file changed\n"; got != want {
+		t.Errorf("with marker = %q, want %q", got, want)
+	}
+
+	got = expandCoverBody("Intro", shortlog, diffstat)
+	if want := "Intro\n\nA (1):\n      fix\n\n a | 1 +\n 1 file changed\n"; got != want {
+		t.Errorf("without markers = %q, want %q", got, want)
+	}
+}
+
+func TestPrepBranchRecipients(t *testing.T) {
+	dir, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	g := NewGit(dir)
+
+	baseBranch, _ := g.CurrentBranch()
+
+	pb, err := NewPrepBranch(g, "cc-test", baseBranch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pb.Create(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pb.SaveRecipients([]string{"list@example.org"}, []string{"Maint <maint@example.org>"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first commit copies a reviewer; the second has no Cc: trailer
+	messages := []string{
+		"Add a.txt\n\nCc: Rev Iewer <rev@example.com>\nCc: maint@example.org\nSigned-off-by: Test User <test@example.com>",
+		"Add b.txt",
+	}
+	for i, name := range []string{"a.txt", "b.txt"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("content\n"), 0644)
+		g.Run("add", name)
+		g.Run("commit", "-m", messages[i])
+	}
+
+	matrix, err := pb.Recipients()
+	if err != nil {
+		t.Fatalf("Recipients() error = %v", err)
+	}
+	if len(matrix) != 3 {
+		t.Fatalf("len(matrix) = %d, want 3", len(matrix))
+	}
+
+	want := [][]string{
+		{"Maint <maint@example.org>", "Rev Iewer <rev@example.com>"}, // cover letter
+		{"Maint <maint@example.org>", "Rev Iewer <rev@example.com>"},
+		{"Maint <maint@example.org>"},
+	}
+	for i, r := range matrix {
+		if r.Patch != i {
+			t.Errorf("matrix[%d].Patch = %d", i, r.Patch)
+		}
+		if !reflect.DeepEqual(r.To, []string{"list@example.org"}) {
+			t.Errorf("matrix[%d].To = %q", i, r.To)
+		}
+		if !reflect.DeepEqual(r.Cc, want[i]) {
+			t.Errorf("matrix[%d].Cc = %q, want %q", i, r.Cc, want[i])
+		}
+	}
+	if matrix[2].Subject != "Add b.txt" {
+		t.Errorf("matrix[2].Subject = %q", matrix[2].Subject)
+	}
+
+	// The generated patches carry their own recipients
+	outputDir := t.TempDir()
+	paths, err := pb.GetPatches(outputDir)
+	if err != nil {
+		t.Fatalf("GetPatches() error = %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("len(paths) = %d, want 2", len(paths))
+	}
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("patch %d: %v", i+1, err)
+		}
+		if to := msg.Header.Get("To"); to != "list@example.org" {
+			t.Errorf("patch %d To = %q", i+1, to)
+		}
+		cc, err := msg.Header.AddressList("Cc")
+		if err != nil {
+			t.Fatalf("patch %d Cc: %v", i+1, err)
+		}
+		if len(cc) != len(want[i+1]) {
+			t.Errorf("patch %d Cc = %v, want %q", i+1, cc, want[i+1])
+		}
+	}
+}
+
 func TestPrepBranchDiffStat(t *testing.T) {
 	dir, cleanup := setupTestRepo(t)
 	defer cleanup()
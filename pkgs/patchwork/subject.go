@@ -10,6 +10,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 // PatchSubject holds the parsed components of an email subject line
@@ -21,6 +22,11 @@ type PatchSubject struct {
 	// Prefixes contains all text from bracket prefixes (e.g., "PATCH", "RFC").
 	Prefixes []string
 
+	// Tree is the tree or branch a patch targets (e.g., "net-next" in
+	// [PATCH net-next v4 03/12]), or "" if none is given. It is also in
+	// Prefixes.
+	Tree string
+
 	// Counter is the patch number in the series (e.g., 2 in [PATCH 2/5]).
 	Counter int
 
@@ -30,7 +36,8 @@ type PatchSubject struct {
 	// Revision is the version (e.g., 3 for [PATCH v3]).
 	Revision int
 
-	// IsReply indicates the subject had Re:/Aw:/Fwd: prefix.
+	// IsReply indicates the subject had a reply or forward prefix
+	// (Re:, Aw:, Fwd:, SV:, 回复:, ...).
 	IsReply bool
 
 	// IsRFC indicates the [RFC] prefix was present.
@@ -44,11 +51,14 @@ type PatchSubject struct {
 }
 
 var (
-	// reReply matches Re:/Aw:/Fwd: prefixes (case-insensitive).
-	reReply = regexp.MustCompile(`(?i)^(Re|Aw|Fwd)\s*:`)
+	// reReply matches a reply or forward prefix, in English or the
+	// languages mail clients commonly localize it to, with an optional
+	// reply count (e.g., "Re:", "Re[2]:", "SV:", "WG:", "回复：").
+	reReply = regexp.MustCompile(`(?i)^(re|aw|fwd?|sv|vs|antw|odp|wg|tr|r|res|enc|rv|ynt|回复|回覆|答复|答覆|转发|轉寄|轉發|返信|転送)(\[\d+\])?\s*[:：]\s*`)
 
-	// reGenericReply matches generic 2-3 letter reply prefixes before [.
-	reGenericReply = regexp.MustCompile(`(?i)^\w{2,3}:\s*\[`)
+	// reGenericReply matches other 2-3 letter reply prefixes, in any
+	// script, before [.
+	reGenericReply = regexp.MustCompile(`^\p{L}{2,3}\s*[:：]\s*\[`)
 
 	// reBracket matches a [...] prefix block.
 	reBracket = regexp.MustCompile(`^\s*\[([^\]]*)\]\s*`)
@@ -69,12 +79,23 @@ var (
 	reNestedBracketOuter = regexp.MustCompile(`\[([^\]]*)\]([^\[\]]*)\]`)
 )
 
+// nonTreePrefixes are the bracket words (uppercase) that never name a tree.
+var nonTreePrefixes = map[string]bool{
+	"PATCH":  true,
+	"GIT":    true,
+	"REPOST": true,
+	"RFT":    true,
+	"WIP":    true,
+	"FYI":    true,
+}
+
 // ParseSubject parses a patch email subject line into its components.
 // It handles subjects like:
 //
 //	"[PATCH v3 RFC 2/5] drivers: fix null pointer dereference"
 //	"Re: [PATCH 1/3] some fix"
 //	"[PATCH] single patch"
+//	"SV: 回复: [RFC PATCH net-next v4,03/12] net: fix"
 func ParseSubject(subject string) *PatchSubject {
 	ps := &PatchSubject{
 		Revision: 1,
@@ -83,17 +104,18 @@ func ParseSubject(subject string) *PatchSubject {
 	// Normalize whitespace
 	s := strings.Join(strings.Fields(subject), " ")
 
-	// Detect and strip reply prefix
-	if reReply.MatchString(s) {
-		ps.IsReply = true
-		s = reReply.ReplaceAllString(s, "")
-		s = strings.TrimSpace(s)
-	} else if reGenericReply.MatchString(s) {
-		ps.IsReply = true
-		idx := strings.Index(s, "[")
-		if idx >= 0 {
-			s = s[idx:]
+	// Detect and strip reply prefixes, which may be stacked
+	for {
+		if loc := reReply.FindStringIndex(s); loc != nil {
+			ps.IsReply = true
+			s = s[loc[1]:]
+			continue
+		}
+		if reGenericReply.MatchString(s) {
+			ps.IsReply = true
+			s = s[strings.Index(s, "["):]
 		}
+		break
 	}
 
 	// Flatten nested brackets
@@ -116,26 +138,29 @@ func ParseSubject(subject string) *PatchSubject {
 		content := s[loc[2]:loc[3]]
 		s = s[loc[1]:]
 
-		// Parse each chunk inside the brackets
-		chunks := strings.Fields(content)
+		// Parse each chunk inside the brackets; some senders separate
+		// them with commas
+		chunks := strings.FieldsFunc(content, func(r rune) bool {
+			return unicode.IsSpace(r) || r == ','
+		})
 		for _, chunk := range chunks {
 			upper := strings.ToUpper(chunk)
 
 			switch {
 			case reCounter.MatchString(chunk):
 				m := reCounter.FindStringSubmatch(chunk)
-				ps.Counter, _ = strconv.Atoi(m[1])
-				ps.Expected, _ = strconv.Atoi(m[2])
+				ps.Counter = atoiOr(m[1], ps.Counter)
+				ps.Expected = atoiOr(m[2], ps.Expected)
 
 			case reRevision.MatchString(chunk):
 				m := reRevision.FindStringSubmatch(chunk)
-				ps.Revision, _ = strconv.Atoi(m[1])
+				ps.Revision = atoiOr(m[1], ps.Revision)
 
 			case rePatchVersion.MatchString(chunk):
 				// e.g., "PATCHv3" → treat as PATCH + v3
 				m := rePatchVersion.FindStringSubmatch(chunk)
 				vStr := strings.TrimPrefix(strings.ToLower(m[1]), "v")
-				ps.Revision, _ = strconv.Atoi(vStr)
+				ps.Revision = atoiOr(vStr, ps.Revision)
 				ps.Prefixes = append(ps.Prefixes, "PATCH")
 
 			case upper == "RFC":
@@ -152,14 +177,36 @@ func ParseSubject(subject string) *PatchSubject {
 
 			default:
 				ps.Prefixes = append(ps.Prefixes, chunk)
+				if ps.Tree == "" && !nonTreePrefixes[upper] {
+					ps.Tree = chunk
+				}
 			}
 		}
 	}
 
+	// Only patches and pull requests name a tree; in e.g. [ANNOUNCE]
+	// the word is just a tag
+	if !ps.IsPatch() && !ps.IsPull && !ps.IsRFC {
+		ps.Tree = ""
+	}
+
+	// "[PATCH]: subject"
+	s = strings.TrimPrefix(s, ":")
+
 	ps.Subject = strings.TrimSpace(s)
 	return ps
 }
 
+// atoiOr parses a decimal number from a subject prefix, returning def when
+// s does not fit in an int (strconv.Atoi would clamp it to MaxInt).
+func atoiOr(s string, def int) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
 // Rebuild reconstructs the subject line with properly formatted prefixes.
 // Counter is zero-padded to match the width of Expected (e.g., "02/12").
 func (ps *PatchSubject) Rebuild() string {
@@ -143,6 +143,70 @@ func TestParseSubject(t *testing.T) {
 			revision: 1,
 			subject:  "修复空指针问题",
 		},
+		{
+			name:     "tree designator",
+			input:    "[PATCH net-next v4 03/12] net: fix leak",
+			counter:  3,
+			expected: 12,
+			revision: 4,
+			subject:  "net: fix leak",
+		},
+		{
+			name:     "RFC RESEND",
+			input:    "[RFC PATCH RESEND] mm: try this",
+			revision: 1,
+			isRFC:    true,
+			isResend: true,
+			subject:  "mm: try this",
+		},
+		{
+			name:     "multiple bracket groups",
+			input:    "[RFC][PATCH v2 2/3][bpf] bpf: fix",
+			counter:  2,
+			expected: 3,
+			revision: 2,
+			isRFC:    true,
+			subject:  "bpf: fix",
+		},
+		{
+			name:     "comma separated",
+			input:    "[PATCH v3,5/7] fix commas",
+			counter:  5,
+			expected: 7,
+			revision: 3,
+			subject:  "fix commas",
+		},
+		{
+			name:     "stacked replies",
+			input:    "Re: SV: Re[2]: [PATCH 1/2] fix",
+			counter:  1,
+			expected: 2,
+			revision: 1,
+			isReply:  true,
+			subject:  "fix",
+		},
+		{
+			name:     "Chinese reply",
+			input:    "回复： [PATCH v2 1/2] 修复",
+			counter:  1,
+			expected: 2,
+			revision: 2,
+			isReply:  true,
+			subject:  "修复",
+		},
+		{
+			name:     "Swedish reply",
+			input:    "SV: [PATCH] fix",
+			revision: 1,
+			isReply:  true,
+			subject:  "fix",
+		},
+		{
+			name:     "colon after prefix",
+			input:    "[PATCH]: fix",
+			revision: 1,
+			subject:  "fix",
+		},
 	}
 
 	for _, tt := range tests {
@@ -177,6 +241,30 @@ func TestParseSubject(t *testing.T) {
 	}
 }
 
+func TestParseSubjectTree(t *testing.T) {
+	tests := []struct {
+		input string
+		tree  string
+	}{
+		{"[PATCH net-next v4 03/12] net: fix", "net-next"},
+		{"[net PATCH 1/2] net: fix", "net"},
+		{"[PATCH 6.1 012/123] stable backport", "6.1"},
+		{"[GIT PULL] drm-fixes for 6.9", ""},
+		{"[PATCH v2] no tree", ""},
+		{"[RFC PATCH bpf-next] idea", "bpf-next"},
+		{"[ANNOUNCE] not a patch", ""},
+		{"Re: [PATCH wireless] wifi: fix", "wireless"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := ParseSubject(tt.input).Tree; got != tt.tree {
+				t.Errorf("Tree = %q, want %q", got, tt.tree)
+			}
+		})
+	}
+}
+
 func TestParseSubjectRebuild(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -208,6 +296,11 @@ func TestParseSubjectRebuild(t *testing.T) {
 			input:    "[PATCH v2 1/3] something",
 			expected: "[PATCH v2 1/3] something",
 		},
+		{
+			name:     "tree kept",
+			input:    "[PATCH net-next v4 3/12] something",
+			expected: "[PATCH net-next v4 03/12] something",
+		},
 	}
 
 	for _, tt := range tests {
@@ -223,8 +316,8 @@ func TestParseSubjectRebuild(t *testing.T) {
 
 func TestIsCoverLetter(t *testing.T) {
 	tests := []struct {
-		input  string
-		isCL   bool
+		input string
+		isCL  bool
 	}{
 		{"[PATCH 0/3] Cover letter", true},
 		{"[PATCH v2 0/5] Cover letter", true},
@@ -264,3 +357,23 @@ func TestIsPatch(t *testing.T) {
 		})
 	}
 }
+
+func FuzzParseSubject(f *testing.F) {
+	f.Add("[PATCH v3 RFC 2/5] drivers: fix null pointer dereference")
+	f.Add("Re: [PATCH 1/3] some fix")
+	f.Add("[PATCHv2 0/12] cover")
+	f.Add("[foo[bar]] nested")
+	f.Add("[PATCH 99999999999999999999/1] overflow")
+	f.Add("Aw: Re:\t[PATCH]\n folded")
+	f.Add("回复： SV: [RFC PATCH net-next v4,03/12] tree")
+
+	f.Fuzz(func(t *testing.T, subject string) {
+		ps := ParseSubject(subject)
+		if ps.Counter < 0 || ps.Expected < 0 || ps.Revision < 0 {
+			t.Fatalf("ParseSubject(%q) produced negative numbers: %+v", subject, ps)
+		}
+		ps.Rebuild()
+		ps.IsCoverLetter()
+		ps.IsPatch()
+	})
+}
@@ -0,0 +1,162 @@
+package patchwork
+
+import (
+	"sort"
+	"strings"
+)
+
+// ThreadNode is a message of a Mailbox in its reply tree.
+type ThreadNode struct {
+	// Message is the message of the node.
+	Message *PatchMessage
+
+	// Parent is the node of the message this one replies to, nil for a
+	// root.
+	Parent *ThreadNode
+
+	// Replies contains the nodes of the messages replying to this one:
+	// patches by revision and number first, then the others by date.
+	Replies []*ThreadNode
+}
+
+// Thread arranges the messages of the mailbox into reply trees, cover
+// letter → patches → reviews. A message hangs under the message it
+// replies to: its In-Reply-To, or else the closest of its References in
+// the mailbox. The others, usually one cover letter or first patch per
+// revision, are the roots, by date.
+func (mb *Mailbox) Thread() []*ThreadNode {
+	nodes := make(map[string]*ThreadNode, len(mb.Messages))
+	all := make([]*ThreadNode, len(mb.Messages))
+	for i, m := range mb.Messages {
+		all[i] = &ThreadNode{Message: m}
+		if m.MessageID != "" {
+			nodes[m.MessageID] = all[i]
+		}
+	}
+
+	var roots []*ThreadNode
+	for _, n := range all {
+		if parent := findParent(n, nodes); parent != nil {
+			n.Parent = parent
+			parent.Replies = append(parent.Replies, n)
+		} else {
+			roots = append(roots, n)
+		}
+	}
+
+	sortNodes(roots)
+	for _, n := range all {
+		sortNodes(n.Replies)
+	}
+	return roots
+}
+
+// findParent returns the node n replies to, skipping candidates that
+// would make a cycle with replies already placed.
+func findParent(n *ThreadNode, nodes map[string]*ThreadNode) *ThreadNode {
+	m := n.Message
+	candidates := make([]string, 0, len(m.References)+1)
+	if m.InReplyTo != "" {
+		candidates = append(candidates, m.InReplyTo)
+	}
+	for i := len(m.References) - 1; i >= 0; i-- {
+		candidates = append(candidates, m.References[i])
+	}
+	for _, id := range candidates {
+		p := nodes[id]
+		if p == nil || isAncestor(n, p) {
+			continue
+		}
+		return p
+	}
+	return nil
+}
+
+// isAncestor reports whether a is n or one of its ancestors.
+func isAncestor(a, n *ThreadNode) bool {
+	for ; n != nil; n = n.Parent {
+		if n == a {
+			return true
+		}
+	}
+	return false
+}
+
+func sortNodes(nodes []*ThreadNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		if a.IsPatch() != b.IsPatch() {
+			return a.IsPatch()
+		}
+		if a.IsPatch() {
+			if a.Message.Parsed.Revision != b.Message.Parsed.Revision {
+				return a.Message.Parsed.Revision < b.Message.Parsed.Revision
+			}
+			return a.Message.Parsed.Counter < b.Message.Parsed.Counter
+		}
+		return a.Message.Date.Before(b.Message.Date)
+	})
+}
+
+// IsCoverLetter reports whether the node is a cover letter, not a reply
+// to one.
+func (n *ThreadNode) IsCoverLetter() bool {
+	return !n.Message.Parsed.IsReply && n.Message.Parsed.IsCoverLetter()
+}
+
+// IsPatch reports whether the node is a patch, classified as by
+// Mailbox.AddMessage.
+func (n *ThreadNode) IsPatch() bool {
+	m := n.Message
+	if m.Parsed.IsReply && !m.HasDiff {
+		return false
+	}
+	return !m.Parsed.IsCoverLetter() && (m.HasDiff || m.Parsed.IsPatch())
+}
+
+// Trailers returns the trailers the message of the node adds: those of a
+// patch or cover letter, or those a reply gives.
+func (n *ThreadNode) Trailers() []*Trailer {
+	if n.Message.Parsed.IsReply && !n.Message.HasDiff {
+		return n.Message.FollowupTrailers
+	}
+	if n.Message.BodyParts == nil {
+		return nil
+	}
+	return n.Message.BodyParts.Trailers
+}
+
+// Reviewed reports whether the patch of the node has a Reviewed-by
+// trailer: its own, one in a reply below it, or one given to the cover
+// letter it replies to, which applies to every patch.
+func (n *ThreadNode) Reviewed() bool {
+	if hasTrailer(n, "Reviewed-by", false) {
+		return true
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.IsCoverLetter() {
+			return hasTrailer(p, "Reviewed-by", true)
+		}
+	}
+	return false
+}
+
+// hasTrailer reports whether n or a reply below it has a trailer named
+// name. With skipPatches the patches below n and their replies are left
+// out.
+func hasTrailer(n *ThreadNode, name string, skipPatches bool) bool {
+	for _, t := range n.Trailers() {
+		if strings.EqualFold(t.Name, name) {
+			return true
+		}
+	}
+	for _, r := range n.Replies {
+		if skipPatches && r.IsPatch() {
+			continue
+		}
+		if hasTrailer(r, name, skipPatches) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package patchwork
+
+import (
+	"strings"
+	"testing"
+)
+
+// threadTestMbox is a v2 series of two patches: the cover letter got a
+// review and an Acked-by, patch 1 a Reviewed-by, patch 2 a question.
+var threadTestMbox = buildTestMbox(
+	`From: Author <author@example.com>
+Date: Mon, 01 Jan 2024 00:00:00 +0000
+Subject: [PATCH v2 0/2] Add widgets
+Message-Id: <cover@example.com>
+
+Two patches.`,
+	`From: Author <author@example.com>
+Date: Mon, 01 Jan 2024 00:00:02 +0000
+Subject: [PATCH v2 2/2] widget: document it
+Message-Id: <p2@example.com>
+In-Reply-To: <cover@example.com>
+References: <cover@example.com>
+
+Docs.
+
+Signed-off-by: Author <author@example.com>
+---
+diff --git a/README b/README
+--- a/README
++++ b/README
+@@ -1 +1,2 @@
++widgets`,
+	`From: Author <author@example.com>
+Date: Mon, 01 Jan 2024 00:00:01 +0000
+Subject: [PATCH v2 1/2] widget: add core
+Message-Id: <p1@example.com>
+In-Reply-To: <cover@example.com>
+References: <cover@example.com>
+
+Core.
+
+Signed-off-by: Author <author@example.com>
+---
+diff --git a/w.c b/w.c
+--- a/w.c
++++ b/w.c
+@@ -1 +1,2 @@
++int w;`,
+	`From: Reviewer <reviewer@example.com>
+Date: Mon, 01 Jan 2024 01:00:00 +0000
+Subject: Re: [PATCH v2 1/2] widget: add core
+Message-Id: <r1@example.com>
+In-Reply-To: <p1@example.com>
+References: <cover@example.com> <p1@example.com>
+
+Reviewed-by: Reviewer <reviewer@example.com>`,
+	`From: Reviewer <reviewer@example.com>
+Date: Mon, 01 Jan 2024 02:00:00 +0000
+Subject: Re: [PATCH v2 2/2] widget: document it
+Message-Id: <r2@example.com>
+References: <cover@example.com> <p2@example.com>
+
+Why?`,
+	`From: Maintainer <maint@example.com>
+Date: Mon, 01 Jan 2024 03:00:00 +0000
+Subject: Re: [PATCH v2 0/2] Add widgets
+Message-Id: <r0@example.com>
+In-Reply-To: <cover@example.com>
+
+Acked-by: Maintainer <maint@example.com>`,
+)
+
+func TestMailboxThread(t *testing.T) {
+	mb := NewMailbox()
+	if err := mb.ReadMbox(strings.NewReader(threadTestMbox)); err != nil {
+		t.Fatal(err)
+	}
+
+	roots := mb.Thread()
+	if len(roots) != 1 || roots[0].Message.MessageID != "cover@example.com" || !roots[0].IsCoverLetter() {
+		t.Fatalf("roots = %v, want the cover letter", roots)
+	}
+	cover := roots[0]
+	// Patches by number, then the reply to the cover letter
+	var ids []string
+	for _, r := range cover.Replies {
+		ids = append(ids, r.Message.MessageID)
+	}
+	if strings.Join(ids, " ") != "p1@example.com p2@example.com r0@example.com" {
+		t.Fatalf("replies to the cover letter = %v", ids)
+	}
+	p1, p2 := cover.Replies[0], cover.Replies[1]
+	// r2 has no In-Reply-To: its last reference is the parent
+	if len(p1.Replies) != 1 || len(p2.Replies) != 1 || p2.Replies[0].Message.MessageID != "r2@example.com" {
+		t.Errorf("replies to the patches = %v, %v", p1.Replies, p2.Replies)
+	}
+
+	if !p1.IsPatch() || cover.IsPatch() || p1.Replies[0].IsPatch() {
+		t.Error("nodes misclassified")
+	}
+	if got := p1.Replies[0].Trailers(); len(got) != 1 || got[0].Name != "Reviewed-by" {
+		t.Errorf("trailers of the review = %v", got)
+	}
+	if !p1.Reviewed() || p2.Reviewed() {
+		t.Errorf("Reviewed() = %v, %v; want true, false", p1.Reviewed(), p2.Reviewed())
+	}
+}
+
+func TestMailboxThreadCoverReview(t *testing.T) {
+	// A Reviewed-by given to the cover letter covers every patch
+	mb := NewMailbox()
+	err := mb.ReadMbox(strings.NewReader(threadTestMbox + buildTestMbox(`From: Maintainer <maint@example.com>
+Date: Mon, 01 Jan 2024 04:00:00 +0000
+Subject: Re: [PATCH v2 0/2] Add widgets
+Message-Id: <r3@example.com>
+In-Reply-To: <r0@example.com>
+
+Reviewed-by: Maintainer <maint@example.com>`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range mb.Thread()[0].Replies[:2] {
+		if !p.Reviewed() {
+			t.Errorf("%s not reviewed", p.Message.MessageID)
+		}
+	}
+}
+
+func TestMailboxThreadCycle(t *testing.T) {
+	mb := NewMailbox()
+	err := mb.ReadMbox(strings.NewReader(buildTestMbox(`From: A <a@example.com>
+Subject: Re: one
+Message-Id: <a@example.com>
+In-Reply-To: <b@example.com>
+
+a`, `From: B <b@example.com>
+Subject: Re: two
+Message-Id: <b@example.com>
+In-Reply-To: <a@example.com>
+
+b`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := mb.Thread()
+	if len(roots) != 1 || len(roots[0].Replies) != 1 {
+		t.Fatalf("roots = %v, want one message with a reply", roots)
+	}
+}
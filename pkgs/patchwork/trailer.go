@@ -4,6 +4,8 @@ import (
 	"net/mail"
 	"regexp"
 	"strings"
+
+	"github.com/emx-mail/cli/pkgs/email"
 )
 
 // TrailerType classifies what kind of value a trailer has.
@@ -183,14 +185,11 @@ func ParseMessageBody(body string) *MessageBodyParts {
 	body = strings.ReplaceAll(body, "\r\n", "\n")
 
 	// Split off signature (-- \n)
-	if idx := strings.Index(body, "\n-- \n"); idx >= 0 {
-		parts.Signature = body[idx+5:]
-		body = body[:idx]
-	}
+	body, parts.Signature = email.SplitSignature(body)
 
 	// Split off below-the-cut content (---\n)
-	if idx := findCutLine(body); idx >= 0 {
-		parts.Below = body[idx+4:]
+	if idx, end := findCutLine(body); idx >= 0 {
+		parts.Below = body[end:]
 		body = body[:idx]
 	}
 
@@ -226,19 +225,24 @@ func ParseMessageBody(body string) *MessageBodyParts {
 	return parts
 }
 
-// findCutLine finds the position of a "---" line that marks the cut point.
-// Returns -1 if not found. The cut line must be on its own line.
-func findCutLine(body string) int {
+// findCutLine finds the position of a "---" line that marks the cut point,
+// returning the offset where the line starts and where the content after it
+// begins. Returns -1, -1 if not found. The cut line must be on its own line.
+func findCutLine(body string) (start, end int) {
 	lines := strings.Split(body, "\n")
 	pos := 0
 	for _, line := range lines {
+		next := pos + len(line) + 1
 		trimmed := strings.TrimSpace(line)
 		if trimmed == "---" {
-			return pos
+			if next > len(body) {
+				next = len(body)
+			}
+			return pos, next
 		}
-		pos += len(line) + 1
+		pos = next
 	}
-	return -1
+	return -1, -1
 }
 
 // splitParagraphs splits text into paragraphs separated by blank lines.
@@ -1,6 +1,7 @@
 package patchwork
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -330,3 +331,38 @@ func TestParseIntRange(t *testing.T) {
 		})
 	}
 }
+
+func FuzzParseMessageBody(f *testing.F) {
+	f.Add("Fix the bug.\n\nSigned-off-by: Dev <dev@example.com>\n---\n file.go | 1 +\n-- \nsig\n")
+	f.Add("From: A <a@b.c>\nSubject: x\n\nbody\n\nReviewed-by: R <r@x.org> # v2\n[extinfo]\n")
+	f.Add("Link: https://example.com/x\r\nCc: <broken@\r\n")
+	f.Add("\n-- \n")
+	f.Add("---\n")
+
+	f.Fuzz(func(t *testing.T, body string) {
+		parts := ParseMessageBody(body)
+		for _, tr := range parts.Trailers {
+			if s := tr.String(); !strings.HasPrefix(s, tr.Name+": ") {
+				t.Errorf("String() = %q, want the %q trailer", s, tr.Name)
+			}
+		}
+		for _, tr := range ParseTrailers(body) {
+			if !tr.Equal(tr) {
+				t.Errorf("trailer %q not equal to itself", tr.String())
+			}
+		}
+	})
+}
+
+func TestParseMessageBodyCutAtEOF(t *testing.T) {
+	// A trailing "---" without a newline used to slice past the end.
+	parts := ParseMessageBody("Fix it\n---")
+	if parts.Body != "Fix it" || parts.Below != "" {
+		t.Errorf("got Body=%q Below=%q", parts.Body, parts.Below)
+	}
+
+	parts = ParseMessageBody("Fix it\n  ---  \n file | 1 +\n")
+	if parts.Below != " file | 1 +\n" {
+		t.Errorf("Below = %q", parts.Below)
+	}
+}
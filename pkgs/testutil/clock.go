@@ -0,0 +1,71 @@
+package testutil
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// FakeClock is a clock for the Clock fields of email.SMTPConfig and
+// email.IMAPConfig. Time stands still until Advance is called, and waits
+// return at once, moving the clock forward by the wait, so retry and
+// reconnect delays take no real time and can be checked with Waits:
+//
+//	clock := testutil.NewFakeClock(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC))
+//	client := email.NewSMTPClient(email.SMTPConfig{
+//		Host: host, Port: port,
+//		Clock: clock, Rand: testutil.NewRand(1),
+//	})
+type FakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After records the wait d, advances the clock by it and returns a channel
+// that already holds the new time.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waits = append(c.waits, d)
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Waits returns the durations passed to After, in order.
+func (c *FakeClock) Waits() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.waits...)
+}
+
+// NewRand returns a reader of pseudo-random bytes determined by seed, for
+// the Rand field of email.SMTPConfig: the same seed yields the same
+// Message-IDs.
+func NewRand(seed int64) io.Reader {
+	return rand.New(rand.NewSource(seed))
+}
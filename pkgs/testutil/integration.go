@@ -0,0 +1,35 @@
+package testutil
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// IntegrationAddr returns the host and port of a real mail server for
+// integration tests, read from EMX_IT_<PROTO>_ADDR (e.g. EMX_IT_IMAP_ADDR=
+// localhost:14143). The test is skipped when the variable is unset, so
+// integration tests are no-ops unless the docker harness is running.
+func IntegrationAddr(t testing.TB, proto string) (string, int) {
+	t.Helper()
+	key := "EMX_IT_" + strings.ToUpper(proto) + "_ADDR"
+	addr := os.Getenv(key)
+	if addr == "" {
+		t.Skipf("%s not set; start pkgs/testutil/docker and export it to run", key)
+	}
+	return SplitHostPort(t, addr)
+}
+
+// IntegrationCredentials returns the mailbox credentials for integration
+// tests from EMX_IT_USER / EMX_IT_PASS, defaulting to Username/Password,
+// which the docker harness provisions.
+func IntegrationCredentials() (user, pass string) {
+	user, pass = os.Getenv("EMX_IT_USER"), os.Getenv("EMX_IT_PASS")
+	if user == "" {
+		user = Username
+	}
+	if pass == "" {
+		pass = Password
+	}
+	return user, pass
+}
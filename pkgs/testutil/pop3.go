@@ -0,0 +1,267 @@
+package testutil
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// POP3Message is a message served by the POP3 test server.
+type POP3Message struct {
+	ID   int
+	UIDL string // Defaults to "msg-<ID>"
+	Data string // RFC 5322 raw, CRLF line endings
+}
+
+// POP3Options configures NewPOP3Server.
+type POP3Options struct {
+	Messages    []POP3Message
+	UseTLS      bool // implicit TLS (POP3S)
+	SupportSTLS bool // advertise and handle STLS
+	RejectAuth  bool // fail every PASS command
+}
+
+// NewPOP3Server starts a minimal RFC 1939 POP3 server (CAPA, STLS, USER/PASS,
+// STAT, LIST, UIDL, RETR, TOP, DELE) on a random localhost port and returns
+// its address. Any username and password are accepted unless RejectAuth is set.
+// Deletions are per connection. The server stops when the test finishes.
+func NewPOP3Server(t testing.TB, opts POP3Options) string {
+	t.Helper()
+
+	var tlsConfig *tls.Config
+	if opts.UseTLS || opts.SupportSTLS {
+		tlsConfig = NewTLSConfig(t)
+	}
+
+	var ln net.Listener
+	var err error
+	if opts.UseTLS {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			raw, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handlePOP3Conn(raw, opts, tlsConfig)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func handlePOP3Conn(conn net.Conn, opts POP3Options, tlsCfg *tls.Config) {
+	defer conn.Close()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	writeLine := func(s string) {
+		fmt.Fprintf(rw, "%s\r\n", s)
+		rw.Flush()
+	}
+
+	writeLine("+OK POP3 server ready")
+
+	authed := false
+	deleted := map[int]bool{}
+
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		cmd := strings.ToUpper(fields[0])
+
+		switch cmd {
+		case "CAPA":
+			writeLine("+OK")
+			if opts.SupportSTLS {
+				writeLine("STLS")
+			}
+			writeLine("UIDL")
+			writeLine("TOP")
+			writeLine(".")
+
+		case "STLS":
+			if !opts.SupportSTLS || tlsCfg == nil {
+				writeLine("-ERR STLS not supported")
+				continue
+			}
+			writeLine("+OK Begin TLS")
+			rw.Flush()
+			tlsConn := tls.Server(conn, tlsCfg)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			rw = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+		case "USER":
+			writeLine("+OK")
+
+		case "PASS":
+			if opts.RejectAuth {
+				writeLine("-ERR auth failed")
+				continue
+			}
+			authed = true
+			writeLine("+OK Logged in")
+
+		case "NOOP":
+			if !authed {
+				writeLine("-ERR not authenticated")
+				continue
+			}
+			writeLine("+OK")
+
+		case "STAT":
+			if !authed {
+				writeLine("-ERR not authenticated")
+				continue
+			}
+			total := 0
+			totalSize := 0
+			for _, m := range opts.Messages {
+				if !deleted[m.ID] {
+					total++
+					totalSize += len(m.Data)
+				}
+			}
+			writeLine(fmt.Sprintf("+OK %d %d", total, totalSize))
+
+		case "LIST":
+			if !authed {
+				writeLine("-ERR not authenticated")
+				continue
+			}
+			if len(fields) > 1 {
+				// single-message LIST
+				idx := 0
+				fmt.Sscanf(fields[1], "%d", &idx)
+				for _, m := range opts.Messages {
+					if m.ID == idx && !deleted[idx] {
+						writeLine(fmt.Sprintf("+OK %d %d", m.ID, len(m.Data)))
+						goto listDone
+					}
+				}
+				writeLine("-ERR no such message")
+			listDone:
+			} else {
+				writeLine("+OK")
+				for _, m := range opts.Messages {
+					if !deleted[m.ID] {
+						writeLine(fmt.Sprintf("%d %d", m.ID, len(m.Data)))
+					}
+				}
+				writeLine(".")
+			}
+
+		case "UIDL":
+			if !authed {
+				writeLine("-ERR not authenticated")
+				continue
+			}
+			writeLine("+OK")
+			for _, m := range opts.Messages {
+				if !deleted[m.ID] {
+					uid := m.UIDL
+					if uid == "" {
+						uid = fmt.Sprintf("msg-%d", m.ID)
+					}
+					writeLine(fmt.Sprintf("%d %s", m.ID, uid))
+				}
+			}
+			writeLine(".")
+
+		case "RETR":
+			if !authed {
+				writeLine("-ERR not authenticated")
+				continue
+			}
+			idx := 0
+			if len(fields) > 1 {
+				fmt.Sscanf(fields[1], "%d", &idx)
+			}
+			if idx < 1 || idx > len(opts.Messages) || deleted[idx] {
+				writeLine("-ERR no such message")
+				continue
+			}
+			writeLine("+OK")
+			// Write message data line by line
+			for _, dataLine := range strings.Split(opts.Messages[idx-1].Data, "\r\n") {
+				// Byte-stuff lines starting with "."
+				if strings.HasPrefix(dataLine, ".") {
+					writeLine("." + dataLine)
+				} else {
+					writeLine(dataLine)
+				}
+			}
+			writeLine(".")
+
+		case "TOP":
+			if !authed {
+				writeLine("-ERR not authenticated")
+				continue
+			}
+			idx, numLines := 0, 0
+			if len(fields) > 1 {
+				fmt.Sscanf(fields[1], "%d", &idx)
+			}
+			if len(fields) > 2 {
+				fmt.Sscanf(fields[2], "%d", &numLines)
+			}
+			if idx < 1 || idx > len(opts.Messages) {
+				writeLine("-ERR no such message")
+				continue
+			}
+			writeLine("+OK")
+			parts := strings.SplitN(opts.Messages[idx-1].Data, "\r\n\r\n", 2)
+			// Headers
+			for _, hl := range strings.Split(parts[0], "\r\n") {
+				writeLine(hl)
+			}
+			writeLine("") // empty line between headers and body
+			if len(parts) > 1 && numLines > 0 {
+				bodyLines := strings.Split(parts[1], "\r\n")
+				for i := 0; i < numLines && i < len(bodyLines); i++ {
+					writeLine(bodyLines[i])
+				}
+			}
+			writeLine(".")
+
+		case "DELE":
+			if !authed {
+				writeLine("-ERR not authenticated")
+				continue
+			}
+			idx := 0
+			if len(fields) > 1 {
+				fmt.Sscanf(fields[1], "%d", &idx)
+			}
+			deleted[idx] = true
+			writeLine("+OK")
+
+		case "QUIT":
+			writeLine("+OK Bye")
+			return
+
+		default:
+			writeLine("-ERR unknown command")
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package testutil
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-sasl"
+	gosmtp "github.com/emersion/go-smtp"
+)
+
+// SMTPMessage is a message received by the SMTP test server.
+type SMTPMessage struct {
+	Identity string // SASL authorization identity of the session, if any
+	From     string
+	To       []string
+	Data     []byte
+}
+
+// SMTPBackend records the messages received by the SMTP test server.
+type SMTPBackend struct {
+	mu       sync.Mutex
+	messages []*SMTPMessage
+	conns    int
+}
+
+// NewSession implements gosmtp.Backend.
+func (be *SMTPBackend) NewSession(_ *gosmtp.Conn) (gosmtp.Session, error) {
+	be.mu.Lock()
+	be.conns++
+	be.mu.Unlock()
+	return &smtpSession{backend: be}, nil
+}
+
+// Conns returns the number of connections the server accepted so far.
+func (be *SMTPBackend) Conns() int {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	return be.conns
+}
+
+// Messages returns a snapshot of the messages received so far.
+func (be *SMTPBackend) Messages() []*SMTPMessage {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+	return append([]*SMTPMessage(nil), be.messages...)
+}
+
+type smtpSession struct {
+	backend  *SMTPBackend
+	msg      *SMTPMessage
+	identity string
+}
+
+func (s *smtpSession) AuthMechanisms() []string { return []string{"PLAIN"} }
+
+func (s *smtpSession) Auth(mech string) (sasl.Server, error) {
+	return sasl.NewPlainServer(func(identity, username, password string) error {
+		if username != Username || password != Password {
+			return errors.New("invalid credentials")
+		}
+		s.identity = identity
+		return nil
+	}), nil
+}
+
+func (s *smtpSession) Mail(from string, _ *gosmtp.MailOptions) error {
+	s.msg = &SMTPMessage{Identity: s.identity, From: from}
+	return nil
+}
+
+func (s *smtpSession) Rcpt(to string, _ *gosmtp.RcptOptions) error {
+	s.msg.To = append(s.msg.To, to)
+	return nil
+}
+
+func (s *smtpSession) Data(r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.msg.Data = b
+	s.backend.mu.Lock()
+	s.backend.messages = append(s.backend.messages, s.msg)
+	s.backend.mu.Unlock()
+	return nil
+}
+
+func (s *smtpSession) Reset()        { s.msg = nil }
+func (s *smtpSession) Logout() error { return nil }
+
+// Ensure interface conformance
+var _ gosmtp.AuthSession = (*smtpSession)(nil)
+
+// NewSMTPServer starts an SMTP server accepting PLAIN auth with
+// Username/Password over plaintext. Returns the backend (to inspect received
+// mail) and the listen address. The server is closed when the test finishes.
+func NewSMTPServer(t testing.TB) (*SMTPBackend, string) {
+	t.Helper()
+
+	be := &SMTPBackend{}
+	srv := gosmtp.NewServer(be)
+	srv.Domain = "localhost"
+	srv.AllowInsecureAuth = true
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	return be, ln.Addr().String()
+}